@@ -45,6 +45,13 @@ func (p *S3CredProvider) IsExpired() bool {
 }
 
 func NewAwsCtx(id, secret, region string, hctx *http.Client) *S3ctx {
+	return NewAwsCtxWithEndpoint(id, secret, region, "", hctx)
+}
+
+// NewAwsCtxWithEndpoint creates a context for an S3-compatible object
+// store reachable at endpoint (e.g., a MinIO or Ceph RGW URL).
+// An empty endpoint means the AWS endpoint for the region.
+func NewAwsCtxWithEndpoint(id, secret, region, endpoint string, hctx *http.Client) *S3ctx {
 	ctx := S3ctx{
 		p:   S3CredProvider{id: id, secret: secret},
 		ctx: aws.BackgroundContext(),
@@ -57,6 +64,13 @@ func NewAwsCtx(id, secret, region string, hctx *http.Client) *S3ctx {
 	// regions
 	cfg.WithRegion(region)
 
+	// S3-compatible stores are typically not reachable using
+	// virtual-hosted style bucket names hence use path style
+	if endpoint != "" {
+		cfg.WithEndpoint(endpoint)
+		cfg.WithS3ForcePathStyle(true)
+	}
+
 	if hctx != nil {
 		cfg.WithHTTPClient(hctx)
 	}
@@ -62,10 +62,12 @@ type DronaEndPoint interface {
 	WithSrcIPAndProxySelection(localAddr net.IP, proxy *url.URL) error
 	WithBindIntf(intf string) error
 	WithLogging(onoff bool) error
+	WithRateLimiter(limiter *RateLimiter) error
 }
 
 // use the specific ip as source address for this connection
-func httpClientSrcIP(localAddr net.IP, proxy *url.URL) *http.Client {
+func httpClientSrcIP(localAddr net.IP, proxy *url.URL,
+	limiter *RateLimiter) *http.Client {
 	// You also need to do this to make it work and not give you a
 	// "mismatched local address type ip"
 	// This will make the ResolveIPAddr a TCPAddr without needing to
@@ -78,16 +80,24 @@ func httpClientSrcIP(localAddr net.IP, proxy *url.URL) *http.Client {
 		return d.Dial(network, address)
 	}
 	r := net.Resolver{Dial: resolverDial, PreferGo: true, StrictErrors: false}
+	dialer := &net.Dialer{
+		Resolver:  &r,
+		LocalAddr: &localTCPAddr,
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		DualStack: true,
+	}
+	dialContext := func(ctx context.Context, network, address string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		return limiter.wrapConn(conn), nil
+	}
 	webclient := &http.Client{
 		Transport: &http.Transport{
-			Proxy: http.ProxyURL(proxy),
-			DialContext: (&net.Dialer{
-				Resolver:  &r,
-				LocalAddr: &localTCPAddr,
-				Timeout:   30 * time.Second,
-				KeepAlive: 30 * time.Second,
-				DualStack: true,
-			}).DialContext,
+			Proxy:                 http.ProxyURL(proxy),
+			DialContext:           dialContext,
 			MaxIdleConns:          100,
 			IdleConnTimeout:       90 * time.Second,
 			TLSHandshakeTimeout:   10 * time.Second,
@@ -39,7 +39,8 @@ func (ep *AwsTransportMethod) Action(req *DronaRequest) error {
 		req.contentLength = contentLength
 		req.remoteFileMD5 = remoteFileMD5
 	case SysOpPutPart:
-		etagID, uploadID, err := ep.processMultipartUpload(req)
+		var etagID, uploadID string
+		etagID, uploadID, err = ep.processMultipartUpload(req)
 		if err == nil {
 			req.UploadID = uploadID
 			req.EtagID = etagID
@@ -75,7 +76,7 @@ func (ep *AwsTransportMethod) Close() error {
 
 // WithSrcIPSelection use the specific ip as source address for this connection
 func (ep *AwsTransportMethod) WithSrcIPSelection(localAddr net.IP) error {
-	ep.hClient = httpClientSrcIP(localAddr, nil)
+	ep.hClient = httpClientSrcIP(localAddr, nil, ep.limiter)
 	return nil
 }
 
@@ -83,7 +84,7 @@ func (ep *AwsTransportMethod) WithSrcIPSelection(localAddr net.IP) error {
 // connection and connect via the provided proxy URL
 func (ep *AwsTransportMethod) WithSrcIPAndProxySelection(localAddr net.IP,
 	proxy *url.URL) error {
-	ep.hClient = httpClientSrcIP(localAddr, proxy)
+	ep.hClient = httpClientSrcIP(localAddr, proxy, ep.limiter)
 	return nil
 }
 
@@ -97,7 +98,7 @@ func (ep *AwsTransportMethod) WithSrcIPAndHTTPSCerts(localAddr net.IP, certs [][
 func (ep *AwsTransportMethod) WithBindIntf(intf string) error {
 	localAddr := getSrcIpFromInterface(intf)
 	if localAddr != nil {
-		ep.hClient = httpClientSrcIP(localAddr, nil)
+		ep.hClient = httpClientSrcIP(localAddr, nil, ep.limiter)
 		return nil
 	}
 	return fmt.Errorf("failed to get the address for intf")
@@ -107,6 +108,21 @@ func (ep *AwsTransportMethod) WithLogging(onoff bool) error {
 	return nil
 }
 
+// WithRateLimiter limits the bandwidth used by this endpoint. The limiter
+// is applied to the connections opened after this call hence it should
+// be called before the WithSrcIP functions.
+func (ep *AwsTransportMethod) WithRateLimiter(limiter *RateLimiter) error {
+	ep.limiter = limiter
+	return nil
+}
+
+// WithEndpoint directs the requests to an S3-compatible object store
+// instead of the AWS endpoint for the region
+func (ep *AwsTransportMethod) WithEndpoint(endpoint string) error {
+	ep.endpoint = endpoint
+	return nil
+}
+
 // File upload to AWS S3 Datastore
 func (ep *AwsTransportMethod) processS3Upload(req *DronaRequest) (error, int) {
 	fInfo, err := os.Stat(req.objloc)
@@ -135,7 +151,7 @@ func (ep *AwsTransportMethod) processS3Upload(req *DronaRequest) (error, int) {
 
 	// FiXME: strings.TrimSuffix needs to go away once final soultion is done.
 	// upload, always the compression file.
-	sc := zedAWS.NewAwsCtx(ep.token, ep.apiKey, ep.region, ep.hClient)
+	sc := zedAWS.NewAwsCtxWithEndpoint(ep.token, ep.apiKey, ep.region, ep.endpoint, ep.hClient)
 	if sc == nil {
 		return fmt.Errorf("unable to create S3 context"), 0
 	}
@@ -156,7 +172,7 @@ func (ep *AwsTransportMethod) processS3Download(req *DronaRequest) (error, int)
 	var csize int
 	pwd := strings.TrimSuffix(ep.apiKey, "\n")
 	if req.ackback {
-		s := zedAWS.NewAwsCtx(ep.token, pwd, ep.region, ep.hClient)
+		s := zedAWS.NewAwsCtxWithEndpoint(ep.token, pwd, ep.region, ep.endpoint, ep.hClient)
 		if req.cancelContext != nil {
 			s = s.WithContext(req.cancelContext)
 		}
@@ -188,7 +204,7 @@ func (ep *AwsTransportMethod) processS3Download(req *DronaRequest) (error, int)
 		}(req, prgChan)
 	}
 
-	sc := zedAWS.NewAwsCtx(ep.token, pwd, ep.region, ep.hClient)
+	sc := zedAWS.NewAwsCtxWithEndpoint(ep.token, pwd, ep.region, ep.endpoint, ep.hClient)
 	if sc == nil {
 		return fmt.Errorf("unable to create S3 context"), 0
 	}
@@ -211,7 +227,7 @@ func (ep *AwsTransportMethod) processS3Download(req *DronaRequest) (error, int)
 
 func (ep *AwsTransportMethod) processS3DownloadByChunks(req *DronaRequest) error {
 	pwd := strings.TrimSuffix(ep.apiKey, "\n")
-	sc := zedAWS.NewAwsCtx(ep.token, pwd, ep.region, ep.hClient)
+	sc := zedAWS.NewAwsCtxWithEndpoint(ep.token, pwd, ep.region, ep.endpoint, ep.hClient)
 	if sc == nil {
 		return fmt.Errorf("unable to create S3 context")
 	}
@@ -235,7 +251,7 @@ func (ep *AwsTransportMethod) processS3DownloadByChunks(req *DronaRequest) error
 // File delete from AWS S3 Datastore
 func (ep *AwsTransportMethod) processS3Delete(req *DronaRequest) error {
 	var err error
-	s3ctx := zedAWS.NewAwsCtx(ep.token, ep.apiKey, ep.region, ep.hClient)
+	s3ctx := zedAWS.NewAwsCtxWithEndpoint(ep.token, ep.apiKey, ep.region, ep.endpoint, ep.hClient)
 	if s3ctx != nil {
 		if req.cancelContext != nil {
 			s3ctx = s3ctx.WithContext(req.cancelContext)
@@ -277,7 +293,7 @@ func (ep *AwsTransportMethod) processS3List(req *DronaRequest) ([]string, error,
 			}
 		}(req, prgChan)
 	}
-	sc := zedAWS.NewAwsCtx(ep.token, pwd, ep.region, ep.hClient)
+	sc := zedAWS.NewAwsCtxWithEndpoint(ep.token, pwd, ep.region, ep.endpoint, ep.hClient)
 	if sc == nil {
 		return s, fmt.Errorf("unable to create S3 context"), 0
 	}
@@ -298,7 +314,7 @@ func (ep *AwsTransportMethod) processS3List(req *DronaRequest) ([]string, error,
 //Verify Uploaded Object Size and MD5 sum
 func (ep *AwsTransportMethod) processS3ObjectMetaData(req *DronaRequest) (int64, string, error) {
 	pwd := strings.TrimSuffix(ep.apiKey, "\n")
-	sc := zedAWS.NewAwsCtx(ep.token, pwd, ep.region, ep.hClient)
+	sc := zedAWS.NewAwsCtxWithEndpoint(ep.token, pwd, ep.region, ep.endpoint, ep.hClient)
 	if sc == nil {
 		return 0, "", fmt.Errorf("unable to create S3 context")
 	}
@@ -339,7 +355,7 @@ func (ep *AwsTransportMethod) getContext() *DronaCtx {
 }
 
 func (ep *AwsTransportMethod) processMultipartUpload(req *DronaRequest) (string, string, error) {
-	s3ctx := zedAWS.NewAwsCtx(ep.token, ep.apiKey, ep.region, ep.hClient)
+	s3ctx := zedAWS.NewAwsCtxWithEndpoint(ep.token, ep.apiKey, ep.region, ep.endpoint, ep.hClient)
 	if req.cancelContext != nil {
 		s3ctx = s3ctx.WithContext(req.cancelContext)
 	}
@@ -348,7 +364,7 @@ func (ep *AwsTransportMethod) processMultipartUpload(req *DronaRequest) (string,
 }
 
 func (ep *AwsTransportMethod) completeMultipartUpload(req *DronaRequest) error {
-	s3ctx := zedAWS.NewAwsCtx(ep.token, ep.apiKey, ep.region, ep.hClient)
+	s3ctx := zedAWS.NewAwsCtxWithEndpoint(ep.token, ep.apiKey, ep.region, ep.endpoint, ep.hClient)
 	if req.cancelContext != nil {
 		s3ctx = s3ctx.WithContext(req.cancelContext)
 	}
@@ -356,7 +372,7 @@ func (ep *AwsTransportMethod) completeMultipartUpload(req *DronaRequest) error {
 }
 
 func (ep *AwsTransportMethod) generateSignedURL(req *DronaRequest) (string, error) {
-	s3ctx := zedAWS.NewAwsCtx(ep.token, ep.apiKey, ep.region, ep.hClient)
+	s3ctx := zedAWS.NewAwsCtxWithEndpoint(ep.token, ep.apiKey, ep.region, ep.endpoint, ep.hClient)
 	if req.cancelContext != nil {
 		s3ctx = s3ctx.WithContext(req.cancelContext)
 	}
@@ -367,6 +383,8 @@ type AwsTransportMethod struct {
 	transport SyncTransportType
 	region    string
 	bucket    string
+	// optional, endpoint of an S3-compatible object store
+	endpoint string
 
 	//Auth
 	token  string
@@ -375,4 +393,5 @@ type AwsTransportMethod struct {
 	failPostTime time.Time
 	ctx          *DronaCtx
 	hClient      *http.Client
+	limiter      *RateLimiter
 }
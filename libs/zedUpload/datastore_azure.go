@@ -70,7 +70,7 @@ func (ep *AzureTransportMethod) Close() error {
 
 // WithSrcIPSelection use the specific ip as source address for this connection
 func (ep *AzureTransportMethod) WithSrcIPSelection(localAddr net.IP) error {
-	ep.hClient = httpClientSrcIP(localAddr, nil)
+	ep.hClient = httpClientSrcIP(localAddr, nil, ep.limiter)
 	return nil
 }
 
@@ -78,7 +78,7 @@ func (ep *AzureTransportMethod) WithSrcIPSelection(localAddr net.IP) error {
 // connection and connect via the provided proxy URL
 func (ep *AzureTransportMethod) WithSrcIPAndProxySelection(localAddr net.IP,
 	proxy *url.URL) error {
-	ep.hClient = httpClientSrcIP(localAddr, proxy)
+	ep.hClient = httpClientSrcIP(localAddr, proxy, ep.limiter)
 	return nil
 }
 
@@ -96,6 +96,14 @@ func (ep *AzureTransportMethod) WithLogging(onoff bool) error {
 	return nil
 }
 
+// WithRateLimiter limits the bandwidth used by this endpoint. The limiter
+// is applied to the connections opened after this call hence it should
+// be called before the WithSrcIP functions.
+func (ep *AzureTransportMethod) WithRateLimiter(limiter *RateLimiter) error {
+	ep.limiter = limiter
+	return nil
+}
+
 // File upload to Azure Blob Datastore
 func (ep *AzureTransportMethod) processAzureUpload(req *DronaRequest) (string, error) {
 	file := req.name
@@ -223,4 +231,5 @@ type AzureTransportMethod struct {
 	failPostTime time.Time
 	ctx          *DronaCtx
 	hClient      *http.Client
+	limiter      *RateLimiter
 }
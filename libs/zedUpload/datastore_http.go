@@ -4,6 +4,7 @@
 package zedUpload
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
@@ -25,6 +26,7 @@ type HttpTransportMethod struct {
 	failPostTime time.Time
 	ctx          *DronaCtx
 	hClient      *http.Client
+	limiter      *RateLimiter
 }
 
 func (ep *HttpTransportMethod) Action(req *DronaRequest) error {
@@ -69,7 +71,7 @@ func (ep *HttpTransportMethod) Close() error {
 
 // WithSrcIPSelection use the specific ip as source address for this connection
 func (ep *HttpTransportMethod) WithSrcIPSelection(localAddr net.IP) error {
-	ep.hClient = httpClientSrcIP(localAddr, nil)
+	ep.hClient = httpClientSrcIP(localAddr, nil, ep.limiter)
 	return nil
 }
 
@@ -77,13 +79,13 @@ func (ep *HttpTransportMethod) WithSrcIPSelection(localAddr net.IP) error {
 // connection and connect via the provided proxy URL
 func (ep *HttpTransportMethod) WithSrcIPAndProxySelection(localAddr net.IP,
 	proxy *url.URL) error {
-	ep.hClient = httpClientSrcIP(localAddr, proxy)
+	ep.hClient = httpClientSrcIP(localAddr, proxy, ep.limiter)
 	return nil
 }
 
 // WithSrcIPAndHTTPSCerts append certs for https datastore
 func (ep *HttpTransportMethod) WithSrcIPAndHTTPSCerts(localAddr net.IP, certs [][]byte) error {
-	ep.hClient = httpClientSrcIP(localAddr, nil)
+	ep.hClient = httpClientSrcIP(localAddr, nil, ep.limiter)
 	err := ep.httpClientAddCerts(certs)
 	return err
 }
@@ -110,6 +112,14 @@ func (ep *HttpTransportMethod) WithLogging(onoff bool) error {
 	return nil
 }
 
+// WithRateLimiter limits the bandwidth used by this endpoint. The limiter
+// is applied to the connections opened after this call hence it should
+// be called before the WithSrcIP functions.
+func (ep *HttpTransportMethod) WithRateLimiter(limiter *RateLimiter) error {
+	ep.limiter = limiter
+	return nil
+}
+
 // File upload to HTTP Datastore
 func (ep *HttpTransportMethod) processHttpUpload(req *DronaRequest) (error, int) {
 	postUrl := ep.hurl + "/" + ep.path
@@ -161,7 +171,12 @@ func (ep *HttpTransportMethod) processHttpDownload(req *DronaRequest) (error, in
 			}
 		}(req, prgChan)
 	}
-	resp := zedHttp.ExecCmd("get", file, "", req.objloc, req.sizelimit, prgChan, ep.hClient)
+	ctx := req.cancelContext
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	resp := zedHttp.ExecCmdWithContext(ctx, "get", file, "", req.objloc,
+		req.sizelimit, prgChan, ep.hClient, req.resume)
 	if resp.Error != nil {
 		return resp.Error, resp.BodyLength
 	}
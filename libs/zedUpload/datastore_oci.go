@@ -29,6 +29,7 @@ type OCITransportMethod struct {
 	failPostTime time.Time
 	ctx          *DronaCtx
 	hClient      *http.Client
+	limiter      *RateLimiter
 }
 
 // Action perform an action using this method, one of
@@ -80,7 +81,7 @@ func (ep *OCITransportMethod) Close() error {
 
 // WithSrcIPSelection use the specific ip as source address for this connection
 func (ep *OCITransportMethod) WithSrcIPSelection(localAddr net.IP) error {
-	ep.hClient = httpClientSrcIP(localAddr, nil)
+	ep.hClient = httpClientSrcIP(localAddr, nil, ep.limiter)
 	return nil
 }
 
@@ -88,7 +89,7 @@ func (ep *OCITransportMethod) WithSrcIPSelection(localAddr net.IP) error {
 // connection and transit through the specific proxy URL
 func (ep *OCITransportMethod) WithSrcIPAndProxySelection(localAddr net.IP,
 	proxy *url.URL) error {
-	ep.hClient = httpClientSrcIP(localAddr, proxy)
+	ep.hClient = httpClientSrcIP(localAddr, proxy, ep.limiter)
 	return nil
 }
 
@@ -103,7 +104,7 @@ func (ep *OCITransportMethod) WithBindIntf(intf string) error {
 	if localAddr == nil {
 		return fmt.Errorf("failed to get the address for intf")
 	}
-	ep.hClient = httpClientSrcIP(localAddr, nil)
+	ep.hClient = httpClientSrcIP(localAddr, nil, ep.limiter)
 	return nil
 }
 
@@ -112,6 +113,14 @@ func (ep *OCITransportMethod) WithLogging(onoff bool) error {
 	return nil
 }
 
+// WithRateLimiter limits the bandwidth used by this endpoint. The limiter
+// is applied to the connections opened after this call hence it should
+// be called before the WithSrcIP functions.
+func (ep *OCITransportMethod) WithRateLimiter(limiter *RateLimiter) error {
+	ep.limiter = limiter
+	return nil
+}
+
 // processUpload artifact upload to OCI registry
 // not yet supported
 func (ep *OCITransportMethod) processUpload(req *DronaRequest) (int64, error) {
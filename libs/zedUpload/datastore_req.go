@@ -31,6 +31,10 @@ type DronaRequest struct {
 	cancelContext context.Context
 	cancelFunc    context.CancelFunc
 
+	// If resume is set a download continues from the end of an
+	// existing partial file, where the transport supports it
+	resume bool
+
 	// Object that needs to be downloaded
 	name      string
 	localName string
@@ -344,3 +348,11 @@ func (req *DronaRequest) WithCancel(ctx context.Context) *DronaRequest {
 	req.cancelFunc = cancel
 	return req
 }
+
+// WithResume asks for a download to continue from the end of an existing
+// partial local file rather than starting over. Only the HTTP transport
+// supports this; the others ignore it.
+func (req *DronaRequest) WithResume() *DronaRequest {
+	req.resume = true
+	return req
+}
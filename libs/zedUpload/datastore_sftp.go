@@ -38,6 +38,9 @@ type SftpTransportMethod struct {
 	failPostTime time.Time
 
 	ctx *DronaCtx
+
+	// optional, bandwidth limit
+	limiter *RateLimiter
 }
 
 //
@@ -108,6 +111,13 @@ func (ep *SftpTransportMethod) WithLogging(onoff bool) error {
 	return nil
 }
 
+// WithRateLimiter limits the bandwidth used by this endpoint. The limiter
+// is applied to the connections opened for each request.
+func (ep *SftpTransportMethod) WithRateLimiter(limiter *RateLimiter) error {
+	ep.limiter = limiter
+	return nil
+}
+
 // File upload to SFTP Datastore
 func (ep *SftpTransportMethod) processSftpUpload(req *DronaRequest) (error, int) {
 	file := req.name
@@ -138,7 +148,8 @@ func (ep *SftpTransportMethod) processSftpUpload(req *DronaRequest) (error, int)
 		}(req, prgChan)
 	}
 
-	resp := sftp.ExecCmd("put", ep.surl, ep.uname, ep.passwd, file, req.objloc, req.sizelimit, prgChan)
+	resp := sftp.ExecCmdWithConnWrapper("put", ep.surl, ep.uname, ep.passwd, file, req.objloc, req.sizelimit, prgChan,
+		ep.limiter.wrapConn)
 	return resp.Error, int(resp.Asize)
 }
 
@@ -172,7 +183,8 @@ func (ep *SftpTransportMethod) processSftpDownload(req *DronaRequest) (error, in
 		}(req, prgChan)
 	}
 
-	resp := sftp.ExecCmd("fetch", ep.surl, ep.uname, ep.passwd, file, req.objloc, req.sizelimit, prgChan)
+	resp := sftp.ExecCmdWithConnWrapper("fetch", ep.surl, ep.uname, ep.passwd, file, req.objloc, req.sizelimit, prgChan,
+		ep.limiter.wrapConn)
 	return resp.Error, int(resp.Asize)
 }
 
@@ -186,7 +198,8 @@ func (ep *SftpTransportMethod) processSftpDelete(req *DronaRequest) error {
 			file = ep.path + "/" + req.name
 		}
 	}
-	resp := sftp.ExecCmd("rm", ep.surl, ep.uname, ep.passwd, file, "", req.sizelimit, nil)
+	resp := sftp.ExecCmdWithConnWrapper("rm", ep.surl, ep.uname, ep.passwd, file, "", req.sizelimit, nil,
+		ep.limiter.wrapConn)
 	return resp.Error
 }
 
@@ -212,7 +225,8 @@ func (ep *SftpTransportMethod) processSftpList(req *DronaRequest) ([]string, err
 		}(req, prgChan)
 	}
 
-	resp := sftp.ExecCmd("ls", ep.surl, ep.uname, ep.passwd, ep.path, "", req.sizelimit, prgChan)
+	resp := sftp.ExecCmdWithConnWrapper("ls", ep.surl, ep.uname, ep.passwd, ep.path, "", req.sizelimit, prgChan,
+		ep.limiter.wrapConn)
 	return resp.List, resp.Error
 }
 
@@ -225,7 +239,8 @@ func (ep *SftpTransportMethod) processSftpObjectMetaData(req *DronaRequest) (err
 			file = ep.path + "/" + req.name
 		}
 	}
-	resp := sftp.ExecCmd("stat", ep.surl, ep.uname, ep.passwd, file, "", req.sizelimit, nil)
+	resp := sftp.ExecCmdWithConnWrapper("stat", ep.surl, ep.uname, ep.passwd, file, "", req.sizelimit, nil,
+		ep.limiter.wrapConn)
 	return resp.Error, resp.ContentLength
 }
 
@@ -5,6 +5,7 @@ package http
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"fmt"
 	"io"
@@ -65,6 +66,17 @@ func getHref(token html.Token) (ok bool, href string) {
 func ExecCmd(cmd, host, remoteFile, localFile string, objSize int64,
 	prgNotify NotifChan, client *http.Client) UpdateStats {
 
+	return ExecCmdWithContext(context.Background(), cmd, host, remoteFile,
+		localFile, objSize, prgNotify, client, false)
+}
+
+// ExecCmdWithContext is ExecCmd where a "get" can be cancelled using ctx.
+// If resume is set and localFile exists, a "get" asks the server for the
+// remainder of the object and appends it to localFile.
+func ExecCmdWithContext(ctx context.Context, cmd, host, remoteFile,
+	localFile string, objSize int64, prgNotify NotifChan,
+	client *http.Client, resume bool) UpdateStats {
+
 	var imgList []string
 	stats := UpdateStats{}
 	if client == nil {
@@ -115,7 +127,7 @@ func ExecCmd(cmd, host, remoteFile, localFile string, objSize int64,
 		}
 		return stats
 	case "get":
-		req, err := http.NewRequest(http.MethodGet, host, nil)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, host, nil)
 		if err != nil {
 			stats.Error = fmt.Errorf("request failed for get %s: %s",
 				host, err)
@@ -123,6 +135,13 @@ func ExecCmd(cmd, host, remoteFile, localFile string, objSize int64,
 		}
 		req.Header.Set("User-Agent", userAgent)
 		req.Header.Set("Content-Type", "application/octet-stream")
+		var offset int64
+		if resume {
+			if info, err := os.Stat(localFile); err == nil && info.Size() > 0 {
+				offset = info.Size()
+				req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+			}
+		}
 
 		resp, err := client.Do(req)
 		if err != nil {
@@ -130,10 +149,25 @@ func ExecCmd(cmd, host, remoteFile, localFile string, objSize int64,
 				host, err)
 			return stats
 		}
-		if resp.StatusCode != 200 {
+		defer resp.Body.Close()
+		fileFlags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		switch {
+		case resp.StatusCode == http.StatusPartialContent && offset != 0:
+			fileFlags = os.O_WRONLY | os.O_APPEND
+		case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset != 0:
+			// The partial file does not match the object; start over
+			// on the next attempt
+			os.Remove(localFile)
+			stats.Error = fmt.Errorf("cannot resume %s from %d",
+				host, offset)
+			return stats
+		case resp.StatusCode != 200:
 			stats.Error = fmt.Errorf("bad response code for %s: %d",
 				host, resp.StatusCode)
 			return stats
+		default:
+			// Server ignored the range
+			offset = 0
 		}
 		tempLocalFile := localFile
 		index := strings.LastIndex(tempLocalFile, "/")
@@ -142,15 +176,15 @@ func ExecCmd(cmd, host, remoteFile, localFile string, objSize int64,
 			stats.Error = dir_err
 			return stats
 		}
-		local, fileErr := os.Create(localFile)
+		local, fileErr := os.OpenFile(localFile, fileFlags, 0666)
 		if fileErr != nil {
 			stats.Error = fileErr
 			return stats
 		}
 		defer local.Close()
-		defer resp.Body.Close()
 		chunkSize := SingleMB
-		var written, copiedSize int64
+		var written int64
+		copiedSize := offset
 		stats.Size = objSize
 		for {
 			var copyErr error
@@ -172,6 +206,9 @@ func ExecCmd(cmd, host, remoteFile, localFile string, objSize int64,
 			}
 		}
 		stats.BodyLength = int(resp.ContentLength)
+		if resp.ContentLength >= 0 {
+			stats.BodyLength += int(offset)
+		}
 		return stats
 	case "post":
 		file, err := os.Open(localFile)
@@ -0,0 +1,133 @@
+// Copyright(c) 2021 Zededa, Inc.
+// All rights reserved.
+
+package zedUpload
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// rateLimitChunk is the largest read or write we do in one go on a
+	// rate limited connection, so that concurrent transfers sharing the
+	// limiter get a fair share.
+	rateLimitChunk = 32 * 1024
+)
+
+// RateLimiter is a token bucket which limits the combined throughput of
+// all the connections using it. The same RateLimiter can be passed to
+// several endpoints, for instance to apply a cap per datastore.
+// The rate can be changed at any time and applies to transfers which are
+// already in progress.
+type RateLimiter struct {
+	sync.Mutex
+	rate   int64   // bytes per second; zero means unlimited
+	tokens float64 // can be negative when transfers are in debt
+	last   time.Time
+}
+
+// NewRateLimiter returns a RateLimiter allowing bytesPerSecond. Zero means
+// unlimited.
+func NewRateLimiter(bytesPerSecond int64) *RateLimiter {
+	rl := &RateLimiter{}
+	rl.SetRate(bytesPerSecond)
+	return rl
+}
+
+// SetRate changes the limit. Zero means unlimited.
+func (rl *RateLimiter) SetRate(bytesPerSecond int64) {
+	rl.Lock()
+	defer rl.Unlock()
+	if bytesPerSecond < 0 {
+		bytesPerSecond = 0
+	}
+	if bytesPerSecond != rl.rate {
+		// Start with a full bucket of one second worth of data
+		rl.tokens = float64(bytesPerSecond)
+		rl.last = time.Now()
+	}
+	rl.rate = bytesPerSecond
+}
+
+// Rate returns the current limit in bytes per second
+func (rl *RateLimiter) Rate() int64 {
+	rl.Lock()
+	defer rl.Unlock()
+	return rl.rate
+}
+
+// chunk returns the largest transfer to do before calling wait
+func (rl *RateLimiter) chunk() int {
+	rate := rl.Rate()
+	if rate == 0 || rate > rateLimitChunk {
+		return rateLimitChunk
+	}
+	return int(rate)
+}
+
+// wait consumes n bytes worth of tokens and sleeps until the bucket is
+// no longer in debt
+func (rl *RateLimiter) wait(n int) {
+	rl.Lock()
+	if rl.rate == 0 || n <= 0 {
+		rl.Unlock()
+		return
+	}
+	now := time.Now()
+	rl.tokens += now.Sub(rl.last).Seconds() * float64(rl.rate)
+	if rl.tokens > float64(rl.rate) {
+		rl.tokens = float64(rl.rate)
+	}
+	rl.last = now
+	rl.tokens -= float64(n)
+	var delay time.Duration
+	if rl.tokens < 0 {
+		delay = time.Duration(-rl.tokens / float64(rl.rate) * float64(time.Second))
+	}
+	rl.Unlock()
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// wrapConn returns conn unchanged if rl is nil
+func (rl *RateLimiter) wrapConn(conn net.Conn) net.Conn {
+	if rl == nil || conn == nil {
+		return conn
+	}
+	return &rateLimitedConn{Conn: conn, limiter: rl}
+}
+
+// rateLimitedConn limits reads and writes on the underlying connection
+type rateLimitedConn struct {
+	net.Conn
+	limiter *RateLimiter
+}
+
+func (c *rateLimitedConn) Read(b []byte) (int, error) {
+	if chunk := c.limiter.chunk(); len(b) > chunk {
+		b = b[:chunk]
+	}
+	n, err := c.Conn.Read(b)
+	c.limiter.wait(n)
+	return n, err
+}
+
+func (c *rateLimitedConn) Write(b []byte) (int, error) {
+	var written int
+	for written < len(b) {
+		end := written + c.limiter.chunk()
+		if end > len(b) {
+			end = len(b)
+		}
+		c.limiter.wait(end - written)
+		n, err := c.Conn.Write(b[written:end])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
@@ -31,7 +31,11 @@ type UpdateStats struct {
 
 type NotifChan chan UpdateStats
 
-func getSftpClient(host, user, pass string) (*sftp.Client, error) {
+// ConnWrapper can be used to interpose on the connection to the server,
+// for instance to limit its bandwidth
+type ConnWrapper func(net.Conn) net.Conn
+
+func getSftpClient(host, user, pass string, wrap ConnWrapper) (*sftp.Client, error) {
 	clientConfig := &ssh.ClientConfig{
 		User: user,
 		Auth: []ssh.AuthMethod{
@@ -56,10 +60,19 @@ func getSftpClient(host, user, pass string) (*sftp.Client, error) {
 		log.Printf("LookupHost error: %s", err)
 		return nil, err
 	}
-	client, err := ssh.Dial("tcp", host, clientConfig)
+	conn, err := net.DialTimeout("tcp", host, clientConfig.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	if wrap != nil {
+		conn = wrap(conn)
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, host, clientConfig)
 	if err != nil {
+		conn.Close()
 		return nil, err
 	}
+	client := ssh.NewClient(c, chans, reqs)
 	session, err := sftp.NewClient(client)
 	if err != nil {
 		return nil, err
@@ -70,9 +83,18 @@ func getSftpClient(host, user, pass string) (*sftp.Client, error) {
 func ExecCmd(cmd, host, user, pass, remoteFile, localFile string,
 	objSize int64, prgNotify NotifChan) UpdateStats {
 
+	return ExecCmdWithConnWrapper(cmd, host, user, pass, remoteFile,
+		localFile, objSize, prgNotify, nil)
+}
+
+// ExecCmdWithConnWrapper is ExecCmd with the connection to the server
+// passed through wrap
+func ExecCmdWithConnWrapper(cmd, host, user, pass, remoteFile, localFile string,
+	objSize int64, prgNotify NotifChan, wrap ConnWrapper) UpdateStats {
+
 	var list []string
 	stats := UpdateStats{}
-	client, err := getSftpClient(host, user, pass)
+	client, err := getSftpClient(host, user, pass, wrap)
 	if err != nil {
 		stats.Error = fmt.Errorf("sftpclient failed for %s: %s",
 			host, err)
@@ -105,9 +105,17 @@ type appDomain struct {
 	appUUID     string
 	appName     string
 	domainName  string
+	container   bool // runs as native containers, see lookupAppDomain
 	msgIDAppCnt uint64
 }
 
+// containerLog is the content of a log entry of an app container
+type containerLog struct {
+	Container string `json:"container"`
+	Time      string `json:"time"`
+	Msg       string `json:"msg"`
+}
+
 type inputEntry struct {
 	severity  string
 	source    string
@@ -424,6 +432,7 @@ func handleDomainStatusImp(ctxArg interface{}, key string, statusArg interface{}
 		appUUID:     status.UUIDandVersion.UUID.String(),
 		appName:     status.DisplayName,
 		domainName:  status.DomainName,
+		container:   status.VirtualizationMode == types.NOHYPER,
 		msgIDAppCnt: 1,
 	}
 	domainUUID[status.DomainName] = appD
@@ -776,7 +785,7 @@ func checkAppEntry(entry *inputEntry) string {
 	var appSplitArr []string
 	if entry.appUUID != "" {
 		appuuid = entry.appUUID
-		entry.content = "{\"container\":\"" + entry.acName + "\",\"time\":\"" + entry.acLogTime + "\",\"msg\":\"" + entry.content + "\"}"
+		entry.content = containerLogContent(entry.acName, entry.acLogTime, entry.content)
 	} else if strings.HasPrefix(entry.source, "guest_vm-") {
 		appSplitArr = strings.SplitN(entry.source, "guest_vm-", 2)
 		appVMlog = true
@@ -788,8 +797,11 @@ func checkAppEntry(entry *inputEntry) string {
 		if len(appSplitArr) == 2 {
 			if appSplitArr[0] == "" && appSplitArr[1] != "" {
 				entry.source = appSplitArr[1]
-				if du, ok := domainUUID[entry.source]; ok {
+				if du, acName, ok := lookupAppDomain(entry.source); ok {
 					appuuid = du.appUUID
+					if acName != "" {
+						entry.content = containerLogContent(acName, entry.timestamp, entry.content)
+					}
 				}
 			}
 		}
@@ -797,6 +809,40 @@ func checkAppEntry(entry *inputEntry) string {
 	return appuuid
 }
 
+// lookupAppDomain returns the app domain of the source of a guest_vm log,
+// and for an app running as native containers the name of the container
+// which wrote it. The output of a container of a pod is logged as
+// <domain>.<name>, that of any other container as <domain>, in which case
+// the container is named after the app like in the metrics from domainmgr.
+func lookupAppDomain(source string) (appDomain, string, bool) {
+	if du, ok := domainUUID[source]; ok {
+		if du.container {
+			return du, du.appName, true
+		}
+		return du, "", true
+	}
+	if i := strings.LastIndex(source, "."); i > 0 {
+		if du, ok := domainUUID[source[:i]]; ok && du.container {
+			return du, source[i+1:], true
+		}
+	}
+	return appDomain{}, "", false
+}
+
+// containerLogContent returns the content of a log entry of an app
+// container, tagged with its name
+func containerLogContent(acName, acLogTime, msg string) string {
+	content, err := json.Marshal(containerLog{
+		Container: acName,
+		Time:      acLogTime,
+		Msg:       msg,
+	})
+	if err != nil {
+		return msg
+	}
+	return string(content)
+}
+
 // updateLogMsgID - handles the msgID for log for both dev and apps
 // dev log does not have app-uuid, thus domainName passed in is ""
 func updateLogMsgID(domainName string) uint64 {
@@ -64,6 +64,10 @@ const (
 	DownloaderConfigLogType LogObjectType = "downloader_config"
 	// DownloaderStatusLogType :
 	DownloaderStatusLogType LogObjectType = "downloader_status"
+	// UploaderConfigLogType :
+	UploaderConfigLogType LogObjectType = "uploader_config"
+	// UploaderStatusLogType :
+	UploaderStatusLogType LogObjectType = "uploader_status"
 	// ResolveConfigLogType :
 	ResolveConfigLogType LogObjectType = "resolve_config"
 	// ResolveStatusLogType :
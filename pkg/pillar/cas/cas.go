@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"strings"
 
 	"github.com/lf-edge/edge-containers/pkg/resolver"
 	"github.com/lf-edge/eve/pkg/pillar/types"
@@ -105,6 +107,20 @@ type CAS interface {
 	// The rootPath is expected to end in a basename that becomes the snapshotID
 	PrepareContainerRootDir(rootPath, reference, rootBlobSha string) error
 
+	// LazyPullAvailable reports whether the layers of images can be left to a remote
+	// snapshotter, which fetches their contents from the registry when they are read.
+	LazyPullAvailable() bool
+
+	// PrepareLazyContainerRootDir is PrepareContainerRootDir for an image of which only the manifest
+	// and the config were ingested. The layers are fetched by the remote snapshotter from imageRef,
+	// the reference of the image in the registry. Returns an error if any of them can not be, in which
+	// case the image has to be pulled in full.
+	PrepareLazyContainerRootDir(rootPath, reference, imageRef string) error
+
+	// ImageLocalSize returns how many bytes of the layers of the image with 'reference' are
+	// on the device, and the size of all of them.
+	ImageLocalSize(reference string) (int64, int64, error)
+
 	// UnmountContainerRootDir unmounts container's rootPath.
 	UnmountContainerRootDir(rootPath string) error
 
@@ -137,6 +153,27 @@ type casDesc struct {
 
 var knownCASHandlers = map[string]casDesc{
 	"containerd": {constructor: newContainerdCAS},
+	"filesystem": {constructor: newFilesystemCAS},
+}
+
+// DefaultCAS is the CAS used unless types.CASTypeFileName names another one
+const DefaultCAS = "containerd"
+
+// SelectedCAS returns the name of the CAS for the app images on this device,
+// which is the content of types.CASTypeFileName if it names a known CAS and
+// DefaultCAS otherwise. The agents sharing the images, volumemgr and
+// domainmgr, read it once when they start. Changing it leaves the content of
+// the other CAS behind hence it is meant to be set when EVE is installed.
+func SelectedCAS() string {
+	data, err := ioutil.ReadFile(types.CASTypeFileName)
+	if err != nil {
+		return DefaultCAS
+	}
+	selected := strings.TrimSpace(string(data))
+	if _, found := knownCASHandlers[selected]; !found {
+		return DefaultCAS
+	}
+	return selected
 }
 
 // NewCAS returns new CAS object with a new client of underlying implementor(selectedCAS).
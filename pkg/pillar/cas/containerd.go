@@ -104,43 +104,7 @@ func (c *containerdCAS) ListBlobsMediaTypes() (map[string]string, error) {
 	}
 	// save the root and type of each image
 	for _, i := range imageObjectList {
-		dig, mediaType := i.Target.Digest.String(), i.Target.MediaType
-		hashMap[dig] = mediaType
-		switch v1types.MediaType(mediaType) {
-		case v1types.OCIImageIndex, v1types.DockerManifestList:
-			index, err := getIndexManifest(c, dig)
-			if err != nil {
-				logrus.Infof("ListBlobsMediaTypes: could not get index for %s, ignoring", dig)
-				continue
-			}
-			// save all of the manifests
-			for _, m := range index.Manifests {
-				digm := m.Digest.String()
-				hashMap[digm] = string(m.MediaType)
-				// and now read each manifest
-				manifest, err := getManifest(c, digm)
-				if err != nil {
-					logrus.Infof("ListBlobsMediaTypes: could not get manifest for %s in index %s, ignoring", digm, dig)
-					continue
-				}
-				// read the config and the layers
-				hashMap[manifest.Config.Digest.String()] = string(manifest.Config.MediaType)
-				for _, l := range manifest.Layers {
-					hashMap[l.Digest.String()] = string(l.MediaType)
-				}
-			}
-		case v1types.OCIManifestSchema1, v1types.DockerManifestSchema1, v1types.DockerManifestSchema2, v1types.DockerManifestSchema1Signed:
-			manifest, err := getManifest(c, dig)
-			if err != nil {
-				logrus.Infof("ListBlobsMediaTypes: could not get manifest for %s, ignoring", dig)
-				continue
-			}
-			// read the config and the layers
-			hashMap[manifest.Config.Digest.String()] = string(manifest.Config.MediaType)
-			for _, l := range manifest.Layers {
-				hashMap[l.Digest.String()] = string(l.MediaType)
-			}
-		}
+		addBlobsMediaTypes(c, hashMap, i.Target.Digest.String(), i.Target.MediaType)
 	}
 	return hashMap, nil
 }
@@ -590,42 +554,64 @@ func (c *containerdCAS) PrepareContainerRootDir(rootPath, reference, rootBlobSha
 	}
 
 	//Step 3: write OCI image config/spec json under the container's rootPath.
-	clientImageSpec, err := getImageConfig(c, reference)
+	return writeImageConfig(c, rootPath, reference)
+}
+
+// LazyPullAvailable reports whether containerd has loaded the remote snapshotter
+func (c *containerdCAS) LazyPullAvailable() bool {
+	ctrdCtx, done := c.ctrdClient.CtrNewUserServicesCtx()
+	defer done()
+	return c.ctrdClient.CtrLazySnapshotterAvailable(ctrdCtx)
+}
+
+// PrepareLazyContainerRootDir prepares a writable snapshot from the reference in the remote snapshotter,
+// which mounts the layers from imageRef in the registry. Apart from that it is PrepareContainerRootDir.
+func (c *containerdCAS) PrepareLazyContainerRootDir(rootPath, reference, imageRef string) error {
+	//Step 1: remove any existing bundle of the container, like PrepareContainerRootDir
+	if c.RemoveContainerRootDir(rootPath) != nil {
+		logrus.Warnf("PrepareLazyContainerRootDir: tried to clean up any existing state, hopefully it worked")
+	}
+
+	//Step 2: create snapshot of the image in the remote snapshotter. The snapshots of the
+	// layers are prepared under a lease so that containerd removes them if we fail.
+	ctrdCtx, done, err := c.ctrdClient.CtrNewUserServicesCtxWithLease()
 	if err != nil {
-		err = fmt.Errorf("PrepareContainerRootDir: exception while fetching image config for reference %s: %s",
-			reference, err.Error())
-		logrus.Errorf(err.Error())
-		return err
+		return fmt.Errorf("PrepareLazyContainerRootDir: exception while creating lease: %v", err)
 	}
-	mountpoints := clientImageSpec.Config.Volumes
-	execpath := clientImageSpec.Config.Entrypoint
-	cmd := clientImageSpec.Config.Cmd
-	workdir := clientImageSpec.Config.WorkingDir
-	unProcessedEnv := clientImageSpec.Config.Env
-	logrus.Infof("PrepareContainerRootDir: mountPoints %+v execpath %+v cmd %+v workdir %+v env %+v",
-		mountpoints, execpath, cmd, workdir, unProcessedEnv)
-	clientImageSpecJSON, err := getJSON(clientImageSpec)
+	defer done()
+	clientImageObj, err := c.ctrdClient.CtrGetImage(ctrdCtx, reference)
 	if err != nil {
-		err = fmt.Errorf("PrepareContainerRootDir: Could not build json of image: %v. %v",
-			reference, err.Error())
-		logrus.Errorf(err.Error())
-		return err
+		return fmt.Errorf("PrepareLazyContainerRootDir: Exception while getting clientImageObj: %s. %s", reference, err.Error())
 	}
-
-	if err := os.MkdirAll(rootPath, 0766); err != nil {
-		err = fmt.Errorf("PrepareContainerRootDir: Exception while creating rootPath dir. %v", err)
+	snapshotID := containerd.GetSnapshotID(rootPath)
+	if err := c.ctrdClient.CtrPrepareLazySnapshot(ctrdCtx, snapshotID, clientImageObj, imageRef); err != nil {
+		err = fmt.Errorf("PrepareLazyContainerRootDir: Could not create snapshot %s. %v", snapshotID, err)
 		logrus.Errorf(err.Error())
 		return err
 	}
-	if err := ioutil.WriteFile(filepath.Join(rootPath, imageConfigFilename), []byte(clientImageSpecJSON), 0666); err != nil {
-		err = fmt.Errorf("PrepareContainerRootDir: Exception while writing image info to %v/%v. %v",
-			rootPath, imageConfigFilename, err)
-		logrus.Errorf(err.Error())
+
+	//Step 3: write OCI image config/spec json under the container's rootPath.
+	if err := writeImageConfig(c, rootPath, reference); err != nil {
+		if rerr := c.RemoveSnapshot(snapshotID); rerr != nil {
+			logrus.Errorf("PrepareLazyContainerRootDir: %v", rerr)
+		}
 		return err
 	}
 	return nil
 }
 
+// ImageLocalSize returns how many bytes of the layers of the image are in the content store or,
+// for the lazily pulled ones, cached by the remote snapshotter; and the size of all of them.
+func (c *containerdCAS) ImageLocalSize(reference string) (int64, int64, error) {
+	ctrdCtx, done := c.ctrdClient.CtrNewUserServicesCtx()
+	defer done()
+	clientImageObj, err := c.ctrdClient.CtrGetImage(ctrdCtx, reference)
+	if err != nil {
+		return 0, 0, fmt.Errorf("ImageLocalSize: Exception while getting clientImageObj: %s. %s", reference, err.Error())
+	}
+	return c.ctrdClient.CtrImageLocalSize(ctrdCtx, clientImageObj)
+}
+
 // UnmountContainerRootDir unmounts container's rootPath
 func (c *containerdCAS) UnmountContainerRootDir(rootPath string) error {
 	if err := mount.Unmount(filepath.Join(rootPath, containerRootfsPath), 0); err != nil {
@@ -753,9 +739,50 @@ func newContainerdCAS() CAS {
 	return &containerdCAS{ctrdClient: ctrdClient}
 }
 
+// addBlobsMediaTypes adds to hashMap the media types of the root blob of an
+// image and of the manifests, configs and layers below it
+func addBlobsMediaTypes(c CAS, hashMap map[string]string, dig, mediaType string) {
+	hashMap[dig] = mediaType
+	switch v1types.MediaType(mediaType) {
+	case v1types.OCIImageIndex, v1types.DockerManifestList:
+		index, err := getIndexManifest(c, dig)
+		if err != nil {
+			logrus.Infof("ListBlobsMediaTypes: could not get index for %s, ignoring", dig)
+			return
+		}
+		// save all of the manifests
+		for _, m := range index.Manifests {
+			digm := m.Digest.String()
+			hashMap[digm] = string(m.MediaType)
+			// and now read each manifest
+			manifest, err := getManifest(c, digm)
+			if err != nil {
+				logrus.Infof("ListBlobsMediaTypes: could not get manifest for %s in index %s, ignoring", digm, dig)
+				continue
+			}
+			// read the config and the layers
+			hashMap[manifest.Config.Digest.String()] = string(manifest.Config.MediaType)
+			for _, l := range manifest.Layers {
+				hashMap[l.Digest.String()] = string(l.MediaType)
+			}
+		}
+	case v1types.OCIManifestSchema1, v1types.DockerManifestSchema1, v1types.DockerManifestSchema2, v1types.DockerManifestSchema1Signed:
+		manifest, err := getManifest(c, dig)
+		if err != nil {
+			logrus.Infof("ListBlobsMediaTypes: could not get manifest for %s, ignoring", dig)
+			return
+		}
+		// read the config and the layers
+		hashMap[manifest.Config.Digest.String()] = string(manifest.Config.MediaType)
+		for _, l := range manifest.Layers {
+			hashMap[l.Digest.String()] = string(l.MediaType)
+		}
+	}
+}
+
 //getIndexManifest: returns a indexManifest by parsing the given blobSha256
-func getIndexManifest(c *containerdCAS, blobSha256 string) (*v1.IndexManifest, error) {
-	ctrdCtx, done := c.ctrdClient.CtrNewUserServicesCtx()
+func getIndexManifest(c CAS, blobSha256 string) (*v1.IndexManifest, error) {
+	ctrdCtx, done := c.CtrNewUserServicesCtx()
 	defer done()
 
 	reader, err := c.ReadBlob(ctrdCtx, blobSha256)
@@ -770,7 +797,7 @@ func getIndexManifest(c *containerdCAS, blobSha256 string) (*v1.IndexManifest, e
 }
 
 //getManifestFromIndex: returns Manifest for the current architecture from IndexManifest
-func getManifestFromIndex(c *containerdCAS, indexManifest *v1.IndexManifest) (*v1.Manifest, error) {
+func getManifestFromIndex(c CAS, indexManifest *v1.IndexManifest) (*v1.Manifest, error) {
 	manifestSha256, err := getManifestBlobSha256FromIndex(indexManifest)
 	if err != nil {
 		return nil, fmt.Errorf("getManifestFromIndex: Exception while fetching manifest sha256: %s", err.Error())
@@ -779,8 +806,8 @@ func getManifestFromIndex(c *containerdCAS, indexManifest *v1.IndexManifest) (*v
 }
 
 //getManifest: returns manifest as type v1.Manifest byr parsing the given blobSha256
-func getManifest(c *containerdCAS, blobSha256 string) (*v1.Manifest, error) {
-	ctrdCtx, done := c.ctrdClient.CtrNewUserServicesCtx()
+func getManifest(c CAS, blobSha256 string) (*v1.Manifest, error) {
+	ctrdCtx, done := c.CtrNewUserServicesCtx()
 	defer done()
 
 	reader, err := c.ReadBlob(ctrdCtx, blobSha256)
@@ -813,7 +840,7 @@ func isNotFoundError(err error) bool {
 }
 
 // getBlobSize get the size of a blob
-func getBlobSize(c *containerdCAS, blobHash string) (int64, error) {
+func getBlobSize(c CAS, blobHash string) (int64, error) {
 	info, err := c.GetBlobInfo(blobHash)
 	if err != nil {
 		return 0, fmt.Errorf("unable to get blob info for %s: %v", blobHash, err)
@@ -822,7 +849,7 @@ func getBlobSize(c *containerdCAS, blobHash string) (int64, error) {
 }
 
 //getImageConfig returns imageConfig for a reference
-func getImageConfig(c *containerdCAS, reference string) (*ocispec.Image, error) {
+func getImageConfig(c CAS, reference string) (*ocispec.Image, error) {
 	index := ocispec.Index{}
 	manifests := ocispec.Manifest{}
 	imageConfig := ocispec.Image{}
@@ -834,7 +861,7 @@ func getImageConfig(c *containerdCAS, reference string) (*ocispec.Image, error)
 
 	}
 
-	ctrdCtx, done := c.ctrdClient.CtrNewUserServicesCtx()
+	ctrdCtx, done := c.CtrNewUserServicesCtx()
 	defer done()
 
 	//Step 2: Read the parent blob data
@@ -920,6 +947,45 @@ func getImageConfig(c *containerdCAS, reference string) (*ocispec.Image, error)
 	return &imageConfig, nil
 }
 
+// writeImageConfig writes the OCI image config of the reference as
+// image-config.json under the container's rootPath
+func writeImageConfig(c CAS, rootPath, reference string) error {
+	clientImageSpec, err := getImageConfig(c, reference)
+	if err != nil {
+		err = fmt.Errorf("writeImageConfig: exception while fetching image config for reference %s: %s",
+			reference, err.Error())
+		logrus.Errorf(err.Error())
+		return err
+	}
+	mountpoints := clientImageSpec.Config.Volumes
+	execpath := clientImageSpec.Config.Entrypoint
+	cmd := clientImageSpec.Config.Cmd
+	workdir := clientImageSpec.Config.WorkingDir
+	unProcessedEnv := clientImageSpec.Config.Env
+	logrus.Infof("writeImageConfig: mountPoints %+v execpath %+v cmd %+v workdir %+v env %+v",
+		mountpoints, execpath, cmd, workdir, unProcessedEnv)
+	clientImageSpecJSON, err := getJSON(clientImageSpec)
+	if err != nil {
+		err = fmt.Errorf("writeImageConfig: Could not build json of image: %v. %v",
+			reference, err.Error())
+		logrus.Errorf(err.Error())
+		return err
+	}
+
+	if err := os.MkdirAll(rootPath, 0766); err != nil {
+		err = fmt.Errorf("writeImageConfig: Exception while creating rootPath dir. %v", err)
+		logrus.Errorf(err.Error())
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(rootPath, imageConfigFilename), []byte(clientImageSpecJSON), 0666); err != nil {
+		err = fmt.Errorf("writeImageConfig: Exception while writing image info to %v/%v. %v",
+			rootPath, imageConfigFilename, err)
+		logrus.Errorf(err.Error())
+		return err
+	}
+	return nil
+}
+
 // getJSON - returns input in JSON format
 func getJSON(x interface{}) (string, error) {
 	b, err := json.MarshalIndent(x, "", "    ")
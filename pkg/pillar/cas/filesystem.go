@@ -0,0 +1,1133 @@
+package cas
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/archive"
+	"github.com/containerd/containerd/archive/compression"
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/mount"
+	"github.com/containerd/containerd/remotes"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/lf-edge/edge-containers/pkg/resolver"
+	"github.com/lf-edge/eve/pkg/pillar/containerd"
+	"github.com/lf-edge/eve/pkg/pillar/types"
+	fileutils "github.com/lf-edge/eve/pkg/pillar/utils/file"
+	"github.com/opencontainers/go-digest"
+	spec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
+)
+
+// The filesystem CAS keeps everything in plain directories under its root:
+//    blobs/sha256/<hash>          the content of the blobs
+//    labels/sha256/<hash>.json    the labels of the blobs, if any
+//    images/<reference>.json      the target descriptor of each image
+//    layers/sha256/<hash>/        the unpacked layers, shared by snapshots
+//    snapshots/<snapshotID>/      info.json and the upper and work
+//                                 directories of the overlay mount
+// where the references and snapshot IDs are path escaped. Nothing is
+// garbage collected implicitly; the unpacked layers are removed when the
+// last snapshot using them is removed.
+const (
+	filesystemCASType = "filesystem"
+	fsBlobsDir        = "blobs"
+	fsIngestDir       = "ingest"
+	fsLabelsDir       = "labels"
+	fsImagesDir       = "images"
+	fsLayersDir       = "layers"
+	fsSnapshotsDir    = "snapshots"
+	fsSnapshotInfo    = "info.json"
+	fsUpperDir        = "upper"
+	fsWorkDir         = "work"
+)
+
+// fsLock serializes the creation and removal of snapshots and layers by the
+// filesystem CAS clients in this process
+var fsLock sync.Mutex
+
+type filesystemCAS struct {
+	root string
+}
+
+// fsImage is what is stored for an image
+type fsImage struct {
+	Name      string
+	Target    spec.Descriptor
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// fsSnapshot is what is stored for a snapshot
+type fsSnapshot struct {
+	Reference string
+	Layers    []string // digests of the layers, the lowest first
+	CreatedAt time.Time
+}
+
+func (c *filesystemCAS) blobPath(blobHash string) (string, error) {
+	d, err := digest.Parse(blobHash)
+	if err != nil {
+		return "", fmt.Errorf("invalid blob hash %s: %v", blobHash, err)
+	}
+	return filepath.Join(c.root, fsBlobsDir, d.Algorithm().String(), d.Encoded()), nil
+}
+
+func (c *filesystemCAS) labelsPath(blobHash string) (string, error) {
+	d, err := digest.Parse(blobHash)
+	if err != nil {
+		return "", fmt.Errorf("invalid blob hash %s: %v", blobHash, err)
+	}
+	return filepath.Join(c.root, fsLabelsDir, d.Algorithm().String(), d.Encoded()+".json"), nil
+}
+
+func (c *filesystemCAS) layerPath(blobHash string) (string, error) {
+	d, err := digest.Parse(blobHash)
+	if err != nil {
+		return "", fmt.Errorf("invalid blob hash %s: %v", blobHash, err)
+	}
+	return filepath.Join(c.root, fsLayersDir, d.Algorithm().String(), d.Encoded()), nil
+}
+
+func (c *filesystemCAS) imagePath(reference string) string {
+	return filepath.Join(c.root, fsImagesDir, url.PathEscape(reference)+".json")
+}
+
+func (c *filesystemCAS) snapshotPath(snapshotID string) string {
+	return filepath.Join(c.root, fsSnapshotsDir, url.PathEscape(snapshotID))
+}
+
+//CheckBlobExists: returns true if the blob exists. Arg 'blobHash' should be of format sha256:<hash>.
+func (c *filesystemCAS) CheckBlobExists(blobHash string) bool {
+	blobPath, err := c.blobPath(blobHash)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(blobPath)
+	return err == nil
+}
+
+//GetBlobInfo: returns BlobInfo of type BlobInfo for the given blobHash.
+// Arg 'blobHash' should be of format sha256:<hash>.
+//Returns error if no blob is found for the given 'blobHash'.
+func (c *filesystemCAS) GetBlobInfo(blobHash string) (*BlobInfo, error) {
+	blobPath, err := c.blobPath(blobHash)
+	if err != nil {
+		return nil, fmt.Errorf("GetBlobInfo: %v", err)
+	}
+	info, err := os.Stat(blobPath)
+	if err != nil {
+		return nil, fmt.Errorf("GetBlobInfo: Exception while getting size of blob: %s. %s", blobHash, err.Error())
+	}
+	labels, err := c.readLabels(blobHash)
+	if err != nil {
+		return nil, fmt.Errorf("GetBlobInfo: Exception while getting labels of blob: %s. %s", blobHash, err.Error())
+	}
+	return &BlobInfo{
+		Digest: digest.Digest(blobHash).String(),
+		Size:   info.Size(),
+		Labels: labels,
+	}, nil
+}
+
+func (c *filesystemCAS) readLabels(blobHash string) (map[string]string, error) {
+	labelsPath, err := c.labelsPath(blobHash)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(labelsPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	labels := make(map[string]string)
+	if err := json.Unmarshal(data, &labels); err != nil {
+		return nil, err
+	}
+	return labels, nil
+}
+
+//ListBlobInfo: returns list of BlobInfo for all the blob present in CAS
+func (c *filesystemCAS) ListBlobInfo() ([]*BlobInfo, error) {
+	blobInfos := make([]*BlobInfo, 0)
+	algos, err := readDir(filepath.Join(c.root, fsBlobsDir))
+	if err != nil {
+		return nil, fmt.Errorf("ListBlobInfo: Exception while getting blob list. %s", err.Error())
+	}
+	for _, algo := range algos {
+		if !algo.IsDir() {
+			continue
+		}
+		files, err := ioutil.ReadDir(filepath.Join(c.root, fsBlobsDir, algo.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("ListBlobInfo: Exception while getting blob list. %s", err.Error())
+		}
+		for _, file := range files {
+			info, err := c.GetBlobInfo(algo.Name() + ":" + file.Name())
+			if err != nil {
+				// Removed since we listed the directory
+				logrus.Warnf("ListBlobInfo: %v", err)
+				continue
+			}
+			blobInfos = append(blobInfos, info)
+		}
+	}
+	return blobInfos, nil
+}
+
+// ListBlobsMediaTypes get a map of all blobs and their media types.
+// If a blob does not have a media type, it is not returned here.
+// If you want *all* blobs, whether or not it has a type, use ListBlobInfo
+func (c *filesystemCAS) ListBlobsMediaTypes() (map[string]string, error) {
+	hashMap := map[string]string{}
+	references, err := c.ListImages()
+	if err != nil {
+		return nil, fmt.Errorf("ListBlobsMediaTypes: Exception while getting image list. %s", err.Error())
+	}
+	for _, reference := range references {
+		image, err := c.readImage(reference)
+		if err != nil {
+			logrus.Infof("ListBlobsMediaTypes: could not read image %s, ignoring: %v", reference, err)
+			continue
+		}
+		addBlobsMediaTypes(c, hashMap, image.Target.Digest.String(), image.Target.MediaType)
+	}
+	return hashMap, nil
+}
+
+// IngestBlob: parses the given one or more `blobs` (BlobStatus) and for each blob reads the blob data from
+// BlobStatus.Path or BlobStatus.Content and ingests it into CAS's blob store.
+// Accepts a custom context. If ctx is nil, then default context will be used.
+// Returns a list of loaded BlobStatus and an error is thrown if the read blob's hash does not match with the
+// respective BlobStatus.Sha256 or if there is an exception while reading the blob data.
+// In case of exception, the returned list of loaded blobs will contain all the blob that were loaded until that point.
+func (c *filesystemCAS) IngestBlob(ctx context.Context, blobs ...types.BlobStatus) ([]types.BlobStatus, error) {
+	loadedBlobs, _, err := c.ingestBlobs(ctx, blobs...)
+	return loadedBlobs, err
+}
+
+// ingestBlobs is IngestBlob which also returns the hashes of the blobs which
+// were not in CAS before
+func (c *filesystemCAS) ingestBlobs(ctx context.Context, blobs ...types.BlobStatus) ([]types.BlobStatus, []string, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	loadedBlobs := make([]types.BlobStatus, 0)
+	created := make([]string, 0)
+	for _, blob := range blobs {
+		sha := fmt.Sprintf("%s:%s", digest.SHA256, strings.ToLower(blob.Sha256))
+
+		logrus.Infof("IngestBlob(%s): processing blob %+v", blob.Sha256, blob)
+		// Process the blob only if its not in a loaded status already
+		if blob.State == types.LOADED {
+			logrus.Infof("IngestBlob(%s): Not loading blob as it is already marked as loaded", blob.Sha256)
+			loadedBlobs = append(loadedBlobs, blob)
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return loadedBlobs, created, fmt.Errorf("IngestBlob(%s): %v", blob.Sha256, err)
+		}
+
+		var contentReader io.Reader
+		switch {
+		case blob.Path == "" && len(blob.Content) == 0:
+			err := fmt.Errorf("IngestBlob(%s): both blobFile and blobContent empty", blob.Sha256)
+			logrus.Errorf(err.Error())
+			return loadedBlobs, created, err
+		case blob.Path != "" && len(blob.Content) != 0:
+			err := fmt.Errorf("IngestBlob(%s): both blobFile and blobContent provided, cannot pick, %s",
+				blob.Sha256, blob.Path)
+			logrus.Errorf(err.Error())
+			return loadedBlobs, created, err
+		case blob.Path != "":
+			fileReader, err := os.Open(blob.Path)
+			if err != nil {
+				err = fmt.Errorf("IngestBlob(%s): could not open blob file for reading at %s: %+s",
+					blob.Sha256, blob.Path, err.Error())
+				logrus.Errorf(err.Error())
+				return loadedBlobs, created, err
+			}
+			defer fileReader.Close()
+			contentReader = fileReader
+		default:
+			contentReader = bytes.NewReader(blob.Content)
+		}
+
+		isNew, err := c.writeBlob(sha, contentReader)
+		if err != nil {
+			err = fmt.Errorf("IngestBlob(%s): could not load blob file into CAS at %s: %+s",
+				blob.Sha256, blob.Path, err.Error())
+			logrus.Errorf(err.Error())
+			return loadedBlobs, created, err
+		}
+		if isNew {
+			created = append(created, sha)
+		}
+		logrus.Infof("IngestBlob(%s): Loaded the blob successfully", blob.Sha256)
+		blob.State = types.LOADED
+		loadedBlobs = append(loadedBlobs, blob)
+	}
+	return loadedBlobs, created, nil
+}
+
+// writeBlob stores the content of the reader as blobHash, after checking
+// the hash. Returns false if the blob already existed.
+func (c *filesystemCAS) writeBlob(blobHash string, r io.Reader) (bool, error) {
+	if c.CheckBlobExists(blobHash) {
+		return false, nil
+	}
+	blobPath, err := c.blobPath(blobHash)
+	if err != nil {
+		return false, err
+	}
+	tmpfile, err := c.newIngestFile()
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmpfile.Name())
+	verifier := digest.Digest(blobHash).Verifier()
+	if _, err := io.Copy(io.MultiWriter(tmpfile, verifier), r); err != nil {
+		tmpfile.Close()
+		return false, err
+	}
+	if err := tmpfile.Sync(); err != nil {
+		tmpfile.Close()
+		return false, err
+	}
+	if err := tmpfile.Close(); err != nil {
+		return false, err
+	}
+	if !verifier.Verified() {
+		return false, fmt.Errorf("content does not match %s", blobHash)
+	}
+	if err := os.MkdirAll(filepath.Dir(blobPath), 0700); err != nil {
+		return false, err
+	}
+	if err := os.Rename(tmpfile.Name(), blobPath); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (c *filesystemCAS) newIngestFile() (*os.File, error) {
+	ingestDir := filepath.Join(c.root, fsIngestDir)
+	if err := os.MkdirAll(ingestDir, 0700); err != nil {
+		return nil, err
+	}
+	return ioutil.TempFile(ingestDir, "blob")
+}
+
+//UpdateBlobInfo updates BlobInfo of a blob in CAS.
+//Arg is BlobInfo type struct in which BlobInfo.Digest is mandatory, and other field are to be filled
+// only if its needed to be updated
+//The given labels are added to the existing ones. The size of a blob is
+// determined by its content hence it is not updated.
+//Returns error is no blob is found match blobInfo.Digest
+func (c *filesystemCAS) UpdateBlobInfo(blobInfo BlobInfo) error {
+	existingBlobInfo, err := c.GetBlobInfo(blobInfo.Digest)
+	if err != nil {
+		err = fmt.Errorf("UpdateBlobInfo: Exception while fetching existing blobInfo of %s: %s", blobInfo.Digest, err.Error())
+		logrus.Error(err.Error())
+		return err
+	}
+	if blobInfo.Labels == nil {
+		return nil
+	}
+	labels := existingBlobInfo.Labels
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+	for k, v := range blobInfo.Labels {
+		labels[k] = v
+	}
+	data, err := json.Marshal(labels)
+	if err != nil {
+		return fmt.Errorf("UpdateBlobInfo: Exception while updating blobInfo of %s: %s", blobInfo.Digest, err.Error())
+	}
+	labelsPath, _ := c.labelsPath(blobInfo.Digest)
+	if err := os.MkdirAll(filepath.Dir(labelsPath), 0700); err != nil {
+		return fmt.Errorf("UpdateBlobInfo: Exception while updating blobInfo of %s: %s", blobInfo.Digest, err.Error())
+	}
+	if err := fileutils.WriteRename(labelsPath, data); err != nil {
+		return fmt.Errorf("UpdateBlobInfo: Exception while updating blobInfo of %s: %s", blobInfo.Digest, err.Error())
+	}
+	return nil
+}
+
+// fsBlobReader closes the blob file once it has been read
+type fsBlobReader struct {
+	file *os.File
+}
+
+func (r *fsBlobReader) Read(p []byte) (int, error) {
+	n, err := r.file.Read(p)
+	if err != nil {
+		r.file.Close()
+	}
+	return n, err
+}
+
+//ReadBlob: returns a reader to consume the raw data of the blob which matches the given arg 'blobHash'.
+//Returns error if no blob is found for the given 'blobHash'.
+//Arg 'blobHash' should be of format sha256:<hash>.
+//The file is closed once read to the end, or else when garbage collected.
+func (c *filesystemCAS) ReadBlob(ctx context.Context, blobHash string) (io.Reader, error) {
+	blobPath, err := c.blobPath(blobHash)
+	if err != nil {
+		return nil, fmt.Errorf("ReadBlob: %v", err)
+	}
+	file, err := os.Open(blobPath)
+	if err != nil {
+		logrus.Errorf("ReadBlob: Exception while reading blob: %s. %s", blobHash, err.Error())
+		return nil, err
+	}
+	return &fsBlobReader{file: file}, nil
+}
+
+//RemoveBlob: removes a blob which matches the given arg 'blobHash'.
+//To keep this method idempotent, no error is returned if the given arg 'blobHash' does not match any blob.
+//Arg 'blobHash' should be of format sha256:<hash>.
+func (c *filesystemCAS) RemoveBlob(blobHash string) error {
+	blobPath, err := c.blobPath(blobHash)
+	if err != nil {
+		return fmt.Errorf("RemoveBlob: %v", err)
+	}
+	labelsPath, _ := c.labelsPath(blobHash)
+	for _, path := range []string{blobPath, labelsPath} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("RemoveBlob: Exception while removing blob: %s. %s", blobHash, err.Error())
+		}
+	}
+	return nil
+}
+
+//Children: returns a list of child blob hashes if the given arg 'blobHash' belongs to a
+// index or a manifest blob, else an empty list is returned.
+//Format of returned blob hash list and arg 'blobHash' is sha256:<hash>.
+func (c *filesystemCAS) Children(blobHash string) ([]string, error) {
+	if !c.CheckBlobExists(blobHash) {
+		return nil, fmt.Errorf("Children: blob %s not found", blobHash)
+	}
+	childBlobSha256 := make([]string, 0)
+	index, err := getIndexManifest(c, blobHash)
+	if err == nil && index.Manifests != nil {
+		for _, manifest := range index.Manifests {
+			childBlobSha256 = append(childBlobSha256, manifest.Digest.String())
+		}
+	} else {
+		manifest, err := getManifest(c, blobHash)
+		if err != nil {
+			return childBlobSha256, nil
+		}
+		childBlobSha256 = append(childBlobSha256, manifest.Config.Digest.String())
+		for _, layer := range manifest.Layers {
+			childBlobSha256 = append(childBlobSha256, layer.Digest.String())
+		}
+	}
+	return childBlobSha256, nil
+}
+
+func (c *filesystemCAS) readImage(reference string) (*fsImage, error) {
+	data, err := ioutil.ReadFile(c.imagePath(reference))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("image %s: %w", reference, errdefs.ErrNotFound)
+	} else if err != nil {
+		return nil, err
+	}
+	var image fsImage
+	if err := json.Unmarshal(data, &image); err != nil {
+		return nil, err
+	}
+	return &image, nil
+}
+
+func (c *filesystemCAS) writeImage(image *fsImage) error {
+	data, err := json.Marshal(image)
+	if err != nil {
+		return err
+	}
+	imagePath := c.imagePath(image.Name)
+	if err := os.MkdirAll(filepath.Dir(imagePath), 0700); err != nil {
+		return err
+	}
+	return fileutils.WriteRename(imagePath, data)
+}
+
+//CreateImage: creates a reference which points to a blob with 'blobHash'. 'blobHash' must belong to a index blob
+//Arg 'blobHash' should be of format sha256:<hash>.
+//Returns error if no blob is found matching the given 'blobHash' or if the given 'blobHash' does not belong to an index.
+func (c *filesystemCAS) CreateImage(reference, mediaType, blobHash string) error {
+	size, err := getBlobSize(c, blobHash)
+	if err != nil {
+		return fmt.Errorf("CreateImage: exception while parsing blob %s: %s", blobHash, err.Error())
+	}
+	if _, err := os.Stat(c.imagePath(reference)); err == nil {
+		return fmt.Errorf("CreateImage: Exception while creating reference: %s. %v",
+			reference, errdefs.ErrAlreadyExists)
+	}
+	image := fsImage{
+		Name: reference,
+		Target: spec.Descriptor{
+			MediaType: mediaType,
+			Digest:    digest.Digest(blobHash),
+			Size:      size,
+		},
+		CreatedAt: time.Now(),
+	}
+	if err := c.writeImage(&image); err != nil {
+		return fmt.Errorf("CreateImage: Exception while creating reference: %s. %s", reference, err.Error())
+	}
+	return nil
+}
+
+//GetImageHash: returns a blob hash of format sha256:<hash> which the given 'reference' is pointing to.
+// Returns error if the given 'reference' is not found.
+func (c *filesystemCAS) GetImageHash(reference string) (string, error) {
+	image, err := c.readImage(reference)
+	if err != nil {
+		return "", fmt.Errorf("GetImageHash: Exception while getting image: %s. %s", reference, err.Error())
+	}
+	return image.Target.Digest.String(), nil
+}
+
+//ListImages: returns a list of references
+func (c *filesystemCAS) ListImages() ([]string, error) {
+	files, err := readDir(filepath.Join(c.root, fsImagesDir))
+	if err != nil {
+		return nil, fmt.Errorf("ListImages: Exception while getting image list. %s", err.Error())
+	}
+	imageNameList := make([]string, 0)
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		reference, err := url.PathUnescape(strings.TrimSuffix(file.Name(), ".json"))
+		if err != nil {
+			logrus.Warnf("ListImages: ignoring %s: %v", file.Name(), err)
+			continue
+		}
+		imageNameList = append(imageNameList, reference)
+	}
+	return imageNameList, nil
+}
+
+//RemoveImage removes an reference from CAS
+//To keep this method idempotent, no error  is returned if the given 'reference' is not found.
+func (c *filesystemCAS) RemoveImage(reference string) error {
+	if err := os.Remove(c.imagePath(reference)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("RemoveImage: Exception while removing image. %s", err.Error())
+	}
+	return nil
+}
+
+//ReplaceImage: replaces the blob hash to which the given 'reference' is pointing to with the given 'blobHash'.
+//Returns error if the given 'reference' or a blob matching the given arg 'blobHash' is not found.
+//Returns if the given 'blobHash' does not belong to an index.
+//Arg 'blobHash' should be of format sha256:<hash>.
+func (c *filesystemCAS) ReplaceImage(reference, mediaType, blobHash string) error {
+	size, err := getBlobSize(c, blobHash)
+	if err != nil {
+		return fmt.Errorf("ReplaceImage: exception while parsing blob %s: %s", blobHash, err.Error())
+	}
+	image, err := c.readImage(reference)
+	if err != nil {
+		return fmt.Errorf("ReplaceImage: Exception while updating reference: %s. %s", reference, err.Error())
+	}
+	image.Target = spec.Descriptor{
+		MediaType: mediaType,
+		Digest:    digest.Digest(blobHash),
+		Size:      size,
+	}
+	image.UpdatedAt = time.Now()
+	if err := c.writeImage(image); err != nil {
+		return fmt.Errorf("ReplaceImage: Exception while updating reference: %s. %s", reference, err.Error())
+	}
+	return nil
+}
+
+// getImageLayers returns the digests of the layers of the image for the
+// current architecture, the lowest first
+func (c *filesystemCAS) getImageLayers(reference string) ([]string, error) {
+	manifest, err := c.getImageManifest(reference)
+	if err != nil {
+		return nil, err
+	}
+	layers := make([]string, 0, len(manifest.Layers))
+	for _, layer := range manifest.Layers {
+		layers = append(layers, layer.Digest.String())
+	}
+	return layers, nil
+}
+
+// getImageManifest returns the manifest of the image for this platform
+func (c *filesystemCAS) getImageManifest(reference string) (*v1.Manifest, error) {
+	imageHash, err := c.GetImageHash(reference)
+	if err != nil {
+		return nil, err
+	}
+	index, err := getIndexManifest(c, imageHash)
+	var manifestHash string
+	if err == nil && index.Manifests != nil {
+		manifestHash, err = getManifestBlobSha256FromIndex(index)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		manifestHash = imageHash
+	}
+	return getManifest(c, manifestHash)
+}
+
+// unpackLayer unpacks the layer blob unless already done. The whiteouts are
+// converted to the overlay format.
+func (c *filesystemCAS) unpackLayer(blobHash string) error {
+	layerPath, err := c.layerPath(blobHash)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(layerPath); err == nil {
+		return nil
+	}
+	blobPath, err := c.blobPath(blobHash)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(blobPath)
+	if err != nil {
+		return fmt.Errorf("missing layer %s: %v", blobHash, err)
+	}
+	defer file.Close()
+	stream, err := compression.DecompressStream(file)
+	if err != nil {
+		return fmt.Errorf("could not decompress layer %s: %v", blobHash, err)
+	}
+	defer stream.Close()
+
+	if err := os.MkdirAll(filepath.Dir(layerPath), 0700); err != nil {
+		return err
+	}
+	// Unpack next to the final location and rename when done
+	tmpDir, err := ioutil.TempDir(filepath.Dir(layerPath), "tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := archive.Apply(context.Background(), tmpDir, stream,
+		archive.WithConvertWhiteout(archive.OverlayConvertWhiteout)); err != nil {
+		os.RemoveAll(tmpDir)
+		return fmt.Errorf("could not unpack layer %s: %v", blobHash, err)
+	}
+	if err := os.Rename(tmpDir, layerPath); err != nil {
+		os.RemoveAll(tmpDir)
+		return err
+	}
+	return nil
+}
+
+func (c *filesystemCAS) readSnapshot(snapshotID string) (*fsSnapshot, error) {
+	data, err := ioutil.ReadFile(filepath.Join(c.snapshotPath(snapshotID), fsSnapshotInfo))
+	if err != nil {
+		return nil, err
+	}
+	var snapshot fsSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+//CreateSnapshotForImage: creates an snapshot with the given snapshotID for the given 'reference'
+//The snapshot is an overlay of the unpacked layers of the image with a
+// writable upper directory.
+func (c *filesystemCAS) CreateSnapshotForImage(snapshotID, reference string) error {
+	fsLock.Lock()
+	defer fsLock.Unlock()
+
+	snapshotPath := c.snapshotPath(snapshotID)
+	if _, err := os.Stat(snapshotPath); err == nil {
+		return fmt.Errorf("CreateSnapshotForImage: Exception while creating snapshot: %s. %v",
+			snapshotID, errdefs.ErrAlreadyExists)
+	}
+	layers, err := c.getImageLayers(reference)
+	if err != nil {
+		return fmt.Errorf("CreateSnapshotForImage: Exception while getting layers of %s. %s", reference, err.Error())
+	}
+	for _, layer := range layers {
+		if err := c.unpackLayer(layer); err != nil {
+			err = fmt.Errorf("CreateSnapshotForImage: could not unpack image %s: %s", reference, err.Error())
+			logrus.Errorf(err.Error())
+			return err
+		}
+	}
+	for _, dir := range []string{fsUpperDir, fsWorkDir} {
+		if err := os.MkdirAll(filepath.Join(snapshotPath, dir), 0755); err != nil {
+			os.RemoveAll(snapshotPath)
+			return fmt.Errorf("CreateSnapshotForImage: Exception while creating snapshot: %s. %s", snapshotID, err.Error())
+		}
+	}
+	data, err := json.Marshal(fsSnapshot{
+		Reference: reference,
+		Layers:    layers,
+		CreatedAt: time.Now(),
+	})
+	if err == nil {
+		err = fileutils.WriteRename(filepath.Join(snapshotPath, fsSnapshotInfo), data)
+	}
+	if err != nil {
+		os.RemoveAll(snapshotPath)
+		return fmt.Errorf("CreateSnapshotForImage: Exception while creating snapshot: %s. %s", snapshotID, err.Error())
+	}
+	return nil
+}
+
+//MountSnapshot: mounts the snapshot on the given target path
+func (c *filesystemCAS) MountSnapshot(snapshotID, targetPath string) error {
+	snapshot, err := c.readSnapshot(snapshotID)
+	if err != nil {
+		return fmt.Errorf("MountSnapshot: Exception while fetching mounts of snapshot: %s. %s", snapshotID, err)
+	}
+	snapshotPath := c.snapshotPath(snapshotID)
+	upperDir := filepath.Join(snapshotPath, fsUpperDir)
+	var m mount.Mount
+	if len(snapshot.Layers) == 0 {
+		m = mount.Mount{
+			Type:    "bind",
+			Source:  upperDir,
+			Options: []string{"rbind", "rw"},
+		}
+	} else {
+		// lowerdir lists the top most layer first
+		lowerDirs := make([]string, 0, len(snapshot.Layers))
+		for i := len(snapshot.Layers) - 1; i >= 0; i-- {
+			layerPath, err := c.layerPath(snapshot.Layers[i])
+			if err != nil {
+				return fmt.Errorf("MountSnapshot: %s. %v", snapshotID, err)
+			}
+			lowerDirs = append(lowerDirs, layerPath)
+		}
+		m = mount.Mount{
+			Type:   "overlay",
+			Source: "overlay",
+			Options: []string{
+				fmt.Sprintf("workdir=%s", filepath.Join(snapshotPath, fsWorkDir)),
+				fmt.Sprintf("upperdir=%s", upperDir),
+				fmt.Sprintf("lowerdir=%s", strings.Join(lowerDirs, ":")),
+			},
+		}
+	}
+	if err := os.MkdirAll(targetPath, 0766); err != nil {
+		return fmt.Errorf("MountSnapshot: Exception while creating targetPath dir. %v", err)
+	}
+	if err := m.Mount(targetPath); err != nil {
+		return fmt.Errorf("MountSnapshot: Exception while mounting snapshot: %s. %v", snapshotID, err)
+	}
+	return nil
+}
+
+//ListSnapshots: returns a list of snapshotIDs. The unpacked layers are not
+// snapshots of their own.
+func (c *filesystemCAS) ListSnapshots() ([]string, error) {
+	files, err := readDir(filepath.Join(c.root, fsSnapshotsDir))
+	if err != nil {
+		return nil, fmt.Errorf("ListSnapshots: unable to get snapshot info list: %s", err.Error())
+	}
+	snapshotIDList := make([]string, 0)
+	for _, file := range files {
+		snapshotID, err := url.PathUnescape(file.Name())
+		if err != nil {
+			logrus.Warnf("ListSnapshots: ignoring %s: %v", file.Name(), err)
+			continue
+		}
+		snapshotIDList = append(snapshotIDList, snapshotID)
+	}
+	return snapshotIDList, nil
+}
+
+//RemoveSnapshot: removes a snapshot matching the given 'snapshotID', and
+// the unpacked layers no longer used by any snapshot.
+//To keep this method idempotent, no error  is returned if the given 'snapshotID' is not found.
+func (c *filesystemCAS) RemoveSnapshot(snapshotID string) error {
+	fsLock.Lock()
+	defer fsLock.Unlock()
+
+	if err := os.RemoveAll(c.snapshotPath(snapshotID)); err != nil {
+		return fmt.Errorf("RemoveSnapshot: Exception while removing snapshot: %s. %s", snapshotID, err.Error())
+	}
+	c.pruneLayers()
+	return nil
+}
+
+// pruneLayers removes the unpacked layers which are not used by any
+// snapshot. Called with fsLock held.
+func (c *filesystemCAS) pruneLayers() {
+	snapshotIDs, err := c.ListSnapshots()
+	if err != nil {
+		logrus.Errorf("pruneLayers: %v", err)
+		return
+	}
+	used := make(map[string]bool)
+	for _, snapshotID := range snapshotIDs {
+		snapshot, err := c.readSnapshot(snapshotID)
+		if err != nil {
+			// Can't tell what it uses
+			logrus.Errorf("pruneLayers: snapshot %s: %v", snapshotID, err)
+			return
+		}
+		for _, layer := range snapshot.Layers {
+			used[layer] = true
+		}
+	}
+	layersDir := filepath.Join(c.root, fsLayersDir)
+	algos, err := ioutil.ReadDir(layersDir)
+	if err != nil {
+		logrus.Errorf("pruneLayers: %v", err)
+		return
+	}
+	for _, algo := range algos {
+		layers, err := ioutil.ReadDir(filepath.Join(layersDir, algo.Name()))
+		if err != nil {
+			logrus.Errorf("pruneLayers: %v", err)
+			continue
+		}
+		for _, layer := range layers {
+			if strings.HasPrefix(layer.Name(), "tmp") ||
+				used[algo.Name()+":"+layer.Name()] {
+				continue
+			}
+			logrus.Infof("pruneLayers: removing unused layer %s:%s",
+				algo.Name(), layer.Name())
+			if err := os.RemoveAll(filepath.Join(layersDir, algo.Name(), layer.Name())); err != nil {
+				logrus.Errorf("pruneLayers: %v", err)
+			}
+		}
+	}
+}
+
+// PrepareContainerRootDir prepares a writable snapshot from the reference. Before preparing container's root directory,
+// this API removes any existing state that may have accumulated (like existing snapshots being available, etc.)
+// This effectively voids any kind of caching, but on the flip side frees us
+// from cache invalidation. Additionally this API should deposit an OCI config json file and image name
+// next to the rootfs so that the effective structure becomes:
+//    rootPath/rootfs, rootPath/image-config.json
+// The rootPath is expected to end in a basename that becomes the snapshotID
+func (c *filesystemCAS) PrepareContainerRootDir(rootPath, reference, rootBlobSha string) error {
+	//Step 1: On device restart, the existing bundle is not deleted, we need to delete the
+	// existing bundle of the container and recreate it. This is safe to run even
+	// when bundle doesn't exist
+	if c.RemoveContainerRootDir(rootPath) != nil {
+		logrus.Warnf("PrepareContainerRootDir: tried to clean up any existing state, hopefully it worked")
+	}
+
+	//Step 2: create snapshot of the image so that it can be mounted as container's rootfs.
+	snapshotID := containerd.GetSnapshotID(rootPath)
+	if err := c.CreateSnapshotForImage(snapshotID, reference); err != nil {
+		err = fmt.Errorf("PrepareContainerRootDir: Could not create snapshot %s. %v", snapshotID, err)
+		logrus.Errorf(err.Error())
+		return err
+	}
+
+	//Step 3: write OCI image config/spec json under the container's rootPath.
+	return writeImageConfig(c, rootPath, reference)
+}
+
+// LazyPullAvailable is false; the layers are always unpacked from the blobs
+func (c *filesystemCAS) LazyPullAvailable() bool {
+	return false
+}
+
+// PrepareLazyContainerRootDir is not supported
+func (c *filesystemCAS) PrepareLazyContainerRootDir(rootPath, reference, imageRef string) error {
+	return fmt.Errorf("PrepareLazyContainerRootDir: lazy pulling of %s is not supported", reference)
+}
+
+// ImageLocalSize returns the size of the layers of the image, all of
+// which are on the device
+func (c *filesystemCAS) ImageLocalSize(reference string) (int64, int64, error) {
+	manifest, err := c.getImageManifest(reference)
+	if err != nil {
+		return 0, 0, fmt.Errorf("ImageLocalSize: Exception while getting layers of %s. %s", reference, err.Error())
+	}
+	var size int64
+	for _, layer := range manifest.Layers {
+		size += layer.Size
+	}
+	return size, size, nil
+}
+
+// UnmountContainerRootDir unmounts container's rootPath
+func (c *filesystemCAS) UnmountContainerRootDir(rootPath string) error {
+	if err := mount.Unmount(filepath.Join(rootPath, containerRootfsPath), 0); err != nil {
+		err = fmt.Errorf("UnmountContainerRootDir: exception while unmounting: %v/%v. %v",
+			rootPath, containerRootfsPath, err)
+		logrus.Error(err.Error())
+		return err
+	}
+	return nil
+}
+
+// RemoveContainerRootDir removes contents of a container's rootPath and snapshot.
+func (c *filesystemCAS) RemoveContainerRootDir(rootPath string) error {
+	//Step 1: Un-mount container's rootfs
+	if err := c.UnmountContainerRootDir(rootPath); err != nil {
+		err = fmt.Errorf("RemoveContainerRootDir: exception while unmounting: %v/%v. %v",
+			rootPath, containerRootfsPath, err)
+		logrus.Error(err.Error())
+		return err
+	}
+
+	//Step 2: Remove snapshot created for the image. Done before cleaning
+	// rootPath which may hold the snapshotID
+	snapshotID := containerd.GetSnapshotID(rootPath)
+	if err := c.RemoveSnapshot(snapshotID); err != nil {
+		err = fmt.Errorf("RemoveContainerRootDir: unable to remove snapshot: %v. %v", snapshotID, err)
+		logrus.Error(err.Error())
+		return err
+	}
+
+	//Step 3: Clean container rootPath
+	if err := os.RemoveAll(rootPath); err != nil {
+		err = fmt.Errorf("RemoveContainerRootDir: exception while deleting: %v. %v", rootPath, err)
+		logrus.Error(err.Error())
+		return err
+	}
+	return nil
+}
+
+// IngestBlobsAndCreateImage is a combination of IngestBlobs and CreateImage APIs.
+// We will assume that the first blob in the list will be the root blob for which the reference will be created.
+//
+// Returns an an error if the read blob's hash does not match with the respective BlobStatus.Sha256 or
+// if there is an exception while reading the blob data.
+//
+// In case of error the blobs which were added by this call are removed. There is no
+// implicit garbage collection thus the blobs which were already there are kept.
+func (c *filesystemCAS) IngestBlobsAndCreateImage(reference string, root types.BlobStatus, blobs ...types.BlobStatus) ([]types.BlobStatus, error) {
+
+	logrus.Infof("IngestBlobsAndCreateImage: Attempting to Ingest %d blobs and add reference: %s", len(blobs), reference)
+	loadedBlobs, created, err := c.ingestBlobs(context.Background(), blobs...)
+	if err == nil {
+		rootBlobSha := fmt.Sprintf("%s:%s", digest.SHA256, strings.ToLower(root.Sha256))
+		err = c.createOrReplaceImage(reference, root.MediaType, rootBlobSha)
+	}
+	if err != nil {
+		err = fmt.Errorf("IngestBlobsAndCreateImage: Exception while loading blobs into CAS: %v", err.Error())
+		logrus.Errorf(err.Error())
+		for _, blobHash := range created {
+			if err := c.RemoveBlob(blobHash); err != nil {
+				logrus.Errorf("IngestBlobsAndCreateImage: %v", err)
+			}
+		}
+		return nil, err
+	}
+	return loadedBlobs, nil
+}
+
+func (c *filesystemCAS) createOrReplaceImage(reference, mediaType, blobHash string) error {
+	imageHash, err := c.GetImageHash(reference)
+	if err != nil || imageHash == "" {
+		logrus.Infof("createOrReplaceImage: creating reference: %s for rootBlob %s", reference, blobHash)
+		return c.CreateImage(reference, mediaType, blobHash)
+	}
+	logrus.Infof("createOrReplaceImage: updating reference: %s for rootBlob %s", reference, blobHash)
+	return c.ReplaceImage(reference, mediaType, blobHash)
+}
+
+// Resolver get a resolver.ResolverCloser for the filesystem CAS
+func (c *filesystemCAS) Resolver(ctx context.Context) (resolver.ResolverCloser, error) {
+	return &fsResolver{cas: c, ctx: ctx}, nil
+}
+
+//CloseClient has nothing to close for the filesystem CAS
+func (c *filesystemCAS) CloseClient() error {
+	return nil
+}
+
+// CtrNewUserServicesCtx returns a plain cancellable context
+func (c *filesystemCAS) CtrNewUserServicesCtx() (context.Context, context.CancelFunc) {
+	return context.WithCancel(context.Background())
+}
+
+//newFilesystemCAS: constructor for filesystem CAS
+func newFilesystemCAS() CAS {
+	return newFilesystemCASAt(types.FilesystemCASDir)
+}
+
+// newFilesystemCASAt does not create anything under root; the directories
+// are created by the first write so that agents which only mount snapshots,
+// like domainmgr, can create the client before the vault is set up
+func newFilesystemCASAt(root string) *filesystemCAS {
+	return &filesystemCAS{root: root}
+}
+
+// readDir is ioutil.ReadDir returning nothing for a directory which was not
+// created yet
+func readDir(dir string) ([]os.FileInfo, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil && os.IsNotExist(err) {
+		return nil, nil
+	}
+	return files, err
+}
+
+// fsResolver resolves references to the images in the filesystem CAS.
+// Pushing a manifest or an index creates or updates the image.
+type fsResolver struct {
+	cas *filesystemCAS
+	ctx context.Context
+}
+
+func (r *fsResolver) Resolve(ctx context.Context, ref string) (string, spec.Descriptor, error) {
+	image, err := r.cas.readImage(ref)
+	if err != nil {
+		return "", spec.Descriptor{}, err
+	}
+	return ref, image.Target, nil
+}
+
+func (r *fsResolver) Fetcher(ctx context.Context, ref string) (remotes.Fetcher, error) {
+	return remotes.FetcherFunc(func(ctx context.Context, desc spec.Descriptor) (io.ReadCloser, error) {
+		blobPath, err := r.cas.blobPath(desc.Digest.String())
+		if err != nil {
+			return nil, err
+		}
+		file, err := os.Open(blobPath)
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("blob %s: %w", desc.Digest, errdefs.ErrNotFound)
+		}
+		return file, err
+	}), nil
+}
+
+func (r *fsResolver) Pusher(ctx context.Context, ref string) (remotes.Pusher, error) {
+	return remotes.PusherFunc(func(ctx context.Context, desc spec.Descriptor) (content.Writer, error) {
+		if r.cas.CheckBlobExists(desc.Digest.String()) {
+			return nil, fmt.Errorf("blob %s: %w", desc.Digest, errdefs.ErrAlreadyExists)
+		}
+		file, err := r.cas.newIngestFile()
+		if err != nil {
+			return nil, err
+		}
+		return &fsBlobWriter{
+			cas:      r.cas,
+			ref:      ref,
+			desc:     desc,
+			file:     file,
+			digester: digest.Canonical.Digester(),
+			started:  time.Now(),
+		}, nil
+	}), nil
+}
+
+func (r *fsResolver) Finalize(ctx context.Context) error {
+	return nil
+}
+
+func (r *fsResolver) Context() context.Context {
+	return r.ctx
+}
+
+// fsBlobWriter is a content.Writer for the filesystem CAS
+type fsBlobWriter struct {
+	cas       *filesystemCAS
+	ref       string
+	desc      spec.Descriptor
+	file      *os.File
+	digester  digest.Digester
+	offset    int64
+	started   time.Time
+	updated   time.Time
+	committed bool
+}
+
+func (w *fsBlobWriter) Write(p []byte) (int, error) {
+	n, err := w.file.Write(p)
+	w.digester.Hash().Write(p[:n])
+	w.offset += int64(n)
+	w.updated = time.Now()
+	return n, err
+}
+
+func (w *fsBlobWriter) Close() error {
+	err := w.file.Close()
+	if !w.committed {
+		os.Remove(w.file.Name())
+	}
+	return err
+}
+
+func (w *fsBlobWriter) Digest() digest.Digest {
+	return w.digester.Digest()
+}
+
+// Commit moves the blob in place and for a manifest or an index, creates or
+// updates the image. Commit always closes the writer.
+func (w *fsBlobWriter) Commit(ctx context.Context, size int64, expected digest.Digest, opts ...content.Opt) error {
+	defer w.Close()
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	if size > 0 && size != w.offset {
+		return fmt.Errorf("unexpected commit size %d, expected %d: %w",
+			w.offset, size, errdefs.ErrFailedPrecondition)
+	}
+	dgst := w.digester.Digest()
+	if expected != "" && expected != dgst {
+		return fmt.Errorf("unexpected commit digest %s, expected %s: %w",
+			dgst, expected, errdefs.ErrFailedPrecondition)
+	}
+	blobPath, err := w.cas.blobPath(dgst.String())
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(blobPath), 0700); err != nil {
+		return err
+	}
+	if err := os.Rename(w.file.Name(), blobPath); err != nil {
+		return err
+	}
+	w.committed = true
+	switch w.desc.MediaType {
+	case images.MediaTypeDockerSchema2Manifest, spec.MediaTypeImageManifest,
+		images.MediaTypeDockerSchema2ManifestList, spec.MediaTypeImageIndex:
+		return w.cas.createOrReplaceImage(w.ref, w.desc.MediaType, dgst.String())
+	}
+	return nil
+}
+
+func (w *fsBlobWriter) Status() (content.Status, error) {
+	return content.Status{
+		Ref:       w.ref,
+		Offset:    w.offset,
+		Total:     w.desc.Size,
+		Expected:  w.desc.Digest,
+		StartedAt: w.started,
+		UpdatedAt: w.updated,
+	}, nil
+}
+
+func (w *fsBlobWriter) Truncate(size int64) error {
+	if size != 0 {
+		return errdefs.ErrNotImplemented
+	}
+	if err := w.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	w.offset = 0
+	w.digester = digest.Canonical.Digester()
+	return nil
+}
@@ -0,0 +1,265 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cas
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lf-edge/eve/pkg/pillar/types"
+	"github.com/opencontainers/go-digest"
+	spec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+// testImage is a single layer OCI image
+type testImage struct {
+	layer, config, manifest []byte
+}
+
+func hashOf(data []byte) string {
+	return digest.FromBytes(data).String()
+}
+
+func blobStatusOf(data []byte, mediaType string) types.BlobStatus {
+	return types.BlobStatus{
+		Sha256:    strings.TrimPrefix(hashOf(data), "sha256:"),
+		Content:   data,
+		MediaType: mediaType,
+	}
+}
+
+func newTestImage(t *testing.T) testImage {
+	var layer bytes.Buffer
+	tw := tar.NewWriter(&layer)
+	content := []byte("hello\n")
+	if err := tw.WriteHeader(&tar.Header{
+		Name:     "hello.txt",
+		Mode:     0644,
+		Size:     int64(len(content)),
+		Typeflag: tar.TypeReg,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	config, err := json.Marshal(spec.Image{
+		Architecture: "amd64",
+		OS:           "linux",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := spec.Manifest{
+		Config: spec.Descriptor{
+			MediaType: spec.MediaTypeImageConfig,
+			Digest:    digest.FromBytes(config),
+			Size:      int64(len(config)),
+		},
+		Layers: []spec.Descriptor{{
+			MediaType: spec.MediaTypeImageLayer,
+			Digest:    digest.FromBytes(layer.Bytes()),
+			Size:      int64(layer.Len()),
+		}},
+	}
+	manifest.SchemaVersion = 2
+	manifestData, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testImage{
+		layer:    layer.Bytes(),
+		config:   config,
+		manifest: manifestData,
+	}
+}
+
+func newTestFilesystemCAS(t *testing.T) (*filesystemCAS, func()) {
+	root, err := ioutil.TempDir("", "fscas")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newFilesystemCASAt(root), func() { os.RemoveAll(root) }
+}
+
+func TestFilesystemCASEmpty(t *testing.T) {
+	c, cleanup := newTestFilesystemCAS(t)
+	defer cleanup()
+
+	// Nothing is created until the first write
+	files, err := ioutil.ReadDir(c.root)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(files))
+
+	infos, err := c.ListBlobInfo()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(infos))
+	images, err := c.ListImages()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(images))
+	snapshots, err := c.ListSnapshots()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(snapshots))
+}
+
+func TestFilesystemCASBlobs(t *testing.T) {
+	c, cleanup := newTestFilesystemCAS(t)
+	defer cleanup()
+
+	data := []byte("some content")
+	loaded, err := c.IngestBlob(nil, blobStatusOf(data, ""))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(loaded))
+	assert.Equal(t, types.LOADED, loaded[0].State)
+	assert.True(t, c.CheckBlobExists(hashOf(data)))
+
+	info, err := c.GetBlobInfo(hashOf(data))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(data)), info.Size)
+
+	err = c.UpdateBlobInfo(BlobInfo{Digest: hashOf(data),
+		Labels: map[string]string{"a": "b"}})
+	assert.NoError(t, err)
+	info, err = c.GetBlobInfo(hashOf(data))
+	assert.NoError(t, err)
+	assert.Equal(t, "b", info.Labels["a"])
+
+	reader, err := c.ReadBlob(nil, hashOf(data))
+	assert.NoError(t, err)
+	read, err := ioutil.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, data, read)
+
+	// The content does not match the hash
+	bad := blobStatusOf([]byte("other content"), "")
+	bad.Content = data
+	_, err = c.IngestBlob(nil, bad)
+	assert.Error(t, err)
+
+	infos, err := c.ListBlobInfo()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(infos))
+	assert.Equal(t, hashOf(data), infos[0].Digest)
+
+	assert.NoError(t, c.RemoveBlob(hashOf(data)))
+	assert.False(t, c.CheckBlobExists(hashOf(data)))
+	assert.NoError(t, c.RemoveBlob(hashOf(data)))
+}
+
+func TestFilesystemCASImages(t *testing.T) {
+	c, cleanup := newTestFilesystemCAS(t)
+	defer cleanup()
+
+	image := newTestImage(t)
+	reference := "docker.io/library/test:latest"
+	root := blobStatusOf(image.manifest, spec.MediaTypeImageManifest)
+	loaded, err := c.IngestBlobsAndCreateImage(reference, root, root,
+		blobStatusOf(image.config, spec.MediaTypeImageConfig),
+		blobStatusOf(image.layer, spec.MediaTypeImageLayer))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(loaded))
+
+	hash, err := c.GetImageHash(reference)
+	assert.NoError(t, err)
+	assert.Equal(t, hashOf(image.manifest), hash)
+	images, err := c.ListImages()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{reference}, images)
+
+	children, err := c.Children(hash)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{hashOf(image.config), hashOf(image.layer)}, children)
+
+	mediaTypes, err := c.ListBlobsMediaTypes()
+	assert.NoError(t, err)
+	assert.Equal(t, spec.MediaTypeImageManifest, mediaTypes[hashOf(image.manifest)])
+	assert.Equal(t, spec.MediaTypeImageLayer, mediaTypes[hashOf(image.layer)])
+
+	// The resolver serves the image
+	r, err := c.Resolver(context.Background())
+	assert.NoError(t, err)
+	_, desc, err := r.Resolve(context.Background(), reference)
+	assert.NoError(t, err)
+	assert.Equal(t, hashOf(image.manifest), desc.Digest.String())
+	fetcher, err := r.Fetcher(context.Background(), reference)
+	assert.NoError(t, err)
+	rc, err := fetcher.Fetch(context.Background(), desc)
+	assert.NoError(t, err)
+	read, err := ioutil.ReadAll(rc)
+	rc.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, image.manifest, read)
+
+	assert.NoError(t, c.RemoveImage(reference))
+	_, err = c.GetImageHash(reference)
+	assert.Error(t, err)
+}
+
+func TestFilesystemCASIngestRollback(t *testing.T) {
+	c, cleanup := newTestFilesystemCAS(t)
+	defer cleanup()
+
+	image := newTestImage(t)
+	// Already there before hence kept
+	_, err := c.IngestBlob(nil, blobStatusOf(image.config, ""))
+	assert.NoError(t, err)
+
+	bad := blobStatusOf(image.layer, spec.MediaTypeImageLayer)
+	bad.Content = []byte("corrupted")
+	root := blobStatusOf(image.manifest, spec.MediaTypeImageManifest)
+	_, err = c.IngestBlobsAndCreateImage("test", root, root,
+		blobStatusOf(image.config, spec.MediaTypeImageConfig), bad)
+	assert.Error(t, err)
+	assert.False(t, c.CheckBlobExists(hashOf(image.manifest)))
+	assert.True(t, c.CheckBlobExists(hashOf(image.config)))
+	images, err := c.ListImages()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(images))
+}
+
+func TestFilesystemCASSnapshots(t *testing.T) {
+	c, cleanup := newTestFilesystemCAS(t)
+	defer cleanup()
+
+	image := newTestImage(t)
+	reference := "test"
+	root := blobStatusOf(image.manifest, spec.MediaTypeImageManifest)
+	_, err := c.IngestBlobsAndCreateImage(reference, root, root,
+		blobStatusOf(image.config, spec.MediaTypeImageConfig),
+		blobStatusOf(image.layer, spec.MediaTypeImageLayer))
+	assert.NoError(t, err)
+
+	assert.NoError(t, c.CreateSnapshotForImage("snap1", reference))
+	assert.Error(t, c.CreateSnapshotForImage("snap1", reference))
+	assert.NoError(t, c.CreateSnapshotForImage("snap2", reference))
+	snapshots, err := c.ListSnapshots()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"snap1", "snap2"}, snapshots)
+
+	layerPath, err := c.layerPath(hashOf(image.layer))
+	assert.NoError(t, err)
+	content, err := ioutil.ReadFile(filepath.Join(layerPath, "hello.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello\n", string(content))
+
+	// The layer is kept until its last snapshot is removed
+	assert.NoError(t, c.RemoveSnapshot("snap1"))
+	_, err = os.Stat(layerPath)
+	assert.NoError(t, err)
+	assert.NoError(t, c.RemoveSnapshot("snap2"))
+	_, err = os.Stat(layerPath)
+	assert.True(t, os.IsNotExist(err))
+	assert.NoError(t, c.RemoveSnapshot("snap2"))
+}
@@ -0,0 +1,232 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package domainmgr
+
+// Commands run in, and files copied to and from, the containers of running
+// apps for troubleshooting (see types.AppExecConfig). Each request is
+// carried out in a goroutine of its own, with its time and output bounded,
+// and is logged together with its outcome for auditing.
+
+import (
+	"sync"
+	"time"
+
+	"github.com/lf-edge/eve/pkg/pillar/hypervisor"
+	"github.com/lf-edge/eve/pkg/pillar/types"
+)
+
+const (
+	// Bounds of AppExecConfig.TimeLimit, in seconds
+	defaultAppExecTimeLimit = 60
+	maxAppExecTimeLimit     = 600
+	// Bounds of AppExecConfig.MaxOutput, and of the Data copied in, in
+	// bytes. Each has to fit into a pubsub large item once encoded.
+	defaultAppExecMaxOutput = 64 * 1024
+	maxAppExecMaxOutput     = 128 * 1024
+)
+
+// appExecLimits returns the time limit and the output limit of the request
+func appExecLimits(config types.AppExecConfig) (time.Duration, int64) {
+	timeLimit := uint(defaultAppExecTimeLimit)
+	if config.TimeLimit != 0 {
+		timeLimit = config.TimeLimit
+	}
+	if timeLimit > maxAppExecTimeLimit {
+		timeLimit = maxAppExecTimeLimit
+	}
+	maxOutput := uint(defaultAppExecMaxOutput)
+	if config.MaxOutput != 0 {
+		maxOutput = config.MaxOutput
+	}
+	if maxOutput > maxAppExecMaxOutput {
+		maxOutput = maxAppExecMaxOutput
+	}
+	return time.Duration(timeLimit) * time.Second, int64(maxOutput)
+}
+
+// limitedBuffer keeps the first limit bytes written to it and drops the
+// rest, so that a process with a lot of output is not blocked or failed
+type limitedBuffer struct {
+	sync.Mutex
+	buf       []byte
+	limit     int64
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	room := b.limit - int64(len(b.buf))
+	if int64(len(p)) > room {
+		b.buf = append(b.buf, p[:room]...)
+		b.truncated = true
+	} else {
+		b.buf = append(b.buf, p...)
+	}
+	return len(p), nil
+}
+
+func (b *limitedBuffer) String() string {
+	b.Lock()
+	defer b.Unlock()
+	return string(b.buf)
+}
+
+func (b *limitedBuffer) Truncated() bool {
+	b.Lock()
+	defer b.Unlock()
+	return b.truncated
+}
+
+func handleAppExecCreate(ctxArg interface{}, key string,
+	configArg interface{}) {
+
+	ctx := ctxArg.(*domainContext)
+	config := configArg.(types.AppExecConfig)
+	log.Functionf("handleAppExecCreate(%s.%d)", config.Caller, config.Sequence)
+	startAppExec(ctx, config)
+}
+
+func handleAppExecModify(ctxArg interface{}, key string,
+	configArg interface{}, oldConfigArg interface{}) {
+
+	ctx := ctxArg.(*domainContext)
+	config := configArg.(types.AppExecConfig)
+	oldConfig := oldConfigArg.(types.AppExecConfig)
+	if config.Sequence == oldConfig.Sequence {
+		log.Functionf("handleAppExecModify(%s.%d) no change",
+			config.Caller, config.Sequence)
+		return
+	}
+	log.Functionf("handleAppExecModify(%s.%d)", config.Caller, config.Sequence)
+	startAppExec(ctx, config)
+}
+
+func handleAppExecDelete(ctxArg interface{}, key string,
+	configArg interface{}) {
+
+	ctx := ctxArg.(*domainContext)
+	config := configArg.(types.AppExecConfig)
+	log.Functionf("handleAppExecDelete(%s.%d)", config.Caller, config.Sequence)
+	if st, _ := ctx.pubAppExecStatus.Get(key); st != nil {
+		ctx.pubAppExecStatus.Unpublish(key)
+	}
+}
+
+// startAppExec audits the request and carries it out in the background
+func startAppExec(ctx *domainContext, config types.AppExecConfig) {
+	switch config.Kind {
+	case types.AppExecCommand:
+		log.Noticef("appExec(%s.%d): %s %v in app %s container %q",
+			config.Caller, config.Sequence, config.Kind, config.Args,
+			config.AppUUID, config.Container)
+	case types.AppExecCopyIn:
+		log.Noticef("appExec(%s.%d): %s %d bytes to %s in app %s container %q",
+			config.Caller, config.Sequence, config.Kind, len(config.Data),
+			config.Path, config.AppUUID, config.Container)
+	default:
+		log.Noticef("appExec(%s.%d): %s %s in app %s container %q",
+			config.Caller, config.Sequence, config.Kind, config.Path,
+			config.AppUUID, config.Container)
+	}
+	go func() {
+		status := runAppExec(ctx, config)
+		if status.Error != "" {
+			log.Noticef("appExec(%s.%d): failed: %s",
+				config.Caller, config.Sequence, status.Error)
+		} else {
+			log.Noticef("appExec(%s.%d): done exit %d timed out %t truncated %t output %d/%d/%d bytes",
+				config.Caller, config.Sequence, status.ExitValue,
+				status.TimedOut, status.Truncated, len(status.Stdout),
+				len(status.Stderr), len(status.Data))
+		}
+		publishAppExecStatus(ctx, config, status)
+	}()
+}
+
+// publishAppExecStatus publishes the result unless the request was deleted
+// or replaced while it was carried out
+func publishAppExecStatus(ctx *domainContext, config types.AppExecConfig,
+	status types.AppExecStatus) {
+
+	c, _ := ctx.subTmpAppExecConfig.Get(config.Key())
+	if c == nil || c.(types.AppExecConfig).Sequence != config.Sequence {
+		log.Functionf("publishAppExecStatus(%s.%d) request is gone",
+			config.Caller, config.Sequence)
+		return
+	}
+	if err := ctx.pubAppExecStatus.CheckMaxSize(status.Key(), status); err != nil {
+		log.Errorf("publishAppExecStatus(%s.%d): %v",
+			config.Caller, config.Sequence, err)
+		status.Stdout = ""
+		status.Stderr = ""
+		status.Data = nil
+		status.Truncated = true
+		status.Error = err.Error()
+	}
+	ctx.pubAppExecStatus.Publish(status.Key(), status)
+}
+
+// runAppExec carries out the request
+func runAppExec(ctx *domainContext, config types.AppExecConfig) types.AppExecStatus {
+	status := types.AppExecStatus{
+		Caller:    config.Caller,
+		Sequence:  config.Sequence,
+		AppUUID:   config.AppUUID,
+		Container: config.Container,
+		Kind:      config.Kind,
+		Time:      time.Now(),
+	}
+	ds := lookupDomainStatus(ctx, config.AppUUID.String())
+	if ds == nil || !ds.Activated {
+		status.Error = "app is not running"
+		return status
+	}
+	if !isNativeContainer(hyper, *ds) {
+		status.Error = "app does not run as a container"
+		return status
+	}
+	execer, ok := hyper.Task(ds).(hypervisor.ContainerExecer)
+	if !ok {
+		status.Error = "not supported by the hypervisor"
+		return status
+	}
+	if config.Kind != types.AppExecCommand && config.Path == "" {
+		status.Error = "no path"
+		return status
+	}
+	timeLimit, maxOutput := appExecLimits(config)
+	var err error
+	switch config.Kind {
+	case types.AppExecCommand:
+		if len(config.Args) == 0 {
+			status.Error = "no command"
+			return status
+		}
+		stdout := &limitedBuffer{limit: maxOutput}
+		stderr := &limitedBuffer{limit: maxOutput}
+		status.ExitValue, status.TimedOut, err = execer.ContainerExec(
+			ds.DomainName, config.Container, config.Args, stdout, stderr, timeLimit)
+		status.Stdout = stdout.String()
+		status.Stderr = stderr.String()
+		status.Truncated = stdout.Truncated() || stderr.Truncated()
+	case types.AppExecCopyIn:
+		if int64(len(config.Data)) > maxAppExecMaxOutput {
+			status.Error = "too much data"
+			return status
+		}
+		err = execer.ContainerWriteFile(ds.DomainName, config.Container,
+			config.Path, config.Data)
+	case types.AppExecCopyOut:
+		status.Data, status.Truncated, err = execer.ContainerReadFile(
+			ds.DomainName, config.Container, config.Path, maxOutput)
+	default:
+		status.Error = config.Kind.String()
+		return status
+	}
+	if err != nil {
+		status.Error = err.Error()
+	}
+	return status
+}
@@ -0,0 +1,44 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package domainmgr
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lf-edge/eve/pkg/pillar/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAppExecLimits(t *testing.T) {
+	timeLimit, maxOutput := appExecLimits(types.AppExecConfig{})
+	assert.Equal(t, defaultAppExecTimeLimit*time.Second, timeLimit)
+	assert.Equal(t, int64(defaultAppExecMaxOutput), maxOutput)
+
+	timeLimit, maxOutput = appExecLimits(types.AppExecConfig{TimeLimit: 5, MaxOutput: 100})
+	assert.Equal(t, 5*time.Second, timeLimit)
+	assert.Equal(t, int64(100), maxOutput)
+
+	timeLimit, maxOutput = appExecLimits(types.AppExecConfig{TimeLimit: 3600, MaxOutput: 1 << 30})
+	assert.Equal(t, maxAppExecTimeLimit*time.Second, timeLimit)
+	assert.Equal(t, int64(maxAppExecMaxOutput), maxOutput)
+}
+
+func TestLimitedBuffer(t *testing.T) {
+	b := &limitedBuffer{limit: 8}
+	n, err := b.Write([]byte("hello"))
+	assert.NoError(t, err)
+	assert.Equal(t, 5, n)
+	assert.False(t, b.Truncated())
+
+	// the rest is dropped but reported as written
+	n, err = b.Write([]byte(" world"))
+	assert.NoError(t, err)
+	assert.Equal(t, 6, n)
+	assert.True(t, b.Truncated())
+	n, err = b.Write([]byte("!"))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, "hello wo", b.String())
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package domainmgr
+
+// Memory ballooning. A domain with a MaxMem above its Memory is started
+// with a target of Memory, and is grown towards MaxMem while the host has
+// plenty of free memory. When the watcher reports the host memory usage
+// getting high the domains are shrunk back towards Memory. zedmanager sets
+// aside MaxMem for them hence growing them can't starve other domains.
+
+import (
+	"github.com/lf-edge/eve/pkg/pillar/hypervisor"
+	"github.com/lf-edge/eve/pkg/pillar/types"
+)
+
+// balloonSteps is the number of steps to go from Memory to MaxMem
+const balloonSteps = 4
+
+// computeBalloonTarget returns the new target in kbytes given the host
+// memory usage zone
+func computeBalloonTarget(zone types.UsageZone, current, min, max int) int {
+	step := (max - min) / balloonSteps
+	if step == 0 {
+		step = max - min
+	}
+	target := current
+	switch zone {
+	case types.GreenZone:
+		target += step
+	case types.YellowZone:
+		// Leave it where it is
+	case types.OrangeZone:
+		target -= step
+	default:
+		// Give it all back right away
+		target = min
+	}
+	if target > max {
+		target = max
+	}
+	if target < min {
+		target = min
+	}
+	return target
+}
+
+func memoryResizer(status *types.DomainStatus) hypervisor.MemoryResizer {
+	resizer, ok := hyper.Task(status).(hypervisor.MemoryResizer)
+	if !ok {
+		return nil
+	}
+	return resizer
+}
+
+// setMemoryTarget asks the hypervisor to balloon the domain to target kbytes
+func setMemoryTarget(status *types.DomainStatus, target int) bool {
+	resizer := memoryResizer(status)
+	if resizer == nil {
+		return false
+	}
+	if err := resizer.SetMemoryTarget(status.DomainName, status.DomainId,
+		target); err != nil {
+		log.Errorf("setMemoryTarget(%s) to %d kbytes failed: %v",
+			status.Key(), target, err)
+		return false
+	}
+	log.Noticef("setMemoryTarget(%s) from %d to %d kbytes",
+		status.Key(), status.MemoryTarget, target)
+	status.MemoryTarget = target
+	return true
+}
+
+// startBalloon is called once the domain is running to set its initial
+// target to Memory
+func startBalloon(ctx *domainContext, config types.DomainConfig,
+	status *types.DomainStatus) {
+
+	status.MemoryTarget = 0
+	if !config.Ballooning() {
+		return
+	}
+	setMemoryTarget(status, config.Memory)
+}
+
+// maybeBalloon is called periodically to apply the memory-pressure policy
+// to a running domain
+func maybeBalloon(ctx *domainContext, status *types.DomainStatus) {
+	if !status.Activated || status.MemoryTarget == 0 {
+		return
+	}
+	config := lookupDomainConfig(ctx, status.Key())
+	if config == nil || !config.Ballooning() {
+		return
+	}
+	m, err := ctx.subMemoryNotification.Get("global")
+	if err != nil || m == nil {
+		return
+	}
+	memNotif := m.(types.MemoryNotification)
+	if memNotif.Total == 0 {
+		return
+	}
+	target := computeBalloonTarget(memNotif.Zone, status.MemoryTarget,
+		config.Memory, config.MaxMem)
+	if target == status.MemoryTarget {
+		return
+	}
+	if setMemoryTarget(status, target) {
+		publishDomainStatus(ctx, status)
+	}
+}
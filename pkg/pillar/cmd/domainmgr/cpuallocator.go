@@ -0,0 +1,289 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package domainmgr
+
+// CPU allocator. When cpu.pinning.enable is set each domain gets one host
+// CPU per vCPU for itself. The first cpu.eve.reserved CPUs are kept for
+// EVE. The CPUs are preferably taken from the NUMA node of the PCI devices
+// assigned to the domain, then from any single node which has enough free
+// CPUs, and otherwise from all nodes.
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/lf-edge/eve/pkg/pillar/types"
+	"github.com/lf-edge/eve/pkg/pillar/utils"
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	sysfsCPUOnline  = "/sys/devices/system/cpu/online"
+	sysfsNodeGlob   = "/sys/devices/system/node/node[0-9]*"
+	sysfsPciNumaFmt = "/sys/bus/pci/devices/%s/numa_node"
+)
+
+// cpuTopology is the set of host CPUs and the NUMA node of each
+type cpuTopology struct {
+	cpus   []int
+	nodeOf map[int]int
+}
+
+// cpuAllocator tracks which host CPUs are assigned to which domain. It is
+// called from the goroutines of the different domains.
+type cpuAllocator struct {
+	sync.Mutex
+	topology cpuTopology
+	reserved int
+	assigned map[int]uuid.UUID
+}
+
+func newCPUAllocator(topology cpuTopology) *cpuAllocator {
+	return &cpuAllocator{
+		topology: topology,
+		reserved: 1,
+		assigned: make(map[int]uuid.UUID),
+	}
+}
+
+// setReserved changes the number of CPUs kept for EVE. CPUs which are
+// already assigned stay so until released.
+func (a *cpuAllocator) setReserved(reserved int) {
+	a.Lock()
+	defer a.Unlock()
+	a.reserved = reserved
+}
+
+// allocate assigns count CPUs to the app instance, preferably from node
+// (if not negative). Anything previously assigned to it is released first.
+func (a *cpuAllocator) allocate(appUUID uuid.UUID, count int, node int) ([]int, error) {
+	a.Lock()
+	defer a.Unlock()
+	a.releaseLocked(appUUID)
+
+	freeByNode := make(map[int][]int)
+	var free []int
+	for i, cpu := range a.topology.cpus {
+		if i < a.reserved {
+			continue
+		}
+		if _, ok := a.assigned[cpu]; ok {
+			continue
+		}
+		free = append(free, cpu)
+		n := a.topology.nodeOf[cpu]
+		freeByNode[n] = append(freeByNode[n], cpu)
+	}
+	if count > len(free) {
+		return nil, fmt.Errorf("no %d free CPUs for %s; %d free",
+			count, appUUID, len(free))
+	}
+	var picked []int
+	if node >= 0 && len(freeByNode[node]) >= count {
+		picked = freeByNode[node][:count]
+	} else {
+		// Any single node; the one with the fewest free CPUs
+		// which is enough to keep the larger ones for later
+		best := -1
+		for n, cpus := range freeByNode {
+			if len(cpus) < count {
+				continue
+			}
+			if best == -1 || len(cpus) < len(freeByNode[best]) ||
+				(len(cpus) == len(freeByNode[best]) && n < best) {
+				best = n
+			}
+		}
+		if best != -1 {
+			picked = freeByNode[best][:count]
+		} else {
+			picked = free[:count]
+		}
+	}
+	for _, cpu := range picked {
+		a.assigned[cpu] = appUUID
+	}
+	return picked, nil
+}
+
+// assign marks the CPUs as assigned to the app instance, as they were by
+// an earlier run of domainmgr. CPUs which are not known or are already
+// assigned to another app instance are left alone.
+func (a *cpuAllocator) assign(appUUID uuid.UUID, cpus []int) error {
+	a.Lock()
+	defer a.Unlock()
+	known := make(map[int]bool)
+	for _, cpu := range a.topology.cpus {
+		known[cpu] = true
+	}
+	var skipped []int
+	for _, cpu := range cpus {
+		if u, ok := a.assigned[cpu]; !known[cpu] ||
+			(ok && !uuid.Equal(u, appUUID)) {
+			skipped = append(skipped, cpu)
+			continue
+		}
+		a.assigned[cpu] = appUUID
+	}
+	if len(skipped) != 0 {
+		return fmt.Errorf("CPUs %v of %s unknown or assigned to another app instance",
+			skipped, appUUID)
+	}
+	return nil
+}
+
+// release returns the CPUs of the app instance
+func (a *cpuAllocator) release(appUUID uuid.UUID) {
+	a.Lock()
+	defer a.Unlock()
+	a.releaseLocked(appUUID)
+}
+
+func (a *cpuAllocator) releaseLocked(appUUID uuid.UUID) {
+	for cpu, u := range a.assigned {
+		if uuid.Equal(u, appUUID) {
+			delete(a.assigned, cpu)
+		}
+	}
+}
+
+// getCPUTopology reads the host CPUs and NUMA nodes from sysfs. With Xen
+// dom0 does not see the physical CPUs hence we use the count from the
+// hypervisor and a single node.
+func getCPUTopology() cpuTopology {
+	topology := cpuTopology{nodeOf: make(map[int]int)}
+	if hyper.Name() == "xen" {
+		hm, err := hyper.GetHostCPUMem()
+		if err != nil {
+			log.Errorf("getCPUTopology: %v", err)
+		}
+		for cpu := 0; cpu < int(hm.Ncpus); cpu++ {
+			topology.cpus = append(topology.cpus, cpu)
+		}
+		return topology
+	}
+	content, err := ioutil.ReadFile(sysfsCPUOnline)
+	if err != nil {
+		log.Errorf("getCPUTopology: %v", err)
+		return topology
+	}
+	topology.cpus, err = utils.ParseCPUList(string(content))
+	if err != nil {
+		log.Errorf("getCPUTopology: %v", err)
+		return topology
+	}
+	nodes, _ := filepath.Glob(sysfsNodeGlob)
+	for _, nodeDir := range nodes {
+		node, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(nodeDir), "node"))
+		if err != nil {
+			continue
+		}
+		content, err := ioutil.ReadFile(filepath.Join(nodeDir, "cpulist"))
+		if err != nil {
+			continue
+		}
+		cpus, err := utils.ParseCPUList(string(content))
+		if err != nil {
+			log.Errorf("getCPUTopology: %v", err)
+			continue
+		}
+		for _, cpu := range cpus {
+			topology.nodeOf[cpu] = node
+		}
+	}
+	sort.Ints(topology.cpus)
+	return topology
+}
+
+// pciNumaNode returns the NUMA node of the PCI device, or -1 if unknown
+func pciNumaNode(pciLong string) int {
+	content, err := ioutil.ReadFile(fmt.Sprintf(sysfsPciNumaFmt, pciLong))
+	if err != nil {
+		return -1
+	}
+	node, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		return -1
+	}
+	return node
+}
+
+// preferredNumaNode returns the NUMA node of the first assigned PCI device
+// which has one, or -1
+func preferredNumaNode(ctx *domainContext, config types.DomainConfig) int {
+	for _, adapter := range config.IoAdapterList {
+		for _, ib := range ctx.assignableAdapters.LookupIoBundleAny(adapter.Name) {
+			if ib == nil || ib.PciLong == "" {
+				continue
+			}
+			if node := pciNumaNode(ib.PciLong); node >= 0 {
+				return node
+			}
+		}
+	}
+	return -1
+}
+
+// allocateCPUs picks the CPUs for the domain unless pinning is disabled or
+// the CPUs are specified in the config. Returns the list to use as
+// VmConfig.CPUs.
+func allocateCPUs(ctx *domainContext, config types.DomainConfig,
+	status *types.DomainStatus) (string, error) {
+
+	if !ctx.cpuPinning || config.CPUs != "" ||
+		config.VirtualizationMode == types.NOHYPER {
+		return config.CPUs, nil
+	}
+	count := config.VCpus
+	if count == 0 {
+		count = 1
+	}
+	node := preferredNumaNode(ctx, config)
+	cpus, err := ctx.cpuAllocator.allocate(config.UUIDandVersion.UUID,
+		count, node)
+	if err != nil {
+		return "", err
+	}
+	status.CPUs = utils.FormatCPUList(cpus)
+	log.Noticef("allocateCPUs(%s) got %s node %d", status.Key(),
+		status.CPUs, node)
+	return status.CPUs, nil
+}
+
+// seedCPUAllocator assigns the CPUs of the domains which domainmgr
+// allocated before it was restarted, which are still in the DomainStatus
+func seedCPUAllocator(ctx *domainContext) {
+	for _, st := range ctx.pubDomainStatus.GetAll() {
+		status := st.(types.DomainStatus)
+		if status.CPUs == "" {
+			continue
+		}
+		cpus, err := utils.ParseCPUList(status.CPUs)
+		if err != nil {
+			log.Errorf("seedCPUAllocator(%s): %v", status.Key(), err)
+			continue
+		}
+		if err := ctx.cpuAllocator.assign(status.UUIDandVersion.UUID,
+			cpus); err != nil {
+			log.Errorf("seedCPUAllocator(%s): %v", status.Key(), err)
+			continue
+		}
+		log.Noticef("seedCPUAllocator(%s) has %s", status.Key(), status.CPUs)
+	}
+}
+
+// releaseCPUs returns the CPUs allocated to the domain if any
+func releaseCPUs(ctx *domainContext, status *types.DomainStatus) {
+	if status.CPUs == "" {
+		return
+	}
+	log.Functionf("releaseCPUs(%s) %s", status.Key(), status.CPUs)
+	ctx.cpuAllocator.release(status.UUIDandVersion.UUID)
+	status.CPUs = ""
+}
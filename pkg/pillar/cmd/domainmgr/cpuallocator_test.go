@@ -0,0 +1,88 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package domainmgr
+
+import (
+	"testing"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// twoNodes has CPUs 0-3 on node 0 and 4-7 on node 1
+func twoNodes() cpuTopology {
+	topology := cpuTopology{nodeOf: make(map[int]int)}
+	for cpu := 0; cpu < 8; cpu++ {
+		topology.cpus = append(topology.cpus, cpu)
+		topology.nodeOf[cpu] = cpu / 4
+	}
+	return topology
+}
+
+func TestCPUAllocator(t *testing.T) {
+	a := newCPUAllocator(twoNodes())
+	app1 := uuid.NewV4()
+	app2 := uuid.NewV4()
+	app3 := uuid.NewV4()
+
+	// CPU 0 is reserved; node 0 has fewer free CPUs hence is picked
+	cpus, err := a.allocate(app1, 2, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2}, cpus)
+
+	// The node of the PCI device
+	cpus, err = a.allocate(app2, 2, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{4, 5}, cpus)
+
+	// Reallocating releases what the app had first; now node 1 has
+	// the fewest free CPUs
+	cpus, err = a.allocate(app1, 2, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{6, 7}, cpus)
+
+	cpus, err = a.allocate(app3, 3, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 3}, cpus)
+
+	_, err = a.allocate(uuid.NewV4(), 1, -1)
+	assert.Error(t, err)
+
+	a.release(app3)
+	a.release(app1)
+	// Does not fit in a single node
+	cpus, err = a.allocate(app1, 5, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 3, 6, 7}, cpus)
+
+	a.release(app2)
+	a.setReserved(6)
+	_, err = a.allocate(uuid.NewV4(), 1, -1)
+	assert.Error(t, err)
+	a.setReserved(4)
+	cpus, err = a.allocate(uuid.NewV4(), 2, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{4, 5}, cpus)
+}
+
+func TestCPUAllocatorAssign(t *testing.T) {
+	a := newCPUAllocator(twoNodes())
+	app1 := uuid.NewV4()
+	app2 := uuid.NewV4()
+
+	// What a running domain had before a restart of domainmgr
+	assert.NoError(t, a.assign(app1, []int{1, 2}))
+	assert.NoError(t, a.assign(app1, []int{1, 2}))
+	// Only CPU 3 is left in node 0
+	cpus, err := a.allocate(app2, 2, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{4, 5}, cpus)
+
+	// Taken by another one or unknown
+	assert.Error(t, a.assign(uuid.NewV4(), []int{3, 5}))
+	assert.Error(t, a.assign(uuid.NewV4(), []int{8}))
+	cpus, err = a.allocate(uuid.NewV4(), 2, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{6, 7}, cpus)
+}
@@ -0,0 +1,149 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package domainmgr
+
+// Crash dumps. KVM domains have a pvpanic device, except on the virt
+// machine of ARM where crash dumps are not available. When the guest kernel
+// panics QEMU leaves the domain paused and verifyStatus finds it BROKEN;
+// before it is deleted the memory of the guest is saved to AppCrashDumpDir
+// and published in DomainStatus.CrashDump. The space taken by the dumps is
+// capped by app.crashdump.max.size by removing the oldest ones, which are
+// then cleared from the DomainStatus of the other domains.
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/lf-edge/eve/pkg/pillar/hypervisor"
+	"github.com/lf-edge/eve/pkg/pillar/types"
+)
+
+type crashDumpFile struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+// maybeSaveCrashDump saves the memory of a domain whose guest kernel
+// panicked. Returns true if the guest panicked, whether or not the dump
+// could be saved.
+func maybeSaveCrashDump(ctx *domainContext, status *types.DomainStatus) bool {
+	dumper, ok := hyper.Task(status).(hypervisor.CrashDumper)
+	if !ok || !dumper.Panicked(status.DomainName) {
+		return false
+	}
+	log.Warnf("maybeSaveCrashDump(%s): guest kernel panicked", status.Key())
+	maxSize := int64(ctx.crashDumpMaxSize) << 20
+	if maxSize == 0 {
+		log.Noticef("maybeSaveCrashDump(%s): crash dumps disabled", status.Key())
+		return true
+	}
+	if err := os.MkdirAll(types.AppCrashDumpDir, 0700); err != nil {
+		log.Errorf("maybeSaveCrashDump(%s): %v", status.Key(), err)
+		return true
+	}
+	// Make room for a dump as large as the memory of the domain
+	reserve := maxSize
+	if config := lookupDomainConfig(ctx, status.Key()); config != nil &&
+		int64(config.Memory)<<10 < maxSize {
+		reserve = int64(config.Memory) << 10
+	}
+	pruneCrashDumps(ctx, status.Key(), maxSize-reserve)
+
+	file := filepath.Join(types.AppCrashDumpDir,
+		fmt.Sprintf("%s-%d.kdump", status.Key(), time.Now().Unix()))
+	log.Noticef("maybeSaveCrashDump(%s): saving memory to %s", status.Key(), file)
+	if err := dumper.DumpGuestMemory(status.DomainName, file); err != nil {
+		log.Errorf("maybeSaveCrashDump(%s): %v", status.Key(), err)
+		os.Remove(file)
+		return true
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		log.Errorf("maybeSaveCrashDump(%s): %v", status.Key(), err)
+		return true
+	}
+	if info.Size() > maxSize {
+		log.Errorf("maybeSaveCrashDump(%s): dump of %d bytes exceeds %s",
+			status.Key(), info.Size(), types.AppCrashDumpMaxSize)
+		os.Remove(file)
+		return true
+	}
+	pruneCrashDumps(ctx, status.Key(), maxSize)
+	status.CrashDump = types.CrashDump{
+		FileLocation: file,
+		Size:         info.Size(),
+		Time:         info.ModTime(),
+	}
+	log.Noticef("maybeSaveCrashDump(%s): saved %d bytes to %s",
+		status.Key(), info.Size(), file)
+	return true
+}
+
+// pruneCrashDumps removes the oldest dumps until they take at most limit
+// bytes. The DomainStatus which reported a removed dump is republished
+// without it, except for the one of key which the caller publishes.
+func pruneCrashDumps(ctx *domainContext, key string, limit int64) {
+	entries, err := ioutil.ReadDir(types.AppCrashDumpDir)
+	if err != nil {
+		log.Errorf("pruneCrashDumps: %v", err)
+		return
+	}
+	var dumps []crashDumpFile
+	for _, entry := range entries {
+		if entry.Mode().IsRegular() {
+			dumps = append(dumps, crashDumpFile{
+				name:    entry.Name(),
+				size:    entry.Size(),
+				modTime: entry.ModTime(),
+			})
+		}
+	}
+	evicted := make(map[string]bool)
+	for _, name := range crashDumpsToEvict(dumps, limit) {
+		log.Noticef("pruneCrashDumps: removing %s", name)
+		file := filepath.Join(types.AppCrashDumpDir, name)
+		if err := os.Remove(file); err != nil {
+			log.Errorf("pruneCrashDumps: %v", err)
+			continue
+		}
+		evicted[file] = true
+	}
+	if len(evicted) == 0 {
+		return
+	}
+	for _, st := range ctx.pubDomainStatus.GetAll() {
+		status := st.(types.DomainStatus)
+		if status.Key() == key || !evicted[status.CrashDump.FileLocation] {
+			continue
+		}
+		status.CrashDump = types.CrashDump{}
+		publishDomainStatus(ctx, &status)
+	}
+}
+
+// crashDumpsToEvict returns the names of the oldest dumps to remove so
+// that the rest takes at most limit bytes
+func crashDumpsToEvict(dumps []crashDumpFile, limit int64) []string {
+	sort.Slice(dumps, func(i, j int) bool {
+		return dumps[i].modTime.Before(dumps[j].modTime)
+	})
+	var total int64
+	for _, dump := range dumps {
+		total += dump.size
+	}
+	var evict []string
+	for _, dump := range dumps {
+		if total <= limit {
+			break
+		}
+		evict = append(evict, dump.name)
+		total -= dump.size
+	}
+	return evict
+}
@@ -50,7 +50,6 @@ const (
 	errorTime           = 3 * time.Minute
 	warningTime         = 40 * time.Second
 	containerRootfsPath = "rootfs/"
-	casClientType       = "containerd"
 )
 
 // Really a constant
@@ -82,7 +81,11 @@ type domainContext struct {
 	subGlobalConfig        pubsub.Subscription
 	pubAssignableAdapters  pubsub.Publication
 	pubDomainMetric        pubsub.Publication
+	pubAppContainerMetrics pubsub.Publication
+	subTmpAppExecConfig    pubsub.Subscription
+	pubAppExecStatus       pubsub.Publication
 	pubHostMemory          pubsub.Publication
+	subMemoryNotification  pubsub.Subscription
 	pubProcessMetric       pubsub.Publication
 	pubCipherBlockStatus   pubsub.Publication
 	usbAccess              bool
@@ -99,6 +102,17 @@ type domainContext struct {
 
 	// From global config setting
 	processCloudInitMultiPart bool
+	cpuPinning                bool
+	hotplugSlots              int
+	crashDumpMaxSize          uint32
+
+	// Host CPUs assigned to domains
+	cpuAllocator *cpuAllocator
+
+	// Init containers run in the background, by key. The runHandlers
+	// add and remove their entries concurrently.
+	initContainersLock sync.Mutex
+	initContainers     map[string]*initContainersRun
 }
 
 func (ctx *domainContext) publishAssignableAdapters() {
@@ -193,6 +207,7 @@ func Run(ps *pubsub.PubSub, loggerArg *logrus.Logger, logArg *base.LogObject) in
 	}
 	aa := types.AssignableAdapters{}
 	domainCtx.assignableAdapters = &aa
+	domainCtx.cpuAllocator = newCPUAllocator(getCPUTopology())
 
 	// Allow only one concurrent domain create
 	domainCtx.createSema = sema.New(log, 1)
@@ -208,6 +223,7 @@ func Run(ps *pubsub.PubSub, loggerArg *logrus.Logger, logArg *base.LogObject) in
 	}
 	domainCtx.pubDomainStatus = pubDomainStatus
 	pubDomainStatus.ClearRestarted()
+	seedCPUAllocator(&domainCtx)
 
 	pubAssignableAdapters, err := ps.NewPublication(
 		pubsub.PublicationOptions{
@@ -229,6 +245,26 @@ func Run(ps *pubsub.PubSub, loggerArg *logrus.Logger, logArg *base.LogObject) in
 	}
 	domainCtx.pubDomainMetric = pubDomainMetric
 
+	pubAppContainerMetrics, err := ps.NewPublication(
+		pubsub.PublicationOptions{
+			AgentName: agentName,
+			TopicType: types.AppContainerMetrics{},
+		})
+	if err != nil {
+		log.Fatal(err)
+	}
+	domainCtx.pubAppContainerMetrics = pubAppContainerMetrics
+
+	pubAppExecStatus, err := ps.NewPublication(
+		pubsub.PublicationOptions{
+			AgentName: agentName,
+			TopicType: types.AppExecStatus{},
+		})
+	if err != nil {
+		log.Fatal(err)
+	}
+	domainCtx.pubAppExecStatus = pubAppExecStatus
+
 	pubProcessMetric, err := ps.NewPublication(
 		pubsub.PublicationOptions{
 			AgentName: agentName,
@@ -370,6 +406,8 @@ func Run(ps *pubsub.PubSub, loggerArg *logrus.Logger, logArg *base.LogObject) in
 	domainCtx.subDeviceNetworkStatus = subDeviceNetworkStatus
 	subDeviceNetworkStatus.Activate()
 
+	casClientType := cas.SelectedCAS()
+	log.Noticef("Using %s CAS", casClientType)
 	if domainCtx.casClient, err = cas.NewCAS(casClientType); err != nil {
 		err = fmt.Errorf("Run: exception while initializing CAS client: %s", err.Error())
 		log.Fatal(err)
@@ -534,6 +572,22 @@ func Run(ps *pubsub.PubSub, loggerArg *logrus.Logger, logArg *base.LogObject) in
 	}
 	log.Functionf("Have %d assignable adapters", len(aa.IoBundleList))
 
+	// Subscribe to MemoryNotification from watcher for ballooning
+	subMemoryNotification, err := ps.NewSubscription(
+		pubsub.SubscriptionOptions{
+			AgentName:   "watcher",
+			MyAgentName: agentName,
+			TopicImpl:   types.MemoryNotification{},
+			Activate:    true,
+			Ctx:         &domainCtx,
+			WarningTime: warningTime,
+			ErrorTime:   errorTime,
+		})
+	if err != nil {
+		log.Fatal(err)
+	}
+	domainCtx.subMemoryNotification = subMemoryNotification
+
 	// Subscribe to DomainConfig from zedmanager
 	subDomainConfig, err := ps.NewSubscription(
 		pubsub.SubscriptionOptions{
@@ -555,6 +609,26 @@ func Run(ps *pubsub.PubSub, loggerArg *logrus.Logger, logArg *base.LogObject) in
 	domainCtx.subDomainConfig = subDomainConfig
 	subDomainConfig.Activate()
 
+	// Subscribe to AppExecConfig from local tools. There is a single
+	// source, so the Caller alone is unique.
+	subTmpAppExecConfig, err := ps.NewSubscription(
+		pubsub.SubscriptionOptions{
+			AgentName:     "",
+			MyAgentName:   agentName,
+			TopicImpl:     types.AppExecConfig{},
+			Activate:      true,
+			Ctx:           &domainCtx,
+			CreateHandler: handleAppExecCreate,
+			ModifyHandler: handleAppExecModify,
+			DeleteHandler: handleAppExecDelete,
+			WarningTime:   warningTime,
+			ErrorTime:     errorTime,
+		})
+	if err != nil {
+		log.Fatal(err)
+	}
+	domainCtx.subTmpAppExecConfig = subTmpAppExecConfig
+
 	for {
 		select {
 		case change := <-subControllerCert.MsgChan():
@@ -572,6 +646,12 @@ func Run(ps *pubsub.PubSub, loggerArg *logrus.Logger, logArg *base.LogObject) in
 		case change := <-subDomainConfig.MsgChan():
 			subDomainConfig.ProcessChange(change)
 
+		case change := <-subTmpAppExecConfig.MsgChan():
+			subTmpAppExecConfig.ProcessChange(change)
+
+		case change := <-subMemoryNotification.MsgChan():
+			subMemoryNotification.ProcessChange(change)
+
 		case change := <-subDeviceNetworkStatus.MsgChan():
 			subDeviceNetworkStatus.ProcessChange(change)
 
@@ -769,6 +849,10 @@ func runHandler(ctx *domainContext, key string, c <-chan Notify) {
 	ticker := flextimer.NewRangeTicker(time.Duration(min),
 		time.Duration(max))
 
+	guestInfoDone := make(chan guestInfoResult, 1)
+	guestInfoRunning := false
+	initRun := newInitContainersRun(ctx, key)
+	defer deleteInitContainersRun(ctx, key)
 	closed := false
 	for !closed {
 		select {
@@ -801,7 +885,20 @@ func runHandler(ctx *domainContext, key string, c <-chan Notify) {
 			if status != nil {
 				verifyStatus(ctx, status)
 				maybeRetry(ctx, status)
+				maybeBalloon(ctx, status)
+				if !guestInfoRunning {
+					guestInfoRunning = maybeStartGuestInfo(status,
+						guestInfoDone)
+				}
+			}
+		case result := <-guestInfoDone:
+			guestInfoRunning = false
+			status := lookupDomainStatus(ctx, key)
+			if status != nil {
+				updateGuestInfo(ctx, status, result)
 			}
+		case p := <-initRun.progress:
+			handleInitContainersProgress(ctx, key, initRun, p)
 		}
 	}
 	log.Functionf("runHandler(%s) DONE", key)
@@ -817,6 +914,7 @@ func verifyStatus(ctx *domainContext, status *types.DomainStatus) {
 	}
 
 	domainID, domainStatus, err := hyper.Task(status).Info(status.DomainName, status.DomainId)
+	podChanged := updatePodStatus(status)
 	if err != nil || domainStatus == types.HALTED {
 		if status.Activated && configActivate {
 			errStr := fmt.Sprintf("verifyStatus(%s) failed %s",
@@ -833,6 +931,9 @@ func verifyStatus(ctx *domainContext, status *types.DomainStatus) {
 				err := fmt.Errorf("one of the %s tasks has crashed (%v)", status.Key(), err)
 				log.Errorf(err.Error())
 				status.SetErrorNow("one of the application's tasks has crashed - please restart application instance")
+				if maybeSaveCrashDump(ctx, status) {
+					status.SetErrorNow("guest kernel panicked - please restart application instance")
+				}
 				if err := hyper.Task(status).Delete(status.DomainName, status.DomainId); err != nil {
 					log.Errorf("failed to delete domain: %s (%v)", status.DomainName, err)
 				}
@@ -868,10 +969,31 @@ func verifyStatus(ctx *domainContext, status *types.DomainStatus) {
 				status.DomainId, status.BootTime.Format(time.RFC3339Nano),
 				status.Key())
 			publishDomainStatus(ctx, status)
+		} else if podChanged {
+			publishDomainStatus(ctx, status)
 		}
 	}
 }
 
+// updatePodStatus refreshes the state of the containers if the domain is a
+// pod. Returns true if it changed.
+func updatePodStatus(status *types.DomainStatus) bool {
+	inspector, ok := hyper.Task(status).(hypervisor.PodInspector)
+	if !ok {
+		return false
+	}
+	podContainers, err := inspector.PodInfo(status.DomainName)
+	if err != nil {
+		log.Errorf("updatePodStatus(%s): %v", status.Key(), err)
+		return false
+	}
+	if cmp.Equal(podContainers, status.PodContainers) {
+		return false
+	}
+	status.PodContainers = podContainers
+	return true
+}
+
 func maybeRetry(ctx *domainContext, status *types.DomainStatus) {
 
 	maybeRetryBoot(ctx, status)
@@ -1039,6 +1161,7 @@ func handleCreate(ctx *domainContext, key string, config *types.DomainConfig) {
 		EnableVnc:          config.EnableVnc,
 		VncDisplay:         config.VncDisplay,
 		VncPasswd:          config.VncPasswd,
+		GuestRebootCounter: config.GuestRebootCounter,
 		State:              types.INSTALLED,
 	}
 	// Note that the -emu interface doesn't exist until after boot of the domU, but we
@@ -1179,6 +1302,21 @@ func doActivate(ctx *domainContext, config types.DomainConfig,
 	// We now have reserved all of the IoAdapters
 	status.IoAdapterList = config.IoAdapterList
 
+	cpus, err := allocateCPUs(ctx, config, status)
+	if err != nil {
+		log.Errorf("Failed to allocate CPUs for %s: %s",
+			config.Key(), err)
+		status.PendingAdd = false
+		status.SetErrorNow(err.Error())
+		publishDomainStatus(ctx, status)
+		releaseAdapters(ctx, config.IoAdapterList, config.UUIDandVersion.UUID,
+			nil)
+		status.IoAdapterList = nil
+		return
+	}
+	config.CPUs = cpus
+	setHotplugSlots(ctx, &config, status)
+
 	// Assign any I/O devices
 	if err := doAssignIoAdaptersToDomain(ctx, config, status); err != nil {
 		log.Errorf("Failed to assign adapters for %s: %s",
@@ -1222,6 +1360,25 @@ func doActivate(ctx *domainContext, config types.DomainConfig,
 		}
 	}
 
+	started, err := startInitContainers(ctx, config, status)
+	if err != nil {
+		log.Errorf("doActivate(%s): %v", config.Key(), err)
+		status.SetErrorNow(err.Error())
+		return
+	}
+	if started {
+		// handleInitContainersProgress goes on once they are done
+		publishDomainStatus(ctx, status)
+		return
+	}
+	doActivateCreate(ctx, config, status)
+}
+
+// doActivateCreate sets up and creates the domain once its init
+// containers, if any, are done
+func doActivateCreate(ctx *domainContext, config types.DomainConfig,
+	status *types.DomainStatus) {
+
 	filename := xenCfgFilename(config.AppNum)
 	file, err := os.Create(filename)
 	if err != nil {
@@ -1242,9 +1399,16 @@ func doActivate(ctx *domainContext, config types.DomainConfig,
 	for {
 		status.TriedCount += 1
 		var err error
-		ctx.createSema.V(1)
-		domainID, err = DomainCreate(ctx, *status)
-		ctx.createSema.P(1)
+		if needsVTPM(config) {
+			// swtpm terminates when qemu goes away hence
+			// we (re)start it for each attempt
+			err = startSwtpm(config)
+		}
+		if err == nil {
+			ctx.createSema.V(1)
+			domainID, err = DomainCreate(ctx, *status)
+			ctx.createSema.P(1)
+		}
 		if err == nil {
 			break
 		}
@@ -1320,6 +1484,9 @@ func doActivateTail(ctx *domainContext, status *types.DomainStatus,
 			status.Key())
 	}
 	status.Activated = true
+	if config := lookupDomainConfig(ctx, status.Key()); config != nil {
+		startBalloon(ctx, *config, status)
+	}
 	err = setupVlans(status.VifList)
 	if err != nil {
 		log.Errorf("setupVlans failed: %v", err)
@@ -1433,6 +1600,7 @@ func doInactivate(ctx *domainContext, status *types.DomainStatus, impatient bool
 
 	log.Functionf("doInactivate(%v) for %s domainId %d",
 		status.UUIDandVersion, status.DisplayName, status.DomainId)
+	stopInitContainers(ctx, status)
 	domainID, _, err := hyper.Task(status).Info(status.DomainName, status.DomainId)
 	if err == nil && domainID != status.DomainId {
 		status.DomainId = domainID
@@ -1534,11 +1702,19 @@ func doInactivate(ctx *domainContext, status *types.DomainStatus, impatient bool
 	} else {
 		status.Activated = false
 		status.State = types.HALTED
+		status.MemoryTarget = 0
+		status.GuestInfo = types.GuestInfo{}
+	}
+	if status.DomainId == 0 {
+		stopSwtpm(status.UUIDandVersion.UUID)
 	}
 	unmountContainers(ctx, status.DiskStatusList)
 	releaseAdapters(ctx, status.IoAdapterList, status.UUIDandVersion.UUID,
 		status)
 	status.IoAdapterList = nil
+	if !status.Activated {
+		releaseCPUs(ctx, status)
+	}
 	publishDomainStatus(ctx, status)
 
 	log.Functionf("doInactivate(%v) done for %s",
@@ -1624,30 +1800,16 @@ func configToStatus(ctx *domainContext, config types.DomainConfig,
 	need9P := false
 	for i, dc := range config.DiskConfigList {
 		ds := &status.DiskStatusList[i]
-		ds.VolumeKey = dc.VolumeKey
-		ds.ReadOnly = dc.ReadOnly
-		ds.FileLocation = dc.FileLocation
-		ds.Format = dc.Format
-		ds.MountDir = dc.MountDir
-		ds.DisplayName = dc.DisplayName
-		// Generate Devtype for hypervisor package
-		// XXX can hypervisor look at something different?
+		*ds = diskConfigToStatus(config, dc, i)
 		if dc.Format == zconfig.Format_CONTAINER {
 			if i == 0 {
 				ds.MountDir = "/"
 				status.OCIConfigDir = ds.FileLocation
 			}
-			ds.Devtype = ""
-			need9P = true
-		} else {
-			if config.VirtualizationMode == types.LEGACY {
-				ds.Devtype = "legacy"
-			} else {
-				ds.Devtype = "hdd"
+			if !isInitImage(config, i) {
+				need9P = true
 			}
 		}
-		// map from i=1 to xvdb, 2 to xvdc etc
-		ds.Vdev = fmt.Sprintf("xvd%c", int('a')+i)
 	}
 
 	if config.IsCipher || config.CloudInitUserData != nil {
@@ -1685,6 +1847,34 @@ func configToStatus(ctx *domainContext, config types.DomainConfig,
 	return nil
 }
 
+// diskConfigToStatus fills in the DiskStatus for the disk at index i
+func diskConfigToStatus(config types.DomainConfig, dc types.DiskConfig,
+	i int) types.DiskStatus {
+
+	ds := types.DiskStatus{
+		VolumeKey:    dc.VolumeKey,
+		ReadOnly:     dc.ReadOnly,
+		FileLocation: dc.FileLocation,
+		Format:       dc.Format,
+		MountDir:     dc.MountDir,
+		DisplayName:  dc.DisplayName,
+	}
+	// Generate Devtype for hypervisor package
+	// XXX can hypervisor look at something different?
+	if dc.Format == zconfig.Format_CONTAINER {
+		ds.Devtype = ""
+	} else {
+		if config.VirtualizationMode == types.LEGACY {
+			ds.Devtype = "legacy"
+		} else {
+			ds.Devtype = "hdd"
+		}
+	}
+	// map from i=1 to xvdb, 2 to xvdc etc
+	ds.Vdev = fmt.Sprintf("xvd%c", int('a')+i)
+	return ds
+}
+
 // Check for errors and reserve any assigned adapters by setting UsedByUUID
 func reserveAdapters(ctx *domainContext, config types.DomainConfig) error {
 
@@ -1776,7 +1966,8 @@ func handleModify(ctx *domainContext, key string,
 	publishDomainStatus(ctx, status)
 
 	changed := false
-	if config.Activate && !status.Activated && status.State != types.BROKEN {
+	if config.Activate && !status.Activated && status.State != types.BROKEN &&
+		!initContainersRunning(ctx, key) {
 		log.Functionf("handleModify(%v) activating for %s",
 			config.UUIDandVersion, config.DisplayName)
 
@@ -1813,7 +2004,7 @@ func handleModify(ctx *domainContext, key string,
 			doInactivate(ctx, status, false)
 			updateStatusFromConfig(status, *config)
 			changed = true
-		} else if status.Activated {
+		} else if status.Activated || initContainersRunning(ctx, key) {
 			doInactivate(ctx, status, false)
 			updateStatusFromConfig(status, *config)
 			changed = true
@@ -1843,6 +2034,16 @@ func handleModify(ctx *domainContext, key string,
 		return
 	}
 
+	// Plug in or remove any added or removed disks and network
+	// interfaces of the running domain
+	if config.Activate && status.Activated {
+		doHotplug(ctx, *config, status)
+		if config.GuestRebootCounter != status.GuestRebootCounter {
+			guestReboot(*config, status)
+			publishDomainStatus(ctx, status)
+		}
+	}
+
 	// XXX check if we have status.HasError() and delete and retry
 	// even if same version. XXX won't the above Activate/Activated checks
 	// result in redoing things? Could have failures during copy i.e.
@@ -1871,6 +2072,8 @@ func handleModify(ctx *domainContext, key string,
 
 func updateStatusFromConfig(status *types.DomainStatus, config types.DomainConfig) {
 	status.VirtualizationMode = config.VirtualizationModeOrDefault()
+	status.GuestRebootCounter = config.GuestRebootCounter
+	status.GuestRebootFailed = false
 	status.EnableVnc = config.EnableVnc
 	status.VncDisplay = config.VncDisplay
 	status.VncPasswd = config.VncPasswd
@@ -1944,7 +2147,7 @@ func handleDelete(ctx *domainContext, key string, status *types.DomainStatus) {
 	status.PendingDelete = true
 	publishDomainStatus(ctx, status)
 
-	if status.Activated {
+	if status.Activated || initContainersRunning(ctx, key) {
 		doInactivate(ctx, status, true)
 	}
 
@@ -1957,12 +2160,21 @@ func handleDelete(ctx *domainContext, key string, status *types.DomainStatus) {
 		log.Errorln(err)
 	}
 
+	releaseCPUs(ctx, status)
+
+	// The TPM state goes with the domain, including on purge
+	stopSwtpm(status.UUIDandVersion.UUID)
+	removeVTPMState(status.UUIDandVersion.UUID)
+
 	status.PendingDelete = false
 	publishDomainStatus(ctx, status)
 	// Write out what we modified to DomainStatus aka delete
 	unpublishDomainStatus(ctx, status)
 	// No point in publishing metrics any more
 	ctx.pubDomainMetric.Unpublish(status.Key())
+	if m, _ := ctx.pubAppContainerMetrics.Get(status.Key()); m != nil {
+		ctx.pubAppContainerMetrics.Unpublish(status.Key())
+	}
 
 	log.Functionf("handleDelete(%v) DONE for %s",
 		status.UUIDandVersion, status.DisplayName)
@@ -1999,6 +2211,11 @@ func DomainShutdown(status types.DomainStatus, force bool) error {
 	var err error
 	log.Functionf("DomainShutdown force-%v %s %d", force, status.DomainName, status.DomainId)
 
+	// Prefer the guest agent over ACPI for a graceful shutdown
+	if !force && guestShutdown(&status) {
+		return nil
+	}
+
 	// Stop the domain
 	log.Functionf("Stopping domain - %s", status.DomainName)
 	err = hyper.Task(&status).Stop(status.DomainName, status.DomainId, force)
@@ -2095,6 +2312,10 @@ func handleGlobalConfigImpl(ctxArg interface{}, key string,
 			ctx.metricInterval = gcp.GlobalValueInt(types.MetricInterval)
 		}
 		ctx.processCloudInitMultiPart = gcp.GlobalValueBool(types.ProcessCloudInitMultiPart)
+		ctx.cpuPinning = gcp.GlobalValueBool(types.CPUPinning)
+		ctx.cpuAllocator.setReserved(int(gcp.GlobalValueInt(types.EveCPUsReserved)))
+		ctx.hotplugSlots = int(gcp.GlobalValueInt(types.AppHotplugSlots))
+		ctx.crashDumpMaxSize = gcp.GlobalValueInt(types.AppCrashDumpMaxSize)
 		ctx.GCInitialized = true
 	}
 	log.Functionf("handleGlobalConfigImpl done for %s. "+
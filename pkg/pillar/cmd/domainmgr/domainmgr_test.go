@@ -15,8 +15,11 @@ import (
 	"os"
 	"reflect"
 	"testing"
+	"time"
 
+	zconfig "github.com/lf-edge/eve/api/go/config"
 	"github.com/lf-edge/eve/pkg/pillar/base"
+	"github.com/lf-edge/eve/pkg/pillar/types"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 )
@@ -409,3 +412,162 @@ func TestHandleMimeMultipart(t *testing.T) {
 	}
 	os.RemoveAll(dir)
 }
+
+func TestComputeBalloonTarget(t *testing.T) {
+	testMatrix := map[string]struct {
+		zone    types.UsageZone
+		current int
+		expect  int
+	}{
+		"Green grows a step": {
+			zone:    types.GreenZone,
+			current: 1024 * 1024,
+			expect:  1280 * 1024,
+		},
+		"Green stops at MaxMem": {
+			zone:    types.GreenZone,
+			current: 1920 * 1024,
+			expect:  2048 * 1024,
+		},
+		"Yellow holds": {
+			zone:    types.YellowZone,
+			current: 1536 * 1024,
+			expect:  1536 * 1024,
+		},
+		"Orange shrinks a step": {
+			zone:    types.OrangeZone,
+			current: 1536 * 1024,
+			expect:  1280 * 1024,
+		},
+		"Orange stops at Memory": {
+			zone:    types.OrangeZone,
+			current: 1100 * 1024,
+			expect:  1024 * 1024,
+		},
+		"Red goes to Memory": {
+			zone:    types.RedZone,
+			current: 2048 * 1024,
+			expect:  1024 * 1024,
+		},
+	}
+	for testname, test := range testMatrix {
+		t.Logf("Running test case %s", testname)
+		target := computeBalloonTarget(test.zone, test.current,
+			1024*1024, 2048*1024)
+		assert.Equal(t, test.expect, target)
+	}
+}
+
+func TestComputeHotplugChanges(t *testing.T) {
+	disk := func(key string) types.DiskConfig {
+		return types.DiskConfig{VolumeKey: key, FileLocation: "/persist/" + key,
+			Format: zconfig.Format_QCOW2, DisplayName: key}
+	}
+	vif := func(name string) types.VifInfo {
+		return types.VifInfo{Vif: name, VifUsed: name, Bridge: "bn1",
+			Mac: "00:16:3e:00:01:01"}
+	}
+	status := types.DomainStatus{HotplugSlots: 2}
+	running := types.DomainConfig{
+		DiskConfigList: []types.DiskConfig{disk("boot"), disk("data")},
+		VifList:        []types.VifInfo{vif("nbu1x1")},
+	}
+	for i, dc := range running.DiskConfigList {
+		status.DiskStatusList = append(status.DiskStatusList,
+			diskConfigToStatus(running, dc, i))
+	}
+	// The cloud-init disk stays
+	status.DiskStatusList = append(status.DiskStatusList,
+		types.DiskStatus{FileLocation: "/run/cloud-init.iso", Devtype: "cdrom",
+			Vdev: "xvdc"})
+	status.VifList = running.VifList
+
+	changes, err := computeHotplugChanges(running, status)
+	assert.NoError(t, err)
+	assert.True(t, changes.empty())
+
+	// Replace the data disk and add an interface
+	config := types.DomainConfig{
+		DiskConfigList: []types.DiskConfig{disk("boot"), disk("more")},
+		VifList:        []types.VifInfo{vif("nbu1x1"), vif("nbu2x1")},
+	}
+	changes, err = computeHotplugChanges(config, status)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(changes.removeDisks))
+	assert.Equal(t, "data", changes.removeDisks[0].VolumeKey)
+	assert.Equal(t, 1, len(changes.addDisks))
+	assert.Equal(t, "xvdd", changes.addDisks[0].Vdev)
+	assert.True(t, changes.addDisks[0].Hotplugged)
+	assert.Equal(t, 1, len(changes.addVifs))
+	assert.Equal(t, "nbu2x1", changes.addVifs[0].Vif)
+	assert.Equal(t, 0, len(changes.removeVifs))
+
+	// Not enough slots once one is used
+	status.VifList = append(status.VifList, vif("nbu3x1"))
+	status.VifList[1].Hotplugged = true
+	config.VifList = append(config.VifList, vif("nbu3x1"))
+	_, err = computeHotplugChanges(config, status)
+	assert.Error(t, err)
+
+	// The boot disk can not be replaced
+	config = running
+	config.DiskConfigList = []types.DiskConfig{disk("other"), disk("data")}
+	_, err = computeHotplugChanges(config, status)
+	assert.Error(t, err)
+
+	// Nor can anything be plugged without slots
+	status.HotplugSlots = 0
+	config = running
+	config.DiskConfigList = append(config.DiskConfigList, disk("more"))
+	_, err = computeHotplugChanges(config, status)
+	assert.Error(t, err)
+}
+
+func TestClearHotplugError(t *testing.T) {
+	status := types.DomainStatus{}
+	assert.False(t, clearHotplugError(&status))
+
+	// Errors from elsewhere stay
+	status.SetErrorNow("boot failed")
+	assert.False(t, clearHotplugError(&status))
+	assert.Equal(t, "boot failed", status.Error)
+
+	status.SetErrorNow(hotplugErrorPrefix + "failed: [no slot]")
+	assert.True(t, clearHotplugError(&status))
+	assert.False(t, status.HasError())
+}
+
+func TestCrashDumpsToEvict(t *testing.T) {
+	now := time.Now()
+	dumps := []crashDumpFile{
+		{name: "new", size: 300, modTime: now},
+		{name: "oldest", size: 100, modTime: now.Add(-2 * time.Hour)},
+		{name: "old", size: 200, modTime: now.Add(-time.Hour)},
+	}
+	testMatrix := map[string]struct {
+		limit  int64
+		expect []string
+	}{
+		"Fits": {
+			limit:  600,
+			expect: nil,
+		},
+		"Evict oldest": {
+			limit:  500,
+			expect: []string{"oldest"},
+		},
+		"Evict two oldest": {
+			limit:  400,
+			expect: []string{"oldest", "old"},
+		},
+		"Evict all": {
+			limit:  0,
+			expect: []string{"oldest", "old", "new"},
+		},
+	}
+	for testname, test := range testMatrix {
+		t.Logf("Running test case %s", testname)
+		evict := crashDumpsToEvict(dumps, test.limit)
+		assert.Equal(t, test.expect, evict)
+	}
+}
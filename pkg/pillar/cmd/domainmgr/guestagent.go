@@ -0,0 +1,120 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package domainmgr
+
+// Guest agent. KVM domains have a virtio-serial port for qemu-guest-agent.
+// When the guest runs the agent it is used for a graceful shutdown or
+// reboot, and the OS, network addresses and filesystem usage it reports are
+// published in DomainStatus.GuestInfo.
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/lf-edge/eve/pkg/pillar/hypervisor"
+	"github.com/lf-edge/eve/pkg/pillar/types"
+)
+
+// guestInfoInterval is how often the guest agent is asked for its info
+const guestInfoInterval = 2 * time.Minute
+
+func guestAgent(status *types.DomainStatus) hypervisor.GuestAgent {
+	agent, ok := hyper.Task(status).(hypervisor.GuestAgent)
+	if !ok {
+		return nil
+	}
+	return agent
+}
+
+// guestShutdown tries a shutdown through the guest agent. Returns false if
+// there is no agent to do it.
+func guestShutdown(status *types.DomainStatus) bool {
+	agent := guestAgent(status)
+	if agent == nil || !status.GuestInfo.AgentRunning {
+		return false
+	}
+	if err := agent.GuestShutdown(status.DomainName); err != nil {
+		log.Warnf("guestShutdown(%s) failed: %v", status.Key(), err)
+		return false
+	}
+	log.Noticef("guestShutdown(%s) done", status.Key())
+	return true
+}
+
+// guestReboot has the guest agent reboot the guest OS for the
+// GuestRebootCounter of the config
+func guestReboot(config types.DomainConfig, status *types.DomainStatus) {
+	status.GuestRebootCounter = config.GuestRebootCounter
+	status.GuestRebootFailed = true
+	agent := guestAgent(status)
+	if agent == nil || !status.GuestInfo.AgentRunning {
+		log.Warnf("guestReboot(%s): no guest agent", status.Key())
+		return
+	}
+	if err := agent.GuestReboot(status.DomainName); err != nil {
+		log.Warnf("guestReboot(%s) failed: %v", status.Key(), err)
+		return
+	}
+	log.Noticef("guestReboot(%s) done", status.Key())
+	status.GuestRebootFailed = false
+}
+
+// guestInfoResult is the GuestInfo collected for a domain
+type guestInfoResult struct {
+	domainID int
+	info     types.GuestInfo
+}
+
+// maybeStartGuestInfo is called periodically to refresh the GuestInfo of a
+// running domain. The guest agent can take a while to answer, or not at
+// all, hence it is asked in a goroutine which sends the result to done.
+// Returns true if it was started.
+func maybeStartGuestInfo(status *types.DomainStatus,
+	done chan<- guestInfoResult) bool {
+
+	if !status.Activated || status.DomainId == 0 {
+		return false
+	}
+	if time.Since(status.GuestInfo.LastUpdate) < guestInfoInterval {
+		return false
+	}
+	agent := guestAgent(status)
+	if agent == nil {
+		return false
+	}
+	key := status.Key()
+	domainName := status.DomainName
+	domainID := status.DomainId
+	go func() {
+		info, err := agent.GuestInfo(domainName)
+		if err != nil {
+			log.Tracef("maybeStartGuestInfo(%s): %v", key, err)
+		}
+		info.LastUpdate = time.Now()
+		done <- guestInfoResult{domainID: domainID, info: info}
+	}()
+	return true
+}
+
+// updateGuestInfo publishes the GuestInfo collected by maybeStartGuestInfo
+// unless the domain was halted or restarted in the meantime
+func updateGuestInfo(ctx *domainContext, status *types.DomainStatus,
+	result guestInfoResult) {
+
+	if !status.Activated || status.DomainId != result.domainID {
+		return
+	}
+	info := result.info
+	old := status.GuestInfo
+	status.GuestInfo = info
+	old.LastUpdate = info.LastUpdate
+	if reflect.DeepEqual(old, info) {
+		return
+	}
+	if info.AgentRunning != old.AgentRunning {
+		log.Noticef("updateGuestInfo(%s) guest agent running %t os %s",
+			status.Key(), info.AgentRunning, info.OSName)
+	}
+	publishDomainStatus(ctx, status)
+}
@@ -0,0 +1,271 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package domainmgr
+
+// Hot-plug of disks and network interfaces. Domains are booted with
+// app.hotplug.slots spare slots when the hypervisor supports it. When a
+// modified DomainConfig for a running domain only adds or removes virtio
+// disks and network interfaces those are plugged and unplugged live
+// instead of the changes waiting for the next boot.
+
+import (
+	"fmt"
+	"strings"
+
+	zconfig "github.com/lf-edge/eve/api/go/config"
+	"github.com/lf-edge/eve/pkg/pillar/hypervisor"
+	"github.com/lf-edge/eve/pkg/pillar/types"
+)
+
+func hotplugger(status *types.DomainStatus) hypervisor.Hotplugger {
+	h, ok := hyper.Task(status).(hypervisor.Hotplugger)
+	if !ok {
+		return nil
+	}
+	return h
+}
+
+// setHotplugSlots decides how many spare slots the domain gets when it
+// is booted
+func setHotplugSlots(ctx *domainContext, config *types.DomainConfig,
+	status *types.DomainStatus) {
+
+	config.HotplugSlots = 0
+	if hotplugger(status) != nil &&
+		config.VirtualizationMode != types.LEGACY {
+		config.HotplugSlots = ctx.hotplugSlots
+	}
+	status.HotplugSlots = config.HotplugSlots
+	// After a boot everything has a slot of its own
+	for i := range status.DiskStatusList {
+		status.DiskStatusList[i].Hotplugged = false
+	}
+	for i := range status.VifList {
+		status.VifList[i].Hotplugged = false
+	}
+}
+
+// hotplugChanges is what needs to be plugged and unplugged to get the
+// running domain from status to config
+type hotplugChanges struct {
+	addDisks    []types.DiskStatus
+	removeDisks []types.DiskStatus
+	addVifs     []types.VifInfo
+	removeVifs  []types.VifInfo
+}
+
+func (changes hotplugChanges) empty() bool {
+	return len(changes.addDisks) == 0 && len(changes.removeDisks) == 0 &&
+		len(changes.addVifs) == 0 && len(changes.removeVifs) == 0
+}
+
+// freeVdev returns the first xvd name not in use
+func freeVdev(used map[string]bool) string {
+	for c := 'a'; c <= 'z'; c++ {
+		vdev := fmt.Sprintf("xvd%c", c)
+		if !used[vdev] {
+			return vdev
+		}
+	}
+	return ""
+}
+
+// computeHotplugChanges compares the disks and network interfaces. Returns
+// an error if the differences can not be applied to the running domain.
+func computeHotplugChanges(config types.DomainConfig,
+	status types.DomainStatus) (hotplugChanges, error) {
+
+	var changes hotplugChanges
+	wanted := make(map[string]bool)
+	for _, dc := range config.DiskConfigList {
+		wanted[dc.VolumeKey] = true
+	}
+	existing := make(map[string]types.DiskStatus)
+	usedVdev := make(map[string]bool)
+	for i, ds := range status.DiskStatusList {
+		usedVdev[ds.Vdev] = true
+		// The cloud-init and 9P disks have no VolumeKey
+		if ds.VolumeKey == "" {
+			continue
+		}
+		existing[ds.VolumeKey] = ds
+		if wanted[ds.VolumeKey] {
+			continue
+		}
+		if i == 0 {
+			return changes, fmt.Errorf("boot disk %s removed",
+				ds.DisplayName)
+		}
+		if ds.Format == zconfig.Format_CONTAINER {
+			return changes, fmt.Errorf("container disk %s removed",
+				ds.DisplayName)
+		}
+		changes.removeDisks = append(changes.removeDisks, ds)
+	}
+	for i, dc := range config.DiskConfigList {
+		if ds, ok := existing[dc.VolumeKey]; ok {
+			if ds.FileLocation != dc.FileLocation ||
+				ds.ReadOnly != dc.ReadOnly {
+				return changes, fmt.Errorf("disk %s changed",
+					dc.DisplayName)
+			}
+			continue
+		}
+		if i == 0 {
+			return changes, fmt.Errorf("boot disk %s changed",
+				dc.DisplayName)
+		}
+		ds := diskConfigToStatus(config, dc, i)
+		if ds.Devtype != "hdd" {
+			return changes, fmt.Errorf("disk %s of format %s added",
+				dc.DisplayName, dc.Format)
+		}
+		ds.Vdev = freeVdev(usedVdev)
+		if ds.Vdev == "" {
+			return changes, fmt.Errorf("no free vdev for %s",
+				dc.DisplayName)
+		}
+		usedVdev[ds.Vdev] = true
+		ds.Hotplugged = true
+		changes.addDisks = append(changes.addDisks, ds)
+	}
+
+	wantedVifs := make(map[string]types.VifInfo)
+	for _, net := range config.VifList {
+		wantedVifs[net.Vif] = net
+	}
+	existingVifs := make(map[string]bool)
+	for _, net := range status.VifList {
+		existingVifs[net.Vif] = true
+		want, ok := wantedVifs[net.Vif]
+		if !ok {
+			changes.removeVifs = append(changes.removeVifs, net)
+			continue
+		}
+		if want.Mac != net.Mac || want.Bridge != net.Bridge {
+			return changes, fmt.Errorf("network interface %s changed",
+				net.Vif)
+		}
+	}
+	for _, net := range config.VifList {
+		if existingVifs[net.Vif] {
+			continue
+		}
+		net.VifUsed = net.Vif
+		net.Hotplugged = true
+		changes.addVifs = append(changes.addVifs, net)
+	}
+	if changes.empty() {
+		return changes, nil
+	}
+	if status.HotplugSlots == 0 {
+		return changes, fmt.Errorf("domain has no hot-plug slots")
+	}
+	if config.VirtualizationMode == types.LEGACY {
+		return changes, fmt.Errorf("legacy domain")
+	}
+	added := len(changes.addDisks) + len(changes.addVifs)
+	if free := status.FreeHotplugSlots(); added > free {
+		return changes, fmt.Errorf("adding %d devices with %d free hot-plug slots",
+			added, free)
+	}
+	return changes, nil
+}
+
+// hotplugErrorPrefix marks the errors set by doHotplug so that only those
+// are cleared once the hot-plug succeeds
+const hotplugErrorPrefix = "hot-plug: "
+
+// clearHotplugError clears the error if doHotplug set it.
+// Returns true if it was cleared.
+func clearHotplugError(status *types.DomainStatus) bool {
+	if !status.HasError() || !strings.HasPrefix(status.Error, hotplugErrorPrefix) {
+		return false
+	}
+	log.Noticef("doHotplug(%s) clearing existing error: %s",
+		status.Key(), status.Error)
+	status.ClearError()
+	return true
+}
+
+// doHotplug applies added and removed disks and network interfaces to the
+// running domain
+func doHotplug(ctx *domainContext, config types.DomainConfig,
+	status *types.DomainStatus) {
+
+	changes, err := computeHotplugChanges(config, *status)
+	if changes.empty() && err == nil {
+		// Nothing left to do, e.g., the change which could not be
+		// applied was reverted
+		if clearHotplugError(status) {
+			publishDomainStatus(ctx, status)
+		}
+		return
+	}
+	h := hotplugger(status)
+	if err == nil && h == nil {
+		err = fmt.Errorf("not supported by %s", hyper.Name())
+	}
+	if err != nil {
+		err = fmt.Errorf("changed disks or network interfaces require a restart: %v",
+			err)
+		log.Errorf("doHotplug(%s): %v", status.Key(), err)
+		status.SetErrorNow(hotplugErrorPrefix + err.Error())
+		publishDomainStatus(ctx, status)
+		return
+	}
+	var errs []string
+	for _, ds := range changes.removeDisks {
+		if err := h.DetachDisk(*status, ds); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		log.Noticef("doHotplug(%s) removed disk %s", status.Key(), ds.Vdev)
+		var diskList []types.DiskStatus
+		for _, old := range status.DiskStatusList {
+			if old.VolumeKey != ds.VolumeKey {
+				diskList = append(diskList, old)
+			}
+		}
+		status.DiskStatusList = diskList
+	}
+	for _, net := range changes.removeVifs {
+		if err := h.DetachVif(*status, net); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		log.Noticef("doHotplug(%s) removed %s", status.Key(), net.Vif)
+		var vifList []types.VifInfo
+		for _, old := range status.VifList {
+			if old.Vif != net.Vif {
+				vifList = append(vifList, old)
+			}
+		}
+		status.VifList = vifList
+	}
+	for _, ds := range changes.addDisks {
+		if err := h.AttachDisk(*status, ds); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		log.Noticef("doHotplug(%s) added disk %s as %s", status.Key(),
+			ds.FileLocation, ds.Vdev)
+		status.DiskStatusList = append(status.DiskStatusList, ds)
+	}
+	for _, net := range changes.addVifs {
+		if err := h.AttachVif(*status, net); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		log.Noticef("doHotplug(%s) added %s", status.Key(), net.Vif)
+		status.VifList = append(status.VifList, net)
+	}
+	if len(errs) != 0 {
+		status.SetErrorNow(fmt.Sprintf("%sfailed: %v", hotplugErrorPrefix,
+			errs))
+	} else {
+		clearHotplugError(status)
+	}
+	publishDomainStatus(ctx, status)
+}
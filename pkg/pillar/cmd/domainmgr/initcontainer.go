@@ -0,0 +1,288 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package domainmgr
+
+// Init containers. Each time a domain is activated its InitContainers are
+// run to completion, one after the other, once its volumes are ready and
+// before it is created. They run in a goroutine so that the runHandler of
+// the domain can still stop them on a deactivation or delete; it goes on
+// with the activation once they are done. The activation fails as soon as
+// one of them fails, and they are not run again until the domain is
+// activated again. The end of the output of each one is kept in
+// DomainStatus.InitContainers along with its exit code.
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lf-edge/eve/pkg/pillar/hypervisor"
+	"github.com/lf-edge/eve/pkg/pillar/types"
+)
+
+const (
+	// defaultInitContainerTimeLimit applies to the init containers
+	// without a TimeLimit
+	defaultInitContainerTimeLimit = 10 * time.Minute
+	// initContainerOutputSize is how much of the output of an init
+	// container is kept
+	initContainerOutputSize = 4096
+)
+
+// tailBuffer keeps the last size bytes written to it
+type tailBuffer struct {
+	sync.Mutex
+	buf  []byte
+	size int
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	if len(p) >= b.size {
+		b.buf = append(b.buf[:0], p[len(p)-b.size:]...)
+		return len(p), nil
+	}
+	b.buf = append(b.buf, p...)
+	if over := len(b.buf) - b.size; over > 0 {
+		n := copy(b.buf, b.buf[over:])
+		b.buf = b.buf[:n]
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	b.Lock()
+	defer b.Unlock()
+	return string(b.buf)
+}
+
+// isInitImage returns true if the disk is the image of an init container
+// and of nothing else, hence not to be seen by a VM
+func isInitImage(config types.DomainConfig, disk int) bool {
+	if disk == 0 {
+		return false
+	}
+	for _, c := range config.PodContainers {
+		if c.ImageDisk == disk {
+			return false
+		}
+	}
+	for _, c := range config.InitContainers {
+		if c.ImageDisk == disk {
+			return true
+		}
+	}
+	return false
+}
+
+// initContainersRun is the run of the init containers of a domain in the
+// background. Apart from the map holding it, it is only used by the
+// runHandler of the domain.
+type initContainersRun struct {
+	progress chan initContainersProgress
+	stop     chan struct{}
+	running  bool
+	// config is the DomainConfig, with its CPUs, the activation goes on
+	// with once the init containers are done
+	config types.DomainConfig
+}
+
+// initContainersProgress is sent each time an init container starts or
+// ends. The last one has done set, and the error which stopped the run if
+// any.
+type initContainersProgress struct {
+	statuses []types.InitContainerStatus
+	done     bool
+	err      error
+}
+
+// newInitContainersRun is called by the runHandler of the domain
+func newInitContainersRun(ctx *domainContext, key string) *initContainersRun {
+	run := &initContainersRun{progress: make(chan initContainersProgress)}
+	ctx.initContainersLock.Lock()
+	defer ctx.initContainersLock.Unlock()
+	if ctx.initContainers == nil {
+		ctx.initContainers = make(map[string]*initContainersRun)
+	}
+	ctx.initContainers[key] = run
+	return run
+}
+
+func deleteInitContainersRun(ctx *domainContext, key string) {
+	ctx.initContainersLock.Lock()
+	defer ctx.initContainersLock.Unlock()
+	delete(ctx.initContainers, key)
+}
+
+func lookupInitContainersRun(ctx *domainContext, key string) *initContainersRun {
+	ctx.initContainersLock.Lock()
+	defer ctx.initContainersLock.Unlock()
+	return ctx.initContainers[key]
+}
+
+// initContainersRunning returns true while the init containers of the
+// domain are run
+func initContainersRunning(ctx *domainContext, key string) bool {
+	run := lookupInitContainersRun(ctx, key)
+	return run != nil && run.running
+}
+
+// initContainerStatuses returns the status of the init containers before
+// they are run
+func initContainerStatuses(config types.DomainConfig) []types.InitContainerStatus {
+	statuses := make([]types.InitContainerStatus, len(config.InitContainers))
+	for i, c := range config.InitContainers {
+		statuses[i] = types.InitContainerStatus{
+			Name:  c.Name,
+			State: types.INITIAL,
+		}
+	}
+	return statuses
+}
+
+// startInitContainers starts running the init containers of the domain in
+// the background. Returns false if it has none, in which case the
+// activation goes on right away.
+func startInitContainers(ctx *domainContext, config types.DomainConfig,
+	status *types.DomainStatus) (bool, error) {
+
+	if len(config.InitContainers) == 0 {
+		status.InitContainers = nil
+		return false, nil
+	}
+	runner, ok := hyper.Task(status).(hypervisor.InitContainerRunner)
+	if !ok {
+		return false, fmt.Errorf("init containers are not supported by %s",
+			hyper.Name())
+	}
+	run := lookupInitContainersRun(ctx, status.Key())
+	if run == nil {
+		return false, fmt.Errorf("no handler for %s", status.Key())
+	}
+	if run.running {
+		return false, fmt.Errorf("init containers of %s already running",
+			status.Key())
+	}
+	status.InitContainers = initContainerStatuses(config)
+	run.running = true
+	run.stop = make(chan struct{})
+	run.config = config
+	go runInitContainers(runner, *status, config, run.progress, run.stop)
+	return true, nil
+}
+
+// runInitContainers runs the init containers of the domain in order, until
+// one fails or stop is closed, sending its progress
+func runInitContainers(runner hypervisor.InitContainerRunner,
+	status types.DomainStatus, config types.DomainConfig,
+	progress chan<- initContainersProgress, stop <-chan struct{}) {
+
+	statuses := initContainerStatuses(config)
+	send := func(done bool, err error) {
+		progress <- initContainersProgress{
+			statuses: append([]types.InitContainerStatus(nil), statuses...),
+			done:     done,
+			err:      err,
+		}
+	}
+	stopped := func() bool {
+		select {
+		case <-stop:
+			return true
+		default:
+			return false
+		}
+	}
+	for i, c := range config.InitContainers {
+		if stopped() {
+			send(true, fmt.Errorf("init container %s not run: stopped", c.Name))
+			return
+		}
+		ics := &statuses[i]
+		ics.State = types.RUNNING
+		ics.StartTime = time.Now()
+		send(false, nil)
+
+		timeLimit := defaultInitContainerTimeLimit
+		if c.TimeLimit != 0 {
+			timeLimit = time.Duration(c.TimeLimit) * time.Second
+		}
+		log.Noticef("runInitContainers(%s): running %s", status.Key(), c.Name)
+		output := &tailBuffer{size: initContainerOutputSize}
+		exitCode, timedOut, err := runner.RunInitContainer(status, config, c,
+			output, timeLimit, stop)
+		ics.EndTime = time.Now()
+		ics.ExitCode = exitCode
+		ics.TimedOut = timedOut
+		ics.Output = output.String()
+		switch {
+		case err != nil:
+			err = fmt.Errorf("init container %s failed: %v", c.Name, err)
+		case stopped():
+			err = fmt.Errorf("init container %s stopped", c.Name)
+		case timedOut:
+			err = fmt.Errorf("init container %s did not complete in %v",
+				c.Name, timeLimit)
+		case exitCode != 0:
+			err = fmt.Errorf("init container %s exited with %d",
+				c.Name, exitCode)
+		}
+		if err != nil {
+			ics.State = types.BROKEN
+			send(true, err)
+			return
+		}
+		ics.State = types.HALTED
+		log.Noticef("runInitContainers(%s): %s done in %v", status.Key(),
+			c.Name, ics.EndTime.Sub(ics.StartTime))
+	}
+	send(true, nil)
+}
+
+// handleInitContainersProgress publishes the progress of the init
+// containers, and once they are all done goes on with the activation, or
+// fails it
+func handleInitContainersProgress(ctx *domainContext, key string,
+	run *initContainersRun, p initContainersProgress) {
+
+	if p.done {
+		run.running = false
+	}
+	status := lookupDomainStatus(ctx, key)
+	if status == nil {
+		log.Errorf("handleInitContainersProgress(%s): no DomainStatus", key)
+		return
+	}
+	status.InitContainers = p.statuses
+	switch {
+	case !p.done:
+	case p.err != nil:
+		log.Errorf("handleInitContainersProgress(%s): %v", key, p.err)
+		status.SetErrorNow(p.err.Error())
+	default:
+		log.Noticef("handleInitContainersProgress(%s): done", key)
+		doActivateCreate(ctx, run.config, status)
+	}
+	publishDomainStatus(ctx, status)
+}
+
+// stopInitContainers kills the init container running for the domain, if
+// any, and waits until the run is over
+func stopInitContainers(ctx *domainContext, status *types.DomainStatus) {
+	run := lookupInitContainersRun(ctx, status.Key())
+	if run == nil || !run.running {
+		return
+	}
+	log.Noticef("stopInitContainers(%s)", status.Key())
+	close(run.stop)
+	for {
+		p := <-run.progress
+		status.InitContainers = p.statuses
+		if p.done {
+			break
+		}
+	}
+	run.running = false
+}
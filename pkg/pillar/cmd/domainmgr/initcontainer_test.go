@@ -0,0 +1,148 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package domainmgr
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/lf-edge/eve/pkg/pillar/base"
+	"github.com/lf-edge/eve/pkg/pillar/types"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTailBuffer(t *testing.T) {
+	b := &tailBuffer{size: 8}
+	n, err := b.Write([]byte("hello"))
+	assert.NoError(t, err)
+	assert.Equal(t, 5, n)
+	assert.Equal(t, "hello", b.String())
+
+	n, err = b.Write([]byte(" world"))
+	assert.NoError(t, err)
+	assert.Equal(t, 6, n)
+	assert.Equal(t, "lo world", b.String())
+
+	// a write larger than the buffer replaces it
+	n, err = b.Write([]byte("0123456789"))
+	assert.NoError(t, err)
+	assert.Equal(t, 10, n)
+	assert.Equal(t, "23456789", b.String())
+}
+
+func TestIsInitImage(t *testing.T) {
+	config := types.DomainConfig{
+		PodContainers: []types.PodContainer{
+			{Name: "web", ImageDisk: 0},
+			{Name: "db", ImageDisk: 1},
+		},
+		InitContainers: []types.InitContainer{
+			{Name: "migrate", ImageDisk: 1},
+			{Name: "license", ImageDisk: 2},
+			{Name: "self", ImageDisk: 0},
+		},
+	}
+	assert.False(t, isInitImage(config, 0))
+	assert.False(t, isInitImage(config, 1))
+	assert.True(t, isInitImage(config, 2))
+	assert.False(t, isInitImage(config, 3))
+}
+
+// testInitRunner exits with the code of each init container in exitCodes,
+// or, for "block", waits to be stopped
+type testInitRunner struct {
+	exitCodes map[string]int
+	ran       []string
+}
+
+func (r *testInitRunner) RunInitContainer(status types.DomainStatus, config types.DomainConfig,
+	c types.InitContainer, output io.Writer, timeout time.Duration,
+	stop <-chan struct{}) (int, bool, error) {
+
+	r.ran = append(r.ran, c.Name)
+	if c.Name == "block" {
+		<-stop
+		return 137, false, nil
+	}
+	_, _ = output.Write([]byte(c.Name))
+	return r.exitCodes[c.Name], false, nil
+}
+
+// collectInitProgress returns what runInitContainers sent until it was done
+func collectInitProgress(t *testing.T, progress <-chan initContainersProgress) []initContainersProgress {
+	t.Helper()
+	var ret []initContainersProgress
+	for {
+		select {
+		case p := <-progress:
+			ret = append(ret, p)
+			if p.done {
+				return ret
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("init containers not done")
+		}
+	}
+}
+
+func initContainersConfig(names ...string) types.DomainConfig {
+	config := types.DomainConfig{}
+	for _, name := range names {
+		config.InitContainers = append(config.InitContainers,
+			types.InitContainer{Name: name})
+	}
+	return config
+}
+
+func TestRunInitContainers(t *testing.T) {
+	log = base.NewSourceLogObject(logrus.StandardLogger(), "domainmgr", 0)
+	runner := &testInitRunner{exitCodes: map[string]int{"fail": 2}}
+	progress := make(chan initContainersProgress)
+
+	go runInitContainers(runner, types.DomainStatus{},
+		initContainersConfig("format", "migrate"), progress, make(chan struct{}))
+	sent := collectInitProgress(t, progress)
+	assert.Len(t, sent, 3)
+	assert.Equal(t, types.RUNNING, sent[0].statuses[0].State)
+	assert.Equal(t, types.INITIAL, sent[0].statuses[1].State)
+	last := sent[len(sent)-1]
+	assert.NoError(t, last.err)
+	assert.Equal(t, types.HALTED, last.statuses[0].State)
+	assert.Equal(t, types.HALTED, last.statuses[1].State)
+	assert.Equal(t, "migrate", last.statuses[1].Output)
+
+	// the ones after a failure are not run
+	runner.ran = nil
+	go runInitContainers(runner, types.DomainStatus{},
+		initContainersConfig("fail", "migrate"), progress, make(chan struct{}))
+	sent = collectInitProgress(t, progress)
+	last = sent[len(sent)-1]
+	assert.Error(t, last.err)
+	assert.Equal(t, []string{"fail"}, runner.ran)
+	assert.Equal(t, types.BROKEN, last.statuses[0].State)
+	assert.Equal(t, 2, last.statuses[0].ExitCode)
+	assert.Equal(t, types.INITIAL, last.statuses[1].State)
+}
+
+func TestRunInitContainersStopped(t *testing.T) {
+	log = base.NewSourceLogObject(logrus.StandardLogger(), "domainmgr", 0)
+	runner := &testInitRunner{}
+	progress := make(chan initContainersProgress)
+	stop := make(chan struct{})
+
+	go runInitContainers(runner, types.DomainStatus{},
+		initContainersConfig("block", "migrate"), progress, stop)
+	p := <-progress
+	assert.False(t, p.done)
+	assert.Equal(t, types.RUNNING, p.statuses[0].State)
+	close(stop)
+	sent := collectInitProgress(t, progress)
+	last := sent[len(sent)-1]
+	assert.Error(t, last.err)
+	assert.Equal(t, []string{"block"}, runner.ran)
+	assert.Equal(t, types.BROKEN, last.statuses[0].State)
+	assert.Equal(t, types.INITIAL, last.statuses[1].State)
+}
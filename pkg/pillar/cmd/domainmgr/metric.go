@@ -55,6 +55,7 @@ func getAndPublishMetrics(ctx *domainContext, hyper hypervisor.Hypervisor) {
 		if status != nil {
 			dm.UUIDandVersion.Version = status.UUIDandVersion.Version
 			dm.Activated = status.Activated
+			dm.MemoryTarget = uint32((status.MemoryTarget + 1023) / 1024)
 		}
 		if !dm.Activated {
 			// We clear the memory so it doesn't accidentally get
@@ -85,6 +86,7 @@ func getAndPublishMetrics(ctx *domainContext, hyper hypervisor.Hypervisor) {
 		dm.UsedMemoryPercent = 0
 		ctx.pubDomainMetric.Publish(dm.Key(), dm)
 	}
+	publishAppContainerMetrics(ctx, hyper, now)
 	hm, _ := hyper.GetHostCPUMem()
 	if hyper.Name() != "xen" {
 		// the the hypervisor other than Xen, we don't have the Dom0 stats. Get the host
@@ -137,3 +139,60 @@ func formatAndPublishHostCPUMem(ctx *domainContext, hm types.HostMemory, now tim
 	log.Tracef("formatAndPublishHostCPUMem: hostcpu, dm %+v, CPU num %d", dm, CPUnum)
 	ctx.pubDomainMetric.Publish(dm.Key(), dm)
 }
+
+// publishAppContainerMetrics publishes the stats of the containers of the
+// activated domains which run as containers on the host. zedagent reports
+// them like those zedrouter collects from docker-in-VM apps.
+func publishAppContainerMetrics(ctx *domainContext, hyper hypervisor.Hypervisor, now time.Time) {
+	var systemCPU uint64
+	if cpuStat, err := cpu.Times(false); err != nil {
+		log.Errorf("publishAppContainerMetrics: cpu Get error %v", err)
+	} else {
+		for _, t := range cpuStat {
+			systemCPU += uint64(t.Total())
+		}
+	}
+	heard := make(map[string]bool)
+	for _, item := range ctx.pubDomainStatus.GetAll() {
+		status := item.(types.DomainStatus)
+		if !status.Activated || !isNativeContainer(hyper, status) {
+			continue
+		}
+		collector, ok := hyper.Task(&status).(hypervisor.ContainerStatsCollector)
+		if !ok {
+			continue
+		}
+		statsList, err := collector.ContainerStats(status.DomainName)
+		if err != nil {
+			log.Errorf("publishAppContainerMetrics(%s): %v",
+				status.DomainName, err)
+			continue
+		}
+		for i := range statsList {
+			if statsList[i].ContainerName == "" {
+				statsList[i].ContainerName = status.DisplayName
+			}
+			statsList[i].Uptime = status.BootTime.UnixNano()
+			statsList[i].SystemCPUTotal = systemCPU
+		}
+		acMetrics := types.AppContainerMetrics{
+			UUIDandVersion: status.UUIDandVersion,
+			CollectTime:    now,
+			StatsList:      statsList,
+		}
+		ctx.pubAppContainerMetrics.Publish(acMetrics.Key(), acMetrics)
+		heard[acMetrics.Key()] = true
+	}
+	for _, m := range ctx.pubAppContainerMetrics.GetAll() {
+		acMetrics := m.(types.AppContainerMetrics)
+		if !heard[acMetrics.Key()] {
+			ctx.pubAppContainerMetrics.Unpublish(acMetrics.Key())
+		}
+	}
+}
+
+// isNativeContainer returns true if the domain runs as containers on the
+// host rather than in a VM
+func isNativeContainer(hyper hypervisor.Hypervisor, status types.DomainStatus) bool {
+	return status.VirtualizationMode == types.NOHYPER || hyper.Name() == "containerd"
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package domainmgr
+
+// Per-domain software TPM. For KVM domains with EnableVTPM we run an swtpm
+// instance which qemu talks to over a unix socket. The TPM state lives in
+// the vault hence is encrypted at rest, survives restarts of the domain and
+// of the device, and is removed with the domain when it is deleted or purged.
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/lf-edge/eve/pkg/pillar/base"
+	"github.com/lf-edge/eve/pkg/pillar/types"
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	swtpmStartTimeout = 10 * time.Second
+	swtpmStopTimeout  = 10 * time.Second
+)
+
+// needsVTPM returns true if we need to run an swtpm for the domain
+func needsVTPM(config types.DomainConfig) bool {
+	return config.EnableVTPM && hyper.Name() == "kvm" &&
+		config.VirtualizationMode != types.NOHYPER
+}
+
+func swtpmPidFile(appUUID uuid.UUID) string {
+	return filepath.Join(types.VTPMRunDir, appUUID.String()+".pid")
+}
+
+// startSwtpm starts the swtpm for the domain and waits for its control
+// socket to appear so that qemu can connect to it
+func startSwtpm(config types.DomainConfig) error {
+	appUUID := config.UUIDandVersion.UUID
+	log.Functionf("startSwtpm(%s)", appUUID)
+
+	// Anything left from a previous activation
+	stopSwtpm(appUUID)
+
+	stateDir := types.VTPMStatePath(appUUID)
+	if err := os.MkdirAll(stateDir, 0700); err != nil {
+		return fmt.Errorf("startSwtpm(%s): %v", appUUID, err)
+	}
+	if err := os.MkdirAll(types.VTPMRunDir, 0700); err != nil {
+		return fmt.Errorf("startSwtpm(%s): %v", appUUID, err)
+	}
+	socket := types.VTPMSocketPath(appUUID)
+	cmd := "swtpm"
+	args := []string{
+		"socket",
+		"--tpm2",
+		"--tpmstate", "dir=" + stateDir,
+		"--ctrl", "type=unixio,path=" + socket,
+		"--pid", "file=" + swtpmPidFile(appUUID),
+		"--flags", "startup-clear",
+		"--terminate",
+		"--daemon",
+	}
+	log.Functionf("Calling command %s %v", cmd, args)
+	stdoutStderr, err := base.Exec(log, cmd, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("swtpm for %s failed: %s: %s",
+			appUUID, err, string(stdoutStderr))
+	}
+	start := time.Now()
+	for {
+		if _, err := os.Stat(socket); err == nil {
+			break
+		}
+		if time.Since(start) > swtpmStartTimeout {
+			stopSwtpm(appUUID)
+			return fmt.Errorf("swtpm for %s: no socket after %v",
+				appUUID, swtpmStartTimeout)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	log.Noticef("startSwtpm(%s) done", appUUID)
+	return nil
+}
+
+// stopSwtpm stops the swtpm for the domain if it is running. Normally it
+// has terminated by itself when qemu closed the connection.
+func stopSwtpm(appUUID uuid.UUID) {
+	pidFile := swtpmPidFile(appUUID)
+	defer os.Remove(types.VTPMSocketPath(appUUID))
+	defer os.Remove(pidFile)
+
+	pidBytes, err := ioutil.ReadFile(pidFile)
+	if err != nil {
+		return
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(pidBytes)))
+	if err != nil {
+		log.Errorf("stopSwtpm(%s): bad pid file: %v", appUUID, err)
+		return
+	}
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return
+	}
+	if err := p.Signal(syscall.SIGTERM); err != nil {
+		// Already gone
+		return
+	}
+	log.Functionf("stopSwtpm(%s): sent SIGTERM to %d", appUUID, pid)
+	start := time.Now()
+	for p.Signal(syscall.Signal(0)) == nil {
+		if time.Since(start) > swtpmStopTimeout {
+			log.Warnf("stopSwtpm(%s): killing %d", appUUID, pid)
+			p.Signal(syscall.SIGKILL)
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// removeVTPMState drops the TPM state of the domain
+func removeVTPMState(appUUID uuid.UUID) {
+	stateDir := types.VTPMStatePath(appUUID)
+	if _, err := os.Stat(stateDir); err != nil {
+		return
+	}
+	log.Noticef("removeVTPMState(%s)", appUUID)
+	if err := os.RemoveAll(stateDir); err != nil {
+		log.Errorf("removeVTPMState(%s): %v", appUUID, err)
+	}
+}
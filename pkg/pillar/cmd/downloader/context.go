@@ -4,10 +4,14 @@
 package downloader
 
 import (
+	"sync"
+	"time"
+
 	"github.com/lf-edge/eve/libs/zedUpload"
 	"github.com/lf-edge/eve/pkg/pillar/cipher"
 	"github.com/lf-edge/eve/pkg/pillar/pubsub"
 	"github.com/lf-edge/eve/pkg/pillar/types"
+	uuid "github.com/satori/go.uuid"
 )
 
 type downloaderContext struct {
@@ -25,6 +29,13 @@ type downloaderContext struct {
 	subGlobalConfig          pubsub.Subscription
 	GCInitialized            bool
 	downloadMaxPortCost      uint8
+
+	// Download windows and bandwidth caps; see schedule.go
+	scheduleLock      sync.Mutex
+	downloadWindows   types.DownloadSchedule
+	globalLimiter     *zedUpload.RateLimiter
+	datastoreLimiters map[uuid.UUID]*zedUpload.RateLimiter
+	scheduleTimer     *time.Timer
 }
 
 func (ctx *downloaderContext) registerHandlers(ps *pubsub.PubSub) error {
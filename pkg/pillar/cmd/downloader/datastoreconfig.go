@@ -23,6 +23,8 @@ func handleDatastoreConfigImpl(ctxArg interface{}, key string,
 	ctx := ctxArg.(*downloaderContext)
 	config := configArg.(types.DatastoreConfig)
 	log.Functionf("handleDatastoreConfigImpl for %s", key)
+	updateDatastoreLimiter(ctx, &config)
+	checkDownloadSchedule(ctx)
 	checkAndUpdateDownloadableObjects(ctx, config.UUID)
 	checkAndUpdateResolveConfig(ctx, config.UUID)
 	log.Functionf("handleDatastoreConfigImpl for %s, done", key)
@@ -34,5 +36,6 @@ func handleDatastoreConfigDelete(ctxArg interface{}, key string,
 	config := configArg.(types.DatastoreConfig)
 	cipherBlock := config.CipherBlockStatus
 	ctx.pubCipherBlockStatus.Unpublish(cipherBlock.Key())
+	deleteDatastoreLimiter(ctx, config.UUID)
 	log.Functionf("handleDatastoreConfigDelete for %s", key)
 }
@@ -24,6 +24,7 @@ func download(ctx *downloaderContext, trType zedUpload.SyncTransportType,
 	status Status, syncOp zedUpload.SyncOpType, downloadURL string,
 	auth *zedUpload.AuthInput, dpath, region string, maxsize uint64, ifname string,
 	ipSrc net.IP, filename, locFilename string, certs [][]byte,
+	limiter *zedUpload.RateLimiter, resume bool,
 	receiveChan chan<- CancelChannel) (string, bool, error) {
 
 	// create Endpoint
@@ -46,6 +47,14 @@ func download(ctx *downloaderContext, trType zedUpload.SyncTransportType,
 		log.Errorf("NewSyncerDest failed: %s", err)
 		return "", cancel, err
 	}
+	// The limiter must be set before the source IP which creates the
+	// connections
+	if limiter != nil {
+		if err := dEndPoint.WithRateLimiter(limiter); err != nil {
+			log.Errorf("Set rate limiter failed: %s", err)
+			return "", cancel, err
+		}
+	}
 	// check for proxies on the selected management port interface
 	proxyLookupURL := zedcloud.IntfLookupProxyCfg(log, &ctx.deviceNetworkStatus, ifname, downloadURL, trType)
 	proxyURL, err := zedcloud.LookupProxy(log, &ctx.deviceNetworkStatus, ifname, proxyLookupURL)
@@ -85,6 +94,9 @@ func download(ctx *downloaderContext, trType zedUpload.SyncTransportType,
 
 	req = req.WithCancel(context.Background())
 	defer req.Cancel()
+	if resume {
+		req = req.WithResume()
+	}
 
 	// Tell caller where we can be cancelled
 	cancelChan := make(chan Notify, 1)
@@ -98,7 +98,10 @@ func Run(ps *pubsub.PubSub, loggerArg *logrus.Logger, logArg *base.LogObject) in
 		time.Duration(max))
 
 	// Any state needed by handler functions
-	ctx := downloaderContext{}
+	ctx := downloaderContext{
+		globalLimiter:     zedUpload.NewRateLimiter(0),
+		datastoreLimiters: make(map[uuid.UUID]*zedUpload.RateLimiter),
+	}
 
 	// set up any state needed by handler functions
 	err = ctx.registerHandlers(ps)
@@ -148,6 +151,10 @@ func Run(ps *pubsub.PubSub, loggerArg *logrus.Logger, logArg *base.LogObject) in
 
 	ctx.dCtx = downloaderInit(&ctx)
 
+	// Fires at the next edge of a download window
+	ctx.scheduleTimer = time.NewTimer(time.Hour)
+	checkDownloadSchedule(&ctx)
+
 	for {
 		select {
 		case change := <-ctx.decryptCipherContext.SubControllerCert.MsgChan():
@@ -177,6 +184,12 @@ func Run(ps *pubsub.PubSub, loggerArg *logrus.Logger, logArg *base.LogObject) in
 		case change := <-ctx.subDatastoreConfig.MsgChan():
 			ctx.subDatastoreConfig.ProcessChange(change)
 
+		case <-ctx.scheduleTimer.C:
+			start := time.Now()
+			checkDownloadSchedule(&ctx)
+			ps.CheckMaxTimeTopic(agentName, "scheduleTimer", start,
+				warningTime, errorTime)
+
 		case <-publishTimer.C:
 			start := time.Now()
 			// Transfer to a local copy in since metrics updates are
@@ -445,7 +458,17 @@ func doDownload(ctx *downloaderContext, config types.DownloaderConfig, status *t
 	}
 	log.Tracef("Found datastore(%s) for %s", config.DatastoreID.String(), config.Name)
 
-	handleSyncOp(ctx, status.Key(), config, status, dst, receiveChan)
+	if allowed, resumeTime := downloadAllowed(ctx, dst); !allowed {
+		log.Noticef("doDownload(%s): outside download window until %v",
+			config.Name, resumeTime)
+		pauseDownload(ctx, status, resumeTime)
+		return
+	}
+	// Continue where we stopped if we were paused
+	resume := status.Paused
+	status.Paused = false
+	status.ResumeTime = time.Time{}
+	handleSyncOp(ctx, status.Key(), config, status, dst, resume, receiveChan)
 }
 
 func handleDelete(ctx *downloaderContext, key string,
@@ -40,6 +40,9 @@ func handleGlobalConfigImpl(ctxArg interface{}, key string,
 			maxStalledTime = time.Duration(gcp.GlobalValueInt(types.DownloadStalledTime)) * time.Second
 		}
 		ctx.downloadMaxPortCost = uint8(gcp.GlobalValueInt(types.DownloadMaxPortCost))
+		ctx.globalLimiter.SetRate(int64(gcp.GlobalValueInt(types.DownloadMaxBps)))
+		setDownloadWindows(ctx, gcp.GlobalValueString(types.DownloadWindows))
+		checkDownloadSchedule(ctx)
 		ctx.GCInitialized = true
 	}
 	log.Functionf("handleGlobalConfigImpl done for %s", key)
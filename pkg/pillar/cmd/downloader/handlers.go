@@ -77,6 +77,33 @@ func (d *downloadHandler) modify(ctxArg interface{},
 	}
 }
 
+// pause cancels the current operation for the key, if any. The handler
+// goroutine notices that the download window has closed and marks the
+// download as paused instead of failed.
+func (d *downloadHandler) pause(key string) {
+
+	log.Functionf("downloadHandler.pause(%s)", key)
+	h, ok := d.handlers[key]
+	if !ok {
+		log.Functionf("downloadHandler.pause: unknown %s", key)
+		return
+	}
+	if h.currentCancelChan == nil {
+		return
+	}
+	select {
+	case h.currentCancelChan <- Notify{}:
+		log.Noticef("downloadHandler.pause(%s) sent cancel to %v",
+			key, h.currentCancelChan)
+	default:
+		// handler is slow
+		log.Warnf("downloadHandler.pause(%s) NOT sent cancel", key)
+	}
+	// We only cancel one operation once
+	close(h.currentCancelChan)
+	h.currentCancelChan = nil
+}
+
 func (d *downloadHandler) create(ctxArg interface{},
 	key string, configArg interface{}) {
 
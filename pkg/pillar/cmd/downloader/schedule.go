@@ -0,0 +1,159 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package downloader
+
+import (
+	"time"
+
+	"github.com/lf-edge/eve/libs/zedUpload"
+	"github.com/lf-edge/eve/pkg/pillar/types"
+	"github.com/lf-edge/eve/pkg/pillar/utils"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Download scheduling. Downloads are only done inside the download windows
+// of their datastore, or the global ones if the datastore has none. When
+// a window closes the downloads in progress are cancelled and marked as
+// Paused in DownloaderStatus, keeping what was downloaded so far, and they
+// are resumed when the next window opens.
+// The bandwidth is capped using a zedUpload.RateLimiter shared by all the
+// downloads from a datastore with its own cap, or else by all downloads.
+
+// setDownloadWindows is called from the global config handler
+func setDownloadWindows(ctx *downloaderContext, windows string) {
+	sched, err := types.ParseDownloadSchedule(windows)
+	if err != nil {
+		log.Errorf("Ignoring %s %q: %v", types.DownloadWindows, windows, err)
+		sched = nil
+	}
+	ctx.scheduleLock.Lock()
+	ctx.downloadWindows = sched
+	ctx.scheduleLock.Unlock()
+}
+
+// datastoreSchedule returns the schedule which applies to the datastore
+func datastoreSchedule(ctx *downloaderContext,
+	dst *types.DatastoreConfig) types.DownloadSchedule {
+
+	if dst != nil && dst.DownloadWindow != "" {
+		sched, err := types.ParseDownloadSchedule(dst.DownloadWindow)
+		if err == nil {
+			return sched
+		}
+		log.Errorf("Ignoring download window %q for datastore %s: %v",
+			dst.DownloadWindow, dst.Key(), err)
+	}
+	ctx.scheduleLock.Lock()
+	defer ctx.scheduleLock.Unlock()
+	return ctx.downloadWindows
+}
+
+// downloadAllowed returns whether downloads from the datastore are allowed
+// now and if not, when they will be
+func downloadAllowed(ctx *downloaderContext,
+	dst *types.DatastoreConfig) (bool, time.Time) {
+
+	sched := datastoreSchedule(ctx, dst)
+	now := time.Now()
+	if sched.Allowed(now) {
+		return true, time.Time{}
+	}
+	return false, sched.NextChange(now)
+}
+
+// rateLimiter returns the limiter to use for downloads from the datastore
+func rateLimiter(ctx *downloaderContext,
+	dst *types.DatastoreConfig) *zedUpload.RateLimiter {
+
+	ctx.scheduleLock.Lock()
+	defer ctx.scheduleLock.Unlock()
+	if dst == nil || dst.DownloadMaxBps == 0 {
+		return ctx.globalLimiter
+	}
+	limiter, ok := ctx.datastoreLimiters[dst.UUID]
+	if !ok {
+		limiter = zedUpload.NewRateLimiter(0)
+		ctx.datastoreLimiters[dst.UUID] = limiter
+	}
+	limiter.SetRate(int64(dst.DownloadMaxBps))
+	return limiter
+}
+
+// updateDatastoreLimiter applies a change of the bandwidth cap of the
+// datastore to the downloads in progress
+func updateDatastoreLimiter(ctx *downloaderContext, dst *types.DatastoreConfig) {
+	ctx.scheduleLock.Lock()
+	defer ctx.scheduleLock.Unlock()
+	if limiter, ok := ctx.datastoreLimiters[dst.UUID]; ok {
+		limiter.SetRate(int64(dst.DownloadMaxBps))
+	}
+}
+
+func deleteDatastoreLimiter(ctx *downloaderContext, dsID uuid.UUID) {
+	ctx.scheduleLock.Lock()
+	defer ctx.scheduleLock.Unlock()
+	delete(ctx.datastoreLimiters, dsID)
+}
+
+// pauseDownload marks the download as paused until resumeTime. Whatever was
+// downloaded so far is kept.
+func pauseDownload(ctx *downloaderContext, status *types.DownloaderStatus,
+	resumeTime time.Time) {
+
+	status.Paused = true
+	status.ResumeTime = resumeTime
+	status.ClearPendingStatus()
+	publishDownloaderStatus(ctx, status)
+}
+
+// checkDownloadSchedule pauses the downloads in progress whose window has
+// closed, resumes the paused ones whose window has opened, and rearms the
+// schedule timer for the next window edge.
+func checkDownloadSchedule(ctx *downloaderContext) {
+	if ctx.scheduleTimer == nil {
+		// Not yet running
+		return
+	}
+	log.Functionf("checkDownloadSchedule")
+	items := ctx.pubDownloaderStatus.GetAll()
+	for key, st := range items {
+		status := st.(types.DownloaderStatus)
+		config := lookupDownloaderConfig(ctx, key)
+		if config == nil || config.RefCount == 0 {
+			continue
+		}
+		dst, _ := utils.LookupDatastoreConfig(ctx.subDatastoreConfig,
+			status.DatastoreID)
+		allowed, _ := downloadAllowed(ctx, dst)
+		if status.Paused && allowed {
+			log.Noticef("checkDownloadSchedule(%s) resume download of %s",
+				key, status.Name)
+			dHandler.modify(ctx, key, *config)
+		} else if !status.Paused && !allowed &&
+			status.State == types.DOWNLOADING && !status.HasError() {
+			log.Noticef("checkDownloadSchedule(%s) pause download of %s",
+				key, status.Name)
+			dHandler.pause(key)
+		}
+	}
+
+	// Wake up at the next edge of any window
+	now := time.Now()
+	next := datastoreSchedule(ctx, nil).NextChange(now)
+	for _, c := range ctx.subDatastoreConfig.GetAll() {
+		dst := c.(types.DatastoreConfig)
+		if dst.DownloadWindow == "" {
+			continue
+		}
+		change := datastoreSchedule(ctx, &dst).NextChange(now)
+		if !change.IsZero() && (next.IsZero() || change.Before(next)) {
+			next = change
+		}
+	}
+	ctx.scheduleTimer.Stop()
+	if !next.IsZero() {
+		log.Functionf("checkDownloadSchedule next change at %v", next)
+		ctx.scheduleTimer.Reset(time.Until(next))
+	}
+}
@@ -22,7 +22,7 @@ import (
 // Drona APIs for object Download
 func handleSyncOp(ctx *downloaderContext, key string,
 	config types.DownloaderConfig, status *types.DownloaderStatus,
-	dst *types.DatastoreConfig, receiveChan chan<- CancelChannel) {
+	dst *types.DatastoreConfig, resume bool, receiveChan chan<- CancelChannel) {
 	var (
 		err                                                    error
 		errStr, locFilename, locDirname, remoteName, serverURL string
@@ -207,7 +207,7 @@ func handleSyncOp(ctx *downloaderContext, key string,
 		contentType, cancelled, err = download(ctx, trType, st, syncOp, serverURL, auth,
 			dsCtx.Dpath, dsCtx.Region,
 			config.Size, ifname, ipSrc, remoteName, locFilename, dst.DsCertPEM,
-			receiveChan)
+			rateLimiter(ctx, dst), resume, receiveChan)
 		if err != nil {
 			if cancelled {
 				log.Errorf("download %s cancelled", serverURL)
@@ -243,6 +243,16 @@ func handleSyncOp(ctx *downloaderContext, key string,
 	if !cancelled {
 		log.Errorf("All source IP addresses failed. All errors:%s",
 			errStr)
+	} else if allowed, resumeTime := downloadAllowed(ctx, dst); !allowed {
+		// Cancelled since the download window closed. Keep what we
+		// have so far unless the download is no longer wanted.
+		c := lookupDownloaderConfig(ctx, key)
+		if c != nil && c.RefCount != 0 {
+			log.Noticef("download of %s paused until %v",
+				config.Name, resumeTime)
+			pauseDownload(ctx, status, resumeTime)
+			return
+		}
 	}
 	handleSyncOpResponse(ctx, config, status, locFilename,
 		key, errStr, cancelled)
@@ -0,0 +1,141 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package kubenodemgr
+
+// The pods join a local network instance through the bridge CNI plugin on
+// the bridge of the network instance. zedrouter gives out the addresses of
+// the DHCP range to the domains, so the pods get theirs from the rest of
+// the subnet after it. The bridge already has the gateway address and the
+// NAT, hence isGateway and ipMasq are off.
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+
+	"github.com/lf-edge/eve/pkg/pillar/types"
+)
+
+const cniVersion = "0.4.0"
+
+type cniConflist struct {
+	CNIVersion string        `json:"cniVersion"`
+	Name       string        `json:"name"`
+	Plugins    []interface{} `json:"plugins"`
+}
+
+type cniBridge struct {
+	Type        string  `json:"type"`
+	Bridge      string  `json:"bridge"`
+	IsGateway   bool    `json:"isGateway"`
+	IPMasq      bool    `json:"ipMasq"`
+	HairpinMode bool    `json:"hairpinMode"`
+	IPAM        cniIPAM `json:"ipam"`
+}
+
+type cniIPAM struct {
+	Type    string       `json:"type"`
+	Ranges  [][]cniRange `json:"ranges"`
+	Routes  []cniRoute   `json:"routes"`
+	DataDir string       `json:"dataDir"`
+}
+
+type cniRange struct {
+	Subnet     string `json:"subnet"`
+	RangeStart string `json:"rangeStart"`
+	RangeEnd   string `json:"rangeEnd"`
+	Gateway    string `json:"gateway"`
+}
+
+type cniRoute struct {
+	Dst string `json:"dst"`
+}
+
+type cniPortmap struct {
+	Type         string          `json:"type"`
+	Capabilities map[string]bool `json:"capabilities"`
+}
+
+// podRange returns the addresses of the subnet after the DHCP range, less
+// the broadcast address
+func podRange(status types.NetworkInstanceStatus) (net.IP, net.IP, error) {
+	subnet := status.Subnet
+	base := subnet.IP.To4()
+	dhcpEnd := status.DhcpRange.End.To4()
+	if base == nil || dhcpEnd == nil || len(subnet.Mask) != net.IPv4len {
+		return nil, nil, fmt.Errorf("network instance %s has no IPv4 subnet and DHCP range",
+			status.DisplayName)
+	}
+	ones, _ := subnet.Mask.Size()
+	first := binary.BigEndian.Uint32(base)
+	last := first | (1<<(32-uint(ones)) - 1)
+	start := binary.BigEndian.Uint32(dhcpEnd) + 1
+	end := last - 1
+	if start < first || start > end {
+		return nil, nil, fmt.Errorf("network instance %s has no addresses left after its DHCP range %s-%s",
+			status.DisplayName, status.DhcpRange.Start, status.DhcpRange.End)
+	}
+	if gw := status.Gateway.To4(); gw != nil {
+		addr := binary.BigEndian.Uint32(gw)
+		if addr >= start && addr <= end {
+			return nil, nil, fmt.Errorf("network instance %s has its gateway %s after its DHCP range",
+				status.DisplayName, status.Gateway)
+		}
+	}
+	return uint32ToIP(start), uint32ToIP(end), nil
+}
+
+func uint32ToIP(addr uint32) net.IP {
+	ip := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(ip, addr)
+	return ip
+}
+
+// networkConflist returns the CNI config for pods on a local network
+// instance. ipamDir is where host-local keeps its leases.
+func networkConflist(status types.NetworkInstanceStatus, ipamDir string) ([]byte, error) {
+	if status.Type != types.NetworkInstanceTypeLocal {
+		return nil, fmt.Errorf("network instance %s is not local",
+			status.DisplayName)
+	}
+	if status.BridgeName == "" {
+		return nil, fmt.Errorf("network instance %s has no bridge",
+			status.DisplayName)
+	}
+	start, end, err := podRange(status)
+	if err != nil {
+		return nil, err
+	}
+	r := cniRange{
+		Subnet:     status.Subnet.String(),
+		RangeStart: start.String(),
+		RangeEnd:   end.String(),
+	}
+	if status.Gateway != nil {
+		r.Gateway = status.Gateway.String()
+	}
+	conflist := cniConflist{
+		CNIVersion: cniVersion,
+		Name:       "eve-" + status.UUID.String(),
+		Plugins: []interface{}{
+			cniBridge{
+				Type:        "bridge",
+				Bridge:      status.BridgeName,
+				HairpinMode: true,
+				IPAM: cniIPAM{
+					Type:    "host-local",
+					Ranges:  [][]cniRange{{r}},
+					Routes:  []cniRoute{{Dst: "0.0.0.0/0"}},
+					DataDir: ipamDir,
+				},
+			},
+			cniPortmap{
+				Type:         "portmap",
+				Capabilities: map[string]bool{"portMappings": true},
+			},
+		},
+	}
+	return json.MarshalIndent(conflist, "", "  ")
+}
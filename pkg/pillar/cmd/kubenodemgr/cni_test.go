@@ -0,0 +1,113 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package kubenodemgr
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/lf-edge/eve/pkg/pillar/types"
+	uuid "github.com/satori/go.uuid"
+)
+
+func testNetworkInstance(subnet string, dhcpEnd string, gateway string) types.NetworkInstanceStatus {
+	status := types.NetworkInstanceStatus{}
+	status.UUID = uuid.FromStringOrNil("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+	status.DisplayName = "local"
+	status.Type = types.NetworkInstanceTypeLocal
+	status.BridgeName = "bn1"
+	_, ipnet, _ := net.ParseCIDR(subnet)
+	status.Subnet = *ipnet
+	status.DhcpRange.Start = net.ParseIP("10.1.0.2")
+	status.DhcpRange.End = net.ParseIP(dhcpEnd)
+	status.Gateway = net.ParseIP(gateway)
+	return status
+}
+
+func TestNetworkConflist(t *testing.T) {
+	testMatrix := map[string]struct {
+		status     types.NetworkInstanceStatus
+		rangeStart string
+		rangeEnd   string
+		fail       bool
+	}{
+		"after the DHCP range": {
+			status:     testNetworkInstance("10.1.0.0/24", "10.1.0.127", "10.1.0.1"),
+			rangeStart: "10.1.0.128",
+			rangeEnd:   "10.1.0.254",
+		},
+		"one address left": {
+			status:     testNetworkInstance("10.1.0.0/24", "10.1.0.253", "10.1.0.1"),
+			rangeStart: "10.1.0.254",
+			rangeEnd:   "10.1.0.254",
+		},
+		"DHCP range up to the broadcast address": {
+			status: testNetworkInstance("10.1.0.0/24", "10.1.0.254", "10.1.0.1"),
+			fail:   true,
+		},
+		"gateway after the DHCP range": {
+			status: testNetworkInstance("10.1.0.0/24", "10.1.0.127", "10.1.0.254"),
+			fail:   true,
+		},
+		"IPv6": {
+			status: testNetworkInstance("fd00::/64", "fd00::ff", "fd00::1"),
+			fail:   true,
+		},
+		"switch": {
+			status: func() types.NetworkInstanceStatus {
+				status := testNetworkInstance("10.1.0.0/24", "10.1.0.127", "10.1.0.1")
+				status.Type = types.NetworkInstanceTypeSwitch
+				return status
+			}(),
+			fail: true,
+		},
+	}
+	for testname, test := range testMatrix {
+		t.Run(testname, func(t *testing.T) {
+			b, err := networkConflist(test.status, "/persist/k3s/eve/ipam")
+			if test.fail {
+				if err == nil {
+					t.Errorf("no error, got %s", b)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var conflist struct {
+				Name    string `json:"name"`
+				Plugins []struct {
+					Type      string `json:"type"`
+					Bridge    string `json:"bridge"`
+					IsGateway bool   `json:"isGateway"`
+					IPAM      struct {
+						Ranges [][]cniRange `json:"ranges"`
+					} `json:"ipam"`
+				} `json:"plugins"`
+			}
+			if err := json.Unmarshal(b, &conflist); err != nil {
+				t.Fatal(err)
+			}
+			if len(conflist.Plugins) != 2 || conflist.Plugins[0].Type != "bridge" ||
+				conflist.Plugins[1].Type != "portmap" {
+				t.Fatalf("unexpected plugins %s", b)
+			}
+			bridge := conflist.Plugins[0]
+			if bridge.Bridge != "bn1" || bridge.IsGateway {
+				t.Errorf("unexpected bridge %s", b)
+			}
+			r := bridge.IPAM.Ranges[0][0]
+			expected := cniRange{
+				Subnet:     "10.1.0.0/24",
+				RangeStart: test.rangeStart,
+				RangeEnd:   test.rangeEnd,
+				Gateway:    "10.1.0.1",
+			}
+			if r != expected {
+				t.Errorf("range %+v, expected %+v", r, expected)
+			}
+		})
+	}
+}
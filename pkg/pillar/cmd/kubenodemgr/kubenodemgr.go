@@ -0,0 +1,724 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+// Run the device as a single node Kubernetes cluster when
+// kubernetes.node.enable is set, and hand the app instances with a
+// KubeManifest to it. k3s itself is run by a service of its own which
+// follows the files written here:
+//   /run/kube/k3s-args exists while k3s should run, with its arguments
+//   /persist/k3s/eve/apps/<namespace>.yaml are the objects of each app
+//   instance, deleted with the namespace when the file is removed
+// and reports back in /run/kube/node.json and /run/kube/pods/<namespace>.json.
+
+package kubenodemgr
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/lf-edge/eve/pkg/pillar/agentlog"
+	"github.com/lf-edge/eve/pkg/pillar/base"
+	"github.com/lf-edge/eve/pkg/pillar/pidfile"
+	"github.com/lf-edge/eve/pkg/pillar/pubsub"
+	"github.com/lf-edge/eve/pkg/pillar/types"
+	fileutils "github.com/lf-edge/eve/pkg/pillar/utils/file"
+	uuid "github.com/satori/go.uuid"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	agentName = "kubenodemgr"
+	// Time limits for event loop handlers
+	errorTime            = 3 * time.Minute
+	warningTime          = 40 * time.Second
+	stillRunningInterval = 25 * time.Second
+	// How often the state written by the k3s service is read
+	stateInterval = 10 * time.Second
+	// The state of the node is stale once it is older than this
+	nodeStateTimeout = 2 * time.Minute
+
+	// kubeNodeName is the name of the node of the device
+	kubeNodeName = "eve"
+	// podNetworkFile is the CNI config of the pods, in the k3s data dir
+	podNetworkFile = "agent/etc/cni/net.d/10-eve.conflist"
+)
+
+var (
+	debug         bool
+	debugOverride bool
+	logger        *logrus.Logger
+	log           *base.LogObject
+)
+
+type kubeContext struct {
+	subGlobalConfig          pubsub.Subscription
+	subNetworkInstanceStatus pubsub.Subscription
+	subVolumeStatus          pubsub.Subscription
+	subKubeAppConfig         pubsub.Subscription
+	subAppInstanceConfig     pubsub.Subscription
+	pubKubeAppStatus         pubsub.Publication
+	pubKubeNodeStatus        pubsub.Publication
+	globalConfig             *types.ConfigItemValueMap
+	GCInitialized            bool
+	// Set once zedagent has published all AppInstanceConfig
+	appsRestarted bool
+	// When kubernetes.node.enable was last turned on
+	enabledTime time.Time
+
+	runDir  string // For the files exchanged with the k3s service
+	dataDir string // Of k3s
+}
+
+func (ctx *kubeContext) argsFile() string {
+	return filepath.Join(ctx.runDir, "k3s-args")
+}
+
+func (ctx *kubeContext) nodeStateFile() string {
+	return filepath.Join(ctx.runDir, "node.json")
+}
+
+func (ctx *kubeContext) podsStateFile(namespace string) string {
+	return filepath.Join(ctx.runDir, "pods", namespace+".json")
+}
+
+func (ctx *kubeContext) cniDir() string {
+	return filepath.Join(ctx.dataDir, "eve", "cni")
+}
+
+func (ctx *kubeContext) appsDir() string {
+	return filepath.Join(ctx.dataDir, "eve", "apps")
+}
+
+func (ctx *kubeContext) appFile(namespace string) string {
+	return filepath.Join(ctx.appsDir(), namespace+".yaml")
+}
+
+// k3sArgs returns the arguments of k3s. It uses the containerd embedded in
+// k3s since the CRI plugin of the one of EVE is disabled, and the CNI
+// config written here instead of flannel.
+func (ctx *kubeContext) k3sArgs() string {
+	return strings.Join([]string{
+		"server",
+		"--data-dir=" + ctx.dataDir,
+		"--node-name=" + kubeNodeName,
+		"--flannel-backend=none",
+		"--disable-network-policy",
+		"--disable=traefik",
+		"--disable=servicelb",
+	}, " ") + "\n"
+}
+
+// Run is the main entry point of kubenodemgr
+func Run(ps *pubsub.PubSub, loggerArg *logrus.Logger, logArg *base.LogObject) int {
+	logger = loggerArg
+	log = logArg
+	debugPtr := flag.Bool("d", false, "Debug flag")
+	flag.Parse()
+	debug = *debugPtr
+	debugOverride = debug
+	if debugOverride {
+		logger.SetLevel(logrus.TraceLevel)
+	} else {
+		logger.SetLevel(logrus.InfoLevel)
+	}
+
+	if err := pidfile.CheckAndCreatePidfile(log, agentName); err != nil {
+		log.Fatal(err)
+	}
+
+	// Run a periodic timer so we always update StillRunning
+	stillRunning := time.NewTicker(stillRunningInterval)
+	ps.StillRunning(agentName, warningTime, errorTime)
+
+	ctx := kubeContext{
+		globalConfig: types.DefaultConfigItemValueMap(),
+		runDir:       "/run/kube",
+		dataDir:      filepath.Join(types.PersistDir, "k3s"),
+	}
+	for _, dir := range []string{filepath.Join(ctx.runDir, "pods"),
+		ctx.cniDir(), ctx.appsDir(),
+		filepath.Dir(filepath.Join(ctx.dataDir, podNetworkFile))} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	pubKubeAppStatus, err := ps.NewPublication(pubsub.PublicationOptions{
+		AgentName: agentName,
+		TopicType: types.KubeAppStatus{},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx.pubKubeAppStatus = pubKubeAppStatus
+
+	pubKubeNodeStatus, err := ps.NewPublication(pubsub.PublicationOptions{
+		AgentName: agentName,
+		TopicType: types.KubeNodeStatus{},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx.pubKubeNodeStatus = pubKubeNodeStatus
+
+	// Look for global config such as log levels
+	subGlobalConfig, err := ps.NewSubscription(pubsub.SubscriptionOptions{
+		AgentName:     "zedagent",
+		MyAgentName:   agentName,
+		TopicImpl:     types.ConfigItemValueMap{},
+		Persistent:    true,
+		Activate:      false,
+		Ctx:           &ctx,
+		CreateHandler: handleGlobalConfigCreate,
+		ModifyHandler: handleGlobalConfigModify,
+		DeleteHandler: handleGlobalConfigDelete,
+		WarningTime:   warningTime,
+		ErrorTime:     errorTime,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx.subGlobalConfig = subGlobalConfig
+	subGlobalConfig.Activate()
+
+	// Pick up debug aka log level before we start real work
+	for !ctx.GCInitialized {
+		log.Functionf("waiting for GCInitialized")
+		select {
+		case change := <-subGlobalConfig.MsgChan():
+			subGlobalConfig.ProcessChange(change)
+		case <-stillRunning.C:
+		}
+		ps.StillRunning(agentName, warningTime, errorTime)
+	}
+	log.Functionf("processed GlobalConfig")
+
+	subNetworkInstanceStatus, err := ps.NewSubscription(pubsub.SubscriptionOptions{
+		AgentName:     "zedrouter",
+		MyAgentName:   agentName,
+		TopicImpl:     types.NetworkInstanceStatus{},
+		Activate:      false,
+		Ctx:           &ctx,
+		CreateHandler: handleKubeInputCreate,
+		ModifyHandler: handleKubeInputModify,
+		DeleteHandler: handleKubeInputDelete,
+		WarningTime:   warningTime,
+		ErrorTime:     errorTime,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx.subNetworkInstanceStatus = subNetworkInstanceStatus
+	subNetworkInstanceStatus.Activate()
+
+	subVolumeStatus, err := ps.NewSubscription(pubsub.SubscriptionOptions{
+		AgentName:     "volumemgr",
+		MyAgentName:   agentName,
+		TopicImpl:     types.VolumeStatus{},
+		Activate:      false,
+		Ctx:           &ctx,
+		CreateHandler: handleKubeInputCreate,
+		ModifyHandler: handleKubeInputModify,
+		DeleteHandler: handleKubeInputDelete,
+		WarningTime:   warningTime,
+		ErrorTime:     errorTime,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx.subVolumeStatus = subVolumeStatus
+	subVolumeStatus.Activate()
+
+	subKubeAppConfig, err := ps.NewSubscription(pubsub.SubscriptionOptions{
+		AgentName:     "zedmanager",
+		MyAgentName:   agentName,
+		TopicImpl:     types.KubeAppConfig{},
+		Activate:      false,
+		Ctx:           &ctx,
+		CreateHandler: handleKubeInputCreate,
+		ModifyHandler: handleKubeInputModify,
+		DeleteHandler: handleKubeInputDelete,
+		WarningTime:   warningTime,
+		ErrorTime:     errorTime,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx.subKubeAppConfig = subKubeAppConfig
+	subKubeAppConfig.Activate()
+
+	// The objects of the app instances deleted while the device was
+	// down are removed once the whole config is known
+	subAppInstanceConfig, err := ps.NewSubscription(pubsub.SubscriptionOptions{
+		AgentName:      "zedagent",
+		MyAgentName:    agentName,
+		TopicImpl:      types.AppInstanceConfig{},
+		Activate:       false,
+		Ctx:            &ctx,
+		RestartHandler: handleAppInstanceConfigRestart,
+		WarningTime:    warningTime,
+		ErrorTime:      errorTime,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx.subAppInstanceConfig = subAppInstanceConfig
+	subAppInstanceConfig.Activate()
+
+	stateTicker := time.NewTicker(stateInterval)
+	updateKube(&ctx)
+
+	for {
+		select {
+		case change := <-subGlobalConfig.MsgChan():
+			subGlobalConfig.ProcessChange(change)
+
+		case change := <-subNetworkInstanceStatus.MsgChan():
+			subNetworkInstanceStatus.ProcessChange(change)
+
+		case change := <-subVolumeStatus.MsgChan():
+			subVolumeStatus.ProcessChange(change)
+
+		case change := <-subKubeAppConfig.MsgChan():
+			subKubeAppConfig.ProcessChange(change)
+
+		case change := <-subAppInstanceConfig.MsgChan():
+			subAppInstanceConfig.ProcessChange(change)
+
+		case <-stateTicker.C:
+			start := time.Now()
+			updateKube(&ctx)
+			ps.CheckMaxTimeTopic(agentName, "updateKube", start,
+				warningTime, errorTime)
+
+		case <-stillRunning.C:
+		}
+		ps.StillRunning(agentName, warningTime, errorTime)
+	}
+}
+
+// updateKube brings the files for the k3s service and the published
+// statuses up to date with the inputs. There are only a few network
+// instances and app instances, so all of them are gone through on each
+// change.
+func updateKube(ctx *kubeContext) {
+	enabled := ctx.globalConfig.GlobalValueBool(types.KubernetesNodeEnable)
+	node := types.KubeNodeStatus{Enabled: enabled}
+	if !enabled {
+		ctx.enabledTime = time.Time{}
+	} else if ctx.enabledTime.IsZero() {
+		ctx.enabledTime = time.Now()
+	}
+	if enabled {
+		if err := writeIfChanged(ctx.argsFile(), []byte(ctx.k3sArgs())); err != nil {
+			log.Error(err)
+			node.SetErrorNow(err.Error())
+		}
+	} else {
+		removeFile(ctx.argsFile())
+	}
+	networkErrs := updateNetworkConflists(ctx)
+
+	var configs []types.KubeAppConfig
+	for _, c := range ctx.subKubeAppConfig.GetAll() {
+		configs = append(configs, c.(types.KubeAppConfig))
+	}
+	sort.Slice(configs, func(i, j int) bool {
+		return configs[i].Key() < configs[j].Key()
+	})
+	node.PodNetworkInstance = podNetwork(configs)
+	if err := updatePodNetwork(ctx, node.PodNetworkInstance, networkErrs); err != nil {
+		log.Error(err)
+		node.SetErrorNow(err.Error())
+	}
+
+	nodeRunning, nodeErr := readNodeState(ctx, &node)
+	if nodeErr != nil && enabled {
+		log.Error(nodeErr)
+		if !node.HasError() {
+			node.SetErrorNow(nodeErr.Error())
+		}
+	}
+
+	keep := make(map[string]bool)
+	for _, config := range configs {
+		keep[config.Key()] = true
+		status := updateKubeApp(ctx, config, enabled, node.PodNetworkInstance,
+			networkErrs, nodeRunning, nodeErr)
+		publishKubeAppStatus(ctx, status)
+	}
+	for _, st := range ctx.pubKubeAppStatus.GetAll() {
+		status := st.(types.KubeAppStatus)
+		if keep[status.Key()] {
+			continue
+		}
+		ns := types.KubeNamespace(status.UUIDandVersion.UUID)
+		removeFile(ctx.appFile(ns))
+		if nodeRunning && fileExists(ctx.podsStateFile(ns)) {
+			log.Functionf("updateKube: waiting for the pods of %s to go",
+				status.DisplayName)
+			continue
+		}
+		log.Functionf("updateKube: unpublish %s", status.Key())
+		ctx.pubKubeAppStatus.Unpublish(status.Key())
+	}
+	if ctx.appsRestarted {
+		removeStaleAppFiles(ctx)
+	}
+	publishKubeNodeStatus(ctx, node)
+}
+
+// readNodeState fills in the node from the state the k3s service refreshes
+// while k3s runs. It returns whether k3s runs, and an error if the state
+// can not be read or has not been refreshed for nodeStateTimeout since the
+// node was enabled.
+func readNodeState(ctx *kubeContext, node *types.KubeNodeStatus) (bool, error) {
+	file := ctx.nodeStateFile()
+	fi, err := os.Stat(file)
+	if err != nil || time.Since(fi.ModTime()) >= nodeStateTimeout {
+		if ctx.enabledTime.IsZero() ||
+			time.Since(ctx.enabledTime) < nodeStateTimeout {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("k3s is not running: no %s after %v",
+				file, nodeStateTimeout)
+		}
+		return false, fmt.Errorf("k3s is not running: %s not refreshed since %s",
+			file, fi.ModTime().UTC().Format(time.RFC3339))
+	}
+	b, err := ioutil.ReadFile(file)
+	if err == nil {
+		node.Ready, node.Version, err = parseNode(b)
+	}
+	if err != nil {
+		return true, fmt.Errorf("read %s: %v", file, err)
+	}
+	return true, nil
+}
+
+// updateNetworkConflists writes the CNI config for each local network
+// instance and returns the network instances it could not write one for
+func updateNetworkConflists(ctx *kubeContext) map[uuid.UUID]error {
+	errs := make(map[uuid.UUID]error)
+	keep := make(map[string]bool)
+	for _, st := range ctx.subNetworkInstanceStatus.GetAll() {
+		status := st.(types.NetworkInstanceStatus)
+		if status.Type != types.NetworkInstanceTypeLocal || !status.Activated {
+			continue
+		}
+		name := status.UUID.String() + ".conflist"
+		b, err := networkConflist(status,
+			filepath.Join(ctx.dataDir, "eve", "ipam"))
+		if err == nil {
+			keep[name] = true
+			err = writeIfChanged(filepath.Join(ctx.cniDir(), name), b)
+		}
+		if err != nil {
+			errs[status.UUID] = err
+		}
+	}
+	removeOtherFiles(ctx.cniDir(), keep)
+	return errs
+}
+
+// podNetwork returns the network instance of the pods. It is the one of
+// the first app instance, since the node has a single pod network.
+func podNetwork(configs []types.KubeAppConfig) uuid.UUID {
+	for _, config := range configs {
+		if config.Activate && config.NetworkInstance != nilUUID {
+			return config.NetworkInstance
+		}
+	}
+	return nilUUID
+}
+
+var nilUUID uuid.UUID
+
+// updatePodNetwork copies the CNI config of the pod network for k3s
+func updatePodNetwork(ctx *kubeContext, network uuid.UUID,
+	networkErrs map[uuid.UUID]error) error {
+
+	file := filepath.Join(ctx.dataDir, podNetworkFile)
+	if network == nilUUID {
+		removeFile(file)
+		return nil
+	}
+	if err := networkErrs[network]; err != nil {
+		return err
+	}
+	b, err := ioutil.ReadFile(filepath.Join(ctx.cniDir(), network.String()+".conflist"))
+	if err != nil {
+		return fmt.Errorf("network instance %s of the pods is not ready", network)
+	}
+	return writeIfChanged(file, b)
+}
+
+// updateKubeApp writes or removes the objects of an app instance and
+// returns its status. nodeErr is set on an activated app instance if k3s
+// does not run.
+func updateKubeApp(ctx *kubeContext, config types.KubeAppConfig,
+	enabled bool, network uuid.UUID, networkErrs map[uuid.UUID]error,
+	nodeRunning bool, nodeErr error) types.KubeAppStatus {
+
+	ns := config.Namespace()
+	status := types.KubeAppStatus{
+		UUIDandVersion: config.UUIDandVersion,
+		DisplayName:    config.DisplayName,
+		State:          types.INSTALLED,
+	}
+	if config.Activate {
+		if err := activateKubeApp(ctx, config, enabled, network, networkErrs); err != nil {
+			log.Errorf("updateKubeApp(%s): %v", config.Key(), err)
+			status.SetErrorNow(err.Error())
+		}
+	} else {
+		removeFile(ctx.appFile(ns))
+	}
+	podsFile := ctx.podsStateFile(ns)
+	status.Activated = fileExists(ctx.appFile(ns)) ||
+		(nodeRunning && fileExists(podsFile))
+	if !config.Activate || !status.Activated {
+		return status
+	}
+	status.State = types.BOOTING
+	if !nodeRunning {
+		if nodeErr != nil && !status.HasError() {
+			status.SetErrorNow(nodeErr.Error())
+		}
+		return status
+	}
+	if b, err := ioutil.ReadFile(podsFile); err == nil {
+		pods, err := parsePods(b)
+		if err != nil {
+			log.Errorf("parse %s: %v", podsFile, err)
+		} else {
+			status.Pods = pods
+			status.State = podsState(pods)
+		}
+	}
+	return status
+}
+
+// activateKubeApp writes the objects of an app instance for k3s
+func activateKubeApp(ctx *kubeContext, config types.KubeAppConfig,
+	enabled bool, network uuid.UUID, networkErrs map[uuid.UUID]error) error {
+
+	if !enabled {
+		return fmt.Errorf("%s is not set", types.KubernetesNodeEnable)
+	}
+	if config.NetworkInstance == nilUUID {
+		return fmt.Errorf("the pods need a network instance")
+	}
+	if config.NetworkInstance != network {
+		return fmt.Errorf("the pods of all app instances must use network instance %s",
+			network)
+	}
+	if err := networkErrs[network]; err != nil {
+		return err
+	}
+	var volumes []kubeVolume
+	for _, key := range config.VolumeKeys {
+		vol, err := lookupKubeVolume(ctx, key)
+		if err != nil {
+			return err
+		}
+		volumes = append(volumes, vol)
+	}
+	b, err := appManifest(config, volumes, kubeNodeName)
+	if err != nil {
+		return err
+	}
+	return writeIfChanged(ctx.appFile(config.Namespace()), b)
+}
+
+// lookupKubeVolume returns a volume which can be a local PersistentVolume
+func lookupKubeVolume(ctx *kubeContext, key string) (kubeVolume, error) {
+	st, _ := ctx.subVolumeStatus.Get(key)
+	if st == nil {
+		return kubeVolume{}, fmt.Errorf("no VolumeStatus for %s", key)
+	}
+	status := st.(types.VolumeStatus)
+	vol := kubeVolume{path: status.FileLocation, size: status.MaxVolSize}
+	fi, err := os.Stat(status.FileLocation)
+	if err != nil {
+		return vol, fmt.Errorf("volume %s: %v", status.DisplayName, err)
+	}
+	switch {
+	case fi.IsDir():
+	case fi.Mode()&os.ModeDevice != 0:
+		vol.block = true
+	default:
+		return vol, fmt.Errorf("volume %s is an image file; only directories and block devices can be persistent volumes",
+			status.DisplayName)
+	}
+	return vol, nil
+}
+
+// removeStaleAppFiles removes the objects of the app instances which are
+// no longer in the config
+func removeStaleAppFiles(ctx *kubeContext) {
+	files := make(map[string]bool)
+	for _, c := range ctx.subAppInstanceConfig.GetAll() {
+		config := c.(types.AppInstanceConfig)
+		files[types.KubeNamespace(config.UUIDandVersion.UUID)+".yaml"] = true
+	}
+	removeOtherFiles(ctx.appsDir(), files)
+}
+
+func publishKubeAppStatus(ctx *kubeContext, status types.KubeAppStatus) {
+	key := status.Key()
+	pub := ctx.pubKubeAppStatus
+	if old, _ := pub.Get(key); old != nil {
+		oldStatus := old.(types.KubeAppStatus)
+		// Keep the time of an error which persists
+		if oldStatus.Error == status.Error {
+			status.ErrorTime = oldStatus.ErrorTime
+		}
+		if cmp.Equal(oldStatus, status) {
+			return
+		}
+	}
+	log.Functionf("publishKubeAppStatus(%s) activated %t state %s",
+		key, status.Activated, status.State)
+	pub.Publish(key, status)
+}
+
+func publishKubeNodeStatus(ctx *kubeContext, status types.KubeNodeStatus) {
+	pub := ctx.pubKubeNodeStatus
+	if old, _ := pub.Get(status.Key()); old != nil {
+		oldStatus := old.(types.KubeNodeStatus)
+		if oldStatus.Error == status.Error {
+			status.ErrorTime = oldStatus.ErrorTime
+		}
+		if cmp.Equal(oldStatus, status) {
+			return
+		}
+	}
+	log.Functionf("publishKubeNodeStatus enabled %t ready %t",
+		status.Enabled, status.Ready)
+	pub.Publish(status.Key(), status)
+}
+
+// writeIfChanged replaces the file unless it already has the content, so
+// that the k3s service does not apply the same objects again
+func writeIfChanged(file string, b []byte) error {
+	if old, err := ioutil.ReadFile(file); err == nil && string(old) == string(b) {
+		return nil
+	}
+	log.Functionf("writeIfChanged: %s", file)
+	return fileutils.WriteRename(file, b)
+}
+
+func removeFile(file string) {
+	if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+		log.Error(err)
+	} else if err == nil {
+		log.Functionf("removeFile: %s", file)
+	}
+}
+
+// removeOtherFiles removes the files in dir which are not in keep
+func removeOtherFiles(dir string, keep map[string]bool) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	for _, fi := range files {
+		if !keep[fi.Name()] {
+			removeFile(filepath.Join(dir, fi.Name()))
+		}
+	}
+}
+
+func fileExists(file string) bool {
+	_, err := os.Stat(file)
+	return err == nil
+}
+
+func handleKubeInputCreate(ctxArg interface{}, key string,
+	statusArg interface{}) {
+
+	updateKube(ctxArg.(*kubeContext))
+}
+
+func handleKubeInputModify(ctxArg interface{}, key string,
+	statusArg interface{}, oldStatusArg interface{}) {
+
+	updateKube(ctxArg.(*kubeContext))
+}
+
+func handleKubeInputDelete(ctxArg interface{}, key string,
+	statusArg interface{}) {
+
+	updateKube(ctxArg.(*kubeContext))
+}
+
+func handleAppInstanceConfigRestart(ctxArg interface{}, restartCounter int) {
+
+	log.Functionf("handleAppInstanceConfigRestart(%d)", restartCounter)
+	ctx := ctxArg.(*kubeContext)
+	if restartCounter != 0 && !ctx.appsRestarted {
+		ctx.appsRestarted = true
+		updateKube(ctx)
+	}
+}
+
+func handleGlobalConfigCreate(ctxArg interface{}, key string,
+	statusArg interface{}) {
+	handleGlobalConfigImpl(ctxArg, key, statusArg)
+}
+
+func handleGlobalConfigModify(ctxArg interface{}, key string,
+	statusArg interface{}, oldStatusArg interface{}) {
+	handleGlobalConfigImpl(ctxArg, key, statusArg)
+}
+
+func handleGlobalConfigImpl(ctxArg interface{}, key string,
+	statusArg interface{}) {
+
+	ctx := ctxArg.(*kubeContext)
+	if key != "global" {
+		log.Functionf("handleGlobalConfigImpl: ignoring %s", key)
+		return
+	}
+	log.Functionf("handleGlobalConfigImpl for %s", key)
+	var gcp *types.ConfigItemValueMap
+	debug, gcp = agentlog.HandleGlobalConfig(log, ctx.subGlobalConfig, agentName,
+		debugOverride, logger)
+	if gcp != nil {
+		ctx.globalConfig = gcp
+		ctx.GCInitialized = true
+	}
+	// The other subscriptions are created once this is initialized
+	if ctx.subKubeAppConfig != nil {
+		updateKube(ctx)
+	}
+	log.Functionf("handleGlobalConfigImpl done for %s", key)
+}
+
+func handleGlobalConfigDelete(ctxArg interface{}, key string,
+	statusArg interface{}) {
+
+	ctx := ctxArg.(*kubeContext)
+	if key != "global" {
+		log.Functionf("handleGlobalConfigDelete: ignoring %s", key)
+		return
+	}
+	log.Functionf("handleGlobalConfigDelete for %s", key)
+	debug, _ = agentlog.HandleGlobalConfig(log, ctx.subGlobalConfig, agentName,
+		debugOverride, logger)
+	ctx.globalConfig = types.DefaultConfigItemValueMap()
+	if ctx.subKubeAppConfig != nil {
+		updateKube(ctx)
+	}
+	log.Functionf("handleGlobalConfigDelete done for %s", key)
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package kubenodemgr
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lf-edge/eve/pkg/pillar/types"
+)
+
+func TestReadNodeState(t *testing.T) {
+	ready := `{"status": {"conditions": [{"type": "Ready", "status": "True"}],
+		"nodeInfo": {"kubeletVersion": "v1.21.1+k3s1"}}}`
+	testMatrix := map[string]struct {
+		enabledFor time.Duration // Zero if not enabled
+		node       string        // Not written if empty
+		age        time.Duration
+		running    bool
+		ready      bool
+		err        string
+	}{
+		"just enabled": {
+			enabledFor: time.Second,
+		},
+		"not enabled": {},
+		"never started": {
+			enabledFor: 3 * time.Minute,
+			err:        "k3s is not running: no ",
+		},
+		"stale": {
+			enabledFor: time.Hour,
+			node:       ready,
+			age:        5 * time.Minute,
+			err:        "not refreshed since",
+		},
+		"stale just after enabled again": {
+			enabledFor: time.Second,
+			node:       ready,
+			age:        5 * time.Minute,
+		},
+		"ready": {
+			enabledFor: time.Hour,
+			node:       ready,
+			running:    true,
+			ready:      true,
+		},
+		"garbage": {
+			enabledFor: time.Hour,
+			node:       `{"status": `,
+			running:    true,
+			err:        "node.json",
+		},
+	}
+	for testname, test := range testMatrix {
+		t.Run(testname, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "kubenodemgr")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			ctx := kubeContext{runDir: dir}
+			if test.enabledFor != 0 {
+				ctx.enabledTime = time.Now().Add(-test.enabledFor)
+			}
+			if test.node != "" {
+				file := ctx.nodeStateFile()
+				if err := ioutil.WriteFile(file, []byte(test.node), 0644); err != nil {
+					t.Fatal(err)
+				}
+				mtime := time.Now().Add(-test.age)
+				if err := os.Chtimes(file, mtime, mtime); err != nil {
+					t.Fatal(err)
+				}
+			}
+			node := types.KubeNodeStatus{}
+			running, err := readNodeState(&ctx, &node)
+			if running != test.running || node.Ready != test.ready {
+				t.Errorf("got running %t ready %t, expected %t %t",
+					running, node.Ready, test.running, test.ready)
+			}
+			switch {
+			case test.err == "" && err != nil:
+				t.Errorf("unexpected error %v", err)
+			case test.err != "" && err == nil:
+				t.Errorf("no error")
+			case test.err != "" && !strings.Contains(err.Error(), test.err):
+				t.Errorf("error %q does not have %q", err, test.err)
+			}
+		})
+	}
+}
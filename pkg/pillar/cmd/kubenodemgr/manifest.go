@@ -0,0 +1,157 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package kubenodemgr
+
+// The objects of an app instance go to k3s as one file: its namespace,
+// a local PersistentVolume and a PersistentVolumeClaim bound to it for
+// each of its volumes, then the manifest from the controller. The
+// generated objects are JSON documents, which are valid YAML.
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/lf-edge/eve/pkg/pillar/types"
+)
+
+// appLabel marks the cluster wide objects of an app instance, so that
+// they can be deleted with its namespace
+const appLabel = "eve.lfedge.org/app"
+
+// kubeVolume is a volume of an app instance as found on the device
+type kubeVolume struct {
+	path  string
+	size  uint64 // Bytes
+	block bool   // A block device; otherwise a directory
+}
+
+type kubeMeta struct {
+	Name      string            `json:"name"`
+	Namespace string            `json:"namespace,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+}
+
+type kubeObject struct {
+	APIVersion string      `json:"apiVersion"`
+	Kind       string      `json:"kind"`
+	Metadata   kubeMeta    `json:"metadata"`
+	Spec       interface{} `json:"spec,omitempty"`
+}
+
+type pvSpec struct {
+	Capacity                      map[string]string `json:"capacity"`
+	VolumeMode                    string            `json:"volumeMode"`
+	AccessModes                   []string          `json:"accessModes"`
+	PersistentVolumeReclaimPolicy string            `json:"persistentVolumeReclaimPolicy"`
+	StorageClassName              string            `json:"storageClassName"`
+	Local                         map[string]string `json:"local"`
+	NodeAffinity                  interface{}       `json:"nodeAffinity"`
+}
+
+type pvcSpec struct {
+	VolumeMode       string                 `json:"volumeMode"`
+	AccessModes      []string               `json:"accessModes"`
+	StorageClassName string                 `json:"storageClassName"`
+	VolumeName       string                 `json:"volumeName"`
+	Resources        map[string]interface{} `json:"resources"`
+}
+
+// nodeAffinity pins a local volume to the node of the device
+func nodeAffinity(nodeName string) interface{} {
+	return map[string]interface{}{
+		"required": map[string]interface{}{
+			"nodeSelectorTerms": []interface{}{
+				map[string]interface{}{
+					"matchExpressions": []interface{}{
+						map[string]interface{}{
+							"key":      "kubernetes.io/hostname",
+							"operator": "In",
+							"values":   []string{nodeName},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// pvName returns the name of the PersistentVolume of the volume with index
+// i in VolumeKeys
+func pvName(namespace string, i int) string {
+	return fmt.Sprintf("%s-volume-%d", namespace, i)
+}
+
+// pvcName returns the name the manifest refers to the volume with index i
+// in VolumeKeys by
+func pvcName(i int) string {
+	return fmt.Sprintf("volume-%d", i)
+}
+
+// appManifest returns the objects of the app instance for k3s
+func appManifest(config types.KubeAppConfig, volumes []kubeVolume,
+	nodeName string) ([]byte, error) {
+
+	ns := config.Namespace()
+	labels := map[string]string{appLabel: ns}
+	objects := []kubeObject{{
+		APIVersion: "v1",
+		Kind:       "Namespace",
+		Metadata:   kubeMeta{Name: ns, Labels: labels},
+	}}
+	for i, vol := range volumes {
+		mode := "Filesystem"
+		if vol.block {
+			mode = "Block"
+		}
+		// Kubernetes needs a size, which a volume without a limit has not
+		size := "1"
+		if vol.size != 0 {
+			size = fmt.Sprintf("%d", vol.size)
+		}
+		objects = append(objects, kubeObject{
+			APIVersion: "v1",
+			Kind:       "PersistentVolume",
+			Metadata:   kubeMeta{Name: pvName(ns, i), Labels: labels},
+			Spec: pvSpec{
+				Capacity:                      map[string]string{"storage": size},
+				VolumeMode:                    mode,
+				AccessModes:                   []string{"ReadWriteOnce"},
+				PersistentVolumeReclaimPolicy: "Retain",
+				StorageClassName:              "",
+				Local:                         map[string]string{"path": vol.path},
+				NodeAffinity:                  nodeAffinity(nodeName),
+			},
+		}, kubeObject{
+			APIVersion: "v1",
+			Kind:       "PersistentVolumeClaim",
+			Metadata:   kubeMeta{Name: pvcName(i), Namespace: ns},
+			Spec: pvcSpec{
+				VolumeMode:       mode,
+				AccessModes:      []string{"ReadWriteOnce"},
+				StorageClassName: "",
+				VolumeName:       pvName(ns, i),
+				Resources: map[string]interface{}{
+					"requests": map[string]string{"storage": size},
+				},
+			},
+		})
+	}
+	var docs []string
+	for _, obj := range objects {
+		b, err := json.Marshal(obj)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, string(b))
+	}
+	manifest := strings.TrimSpace(config.Manifest)
+	manifest = strings.TrimPrefix(manifest, "---")
+	if strings.TrimSpace(manifest) == "" {
+		return nil, fmt.Errorf("app instance %s has an empty manifest",
+			config.DisplayName)
+	}
+	docs = append(docs, strings.TrimSpace(manifest))
+	return []byte(strings.Join(docs, "\n---\n") + "\n"), nil
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package kubenodemgr
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/lf-edge/eve/pkg/pillar/types"
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestAppManifest(t *testing.T) {
+	config := types.KubeAppConfig{
+		DisplayName: "web",
+		Manifest:    "---\napiVersion: v1\nkind: Pod\nmetadata:\n  name: web\n",
+	}
+	config.UUIDandVersion.UUID = uuid.FromStringOrNil("6ba7b811-9dad-11d1-80b4-00c04fd430c8")
+	ns := "eve-6ba7b811-9dad-11d1-80b4-00c04fd430c8"
+	volumes := []kubeVolume{
+		{path: "/persist/vault/volumes/a.container", size: 0},
+		{path: "/dev/zvol/persist/vault/volumes/b", size: 1 << 30, block: true},
+	}
+	b, err := appManifest(config, volumes, "eve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	docs := strings.Split(string(b), "\n---\n")
+	if len(docs) != 6 {
+		t.Fatalf("expected 6 documents, got %d: %s", len(docs), b)
+	}
+	if docs[5] != "apiVersion: v1\nkind: Pod\nmetadata:\n  name: web\n" {
+		t.Errorf("manifest not last: %q", docs[5])
+	}
+	expected := []struct {
+		kind      string
+		name      string
+		namespace string
+		mode      string
+		size      string
+		path      string
+	}{
+		{kind: "Namespace", name: ns},
+		{kind: "PersistentVolume", name: ns + "-volume-0", mode: "Filesystem",
+			size: "1", path: "/persist/vault/volumes/a.container"},
+		{kind: "PersistentVolumeClaim", name: "volume-0", namespace: ns,
+			mode: "Filesystem", size: "1"},
+		{kind: "PersistentVolume", name: ns + "-volume-1", mode: "Block",
+			size: "1073741824", path: "/dev/zvol/persist/vault/volumes/b"},
+		{kind: "PersistentVolumeClaim", name: "volume-1", namespace: ns,
+			mode: "Block", size: "1073741824"},
+	}
+	for i, exp := range expected {
+		var obj struct {
+			Kind     string `json:"kind"`
+			Metadata struct {
+				Name      string            `json:"name"`
+				Namespace string            `json:"namespace"`
+				Labels    map[string]string `json:"labels"`
+			} `json:"metadata"`
+			Spec struct {
+				VolumeMode string            `json:"volumeMode"`
+				Capacity   map[string]string `json:"capacity"`
+				Local      map[string]string `json:"local"`
+				VolumeName string            `json:"volumeName"`
+				Resources  struct {
+					Requests map[string]string `json:"requests"`
+				} `json:"resources"`
+			} `json:"spec"`
+		}
+		if err := json.Unmarshal([]byte(docs[i]), &obj); err != nil {
+			t.Fatalf("document %d: %v", i, err)
+		}
+		if obj.Kind != exp.kind || obj.Metadata.Name != exp.name ||
+			obj.Metadata.Namespace != exp.namespace ||
+			obj.Spec.VolumeMode != exp.mode {
+			t.Errorf("document %d is %s", i, docs[i])
+		}
+		switch obj.Kind {
+		case "Namespace":
+			if obj.Metadata.Labels[appLabel] != ns {
+				t.Errorf("namespace not labelled: %s", docs[i])
+			}
+		case "PersistentVolume":
+			if obj.Spec.Capacity["storage"] != exp.size ||
+				obj.Spec.Local["path"] != exp.path ||
+				obj.Metadata.Labels[appLabel] != ns {
+				t.Errorf("unexpected volume %s", docs[i])
+			}
+		case "PersistentVolumeClaim":
+			if obj.Spec.Resources.Requests["storage"] != exp.size ||
+				obj.Spec.VolumeName != expected[i-1].name {
+				t.Errorf("unexpected claim %s", docs[i])
+			}
+		}
+	}
+
+	config.Manifest = "---\n"
+	if b, err := appManifest(config, nil, "eve"); err == nil {
+		t.Errorf("no error for an empty manifest, got %s", b)
+	}
+}
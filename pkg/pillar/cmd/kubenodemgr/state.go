@@ -0,0 +1,102 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package kubenodemgr
+
+// The k3s service writes what kubectl reports about the node and the pods
+// of each app instance as JSON. These are read here, without the
+// Kubernetes client, into the fields kubenodemgr publishes.
+
+import (
+	"encoding/json"
+
+	"github.com/lf-edge/eve/pkg/pillar/types"
+)
+
+type kubeCondition struct {
+	Type   string `json:"type"`
+	Status string `json:"status"`
+}
+
+type kubeNode struct {
+	Status struct {
+		Conditions []kubeCondition `json:"conditions"`
+		NodeInfo   struct {
+			KubeletVersion string `json:"kubeletVersion"`
+		} `json:"nodeInfo"`
+	} `json:"status"`
+}
+
+type kubePodList struct {
+	Items []struct {
+		Metadata struct {
+			Name string `json:"name"`
+		} `json:"metadata"`
+		Status struct {
+			Phase             string `json:"phase"`
+			ContainerStatuses []struct {
+				Ready        bool `json:"ready"`
+				RestartCount int  `json:"restartCount"`
+			} `json:"containerStatuses"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+// parseNode returns whether the node is ready and its kubelet version from
+// the output of kubectl get node -o json
+func parseNode(b []byte) (bool, string, error) {
+	var node kubeNode
+	if err := json.Unmarshal(b, &node); err != nil {
+		return false, "", err
+	}
+	ready := false
+	for _, cond := range node.Status.Conditions {
+		if cond.Type == "Ready" {
+			ready = cond.Status == "True"
+		}
+	}
+	return ready, node.Status.NodeInfo.KubeletVersion, nil
+}
+
+// parsePods returns the pods from the output of kubectl get pods -o json
+func parsePods(b []byte) ([]types.KubePodStatus, error) {
+	var list kubePodList
+	if err := json.Unmarshal(b, &list); err != nil {
+		return nil, err
+	}
+	var pods []types.KubePodStatus
+	for _, item := range list.Items {
+		pod := types.KubePodStatus{
+			Name:  item.Metadata.Name,
+			Phase: item.Status.Phase,
+			Ready: len(item.Status.ContainerStatuses) != 0,
+		}
+		for _, cs := range item.Status.ContainerStatuses {
+			pod.Ready = pod.Ready && cs.Ready
+			pod.Restarts += cs.RestartCount
+		}
+		pods = append(pods, pod)
+	}
+	return pods, nil
+}
+
+// podsState returns the state of an app instance with the given pods. It
+// is RUNNING once all of them are ready or have completed, and BROKEN if
+// one failed.
+func podsState(pods []types.KubePodStatus) types.SwState {
+	if len(pods) == 0 {
+		return types.BOOTING
+	}
+	state := types.RUNNING
+	for _, pod := range pods {
+		switch {
+		case pod.Phase == "Failed":
+			return types.BROKEN
+		case pod.Phase == "Succeeded":
+		case pod.Phase == "Running" && pod.Ready:
+		default:
+			state = types.BOOTING
+		}
+	}
+	return state
+}
@@ -0,0 +1,125 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package kubenodemgr
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/lf-edge/eve/pkg/pillar/types"
+)
+
+func TestParseNode(t *testing.T) {
+	testMatrix := map[string]struct {
+		node    string
+		ready   bool
+		version string
+		fail    bool
+	}{
+		"ready": {
+			node: `{"status": {"conditions": [{"type": "MemoryPressure", "status": "False"},
+				{"type": "Ready", "status": "True"}],
+				"nodeInfo": {"kubeletVersion": "v1.21.1+k3s1"}}}`,
+			ready:   true,
+			version: "v1.21.1+k3s1",
+		},
+		"not ready": {
+			node: `{"status": {"conditions": [{"type": "Ready", "status": "False"}],
+				"nodeInfo": {"kubeletVersion": "v1.21.1+k3s1"}}}`,
+			version: "v1.21.1+k3s1",
+		},
+		"no conditions": {
+			node: `{"status": {}}`,
+		},
+		"garbage": {
+			node: `{"status": `,
+			fail: true,
+		},
+	}
+	for testname, test := range testMatrix {
+		t.Run(testname, func(t *testing.T) {
+			ready, version, err := parseNode([]byte(test.node))
+			if test.fail {
+				if err == nil {
+					t.Error("no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ready != test.ready || version != test.version {
+				t.Errorf("got %t %s, expected %t %s", ready, version,
+					test.ready, test.version)
+			}
+		})
+	}
+}
+
+func TestPodsState(t *testing.T) {
+	testMatrix := map[string]struct {
+		pods     string
+		expected []types.KubePodStatus
+		state    types.SwState
+	}{
+		"no pods yet": {
+			pods:  `{"items": []}`,
+			state: types.BOOTING,
+		},
+		"all ready": {
+			pods: `{"items": [
+				{"metadata": {"name": "web"}, "status": {"phase": "Running",
+					"containerStatuses": [{"ready": true, "restartCount": 1},
+						{"ready": true, "restartCount": 2}]}},
+				{"metadata": {"name": "setup"}, "status": {"phase": "Succeeded",
+					"containerStatuses": [{"ready": false}]}}]}`,
+			expected: []types.KubePodStatus{
+				{Name: "web", Phase: "Running", Ready: true, Restarts: 3},
+				{Name: "setup", Phase: "Succeeded"},
+			},
+			state: types.RUNNING,
+		},
+		"container not ready": {
+			pods: `{"items": [
+				{"metadata": {"name": "web"}, "status": {"phase": "Running",
+					"containerStatuses": [{"ready": true}, {"ready": false}]}}]}`,
+			expected: []types.KubePodStatus{
+				{Name: "web", Phase: "Running"},
+			},
+			state: types.BOOTING,
+		},
+		"pending": {
+			pods: `{"items": [
+				{"metadata": {"name": "web"}, "status": {"phase": "Pending"}}]}`,
+			expected: []types.KubePodStatus{
+				{Name: "web", Phase: "Pending"},
+			},
+			state: types.BOOTING,
+		},
+		"failed": {
+			pods: `{"items": [
+				{"metadata": {"name": "web"}, "status": {"phase": "Pending"}},
+				{"metadata": {"name": "db"}, "status": {"phase": "Failed"}}]}`,
+			expected: []types.KubePodStatus{
+				{Name: "web", Phase: "Pending"},
+				{Name: "db", Phase: "Failed"},
+			},
+			state: types.BROKEN,
+		},
+	}
+	for testname, test := range testMatrix {
+		t.Run(testname, func(t *testing.T) {
+			pods, err := parsePods([]byte(test.pods))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.expected, pods); diff != "" {
+				t.Errorf("unexpected pods: %s", diff)
+			}
+			if state := podsState(pods); state != test.state {
+				t.Errorf("state %s, expected %s", state, test.state)
+			}
+		})
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package uploader
+
+import (
+	"context"
+	"time"
+
+	"github.com/lf-edge/eve/pkg/pillar/types"
+)
+
+func handleUploaderConfigCreate(ctxArg interface{}, key string,
+	configArg interface{}) {
+
+	ctx := ctxArg.(*uploaderContext)
+	config := configArg.(types.UploaderConfig)
+	log.Functionf("handleUploaderConfigCreate(%s) %s to %s",
+		key, config.LocalPath, config.RemoteName)
+	updateUpload(ctx, key)
+	log.Functionf("handleUploaderConfigCreate(%s) done", key)
+}
+
+func handleUploaderConfigModify(ctxArg interface{}, key string,
+	configArg interface{}, oldConfigArg interface{}) {
+
+	ctx := ctxArg.(*uploaderContext)
+	config := configArg.(types.UploaderConfig)
+	oldConfig := oldConfigArg.(types.UploaderConfig)
+	log.Functionf("handleUploaderConfigModify(%s) %s to %s",
+		key, config.LocalPath, config.RemoteName)
+	if config == oldConfig {
+		log.Functionf("handleUploaderConfigModify(%s) no change", key)
+		return
+	}
+	// Any change means we need to upload again. If the upload is in
+	// progress we restart once the goroutine reports that it is done.
+	if cancel, ok := ctx.inprogress[key]; ok {
+		log.Noticef("handleUploaderConfigModify(%s) cancel upload", key)
+		cancel()
+		return
+	}
+	updateUpload(ctx, key)
+	log.Functionf("handleUploaderConfigModify(%s) done", key)
+}
+
+func handleUploaderConfigDelete(ctxArg interface{}, key string,
+	configArg interface{}) {
+
+	ctx := ctxArg.(*uploaderContext)
+	log.Functionf("handleUploaderConfigDelete(%s)", key)
+	if cancel, ok := ctx.inprogress[key]; ok {
+		log.Noticef("handleUploaderConfigDelete(%s) cancel upload", key)
+		cancel()
+		return
+	}
+	updateUpload(ctx, key)
+	log.Functionf("handleUploaderConfigDelete(%s) done", key)
+}
+
+// handleUploaderConfigSynchronized removes the persisted status for
+// uploads which were deleted while we were not running
+func handleUploaderConfigSynchronized(ctxArg interface{}, synchronized bool) {
+
+	ctx := ctxArg.(*uploaderContext)
+	log.Functionf("handleUploaderConfigSynchronized(%v)", synchronized)
+	if !synchronized {
+		return
+	}
+	items := ctx.pubUploaderStatus.GetAll()
+	for key := range items {
+		updateUpload(ctx, key)
+	}
+}
+
+func lookupUploaderConfig(ctx *uploaderContext, key string) *types.UploaderConfig {
+	c, _ := ctx.subTmpUploaderConfig.Get(key)
+	if c == nil {
+		return nil
+	}
+	config := c.(types.UploaderConfig)
+	return &config
+}
+
+func lookupUploaderStatus(ctx *uploaderContext, key string) *types.UploaderStatus {
+	s, _ := ctx.pubUploaderStatus.Get(key)
+	if s == nil {
+		return nil
+	}
+	status := s.(types.UploaderStatus)
+	return &status
+}
+
+func publishUploaderStatus(ctx *uploaderContext, status *types.UploaderStatus) {
+	key := status.Key()
+	log.Tracef("publishUploaderStatus(%s)", key)
+	ctx.pubUploaderStatus.Publish(key, *status)
+}
+
+func unpublishUploaderStatus(ctx *uploaderContext, key string) {
+	log.Tracef("unpublishUploaderStatus(%s)", key)
+	ctx.pubUploaderStatus.Unpublish(key)
+}
+
+// updateUpload compares the config and status for the key and starts,
+// resumes, retries or forgets the upload. It must not be called while
+// an upload goroutine exists for the key.
+func updateUpload(ctx *uploaderContext, key string) {
+	if _, ok := ctx.inprogress[key]; ok {
+		log.Functionf("updateUpload(%s) upload in progress", key)
+		return
+	}
+	config := lookupUploaderConfig(ctx, key)
+	status := lookupUploaderStatus(ctx, key)
+	if config == nil {
+		if status != nil {
+			log.Functionf("updateUpload(%s) no config", key)
+			unpublishUploaderStatus(ctx, key)
+		}
+		return
+	}
+	if status == nil || status.Sequence != config.Sequence ||
+		status.LocalPath != config.LocalPath ||
+		status.RemoteName != config.RemoteName ||
+		status.DatastoreID != config.DatastoreID {
+
+		log.Noticef("updateUpload(%s) new upload of %s to %s",
+			key, config.LocalPath, config.RemoteName)
+		status = &types.UploaderStatus{
+			UUID:        config.UUID,
+			DatastoreID: config.DatastoreID,
+			LocalPath:   config.LocalPath,
+			RemoteName:  config.RemoteName,
+			Sequence:    config.Sequence,
+			State:       types.UploadStatePending,
+		}
+		publishUploaderStatus(ctx, status)
+	}
+	switch status.State {
+	case types.UploadStateUploaded:
+		log.Functionf("updateUpload(%s) already uploaded", key)
+		return
+	case types.UploadStateFailed:
+		if !retryDue(ctx, *status) {
+			log.Functionf("updateUpload(%s) retry not due", key)
+			return
+		}
+	}
+	startUpload(ctx, *config, *status)
+}
+
+// retryDue returns true when it is time to retry a failed upload
+func retryDue(ctx *uploaderContext, status types.UploaderStatus) bool {
+	if ctx.maxRetries != 0 && status.RetryCount >= ctx.maxRetries {
+		return false
+	}
+	return time.Since(status.ErrorTime) >= ctx.retryTime
+}
+
+// retryUploads is called periodically to retry failed uploads
+func retryUploads(ctx *uploaderContext) {
+	items := ctx.pubUploaderStatus.GetAll()
+	for key, item := range items {
+		status := item.(types.UploaderStatus)
+		if status.State != types.UploadStateFailed {
+			continue
+		}
+		if _, ok := ctx.inprogress[key]; ok {
+			continue
+		}
+		if retryDue(ctx, status) {
+			log.Noticef("retryUploads(%s) retry %d",
+				key, status.RetryCount)
+			updateUpload(ctx, key)
+		}
+	}
+}
+
+// startUpload runs the upload in a goroutine which owns the status
+// until it reports on doneChan
+func startUpload(ctx *uploaderContext, config types.UploaderConfig,
+	status types.UploaderStatus) {
+
+	key := status.Key()
+	cancelCtx, cancel := context.WithCancel(context.Background())
+	ctx.inprogress[key] = cancel
+	log.Functionf("startUpload(%s) from %d parts", key, len(status.Parts))
+	go func() {
+		runUpload(ctx, cancelCtx, config, status)
+		cancel()
+		ctx.doneChan <- key
+	}()
+}
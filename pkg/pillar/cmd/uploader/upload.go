@@ -0,0 +1,449 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package uploader
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	zconfig "github.com/lf-edge/eve/api/go/config"
+	"github.com/lf-edge/eve/libs/zedUpload"
+	"github.com/lf-edge/eve/pkg/pillar/cipher"
+	"github.com/lf-edge/eve/pkg/pillar/types"
+	"github.com/lf-edge/eve/pkg/pillar/zedcloud"
+	uuid "github.com/satori/go.uuid"
+)
+
+// runUpload performs the upload and publishes the resulting status
+func runUpload(ctx *uploaderContext, cancelCtx context.Context,
+	config types.UploaderConfig, status types.UploaderStatus) {
+
+	key := status.Key()
+	status.State = types.UploadStateUploading
+	publishUploaderStatus(ctx, &status)
+
+	err := doUpload(ctx, cancelCtx, &status)
+	if err != nil {
+		if cancelCtx.Err() != nil {
+			log.Noticef("runUpload(%s) cancelled", key)
+			status.State = types.UploadStatePending
+			publishUploaderStatus(ctx, &status)
+			return
+		}
+		status.RetryCount++
+		status.State = types.UploadStateFailed
+		errStr := err.Error()
+		if ctx.maxRetries == 0 || status.RetryCount < ctx.maxRetries {
+			errStr = fmt.Sprintf("Will retry in %v: %s",
+				ctx.retryTime, errStr)
+		}
+		status.SetErrorNow(errStr)
+		publishUploaderStatus(ctx, &status)
+		log.Errorf("runUpload(%s) failed: %s", key, errStr)
+		return
+	}
+	log.Noticef("runUpload(%s) uploaded %s to %s", key,
+		status.LocalPath, status.RemoteName)
+	status.State = types.UploadStateUploaded
+	status.CurrentSize = status.TotalSize
+	status.Progress = 100
+	status.ModTime = time.Now()
+	status.ClearMultipart()
+	status.ClearError()
+	publishUploaderStatus(ctx, &status)
+	if config.DeleteLocal {
+		if err := os.Remove(status.LocalPath); err != nil {
+			log.Errorf("runUpload(%s) remove failed: %s", key, err)
+		}
+	}
+}
+
+// doUpload tries all the management ports until a success
+func doUpload(ctx *uploaderContext, cancelCtx context.Context,
+	status *types.UploaderStatus) error {
+
+	dst := lookupDatastoreConfig(ctx, status.DatastoreID)
+	if dst == nil {
+		return fmt.Errorf("datastore %s not found", status.DatastoreID)
+	}
+	info, err := os.Stat(status.LocalPath)
+	if err != nil {
+		return err
+	}
+	if status.TotalSize != info.Size() {
+		// File changed since we started a multipart upload
+		status.ClearMultipart()
+		status.CurrentSize = 0
+		status.Progress = 0
+		status.TotalSize = info.Size()
+	}
+	decBlock, err := getDatastoreCredential(ctx, *dst)
+	if err != nil {
+		return err
+	}
+
+	var trType zedUpload.SyncTransportType
+	var auth *zedUpload.AuthInput
+	var metricsURL string
+	switch dst.DsType {
+	case zconfig.DsType_DsS3.String():
+		trType = zedUpload.SyncAwsTr
+		auth = &zedUpload.AuthInput{
+			AuthType: "s3",
+			Uname:    decBlock.DsAPIKey,
+			Password: decBlock.DsPassword,
+		}
+		metricsURL = fmt.Sprintf("S3:%s/%s", dst.Dpath, status.RemoteName)
+	case zconfig.DsType_DsAzureBlob.String():
+		trType = zedUpload.SyncAzureTr
+		auth = &zedUpload.AuthInput{
+			AuthType: "password",
+			Uname:    decBlock.DsAPIKey,
+			Password: decBlock.DsPassword,
+		}
+		metricsURL = fmt.Sprintf("Azure:%s/%s", dst.Dpath, status.RemoteName)
+	case zconfig.DsType_DsSFTP.String():
+		trType = zedUpload.SyncSftpTr
+		auth = &zedUpload.AuthInput{
+			AuthType: "sftp",
+			Uname:    decBlock.DsAPIKey,
+			Password: decBlock.DsPassword,
+		}
+		metricsURL = fmt.Sprintf("sftp://%s/%s/%s", dst.Fqdn, dst.Dpath,
+			status.RemoteName)
+	default:
+		return fmt.Errorf("upload not supported for datastore type %s",
+			dst.DsType)
+	}
+
+	addrCount := types.CountLocalAddrNoLinkLocalWithCost(ctx.deviceNetworkStatus,
+		ctx.maxPortCost)
+	if addrCount == 0 {
+		return fmt.Errorf("No IP management port addresses with cost <= %d",
+			ctx.maxPortCost)
+	}
+	var errStr string
+	for addrIndex := 0; addrIndex < addrCount; addrIndex++ {
+		ipSrc, err := types.GetLocalAddrNoLinkLocalWithCost(ctx.deviceNetworkStatus,
+			addrIndex, "", ctx.maxPortCost)
+		if err != nil {
+			log.Errorf("GetLocalAddr failed: %s", err)
+			errStr = errStr + "\n" + err.Error()
+			continue
+		}
+		ifname := types.GetMgmtPortFromAddr(ctx.deviceNetworkStatus, ipSrc)
+		log.Functionf("Uploading %s to %s using IP source %v if %s transport %v",
+			status.LocalPath, metricsURL, ipSrc, ifname, trType)
+		dEndPoint, err := newEndPoint(ctx, trType, *dst, auth, ifname,
+			ipSrc, metricsURL)
+		if err != nil {
+			log.Errorf("newEndPoint failed: %s", err)
+			errStr = errStr + "\n" + err.Error()
+			continue
+		}
+		startSize := status.CurrentSize
+		uploadStartTime := time.Now()
+		if useMultipart(trType, status.TotalSize) {
+			err = uploadParts(ctx, cancelCtx, dEndPoint, trType, status)
+		} else {
+			err = uploadFile(ctx, cancelCtx, dEndPoint, status)
+		}
+		if err != nil {
+			if cancelCtx.Err() != nil {
+				return err
+			}
+			log.Errorf("Source IP %s failed: %s", ipSrc, err)
+			zedcloud.ZedCloudFailure(log, ifname, metricsURL, 1024, 0, false)
+			errStr = errStr + "\n" + err.Error()
+			continue
+		}
+		uploadTime := int64(time.Since(uploadStartTime) / time.Millisecond)
+		zedcloud.ZedCloudSuccess(log, ifname, metricsURL,
+			status.TotalSize-startSize, 1024, uploadTime)
+		return nil
+	}
+	return fmt.Errorf("All source IP addresses failed. All errors:%s", errStr)
+}
+
+// useMultipart returns true if the file is large enough to be sent
+// in parts and the datastore lets us resume from the uploaded parts
+func useMultipart(trType zedUpload.SyncTransportType, size int64) bool {
+	switch trType {
+	case zedUpload.SyncAwsTr, zedUpload.SyncAzureTr:
+		return size > uploadPartSize
+	default:
+		return false
+	}
+}
+
+// s3Endpoint returns the endpoint of an S3-compatible object store.
+// An empty string means AWS itself.
+func s3Endpoint(fqdn string) string {
+	if fqdn == "" {
+		return ""
+	}
+	host := fqdn
+	if i := strings.Index(host, "://"); i >= 0 {
+		host = host[i+3:]
+	}
+	host = strings.SplitN(host, "/", 2)[0]
+	if host == "amazonaws.com" || strings.HasSuffix(host, ".amazonaws.com") {
+		return ""
+	}
+	if !strings.Contains(fqdn, "://") {
+		return "https://" + fqdn
+	}
+	return fqdn
+}
+
+func newEndPoint(ctx *uploaderContext, trType zedUpload.SyncTransportType,
+	dst types.DatastoreConfig, auth *zedUpload.AuthInput, ifname string,
+	ipSrc net.IP, serverURL string) (zedUpload.DronaEndPoint, error) {
+
+	var dEndPoint zedUpload.DronaEndPoint
+	var err error
+	switch trType {
+	case zedUpload.SyncSftpTr:
+		dEndPoint, err = ctx.dCtx.NewSyncerDest(trType, dst.Fqdn, dst.Dpath, auth)
+	case zedUpload.SyncAzureTr:
+		dEndPoint, err = ctx.dCtx.NewSyncerDest(trType, "", dst.Dpath, auth)
+	case zedUpload.SyncAwsTr:
+		dEndPoint, err = ctx.dCtx.NewSyncerDest(trType, dst.Region, dst.Dpath, auth)
+		if err == nil {
+			endpoint := s3Endpoint(dst.Fqdn)
+			if s3EndPoint, ok := dEndPoint.(*zedUpload.AwsTransportMethod); ok && endpoint != "" {
+				log.Functionf("Using S3 endpoint %s", endpoint)
+				err = s3EndPoint.WithEndpoint(endpoint)
+			}
+		}
+	default:
+		err = fmt.Errorf("unknown transfer type: %s", trType)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// check for proxies on the selected management port interface
+	proxyLookupURL := zedcloud.IntfLookupProxyCfg(log, &ctx.deviceNetworkStatus,
+		ifname, serverURL, trType)
+	proxyURL, err := zedcloud.LookupProxy(log, &ctx.deviceNetworkStatus,
+		ifname, proxyLookupURL)
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL != nil {
+		log.Functionf("%s: Using proxy %s", trType, proxyURL.String())
+		err = dEndPoint.WithSrcIPAndProxySelection(ipSrc, proxyURL)
+	} else {
+		err = dEndPoint.WithSrcIPSelection(ipSrc)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return dEndPoint, nil
+}
+
+// uploadFile sends the whole file in one request
+func uploadFile(ctx *uploaderContext, cancelCtx context.Context,
+	dEndPoint zedUpload.DronaEndPoint, status *types.UploaderStatus) error {
+
+	respChan := make(chan *zedUpload.DronaRequest)
+	req := dEndPoint.NewRequest(zedUpload.SyncOpUpload, status.RemoteName,
+		status.LocalPath, 0, true, respChan)
+	if req == nil {
+		return errors.New("NewRequest failed")
+	}
+	req = req.WithCancel(cancelCtx)
+	defer req.Cancel()
+	if err := req.Post(); err != nil {
+		return err
+	}
+	for resp := range respChan {
+		if resp.IsDnUpdate() {
+			currentSize, _, _ := resp.Progress()
+			updateProgress(ctx, status, currentSize)
+			continue
+		}
+		location, err := resp.GetUpStatus()
+		if resp.IsError() {
+			return err
+		}
+		status.RemoteLocation = location
+		return nil
+	}
+	return fmt.Errorf("respChan EOF for %s", status.RemoteName)
+}
+
+// uploadParts sends the file in parts starting after the parts recorded
+// in the status and then asks the datastore to assemble them
+func uploadParts(ctx *uploaderContext, cancelCtx context.Context,
+	dEndPoint zedUpload.DronaEndPoint, trType zedUpload.SyncTransportType,
+	status *types.UploaderStatus) error {
+
+	if status.PartSize != uploadPartSize {
+		status.ClearMultipart()
+		status.PartSize = uploadPartSize
+	}
+	if len(status.Parts) != 0 {
+		log.Noticef("uploadParts(%s) resuming after %d parts",
+			status.Key(), len(status.Parts))
+	}
+	file, err := os.Open(status.LocalPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	partCount := int((status.TotalSize + status.PartSize - 1) / status.PartSize)
+	for partIndex := len(status.Parts); partIndex < partCount; partIndex++ {
+		offset := int64(partIndex) * status.PartSize
+		size := status.PartSize
+		if offset+size > status.TotalSize {
+			size = status.TotalSize - offset
+		}
+		chunk := make([]byte, size)
+		if _, err := file.ReadAt(chunk, offset); err != nil && err != io.EOF {
+			return err
+		}
+		respChan := make(chan *zedUpload.DronaRequest, 1)
+		req := dEndPoint.NewRequest(zedUpload.SysOpPutPart, status.RemoteName,
+			status.LocalPath, 0, false, respChan)
+		req.Adata = chunk
+		req.PartID = int64(partIndex + 1)
+		var blockID string
+		if trType == zedUpload.SyncAzureTr {
+			// Azure block IDs need to have the same length
+			blockID = base64.StdEncoding.EncodeToString(
+				[]byte(fmt.Sprintf("%08d", partIndex)))
+			req.UploadID = blockID
+		} else {
+			req.UploadID = status.UploadID
+		}
+		resp, err := postAndWait(cancelCtx, req, respChan)
+		if err != nil {
+			checkMultipartGone(status, err)
+			return err
+		}
+		if trType == zedUpload.SyncAzureTr {
+			status.Parts = append(status.Parts, blockID)
+		} else {
+			status.UploadID = resp.UploadID
+			status.Parts = append(status.Parts, resp.EtagID)
+		}
+		// Publishing records the part so that we can resume from here
+		updateProgress(ctx, status, offset+size)
+	}
+	respChan := make(chan *zedUpload.DronaRequest, 1)
+	req := dEndPoint.NewRequest(zedUpload.SysOpCompleteParts, status.RemoteName,
+		status.LocalPath, 0, false, respChan)
+	req.UploadID = status.UploadID
+	req.Blocks = status.Parts
+	if _, err := postAndWait(cancelCtx, req, respChan); err != nil {
+		checkMultipartGone(status, err)
+		return err
+	}
+	return nil
+}
+
+// checkMultipartGone forgets the uploaded parts if the datastore no
+// longer knows about the multipart upload, e.g., since it expired
+func checkMultipartGone(status *types.UploaderStatus, err error) {
+	if strings.Contains(err.Error(), "NoSuchUpload") {
+		log.Warnf("checkMultipartGone(%s) restarting upload: %s",
+			status.Key(), err)
+		status.ClearMultipart()
+		status.CurrentSize = 0
+		status.Progress = 0
+	}
+}
+
+// postAndWait sends one request and waits for its response
+func postAndWait(cancelCtx context.Context, req *zedUpload.DronaRequest,
+	respChan chan *zedUpload.DronaRequest) (*zedUpload.DronaRequest, error) {
+
+	req = req.WithCancel(cancelCtx)
+	defer req.Cancel()
+	if err := req.Post(); err != nil {
+		return nil, err
+	}
+	resp, ok := <-respChan
+	if !ok {
+		return nil, errors.New("respChan closed")
+	}
+	if resp.IsError() {
+		return nil, errors.New(resp.GetStatus())
+	}
+	return resp, nil
+}
+
+// updateProgress publishes the status if the progress changed
+func updateProgress(ctx *uploaderContext, status *types.UploaderStatus,
+	currentSize int64) {
+
+	progress := uint(0)
+	if status.TotalSize != 0 {
+		progress = uint(100 * currentSize / status.TotalSize)
+	}
+	if status.CurrentSize == currentSize && status.Progress == progress {
+		return
+	}
+	status.CurrentSize = currentSize
+	status.Progress = progress
+	publishUploaderStatus(ctx, status)
+}
+
+func lookupDatastoreConfig(ctx *uploaderContext,
+	dsID uuid.UUID) *types.DatastoreConfig {
+
+	c, _ := ctx.subDatastoreConfig.Get(dsID.String())
+	if c == nil {
+		return nil
+	}
+	dst := c.(types.DatastoreConfig)
+	return &dst
+}
+
+func getDatastoreCredential(ctx *uploaderContext,
+	dst types.DatastoreConfig) (types.EncryptionBlock, error) {
+	if dst.CipherBlockStatus.IsCipher {
+		status, decBlock, err := cipher.GetCipherCredentials(&ctx.decryptCipherContext,
+			agentName, dst.CipherBlockStatus)
+		ctx.pubCipherBlockStatus.Publish(status.Key(), status)
+		if err != nil {
+			log.Errorf("%s, datastore config cipherblock decryption unsuccessful, falling back to cleartext: %v",
+				dst.Key(), err)
+			decBlock.DsAPIKey = dst.ApiKey
+			decBlock.DsPassword = dst.Password
+			// We assume IsCipher is only set when there was some
+			// data. Hence this is a fallback if there is
+			// some cleartext.
+			if decBlock.DsAPIKey != "" || decBlock.DsPassword != "" {
+				cipher.RecordFailure(agentName,
+					types.CleartextFallback)
+			} else {
+				cipher.RecordFailure(agentName,
+					types.MissingFallback)
+			}
+			return decBlock, nil
+		}
+		log.Functionf("%s, datastore config cipherblock decryption successful", dst.Key())
+		return decBlock, nil
+	}
+	log.Functionf("%s, datastore config cipherblock not present", dst.Key())
+	decBlock := types.EncryptionBlock{}
+	decBlock.DsAPIKey = dst.ApiKey
+	decBlock.DsPassword = dst.Password
+	if decBlock.DsAPIKey != "" || decBlock.DsPassword != "" {
+		cipher.RecordFailure(agentName, types.NoCipher)
+	} else {
+		cipher.RecordFailure(agentName, types.NoData)
+	}
+	return decBlock, nil
+}
@@ -0,0 +1,65 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package uploader
+
+import (
+	"testing"
+
+	"github.com/lf-edge/eve/libs/zedUpload"
+)
+
+func TestS3Endpoint(t *testing.T) {
+	testMatrix := map[string]struct {
+		fqdn     string
+		expected string
+	}{
+		"empty": {
+			fqdn:     "",
+			expected: "",
+		},
+		"aws": {
+			fqdn:     "s3.amazonaws.com",
+			expected: "",
+		},
+		"aws regional with scheme": {
+			fqdn:     "https://s3.eu-west-1.amazonaws.com",
+			expected: "",
+		},
+		"minio no scheme": {
+			fqdn:     "minio.example.com:9000",
+			expected: "https://minio.example.com:9000",
+		},
+		"minio http": {
+			fqdn:     "http://10.1.0.2:9000",
+			expected: "http://10.1.0.2:9000",
+		},
+		"not aws": {
+			fqdn:     "notamazonaws.com",
+			expected: "https://notamazonaws.com",
+		},
+	}
+	for testname, test := range testMatrix {
+		t.Logf("Running test case %s", testname)
+		endpoint := s3Endpoint(test.fqdn)
+		if endpoint != test.expected {
+			t.Errorf("%s: got %s, expected %s", testname, endpoint,
+				test.expected)
+		}
+	}
+}
+
+func TestUseMultipart(t *testing.T) {
+	if useMultipart(zedUpload.SyncSftpTr, 10*uploadPartSize) {
+		t.Errorf("SFTP should not use multipart")
+	}
+	if useMultipart(zedUpload.SyncAwsTr, uploadPartSize) {
+		t.Errorf("S3 should not use multipart for a single part")
+	}
+	if !useMultipart(zedUpload.SyncAwsTr, uploadPartSize+1) {
+		t.Errorf("S3 should use multipart for two parts")
+	}
+	if !useMultipart(zedUpload.SyncAzureTr, 2*uploadPartSize) {
+		t.Errorf("Azure should use multipart for two parts")
+	}
+}
@@ -0,0 +1,476 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+// Process input in the form of collections of UploaderConfig structs,
+// upload the files to S3-compatible, Azure or SFTP datastores,
+// and publish the results as collections of UploaderStatus structs.
+
+package uploader
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/lf-edge/eve/libs/zedUpload"
+	"github.com/lf-edge/eve/pkg/pillar/agentlog"
+	"github.com/lf-edge/eve/pkg/pillar/base"
+	"github.com/lf-edge/eve/pkg/pillar/cipher"
+	"github.com/lf-edge/eve/pkg/pillar/pidfile"
+	"github.com/lf-edge/eve/pkg/pillar/pubsub"
+	"github.com/lf-edge/eve/pkg/pillar/types"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	agentName = "uploader"
+	// Time limits for event loop handlers
+	errorTime   = 3 * time.Minute
+	warningTime = 40 * time.Second
+	// Size of the parts for multipart uploads. S3 requires at least
+	// 5 Mbytes for all parts but the last.
+	uploadPartSize = 8 * 1024 * 1024
+)
+
+// Go doesn't like this as a constant
+var (
+	debug         = false
+	debugOverride bool                     // From command line arg
+	Version       = "No version specified" // Set from Makefile
+	logger        *logrus.Logger
+	log           *base.LogObject
+)
+
+type uploaderContext struct {
+	decryptCipherContext   cipher.DecryptCipherContext
+	dCtx                   *zedUpload.DronaCtx
+	subDeviceNetworkStatus pubsub.Subscription
+	subDatastoreConfig     pubsub.Subscription
+	subTmpUploaderConfig   pubsub.Subscription
+	pubUploaderStatus      pubsub.Publication
+	pubCipherBlockStatus   pubsub.Publication
+	deviceNetworkStatus    types.DeviceNetworkStatus
+	subGlobalConfig        pubsub.Subscription
+	GCInitialized          bool
+
+	retryTime   time.Duration
+	maxRetries  int
+	maxPortCost uint8 // We honor network.download.max.cost for uploads
+
+	// One cancel function per upload goroutine. Only accessed from
+	// the main loop; the goroutines report completion on doneChan.
+	inprogress map[string]context.CancelFunc
+	doneChan   chan string
+}
+
+// Run uploader
+func Run(ps *pubsub.PubSub, loggerArg *logrus.Logger, logArg *base.LogObject) int {
+	logger = loggerArg
+	log = logArg
+	versionPtr := flag.Bool("v", false, "Version")
+	debugPtr := flag.Bool("d", false, "Debug flag")
+	flag.Parse()
+	debug = *debugPtr
+	debugOverride = debug
+	if debugOverride {
+		logger.SetLevel(logrus.TraceLevel)
+	} else {
+		logger.SetLevel(logrus.InfoLevel)
+	}
+	if *versionPtr {
+		fmt.Printf("%s: %s\n", os.Args[0], Version)
+		return 0
+	}
+	if err := pidfile.CheckAndCreatePidfile(log, agentName); err != nil {
+		log.Fatal(err)
+	}
+	log.Functionf("Starting %s", agentName)
+
+	// Run a periodic timer so we always update StillRunning
+	stillRunning := time.NewTicker(25 * time.Second)
+	ps.StillRunning(agentName, warningTime, errorTime)
+
+	// Any state needed by handler functions
+	ctx := uploaderContext{
+		retryTime:  600 * time.Second,
+		maxRetries: 10,
+		inprogress: make(map[string]context.CancelFunc),
+		doneChan:   make(chan string, 10),
+	}
+
+	if err := ctx.registerHandlers(ps); err != nil {
+		log.Fatal(err)
+	}
+
+	// Pick up debug aka log level before we start real work
+	for !ctx.GCInitialized {
+		log.Functionf("waiting for GCInitialized")
+		select {
+		case change := <-ctx.subGlobalConfig.MsgChan():
+			ctx.subGlobalConfig.ProcessChange(change)
+		case <-stillRunning.C:
+		}
+		ps.StillRunning(agentName, warningTime, errorTime)
+	}
+	log.Functionf("processed GlobalConfig")
+
+	// Wait to have some management ports with addresses so that
+	// the uploads we resume do not fail right away
+	for types.CountLocalAddrAnyNoLinkLocal(ctx.deviceNetworkStatus) == 0 {
+		log.Functionf("Waiting for management port addresses")
+
+		select {
+		case change := <-ctx.subGlobalConfig.MsgChan():
+			ctx.subGlobalConfig.ProcessChange(change)
+
+		case change := <-ctx.subDeviceNetworkStatus.MsgChan():
+			ctx.subDeviceNetworkStatus.ProcessChange(change)
+
+		// This wait can take an unbounded time since we wait for IP
+		// addresses. Punch StillRunning
+		case <-stillRunning.C:
+		}
+		ps.StillRunning(agentName, warningTime, errorTime)
+	}
+	log.Functionf("Have %d management ports addresses to use",
+		types.CountLocalAddrAnyNoLinkLocal(ctx.deviceNetworkStatus))
+
+	dCtx, err := zedUpload.NewDronaCtx("zuploader", 0)
+	if dCtx == nil {
+		log.Fatalf("context create fail %s", err)
+	}
+	ctx.dCtx = dCtx
+
+	if err := ctx.registerUploaderConfig(ps); err != nil {
+		log.Fatal(err)
+	}
+
+	retryTimer := time.NewTicker(time.Minute)
+
+	for {
+		select {
+		case change := <-ctx.decryptCipherContext.SubControllerCert.MsgChan():
+			ctx.decryptCipherContext.SubControllerCert.ProcessChange(change)
+
+		case change := <-ctx.decryptCipherContext.SubEdgeNodeCert.MsgChan():
+			ctx.decryptCipherContext.SubEdgeNodeCert.ProcessChange(change)
+
+		case change := <-ctx.decryptCipherContext.SubCipherContext.MsgChan():
+			ctx.decryptCipherContext.SubCipherContext.ProcessChange(change)
+
+		case change := <-ctx.subGlobalConfig.MsgChan():
+			ctx.subGlobalConfig.ProcessChange(change)
+
+		case change := <-ctx.subDeviceNetworkStatus.MsgChan():
+			ctx.subDeviceNetworkStatus.ProcessChange(change)
+
+		case change := <-ctx.subDatastoreConfig.MsgChan():
+			ctx.subDatastoreConfig.ProcessChange(change)
+
+		case change := <-ctx.subTmpUploaderConfig.MsgChan():
+			ctx.subTmpUploaderConfig.ProcessChange(change)
+
+		case key := <-ctx.doneChan:
+			start := time.Now()
+			delete(ctx.inprogress, key)
+			updateUpload(&ctx, key)
+			ps.CheckMaxTimeTopic(agentName, "doneChan", start,
+				warningTime, errorTime)
+
+		case <-retryTimer.C:
+			start := time.Now()
+			retryUploads(&ctx)
+			ps.CheckMaxTimeTopic(agentName, "retryTimer", start,
+				warningTime, errorTime)
+
+		case <-stillRunning.C:
+		}
+		ps.StillRunning(agentName, warningTime, errorTime)
+	}
+}
+
+func (ctx *uploaderContext) registerHandlers(ps *pubsub.PubSub) error {
+	// Look for controller certs which will be used for decryption
+	subControllerCert, err := ps.NewSubscription(pubsub.SubscriptionOptions{
+		AgentName:   "zedagent",
+		MyAgentName: agentName,
+		TopicImpl:   types.ControllerCert{},
+		Activate:    false,
+		Ctx:         ctx,
+		WarningTime: warningTime,
+		ErrorTime:   errorTime,
+		Persistent:  true,
+	})
+	if err != nil {
+		return err
+	}
+	ctx.decryptCipherContext.Log = log
+	ctx.decryptCipherContext.SubControllerCert = subControllerCert
+	subControllerCert.Activate()
+
+	// Look for edge node certs which will be used for decryption
+	subEdgeNodeCert, err := ps.NewSubscription(pubsub.SubscriptionOptions{
+		AgentName:   "tpmmgr",
+		MyAgentName: agentName,
+		TopicImpl:   types.EdgeNodeCert{},
+		Activate:    false,
+		Persistent:  true,
+		Ctx:         ctx,
+		WarningTime: warningTime,
+		ErrorTime:   errorTime,
+	})
+	if err != nil {
+		return err
+	}
+	ctx.decryptCipherContext.SubEdgeNodeCert = subEdgeNodeCert
+	subEdgeNodeCert.Activate()
+
+	// Look for cipher context which will be used for decryption
+	subCipherContext, err := ps.NewSubscription(pubsub.SubscriptionOptions{
+		AgentName:   "zedagent",
+		MyAgentName: agentName,
+		TopicImpl:   types.CipherContext{},
+		Activate:    false,
+		Ctx:         ctx,
+		WarningTime: warningTime,
+		ErrorTime:   errorTime,
+		Persistent:  true,
+	})
+	if err != nil {
+		return err
+	}
+	ctx.decryptCipherContext.SubCipherContext = subCipherContext
+	subCipherContext.Activate()
+
+	// Look for global config such as log levels
+	subGlobalConfig, err := ps.NewSubscription(pubsub.SubscriptionOptions{
+		AgentName:     "zedagent",
+		MyAgentName:   agentName,
+		CreateHandler: handleGlobalConfigCreate,
+		ModifyHandler: handleGlobalConfigModify,
+		DeleteHandler: handleGlobalConfigDelete,
+		WarningTime:   warningTime,
+		ErrorTime:     errorTime,
+		TopicImpl:     types.ConfigItemValueMap{},
+		Persistent:    true,
+		Ctx:           ctx,
+	})
+	if err != nil {
+		return err
+	}
+	ctx.subGlobalConfig = subGlobalConfig
+	subGlobalConfig.Activate()
+
+	subDeviceNetworkStatus, err := ps.NewSubscription(pubsub.SubscriptionOptions{
+		CreateHandler: handleDNSCreate,
+		ModifyHandler: handleDNSModify,
+		DeleteHandler: handleDNSDelete,
+		WarningTime:   warningTime,
+		ErrorTime:     errorTime,
+		TopicImpl:     types.DeviceNetworkStatus{},
+		Ctx:           ctx,
+		AgentName:     "nim",
+		MyAgentName:   agentName,
+	})
+	if err != nil {
+		return err
+	}
+	ctx.subDeviceNetworkStatus = subDeviceNetworkStatus
+	subDeviceNetworkStatus.Activate()
+
+	subDatastoreConfig, err := ps.NewSubscription(pubsub.SubscriptionOptions{
+		CreateHandler: handleDatastoreConfigCreate,
+		ModifyHandler: handleDatastoreConfigModify,
+		DeleteHandler: handleDatastoreConfigDelete,
+		WarningTime:   warningTime,
+		ErrorTime:     errorTime,
+		AgentName:     "zedagent",
+		MyAgentName:   agentName,
+		TopicImpl:     types.DatastoreConfig{},
+		Ctx:           ctx,
+	})
+	if err != nil {
+		return err
+	}
+	ctx.subDatastoreConfig = subDatastoreConfig
+	subDatastoreConfig.Activate()
+
+	pubCipherBlockStatus, err := ps.NewPublication(pubsub.PublicationOptions{
+		AgentName: agentName,
+		TopicType: types.CipherBlockStatus{},
+	})
+	if err != nil {
+		return err
+	}
+	ctx.pubCipherBlockStatus = pubCipherBlockStatus
+
+	// Persistent so that we can resume multipart uploads after a reboot
+	pubUploaderStatus, err := ps.NewPublication(pubsub.PublicationOptions{
+		AgentName:  agentName,
+		TopicType:  types.UploaderStatus{},
+		Persistent: true,
+	})
+	if err != nil {
+		return err
+	}
+	ctx.pubUploaderStatus = pubUploaderStatus
+	return nil
+}
+
+// registerUploaderConfig subscribes to the upload requests. This is done
+// once we have management port addresses and a DronaCtx. The API has no
+// upload request, so they only come from local tools placing an
+// UploaderConfig in /run/global/UploaderConfig/, e.g. debug scripts.
+func (ctx *uploaderContext) registerUploaderConfig(ps *pubsub.PubSub) error {
+	subTmpUploaderConfig, err := ps.NewSubscription(pubsub.SubscriptionOptions{
+		CreateHandler: handleUploaderConfigCreate,
+		ModifyHandler: handleUploaderConfigModify,
+		DeleteHandler: handleUploaderConfigDelete,
+		SyncHandler:   handleUploaderConfigSynchronized,
+		WarningTime:   warningTime,
+		ErrorTime:     errorTime,
+		AgentName:     "",
+		MyAgentName:   agentName,
+		TopicImpl:     types.UploaderConfig{},
+		Ctx:           ctx,
+	})
+	if err != nil {
+		return err
+	}
+	ctx.subTmpUploaderConfig = subTmpUploaderConfig
+	subTmpUploaderConfig.Activate()
+	return nil
+}
+
+func handleGlobalConfigCreate(ctxArg interface{}, key string,
+	statusArg interface{}) {
+	handleGlobalConfigImpl(ctxArg, key, statusArg)
+}
+
+func handleGlobalConfigModify(ctxArg interface{}, key string,
+	statusArg interface{}, oldStatusArg interface{}) {
+	handleGlobalConfigImpl(ctxArg, key, statusArg)
+}
+
+func handleGlobalConfigImpl(ctxArg interface{}, key string,
+	statusArg interface{}) {
+
+	ctx := ctxArg.(*uploaderContext)
+	if key != "global" {
+		log.Functionf("handleGlobalConfigImpl: ignoring %s", key)
+		return
+	}
+	log.Functionf("handleGlobalConfigImpl for %s", key)
+	var gcp *types.ConfigItemValueMap
+	debug, gcp = agentlog.HandleGlobalConfig(log, ctx.subGlobalConfig, agentName,
+		debugOverride, logger)
+	if gcp != nil {
+		if gcp.GlobalValueInt(types.UploadRetryTime) != 0 {
+			ctx.retryTime = time.Duration(gcp.GlobalValueInt(types.UploadRetryTime)) * time.Second
+		}
+		ctx.maxRetries = int(gcp.GlobalValueInt(types.UploadMaxRetries))
+		ctx.maxPortCost = uint8(gcp.GlobalValueInt(types.DownloadMaxPortCost))
+		ctx.GCInitialized = true
+	}
+	log.Functionf("handleGlobalConfigImpl done for %s", key)
+}
+
+func handleGlobalConfigDelete(ctxArg interface{}, key string,
+	statusArg interface{}) {
+
+	ctx := ctxArg.(*uploaderContext)
+	if key != "global" {
+		log.Functionf("handleGlobalConfigDelete: ignoring %s", key)
+		return
+	}
+	log.Functionf("handleGlobalConfigDelete for %s", key)
+	debug, _ = agentlog.HandleGlobalConfig(log, ctx.subGlobalConfig, agentName,
+		debugOverride, logger)
+	log.Functionf("handleGlobalConfigDelete done for %s", key)
+}
+
+func handleDNSCreate(ctxArg interface{}, key string,
+	statusArg interface{}) {
+	handleDNSImpl(ctxArg, key, statusArg)
+}
+
+func handleDNSModify(ctxArg interface{}, key string,
+	statusArg interface{}, oldStatusArg interface{}) {
+	handleDNSImpl(ctxArg, key, statusArg)
+}
+
+func handleDNSImpl(ctxArg interface{}, key string,
+	statusArg interface{}) {
+
+	ctx := ctxArg.(*uploaderContext)
+	status := statusArg.(types.DeviceNetworkStatus)
+	if key != "global" {
+		log.Functionf("handleDNSImpl: ignoring %s", key)
+		return
+	}
+	log.Functionf("handleDNSImpl for %s", key)
+	// Ignore test status and timestamps
+	if ctx.deviceNetworkStatus.MostlyEqual(status) {
+		log.Functionf("handleDNSImpl unchanged")
+		return
+	}
+	ctx.deviceNetworkStatus = status
+	log.Functionf("handleDNSImpl done for %s", key)
+}
+
+func handleDNSDelete(ctxArg interface{}, key string, statusArg interface{}) {
+
+	ctx := ctxArg.(*uploaderContext)
+	log.Functionf("handleDNSDelete for %s", key)
+	if key != "global" {
+		log.Functionf("handleDNSDelete: ignoring %s", key)
+		return
+	}
+	ctx.deviceNetworkStatus = types.DeviceNetworkStatus{}
+	log.Functionf("handleDNSDelete done for %s", key)
+}
+
+func handleDatastoreConfigCreate(ctxArg interface{}, key string,
+	configArg interface{}) {
+	handleDatastoreConfigImpl(ctxArg, key, configArg)
+}
+
+func handleDatastoreConfigModify(ctxArg interface{}, key string,
+	configArg interface{}, oldConfigArg interface{}) {
+	handleDatastoreConfigImpl(ctxArg, key, configArg)
+}
+
+// handleDatastoreConfigImpl retries the failed uploads to the datastore
+// since the failure might have been due to missing or wrong credentials
+func handleDatastoreConfigImpl(ctxArg interface{}, key string,
+	configArg interface{}) {
+
+	ctx := ctxArg.(*uploaderContext)
+	config := configArg.(types.DatastoreConfig)
+	log.Functionf("handleDatastoreConfigImpl for %s", key)
+	items := ctx.pubUploaderStatus.GetAll()
+	for _, item := range items {
+		status := item.(types.UploaderStatus)
+		if status.DatastoreID != config.UUID ||
+			status.State != types.UploadStateFailed {
+			continue
+		}
+		status.RetryCount = 0
+		status.State = types.UploadStatePending
+		publishUploaderStatus(ctx, &status)
+		updateUpload(ctx, status.Key())
+	}
+	log.Functionf("handleDatastoreConfigImpl for %s, done", key)
+}
+
+func handleDatastoreConfigDelete(ctxArg interface{}, key string,
+	configArg interface{}) {
+
+	ctx := ctxArg.(*uploaderContext)
+	config := configArg.(types.DatastoreConfig)
+	cipherBlock := config.CipherBlockStatus
+	ctx.pubCipherBlockStatus.Unpublish(cipherBlock.Key())
+	log.Functionf("handleDatastoreConfigDelete for %s", key)
+}
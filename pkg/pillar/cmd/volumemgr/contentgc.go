@@ -0,0 +1,305 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package volumemgr
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lf-edge/eve/pkg/pillar/containerd"
+	"github.com/lf-edge/eve/pkg/pillar/types"
+)
+
+// Content garbage collection. The blobs, images and snapshots in CAS and the
+// files in the verifier's verified directory are normally removed when their
+// RefCount drops to zero, but anything missed by those paths (for instance
+// due to a crash or a reboot at the wrong time) stays on /persist forever.
+// The content GC periodically lists all of them, determines which ones are
+// reachable from the current ContentTreeStatus, VolumeStatus, BlobStatus and
+// VerifyImageStatus, and reports the others in ContentGCStatus. With the
+// delete policy the orphans which have been unreferenced for at least
+// timer.gc.content.minage are deleted.
+
+// verifiedDirname must match getVerifiedDir in the verifier
+const verifiedDirname = types.SealedDirName + "/verifier/verified"
+
+// contentGCObject is an object found in CAS or the verified directory
+type contentGCObject struct {
+	kind types.ContentGCKind
+	id   string
+	size int64
+}
+
+// contentGCReachable is the set of reachable IDs per kind
+type contentGCReachable map[types.ContentGCKind]map[string]bool
+
+func (reachable contentGCReachable) add(kind types.ContentGCKind, id string) {
+	if _, ok := reachable[kind]; !ok {
+		reachable[kind] = make(map[string]bool)
+	}
+	reachable[kind][id] = true
+}
+
+func contentGCKey(kind types.ContentGCKind, id string) string {
+	return kind.String() + " " + id
+}
+
+// selectContentGCOrphans returns the objects which are not reachable sorted
+// in the order in which they should be deleted. firstSeen records when each
+// orphan was first found and is updated to forget about the objects which
+// are gone or reachable again.
+func selectContentGCOrphans(objects []contentGCObject,
+	reachable contentGCReachable, firstSeen map[string]time.Time,
+	now time.Time) []types.ContentGCOrphan {
+
+	orphans := []types.ContentGCOrphan{}
+	current := make(map[string]bool)
+	for _, obj := range objects {
+		if reachable[obj.kind][obj.id] {
+			continue
+		}
+		key := contentGCKey(obj.kind, obj.id)
+		seen, ok := firstSeen[key]
+		if !ok {
+			seen = now
+			firstSeen[key] = seen
+		}
+		current[key] = true
+		orphans = append(orphans, types.ContentGCOrphan{
+			Kind:      obj.kind,
+			ID:        obj.id,
+			Size:      obj.size,
+			FirstSeen: seen,
+		})
+	}
+	for key := range firstSeen {
+		if !current[key] {
+			delete(firstSeen, key)
+		}
+	}
+	// Snapshots before the images and images before the blobs
+	sort.Slice(orphans, func(i, j int) bool {
+		if orphans[i].Kind != orphans[j].Kind {
+			return orphans[i].Kind < orphans[j].Kind
+		}
+		return orphans[i].ID < orphans[j].ID
+	})
+	return orphans
+}
+
+// listContentGCObjects returns everything in CAS and the verified directory
+// which is subject to content GC
+func listContentGCObjects(ctx *volumemgrContext) ([]contentGCObject, []string) {
+	var objects []contentGCObject
+	var errs []string
+
+	snapshots, err := ctx.casClient.ListSnapshots()
+	if err != nil {
+		errs = append(errs, err.Error())
+	}
+	for _, snapshotID := range snapshots {
+		// The committed snapshots of the layers belong to the images
+		// and are removed by containerd with them
+		if strings.HasPrefix(snapshotID, "sha256:") {
+			continue
+		}
+		objects = append(objects, contentGCObject{
+			kind: types.ContentGCSnapshot,
+			id:   snapshotID,
+		})
+	}
+
+	images, err := ctx.casClient.ListImages()
+	if err != nil {
+		errs = append(errs, err.Error())
+	}
+	for _, image := range images {
+		objects = append(objects, contentGCObject{
+			kind: types.ContentGCImage,
+			id:   image,
+		})
+	}
+
+	blobInfos, err := ctx.casClient.ListBlobInfo()
+	if err != nil {
+		errs = append(errs, err.Error())
+	}
+	for _, blobInfo := range blobInfos {
+		objects = append(objects, contentGCObject{
+			kind: types.ContentGCBlob,
+			id:   blobInfo.Digest,
+			size: blobInfo.Size,
+		})
+	}
+
+	err = filepath.Walk(verifiedDirname,
+		func(pathname string, info os.FileInfo, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if info.Mode().IsRegular() {
+				objects = append(objects, contentGCObject{
+					kind: types.ContentGCVerifiedFile,
+					id:   pathname,
+					size: info.Size(),
+				})
+			}
+			return nil
+		})
+	if err != nil {
+		errs = append(errs, err.Error())
+	}
+	return objects, errs
+}
+
+// getContentGCReachable returns what is referenced by our status and the
+// verifier's status. All the blobs of the images which are still in CAS are
+// considered reachable so that an image is never left with missing blobs;
+// they become orphans once an unreferenced image has been deleted.
+func getContentGCReachable(ctx *volumemgrContext) (contentGCReachable, []string) {
+	reachable := make(contentGCReachable)
+	var errs []string
+
+	for _, status := range getAllContentTreeStatus(ctx) {
+		reachable.add(types.ContentGCImage, status.ReferenceID())
+		for _, blob := range status.Blobs {
+			reachable.add(types.ContentGCBlob,
+				checkAndCorrectBlobHash(strings.ToLower(blob)))
+		}
+	}
+	for _, item := range ctx.pubBlobStatus.GetAll() {
+		blob := item.(types.BlobStatus)
+		reachable.add(types.ContentGCBlob,
+			checkAndCorrectBlobHash(strings.ToLower(blob.Sha256)))
+	}
+
+	images, err := ctx.casClient.ListImages()
+	if err != nil {
+		errs = append(errs, err.Error())
+	}
+	visited := make(map[string]bool)
+	for _, image := range images {
+		hash, err := ctx.casClient.GetImageHash(image)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		// Walk the tree of index, manifests, config and layers
+		pending := []string{hash}
+		for len(pending) != 0 {
+			blob := pending[0]
+			pending = pending[1:]
+			if visited[blob] {
+				continue
+			}
+			visited[blob] = true
+			reachable.add(types.ContentGCBlob, blob)
+			children, err := ctx.casClient.Children(blob)
+			if err != nil {
+				// Might be a missing layer of a partial image
+				log.Warnf("getContentGCReachable: %v", err)
+				continue
+			}
+			pending = append(pending, children...)
+		}
+	}
+
+	for _, item := range ctx.pubVolumeStatus.GetAll() {
+		status := item.(types.VolumeStatus)
+		if !status.IsContainer() {
+			continue
+		}
+		// The FileLocation is not yet set while the volume is created
+		reachable.add(types.ContentGCSnapshot,
+			containerd.GetSnapshotID(status.PathName()))
+		if status.FileLocation != "" {
+			reachable.add(types.ContentGCSnapshot,
+				containerd.GetSnapshotID(status.FileLocation))
+		}
+	}
+
+	for _, item := range ctx.subVerifyImageStatus.GetAll() {
+		status := item.(types.VerifyImageStatus)
+		if status.FileLocation != "" {
+			reachable.add(types.ContentGCVerifiedFile, status.FileLocation)
+		}
+	}
+	return reachable, errs
+}
+
+func deleteContentGCOrphan(ctx *volumemgrContext, orphan types.ContentGCOrphan) error {
+	switch orphan.Kind {
+	case types.ContentGCSnapshot:
+		return ctx.casClient.RemoveSnapshot(orphan.ID)
+	case types.ContentGCImage:
+		return ctx.casClient.RemoveImage(orphan.ID)
+	case types.ContentGCBlob:
+		return ctx.casClient.RemoveBlob(orphan.ID)
+	case types.ContentGCVerifiedFile:
+		return os.Remove(orphan.ID)
+	default:
+		return fmt.Errorf("unknown kind %d", orphan.Kind)
+	}
+}
+
+// gcContent is called from the contentGC timer. It does nothing until the
+// objects from before the reboot have been garbage collected, which only
+// happens once we are using a config.
+func gcContent(ctx *volumemgrContext) {
+	if ctx.contentGCPolicy == types.ContentGCOff || !ctx.initGced {
+		return
+	}
+	log.Functionf("gcContent policy %s", ctx.contentGCPolicy)
+	now := time.Now()
+	status := types.ContentGCStatus{
+		LastRun: now,
+		Policy:  ctx.contentGCPolicy,
+	}
+	objects, errs := listContentGCObjects(ctx)
+	reachable, reachErrs := getContentGCReachable(ctx)
+	errs = append(errs, reachErrs...)
+	if len(reachErrs) != 0 {
+		// Do not delete what might be reachable
+		status.Policy = types.ContentGCReport
+	}
+	status.Orphans = selectContentGCOrphans(objects, reachable,
+		ctx.contentGCFirstSeen, now)
+	for i := range status.Orphans {
+		orphan := &status.Orphans[i]
+		if status.Policy == types.ContentGCDelete &&
+			now.Sub(orphan.FirstSeen) >= ctx.contentGCMinAge {
+			log.Noticef("gcContent: deleting %s %s size %d unreferenced since %v",
+				orphan.Kind, orphan.ID, orphan.Size, orphan.FirstSeen)
+			if err := deleteContentGCOrphan(ctx, *orphan); err != nil {
+				log.Errorf("gcContent: delete %s %s failed: %v",
+					orphan.Kind, orphan.ID, err)
+				errs = append(errs, err.Error())
+			} else {
+				orphan.Deleted = true
+				status.ReclaimedCount++
+				status.ReclaimedSize += orphan.Size
+				delete(ctx.contentGCFirstSeen,
+					contentGCKey(orphan.Kind, orphan.ID))
+				continue
+			}
+		}
+		status.OrphanSize += orphan.Size
+	}
+	if len(errs) != 0 {
+		status.SetErrorNow(strings.Join(errs, "; "))
+	}
+	if len(status.Orphans) != 0 {
+		log.Noticef("gcContent: %d orphans using %d bytes, reclaimed %d bytes from %d",
+			len(status.Orphans)-int(status.ReclaimedCount),
+			status.OrphanSize, status.ReclaimedSize, status.ReclaimedCount)
+	}
+	ctx.pubContentGCStatus.Publish(status.Key(), status)
+}
@@ -0,0 +1,54 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package volumemgr
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lf-edge/eve/pkg/pillar/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectContentGCOrphans(t *testing.T) {
+	objects := []contentGCObject{
+		{kind: types.ContentGCBlob, id: "sha256:1111", size: 100},
+		{kind: types.ContentGCBlob, id: "sha256:2222", size: 200},
+		{kind: types.ContentGCImage, id: "docker.io/library/alpine:latest"},
+		{kind: types.ContentGCSnapshot, id: "snapshot1"},
+		{kind: types.ContentGCVerifiedFile, id: "/persist/vault/verifier/verified/3333", size: 300},
+	}
+	reachable := make(contentGCReachable)
+	reachable.add(types.ContentGCBlob, "sha256:1111")
+	reachable.add(types.ContentGCSnapshot, "snapshot1")
+
+	firstSeen := make(map[string]time.Time)
+	// Old entry for an object which is gone
+	firstSeen[contentGCKey(types.ContentGCBlob, "sha256:4444")] = time.Unix(100, 0)
+	now := time.Unix(1000, 0)
+
+	orphans := selectContentGCOrphans(objects, reachable, firstSeen, now)
+	assert.Equal(t, 3, len(orphans))
+	// Images before blobs before verified files
+	assert.Equal(t, types.ContentGCImage, orphans[0].Kind)
+	assert.Equal(t, types.ContentGCBlob, orphans[1].Kind)
+	assert.Equal(t, "sha256:2222", orphans[1].ID)
+	assert.Equal(t, int64(200), orphans[1].Size)
+	assert.Equal(t, types.ContentGCVerifiedFile, orphans[2].Kind)
+	for _, orphan := range orphans {
+		assert.Equal(t, now, orphan.FirstSeen)
+	}
+	assert.Equal(t, 3, len(firstSeen))
+
+	// On the next run the image is referenced again and the others keep
+	// their first seen time
+	reachable.add(types.ContentGCImage, "docker.io/library/alpine:latest")
+	later := now.Add(time.Hour)
+	orphans = selectContentGCOrphans(objects, reachable, firstSeen, later)
+	assert.Equal(t, 2, len(orphans))
+	for _, orphan := range orphans {
+		assert.Equal(t, now, orphan.FirstSeen)
+	}
+	assert.Equal(t, 2, len(firstSeen))
+}
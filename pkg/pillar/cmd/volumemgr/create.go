@@ -77,7 +77,7 @@ func createVdiskVolume(ctx *volumemgrContext, status types.VolumeStatus,
 			Image: ref,
 		}
 
-		casClient, err := cas.NewCAS(casClientType)
+		casClient, err := cas.NewCAS(ctx.casType)
 		if err != nil {
 			err = fmt.Errorf("Run: exception while initializing CAS client: %s", err.Error())
 			return created, "", err
@@ -141,6 +141,14 @@ func createContainerVolume(ctx *volumemgrContext, status types.VolumeStatus,
 		log.Errorf(err.Error())
 		return created, filelocation, err
 	}
+	if ctStatus.LazyPull {
+		if err := ctx.casClient.PrepareLazyContainerRootDir(filelocation, ref, ctStatus.ImageRef); err != nil {
+			log.Errorf("Failed to create ctr bundle lazily. Error %s", err)
+			return created, filelocation, err
+		}
+		log.Functionf("createContainerVolume(%s) lazily DONE", status.Key())
+		return true, filelocation, nil
+	}
 	if err := ctx.casClient.PrepareContainerRootDir(filelocation, ref, checkAndCorrectBlobHash(rootBlobStatus.Sha256)); err != nil {
 		log.Errorf("Failed to create ctr bundle. Error %s", err)
 		return created, filelocation, err
@@ -0,0 +1,185 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package volumemgr
+
+// Lazy pulling of container images, see app.lazy.pull. Only the index,
+// the manifest and the config of an eStargz image are downloaded and loaded
+// into CAS; the volume is created by the remote snapshotter, which fetches
+// the chunks of the layers from the registry when they are read. If that
+// does not work out the layers are added to the content tree, which is then
+// pulled in full.
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/lf-edge/eve/pkg/pillar/types"
+	"github.com/lf-edge/eve/pkg/pillar/utils"
+)
+
+const (
+	// estargzTOCAnnotation is set on the layers of eStargz images
+	estargzTOCAnnotation = "containerd.io/snapshot/stargz/toc.digest"
+	// lazyPullInterval is how often the fetched size of the images of
+	// the lazily pulled volumes is updated
+	lazyPullInterval = time.Minute
+)
+
+// isEStargzManifest returns true if all the layers of the manifest can be
+// fetched lazily
+func isEStargzManifest(manifest *v1.Manifest) bool {
+	if manifest == nil || len(manifest.Layers) == 0 {
+		return false
+	}
+	for _, l := range manifest.Layers {
+		if l.Annotations[estargzTOCAnnotation] == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// withoutLayers returns the blobs less the layers of the manifest
+func withoutLayers(blobs []*types.BlobStatus, manifest *v1.Manifest) []*types.BlobStatus {
+	layers := make(map[string]bool)
+	for _, l := range manifest.Layers {
+		layers[strings.ToLower(l.Digest.Hex)] = true
+	}
+	var ret []*types.BlobStatus
+	for _, b := range blobs {
+		if !layers[b.Sha256] {
+			ret = append(ret, b)
+		}
+	}
+	return ret
+}
+
+// registryImageRef returns the reference of the image in the registry of the
+// datastore. The remote snapshotter has no credentials, hence only the
+// images in public registries can be pulled lazily.
+func registryImageRef(ds types.DatastoreConfig, relativeURL string) (string, error) {
+	if ds.ApiKey != "" || ds.Password != "" || ds.IsCipher {
+		return "", fmt.Errorf("datastore %s has credentials", ds.UUID)
+	}
+	if len(ds.DsCertPEM) != 0 {
+		return "", fmt.Errorf("datastore %s has its own certificates", ds.UUID)
+	}
+	host := ds.Fqdn
+	for _, scheme := range []string{"docker://", "oci://", "https://"} {
+		host = strings.TrimPrefix(host, scheme)
+	}
+	host = strings.TrimSuffix(host, "/")
+	if host == "" {
+		return "", fmt.Errorf("datastore %s has no registry", ds.UUID)
+	}
+	ref := host
+	if ds.Dpath != "" {
+		ref = ref + "/" + strings.Trim(ds.Dpath, "/")
+	}
+	return ref + "/" + strings.TrimPrefix(relativeURL, "/"), nil
+}
+
+// lazyPullAllowed returns true if the content tree may be pulled lazily,
+// leaving out the check of the image itself
+func lazyPullAllowed(ctx *volumemgrContext, status *types.ContentTreeStatus) bool {
+	return !status.LazyPullFailed && status.IsContainer() && status.IsOCIRegistry() &&
+		ctx.globalConfig.GlobalValueBool(types.LazyImagePull)
+}
+
+// lazyBlobChildren returns the children of the verified manifest blob which
+// the content tree needs, which are all of them unless its layers are left
+// to the remote snapshotter. Decides on the latter when first called for
+// the content tree.
+func lazyBlobChildren(ctx *volumemgrContext, status *types.ContentTreeStatus,
+	blob *types.BlobStatus, children []*types.BlobStatus) []*types.BlobStatus {
+
+	if !status.LazyPull && !lazyPullAllowed(ctx, status) {
+		return children
+	}
+	manifest, err := resolveManifest(ctx, blob)
+	if err != nil {
+		log.Errorf("lazyBlobChildren(%s): pulling in full: %v", status.Key(), err)
+		status.LazyPull = false
+		status.LazyPullFailed = true
+		return children
+	}
+	if !status.LazyPull {
+		if !isEStargzManifest(manifest) {
+			log.Functionf("lazyBlobChildren(%s): %s is not an eStargz image",
+				status.Key(), status.RelativeURL)
+			return children
+		}
+		ds, err := utils.LookupDatastoreConfig(ctx.subDatastoreConfig, status.DatastoreID)
+		if err != nil {
+			log.Errorf("lazyBlobChildren(%s): %v", status.Key(), err)
+			return children
+		}
+		ref, err := registryImageRef(*ds, status.RelativeURL)
+		if err != nil {
+			log.Noticef("lazyBlobChildren(%s): pulling in full: %v", status.Key(), err)
+			return children
+		}
+		if !ctx.casClient.LazyPullAvailable() {
+			log.Noticef("lazyBlobChildren(%s): pulling in full: no remote snapshotter",
+				status.Key())
+			return children
+		}
+		log.Noticef("lazyBlobChildren(%s): pulling %s lazily", status.Key(), ref)
+		status.LazyPull = true
+		status.ImageRef = ref
+	}
+	return withoutLayers(children, manifest)
+}
+
+// fallbackToFullPull adds the layers to the content tree of the volume whose
+// creation by the remote snapshotter failed, and waits for them again
+func fallbackToFullPull(ctx *volumemgrContext, status *types.VolumeStatus,
+	ctStatus *types.ContentTreeStatus, reason error) {
+
+	log.Warnf("fallbackToFullPull(%s): pulling content tree %s in full: %v",
+		status.Key(), ctStatus.Key(), reason)
+	ctStatus.LazyPull = false
+	ctStatus.LazyPullFailed = true
+	ctStatus.State = types.DOWNLOADING
+	DeleteWorkLoad(ctx, ctStatus.Key())
+	doUpdateContentTree(ctx, ctStatus)
+	publishContentTreeStatus(ctx, ctStatus)
+
+	DeleteWorkCreate(ctx, status)
+	status.State = ctStatus.State
+	status.SubState = types.VolumeSubStateInitial
+	status.LazyPull = false
+}
+
+// updateVolumeLocalSize updates how much of the image of the container
+// volume is on the device. Returns changed.
+func updateVolumeLocalSize(ctx *volumemgrContext, status *types.VolumeStatus) bool {
+	local, total, err := ctx.casClient.ImageLocalSize(status.ReferenceName)
+	if err != nil {
+		log.Errorf("updateVolumeLocalSize(%s): %v", status.Key(), err)
+		return false
+	}
+	if status.LocalSize == local && status.ImageSize == total {
+		return false
+	}
+	status.LocalSize = local
+	status.ImageSize = total
+	return true
+}
+
+// updateLazyVolumes updates how much of the images of the lazily pulled
+// volumes has been fetched
+func updateLazyVolumes(ctx *volumemgrContext) {
+	for _, status := range getAllVolumeStatus(ctx) {
+		if !status.LazyPull || status.State != types.CREATED_VOLUME ||
+			(status.ImageSize != 0 && status.LocalSize >= status.ImageSize) {
+			continue
+		}
+		if updateVolumeLocalSize(ctx, status) {
+			publishVolumeStatus(ctx, status)
+		}
+	}
+}
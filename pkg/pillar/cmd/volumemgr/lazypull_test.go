@@ -0,0 +1,82 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package volumemgr
+
+import (
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/lf-edge/eve/pkg/pillar/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func layer(hex string, estargz bool) v1.Descriptor {
+	d := v1.Descriptor{Digest: v1.Hash{Algorithm: "sha256", Hex: hex}}
+	if estargz {
+		d.Annotations = map[string]string{estargzTOCAnnotation: "sha256:abcd"}
+	}
+	return d
+}
+
+func TestIsEStargzManifest(t *testing.T) {
+	assert.False(t, isEStargzManifest(nil))
+	assert.False(t, isEStargzManifest(&v1.Manifest{}))
+	assert.True(t, isEStargzManifest(&v1.Manifest{
+		Layers: []v1.Descriptor{layer("aa", true), layer("bb", true)},
+	}))
+	assert.False(t, isEStargzManifest(&v1.Manifest{
+		Layers: []v1.Descriptor{layer("aa", true), layer("bb", false)},
+	}))
+}
+
+func TestWithoutLayers(t *testing.T) {
+	manifest := &v1.Manifest{
+		Config: layer("cc", false),
+		Layers: []v1.Descriptor{layer("AA", true), layer("bb", true)},
+	}
+	blobs := []*types.BlobStatus{{Sha256: "cc"}, {Sha256: "aa"}, {Sha256: "bb"}}
+	children := withoutLayers(blobs, manifest)
+	assert.Len(t, children, 1)
+	assert.Equal(t, "cc", children[0].Sha256)
+}
+
+func TestRegistryImageRef(t *testing.T) {
+	testMatrix := map[string]struct {
+		ds       types.DatastoreConfig
+		expected string
+		fails    bool
+	}{
+		"docker hub": {
+			ds:       types.DatastoreConfig{Fqdn: "docker://docker.io"},
+			expected: "docker.io/library/nginx@sha256:1234",
+		},
+		"with path": {
+			ds:       types.DatastoreConfig{Fqdn: "oci://ghcr.io/", Dpath: "/org/"},
+			expected: "ghcr.io/org/library/nginx@sha256:1234",
+		},
+		"credentials": {
+			ds:    types.DatastoreConfig{Fqdn: "docker.io", Password: "secret"},
+			fails: true,
+		},
+		"encrypted credentials": {
+			ds: types.DatastoreConfig{Fqdn: "docker.io",
+				CipherBlockStatus: types.CipherBlockStatus{IsCipher: true}},
+			fails: true,
+		},
+		"no registry": {
+			ds:    types.DatastoreConfig{},
+			fails: true,
+		},
+	}
+	for testname, test := range testMatrix {
+		t.Logf("Running test case %s", testname)
+		ref, err := registryImageRef(test.ds, "library/nginx@sha256:1234")
+		if test.fails {
+			assert.Error(t, err, testname)
+			continue
+		}
+		assert.NoError(t, err, testname)
+		assert.Equal(t, test.expected, ref, testname)
+	}
+}
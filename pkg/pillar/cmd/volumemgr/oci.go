@@ -67,6 +67,26 @@ func resolveIndex(ctx *volumemgrContext, blob *types.BlobStatus) (*v1.Descriptor
 // resolveManifestChildren get all of the children of a manifest, as well as
 // expected total size
 func resolveManifestChildren(ctx *volumemgrContext, blob *types.BlobStatus) (int64, []v1.Descriptor, error) {
+	manifest, err := resolveManifest(ctx, blob)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// get all of the parts and calculate the size
+	var size int64
+
+	children := []v1.Descriptor{}
+	children = append(children, manifest.Config)
+	size += manifest.Config.Size
+	for _, l := range manifest.Layers {
+		children = append(children, l)
+		size += l.Size
+	}
+	return size, children, nil
+}
+
+// resolveManifest parses the manifest blob
+func resolveManifest(ctx *volumemgrContext, blob *types.BlobStatus) (*v1.Manifest, error) {
 	var manifest *v1.Manifest
 
 	ctrdCtx, done := ctx.casClient.CtrNewUserServicesCtx()
@@ -78,44 +98,33 @@ func resolveManifestChildren(ctx *volumemgrContext, blob *types.BlobStatus) (int
 		// try it as an index and as a straight manifest
 		reader, err := ctx.casClient.ReadBlob(ctrdCtx, blobHash)
 		if err != nil {
-			err = fmt.Errorf("resolveManifestChildren(%s): Exception while reading blob: %v", blob.Sha256, err)
+			err = fmt.Errorf("resolveManifest(%s): Exception while reading blob: %v", blob.Sha256, err)
 			log.Errorf(err.Error())
-			return 0, nil, err
+			return nil, err
 		}
 		manifest, err = v1.ParseManifest(reader)
 		if err != nil && err != io.EOF {
-			err = fmt.Errorf("resolveManifestChildren(%s): Exception while parsing Index from cas: %v", blob.Sha256, err)
+			err = fmt.Errorf("resolveManifest(%s): Exception while parsing Index from cas: %v", blob.Sha256, err)
 			log.Errorf(err.Error())
-			return 0, nil, err
+			return nil, err
 		}
 	} else {
 		fileReader, err := os.Open(blob.Path)
 		if err != nil {
-			err = fmt.Errorf("resolveManifestChildren(%s): failed to open file %s: %v", blob.Sha256, blob.Path, err)
+			err = fmt.Errorf("resolveManifest(%s): failed to open file %s: %v", blob.Sha256, blob.Path, err)
 			log.Errorf(err.Error())
-			return 0, nil, err
+			return nil, err
 		}
 		manifest, err = v1.ParseManifest(fileReader)
 		if err != nil && err != io.EOF {
-			err = fmt.Errorf("resolveManifestChildren(%s): Exception while parsing Index from %s: %v",
+			err = fmt.Errorf("resolveManifest(%s): Exception while parsing Index from %s: %v",
 				blob.Sha256, blob.Path, err)
 			log.Errorf(err.Error())
-			return 0, nil, err
+			return nil, err
 		}
 		defer fileReader.Close()
 	}
-
-	// get all of the parts and calculate the size
-	var size int64
-
-	children := []v1.Descriptor{}
-	children = append(children, manifest.Config)
-	size += manifest.Config.Size
-	for _, l := range manifest.Layers {
-		children = append(children, l)
-		size += l.Size
-	}
-	return size, children, nil
+	return manifest, nil
 }
 
 // descriptorSizes calculate the size of all of the descriptors, normally from
@@ -19,7 +19,7 @@ func getVolumeFilePath(ctx *volumemgrContext, status types.VolumeStatus) (string
 	puller := registry.Puller{
 		Image: status.ReferenceName,
 	}
-	casClient, err := cas.NewCAS(casClientType)
+	casClient, err := cas.NewCAS(ctx.casType)
 	if err != nil {
 		err = fmt.Errorf("getVolumeFilePathAndVSize: exception while initializing CAS client: %s", err.Error())
 		return "", err
@@ -173,6 +173,9 @@ func doUpdateContentTree(ctx *volumemgrContext, status *types.ContentTreeStatus)
 				log.Tracef("doUpdateContentTree: blob sha %s download state VERIFIED", blob.Sha256)
 				// if verified, check for any children and start them off
 				blobChildren := blobsNotInList(getBlobChildren(ctx, blob), status.Blobs)
+				if blob.IsManifest() && len(blobChildren) > 0 {
+					blobChildren = lazyBlobChildren(ctx, status, blob, blobChildren)
+				}
 				if len(blobChildren) > 0 {
 					log.Functionf("doUpdateContentTree: adding %d children", len(blobChildren))
 					// add all of the children
@@ -202,8 +205,9 @@ func doUpdateContentTree(ctx *volumemgrContext, status *types.ContentTreeStatus)
 		}
 
 		// The manifestTotalSize does not include the size of the
-		// manifest itself but we set it as an initial approximation
-		if totalSize < manifestTotalSize {
+		// manifest itself but we set it as an initial approximation.
+		// The layers of a lazily pulled tree are not downloaded.
+		if totalSize < manifestTotalSize && !status.LazyPull {
 			log.Functionf("doUpdateContentTree: manifestTotal %d total %d",
 				manifestTotalSize, totalSize)
 			totalSize = manifestTotalSize
@@ -548,6 +552,11 @@ func doUpdateVol(ctx *volumemgrContext, status *types.VolumeStatus) (bool, bool)
 					status.FileLocation = vr.FileLocation
 					changed = true
 				}
+				if vr.Error != nil && ctStatus.LazyPull {
+					fallbackToFullPull(ctx, status, ctStatus, vr.Error)
+					changed = true
+					return changed, false
+				}
 				if vr.Error != nil {
 					log.Errorf("doUpdate: Error recieved from the volume worker %v",
 						vr.Error)
@@ -585,6 +594,10 @@ func doUpdateVol(ctx *volumemgrContext, status *types.VolumeStatus) (bool, bool)
 					changed = true
 				}
 			}
+			if status.IsContainer() {
+				status.LazyPull = ctStatus.LazyPull
+				updateVolumeLocalSize(ctx, status)
+			}
 			persistFsType := vault.ReadPersistType()
 			updateStatusByPersistType(status, persistFsType)
 			return changed, true
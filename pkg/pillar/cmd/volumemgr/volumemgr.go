@@ -33,9 +33,8 @@ const (
 	volumeEncryptedDirName = types.VolumeEncryptedDirName // We store encrypted VM and OCI volumes here
 	volumeClearDirName     = types.VolumeClearDirName     // We store un-encrypted VM and OCI volumes here
 	// Time limits for event loop handlers
-	errorTime     = 3 * time.Minute
-	warningTime   = 40 * time.Second
-	casClientType = "containerd"
+	errorTime   = 3 * time.Minute
+	warningTime = 40 * time.Second
 )
 
 // Set from Makefile
@@ -66,11 +65,13 @@ type volumemgrContext struct {
 	pubBlobStatus           pubsub.Publication
 	pubDiskMetric           pubsub.Publication
 	pubAppDiskMetric        pubsub.Publication
+	pubContentGCStatus      pubsub.Publication
 	subDatastoreConfig      pubsub.Subscription
 	subZVolStatus           pubsub.Subscription
 	diskMetricsTickerHandle interface{}
 	gc                      *time.Ticker
 	deferDelete             *time.Ticker
+	contentGC               *time.Ticker
 
 	worker worker.Worker // For background work
 
@@ -84,9 +85,15 @@ type volumemgrContext struct {
 	GCInitialized      bool
 	vdiskGCTime        uint32 // In seconds; XXX delete when OldVolumeStatus is deleted
 	deferContentDelete uint32 // In seconds
+	contentGCInterval  uint32 // In seconds
+	contentGCMinAge    time.Duration
+	contentGCPolicy    types.ContentGCPolicy
+	// When each orphan was first found by the content GC
+	contentGCFirstSeen map[string]time.Time
 	// Common CAS client which can be used by multiple routines.
 	// There is no shared data so its safe to be used by multiple goroutines
 	casClient cas.CAS
+	casType   string // the name of casClient for the other clients
 
 	volumeConfigCreateDeferredMap map[string]*types.VolumeConfig
 
@@ -124,6 +131,10 @@ func Run(ps *pubsub.PubSub, loggerArg *logrus.Logger, logArg *base.LogObject) in
 		ps:                 ps,
 		vdiskGCTime:        3600,
 		deferContentDelete: 0,
+		contentGCInterval:  3600,
+		contentGCMinAge:    time.Hour,
+		contentGCPolicy:    types.ContentGCReport,
+		contentGCFirstSeen: make(map[string]time.Time),
 		globalConfig:       types.DefaultConfigItemValueMap(),
 		persistType:        vault.ReadPersistType(),
 	}
@@ -261,6 +272,17 @@ func Run(ps *pubsub.PubSub, loggerArg *logrus.Logger, logArg *base.LogObject) in
 	}
 	ctx.pubAppDiskMetric = pubAppDiskMetric
 
+	pubContentGCStatus, err := ps.NewPublication(
+		pubsub.PublicationOptions{
+			AgentName: agentName,
+			TopicType: types.ContentGCStatus{},
+		},
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx.pubContentGCStatus = pubContentGCStatus
+
 	// Look for global config such as log levels
 	subZedAgentStatus, err := ps.NewSubscription(pubsub.SubscriptionOptions{
 		AgentName:     "zedagent",
@@ -463,7 +485,9 @@ func Run(ps *pubsub.PubSub, loggerArg *logrus.Logger, logArg *base.LogObject) in
 	populateExistingVolumesFormat(volumeEncryptedDirName)
 	populateExistingVolumesFormat(volumeClearDirName)
 
-	if ctx.casClient, err = cas.NewCAS(casClientType); err != nil {
+	ctx.casType = cas.SelectedCAS()
+	log.Noticef("Using %s CAS", ctx.casType)
+	if ctx.casClient, err = cas.NewCAS(ctx.casType); err != nil {
 		err = fmt.Errorf("Run: exception while initializing CAS client: %s", err.Error())
 		log.Fatal(err)
 	}
@@ -515,6 +539,12 @@ func Run(ps *pubsub.PubSub, loggerArg *logrus.Logger, logArg *base.LogObject) in
 	ctx.deferDelete = time.NewTicker(time.Hour)
 	ctx.deferDelete.Stop()
 
+	// The content GC does nothing until initGced is set
+	ctx.contentGC = time.NewTicker(time.Duration(ctx.contentGCInterval) * time.Second)
+
+	// Report how much of the lazily pulled images has been fetched
+	lazyPullTicker := time.NewTicker(lazyPullInterval)
+
 	// start the metrics reporting task
 	diskMetricsTickerHandle := make(chan interface{})
 	log.Functionf("Creating %s at %s", "diskMetricsTimerTask", agentlog.GetMyStack())
@@ -575,6 +605,18 @@ func Run(ps *pubsub.PubSub, loggerArg *logrus.Logger, logArg *base.LogObject) in
 			ps.CheckMaxTimeTopic(agentName, "deferDelete", start,
 				warningTime, errorTime)
 
+		case <-ctx.contentGC.C:
+			start := time.Now()
+			gcContent(&ctx)
+			ps.CheckMaxTimeTopic(agentName, "contentGC", start,
+				warningTime, errorTime)
+
+		case <-lazyPullTicker.C:
+			start := time.Now()
+			updateLazyVolumes(&ctx)
+			ps.CheckMaxTimeTopic(agentName, "lazyPull", start,
+				warningTime, errorTime)
+
 		case res := <-ctx.worker.MsgChan():
 			res.Process(&ctx, true)
 
@@ -719,4 +761,36 @@ func maybeUpdateConfigItems(ctx *volumemgrContext, newConfigItemValueMap *types.
 			ctx.deferDelete = time.NewTicker(duration * time.Second)
 		}
 	}
+	newGCInterval := newConfigItemValueMap.GlobalValueInt(types.ContentGCInterval)
+	if newGCInterval != 0 && newGCInterval != ctx.contentGCInterval {
+		log.Noticef("maybeUpdateConfigItems: Updating contentGCInterval from %d to %d",
+			ctx.contentGCInterval, newGCInterval)
+		ctx.contentGCInterval = newGCInterval
+		// Ticker is created once we are handling all inputs
+		if ctx.contentGC != nil {
+			ctx.contentGC.Stop()
+			ctx.contentGC = time.NewTicker(time.Duration(newGCInterval) * time.Second)
+		}
+	}
+	ctx.contentGCMinAge = time.Duration(newConfigItemValueMap.
+		GlobalValueInt(types.ContentGCMinAge)) * time.Second
+	policyStr := newConfigItemValueMap.GlobalValueString(types.ContentGCPolicyKey)
+	policy, err := types.ParseContentGCPolicy(policyStr)
+	if err != nil {
+		log.Errorf("maybeUpdateConfigItems: %v", err)
+	}
+	if policy != ctx.contentGCPolicy {
+		log.Noticef("maybeUpdateConfigItems: Updating contentGCPolicy from %s to %s",
+			ctx.contentGCPolicy, policy)
+		ctx.contentGCPolicy = policy
+	}
+	newLazy := newConfigItemValueMap.GlobalValueBool(types.LazyImagePull)
+	if newLazy != oldConfigItemValueMap.GlobalValueBool(types.LazyImagePull) {
+		log.Noticef("maybeUpdateConfigItems: Updating lazyImagePull to %t", newLazy)
+		// EVE does not ship a stargz snapshotter, see docs/volumemgr.md
+		if newLazy && ctx.casClient != nil && !ctx.casClient.LazyPullAvailable() {
+			log.Warnf("maybeUpdateConfigItems: %s is set but containerd has no remote snapshotter; images are pulled in full",
+				types.LazyImagePull)
+		}
+	}
 }
@@ -0,0 +1,352 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+// Console sessions to app instances multiplexed over the tunnel
+
+package wstunnelclient
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lf-edge/eve/pkg/pillar/base"
+	"github.com/lf-edge/eve/pkg/pillar/hypervisor"
+	"github.com/lf-edge/eve/pkg/pillar/types"
+	"github.com/lf-edge/eve/pkg/pillar/zedcloud"
+)
+
+const (
+	consoleSerial = "serial" // serial console (hvc for KVM, xl console for Xen, TTY for containers)
+	consoleVNC    = "vnc"    // raw RFB connection to the VNC server of the domain
+
+	vncBasePort = 5900
+	// Input frames queued for a console before we start dropping them
+	consoleInputQueue = 64
+	consoleReadSize   = 32 * 1024
+)
+
+// consoleTunnel is what the console sessions need from the tunnel
+type consoleTunnel interface {
+	SendConsoleMessage(msg zedcloud.ConsoleMessage) error
+}
+
+type consoleSession struct {
+	id       string
+	app      string
+	console  string
+	tunnel   consoleTunnel
+	input    chan []byte
+	done     chan struct{}
+	started  time.Time
+	bytesIn  uint64
+	bytesOut uint64
+	audit    *base.LogObject
+	// conn is nil until the session is attached to the console
+	sync.Mutex
+	conn   io.ReadWriteCloser
+	closed bool
+}
+
+// consoleSessions are the open console sessions keyed by session id
+type consoleSessions struct {
+	sync.Mutex
+	sessions map[string]*consoleSession
+	// attach connects to a console, attachConsole unless testing
+	attach func(app string, console string) (io.ReadWriteCloser, error)
+}
+
+func (cs *consoleSessions) lookup(id string) *consoleSession {
+	cs.Lock()
+	defer cs.Unlock()
+	return cs.sessions[id]
+}
+
+// add returns false if the session id is already in use
+func (cs *consoleSessions) add(session *consoleSession) bool {
+	cs.Lock()
+	defer cs.Unlock()
+	if cs.sessions == nil {
+		cs.sessions = make(map[string]*consoleSession)
+	}
+	if _, ok := cs.sessions[session.id]; ok {
+		return false
+	}
+	cs.sessions[session.id] = session
+	return true
+}
+
+func (cs *consoleSessions) remove(id string) {
+	cs.Lock()
+	defer cs.Unlock()
+	delete(cs.sessions, id)
+}
+
+// list returns the sessions for app, or all of them if app is empty
+func (cs *consoleSessions) list(app string) []*consoleSession {
+	cs.Lock()
+	defer cs.Unlock()
+	var ret []*consoleSession
+	for _, s := range cs.sessions {
+		if app == "" || s.app == app {
+			ret = append(ret, s)
+		}
+	}
+	return ret
+}
+
+// handleConsoleMessage is the ConsoleHandler of the tunnel. It is called
+// from the goroutine reading the websocket, which also relays VNC, hence
+// anything which may block, including writing to the tunnel, is done in
+// the background.
+func handleConsoleMessage(ctx *wstunnelclientContext, tunnel consoleTunnel,
+	msg zedcloud.ConsoleMessage) {
+
+	switch msg.Op {
+	case zedcloud.ConsoleOpOpen:
+		session := &consoleSession{
+			id:      msg.Session,
+			app:     msg.App,
+			console: msg.Console,
+			tunnel:  tunnel,
+			input:   make(chan []byte, consoleInputQueue),
+			done:    make(chan struct{}),
+			started: time.Now(),
+			audit: log.CloneAndAddFields(map[string]interface{}{
+				"console-session": msg.Session,
+				"app-uuid":        msg.App,
+				"console":         msg.Console,
+			}),
+		}
+		// registered right away so that the input sent by the controller
+		// before the console is attached is queued rather than refused
+		if !ctx.consoles.add(session) {
+			go sendConsoleReply(tunnel, msg, "session already open")
+			return
+		}
+		go openConsoleSession(ctx, session)
+	case zedcloud.ConsoleOpData:
+		session := ctx.consoles.lookup(msg.Session)
+		if session == nil {
+			log.Warnf("handleConsoleMessage: data for unknown session %s", msg.Session)
+			go sendConsoleClose(tunnel, msg.Session, "unknown session")
+			return
+		}
+		select {
+		case session.input <- msg.Data:
+		default:
+			log.Warnf("handleConsoleMessage: session %s input queue full, dropping %d bytes",
+				msg.Session, len(msg.Data))
+		}
+	case zedcloud.ConsoleOpClose:
+		if session := ctx.consoles.lookup(msg.Session); session != nil {
+			// detaching from a console may talk to containerd
+			if conn, ok := session.markClosed(ctx); ok {
+				go session.detach(conn, "closed by controller", false)
+			}
+		}
+	default:
+		log.Errorf("handleConsoleMessage: unknown op %s for session %s",
+			msg.Op, msg.Session)
+	}
+}
+
+// openConsoleSession attaches the new session to the console of the
+// domain, provided the AppInstanceConfig allows it, and replies with the
+// outcome
+func openConsoleSession(ctx *wstunnelclientContext, session *consoleSession) {
+	request := zedcloud.ConsoleMessage{
+		Session: session.id,
+		App:     session.app,
+		Console: session.console,
+	}
+	conn, err := ctx.consoles.attach(session.app, session.console)
+	if err != nil {
+		session.audit.Noticef("console session denied: %v", err)
+		ctx.consoles.remove(session.id)
+		sendConsoleReply(session.tunnel, request, err.Error())
+		return
+	}
+	session.Lock()
+	closed := session.closed
+	if !closed {
+		session.conn = conn
+	}
+	session.Unlock()
+	if closed {
+		// closed by the controller or torn down while attaching
+		conn.Close()
+		return
+	}
+	session.audit.Noticef("console session opened")
+	if err := sendConsoleReply(session.tunnel, request, ""); err != nil {
+		closeConsoleSession(ctx, session, "tunnel write failed", false)
+		return
+	}
+	go consoleWriter(ctx, session)
+	go consoleReader(ctx, session)
+}
+
+// sendConsoleReply answers the open request with errStr, if any
+func sendConsoleReply(tunnel consoleTunnel, request zedcloud.ConsoleMessage, errStr string) error {
+	reply := zedcloud.ConsoleMessage{
+		Session: request.Session,
+		Op:      zedcloud.ConsoleOpOpen,
+		App:     request.App,
+		Console: request.Console,
+		Error:   errStr,
+	}
+	err := tunnel.SendConsoleMessage(reply)
+	if err != nil {
+		log.Error(err)
+	}
+	return err
+}
+
+// attachConsole checks that the app instance allows remote console and
+// connects to the requested console of its running domain
+func attachConsole(ctx *wstunnelclientContext, app string, console string) (io.ReadWriteCloser, error) {
+	c, _ := ctx.subAppInstanceConfig.Get(app)
+	if c == nil {
+		return nil, fmt.Errorf("unknown app instance %s", app)
+	}
+	config := c.(types.AppInstanceConfig)
+	if !config.RemoteConsole {
+		return nil, fmt.Errorf("remote console is not enabled for %s", config.DisplayName)
+	}
+	s, _ := ctx.subDomainStatus.Get(app)
+	if s == nil {
+		return nil, fmt.Errorf("no domain for %s", config.DisplayName)
+	}
+	status := s.(types.DomainStatus)
+	if !status.Activated {
+		return nil, fmt.Errorf("%s is not running", config.DisplayName)
+	}
+	switch console {
+	case consoleSerial:
+		opener, ok := ctx.hyper.Task(&status).(hypervisor.ConsoleOpener)
+		if !ok {
+			return nil, fmt.Errorf("%s does not support serial console",
+				ctx.hyper.Name())
+		}
+		return opener.OpenConsole(status.DomainName)
+	case consoleVNC:
+		if !status.EnableVnc {
+			return nil, fmt.Errorf("VNC is not enabled for %s", config.DisplayName)
+		}
+		return net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d",
+			vncBasePort+status.VncDisplay))
+	default:
+		return nil, fmt.Errorf("unknown console %s", console)
+	}
+}
+
+// consoleWriter feeds the input received from the controller to the console
+func consoleWriter(ctx *wstunnelclientContext, session *consoleSession) {
+	for {
+		select {
+		case data := <-session.input:
+			if _, err := session.conn.Write(data); err != nil {
+				closeConsoleSession(ctx, session,
+					fmt.Sprintf("console write failed: %v", err), true)
+				return
+			}
+			atomic.AddUint64(&session.bytesIn, uint64(len(data)))
+		case <-session.done:
+			return
+		}
+	}
+}
+
+// consoleReader sends the console output to the controller until either
+// side goes away
+func consoleReader(ctx *wstunnelclientContext, session *consoleSession) {
+	buf := make([]byte, consoleReadSize)
+	for {
+		n, err := session.conn.Read(buf)
+		if n > 0 {
+			msg := zedcloud.ConsoleMessage{
+				Session: session.id,
+				Op:      zedcloud.ConsoleOpData,
+				Data:    buf[:n],
+			}
+			if err := session.tunnel.SendConsoleMessage(msg); err != nil {
+				closeConsoleSession(ctx, session, err.Error(), false)
+				return
+			}
+			atomic.AddUint64(&session.bytesOut, uint64(n))
+		}
+		if err != nil {
+			reason := "console closed"
+			if !errors.Is(err, io.EOF) {
+				reason = fmt.Sprintf("console read failed: %v", err)
+			}
+			closeConsoleSession(ctx, session, reason, true)
+			return
+		}
+	}
+}
+
+// closeConsoleSession detaches from the console and records the session
+// in the audit log. If notify is set the controller is told about it.
+func closeConsoleSession(ctx *wstunnelclientContext, session *consoleSession,
+	reason string, notify bool) {
+
+	if conn, ok := session.markClosed(ctx); ok {
+		session.detach(conn, reason, notify)
+	}
+}
+
+// markClosed ends the session unless that is already done, and returns
+// the console to detach from, if the session got attached
+func (session *consoleSession) markClosed(ctx *wstunnelclientContext) (io.ReadWriteCloser, bool) {
+	session.Lock()
+	if session.closed {
+		session.Unlock()
+		return nil, false
+	}
+	session.closed = true
+	conn := session.conn
+	session.Unlock()
+
+	ctx.consoles.remove(session.id)
+	close(session.done)
+	return conn, true
+}
+
+func (session *consoleSession) detach(conn io.ReadWriteCloser, reason string, notify bool) {
+	if conn != nil {
+		conn.Close()
+	}
+	if notify {
+		sendConsoleClose(session.tunnel, session.id, reason)
+	}
+	session.audit.CloneAndAddFields(map[string]interface{}{
+		"bytes-in":  atomic.LoadUint64(&session.bytesIn),
+		"bytes-out": atomic.LoadUint64(&session.bytesOut),
+		"duration":  time.Since(session.started).String(),
+	}).Noticef("console session closed: %s", reason)
+}
+
+// closeConsoleSessions closes the sessions for app, or all of them if
+// app is empty
+func closeConsoleSessions(ctx *wstunnelclientContext, app string, reason string) {
+	for _, session := range ctx.consoles.list(app) {
+		closeConsoleSession(ctx, session, reason, true)
+	}
+}
+
+func sendConsoleClose(tunnel consoleTunnel, id string, reason string) {
+	msg := zedcloud.ConsoleMessage{
+		Session: id,
+		Op:      zedcloud.ConsoleOpClose,
+		Error:   reason,
+	}
+	if err := tunnel.SendConsoleMessage(msg); err != nil {
+		log.Function(err)
+	}
+}
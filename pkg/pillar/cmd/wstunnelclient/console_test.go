@@ -0,0 +1,191 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package wstunnelclient
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lf-edge/eve/pkg/pillar/base"
+	"github.com/lf-edge/eve/pkg/pillar/zedcloud"
+	"github.com/sirupsen/logrus"
+)
+
+// testTunnel records what is sent to the controller
+type testTunnel struct {
+	sent chan zedcloud.ConsoleMessage
+}
+
+func (t *testTunnel) SendConsoleMessage(msg zedcloud.ConsoleMessage) error {
+	// the reader reuses its buffer
+	msg.Data = append([]byte(nil), msg.Data...)
+	t.sent <- msg
+	return nil
+}
+
+func (t *testTunnel) expect(tt *testing.T, op string, errStr string) zedcloud.ConsoleMessage {
+	tt.Helper()
+	select {
+	case msg := <-t.sent:
+		if msg.Op != op || msg.Error != errStr {
+			tt.Fatalf("sent %s %q, expected %s %q", msg.Op, msg.Error, op, errStr)
+		}
+		return msg
+	case <-time.After(5 * time.Second):
+		tt.Fatalf("nothing sent, expected %s %q", op, errStr)
+	}
+	return zedcloud.ConsoleMessage{}
+}
+
+func initConsoleTest(attach func(string, string) (io.ReadWriteCloser, error)) (*wstunnelclientContext, *testTunnel) {
+	log = base.NewSourceLogObject(logrus.StandardLogger(), "wstunnelclient", 0)
+	ctx := &wstunnelclientContext{}
+	ctx.consoles.attach = attach
+	return ctx, &testTunnel{sent: make(chan zedcloud.ConsoleMessage, 16)}
+}
+
+func openMsg(session string) zedcloud.ConsoleMessage {
+	return zedcloud.ConsoleMessage{
+		Session: session,
+		Op:      zedcloud.ConsoleOpOpen,
+		App:     "app",
+		Console: consoleSerial,
+	}
+}
+
+func readConsole(t *testing.T, conn net.Conn, n int) string {
+	t.Helper()
+	buf := make([]byte, n)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("read from console: %v", err)
+	}
+	return string(buf)
+}
+
+func TestConsoleSession(t *testing.T) {
+	domain, console := net.Pipe()
+	ctx, tunnel := initConsoleTest(func(app string, name string) (io.ReadWriteCloser, error) {
+		return console, nil
+	})
+
+	handleConsoleMessage(ctx, tunnel, openMsg("s1"))
+	tunnel.expect(t, zedcloud.ConsoleOpOpen, "")
+
+	handleConsoleMessage(ctx, tunnel, zedcloud.ConsoleMessage{
+		Session: "s1", Op: zedcloud.ConsoleOpData, Data: []byte("ls\n"),
+	})
+	if got := readConsole(t, domain, 3); got != "ls\n" {
+		t.Errorf("console got %q", got)
+	}
+	if _, err := domain.Write([]byte("bin\n")); err != nil {
+		t.Fatal(err)
+	}
+	if msg := tunnel.expect(t, zedcloud.ConsoleOpData, ""); string(msg.Data) != "bin\n" {
+		t.Errorf("controller got %q", msg.Data)
+	}
+
+	handleConsoleMessage(ctx, tunnel, zedcloud.ConsoleMessage{
+		Session: "s1", Op: zedcloud.ConsoleOpClose,
+	})
+	_ = domain.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := domain.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("console not detached: %v", err)
+	}
+	if ctx.consoles.lookup("s1") != nil {
+		t.Error("session still open")
+	}
+}
+
+func TestConsoleSessionClosedByDomain(t *testing.T) {
+	domain, console := net.Pipe()
+	ctx, tunnel := initConsoleTest(func(app string, name string) (io.ReadWriteCloser, error) {
+		return console, nil
+	})
+
+	handleConsoleMessage(ctx, tunnel, openMsg("s1"))
+	tunnel.expect(t, zedcloud.ConsoleOpOpen, "")
+	domain.Close()
+	tunnel.expect(t, zedcloud.ConsoleOpClose, "console closed")
+	if ctx.consoles.lookup("s1") != nil {
+		t.Error("session still open")
+	}
+}
+
+func TestConsoleSessionDenied(t *testing.T) {
+	ctx, tunnel := initConsoleTest(func(app string, name string) (io.ReadWriteCloser, error) {
+		return nil, errors.New("remote console is not enabled for app")
+	})
+
+	handleConsoleMessage(ctx, tunnel, openMsg("s1"))
+	tunnel.expect(t, zedcloud.ConsoleOpOpen, "remote console is not enabled for app")
+	if ctx.consoles.lookup("s1") != nil {
+		t.Error("denied session registered")
+	}
+
+	handleConsoleMessage(ctx, tunnel, zedcloud.ConsoleMessage{
+		Session: "s1", Op: zedcloud.ConsoleOpData, Data: []byte("ls\n"),
+	})
+	tunnel.expect(t, zedcloud.ConsoleOpClose, "unknown session")
+}
+
+// attaching to a console may take long, which must neither hold up the
+// other messages nor lose the input sent meanwhile
+func TestConsoleSessionSlowAttach(t *testing.T) {
+	domain, console := net.Pipe()
+	release := make(chan struct{})
+	ctx, tunnel := initConsoleTest(func(app string, name string) (io.ReadWriteCloser, error) {
+		<-release
+		return console, nil
+	})
+
+	handleConsoleMessage(ctx, tunnel, openMsg("s1"))
+	handleConsoleMessage(ctx, tunnel, zedcloud.ConsoleMessage{
+		Session: "s1", Op: zedcloud.ConsoleOpData, Data: []byte("root\n"),
+	})
+	handleConsoleMessage(ctx, tunnel, openMsg("s1"))
+	tunnel.expect(t, zedcloud.ConsoleOpOpen, "session already open")
+
+	close(release)
+	tunnel.expect(t, zedcloud.ConsoleOpOpen, "")
+	if got := readConsole(t, domain, 5); got != "root\n" {
+		t.Errorf("console got %q", got)
+	}
+	closeConsoleSessions(ctx, "", "tunnel stopped")
+	tunnel.expect(t, zedcloud.ConsoleOpClose, "tunnel stopped")
+}
+
+func TestConsoleSessionClosedWhileAttaching(t *testing.T) {
+	domain, console := net.Pipe()
+	release := make(chan struct{})
+	attached := make(chan struct{})
+	ctx, tunnel := initConsoleTest(func(app string, name string) (io.ReadWriteCloser, error) {
+		<-release
+		defer close(attached)
+		return console, nil
+	})
+
+	handleConsoleMessage(ctx, tunnel, openMsg("s1"))
+	handleConsoleMessage(ctx, tunnel, zedcloud.ConsoleMessage{
+		Session: "s1", Op: zedcloud.ConsoleOpClose,
+	})
+	close(release)
+	<-attached
+
+	_ = domain.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := domain.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("console not detached: %v", err)
+	}
+	select {
+	case msg := <-tunnel.sent:
+		t.Errorf("sent %s %q for a closed session", msg.Op, msg.Error)
+	default:
+	}
+	if ctx.consoles.lookup("s1") != nil {
+		t.Error("session still open")
+	}
+}
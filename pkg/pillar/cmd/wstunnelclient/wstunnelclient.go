@@ -6,6 +6,7 @@ package wstunnelclient
 import (
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"strings"
 
@@ -15,6 +16,7 @@ import (
 	"github.com/google/go-cmp/cmp"
 	"github.com/lf-edge/eve/pkg/pillar/agentlog"
 	"github.com/lf-edge/eve/pkg/pillar/base"
+	"github.com/lf-edge/eve/pkg/pillar/hypervisor"
 	"github.com/lf-edge/eve/pkg/pillar/pidfile"
 	"github.com/lf-edge/eve/pkg/pillar/pubsub"
 	"github.com/lf-edge/eve/pkg/pillar/types"
@@ -46,10 +48,13 @@ type wstunnelclientContext struct {
 	subGlobalConfig      pubsub.Subscription
 	GCInitialized        bool
 	subAppInstanceConfig pubsub.Subscription
+	subDomainStatus      pubsub.Subscription
 	serverNameAndPort    string
 	wstunnelclient       *zedcloud.WSTunnelClient
 	dnsContext           *DNSContext
 	devUUID              uuid.UUID
+	hyper                hypervisor.Hypervisor
+	consoles             consoleSessions
 	// XXX add any output from scanAIConfigs()?
 }
 
@@ -61,8 +66,11 @@ var log *base.LogObject
 func Run(ps *pubsub.PubSub, loggerArg *logrus.Logger, logArg *base.LogObject) int {
 	logger = loggerArg
 	log = logArg
+	var err error
+	allHypervisors, enabledHypervisors := hypervisor.GetAvailableHypervisors()
 	versionPtr := flag.Bool("v", false, "Version")
 	debugPtr := flag.Bool("d", false, "Debug flag")
+	hypervisorPtr := flag.String("h", enabledHypervisors[0], fmt.Sprintf("Current hypervisor %+q", allHypervisors))
 	flag.Parse()
 	debug = *debugPtr
 	debugOverride = debug
@@ -90,6 +98,14 @@ func Run(ps *pubsub.PubSub, loggerArg *logrus.Logger, logArg *base.LogObject) in
 	}
 
 	wscCtx := wstunnelclientContext{}
+	// Used to attach to the consoles of the domains
+	wscCtx.hyper, err = hypervisor.GetHypervisor(*hypervisorPtr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	wscCtx.consoles.attach = func(app string, console string) (io.ReadWriteCloser, error) {
+		return attachConsole(&wscCtx, app, console)
+	}
 
 	// Look for global config such as log levels
 	subGlobalConfig, err := ps.NewSubscription(pubsub.SubscriptionOptions{
@@ -148,6 +164,24 @@ func Run(ps *pubsub.PubSub, loggerArg *logrus.Logger, logArg *base.LogObject) in
 	}
 	wscCtx.subAppInstanceConfig = subAppInstanceConfig
 
+	// Look for DomainStatus from domainmgr to find the running domains
+	subDomainStatus, err := ps.NewSubscription(pubsub.SubscriptionOptions{
+		AgentName:     "domainmgr",
+		MyAgentName:   agentName,
+		TopicImpl:     types.DomainStatus{},
+		Activate:      false,
+		Ctx:           &wscCtx,
+		ModifyHandler: handleDomainStatusModify,
+		DeleteHandler: handleDomainStatusDelete,
+		WarningTime:   warningTime,
+		ErrorTime:     errorTime,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	wscCtx.subDomainStatus = subDomainStatus
+	subDomainStatus.Activate()
+
 	//get server name
 	bytes, err := ioutil.ReadFile(types.ServerFileName)
 	if err != nil {
@@ -205,6 +239,9 @@ func Run(ps *pubsub.PubSub, loggerArg *logrus.Logger, logArg *base.LogObject) in
 		case change := <-subAppInstanceConfig.MsgChan():
 			subAppInstanceConfig.ProcessChange(change)
 
+		case change := <-subDomainStatus.MsgChan():
+			subDomainStatus.ProcessChange(change)
+
 		case <-stillRunning.C:
 		}
 		ps.StillRunning(agentName, warningTime, errorTime)
@@ -323,8 +360,11 @@ func handleAppInstanceConfigImpl(ctxArg interface{}, key string,
 	statusArg interface{}) {
 
 	log.Functionf("handleAppInstanceConfigImpl for %s\n", key)
-	// XXX config := configArg.(types.AppInstanceConfig)
+	config := statusArg.(types.AppInstanceConfig)
 	ctx := ctxArg.(*wstunnelclientContext)
+	if !config.RemoteConsole {
+		closeConsoleSessions(ctx, key, "remote console disabled")
+	}
 	scanAIConfigs(ctx)
 	log.Functionf("handleAppInstanceConfigImpl done for %s\n", key)
 }
@@ -335,10 +375,28 @@ func handleAppInstanceConfigDelete(ctxArg interface{}, key string,
 	log.Functionf("handleAppInstanceConfigDelete for %s\n", key)
 	// XXX config := configArg).(types.AppInstanceConfig)
 	ctx := ctxArg.(*wstunnelclientContext)
+	closeConsoleSessions(ctx, key, "app instance deleted")
 	scanAIConfigs(ctx)
 	log.Functionf("handleAppInstanceConfigDelete done for %s\n", key)
 }
 
+func handleDomainStatusModify(ctxArg interface{}, key string,
+	statusArg interface{}, oldStatusArg interface{}) {
+
+	status := statusArg.(types.DomainStatus)
+	ctx := ctxArg.(*wstunnelclientContext)
+	if !status.Activated {
+		closeConsoleSessions(ctx, key, "domain halted")
+	}
+}
+
+func handleDomainStatusDelete(ctxArg interface{}, key string,
+	statusArg interface{}) {
+
+	ctx := ctxArg.(*wstunnelclientContext)
+	closeConsoleSessions(ctx, key, "domain deleted")
+}
+
 // walk over all instances to determine new value
 func scanAIConfigs(ctx *wstunnelclientContext) {
 
@@ -356,6 +414,7 @@ func scanAIConfigs(ctx *wstunnelclientContext) {
 
 	if !isTunnelRequired {
 		if ctx.wstunnelclient != nil {
+			closeConsoleSessions(ctx, "", "tunnel stopped")
 			ctx.wstunnelclient.Stop()
 			ctx.wstunnelclient = nil
 		}
@@ -373,6 +432,12 @@ func scanAIConfigs(ctx *wstunnelclientContext) {
 			continue
 		}
 		wstunnelclient := zedcloud.InitializeTunnelClient(log, ctx.serverNameAndPort, "localhost:4822")
+		wstunnelclient.ConsoleHandler = func(msg zedcloud.ConsoleMessage) {
+			handleConsoleMessage(ctx, wstunnelclient, msg)
+		}
+		wstunnelclient.ConsoleReset = func() {
+			closeConsoleSessions(ctx, "", "tunnel disconnected")
+		}
 		destURL := wstunnelclient.Tunnel
 
 		addrCount, err := types.CountLocalAddrAnyNoLinkLocalIf(*deviceNetworkStatus,
@@ -200,9 +200,13 @@ func lookupDomainMetric(ctx *zedagentContext, uuidStr string) *types.DomainMetri
 	return &metric
 }
 
+// lookupAppContainerMetric returns the container metrics of an app from
+// zedrouter for docker-in-VM apps, or else from domainmgr for native ones
 func lookupAppContainerMetric(ctx *zedagentContext, uuidStr string) *types.AppContainerMetrics {
-	sub := ctx.subAppContainerMetrics
-	m, _ := sub.Get(uuidStr)
+	m, _ := ctx.subAppContainerMetrics.Get(uuidStr)
+	if m == nil {
+		m, _ = ctx.subDomainContainerMetrics.Get(uuidStr)
+	}
 	if m == nil {
 		return nil
 	}
@@ -586,6 +590,9 @@ func publishMetrics(ctx *zedagentContext, iteration int) {
 				aiStatus.DomainName, dm.CPUTotal, dm.UsedMemory,
 				dm.AvailableMemory, dm.UsedMemoryPercent)
 			ReportAppMetric.Cpu.Total = *proto.Uint64(dm.CPUTotal)
+			ReportAppMetric.Cpu.ThrottledPeriods = dm.Throttling.CPUThrottledPeriods
+			ReportAppMetric.Cpu.ThrottledTime = dm.Throttling.CPUThrottledTime
+			ReportAppMetric.PidsLimitHits = dm.Throttling.PidsLimitHits
 			ReportAppMetric.Memory.UsedMem = dm.UsedMemory
 			ReportAppMetric.Memory.AvailMem = dm.AvailableMemory
 			ReportAppMetric.Memory.UsedPercentage = dm.UsedMemoryPercent
@@ -667,7 +674,8 @@ func publishMetrics(ctx *zedagentContext, iteration int) {
 				appDiskDetails)
 		}
 
-		acMetric := lookupAppContainerMetric(ctx, aiStatus.UUIDandVersion.UUID.String())
+		appUUID := aiStatus.UUIDandVersion.UUID.String()
+		acMetric := lookupAppContainerMetric(ctx, appUUID)
 		// the new protocol is always fill in at least the module name to indicate
 		// it has not disappeared yet, even we don't have new info on metrics
 		if acMetric != nil {
@@ -676,7 +684,7 @@ func publishMetrics(ctx *zedagentContext, iteration int) {
 				appContainerMetric.AppContainerName = stats.ContainerName
 
 				// fill in the new metrics info for each module
-				if acMetric.CollectTime.Sub(ctx.appContainerStatsTime) > 0 {
+				if acMetric.CollectTime.Sub(ctx.appContainerStatsTime[appUUID]) > 0 {
 					appContainerMetric.Status = stats.Status
 					appContainerMetric.PIDs = stats.Pids
 
@@ -701,7 +709,7 @@ func publishMetrics(ctx *zedagentContext, iteration int) {
 
 				ReportAppMetric.Container = append(ReportAppMetric.Container, appContainerMetric)
 			}
-			ctx.appContainerStatsTime = acMetric.CollectTime
+			ctx.appContainerStatsTime[appUUID] = acMetric.CollectTime
 		}
 
 		ReportMetrics.Am = append(ReportMetrics.Am, ReportAppMetric)
@@ -892,6 +900,27 @@ func encodeNetworkPortConfig(ctx *zedagentContext,
 // This function is called per change, hence needs to try over all management ports
 // When aiStatus is nil it means a delete and we send a message
 // containing only the UUID to inform zedcloud about the delete.
+// encodeGuestInfo returns what the guest agent reports; the addresses are
+// added to the network of the app instead
+func encodeGuestInfo(guestInfo types.GuestInfo) *info.ZInfoGuest {
+	guest := &info.ZInfoGuest{
+		AgentRunning:  guestInfo.AgentRunning,
+		OsName:        guestInfo.OSName,
+		OsVersion:     guestInfo.OSVersion,
+		KernelRelease: guestInfo.KernelRelease,
+	}
+	for _, fs := range guestInfo.Filesystems {
+		guest.Filesystems = append(guest.Filesystems,
+			&info.ZInfoGuestFilesystem{
+				Mountpoint: fs.Mountpoint,
+				Type:       fs.Type,
+				UsedBytes:  fs.UsedBytes,
+				TotalBytes: fs.TotalBytes,
+			})
+	}
+	return guest
+}
+
 func PublishAppInfoToZedCloud(ctx *zedagentContext, uuid string,
 	aiStatus *types.AppInstanceStatus,
 	aa *types.AssignableAdapters, iteration int) {
@@ -967,6 +996,17 @@ func PublishAppInfoToZedCloud(ctx *zedagentContext, uuid string,
 				ifname)
 			networkInfo.IPAddrs = make([]string, 1)
 			networkInfo.IPAddrs[0] = *proto.String(ip)
+			// Add what the guest agent sees on the interface
+			for _, guestIP := range aiStatus.GuestInfo.IPAddrsByMac(macAddr) {
+				if guestIP == ip {
+					continue
+				}
+				if networkInfo.IPAddrs[0] == "" {
+					networkInfo.IPAddrs[0] = guestIP
+				} else {
+					networkInfo.IPAddrs = append(networkInfo.IPAddrs, guestIP)
+				}
+			}
 			networkInfo.MacAddr = *proto.String(macAddr)
 			networkInfo.Up = allocated
 			networkInfo.IpAddrMisMatch = ipAddrMismatch
@@ -1005,6 +1045,9 @@ func PublishAppInfoToZedCloud(ctx *zedagentContext, uuid string,
 			ReportAppInfo.VolumeRefs = append(ReportAppInfo.VolumeRefs,
 				vr.VolumeID.String())
 		}
+		if aiStatus.GuestInfo.AgentRunning {
+			ReportAppInfo.Guest = encodeGuestInfo(aiStatus.GuestInfo)
+		}
 	}
 
 	ReportInfo.InfoContent = new(info.ZInfoMsg_Ainfo)
@@ -17,6 +17,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/golang/protobuf/ptypes"
 	"github.com/google/go-cmp/cmp"
 	zconfig "github.com/lf-edge/eve/api/go/config"
 	"github.com/lf-edge/eve/pkg/pillar/types"
@@ -511,11 +512,49 @@ func parseAppInstanceConfig(config *zconfig.EdgeDevConfig,
 		appInstance.FixedResources.EnableVnc = cfgApp.Fixedresources.EnableVnc
 		appInstance.FixedResources.VncDisplay = cfgApp.Fixedresources.VncDisplay
 		appInstance.FixedResources.VncPasswd = cfgApp.Fixedresources.VncPasswd
+		appInstance.FixedResources.EnableVTPM = cfgApp.Fixedresources.EnableVtpm
+		appInstance.FixedResources.CPUWeight = parseCgroupWeight(&appInstance,
+			"CPU weight", cfgApp.Fixedresources.CpuWeight)
+		appInstance.FixedResources.CPUQuota = cfgApp.Fixedresources.CpuQuota
+		appInstance.FixedResources.IOWeight = parseCgroupWeight(&appInstance,
+			"IO weight", cfgApp.Fixedresources.IoWeight)
+		appInstance.FixedResources.ReadBps = cfgApp.Fixedresources.ReadBps
+		appInstance.FixedResources.WriteBps = cfgApp.Fixedresources.WriteBps
+		appInstance.FixedResources.ReadIOPS = cfgApp.Fixedresources.ReadIops
+		appInstance.FixedResources.WriteIOPS = cfgApp.Fixedresources.WriteIops
+		appInstance.FixedResources.PidsLimit = cfgApp.Fixedresources.PidsLimit
 		appInstance.MetaDataType = types.MetaDataType(cfgApp.MetaDataType)
 
 		appInstance.VolumeRefConfigList = make([]types.VolumeRefConfig,
 			len(cfgApp.VolumeRefList))
 		parseVolumeRefList(appInstance.VolumeRefConfigList, cfgApp.GetVolumeRefList())
+		if len(cfgApp.GetNextVolumeRefList()) != 0 {
+			appInstance.NextVolumeRefConfigList = make([]types.VolumeRefConfig,
+				len(cfgApp.GetNextVolumeRefList()))
+			parseVolumeRefList(appInstance.NextVolumeRefConfigList,
+				cfgApp.GetNextVolumeRefList())
+		}
+		if cfgApp.GetNextActivationTime() != nil {
+			t, err := ptypes.Timestamp(cfgApp.GetNextActivationTime())
+			if err != nil {
+				log.Errorf("parseAppInstanceConfig: bad next activation time for %s: %v",
+					cfgApp.Displayname, err)
+			} else {
+				appInstance.NextActivationTime = t
+			}
+		}
+
+		appInstance.Health = parseAppHealthConfig(cfgApp.GetHealth())
+		appInstance.PodContainers = parsePodContainers(cfgApp.GetPodContainers())
+		appInstance.InitContainers = parseInitContainers(cfgApp.GetInitContainers())
+		appInstance.Security = types.ContainerSecurity{
+			Profile: types.SecurityProfile(cfgApp.GetSecurity().GetProfile()),
+			CapAdd:  cfgApp.GetSecurity().GetCapAdd(),
+			CapDrop: cfgApp.GetSecurity().GetCapDrop(),
+		}
+		appInstance.KubeManifest = cfgApp.GetKubernetes().GetManifest()
+		appInstance.StartDependencies = parseStartDependencies(&appInstance,
+			cfgApp.GetStartDependencies())
 
 		// fill in the collect stats IP address of the App
 		appInstance.CollectStatsIPAddr = net.ParseIP(cfgApp.GetCollectStatsIPAddr())
@@ -1029,6 +1068,8 @@ func publishDatastoreConfig(ctx *getconfigContext,
 		}
 
 		datastore.DsCertPEM = ds.GetDsCertPEM()
+		datastore.DownloadWindow = ds.GetDownloadWindow()
+		datastore.DownloadMaxBps = ds.GetDownloadMaxBps()
 
 		datastore.CipherBlockStatus = parseCipherBlock(ctx, datastore.Key(),
 			ds.GetCipherData())
@@ -1076,6 +1117,108 @@ func parseVolumeRefList(volumeRefConfigList []types.VolumeRefConfig,
 	}
 }
 
+// parseCgroupWeight returns a weight on the cgroup v2 scale, or the
+// default if it is out of range
+func parseCgroupWeight(appInstance *types.AppInstanceConfig, name string, weight uint32) uint16 {
+	if weight > 10000 {
+		err := fmt.Sprintf("%s %d of %s is not in 1-10000", name, weight,
+			appInstance.DisplayName)
+		log.Error(err)
+		appInstance.Errors = append(appInstance.Errors, err)
+		return 0
+	}
+	return uint16(weight)
+}
+
+func parseAppHealthConfig(health *zconfig.AppHealthConfig) types.HealthConfig {
+	probe := health.GetLivenessProbe()
+	return types.HealthConfig{
+		LivenessProbe: types.LivenessProbe{
+			Type:             types.ProbeType(probe.GetType()),
+			Port:             uint16(probe.GetPort()),
+			Path:             probe.GetPath(),
+			Command:          probe.GetCommand(),
+			InitialDelay:     probe.GetInitialDelay(),
+			Period:           probe.GetPeriod(),
+			Timeout:          probe.GetTimeout(),
+			FailureThreshold: probe.GetFailureThreshold(),
+		},
+		RestartPolicy:     types.RestartPolicy(health.GetRestartPolicy()),
+		RestartBackoff:    health.GetRestartBackoff(),
+		RestartMaxRetries: health.GetRestartMaxRetries(),
+	}
+}
+
+// parsePodContainers keeps the volume indexes of the API, which refer to
+// VolumeRefConfigList
+func parsePodContainers(containers []*zconfig.PodContainer) []types.PodContainer {
+	var ret []types.PodContainer
+	for _, c := range containers {
+		ret = append(ret, types.PodContainer{
+			Name:      c.GetName(),
+			ImageDisk: int(c.GetImageVolume()),
+			Command:   c.GetCommand(),
+			Env:       c.GetEnv(),
+			Mounts:    parsePodMounts(c.GetMounts()),
+			DependsOn: c.GetDependsOn(),
+		})
+	}
+	return ret
+}
+
+// parseInitContainers keeps the volume indexes of the API, which refer to
+// VolumeRefConfigList
+func parseInitContainers(containers []*zconfig.InitContainer) []types.InitContainer {
+	var ret []types.InitContainer
+	for _, c := range containers {
+		ret = append(ret, types.InitContainer{
+			Name:      c.GetName(),
+			ImageDisk: int(c.GetImageVolume()),
+			Command:   c.GetCommand(),
+			Env:       c.GetEnv(),
+			Mounts:    parsePodMounts(c.GetMounts()),
+			TimeLimit: uint(c.GetTimeLimit()),
+		})
+	}
+	return ret
+}
+
+// parseStartDependencies drops the dependencies on an invalid UUID with
+// an error
+func parseStartDependencies(appInstance *types.AppInstanceConfig,
+	deps []*zconfig.StartDependency) []types.StartDependency {
+
+	var ret []types.StartDependency
+	for _, d := range deps {
+		id, err := uuid.FromString(d.GetAppUuid())
+		if err != nil {
+			err := fmt.Sprintf("start dependency of %s on %s: %v",
+				appInstance.DisplayName, d.GetAppUuid(), err)
+			log.Error(err)
+			appInstance.Errors = append(appInstance.Errors, err)
+			continue
+		}
+		ret = append(ret, types.StartDependency{
+			AppUUID:   id,
+			Condition: types.StartCondition(d.GetCondition()),
+			Delay:     uint(d.GetDelay()),
+		})
+	}
+	return ret
+}
+
+func parsePodMounts(mounts []*zconfig.PodMount) []types.PodMount {
+	var ret []types.PodMount
+	for _, m := range mounts {
+		ret = append(ret, types.PodMount{
+			Disk:     int(m.GetVolume()),
+			Path:     m.GetPath(),
+			ReadOnly: m.GetReadOnly(),
+		})
+	}
+	return ret
+}
+
 // XXX Remove when systemAdapter embeds the NetworkXObject
 func lookupNetworkId(id string, cfgNetworks []*zconfig.NetworkConfig) *zconfig.NetworkConfig {
 	for _, netEnt := range cfgNetworks {
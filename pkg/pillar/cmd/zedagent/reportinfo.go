@@ -497,6 +497,10 @@ func PublishDeviceInfoToZedCloud(ctx *zedagentContext) {
 
 	ReportDeviceInfo.Capabilities = getCapabilities(ctx)
 
+	ReportDeviceInfo.KubeNode = getKubeNode(ctx)
+
+	ReportDeviceInfo.ResourceAllocation = getResourceAllocation(ctx)
+
 	// Report if there is a local override of profile
 	if ctx.getconfigCtx.currentProfile != ctx.getconfigCtx.globalProfile {
 		ReportDeviceInfo.LocalProfile = ctx.getconfigCtx.currentProfile
@@ -816,6 +820,66 @@ func getCapabilities(ctx *zedagentContext) *info.Capabilities {
 	}
 }
 
+func getKubeNode(ctx *zedagentContext) *info.ZInfoKubeNode {
+	m, _ := ctx.subKubeNodeStatus.Get("global")
+	if m == nil {
+		return nil
+	}
+	status := m.(types.KubeNodeStatus)
+	node := &info.ZInfoKubeNode{
+		Enabled: status.Enabled,
+		Ready:   status.Ready,
+		Version: status.Version,
+		Error:   encodeErrorInfo(status.ErrorAndTime),
+	}
+	if status.PodNetworkInstance != nilUUID {
+		node.PodNetworkInstance = status.PodNetworkInstance.String()
+	}
+	return node
+}
+
+func getResourceAllocation(ctx *zedagentContext) *info.ZInfoResourceAllocation {
+	m, _ := ctx.subResourceAllocation.Get("global")
+	if m == nil {
+		return nil
+	}
+	ra := m.(types.ResourceAllocation)
+	reportRA := &info.ZInfoResourceAllocation{
+		Cpus:            uint32(ra.CPUs),
+		ReservedCpus:    uint32(ra.ReservedCPUs),
+		AllocatedVcpus:  uint32(ra.AllocatedVCPUs),
+		CpuPinning:      ra.CPUPinning,
+		Memory:          ra.Memory,
+		AllocatedMemory: ra.AllocatedMemory,
+		Disk:            ra.Disk,
+		AllocatedDisk:   ra.AllocatedDisk,
+	}
+	for _, a := range ra.Adapters {
+		reportRA.Adapters = append(reportRA.Adapters, &info.ZInfoAdapterAllocation{
+			Type:    evecommon.PhyIoType(a.Adapter.Type),
+			Name:    a.Adapter.Name,
+			AppUuid: a.AppUUID.String(),
+		})
+	}
+	for _, n := range ra.Networks {
+		reportRA.Networks = append(reportRA.Networks, &info.ZInfoNetworkAllocation{
+			NetworkInstance: n.UUID.String(),
+			Addresses:       uint32(n.Addresses),
+			Allocated:       uint32(n.Allocated),
+		})
+	}
+	for _, app := range ra.Apps {
+		reportRA.Apps = append(reportRA.Apps, &info.ZInfoAppAllocation{
+			Uuid:        app.UUID.String(),
+			DisplayName: app.DisplayName,
+			Vcpus:       uint32(app.VCPUs),
+			Memory:      app.Memory,
+			Disk:        app.Disk,
+		})
+	}
+	return reportRA
+}
+
 func getBaseosUpdateCounter(ctx *zedagentContext) uint32 {
 	m, err := ctx.subBaseOsMgrStatus.Get("global")
 	if err != nil {
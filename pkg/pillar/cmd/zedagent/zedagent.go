@@ -110,9 +110,12 @@ type zedagentContext struct {
 	GCInitialized             bool // Received initial GlobalConfig
 	subZbootStatus            pubsub.Subscription
 	subAppContainerMetrics    pubsub.Subscription
+	subDomainContainerMetrics pubsub.Subscription
 	subDiskMetric             pubsub.Subscription
 	subAppDiskMetric          pubsub.Subscription
 	subCapabilities           pubsub.Subscription
+	subKubeNodeStatus         pubsub.Subscription
+	subResourceAllocation     pubsub.Subscription
 	rebootCmd                 bool
 	rebootCmdDeferred         bool
 	deviceReboot              bool
@@ -135,7 +138,7 @@ type zedagentContext struct {
 	globalConfig            types.ConfigItemValueMap
 	specMap                 types.ConfigItemSpecMap
 	globalStatus            types.GlobalStatus
-	appContainerStatsTime   time.Time // last time the App Container stats uploaded
+	appContainerStatsTime   map[string]time.Time // last time the App Container stats uploaded, per app
 	// The MaintenanceMode can come from GlobalConfig and from the config
 	// API. Those are merged into maintenanceMode
 	// TBD will be also decide locally to go into maintenanceMode based
@@ -224,6 +227,7 @@ func Run(ps *pubsub.PubSub, loggerArg *logrus.Logger, logArg *base.LogObject) in
 		TriggerDeviceInfo: triggerDeviceInfo,
 		TriggerObjectInfo: triggerObjectInfo,
 	}
+	zedagentCtx.appContainerStatsTime = make(map[string]time.Time)
 	zedagentCtx.specMap = types.NewConfigItemSpecMap()
 	zedagentCtx.globalConfig = *types.DefaultConfigItemValueMap()
 	zedagentCtx.globalStatus.ConfigItems = make(
@@ -704,6 +708,24 @@ func Run(ps *pubsub.PubSub, loggerArg *logrus.Logger, logArg *base.LogObject) in
 	zedagentCtx.subAppContainerMetrics = subAppContainerMetrics
 	subAppContainerMetrics.Activate()
 
+	// sub AppContainerMetrics from domainmgr for native containers
+	subDomainContainerMetrics, err := ps.NewSubscription(pubsub.SubscriptionOptions{
+		AgentName:     "domainmgr",
+		MyAgentName:   agentName,
+		TopicImpl:     types.AppContainerMetrics{},
+		Activate:      false,
+		Ctx:           &zedagentCtx,
+		CreateHandler: handleAppContainerMetricsCreate,
+		ModifyHandler: handleAppContainerMetricsModify,
+		WarningTime:   warningTime,
+		ErrorTime:     errorTime,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	zedagentCtx.subDomainContainerMetrics = subDomainContainerMetrics
+	subDomainContainerMetrics.Activate()
+
 	subBaseOsStatus, err := ps.NewSubscription(pubsub.SubscriptionOptions{
 		AgentName:     "baseosmgr",
 		MyAgentName:   agentName,
@@ -929,6 +951,42 @@ func Run(ps *pubsub.PubSub, loggerArg *logrus.Logger, logArg *base.LogObject) in
 	zedagentCtx.subCapabilities = subCapabilities
 	subCapabilities.Activate()
 
+	subKubeNodeStatus, err := ps.NewSubscription(pubsub.SubscriptionOptions{
+		AgentName:     "kubenodemgr",
+		MyAgentName:   agentName,
+		TopicImpl:     types.KubeNodeStatus{},
+		Activate:      false,
+		Ctx:           &zedagentCtx,
+		CreateHandler: handleKubeNodeStatusCreate,
+		ModifyHandler: handleKubeNodeStatusModify,
+		DeleteHandler: handleKubeNodeStatusDelete,
+		WarningTime:   warningTime,
+		ErrorTime:     errorTime,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	zedagentCtx.subKubeNodeStatus = subKubeNodeStatus
+	subKubeNodeStatus.Activate()
+
+	subResourceAllocation, err := ps.NewSubscription(pubsub.SubscriptionOptions{
+		AgentName:     "zedmanager",
+		MyAgentName:   agentName,
+		TopicImpl:     types.ResourceAllocation{},
+		Activate:      false,
+		Ctx:           &zedagentCtx,
+		CreateHandler: handleResourceAllocationCreate,
+		ModifyHandler: handleResourceAllocationModify,
+		DeleteHandler: handleResourceAllocationDelete,
+		WarningTime:   warningTime,
+		ErrorTime:     errorTime,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	zedagentCtx.subResourceAllocation = subResourceAllocation
+	subResourceAllocation.Activate()
+
 	subBaseOsMgrStatus, err := ps.NewSubscription(pubsub.SubscriptionOptions{
 		AgentName:   "baseosmgr",
 		MyAgentName: agentName,
@@ -1346,6 +1404,9 @@ func Run(ps *pubsub.PubSub, loggerArg *logrus.Logger, logArg *base.LogObject) in
 		case change := <-subAppContainerMetrics.MsgChan():
 			subAppContainerMetrics.ProcessChange(change)
 
+		case change := <-subDomainContainerMetrics.MsgChan():
+			subDomainContainerMetrics.ProcessChange(change)
+
 		case change := <-subDiskMetric.MsgChan():
 			subDiskMetric.ProcessChange(change)
 
@@ -1355,6 +1416,12 @@ func Run(ps *pubsub.PubSub, loggerArg *logrus.Logger, logArg *base.LogObject) in
 		case change := <-subCapabilities.MsgChan():
 			subCapabilities.ProcessChange(change)
 
+		case change := <-subKubeNodeStatus.MsgChan():
+			subKubeNodeStatus.ProcessChange(change)
+
+		case change := <-subResourceAllocation.MsgChan():
+			subResourceAllocation.ProcessChange(change)
+
 		case change := <-subBaseOsMgrStatus.MsgChan():
 			subBaseOsMgrStatus.ProcessChange(change)
 
@@ -1504,6 +1571,7 @@ func handleAppInstanceStatusDelete(ctxArg interface{}, key string,
 		ctx.iteration)
 	triggerPublishDevInfo(ctx)
 	ctx.iteration++
+	delete(ctx.appContainerStatsTime, uuidStr)
 	log.Functionf("handleAppInstanceStatusDelete(%s) DONE", key)
 }
 
@@ -1835,6 +1903,50 @@ func handleNodeAgentStatusImpl(ctxArg interface{}, key string,
 	log.Functionf("handleNodeAgentStatusImpl: done.")
 }
 
+func handleKubeNodeStatusCreate(ctxArg interface{}, key string,
+	statusArg interface{}) {
+	handleKubeNodeStatusImpl(ctxArg, key, statusArg)
+}
+
+func handleKubeNodeStatusModify(ctxArg interface{}, key string,
+	statusArg interface{}, oldStatusArg interface{}) {
+	handleKubeNodeStatusImpl(ctxArg, key, statusArg)
+}
+
+func handleKubeNodeStatusDelete(ctxArg interface{}, key string,
+	statusArg interface{}) {
+	handleKubeNodeStatusImpl(ctxArg, key, statusArg)
+}
+
+func handleKubeNodeStatusImpl(ctxArg interface{}, key string,
+	statusArg interface{}) {
+	ctx := ctxArg.(*zedagentContext)
+	log.Functionf("handleKubeNodeStatusImpl for %s", key)
+	triggerPublishDevInfo(ctx)
+}
+
+func handleResourceAllocationCreate(ctxArg interface{}, key string,
+	statusArg interface{}) {
+	handleResourceAllocationImpl(ctxArg, key, statusArg)
+}
+
+func handleResourceAllocationModify(ctxArg interface{}, key string,
+	statusArg interface{}, oldStatusArg interface{}) {
+	handleResourceAllocationImpl(ctxArg, key, statusArg)
+}
+
+func handleResourceAllocationDelete(ctxArg interface{}, key string,
+	statusArg interface{}) {
+	handleResourceAllocationImpl(ctxArg, key, statusArg)
+}
+
+func handleResourceAllocationImpl(ctxArg interface{}, key string,
+	statusArg interface{}) {
+	ctx := ctxArg.(*zedagentContext)
+	log.Functionf("handleResourceAllocationImpl for %s", key)
+	triggerPublishDevInfo(ctx)
+}
+
 func handleNodeAgentStatusDelete(ctxArg interface{}, key string,
 	statusArg interface{}) {
 	ctx := ctxArg.(*zedagentContext)
@@ -0,0 +1,429 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package zedmanager
+
+// Admission control. Before an app instance is activated the CPUs, memory,
+// adapters and network instance addresses it needs are checked against what
+// is left by the app instances which are activated or being activated. If
+// it does not fit it waits, with an error saying what is missing, until
+// others release enough. Disk is admitted by volumemgr when the volumes are
+// created, before the app instance gets here, so it is only accounted.
+// What is admitted is published as a ResourceAllocation, when an app
+// instance is admitted or released and every resourceAllocationInterval for
+// the disk and memory of the device.
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/lf-edge/eve/pkg/pillar/types"
+	uuid "github.com/satori/go.uuid"
+	"github.com/shirou/gopsutil/disk"
+)
+
+// resourceAllocationInterval is how often the ResourceAllocation is
+// recomputed without a change of the app instances
+const resourceAllocationInterval = time.Minute
+
+// resourceUse is what an app instance takes from the device
+type resourceUse struct {
+	uuid        uuid.UUID
+	displayName string
+	vcpus       int
+	pinnedCPUs  int    // Host CPUs it gets for itself if pinning is enabled
+	memory      uint64 // Bytes
+	disk        uint64 // Bytes
+	adapters    []types.IoAdapter
+	networks    map[uuid.UUID]int // Interfaces on each network instance
+}
+
+// resourceCapacity is what the device has for app instances
+type resourceCapacity struct {
+	cpus         int // Zero if not known yet
+	reservedCPUs int
+	cpuPinning   bool
+	checkMemory  bool
+	memory       uint64 // Bytes after the EVE reserve
+	disk         uint64 // Bytes; only reported
+	// Addresses of the network instances which have a limit
+	networks map[uuid.UUID]int
+}
+
+// appResourceUse returns what an app instance with the given resources
+// takes. MaxMem is counted for app instances with a larger MaxMem since a
+// KVM domain is booted with it, and domainmgr may balloon any of them up to
+// it while the host has memory to spare.
+func appResourceUse(appUUID uuid.UUID, displayName string, vm types.VmConfig,
+	volumes []types.VolumeRefStatus, adapters []types.IoAdapter,
+	underlays []types.UnderlayNetworkConfig) resourceUse {
+
+	use := resourceUse{
+		uuid:        appUUID,
+		displayName: displayName,
+		vcpus:       vm.VCpus,
+		memory:      uint64(vm.Memory) << 10,
+		adapters:    adapters,
+		networks:    make(map[uuid.UUID]int),
+	}
+	if vm.MaxMem > vm.Memory {
+		use.memory = uint64(vm.MaxMem) << 10
+	}
+	if use.vcpus == 0 {
+		use.vcpus = 1
+	}
+	// domainmgr does not pin the CPUs of these
+	if vm.CPUs == "" && vm.VirtualizationMode != types.NOHYPER {
+		use.pinnedCPUs = use.vcpus
+	}
+	for _, vol := range volumes {
+		use.disk += vol.MaxVolSize
+	}
+	for _, ul := range underlays {
+		use.networks[ul.Network]++
+	}
+	return use
+}
+
+// checkAdmission returns an error saying everything which is missing for
+// want to fit next to the admitted app instances
+func checkAdmission(capacity resourceCapacity, admitted []resourceUse,
+	want resourceUse) error {
+
+	var missing []string
+	if capacity.cpus != 0 {
+		if want.vcpus > capacity.cpus {
+			missing = append(missing, fmt.Sprintf("needs %d vCPUs but the device has %d CPUs",
+				want.vcpus, capacity.cpus))
+		} else if capacity.cpuPinning && want.pinnedCPUs != 0 {
+			used := 0
+			for _, a := range admitted {
+				used += a.pinnedCPUs
+			}
+			available := capacity.cpus - capacity.reservedCPUs
+			if used+want.pinnedCPUs > available {
+				missing = append(missing, fmt.Sprintf("needs %d dedicated CPUs, %d of the %d CPUs for app instances are in use",
+					want.pinnedCPUs, used, available))
+			}
+		}
+	}
+	if capacity.checkMemory {
+		var used uint64
+		for _, a := range admitted {
+			used += a.memory
+		}
+		if used+want.memory > capacity.memory {
+			missing = append(missing, fmt.Sprintf("needs %d bytes of memory, %d of the %d bytes for app instances are in use",
+				want.memory, used, capacity.memory))
+		}
+	}
+	for _, adapter := range want.adapters {
+		if adapter.Type == types.IoNop {
+			continue
+		}
+		for _, a := range admitted {
+			if adapterAssigned(a.adapters, adapter) {
+				missing = append(missing, fmt.Sprintf("adapter %s is assigned to app instance %s",
+					adapter.Name, a.displayName))
+			}
+		}
+	}
+	for _, network := range sortedNetworks(want.networks) {
+		addresses, ok := capacity.networks[network]
+		if !ok {
+			continue
+		}
+		used := 0
+		for _, a := range admitted {
+			used += a.networks[network]
+		}
+		if used+want.networks[network] > addresses {
+			missing = append(missing, fmt.Sprintf("needs %d addresses on network instance %s, %d of its %d are in use",
+				want.networks[network], network, used, addresses))
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(missing, "; "))
+}
+
+func adapterAssigned(adapters []types.IoAdapter, adapter types.IoAdapter) bool {
+	for _, a := range adapters {
+		if a.Type == adapter.Type && a.Name == adapter.Name {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedNetworks(networks map[uuid.UUID]int) []uuid.UUID {
+	var list []uuid.UUID
+	for network := range networks {
+		list = append(list, network)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].String() < list[j].String()
+	})
+	return list
+}
+
+// networkInstanceAddresses returns how many addresses a network instance
+// can give to app instances, and false if it has no limit
+func networkInstanceAddresses(status types.NetworkInstanceStatus) (int, bool) {
+	if status.Type == types.NetworkInstanceTypeSwitch {
+		return 0, false
+	}
+	start := status.DhcpRange.Start.To4()
+	end := status.DhcpRange.End.To4()
+	if start == nil || end == nil {
+		return 0, false
+	}
+	first := binary.BigEndian.Uint32(start)
+	last := binary.BigEndian.Uint32(end)
+	if last < first {
+		return 0, true
+	}
+	count := int(last - first + 1)
+	// zedrouter takes the bridge address from the range unless there is
+	// a gateway outside of it
+	if status.Gateway != nil && !ipInRange(status.Gateway, first, last) {
+		return count, true
+	}
+	return count - 1, true
+}
+
+func ipInRange(ip net.IP, first, last uint32) bool {
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return false
+	}
+	addr := binary.BigEndian.Uint32(ip4)
+	return addr >= first && addr <= last
+}
+
+// resourceAllocation returns the summary of what is admitted
+func resourceAllocation(capacity resourceCapacity,
+	admitted []resourceUse) types.ResourceAllocation {
+
+	ra := types.ResourceAllocation{
+		CPUs:       capacity.cpus,
+		CPUPinning: capacity.cpuPinning,
+		Memory:     capacity.memory,
+		Disk:       capacity.disk,
+	}
+	if capacity.cpuPinning {
+		ra.ReservedCPUs = capacity.reservedCPUs
+	}
+	networks := make(map[uuid.UUID]int)
+	for _, a := range admitted {
+		ra.AllocatedVCPUs += a.vcpus
+		ra.AllocatedMemory += a.memory
+		ra.AllocatedDisk += a.disk
+		for _, adapter := range a.adapters {
+			if adapter.Type == types.IoNop {
+				continue
+			}
+			ra.Adapters = append(ra.Adapters, types.AdapterAllocation{
+				Adapter: adapter,
+				AppUUID: a.uuid,
+			})
+		}
+		for network, count := range a.networks {
+			networks[network] += count
+		}
+		ra.Apps = append(ra.Apps, types.AppAllocation{
+			UUID:        a.uuid,
+			DisplayName: a.displayName,
+			VCPUs:       a.vcpus,
+			Memory:      a.memory,
+			Disk:        a.disk,
+		})
+	}
+	for network := range capacity.networks {
+		if _, ok := networks[network]; !ok {
+			networks[network] = 0
+		}
+	}
+	for _, network := range sortedNetworks(networks) {
+		ra.Networks = append(ra.Networks, types.NetworkAllocation{
+			UUID:      network,
+			Addresses: capacity.networks[network],
+			Allocated: networks[network],
+		})
+	}
+	return ra
+}
+
+// kubeUnderlays returns the interfaces which take an address from the DHCP
+// range of a network instance. The pods of an app instance with a
+// KubeManifest get theirs from the rest of the subnet.
+func kubeUnderlays(config types.AppInstanceConfig) []types.UnderlayNetworkConfig {
+	if config.KubeManifest != "" {
+		return nil
+	}
+	return config.UnderlayNetworkList
+}
+
+// admittedResources returns what the app instances which are activated or
+// being activated take, except the one with key except
+func admittedResources(ctx *zedmanagerContext, except string) []resourceUse {
+	var admitted []resourceUse
+	for _, st := range ctx.pubAppInstanceStatus.GetAll() {
+		status := st.(types.AppInstanceStatus)
+		if status.Key() == except ||
+			!(status.Activated || status.ActivateInprogress) {
+			continue
+		}
+		adapters := status.IoAdapterList
+		var underlays []types.UnderlayNetworkConfig
+		config := lookupAppInstanceConfig(ctx, status.Key())
+		if config != nil {
+			adapters = config.IoAdapterList
+			underlays = kubeUnderlays(*config)
+		} else {
+			for _, ul := range status.UnderlayNetworks {
+				underlays = append(underlays, ul.UnderlayNetworkConfig)
+			}
+		}
+		admitted = append(admitted, appResourceUse(status.UUIDandVersion.UUID,
+			status.DisplayName, status.FixedResources,
+			status.VolumeRefStatusList, adapters, underlays))
+	}
+	sort.Slice(admitted, func(i, j int) bool {
+		return admitted[i].uuid.String() < admitted[j].uuid.String()
+	})
+	return admitted
+}
+
+// deviceCapacity returns what the device has for app instances
+func deviceCapacity(ctx *zedmanagerContext) (resourceCapacity, error) {
+	capacity := resourceCapacity{
+		cpuPinning:   ctx.globalConfig.GlobalValueBool(types.CPUPinning),
+		reservedCPUs: int(ctx.globalConfig.GlobalValueInt(types.EveCPUsReserved)),
+		checkMemory:  !ctx.globalConfig.GlobalValueBool(types.IgnoreMemoryCheckForApps),
+		networks:     make(map[uuid.UUID]int),
+	}
+	for _, st := range ctx.subNetworkInstanceStatus.GetAll() {
+		status := st.(types.NetworkInstanceStatus)
+		if addresses, ok := networkInstanceAddresses(status); ok {
+			capacity.networks[status.UUID] = addresses
+		}
+	}
+	if usage, err := disk.Usage(types.PersistDir); err == nil {
+		capacity.disk = usage.Total
+	}
+	m, _ := ctx.subHostMemory.Get("global")
+	if m == nil {
+		return capacity, fmt.Errorf("Global host memory is empty")
+	}
+	memory := m.(types.HostMemory)
+	capacity.cpus = int(memory.Ncpus)
+	total := memory.TotalMemoryMB << 20
+	reserved := uint64(ctx.globalConfig.GlobalValueInt(types.EveMemoryLimitInBytes))
+	if total > reserved {
+		capacity.memory = total - reserved
+	}
+	return capacity, nil
+}
+
+// admitAppInstance checks that the app instance fits next to the others
+// which are activated or being activated
+func admitAppInstance(ctx *zedmanagerContext, config types.AppInstanceConfig,
+	status *types.AppInstanceStatus) error {
+
+	capacity, err := deviceCapacity(ctx)
+	if err != nil && capacity.checkMemory {
+		return err
+	}
+	want := appResourceUse(config.UUIDandVersion.UUID, config.DisplayName,
+		config.FixedResources, status.VolumeRefStatusList,
+		config.IoAdapterList, kubeUnderlays(config))
+	admitted := admittedResources(ctx, status.Key())
+	if err := checkAdmission(capacity, admitted, want); err != nil {
+		return err
+	}
+	log.Functionf("admitAppInstance(%s) %d vCPUs memory %d kB disk %d kB next to %d app instances",
+		status.Key(), want.vcpus, want.memory>>10, want.disk>>10,
+		len(admitted))
+	return nil
+}
+
+// allocationChanged returns true if the change of the AppInstanceStatus
+// from old to new, nil if there is none, changes what is admitted
+func allocationChanged(old, new *types.AppInstanceStatus) bool {
+	admitted := func(status *types.AppInstanceStatus) bool {
+		return status != nil && (status.Activated || status.ActivateInprogress)
+	}
+	if admitted(old) != admitted(new) {
+		return true
+	}
+	if !admitted(new) {
+		return false
+	}
+	if old.DisplayName != new.DisplayName ||
+		!cmp.Equal(old.FixedResources, new.FixedResources) ||
+		!cmp.Equal(old.IoAdapterList, new.IoAdapterList) ||
+		len(old.VolumeRefStatusList) != len(new.VolumeRefStatusList) {
+		return true
+	}
+	for i := range new.VolumeRefStatusList {
+		if old.VolumeRefStatusList[i].MaxVolSize != new.VolumeRefStatusList[i].MaxVolSize {
+			return true
+		}
+	}
+	return false
+}
+
+// publishResourceAllocation publishes the summary if it changed
+func publishResourceAllocation(ctx *zedmanagerContext) {
+	capacity, _ := deviceCapacity(ctx)
+	ra := resourceAllocation(capacity, admittedResources(ctx, ""))
+	pub := ctx.pubResourceAllocation
+	if old, _ := pub.Get(ra.Key()); old != nil &&
+		cmp.Equal(old.(types.ResourceAllocation), ra) {
+		return
+	}
+	log.Functionf("publishResourceAllocation %d app instances", len(ra.Apps))
+	pub.Publish(ra.Key(), ra)
+}
+
+func handleNetworkInstanceStatusCreate(ctxArg interface{}, key string,
+	statusArg interface{}) {
+
+	handleNetworkInstanceStatusImpl(ctxArg, key)
+}
+
+func handleNetworkInstanceStatusModify(ctxArg interface{}, key string,
+	statusArg interface{}, oldStatusArg interface{}) {
+
+	status := statusArg.(types.NetworkInstanceStatus)
+	oldStatus := oldStatusArg.(types.NetworkInstanceStatus)
+	addresses, limited := networkInstanceAddresses(status)
+	oldAddresses, oldLimited := networkInstanceAddresses(oldStatus)
+	if addresses == oldAddresses && limited == oldLimited {
+		return
+	}
+	handleNetworkInstanceStatusImpl(ctxArg, key)
+}
+
+func handleNetworkInstanceStatusDelete(ctxArg interface{}, key string,
+	statusArg interface{}) {
+
+	handleNetworkInstanceStatusImpl(ctxArg, key)
+}
+
+// handleNetworkInstanceStatusImpl retries the app instances waiting for
+// resources since the addresses of a network instance changed
+func handleNetworkInstanceStatusImpl(ctxArg interface{}, key string) {
+	ctx := ctxArg.(*zedmanagerContext)
+	log.Functionf("handleNetworkInstanceStatusImpl(%s)", key)
+	ctx.checkFreedResources = true
+	ctx.resourceAllocationChanged = true
+}
@@ -0,0 +1,360 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package zedmanager
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/lf-edge/eve/pkg/pillar/types"
+	uuid "github.com/satori/go.uuid"
+)
+
+var (
+	testApp1    = uuid.FromStringOrNil("6ba7b810-9dad-11d1-80b4-00c04fd430c1")
+	testApp2    = uuid.FromStringOrNil("6ba7b810-9dad-11d1-80b4-00c04fd430c2")
+	testNetwork = uuid.FromStringOrNil("6ba7b810-9dad-11d1-80b4-00c04fd430c3")
+	testLimited = uuid.FromStringOrNil("6ba7b810-9dad-11d1-80b4-00c04fd430c4")
+	testSwitch  = uuid.FromStringOrNil("6ba7b810-9dad-11d1-80b4-00c04fd430c5")
+	testUSB     = types.IoAdapter{Type: types.IoUSB, Name: "USB0"}
+)
+
+func TestCheckAdmission(t *testing.T) {
+	capacity := resourceCapacity{
+		cpus:         4,
+		reservedCPUs: 1,
+		checkMemory:  true,
+		memory:       4 << 30,
+		networks:     map[uuid.UUID]int{testNetwork: 2},
+	}
+	admitted := []resourceUse{{
+		uuid:        testApp1,
+		displayName: "app1",
+		vcpus:       2,
+		pinnedCPUs:  2,
+		memory:      3 << 30,
+		adapters:    []types.IoAdapter{testUSB},
+		networks:    map[uuid.UUID]int{testNetwork: 1},
+	}}
+	testMatrix := map[string]struct {
+		capacity resourceCapacity
+		want     resourceUse
+		missing  []string // Parts of the error; none if it fits
+	}{
+		"fits": {
+			capacity: capacity,
+			want: resourceUse{vcpus: 2, memory: 1 << 30,
+				networks: map[uuid.UUID]int{testNetwork: 1}},
+		},
+		"more vCPUs than CPUs": {
+			capacity: capacity,
+			want:     resourceUse{vcpus: 5},
+			missing:  []string{"needs 5 vCPUs but the device has 4 CPUs"},
+		},
+		"CPUs unknown": {
+			capacity: resourceCapacity{},
+			want:     resourceUse{vcpus: 5, memory: 8 << 30},
+		},
+		"dedicated CPUs": {
+			capacity: func() resourceCapacity {
+				c := capacity
+				c.cpuPinning = true
+				return c
+			}(),
+			want:    resourceUse{vcpus: 2, pinnedCPUs: 2},
+			missing: []string{"needs 2 dedicated CPUs, 2 of the 3 CPUs for app instances are in use"},
+		},
+		"CPUs shared without pinning": {
+			capacity: capacity,
+			want:     resourceUse{vcpus: 4, pinnedCPUs: 4},
+		},
+		"memory": {
+			capacity: capacity,
+			want:     resourceUse{vcpus: 1, memory: 2 << 30},
+			missing:  []string{"needs 2147483648 bytes of memory, 3221225472 of the 4294967296 bytes"},
+		},
+		"memory not checked": {
+			capacity: func() resourceCapacity {
+				c := capacity
+				c.checkMemory = false
+				return c
+			}(),
+			want: resourceUse{vcpus: 1, memory: 2 << 30},
+		},
+		"adapter assigned": {
+			capacity: capacity,
+			want: resourceUse{vcpus: 1, adapters: []types.IoAdapter{testUSB,
+				{Type: types.IoNop, Name: "USB0"}}},
+			missing: []string{"adapter USB0 is assigned to app instance app1"},
+		},
+		"network instance full": {
+			capacity: capacity,
+			want:     resourceUse{vcpus: 1, networks: map[uuid.UUID]int{testNetwork: 2}},
+			missing:  []string{"needs 2 addresses on network instance " + testNetwork.String() + ", 1 of its 2 are in use"},
+		},
+		"network instance without a limit": {
+			capacity: capacity,
+			want:     resourceUse{vcpus: 1, networks: map[uuid.UUID]int{testSwitch: 10}},
+		},
+		"everything missing": {
+			capacity: capacity,
+			want: resourceUse{vcpus: 1, memory: 2 << 30,
+				adapters: []types.IoAdapter{testUSB},
+				networks: map[uuid.UUID]int{testNetwork: 2}},
+			missing: []string{"bytes of memory", "; adapter USB0", "; needs 2 addresses"},
+		},
+	}
+	for testname, test := range testMatrix {
+		t.Run(testname, func(t *testing.T) {
+			err := checkAdmission(test.capacity, admitted, test.want)
+			if len(test.missing) == 0 {
+				if err != nil {
+					t.Errorf("unexpected error %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("no error")
+			}
+			for _, part := range test.missing {
+				if !strings.Contains(err.Error(), part) {
+					t.Errorf("error %q does not have %q", err, part)
+				}
+			}
+		})
+	}
+}
+
+func TestNetworkInstanceAddresses(t *testing.T) {
+	local := func(start, end, gateway string) types.NetworkInstanceStatus {
+		status := types.NetworkInstanceStatus{}
+		status.Type = types.NetworkInstanceTypeLocal
+		status.DhcpRange.Start = net.ParseIP(start)
+		status.DhcpRange.End = net.ParseIP(end)
+		status.Gateway = net.ParseIP(gateway)
+		return status
+	}
+	testMatrix := map[string]struct {
+		status    types.NetworkInstanceStatus
+		addresses int
+		limited   bool
+	}{
+		"gateway outside of the range": {
+			status:    local("10.1.0.2", "10.1.0.11", "10.1.0.1"),
+			addresses: 10,
+			limited:   true,
+		},
+		"gateway in the range": {
+			status:    local("10.1.0.1", "10.1.0.10", "10.1.0.1"),
+			addresses: 9,
+			limited:   true,
+		},
+		"no gateway": {
+			status:    local("10.1.0.2", "10.1.0.11", ""),
+			addresses: 9,
+			limited:   true,
+		},
+		"empty range": {
+			status:  local("10.1.0.11", "10.1.0.2", "10.1.0.1"),
+			limited: true,
+		},
+		"no range": {
+			status: local("", "", ""),
+		},
+		"IPv6": {
+			status: local("fd00::2", "fd00::ff", "fd00::1"),
+		},
+		"switch": {
+			status: func() types.NetworkInstanceStatus {
+				status := local("10.1.0.2", "10.1.0.11", "10.1.0.1")
+				status.Type = types.NetworkInstanceTypeSwitch
+				return status
+			}(),
+		},
+	}
+	for testname, test := range testMatrix {
+		t.Run(testname, func(t *testing.T) {
+			addresses, limited := networkInstanceAddresses(test.status)
+			if addresses != test.addresses || limited != test.limited {
+				t.Errorf("got %d %t, expected %d %t", addresses, limited,
+					test.addresses, test.limited)
+			}
+		})
+	}
+}
+
+func TestResourceAllocation(t *testing.T) {
+	app1 := resourceUse{
+		uuid:        testApp1,
+		displayName: "app1",
+		vcpus:       2,
+		memory:      1 << 30,
+		disk:        10 << 30,
+		adapters:    []types.IoAdapter{testUSB, {Type: types.IoNop, Name: "nop"}},
+		networks:    map[uuid.UUID]int{testNetwork: 2},
+	}
+	app2 := resourceUse{
+		uuid:        testApp2,
+		displayName: "app2",
+		vcpus:       1,
+		memory:      512 << 20,
+		networks:    map[uuid.UUID]int{testNetwork: 1, testSwitch: 1},
+	}
+	capacity := resourceCapacity{
+		cpus:         4,
+		reservedCPUs: 1,
+		memory:       4 << 30,
+		disk:         100 << 30,
+		networks:     map[uuid.UUID]int{testNetwork: 10, testLimited: 5},
+	}
+	testMatrix := map[string]struct {
+		capacity resourceCapacity
+		admitted []resourceUse
+		expected types.ResourceAllocation
+	}{
+		"nothing admitted": {
+			capacity: capacity,
+			expected: types.ResourceAllocation{
+				CPUs:   4,
+				Memory: 4 << 30,
+				Disk:   100 << 30,
+				Networks: []types.NetworkAllocation{
+					{UUID: testNetwork, Addresses: 10},
+					{UUID: testLimited, Addresses: 5},
+				},
+			},
+		},
+		"two app instances": {
+			capacity: capacity,
+			admitted: []resourceUse{app1, app2},
+			expected: types.ResourceAllocation{
+				CPUs:            4,
+				AllocatedVCPUs:  3,
+				Memory:          4 << 30,
+				AllocatedMemory: 1<<30 + 512<<20,
+				Disk:            100 << 30,
+				AllocatedDisk:   10 << 30,
+				Adapters: []types.AdapterAllocation{
+					{Adapter: testUSB, AppUUID: testApp1},
+				},
+				// a network instance without a limit has no addresses
+				Networks: []types.NetworkAllocation{
+					{UUID: testNetwork, Addresses: 10, Allocated: 3},
+					{UUID: testLimited, Addresses: 5},
+					{UUID: testSwitch, Allocated: 1},
+				},
+				Apps: []types.AppAllocation{
+					{UUID: testApp1, DisplayName: "app1", VCPUs: 2,
+						Memory: 1 << 30, Disk: 10 << 30},
+					{UUID: testApp2, DisplayName: "app2", VCPUs: 1,
+						Memory: 512 << 20},
+				},
+			},
+		},
+		"CPU pinning": {
+			capacity: func() resourceCapacity {
+				c := capacity
+				c.cpuPinning = true
+				c.networks = nil
+				return c
+			}(),
+			expected: types.ResourceAllocation{
+				CPUs:         4,
+				ReservedCPUs: 1,
+				CPUPinning:   true,
+				Memory:       4 << 30,
+				Disk:         100 << 30,
+			},
+		},
+	}
+	for testname, test := range testMatrix {
+		t.Run(testname, func(t *testing.T) {
+			ra := resourceAllocation(test.capacity, test.admitted)
+			if diff := cmp.Diff(test.expected, ra); diff != "" {
+				t.Errorf("unexpected allocation: %s", diff)
+			}
+		})
+	}
+}
+
+func TestAllocationChanged(t *testing.T) {
+	activated := types.AppInstanceStatus{
+		DisplayName:    "app1",
+		Activated:      true,
+		FixedResources: types.VmConfig{VCpus: 1, Memory: 1024},
+		VolumeRefStatusList: []types.VolumeRefStatus{
+			{MaxVolSize: 1 << 30},
+		},
+	}
+	testMatrix := map[string]struct {
+		old      *types.AppInstanceStatus
+		new      func(types.AppInstanceStatus) *types.AppInstanceStatus
+		expected bool
+	}{
+		"created": {
+			new:      func(s types.AppInstanceStatus) *types.AppInstanceStatus { return &s },
+			expected: true,
+		},
+		"created inactive": {
+			new: func(s types.AppInstanceStatus) *types.AppInstanceStatus {
+				s.Activated = false
+				return &s
+			},
+		},
+		"deleted": {
+			old:      &activated,
+			new:      func(s types.AppInstanceStatus) *types.AppInstanceStatus { return nil },
+			expected: true,
+		},
+		"state only": {
+			old: &activated,
+			new: func(s types.AppInstanceStatus) *types.AppInstanceStatus {
+				s.State = types.RUNNING
+				return &s
+			},
+		},
+		"deactivated": {
+			old: &activated,
+			new: func(s types.AppInstanceStatus) *types.AppInstanceStatus {
+				s.Activated = false
+				return &s
+			},
+			expected: true,
+		},
+		"activation in progress": {
+			old: &activated,
+			new: func(s types.AppInstanceStatus) *types.AppInstanceStatus {
+				s.Activated = false
+				s.ActivateInprogress = true
+				return &s
+			},
+		},
+		"memory": {
+			old: &activated,
+			new: func(s types.AppInstanceStatus) *types.AppInstanceStatus {
+				s.FixedResources.Memory = 2048
+				return &s
+			},
+			expected: true,
+		},
+		"volume size": {
+			old: &activated,
+			new: func(s types.AppInstanceStatus) *types.AppInstanceStatus {
+				s.VolumeRefStatusList = []types.VolumeRefStatus{{MaxVolSize: 2 << 30}}
+				return &s
+			},
+			expected: true,
+		},
+	}
+	for testname, test := range testMatrix {
+		t.Run(testname, func(t *testing.T) {
+			got := allocationChanged(test.old, test.new(activated))
+			if got != test.expected {
+				t.Errorf("got %t, expected %t", got, test.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package zedmanager
+
+// Start dependencies. An app instance with StartDependencies in its config
+// is not activated until each of the app instances it depends on is
+// running, or healthy according to its liveness probe, and the Delay of
+// the dependency has passed since. Why it is held back is reported in
+// AppInstanceStatus.StartBlocked. Dependencies which form a cycle are an
+// error since none of the app instances in it could ever start.
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lf-edge/eve/pkg/pillar/types"
+)
+
+// startDependencyCheckInterval is how often the app instances which are
+// held back are checked again
+const startDependencyCheckInterval = 5 * time.Second
+
+// startDependencyCycle returns the keys of the app instances in a cycle of
+// dependencies through key, starting and ending with key, or nil if there
+// is none. graph has the keys each app instance depends on.
+func startDependencyCycle(graph map[string][]string, key string) []string {
+	visited := make(map[string]bool)
+	var path []string
+	var visit func(k string) bool
+	visit = func(k string) bool {
+		for _, dep := range graph[k] {
+			if dep == key {
+				path = append(path, k)
+				return true
+			}
+			if visited[dep] {
+				continue
+			}
+			visited[dep] = true
+			if visit(dep) {
+				path = append(path, k)
+				return true
+			}
+		}
+		return false
+	}
+	if !visit(key) {
+		return nil
+	}
+	cycle := make([]string, 0, len(path)+1)
+	for i := len(path) - 1; i >= 0; i-- {
+		cycle = append(cycle, path[i])
+	}
+	return append(cycle, key)
+}
+
+// startConditionMet returns whether the app instance meets the condition
+// and since when
+func startConditionMet(cond types.StartCondition, config types.AppInstanceConfig,
+	status types.AppInstanceStatus) (time.Time, bool) {
+
+	if !status.Activated || status.State != types.RUNNING {
+		return time.Time{}, false
+	}
+	if cond == types.StartWhenHealthy &&
+		config.Health.LivenessProbe.Type != types.ProbeTypeNone {
+		if status.Health.State != types.HealthHealthy {
+			return time.Time{}, false
+		}
+		return status.Health.HealthySince, true
+	}
+	return status.BootTime, true
+}
+
+// startDependencyGraph returns the keys each app instance depends on
+func startDependencyGraph(ctx *zedmanagerContext) map[string][]string {
+	graph := make(map[string][]string)
+	for _, c := range ctx.subAppInstanceConfig.GetAll() {
+		config := c.(types.AppInstanceConfig)
+		for _, dep := range config.StartDependencies {
+			graph[config.Key()] = append(graph[config.Key()],
+				dep.AppUUID.String())
+		}
+	}
+	return graph
+}
+
+// appInstanceLookup returns the config and status of an app instance, or
+// nil if there are none
+type appInstanceLookup func(key string) (*types.AppInstanceConfig,
+	*types.AppInstanceStatus)
+
+// startBlockedReason returns why the app instance can not be started yet
+// or an empty string if it can
+func startBlockedReason(lookup appInstanceLookup, config types.AppInstanceConfig,
+	now time.Time) string {
+
+	for _, dep := range config.StartDependencies {
+		key := dep.AppUUID.String()
+		depConfig, depStatus := lookup(key)
+		if depConfig == nil || depStatus == nil {
+			return fmt.Sprintf("waiting for app instance %s which does not exist",
+				key)
+		}
+		since, met := startConditionMet(dep.Condition, *depConfig, *depStatus)
+		if !met {
+			return fmt.Sprintf("waiting for app instance %s to be %s",
+				depConfig.DisplayName, dep.Condition)
+		}
+		until := since.Add(time.Duration(dep.Delay) * time.Second)
+		if now.Before(until) {
+			return fmt.Sprintf("waiting until %s, %d seconds after app instance %s became %s",
+				until.Format(time.RFC3339), dep.Delay,
+				depConfig.DisplayName, dep.Condition)
+		}
+	}
+	return ""
+}
+
+// doStartDependencies updates StartBlocked, and the error if the
+// dependencies form a cycle. Returns whether the status changed and
+// whether the start is held back.
+func doStartDependencies(ctx *zedmanagerContext, config types.AppInstanceConfig,
+	status *types.AppInstanceStatus) (bool, bool) {
+
+	changed := false
+	var cycle []string
+	if len(config.StartDependencies) != 0 {
+		cycle = startDependencyCycle(startDependencyGraph(ctx), status.Key())
+	}
+	reason := ""
+	if cycle != nil {
+		var names []string
+		for _, key := range cycle {
+			name := key
+			if c := lookupAppInstanceConfig(ctx, key); c != nil {
+				name = c.DisplayName
+			}
+			names = append(names, name)
+		}
+		reason = fmt.Sprintf("start dependencies form a cycle: %s",
+			strings.Join(names, " -> "))
+		if !status.IsErrorSource(types.StartDependency{}) {
+			log.Errorf("doStartDependencies(%s): %s", status.Key(), reason)
+			status.SetErrorWithSource(reason, types.StartDependency{},
+				time.Now())
+			changed = true
+		}
+	} else {
+		if status.IsErrorSource(types.StartDependency{}) {
+			status.ClearErrorWithSource()
+			changed = true
+		}
+		lookup := func(key string) (*types.AppInstanceConfig,
+			*types.AppInstanceStatus) {
+			return lookupAppInstanceConfig(ctx, key),
+				lookupAppInstanceStatus(ctx, key)
+		}
+		reason = startBlockedReason(lookup, config, time.Now())
+	}
+	if reason != status.StartBlocked {
+		if reason != "" {
+			log.Noticef("doStartDependencies(%s): %s", status.Key(), reason)
+		} else {
+			log.Noticef("doStartDependencies(%s): dependencies met",
+				status.Key())
+		}
+		status.StartBlocked = reason
+		changed = true
+	}
+	return changed, reason != ""
+}
+
+// checkStartDependencies is called from a timer to start the app instances
+// whose dependencies came up or whose delay passed
+func checkStartDependencies(ctx *zedmanagerContext) {
+	items := ctx.pubAppInstanceStatus.GetAll()
+	for _, st := range items {
+		status := st.(types.AppInstanceStatus)
+		if status.StartBlocked == "" {
+			continue
+		}
+		log.Functionf("checkStartDependencies: checking %s", status.Key())
+		updateAIStatusUUID(ctx, status.Key())
+	}
+}
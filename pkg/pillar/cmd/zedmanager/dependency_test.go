@@ -0,0 +1,261 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package zedmanager
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/lf-edge/eve/pkg/pillar/types"
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestStartDependencyCycle(t *testing.T) {
+	testMatrix := map[string]struct {
+		graph    map[string][]string
+		key      string
+		expected []string
+	}{
+		"no dependencies": {
+			graph: map[string][]string{},
+			key:   "a",
+		},
+		"self-dependency": {
+			graph:    map[string][]string{"a": {"a"}},
+			key:      "a",
+			expected: []string{"a", "a"},
+		},
+		"two app cycle": {
+			graph:    map[string][]string{"a": {"b"}, "b": {"a"}},
+			key:      "a",
+			expected: []string{"a", "b", "a"},
+		},
+		"three app cycle": {
+			graph:    map[string][]string{"a": {"b"}, "b": {"c"}, "c": {"a"}},
+			key:      "b",
+			expected: []string{"b", "c", "a", "b"},
+		},
+		"cycle not through the app": {
+			graph:    map[string][]string{"a": {"b"}, "b": {"c"}, "c": {"b"}},
+			key:      "a",
+			expected: nil,
+		},
+		"unknown app": {
+			graph: map[string][]string{"a": {"x"}},
+			key:   "a",
+		},
+		"diamond": {
+			graph: map[string][]string{"a": {"b", "c"}, "b": {"d"},
+				"c": {"d"}},
+			key: "a",
+		},
+		"diamond closed into a cycle": {
+			graph: map[string][]string{"a": {"b", "c"}, "b": {"d"},
+				"c": {"d"}, "d": {"a"}},
+			key:      "a",
+			expected: []string{"a", "b", "d", "a"},
+		},
+	}
+	for testname, test := range testMatrix {
+		t.Run(testname, func(t *testing.T) {
+			cycle := startDependencyCycle(test.graph, test.key)
+			if diff := cmp.Diff(test.expected, cycle); diff != "" {
+				t.Errorf("unexpected cycle: %s", diff)
+			}
+		})
+	}
+}
+
+func TestStartBlockedReason(t *testing.T) {
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	app := func(id uuid.UUID, name string, running bool,
+		deps ...types.StartDependency) (types.AppInstanceConfig, types.AppInstanceStatus) {
+
+		config := types.AppInstanceConfig{DisplayName: name,
+			StartDependencies: deps}
+		config.UUIDandVersion.UUID = id
+		status := types.AppInstanceStatus{DisplayName: name}
+		status.UUIDandVersion.UUID = id
+		if running {
+			status.Activated = true
+			status.State = types.RUNNING
+			status.BootTime = now.Add(-time.Minute)
+		}
+		return config, status
+	}
+	on := func(id uuid.UUID) types.StartDependency {
+		return types.StartDependency{AppUUID: id}
+	}
+	testApp3 := uuid.FromStringOrNil("6ba7b810-9dad-11d1-80b4-00c04fd430c6")
+	testApp4 := uuid.FromStringOrNil("6ba7b810-9dad-11d1-80b4-00c04fd430c7")
+
+	testMatrix := map[string]struct {
+		apps   []uuid.UUID // Running
+		halted []uuid.UUID
+		deps   []types.StartDependency
+		// Expected part of the reason; empty if not blocked
+		expected string
+	}{
+		"no dependencies": {},
+		"unknown app": {
+			deps:     []types.StartDependency{on(testApp2)},
+			expected: "waiting for app instance " + testApp2.String() + " which does not exist",
+		},
+		"running": {
+			apps: []uuid.UUID{testApp2},
+			deps: []types.StartDependency{on(testApp2)},
+		},
+		"not running": {
+			halted:   []uuid.UUID{testApp2},
+			deps:     []types.StartDependency{on(testApp2)},
+			expected: "waiting for app instance app2 to be running",
+		},
+		"delay not passed": {
+			apps: []uuid.UUID{testApp2},
+			deps: []types.StartDependency{{AppUUID: testApp2,
+				Delay: 120}},
+			expected: "waiting until 2021-06-01T12:01:00Z, 120 seconds after app instance app2 became running",
+		},
+		"delay passed": {
+			apps: []uuid.UUID{testApp2},
+			deps: []types.StartDependency{{AppUUID: testApp2,
+				Delay: 30}},
+		},
+		"healthy without a probe": {
+			apps: []uuid.UUID{testApp2},
+			deps: []types.StartDependency{{AppUUID: testApp2,
+				Condition: types.StartWhenHealthy}},
+		},
+		"diamond": {
+			apps: []uuid.UUID{testApp2, testApp3, testApp4},
+			deps: []types.StartDependency{on(testApp2), on(testApp3)},
+		},
+		"diamond with the bottom halted": {
+			apps:     []uuid.UUID{testApp2, testApp3},
+			halted:   []uuid.UUID{testApp4},
+			deps:     []types.StartDependency{on(testApp2), on(testApp3)},
+			expected: "",
+		},
+		"diamond with a side halted": {
+			apps:     []uuid.UUID{testApp2, testApp4},
+			halted:   []uuid.UUID{testApp3},
+			deps:     []types.StartDependency{on(testApp2), on(testApp3)},
+			expected: "waiting for app instance app3 to be running",
+		},
+	}
+	for testname, test := range testMatrix {
+		t.Run(testname, func(t *testing.T) {
+			names := map[uuid.UUID]string{testApp2: "app2",
+				testApp3: "app3", testApp4: "app4"}
+			// app2 and app3 depend on app4, making a diamond with app1
+			below := map[uuid.UUID][]types.StartDependency{
+				testApp2: {on(testApp4)},
+				testApp3: {on(testApp4)},
+			}
+			configs := make(map[string]types.AppInstanceConfig)
+			statuses := make(map[string]types.AppInstanceStatus)
+			add := func(ids []uuid.UUID, running bool) {
+				for _, id := range ids {
+					c, s := app(id, names[id], running, below[id]...)
+					configs[id.String()] = c
+					statuses[id.String()] = s
+				}
+			}
+			add(test.apps, true)
+			add(test.halted, false)
+			lookup := func(key string) (*types.AppInstanceConfig,
+				*types.AppInstanceStatus) {
+				c, ok := configs[key]
+				if !ok {
+					return nil, nil
+				}
+				s := statuses[key]
+				return &c, &s
+			}
+			config, _ := app(testApp1, "app1", false, test.deps...)
+			reason := startBlockedReason(lookup, config, now)
+			if test.expected == "" {
+				if reason != "" {
+					t.Errorf("unexpected reason %q", reason)
+				}
+				return
+			}
+			if !strings.Contains(reason, test.expected) {
+				t.Errorf("reason %q does not have %q", reason, test.expected)
+			}
+		})
+	}
+}
+
+func TestStartConditionMet(t *testing.T) {
+	bootTime := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	healthySince := bootTime.Add(time.Minute)
+	running := types.AppInstanceStatus{Activated: true,
+		State: types.RUNNING, BootTime: bootTime}
+	probed := types.AppInstanceConfig{}
+	probed.Health.LivenessProbe.Type = types.ProbeTypeTCP
+
+	testMatrix := map[string]struct {
+		cond     types.StartCondition
+		config   types.AppInstanceConfig
+		status   func(types.AppInstanceStatus) types.AppInstanceStatus
+		since    time.Time
+		expected bool
+	}{
+		"running": {
+			cond:     types.StartWhenRunning,
+			status:   func(s types.AppInstanceStatus) types.AppInstanceStatus { return s },
+			since:    bootTime,
+			expected: true,
+		},
+		"booting": {
+			cond: types.StartWhenRunning,
+			status: func(s types.AppInstanceStatus) types.AppInstanceStatus {
+				s.State = types.BOOTING
+				return s
+			},
+		},
+		"healthy without a probe": {
+			cond:     types.StartWhenHealthy,
+			status:   func(s types.AppInstanceStatus) types.AppInstanceStatus { return s },
+			since:    bootTime,
+			expected: true,
+		},
+		"healthy": {
+			cond:   types.StartWhenHealthy,
+			config: probed,
+			status: func(s types.AppInstanceStatus) types.AppInstanceStatus {
+				s.Health.State = types.HealthHealthy
+				s.Health.HealthySince = healthySince
+				return s
+			},
+			since:    healthySince,
+			expected: true,
+		},
+		"not probed yet": {
+			cond:   types.StartWhenHealthy,
+			config: probed,
+			status: func(s types.AppInstanceStatus) types.AppInstanceStatus { return s },
+		},
+		"running with a probe": {
+			cond:     types.StartWhenRunning,
+			config:   probed,
+			status:   func(s types.AppInstanceStatus) types.AppInstanceStatus { return s },
+			since:    bootTime,
+			expected: true,
+		},
+	}
+	for testname, test := range testMatrix {
+		t.Run(testname, func(t *testing.T) {
+			since, met := startConditionMet(test.cond, test.config,
+				test.status(running))
+			if met != test.expected || !since.Equal(test.since) {
+				t.Errorf("got %s %t, expected %s %t", since, met,
+					test.since, test.expected)
+			}
+		})
+	}
+}
@@ -47,10 +47,18 @@ func MaybeAddDomainConfig(ctx *zedmanagerContext,
 		CipherBlockStatus: aiConfig.CipherBlockStatus,
 		GPUConfig:         "legacy",
 		MetaDataType:      aiConfig.MetaDataType,
+		Security:          aiConfig.Security,
+		RemoteConsole:     aiConfig.RemoteConsole,
+	}
+	if m != nil {
+		dc.GuestRebootCounter = m.GuestRebootCounter
 	}
 
 	dc.DiskConfigList = make([]types.DiskConfig, 0, len(aiStatus.VolumeRefStatusList))
-	for _, vrc := range aiConfig.VolumeRefConfigList {
+	// index in DiskConfigList of each volume, -1 if it has no disk
+	diskIndex := make([]int, len(aiConfig.VolumeRefConfigList))
+	for i, vrc := range aiConfig.VolumeRefConfigList {
+		diskIndex[i] = -1
 		vrs := getVolumeRefStatusFromAIStatus(&aiStatus, vrc)
 		if vrs == nil {
 			log.Errorf("Missing VolumeRefStatus for (VolumeID: %s, GenerationCounter: %d)",
@@ -70,8 +78,21 @@ func MaybeAddDomainConfig(ctx *zedmanagerContext,
 		disk.Format = vrs.ContentFormat
 		disk.MountDir = vrs.MountDir
 		disk.DisplayName = vrs.DisplayName
+		diskIndex[i] = len(dc.DiskConfigList)
 		dc.DiskConfigList = append(dc.DiskConfigList, disk)
 	}
+	podContainers, err := translatePodContainers(aiConfig.PodContainers, diskIndex)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	dc.PodContainers = podContainers
+	initContainers, err := translateInitContainers(aiConfig.InitContainers, diskIndex)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	dc.InitContainers = initContainers
 	// let's fill some of the default values (arguably we may want controller
 	// to do this for us and give us complete config, but it is easier to
 	// fudge DomainConfig for now on our side)
@@ -120,6 +141,69 @@ func MaybeAddDomainConfig(ctx *zedmanagerContext,
 	return &dc, nil
 }
 
+// translateDiskIndex returns the index in DiskConfigList of the volume at
+// index volume in VolumeRefConfigList
+func translateDiskIndex(diskIndex []int, volume int) (int, error) {
+	if volume < 0 || volume >= len(diskIndex) {
+		return 0, fmt.Errorf("no volume %d among the %d of the app instance",
+			volume, len(diskIndex))
+	}
+	if diskIndex[volume] < 0 {
+		return 0, fmt.Errorf("volume %d is not ready", volume)
+	}
+	return diskIndex[volume], nil
+}
+
+func translatePodMounts(mounts []types.PodMount, diskIndex []int) ([]types.PodMount, error) {
+	var ret []types.PodMount
+	for _, m := range mounts {
+		disk, err := translateDiskIndex(diskIndex, m.Disk)
+		if err != nil {
+			return nil, fmt.Errorf("mount %s: %v", m.Path, err)
+		}
+		m.Disk = disk
+		ret = append(ret, m)
+	}
+	return ret, nil
+}
+
+// translatePodContainers returns a copy of containers with their volume
+// indexes, which refer to VolumeRefConfigList, turned into indexes in the
+// DiskConfigList of the DomainConfig
+func translatePodContainers(containers []types.PodContainer, diskIndex []int) ([]types.PodContainer, error) {
+	var ret []types.PodContainer
+	for _, c := range containers {
+		disk, err := translateDiskIndex(diskIndex, c.ImageDisk)
+		if err != nil {
+			return nil, fmt.Errorf("image of pod container %s: %v", c.Name, err)
+		}
+		c.ImageDisk = disk
+		if c.Mounts, err = translatePodMounts(c.Mounts, diskIndex); err != nil {
+			return nil, fmt.Errorf("pod container %s: %v", c.Name, err)
+		}
+		ret = append(ret, c)
+	}
+	return ret, nil
+}
+
+// translateInitContainers does the same as translatePodContainers for the
+// init containers
+func translateInitContainers(containers []types.InitContainer, diskIndex []int) ([]types.InitContainer, error) {
+	var ret []types.InitContainer
+	for _, c := range containers {
+		disk, err := translateDiskIndex(diskIndex, c.ImageDisk)
+		if err != nil {
+			return nil, fmt.Errorf("image of init container %s: %v", c.Name, err)
+		}
+		c.ImageDisk = disk
+		if c.Mounts, err = translatePodMounts(c.Mounts, diskIndex); err != nil {
+			return nil, fmt.Errorf("init container %s: %v", c.Name, err)
+		}
+		ret = append(ret, c)
+	}
+	return ret, nil
+}
+
 func lookupDomainConfig(ctx *zedmanagerContext, key string) *types.DomainConfig {
 
 	pub := ctx.pubDomainConfig
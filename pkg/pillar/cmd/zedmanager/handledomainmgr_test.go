@@ -0,0 +1,93 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package zedmanager
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/lf-edge/eve/pkg/pillar/types"
+)
+
+func TestTranslatePodContainers(t *testing.T) {
+	containers := []types.PodContainer{
+		{
+			Name:      "web",
+			ImageDisk: 1,
+			Mounts:    []types.PodMount{{Disk: 3, Path: "/data"}},
+		},
+		{
+			Name:      "db",
+			ImageDisk: 2,
+		},
+	}
+	testMatrix := map[string]struct {
+		diskIndex []int
+		expected  []types.PodContainer
+		fail      bool
+	}{
+		"all volumes ready": {
+			diskIndex: []int{0, 1, 2, 3},
+			expected: []types.PodContainer{
+				{
+					Name:      "web",
+					ImageDisk: 1,
+					Mounts:    []types.PodMount{{Disk: 3, Path: "/data"}},
+				},
+				{
+					Name:      "db",
+					ImageDisk: 2,
+				},
+			},
+		},
+		// the disks of the volumes after the missing one move up
+		"unused volume missing": {
+			diskIndex: []int{-1, 0, 1, 2},
+			expected: []types.PodContainer{
+				{
+					Name:      "web",
+					ImageDisk: 0,
+					Mounts:    []types.PodMount{{Disk: 2, Path: "/data"}},
+				},
+				{
+					Name:      "db",
+					ImageDisk: 1,
+				},
+			},
+		},
+		"image missing": {
+			diskIndex: []int{0, 1, -1, 2},
+			fail:      true,
+		},
+		"mount missing": {
+			diskIndex: []int{0, 1, 2, -1},
+			fail:      true,
+		},
+		"not enough volumes": {
+			diskIndex: []int{0, 1, 2},
+			fail:      true,
+		},
+	}
+	for testname, test := range testMatrix {
+		t.Run(testname, func(t *testing.T) {
+			got, err := translatePodContainers(containers, test.diskIndex)
+			if test.fail {
+				if err == nil {
+					t.Errorf("no error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.expected, got); diff != "" {
+				t.Errorf("unexpected containers: %s", diff)
+			}
+		})
+	}
+	// the AppInstanceConfig is left alone
+	if containers[0].Mounts[0].Disk != 3 {
+		t.Errorf("mounts of the config changed")
+	}
+}
@@ -0,0 +1,245 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package zedmanager
+
+// App instances with a KubeManifest are not run as domains. Their objects
+// are handed to the k3s node by kubenodemgr, hence there is no
+// AppNetworkConfig or DomainConfig for them; the pods join the network
+// instance through the CNI config kubenodemgr writes for it.
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/lf-edge/eve/pkg/pillar/types"
+	uuid "github.com/satori/go.uuid"
+)
+
+// kubeAppConfig returns the KubeAppConfig for the app instance
+func kubeAppConfig(config types.AppInstanceConfig,
+	status types.AppInstanceStatus, activate bool) (types.KubeAppConfig, error) {
+
+	kc := types.KubeAppConfig{
+		UUIDandVersion: config.UUIDandVersion,
+		DisplayName:    config.DisplayName,
+		Activate:       activate,
+		Manifest:       config.KubeManifest,
+	}
+	for _, vrc := range config.VolumeRefConfigList {
+		vrs := getVolumeRefStatusFromAIStatus(&status, vrc)
+		if vrs == nil {
+			return kc, fmt.Errorf("missing VolumeRefStatus for volume %s",
+				vrc.VolumeID)
+		}
+		kc.VolumeKeys = append(kc.VolumeKeys, vrs.VolumeKey())
+	}
+	if len(config.UnderlayNetworkList) != 0 {
+		kc.NetworkInstance = config.UnderlayNetworkList[0].Network
+	}
+	return kc, nil
+}
+
+// doActivateKube is doActivate for an app instance with a KubeManifest.
+// A restart or purge takes the pods down by clearing Activate.
+func doActivateKube(ctx *zedmanagerContext, uuidStr string,
+	config types.AppInstanceConfig, status *types.AppInstanceStatus) bool {
+
+	log.Functionf("doActivateKube for %s", uuidStr)
+	changed := false
+	status.ActivateInprogress = true
+
+	kc, err := kubeAppConfig(config, *status, true)
+	if err != nil {
+		log.Errorf("doActivateKube(%s): %s", uuidStr, err)
+		status.SetErrorWithSource(err.Error(), types.KubeAppStatus{},
+			time.Now())
+		return true
+	}
+	ks := lookupKubeAppStatus(ctx, uuidStr)
+	if status.RestartInprogress == types.BringDown {
+		if ks != nil && ks.Activated {
+			log.Functionf("RestartInprogress(%s) Clear Activate", uuidStr)
+			kc.Activate = false
+		} else {
+			log.Functionf("RestartInprogress(%s) Set Activate", uuidStr)
+			status.RestartInprogress = types.BringUp
+			changed = true
+		}
+	}
+	old := lookupKubeAppConfig(ctx, uuidStr)
+	if old == nil || !cmp.Equal(*old, kc) {
+		publishKubeAppConfig(ctx, &kc)
+	}
+	if ks == nil {
+		log.Functionf("Waiting for KubeAppStatus for %s", uuidStr)
+		return changed
+	}
+	if ks.HasError() {
+		log.Errorf("Received error from kubenodemgr for %s: %s",
+			uuidStr, ks.Error)
+		status.SetErrorWithSource(ks.Error, types.KubeAppStatus{},
+			ks.ErrorTime)
+		changed = true
+	} else if status.IsErrorSource(types.KubeAppStatus{}) {
+		log.Functionf("Clearing kubenodemgr error %s", status.Error)
+		status.ClearErrorWithSource()
+		changed = true
+	}
+	if !kc.Activate || !ks.Activated {
+		log.Functionf("Waiting for KubeAppStatus Activated for %s", uuidStr)
+		return changed
+	}
+	if ks.State != status.State {
+		switch status.State {
+		case types.RESTARTING, types.PURGING:
+			// Leave unchanged until the pods are up
+			if ks.State < types.RUNNING {
+				break
+			}
+			fallthrough
+		default:
+			log.Functionf("Set State from KubeAppStatus from %d to %d",
+				status.State, ks.State)
+			status.State = ks.State
+			changed = true
+		}
+	}
+	if !status.Activated {
+		status.Activated = true
+		status.ActivateInprogress = false
+		changed = true
+	}
+	if status.RestartInprogress == types.BringUp {
+		log.Functionf("RestartInprogress(%s) activated", uuidStr)
+		status.RestartInprogress = types.NotInprogress
+		changed = true
+	}
+	if status.PurgeInprogress == types.BringUp {
+		log.Functionf("PurgeInprogress(%s) activated", uuidStr)
+		status.PurgeInprogress = types.NotInprogress
+		_ = purgeCmdDone(ctx, config, status)
+		changed = true
+	}
+	log.Functionf("doActivateKube done for %s", uuidStr)
+	return changed
+}
+
+// doInactivateKube is doInactivate for an app instance with a
+// KubeManifest. The objects are deleted from k3s on uninstall and when
+// Activate is cleared, since the volumes may be replaced by a purge.
+func doInactivateKube(ctx *zedmanagerContext, appInstID uuid.UUID,
+	status *types.AppInstanceStatus, uninstall bool) (bool, bool) {
+
+	uuidStr := appInstID.String()
+	log.Functionf("doInactivateKube for %s uninstall %t", uuidStr, uninstall)
+	changed := false
+	kc := lookupKubeAppConfig(ctx, uuidStr)
+	if uninstall {
+		if kc != nil {
+			unpublishKubeAppConfig(ctx, uuidStr)
+		}
+	} else if kc != nil && kc.Activate {
+		log.Functionf("doInactivateKube: Clearing Activate for KubeAppConfig for %s",
+			uuidStr)
+		kc.Activate = false
+		publishKubeAppConfig(ctx, kc)
+	}
+	ks := lookupKubeAppStatus(ctx, uuidStr)
+	if ks != nil && (uninstall || ks.Activated) {
+		log.Functionf("Waiting for KubeAppStatus removal/deactivate for %s",
+			uuidStr)
+		if ks.HasError() {
+			status.SetErrorWithSource(ks.Error, types.KubeAppStatus{},
+				ks.ErrorTime)
+			changed = true
+		}
+		return changed, false
+	}
+	if status.IsErrorSource(types.KubeAppStatus{}) {
+		status.ClearErrorWithSource()
+		changed = true
+	}
+	status.Activated = false
+	status.ActivateInprogress = false
+	ctx.checkFreedResources = true
+	log.Functionf("doInactivateKube done for %s", uuidStr)
+	return changed, true
+}
+
+func lookupKubeAppConfig(ctx *zedmanagerContext, key string) *types.KubeAppConfig {
+
+	pub := ctx.pubKubeAppConfig
+	c, _ := pub.Get(key)
+	if c == nil {
+		log.Tracef("lookupKubeAppConfig(%s) not found", key)
+		return nil
+	}
+	config := c.(types.KubeAppConfig)
+	return &config
+}
+
+func lookupKubeAppStatus(ctx *zedmanagerContext, key string) *types.KubeAppStatus {
+
+	sub := ctx.subKubeAppStatus
+	st, _ := sub.Get(key)
+	if st == nil {
+		log.Tracef("lookupKubeAppStatus(%s) not found", key)
+		return nil
+	}
+	status := st.(types.KubeAppStatus)
+	return &status
+}
+
+func publishKubeAppConfig(ctx *zedmanagerContext, config *types.KubeAppConfig) {
+
+	key := config.Key()
+	log.Functionf("publishKubeAppConfig(%s)", key)
+	pub := ctx.pubKubeAppConfig
+	pub.Publish(key, *config)
+}
+
+func unpublishKubeAppConfig(ctx *zedmanagerContext, key string) {
+
+	log.Functionf("unpublishKubeAppConfig(%s)", key)
+	pub := ctx.pubKubeAppConfig
+	c, _ := pub.Get(key)
+	if c == nil {
+		log.Errorf("unpublishKubeAppConfig(%s) not found", key)
+		return
+	}
+	pub.Unpublish(key)
+}
+
+func handleKubeAppStatusCreate(ctxArg interface{}, key string,
+	statusArg interface{}) {
+
+	handleKubeAppStatusImpl(ctxArg, key, statusArg)
+}
+
+func handleKubeAppStatusModify(ctxArg interface{}, key string,
+	statusArg interface{}, oldStatusArg interface{}) {
+
+	handleKubeAppStatusImpl(ctxArg, key, statusArg)
+}
+
+func handleKubeAppStatusImpl(ctxArg interface{}, key string,
+	statusArg interface{}) {
+
+	status := statusArg.(types.KubeAppStatus)
+	ctx := ctxArg.(*zedmanagerContext)
+	log.Functionf("handleKubeAppStatusImpl: key:%s, name:%s",
+		key, status.DisplayName)
+	updateAIStatusUUID(ctx, status.Key())
+	log.Functionf("handleKubeAppStatusImpl done for %s", key)
+}
+
+func handleKubeAppStatusDelete(ctxArg interface{}, key string,
+	statusArg interface{}) {
+
+	log.Functionf("handleKubeAppStatusDelete for %s", key)
+	ctx := ctxArg.(*zedmanagerContext)
+	removeAIStatusUUID(ctx, key)
+	log.Functionf("handleKubeAppStatusDelete done for %s", key)
+}
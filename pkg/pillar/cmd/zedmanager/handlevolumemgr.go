@@ -138,12 +138,8 @@ func handleVolumeRefStatusImpl(ctxArg interface{}, key string,
 	items := pub.GetAll()
 	for _, st := range items {
 		aiStatus := st.(types.AppInstanceStatus)
-		for _, vrs := range aiStatus.VolumeRefStatusList {
-			if vrs.GenerationCounter == status.GenerationCounter &&
-				vrs.VolumeID == status.VolumeID {
-
-				updateAIStatusUUID(ctx, aiStatus.UUIDandVersion.UUID.String())
-			}
+		if usesVolumeRef(aiStatus, status) {
+			updateAIStatusUUID(ctx, aiStatus.UUIDandVersion.UUID.String())
 		}
 	}
 	log.Functionf("handleVolumeRefStatusImpl done for %s", key)
@@ -160,17 +156,30 @@ func handleVolumeRefStatusDelete(ctxArg interface{}, key string,
 	items := pub.GetAll()
 	for _, st := range items {
 		aiStatus := st.(types.AppInstanceStatus)
-		for _, vrs := range aiStatus.VolumeRefStatusList {
-			if vrs.GenerationCounter == status.GenerationCounter &&
-				vrs.VolumeID == status.VolumeID {
-
-				updateAIStatusUUID(ctx, aiStatus.UUIDandVersion.UUID.String())
-			}
+		if usesVolumeRef(aiStatus, status) {
+			updateAIStatusUUID(ctx, aiStatus.UUIDandVersion.UUID.String())
 		}
 	}
 	log.Functionf("handleVolumeRefStatusDelete done for %s", key)
 }
 
+// usesVolumeRef returns true if the app instance uses or pre-stages the volume
+func usesVolumeRef(aiStatus types.AppInstanceStatus, status types.VolumeRefStatus) bool {
+	for _, vrs := range aiStatus.VolumeRefStatusList {
+		if vrs.GenerationCounter == status.GenerationCounter &&
+			vrs.VolumeID == status.VolumeID {
+			return true
+		}
+	}
+	for _, vrs := range aiStatus.NextVolumeRefStatusList {
+		if vrs.GenerationCounter == status.GenerationCounter &&
+			vrs.VolumeID == status.VolumeID {
+			return true
+		}
+	}
+	return false
+}
+
 func getVolumeRefStatusFromAIStatus(status *types.AppInstanceStatus,
 	vrc types.VolumeRefConfig) *types.VolumeRefStatus {
 
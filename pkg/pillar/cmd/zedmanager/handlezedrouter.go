@@ -26,9 +26,17 @@ func MaybeAddAppNetworkConfig(ctx *zedmanagerContext,
 	if m != nil {
 		log.Functionf("appNetwork config already exists for %s", key)
 		if len(aiConfig.UnderlayNetworkList) != len(m.UnderlayNetworkList) {
-			log.Errorln("Unsupported: Changed number of underlays for ",
-				aiConfig.UUIDandVersion)
-			return
+			if !aiStatus.HotplugInprogress {
+				log.Errorln("Unsupported: Changed number of underlays for ",
+					aiConfig.UUIDandVersion)
+				return
+			}
+			log.Functionf("MaybeAddAppNetworkConfig: number of underlays changed from %d to %d",
+				len(m.UnderlayNetworkList), len(aiConfig.UnderlayNetworkList))
+			changed = true
+		}
+		if m.Hotplug != aiStatus.HotplugInprogress {
+			changed = true
 		}
 		if m.Activate != effectiveActivate {
 			log.Functionf("MaybeAddAppNetworkConfig Activate changed from %v to %v",
@@ -54,6 +62,9 @@ func MaybeAddAppNetworkConfig(ctx *zedmanagerContext,
 			changed = true
 		}
 		for i, new := range aiConfig.UnderlayNetworkList {
+			if i >= len(m.UnderlayNetworkList) {
+				break
+			}
 			old := m.UnderlayNetworkList[i]
 			if !reflect.DeepEqual(new.ACLs, old.ACLs) {
 				log.Functionf("Under ACLs changed from %v to %v",
@@ -75,6 +86,7 @@ func MaybeAddAppNetworkConfig(ctx *zedmanagerContext,
 			CloudInitUserData: aiConfig.CloudInitUserData,
 			CipherBlockStatus: aiConfig.CipherBlockStatus,
 			MetaDataType:      aiConfig.MetaDataType,
+			Hotplug:           aiStatus.HotplugInprogress,
 		}
 		nc.UnderlayNetworkList = make([]types.UnderlayNetworkConfig,
 			len(aiConfig.UnderlayNetworkList))
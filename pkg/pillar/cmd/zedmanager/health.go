@@ -0,0 +1,286 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package zedmanager
+
+// Health checks. An app instance with a LivenessProbe in its HealthConfig
+// is probed every Period once it has been running for InitialDelay, and
+// the outcome is reported in AppInstanceStatus.Health. The RestartPolicy
+// restarts the app instance, using the same sequence as a RestartCmd, when
+// its domain crashed or halted, or when it becomes unhealthy; with an
+// exponential backoff between restarts and up to RestartMaxRetries times.
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/lf-edge/eve/pkg/pillar/containerd"
+	"github.com/lf-edge/eve/pkg/pillar/types"
+)
+
+// healthCheckInterval is how often we look for probes and restarts to do
+const healthCheckInterval = 5 * time.Second
+
+// probeResult is sent back by the goroutine running a probe
+type probeResult struct {
+	key      string
+	bootTime time.Time // Of the domain which was probed
+	time     time.Time
+	err      error
+}
+
+// checkHealth is called from a timer to start the probes which are due and
+// restart the app instances which need it
+func checkHealth(ctx *zedmanagerContext) {
+	items := ctx.subAppInstanceConfig.GetAll()
+	for _, c := range items {
+		config := c.(types.AppInstanceConfig)
+		if !config.Health.Enabled() {
+			continue
+		}
+		status := lookupAppInstanceStatus(ctx, config.Key())
+		if status == nil || !healthCheckable(status) {
+			continue
+		}
+		if maybeRestartUnhealthy(ctx, config, status) {
+			continue
+		}
+		maybeStartProbe(ctx, config, status)
+	}
+}
+
+// healthCheckable returns false while the app instance is not supposed to
+// run or is being changed
+func healthCheckable(status *types.AppInstanceStatus) bool {
+	return status.EffectiveActivate &&
+		status.RestartInprogress == types.NotInprogress &&
+		status.PurgeInprogress == types.NotInprogress &&
+		!status.HotplugInprogress
+}
+
+// maybeRestartUnhealthy applies the restart policy. Returns true if a
+// restart was started.
+func maybeRestartUnhealthy(ctx *zedmanagerContext, config types.AppInstanceConfig,
+	status *types.AppInstanceStatus) bool {
+
+	hc := config.Health
+	health := &status.Health
+	now := time.Now()
+	if health.Restarts != 0 && status.State == types.RUNNING &&
+		health.State != types.HealthUnhealthy &&
+		now.Sub(health.LastRestart) > types.RestartBackoffReset {
+		log.Noticef("maybeRestartUnhealthy(%s): up for %v, forgetting %d restarts",
+			status.Key(), types.RestartBackoffReset, health.Restarts)
+		health.Restarts = 0
+		health.GaveUp = false
+		if status.IsErrorSource(types.AppHealth{}) {
+			status.ClearErrorWithSource()
+		}
+		publishAppInstanceStatus(ctx, status)
+	}
+	domainError := status.IsErrorSource(types.DomainStatus{})
+	unhealthy := health.State == types.HealthUnhealthy
+	if health.GaveUp || !hc.NeedRestart(status.State, domainError, unhealthy) {
+		return false
+	}
+	if hc.RestartMaxRetries != 0 && health.Restarts >= hc.RestartMaxRetries {
+		errStr := fmt.Sprintf("giving up after %d restarts", health.Restarts)
+		log.Errorf("maybeRestartUnhealthy(%s): %s", status.Key(), errStr)
+		health.GaveUp = true
+		status.SetErrorWithSource(errStr, types.AppHealth{}, now)
+		publishAppInstanceStatus(ctx, status)
+		return false
+	}
+	if now.Sub(health.LastRestart) < hc.BackoffDelay(health.Restarts) {
+		log.Functionf("maybeRestartUnhealthy(%s): backing off after %d restarts",
+			status.Key(), health.Restarts)
+		return false
+	}
+	log.Noticef("maybeRestartUnhealthy(%s): restart %d in state %s health %s: %s",
+		status.Key(), health.Restarts+1, status.State.String(),
+		health.State.String(), health.LastError)
+	health.Restarts++
+	health.LastRestart = now
+	health.State = types.HealthUnknown
+	health.Failures = 0
+	status.RestartInprogress = types.BringDown
+	status.State = types.RESTARTING
+	publishAppInstanceStatus(ctx, status)
+	doUpdate(ctx, config, status)
+	publishAppInstanceStatus(ctx, status)
+	return true
+}
+
+// maybeStartProbe starts the liveness probe in a goroutine if it is due.
+// The result comes back on healthResults.
+func maybeStartProbe(ctx *zedmanagerContext, config types.AppInstanceConfig,
+	status *types.AppInstanceStatus) {
+
+	probe := config.Health.LivenessProbe
+	if probe.Type == types.ProbeTypeNone || !status.Activated ||
+		status.State != types.RUNNING {
+		return
+	}
+	if ctx.probesInprogress[status.Key()] {
+		return
+	}
+	now := time.Now()
+	if now.Before(status.BootTime.Add(time.Duration(probe.InitialDelay) * time.Second)) {
+		return
+	}
+	if now.Sub(status.Health.LastProbe) < probe.PeriodOrDefault() {
+		return
+	}
+	run, err := prepareProbe(ctx, config, status)
+	if err != nil {
+		handleProbeResult(ctx, probeResult{key: status.Key(),
+			bootTime: status.BootTime, time: now, err: err})
+		return
+	}
+	ctx.probesInprogress[status.Key()] = true
+	key := status.Key()
+	bootTime := status.BootTime
+	log.Functionf("Creating %s for %s", "probe", key)
+	go func() {
+		err := run()
+		ctx.healthResults <- probeResult{key: key, bootTime: bootTime,
+			time: time.Now(), err: err}
+	}()
+}
+
+// prepareProbe returns the function running the probe. It does not touch
+// the context hence can run in a goroutine.
+func prepareProbe(ctx *zedmanagerContext, config types.AppInstanceConfig,
+	status *types.AppInstanceStatus) (func() error, error) {
+
+	probe := config.Health.LivenessProbe
+	timeout := probe.TimeoutOrDefault()
+	switch probe.Type {
+	case types.ProbeTypeTCP, types.ProbeTypeHTTP:
+		if len(status.UnderlayNetworks) == 0 ||
+			status.UnderlayNetworks[0].AllocatedIPAddr == "" {
+			return nil, fmt.Errorf("no IP address to probe")
+		}
+		ulStatus := status.UnderlayNetworks[0]
+		dialer := &net.Dialer{Timeout: timeout}
+		if bridgeIP := net.ParseIP(ulStatus.BridgeIPAddr); bridgeIP != nil {
+			dialer.LocalAddr = &net.TCPAddr{IP: bridgeIP}
+		}
+		addr := net.JoinHostPort(ulStatus.AllocatedIPAddr,
+			strconv.Itoa(int(probe.Port)))
+		if probe.Type == types.ProbeTypeTCP {
+			return func() error { return probeTCP(dialer, addr) }, nil
+		}
+		url := fmt.Sprintf("http://%s%s", addr, probe.Path)
+		return func() error { return probeHTTP(dialer, url, timeout) }, nil
+	case types.ProbeTypeExec:
+		if config.FixedResources.VirtualizationMode != types.NOHYPER {
+			return nil, fmt.Errorf("exec probe needs a container")
+		}
+		if len(probe.Command) == 0 {
+			return nil, fmt.Errorf("exec probe without a command")
+		}
+		if ctx.ctrdClient == nil {
+			ctrdClient, err := containerd.NewContainerdClient()
+			if err != nil {
+				return nil, fmt.Errorf("no containerd client: %v", err)
+			}
+			ctx.ctrdClient = ctrdClient
+		}
+		ctrdClient := ctx.ctrdClient
+		domainName := status.DomainName
+		return func() error {
+			return probeExec(ctrdClient, domainName, probe.Command, timeout)
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown probe type %d", probe.Type)
+	}
+}
+
+func probeTCP(dialer *net.Dialer, addr string) error {
+	conn, err := dialer.Dial("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func probeHTTP(dialer *net.Dialer, url string, timeout time.Duration) error {
+	client := &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:       dialer.DialContext,
+			DisableKeepAlives: true,
+		},
+		// Any redirect is a success
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return fmt.Errorf("GET %s returned %s", url, resp.Status)
+	}
+	return nil
+}
+
+func probeExec(ctrdClient *containerd.Client, domainName string,
+	command []string, timeout time.Duration) error {
+
+	ctrdCtx, done := ctrdClient.CtrNewUserServicesCtx()
+	defer done()
+	execCtx, cancel := context.WithTimeout(ctrdCtx, timeout)
+	defer cancel()
+	stdout, stderr, err := ctrdClient.CtrExec(execCtx, domainName, command)
+	if err != nil {
+		return fmt.Errorf("%v: %s%s", command, stdout, stderr)
+	}
+	return nil
+}
+
+// handleProbeResult updates the health of the app instance and applies
+// the restart policy if it became unhealthy
+func handleProbeResult(ctx *zedmanagerContext, result probeResult) {
+	delete(ctx.probesInprogress, result.key)
+	config := lookupAppInstanceConfig(ctx, result.key)
+	status := lookupAppInstanceStatus(ctx, result.key)
+	if config == nil || status == nil || !healthCheckable(status) ||
+		!result.bootTime.Equal(status.BootTime) {
+		log.Functionf("handleProbeResult(%s): ignoring stale result",
+			result.key)
+		return
+	}
+	probe := config.Health.LivenessProbe
+	health := &status.Health
+	health.LastProbe = result.time
+	if result.err == nil {
+		if health.State != types.HealthHealthy {
+			log.Noticef("handleProbeResult(%s): healthy", status.Key())
+			health.HealthySince = result.time
+		}
+		health.State = types.HealthHealthy
+		health.Failures = 0
+		health.LastError = ""
+	} else {
+		health.Failures++
+		health.LastError = result.err.Error()
+		log.Warnf("handleProbeResult(%s): failure %d: %v",
+			status.Key(), health.Failures, result.err)
+		if health.Failures >= probe.FailureThresholdOrDefault() &&
+			health.State != types.HealthUnhealthy {
+			log.Noticef("handleProbeResult(%s): unhealthy after %d failures",
+				status.Key(), health.Failures)
+			health.State = types.HealthUnhealthy
+		}
+	}
+	publishAppInstanceStatus(ctx, status)
+	maybeRestartUnhealthy(ctx, *config, status)
+}
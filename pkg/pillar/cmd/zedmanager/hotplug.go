@@ -0,0 +1,111 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package zedmanager
+
+// Live changes of the volumes and network interfaces of a running app
+// instance. Adding or removing them normally needs a purge. When domainmgr
+// reports hot-plug slots for the running domain, and the changes are
+// limited to volumes other than the first one and to network interfaces at
+// the end of the list, the new volumes are created and the DomainConfig and
+// AppNetworkConfig are updated without halting the domain.
+
+import (
+	"github.com/google/go-cmp/cmp"
+	"github.com/lf-edge/eve/pkg/pillar/types"
+)
+
+func sameVolumeRef(a, b types.VolumeRefConfig) bool {
+	return a.VolumeID == b.VolumeID &&
+		a.GenerationCounter == b.GenerationCounter
+}
+
+func hasVolumeRef(list []types.VolumeRefConfig, vrc types.VolumeRefConfig) bool {
+	for _, v := range list {
+		if sameVolumeRef(v, vrc) {
+			return true
+		}
+	}
+	return false
+}
+
+// hotplugPossible returns true if the changes from oldConfig to config can
+// be applied to the running domain instead of purging it
+func hotplugPossible(ctx *zedmanagerContext, config types.AppInstanceConfig,
+	oldConfig types.AppInstanceConfig, status *types.AppInstanceStatus) bool {
+
+	if !status.Activated || !status.EffectiveActivate ||
+		status.PurgeInprogress != types.NotInprogress ||
+		status.RestartInprogress != types.NotInprogress {
+		return false
+	}
+	ds := lookupDomainStatus(ctx, status.Key())
+	if ds == nil || !ds.Activated || ds.HotplugSlots == 0 {
+		return false
+	}
+	if !cmp.Equal(config.IoAdapterList, oldConfig.IoAdapterList) {
+		return false
+	}
+	// The domain boots from the first volume
+	if len(config.VolumeRefConfigList) == 0 ||
+		len(oldConfig.VolumeRefConfigList) == 0 ||
+		!sameVolumeRef(config.VolumeRefConfigList[0],
+			oldConfig.VolumeRefConfigList[0]) {
+		return false
+	}
+	added := 0
+	for _, vrc := range config.VolumeRefConfigList {
+		if !hasVolumeRef(oldConfig.VolumeRefConfigList, vrc) {
+			added++
+		}
+	}
+	// The underlays we keep have to be unchanged since their
+	// interfaces are named by position
+	oldCount := len(oldConfig.UnderlayNetworkList)
+	for i, uc := range config.UnderlayNetworkList {
+		if i >= oldCount {
+			added++
+			continue
+		}
+		old := oldConfig.UnderlayNetworkList[i]
+		if old.AppMacAddr.String() != uc.AppMacAddr.String() ||
+			!old.AppIPAddr.Equal(uc.AppIPAddr) ||
+			old.Network != uc.Network {
+			return false
+		}
+	}
+	if free := ds.FreeHotplugSlots(); added > free {
+		log.Functionf("hotplugPossible(%s): adding %d with %d free slots",
+			status.Key(), added, free)
+		return false
+	}
+	return true
+}
+
+// hotplugDone returns true once the domain has the volumes and network
+// interfaces in the DomainConfig and the removed volumes are gone
+func hotplugDone(config types.AppInstanceConfig, status *types.AppInstanceStatus,
+	dc types.DomainConfig, ds types.DomainStatus) bool {
+
+	if len(status.VolumeRefStatusList) != len(config.VolumeRefConfigList) {
+		return false
+	}
+	if len(ds.VifList) != len(dc.VifList) {
+		return false
+	}
+	diskKeys := make(map[string]bool)
+	for _, disk := range ds.DiskStatusList {
+		if disk.VolumeKey != "" {
+			diskKeys[disk.VolumeKey] = true
+		}
+	}
+	if len(diskKeys) != len(dc.DiskConfigList) {
+		return false
+	}
+	for _, disk := range dc.DiskConfigList {
+		if !diskKeys[disk.VolumeKey] {
+			return false
+		}
+	}
+	return true
+}
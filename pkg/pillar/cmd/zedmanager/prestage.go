@@ -0,0 +1,206 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package zedmanager
+
+// Pre-staging of the volumes of the next version of an app instance.
+// The volumes in NextVolumeRefConfigList are referenced so that volumemgr
+// downloads, verifies and creates them while the domain keeps running with
+// the current volumes. Once NextActivationTime has passed and all of them
+// are created, the switchover is done using the purge sequence, which then
+// finds all of its new volumes ready.
+
+import (
+	"time"
+
+	"github.com/lf-edge/eve/pkg/pillar/types"
+)
+
+// prestageCheckInterval is how often we look for due activations
+const prestageCheckInterval = 10 * time.Second
+
+// effectiveAppInstanceConfig returns the config with the next volumes in
+// VolumeRefConfigList once they have been activated
+func effectiveAppInstanceConfig(config types.AppInstanceConfig,
+	status *types.AppInstanceStatus) types.AppInstanceConfig {
+
+	if status.NextActivated && len(config.NextVolumeRefConfigList) != 0 {
+		config.VolumeRefConfigList = config.NextVolumeRefConfigList
+		config.NextVolumeRefConfigList = nil
+	}
+	return config
+}
+
+func getNextVolumeRefStatus(status *types.AppInstanceStatus,
+	vrc types.VolumeRefConfig) *types.VolumeRefStatus {
+
+	for i := range status.NextVolumeRefStatusList {
+		vrs := &status.NextVolumeRefStatusList[i]
+		if vrs.VolumeID == vrc.VolumeID && vrs.GenerationCounter == vrc.GenerationCounter {
+			return vrs
+		}
+	}
+	return nil
+}
+
+func getNextVolumeRefConfig(config *types.AppInstanceConfig,
+	vrs types.VolumeRefStatus) *types.VolumeRefConfig {
+
+	for i := range config.NextVolumeRefConfigList {
+		vrc := &config.NextVolumeRefConfigList[i]
+		if vrc.VolumeID == vrs.VolumeID && vrc.GenerationCounter == vrs.GenerationCounter {
+			return vrc
+		}
+	}
+	return nil
+}
+
+// doPrestage references the next volumes in the config, drops the ones
+// which were removed from it, and starts the switchover when it is due.
+// Returns true if the status changed.
+func doPrestage(ctx *zedmanagerContext, config types.AppInstanceConfig,
+	status *types.AppInstanceStatus) bool {
+
+	changed := false
+	if status.NextActivated {
+		if len(config.NextVolumeRefConfigList) == 0 {
+			// The controller has made the next volumes the
+			// current ones (or gave up on them)
+			log.Noticef("doPrestage(%s): next volumes no longer in config",
+				status.Key())
+			status.NextActivated = false
+			changed = true
+		}
+		return changed
+	}
+
+	newVrs := []types.VolumeRefStatus{}
+	for _, vrs := range status.NextVolumeRefStatusList {
+		if getNextVolumeRefConfig(&config, vrs) != nil {
+			newVrs = append(newVrs, vrs)
+			continue
+		}
+		log.Functionf("doPrestage(%s): removing next volume ref %s generationCounter %d",
+			status.Key(), vrs.VolumeID, vrs.GenerationCounter)
+		MaybeRemoveVolumeRefConfig(ctx, config.UUIDandVersion.UUID,
+			vrs.VolumeID, vrs.GenerationCounter)
+		changed = true
+	}
+	status.NextVolumeRefStatusList = newVrs
+
+	for _, vrc := range config.NextVolumeRefConfigList {
+		if getNextVolumeRefStatus(status, vrc) != nil {
+			continue
+		}
+		log.Functionf("doPrestage(%s): adding next volume ref %s generationCounter %d",
+			status.Key(), vrc.VolumeID, vrc.GenerationCounter)
+		MaybeAddVolumeRefConfig(ctx, config.UUIDandVersion.UUID,
+			vrc.VolumeID, vrc.GenerationCounter, vrc.MountDir)
+		status.NextVolumeRefStatusList = append(status.NextVolumeRefStatusList,
+			types.VolumeRefStatus{
+				VolumeID:          vrc.VolumeID,
+				GenerationCounter: vrc.GenerationCounter,
+				RefCount:          vrc.RefCount,
+				MountDir:          vrc.MountDir,
+				State:             types.INITIAL,
+			})
+		changed = true
+	}
+
+	// Pick up the progress from volumemgr. Errors are only reported in
+	// the VolumeRefStatus so that they do not affect the running app.
+	nextState := types.MAXSTATE
+	hasError := false
+	for i := range status.NextVolumeRefStatusList {
+		vrs := &status.NextVolumeRefStatusList[i]
+		pubsubVrs := lookupVolumeRefStatus(ctx, vrs.Key())
+		if pubsubVrs != nil && *pubsubVrs != *vrs {
+			*vrs = *pubsubVrs
+			changed = true
+		}
+		if vrs.State < nextState {
+			nextState = vrs.State
+		}
+		if vrs.HasError() {
+			hasError = true
+		}
+	}
+	if nextState == types.MAXSTATE {
+		nextState = types.INITIAL
+	}
+	if status.NextState != nextState {
+		status.NextState = nextState
+		changed = true
+	}
+
+	if !config.NextActivationDue(time.Now()) {
+		return changed
+	}
+	if nextState < types.CREATED_VOLUME || hasError {
+		log.Functionf("doPrestage(%s): activation due but next volumes not ready",
+			status.Key())
+		return changed
+	}
+	if status.PurgeInprogress != types.NotInprogress ||
+		status.RestartInprogress != types.NotInprogress {
+		log.Functionf("doPrestage(%s): activation due but waiting for purge/restart",
+			status.Key())
+		return changed
+	}
+	log.Noticef("doPrestage(%s): switching to %d next volumes",
+		status.Key(), len(status.NextVolumeRefStatusList))
+	for _, vrs := range status.NextVolumeRefStatusList {
+		vrc := types.VolumeRefConfig{
+			VolumeID:          vrs.VolumeID,
+			GenerationCounter: vrs.GenerationCounter,
+		}
+		if getVolumeRefStatusFromAIStatus(status, vrc) != nil {
+			// Shared with the current version; drop the
+			// extra reference
+			MaybeRemoveVolumeRefConfig(ctx, config.UUIDandVersion.UUID,
+				vrs.VolumeID, vrs.GenerationCounter)
+			continue
+		}
+		status.VolumeRefStatusList = append(status.VolumeRefStatusList, vrs)
+	}
+	status.NextVolumeRefStatusList = nil
+	status.NextActivated = true
+	if status.IsErrorSource(types.AppInstanceStatus{}) {
+		status.ClearErrorWithSource()
+	}
+	status.PurgeInprogress = types.RecreateVolumes
+	status.State = types.PURGING
+	return true
+}
+
+// removeNextVolumeRefs drops the references to the pre-staged volumes
+func removeNextVolumeRefs(ctx *zedmanagerContext, status *types.AppInstanceStatus) bool {
+	changed := false
+	for _, vrs := range status.NextVolumeRefStatusList {
+		MaybeRemoveVolumeRefConfig(ctx, status.UUIDandVersion.UUID,
+			vrs.VolumeID, vrs.GenerationCounter)
+		changed = true
+	}
+	status.NextVolumeRefStatusList = nil
+	return changed
+}
+
+// checkPrestage is called from a timer to start the switchovers which
+// have become due
+func checkPrestage(ctx *zedmanagerContext) {
+	now := time.Now()
+	items := ctx.pubAppInstanceStatus.GetAll()
+	for _, st := range items {
+		status := st.(types.AppInstanceStatus)
+		if status.NextActivated || len(status.NextVolumeRefStatusList) == 0 ||
+			status.NextState < types.CREATED_VOLUME {
+			continue
+		}
+		config := lookupAppInstanceConfig(ctx, status.Key())
+		if config == nil || !config.NextActivationDue(now) {
+			continue
+		}
+		log.Functionf("checkPrestage: activation due for %s", status.Key())
+		updateAIStatusUUID(ctx, status.Key())
+	}
+}
@@ -0,0 +1,287 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package zedmanager
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/lf-edge/eve/pkg/pillar/base"
+	"github.com/lf-edge/eve/pkg/pillar/pubsub"
+	"github.com/lf-edge/eve/pkg/pillar/types"
+	uuid "github.com/satori/go.uuid"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	testVol1 = uuid.FromStringOrNil("6ba7b810-9dad-11d1-80b4-00c04fd430d1")
+	testVol2 = uuid.FromStringOrNil("6ba7b810-9dad-11d1-80b4-00c04fd430d2")
+	testVol3 = uuid.FromStringOrNil("6ba7b810-9dad-11d1-80b4-00c04fd430d3")
+)
+
+// prestageTestContext returns a context with the VolumeRefConfig for vol1,
+// which the current version uses, and with the given VolumeRefStatus from
+// volumemgr
+func prestageTestContext(t *testing.T, volumes []types.VolumeRefStatus) *zedmanagerContext {
+	logger := logrus.StandardLogger()
+	log = base.NewSourceLogObject(logger, "zedmanager", 1234)
+	ps := pubsub.New(&pubsub.EmptyDriver{}, logger, log)
+	pubVolumeRefConfig, err := ps.NewPublication(pubsub.PublicationOptions{
+		AgentName:  agentName,
+		AgentScope: types.AppImgObj,
+		TopicType:  types.VolumeRefConfig{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	subVolumeRefStatus, err := ps.NewSubscription(pubsub.SubscriptionOptions{
+		AgentName:   "volumemgr",
+		MyAgentName: agentName,
+		AgentScope:  types.AppImgObj,
+		TopicImpl:   types.VolumeRefStatus{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := &zedmanagerContext{
+		pubVolumeRefConfig: pubVolumeRefConfig,
+		subVolumeRefStatus: subVolumeRefStatus,
+	}
+	MaybeAddVolumeRefConfig(ctx, testApp1, testVol1, 0, "")
+	for _, vrs := range volumes {
+		b, err := json.Marshal(vrs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		subVolumeRefStatus.ProcessChange(pubsub.Change{
+			Operation: pubsub.Modify, Key: vrs.Key(), Value: b})
+	}
+	return ctx
+}
+
+func TestDoPrestage(t *testing.T) {
+	ref := func(ids ...uuid.UUID) []types.VolumeRefConfig {
+		var refs []types.VolumeRefConfig
+		for _, id := range ids {
+			refs = append(refs, types.VolumeRefConfig{VolumeID: id,
+				RefCount: 1})
+		}
+		return refs
+	}
+	volume := func(id uuid.UUID, state types.SwState,
+		err string) types.VolumeRefStatus {
+		vrs := types.VolumeRefStatus{VolumeID: id, RefCount: 1,
+			State: state}
+		vrs.Error = err
+		return vrs
+	}
+	ids := func(refs []types.VolumeRefConfig) []uuid.UUID {
+		var ids []uuid.UUID
+		for _, vrc := range refs {
+			ids = append(ids, vrc.VolumeID)
+		}
+		return ids
+	}
+
+	testMatrix := map[string]struct {
+		current       []types.VolumeRefConfig // vol1 if nil
+		next          []types.VolumeRefConfig
+		activateIn    time.Duration // From now; negative if due
+		volumes       []types.VolumeRefStatus
+		purging       bool
+		nextActivated bool
+		// Expected
+		changed   bool
+		activated bool
+		nextState types.SwState
+		statuses  []uuid.UUID       // VolumeRefStatusList
+		refCounts map[uuid.UUID]int // Zero if not published
+		effective []uuid.UUID       // VolumeRefConfigList used
+	}{
+		"no next version": {
+			changed:   true,
+			nextState: types.INITIAL,
+			statuses:  []uuid.UUID{testVol1},
+			refCounts: map[uuid.UUID]int{testVol1: 1},
+			effective: []uuid.UUID{testVol1},
+		},
+		"not due": {
+			next:       ref(testVol2),
+			activateIn: time.Hour,
+			volumes:    []types.VolumeRefStatus{volume(testVol2, types.CREATED_VOLUME, "")},
+			changed:    true,
+			nextState:  types.CREATED_VOLUME,
+			statuses:   []uuid.UUID{testVol1},
+			refCounts:  map[uuid.UUID]int{testVol1: 1, testVol2: 1},
+			effective:  []uuid.UUID{testVol1},
+		},
+		"no activation time": {
+			next:      ref(testVol2),
+			volumes:   []types.VolumeRefStatus{volume(testVol2, types.CREATED_VOLUME, "")},
+			changed:   true,
+			nextState: types.CREATED_VOLUME,
+			statuses:  []uuid.UUID{testVol1},
+			refCounts: map[uuid.UUID]int{testVol1: 1, testVol2: 1},
+			effective: []uuid.UUID{testVol1},
+		},
+		"due but not created": {
+			next:       ref(testVol2, testVol3),
+			activateIn: -time.Minute,
+			volumes: []types.VolumeRefStatus{
+				volume(testVol2, types.CREATED_VOLUME, ""),
+				volume(testVol3, types.DOWNLOADING, ""),
+			},
+			changed:   true,
+			nextState: types.DOWNLOADING,
+			statuses:  []uuid.UUID{testVol1},
+			refCounts: map[uuid.UUID]int{testVol1: 1, testVol2: 1, testVol3: 1},
+			effective: []uuid.UUID{testVol1},
+		},
+		"due but not seen by volumemgr": {
+			next:       ref(testVol2),
+			activateIn: -time.Minute,
+			changed:    true,
+			nextState:  types.INITIAL,
+			statuses:   []uuid.UUID{testVol1},
+			refCounts:  map[uuid.UUID]int{testVol1: 1, testVol2: 1},
+			effective:  []uuid.UUID{testVol1},
+		},
+		"due with an error": {
+			next:       ref(testVol2),
+			activateIn: -time.Minute,
+			volumes:    []types.VolumeRefStatus{volume(testVol2, types.CREATED_VOLUME, "no space left")},
+			changed:    true,
+			nextState:  types.CREATED_VOLUME,
+			statuses:   []uuid.UUID{testVol1},
+			refCounts:  map[uuid.UUID]int{testVol1: 1, testVol2: 1},
+			effective:  []uuid.UUID{testVol1},
+		},
+		"due during a purge": {
+			next:       ref(testVol2),
+			activateIn: -time.Minute,
+			volumes:    []types.VolumeRefStatus{volume(testVol2, types.CREATED_VOLUME, "")},
+			purging:    true,
+			changed:    true,
+			nextState:  types.CREATED_VOLUME,
+			statuses:   []uuid.UUID{testVol1},
+			refCounts:  map[uuid.UUID]int{testVol1: 1, testVol2: 1},
+			effective:  []uuid.UUID{testVol1},
+		},
+		"due": {
+			next:       ref(testVol2),
+			activateIn: -time.Minute,
+			volumes:    []types.VolumeRefStatus{volume(testVol2, types.CREATED_VOLUME, "")},
+			changed:    true,
+			activated:  true,
+			nextState:  types.CREATED_VOLUME,
+			statuses:   []uuid.UUID{testVol1, testVol2},
+			refCounts:  map[uuid.UUID]int{testVol1: 1, testVol2: 1},
+			effective:  []uuid.UUID{testVol2},
+		},
+		"due with a shared volume": {
+			next:       ref(testVol1, testVol2),
+			activateIn: -time.Minute,
+			volumes: []types.VolumeRefStatus{
+				volume(testVol1, types.CREATED_VOLUME, ""),
+				volume(testVol2, types.CREATED_VOLUME, ""),
+			},
+			changed:   true,
+			activated: true,
+			nextState: types.CREATED_VOLUME,
+			// The extra reference to vol1 is dropped
+			statuses:  []uuid.UUID{testVol1, testVol2},
+			refCounts: map[uuid.UUID]int{testVol1: 1, testVol2: 1},
+			effective: []uuid.UUID{testVol1, testVol2},
+		},
+		"activated": {
+			next:          ref(testVol2),
+			activateIn:    -time.Minute,
+			nextActivated: true,
+			activated:     true,
+			statuses:      []uuid.UUID{testVol1},
+			refCounts:     map[uuid.UUID]int{testVol1: 1},
+			effective:     []uuid.UUID{testVol2},
+		},
+		"next cleared by the controller after activation": {
+			current:       ref(testVol2),
+			nextActivated: true,
+			changed:       true,
+			statuses:      []uuid.UUID{testVol1},
+			refCounts:     map[uuid.UUID]int{testVol1: 1},
+			effective:     []uuid.UUID{testVol2},
+		},
+	}
+	for testname, test := range testMatrix {
+		t.Run(testname, func(t *testing.T) {
+			ctx := prestageTestContext(t, test.volumes)
+			config := types.AppInstanceConfig{
+				VolumeRefConfigList:     test.current,
+				NextVolumeRefConfigList: test.next,
+			}
+			if config.VolumeRefConfigList == nil {
+				config.VolumeRefConfigList = ref(testVol1)
+			}
+			config.UUIDandVersion.UUID = testApp1
+			if test.activateIn != 0 {
+				config.NextActivationTime = time.Now().Add(test.activateIn)
+			}
+			status := types.AppInstanceStatus{
+				VolumeRefStatusList: []types.VolumeRefStatus{
+					volume(testVol1, types.CREATED_VOLUME, ""),
+				},
+				NextActivated: test.nextActivated,
+			}
+			status.UUIDandVersion.UUID = testApp1
+			if test.purging {
+				status.PurgeInprogress = types.RecreateVolumes
+			}
+
+			changed := doPrestage(ctx, config, &status)
+			if changed != test.changed {
+				t.Errorf("changed %t, expected %t", changed, test.changed)
+			}
+			if status.NextActivated != test.activated {
+				t.Errorf("NextActivated %t, expected %t",
+					status.NextActivated, test.activated)
+			}
+			if status.NextState != test.nextState {
+				t.Errorf("NextState %s, expected %s", status.NextState,
+					test.nextState)
+			}
+			if test.activated && !test.nextActivated {
+				if status.PurgeInprogress != types.RecreateVolumes ||
+					status.State != types.PURGING {
+					t.Errorf("not purging: %v %s",
+						status.PurgeInprogress, status.State)
+				}
+				if len(status.NextVolumeRefStatusList) != 0 {
+					t.Errorf("next volumes left: %v",
+						status.NextVolumeRefStatusList)
+				}
+			}
+			var statuses []uuid.UUID
+			for _, vrs := range status.VolumeRefStatusList {
+				statuses = append(statuses, vrs.VolumeID)
+			}
+			if diff := cmp.Diff(test.statuses, statuses); diff != "" {
+				t.Errorf("unexpected volume statuses: %s", diff)
+			}
+			refCounts := make(map[uuid.UUID]int)
+			for _, c := range ctx.pubVolumeRefConfig.GetAll() {
+				vrc := c.(types.VolumeRefConfig)
+				refCounts[vrc.VolumeID] = int(vrc.RefCount)
+			}
+			if diff := cmp.Diff(test.refCounts, refCounts); diff != "" {
+				t.Errorf("unexpected refcounts: %s", diff)
+			}
+			effective := effectiveAppInstanceConfig(config, &status)
+			if diff := cmp.Diff(test.effective,
+				ids(effective.VolumeRefConfigList)); diff != "" {
+				t.Errorf("unexpected effective volumes: %s", diff)
+			}
+		})
+	}
+}
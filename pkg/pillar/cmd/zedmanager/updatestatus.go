@@ -5,8 +5,10 @@ package zedmanager
 
 import (
 	"fmt"
+	"os"
 	"time"
 
+	"github.com/google/go-cmp/cmp"
 	"github.com/lf-edge/eve/pkg/pillar/types"
 	"github.com/lf-edge/eve/pkg/pillar/uuidtonum"
 	"github.com/satori/go.uuid"
@@ -102,9 +104,14 @@ func doUpdate(ctx *zedmanagerContext,
 
 	log.Functionf("doUpdate: UUID:%s, Name", uuidStr)
 
+	// Pre-staging of the next volumes might start a purge to switch to them
+	changed := doPrestage(ctx, config, status)
+	config = effectiveAppInstanceConfig(config, status)
+
 	// The existence of Config is interpreted to mean the
 	// AppInstance should be INSTALLED. Activate is checked separately.
-	changed, done := doInstall(ctx, config, status)
+	c, done := doInstall(ctx, config, status)
+	changed = changed || c
 	if !done {
 		return changed
 	}
@@ -125,13 +132,17 @@ func doUpdate(ctx *zedmanagerContext,
 		log.Functionf("PurgeInprogress(%s) bringing it up",
 			status.Key())
 	}
-	c, done := doPrepare(ctx, config, status)
+	c, done = doPrepare(ctx, config, status)
 	changed = changed || c
 	if !done {
 		return changed
 	}
 
 	if !status.EffectiveActivate {
+		if status.StartBlocked != "" {
+			status.StartBlocked = ""
+			changed = true
+		}
 		if status.Activated || status.ActivateInprogress {
 			c := doInactivateHalt(ctx, config, status)
 			changed = changed || c
@@ -148,6 +159,14 @@ func doUpdate(ctx *zedmanagerContext,
 		return changed
 	}
 	log.Functionf("Have config.Activate for %s", uuidStr)
+	if !status.Activated && !status.ActivateInprogress {
+		c, blocked := doStartDependencies(ctx, config, status)
+		changed = changed || c
+		if blocked {
+			log.Functionf("Waiting for start dependencies of %s", uuidStr)
+			return changed
+		}
+	}
 	c = doActivate(ctx, uuidStr, config, status)
 	changed = changed || c
 	log.Functionf("doUpdate done for %s", uuidStr)
@@ -170,7 +189,8 @@ func doInstall(ctx *zedmanagerContext,
 		errString := fmt.Sprintf("Mismatch in volumeRefConfig vs. Status length: %d vs %d",
 			len(config.VolumeRefConfigList),
 			len(status.VolumeRefStatusList))
-		if status.PurgeInprogress == types.NotInprogress {
+		if status.PurgeInprogress == types.NotInprogress &&
+			!status.HotplugInprogress {
 			log.Errorln(errString)
 			status.SetError(errString, time.Now())
 			return true, false
@@ -182,7 +202,9 @@ func doInstall(ctx *zedmanagerContext,
 	// and not used by any domain while purging. VolumeRefConfig which are
 	// not in AppInstanceConfig but used by some running domain will be
 	// removed as part of purgeCmdDone.
-	if status.PurgeInprogress == types.RecreateVolumes {
+	// When hot-plugging they are removed once the domain no longer has them.
+	if status.PurgeInprogress == types.RecreateVolumes ||
+		status.HotplugInprogress {
 		domainVolMap := make(map[string]bool)
 		domainConfig := lookupDomainConfig(ctx, status.Key())
 		if domainConfig != nil {
@@ -190,6 +212,14 @@ func doInstall(ctx *zedmanagerContext,
 				domainVolMap[dc.VolumeKey] = true
 			}
 		}
+		if status.HotplugInprogress {
+			domainStatus := lookupDomainStatus(ctx, status.Key())
+			if domainStatus != nil {
+				for _, ds := range domainStatus.DiskStatusList {
+					domainVolMap[ds.VolumeKey] = true
+				}
+			}
+		}
 		removed := false
 		newVrs := []types.VolumeRefStatus{}
 		for i := range status.VolumeRefStatusList {
@@ -232,7 +262,8 @@ func doInstall(ctx *zedmanagerContext,
 		if vrs != nil {
 			continue
 		}
-		if status.PurgeInprogress == types.NotInprogress {
+		if status.PurgeInprogress == types.NotInprogress &&
+			!status.HotplugInprogress {
 			errString := fmt.Sprintf("New volumeRefConfig (VolumeID: %s, GenerationCounter: %d) found."+
 				"New Storage configs are not allowed unless purged",
 				vrc.VolumeID, vrc.GenerationCounter)
@@ -253,7 +284,8 @@ func doInstall(ctx *zedmanagerContext,
 		changed = true
 	}
 
-	if status.State < types.CREATED_VOLUME || status.PurgeInprogress != types.NotInprogress {
+	if status.State < types.CREATED_VOLUME || status.PurgeInprogress != types.NotInprogress ||
+		status.HotplugInprogress {
 		for i := range status.VolumeRefStatusList {
 			vrs := &status.VolumeRefStatusList[i]
 			c := doInstallVolumeRef(ctx, config, status, vrs)
@@ -393,45 +425,25 @@ func doActivate(ctx *zedmanagerContext, uuidStr string,
 		}
 	}
 
-	// Check that if we have sufficient memory
-	if !status.ActivateInprogress && !status.Activated &&
-		!ctx.globalConfig.GlobalValueBool(types.IgnoreMemoryCheckForApps) {
-
-		remaining, latent, err := getRemainingMemory(ctx)
-		if err != nil {
-			errStr := fmt.Sprintf("getRemainingMemory failed: %s\n",
-				err)
+	// Check that we have sufficient CPUs, memory, adapters and addresses
+	if !status.ActivateInprogress && !status.Activated {
+		if err := admitAppInstance(ctx, config, status); err != nil {
+			errStr := fmt.Sprintf("Insufficient resources: %s", err)
 			log.Errorf("doActivate(%s) failed: %s",
 				status.Key(), errStr)
 			status.SetErrorWithSource(errStr,
 				types.AppInstanceConfig{}, time.Now())
-			status.MissingMemory = true
-			changed = true
-			return changed
-		}
-		if remaining < uint64(config.FixedResources.Memory)<<10 {
-			errStr := fmt.Sprintf("Remaining memory bytes %d app instance needs %d\n",
-				remaining, config.FixedResources.Memory<<10)
-			log.Errorf("doActivate(%s) failed: %s",
-				status.Key(), errStr)
-			status.SetErrorWithSource(errStr,
-				types.AppInstanceConfig{}, time.Now())
-			status.MissingMemory = true
+			status.MissingResources = true
 			publishAppInstanceStatus(ctx, status)
 			changed = true
 			return changed
 		}
-		if remaining < latent+uint64(config.FixedResources.Memory)<<10 {
-			log.Warnf("Deploying %s memory %d kB remaining %d kB but latent memory use %d kB",
-				config.DisplayName, config.FixedResources.Memory,
-				remaining>>10, latent>>10)
-		} else {
-			log.Functionf("Deploying %s memory %d kB remaining %d kB latent %d kB",
-				config.DisplayName, config.FixedResources.Memory,
-				remaining>>10, latent>>10)
-		}
 	}
-	// Commit that we will be using memory and
+	if config.KubeManifest != "" {
+		c := doActivateKube(ctx, uuidStr, config, status)
+		return changed || c
+	}
+	// Commit that we will be using the resources and
 	// Track that we have cleanup work in case something fails
 	status.ActivateInprogress = true
 
@@ -508,10 +520,45 @@ func doActivate(ctx *zedmanagerContext, uuidStr string,
 		status.BootTime = ds.BootTime
 		changed = true
 	}
+	if !cmp.Equal(status.GuestInfo, ds.GuestInfo) {
+		status.GuestInfo = ds.GuestInfo
+		changed = true
+	}
+	if !cmp.Equal(status.PodContainers, ds.PodContainers) {
+		status.PodContainers = ds.PodContainers
+		changed = true
+	}
+	if !cmp.Equal(status.InitContainers, ds.InitContainers) {
+		status.InitContainers = ds.InitContainers
+		changed = true
+	}
+	if updateCrashDump(status, ds) {
+		changed = true
+	}
 	c := updateVifUsed(status, *ds)
 	if c {
 		changed = true
 	}
+	// Are we rebooting the guest OS? If the guest agent could not do it
+	// the domain is restarted instead.
+	if status.RestartInprogress == types.GuestReboot {
+		dc.GuestRebootCounter = config.RestartCmd.Counter
+		if ds.GuestRebootCounter != dc.GuestRebootCounter {
+			log.Functionf("RestartInprogress(%s) waiting for guest reboot",
+				status.Key())
+		} else if ds.GuestRebootFailed {
+			log.Warnf("RestartInprogress(%s) guest reboot failed; restart the domain",
+				status.Key())
+			status.RestartInprogress = types.BringDown
+			changed = true
+		} else {
+			log.Functionf("RestartInprogress(%s) guest rebooted",
+				status.Key())
+			status.RestartInprogress = types.NotInprogress
+			status.State = types.RUNNING
+			changed = true
+		}
+	}
 	// Are we doing a restart?
 	if status.RestartInprogress == types.BringDown {
 		if dc.Activate {
@@ -579,6 +626,11 @@ func doActivate(ctx *zedmanagerContext, uuidStr string,
 	}
 	log.Functionf("Done with DomainStatus for %s", uuidStr)
 
+	if status.HotplugInprogress && hotplugDone(config, status, *dc, *ds) {
+		log.Functionf("HotplugInprogress(%s) done", status.Key())
+		status.HotplugInprogress = false
+		changed = true
+	}
 	if !status.Activated {
 		status.Activated = true
 		status.ActivateInprogress = false
@@ -615,6 +667,28 @@ func doActivate(ctx *zedmanagerContext, uuidStr string,
 }
 
 // Check if VifUsed has changed and return true if it has
+// updateCrashDump keeps the last dump even once domainmgr forgets about
+// it, for as long as it is not evicted by newer dumps
+func updateCrashDump(status *types.AppInstanceStatus, ds *types.DomainStatus) bool {
+	if ds.CrashDump.FileLocation != "" &&
+		!cmp.Equal(status.CrashDump, ds.CrashDump) {
+		log.Noticef("Update crash dump to %s for %s",
+			ds.CrashDump.FileLocation, status.Key())
+		status.CrashDump = ds.CrashDump
+		return true
+	}
+	if status.CrashDump.FileLocation == "" {
+		return false
+	}
+	if _, err := os.Stat(status.CrashDump.FileLocation); !os.IsNotExist(err) {
+		return false
+	}
+	log.Noticef("Crash dump %s of %s was removed",
+		status.CrashDump.FileLocation, status.Key())
+	status.CrashDump = types.CrashDump{}
+	return true
+}
+
 func updateVifUsed(statusPtr *types.AppInstanceStatus, ds types.DomainStatus) bool {
 	changed := false
 	for i := range statusPtr.UnderlayNetworks {
@@ -700,6 +774,10 @@ func doInactivate(ctx *zedmanagerContext, appInstID uuid.UUID,
 	done := false
 	uninstall := (status.PurgeInprogress != types.BringDown)
 
+	if lookupKubeAppConfig(ctx, uuidStr) != nil ||
+		lookupKubeAppStatus(ctx, uuidStr) != nil {
+		return doInactivateKube(ctx, appInstID, status, uninstall)
+	}
 	if uninstall {
 		log.Functionf("doInactivate uninstall for %s", uuidStr)
 		// First halt the domain by deleting
@@ -835,6 +913,9 @@ func doUninstall(ctx *zedmanagerContext, appInstID uuid.UUID,
 			vrs.VolumeID, vrs.GenerationCounter)
 		changed = true
 	}
+	if removeNextVolumeRefs(ctx, status) {
+		changed = true
+	}
 	log.Tracef("Done with all volume refs removes for %s",
 		appInstID)
 
@@ -853,6 +934,12 @@ func doInactivateHalt(ctx *zedmanagerContext,
 	log.Functionf("doInactivateHalt for %s", uuidStr)
 	changed := false
 
+	if config.KubeManifest != "" {
+		c, done := doInactivateKube(ctx, status.UUIDandVersion.UUID,
+			status, false)
+		return c || done
+	}
+
 	// Check AppNetworkStatus
 	ns := lookupAppNetworkStatus(ctx, uuidStr)
 	if ns == nil {
@@ -911,6 +998,21 @@ func doInactivateHalt(ctx *zedmanagerContext,
 		status.BootTime = ds.BootTime
 		changed = true
 	}
+	if !cmp.Equal(status.GuestInfo, ds.GuestInfo) {
+		status.GuestInfo = ds.GuestInfo
+		changed = true
+	}
+	if !cmp.Equal(status.PodContainers, ds.PodContainers) {
+		status.PodContainers = ds.PodContainers
+		changed = true
+	}
+	if !cmp.Equal(status.InitContainers, ds.InitContainers) {
+		status.InitContainers = ds.InitContainers
+		changed = true
+	}
+	if updateCrashDump(status, ds) {
+		changed = true
+	}
 	c := updateVifUsed(status, *ds)
 	if c {
 		changed = true
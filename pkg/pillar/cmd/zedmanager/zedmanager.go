@@ -16,6 +16,7 @@ import (
 	"github.com/google/go-cmp/cmp"
 	"github.com/lf-edge/eve/pkg/pillar/agentlog"
 	"github.com/lf-edge/eve/pkg/pillar/base"
+	"github.com/lf-edge/eve/pkg/pillar/containerd"
 	"github.com/lf-edge/eve/pkg/pillar/pidfile"
 	"github.com/lf-edge/eve/pkg/pillar/pubsub"
 	"github.com/lf-edge/eve/pkg/pillar/types"
@@ -35,22 +36,35 @@ var Version = "No version specified"
 
 // State used by handlers
 type zedmanagerContext struct {
-	subAppInstanceConfig pubsub.Subscription
-	pubAppInstanceStatus pubsub.Publication
-	pubVolumeRefConfig   pubsub.Publication
-	subVolumeRefStatus   pubsub.Subscription
-	pubAppNetworkConfig  pubsub.Publication
-	subAppNetworkStatus  pubsub.Subscription
-	pubDomainConfig      pubsub.Publication
-	subDomainStatus      pubsub.Subscription
-	subGlobalConfig      pubsub.Subscription
-	subHostMemory        pubsub.Subscription
-	subZedAgentStatus    pubsub.Subscription
-	globalConfig         *types.ConfigItemValueMap
-	pubUuidToNum         pubsub.Publication
-	GCInitialized        bool
-	checkFreedResources  bool // Set when app instance has !Activated
-	currentProfile       string
+	subAppInstanceConfig     pubsub.Subscription
+	pubAppInstanceStatus     pubsub.Publication
+	pubVolumeRefConfig       pubsub.Publication
+	subVolumeRefStatus       pubsub.Subscription
+	pubAppNetworkConfig      pubsub.Publication
+	subAppNetworkStatus      pubsub.Subscription
+	pubDomainConfig          pubsub.Publication
+	subDomainStatus          pubsub.Subscription
+	pubKubeAppConfig         pubsub.Publication
+	subKubeAppStatus         pubsub.Subscription
+	subGlobalConfig          pubsub.Subscription
+	subHostMemory            pubsub.Subscription
+	subNetworkInstanceStatus pubsub.Subscription
+	pubResourceAllocation    pubsub.Publication
+	subZedAgentStatus        pubsub.Subscription
+	globalConfig             *types.ConfigItemValueMap
+	pubUuidToNum             pubsub.Publication
+	GCInitialized            bool
+	checkFreedResources      bool // Set when app instance has !Activated
+	currentProfile           string
+
+	// Set when what is admitted may have changed
+	resourceAllocationChanged bool
+
+	// Liveness probes running in goroutines and their results
+	probesInprogress map[string]bool
+	healthResults    chan probeResult
+	// Created on the first exec probe
+	ctrdClient *containerd.Client
 }
 
 var debug = false
@@ -86,7 +100,9 @@ func Run(ps *pubsub.PubSub, loggerArg *logrus.Logger, logArg *base.LogObject) in
 
 	// Any state needed by handler functions
 	ctx := zedmanagerContext{
-		globalConfig: types.DefaultConfigItemValueMap(),
+		globalConfig:     types.DefaultConfigItemValueMap(),
+		probesInprogress: make(map[string]bool),
+		healthResults:    make(chan probeResult),
 	}
 	// Create publish before subscribing and activating subscriptions
 	pubAppInstanceStatus, err := ps.NewPublication(pubsub.PublicationOptions{
@@ -129,6 +145,16 @@ func Run(ps *pubsub.PubSub, loggerArg *logrus.Logger, logArg *base.LogObject) in
 	ctx.pubDomainConfig = pubDomainConfig
 	pubDomainConfig.ClearRestarted()
 
+	pubKubeAppConfig, err := ps.NewPublication(pubsub.PublicationOptions{
+		AgentName: agentName,
+		TopicType: types.KubeAppConfig{},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx.pubKubeAppConfig = pubKubeAppConfig
+	pubKubeAppConfig.ClearRestarted()
+
 	pubUuidToNum, err := ps.NewPublication(pubsub.PublicationOptions{
 		AgentName:  agentName,
 		Persistent: true,
@@ -140,6 +166,15 @@ func Run(ps *pubsub.PubSub, loggerArg *logrus.Logger, logArg *base.LogObject) in
 	ctx.pubUuidToNum = pubUuidToNum
 	pubUuidToNum.ClearRestarted()
 
+	pubResourceAllocation, err := ps.NewPublication(pubsub.PublicationOptions{
+		AgentName: agentName,
+		TopicType: types.ResourceAllocation{},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx.pubResourceAllocation = pubResourceAllocation
+
 	// Look for global config such as log levels
 	subGlobalConfig, err := ps.NewSubscription(pubsub.SubscriptionOptions{
 		AgentName:     "zedagent",
@@ -239,6 +274,25 @@ func Run(ps *pubsub.PubSub, loggerArg *logrus.Logger, logArg *base.LogObject) in
 	ctx.subDomainStatus = subDomainStatus
 	subDomainStatus.Activate()
 
+	// Get KubeAppStatus from kubenodemgr
+	subKubeAppStatus, err := ps.NewSubscription(pubsub.SubscriptionOptions{
+		AgentName:     "kubenodemgr",
+		MyAgentName:   agentName,
+		TopicImpl:     types.KubeAppStatus{},
+		Activate:      false,
+		Ctx:           &ctx,
+		CreateHandler: handleKubeAppStatusCreate,
+		ModifyHandler: handleKubeAppStatusModify,
+		DeleteHandler: handleKubeAppStatusDelete,
+		WarningTime:   warningTime,
+		ErrorTime:     errorTime,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx.subKubeAppStatus = subKubeAppStatus
+	subKubeAppStatus.Activate()
+
 	subHostMemory, err := ps.NewSubscription(pubsub.SubscriptionOptions{
 		AgentName:   "domainmgr",
 		MyAgentName: agentName,
@@ -253,6 +307,25 @@ func Run(ps *pubsub.PubSub, loggerArg *logrus.Logger, logArg *base.LogObject) in
 	}
 	ctx.subHostMemory = subHostMemory
 
+	// Get NetworkInstanceStatus from zedrouter for admission control
+	subNetworkInstanceStatus, err := ps.NewSubscription(pubsub.SubscriptionOptions{
+		AgentName:     "zedrouter",
+		MyAgentName:   agentName,
+		TopicImpl:     types.NetworkInstanceStatus{},
+		Activate:      false,
+		Ctx:           &ctx,
+		CreateHandler: handleNetworkInstanceStatusCreate,
+		ModifyHandler: handleNetworkInstanceStatusModify,
+		DeleteHandler: handleNetworkInstanceStatusDelete,
+		WarningTime:   warningTime,
+		ErrorTime:     errorTime,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx.subNetworkInstanceStatus = subNetworkInstanceStatus
+	subNetworkInstanceStatus.Activate()
+
 	// subscribe to zedagent status events
 	subZedAgentStatus, err := ps.NewSubscription(pubsub.SubscriptionOptions{
 		AgentName:     "zedagent",
@@ -281,6 +354,12 @@ func Run(ps *pubsub.PubSub, loggerArg *logrus.Logger, logArg *base.LogObject) in
 		}
 		ps.StillRunning(agentName, warningTime, errorTime)
 	}
+	prestageTicker := time.NewTicker(prestageCheckInterval)
+	healthTicker := time.NewTicker(healthCheckInterval)
+	startDependencyTicker := time.NewTicker(startDependencyCheckInterval)
+	// The disk and memory of the device are not followed otherwise
+	resourceAllocationTicker := time.NewTicker(resourceAllocationInterval)
+
 	log.Functionf("Handling all inputs")
 	for {
 		select {
@@ -296,15 +375,45 @@ func Run(ps *pubsub.PubSub, loggerArg *logrus.Logger, logArg *base.LogObject) in
 		case change := <-subDomainStatus.MsgChan():
 			subDomainStatus.ProcessChange(change)
 
+		case change := <-subKubeAppStatus.MsgChan():
+			subKubeAppStatus.ProcessChange(change)
+
 		case change := <-subHostMemory.MsgChan():
 			subHostMemory.ProcessChange(change)
 
+		case change := <-subNetworkInstanceStatus.MsgChan():
+			subNetworkInstanceStatus.ProcessChange(change)
+
 		case change := <-subAppInstanceConfig.MsgChan():
 			subAppInstanceConfig.ProcessChange(change)
 
 		case change := <-subZedAgentStatus.MsgChan():
 			subZedAgentStatus.ProcessChange(change)
 
+		case <-prestageTicker.C:
+			start := time.Now()
+			checkPrestage(&ctx)
+			ps.CheckMaxTimeTopic(agentName, "checkPrestage", start,
+				warningTime, errorTime)
+
+		case <-healthTicker.C:
+			start := time.Now()
+			checkHealth(&ctx)
+			ps.CheckMaxTimeTopic(agentName, "checkHealth", start,
+				warningTime, errorTime)
+
+		case <-startDependencyTicker.C:
+			start := time.Now()
+			checkStartDependencies(&ctx)
+			ps.CheckMaxTimeTopic(agentName, "checkStartDependencies", start,
+				warningTime, errorTime)
+
+		case result := <-ctx.healthResults:
+			handleProbeResult(&ctx, result)
+
+		case <-resourceAllocationTicker.C:
+			ctx.resourceAllocationChanged = true
+
 		case <-stillRunning.C:
 		}
 		ps.StillRunning(agentName, warningTime, errorTime)
@@ -315,6 +424,13 @@ func Run(ps *pubsub.PubSub, loggerArg *logrus.Logger, logArg *base.LogObject) in
 				warningTime, errorTime)
 			ctx.checkFreedResources = false
 		}
+		if ctx.resourceAllocationChanged {
+			start := time.Now()
+			publishResourceAllocation(&ctx)
+			ps.CheckMaxTimeTopic(agentName, "publishResourceAllocation", start,
+				warningTime, errorTime)
+			ctx.resourceAllocationChanged = false
+		}
 	}
 }
 
@@ -323,24 +439,24 @@ func checkRetry(ctxPtr *zedmanagerContext) {
 	items := ctxPtr.pubAppInstanceStatus.GetAll()
 	for _, st := range items {
 		status := st.(types.AppInstanceStatus)
-		if !status.MissingMemory {
+		if !status.MissingResources {
 			continue
 		}
 		config := lookupAppInstanceConfig(ctxPtr, status.Key())
 		if config == nil {
-			log.Noticef("checkRetry: %s waiting for memory but no config",
+			log.Noticef("checkRetry: %s waiting for resources but no config",
 				status.Key())
 			continue
 		}
 		if !status.IsErrorSource(types.AppInstanceConfig{}) {
-			log.Noticef("checkRetry: %s waiting for memory but no error",
+			log.Noticef("checkRetry: %s waiting for resources but no error",
 				status.Key())
 			continue
 		}
 		status.ClearErrorWithSource()
-		status.MissingMemory = false
+		status.MissingResources = false
 
-		log.Noticef("checkRetry: %s waiting for memory", status.Key())
+		log.Noticef("checkRetry: %s waiting for resources", status.Key())
 		handleModify(ctxPtr, status.Key(), *config, *config)
 	}
 }
@@ -385,6 +501,14 @@ func publishAppInstanceStatus(ctx *zedmanagerContext,
 	key := status.Key()
 	log.Tracef("publishAppInstanceStatus(%s)", key)
 	pub := ctx.pubAppInstanceStatus
+	if st, _ := pub.Get(key); st == nil {
+		ctx.resourceAllocationChanged = ctx.resourceAllocationChanged ||
+			allocationChanged(nil, status)
+	} else {
+		old := st.(types.AppInstanceStatus)
+		ctx.resourceAllocationChanged = ctx.resourceAllocationChanged ||
+			allocationChanged(&old, status)
+	}
 	pub.Publish(key, *status)
 }
 
@@ -399,6 +523,9 @@ func unpublishAppInstanceStatus(ctx *zedmanagerContext,
 		log.Errorf("unpublishAppInstanceStatus(%s) not found", key)
 		return
 	}
+	old := st.(types.AppInstanceStatus)
+	ctx.resourceAllocationChanged = ctx.resourceAllocationChanged ||
+		allocationChanged(&old, nil)
 	pub.Unpublish(key)
 }
 
@@ -485,9 +612,17 @@ func handleCreate(ctxArg interface{}, key string,
 			true, "purgeCmdCounter")
 	}
 
+	// After a reboot there is no running domain to keep hence we use
+	// the next volumes right away if their activation is due
+	if config.NextActivationDue(time.Now()) {
+		log.Noticef("handleCreate(%v) for %s using next volumes",
+			config.UUIDandVersion, config.DisplayName)
+		status.NextActivated = true
+	}
+	effectiveConfig := effectiveAppInstanceConfig(config, &status)
 	status.VolumeRefStatusList = make([]types.VolumeRefStatus,
-		len(config.VolumeRefConfigList))
-	for i, vrc := range config.VolumeRefConfigList {
+		len(effectiveConfig.VolumeRefConfigList))
+	for i, vrc := range effectiveConfig.VolumeRefConfigList {
 		vrs := &status.VolumeRefStatusList[i]
 		vrs.VolumeID = vrc.VolumeID
 		vrs.GenerationCounter = vrc.GenerationCounter
@@ -518,6 +653,21 @@ func handleCreate(ctxArg interface{}, key string,
 		errStr := "Invalid Cpu count - 0\n"
 		allErrors += errStr
 	}
+	if config.FixedResources.CPUWeight > 10000 {
+		errStr := fmt.Sprintf("Invalid CPU weight - %d\n",
+			config.FixedResources.CPUWeight)
+		allErrors += errStr
+	}
+	if config.FixedResources.IOWeight > 10000 {
+		errStr := fmt.Sprintf("Invalid IO weight - %d\n",
+			config.FixedResources.IOWeight)
+		allErrors += errStr
+	}
+	if config.FixedResources.PidsLimit < 0 {
+		errStr := fmt.Sprintf("Invalid pids limit - %d\n",
+			config.FixedResources.PidsLimit)
+		allErrors += errStr
+	}
 
 	// if some error, return
 	if allErrors != "" {
@@ -550,6 +700,9 @@ func handleModify(ctxArg interface{}, key string,
 	log.Functionf("handleModify(%v) for %s",
 		config.UUIDandVersion, config.DisplayName)
 
+	// The adapters and interfaces of an admitted app instance are
+	// accounted from its config
+	ctx.resourceAllocationChanged = true
 	effectiveActivate := effectiveActivateCurrentProfile(config, ctx.currentProfile)
 	status.EffectiveActivate = effectiveActivate
 	publishAppInstanceStatus(ctx, status)
@@ -562,7 +715,19 @@ func handleModify(ctxArg interface{}, key string,
 	// purge of disk changes, so we can generate errors if it is
 	// not a PurgeCmd and RestartCmd, respectively
 	// If we are purging then restart is redundant.
-	needPurge, needRestart, purgeReason, restartReason := quantifyChanges(config, oldConfig, *status)
+	needPurge, needRestart, purgeReason, restartReason := quantifyChanges(
+		effectiveAppInstanceConfig(config, status),
+		effectiveAppInstanceConfig(oldConfig, status), *status)
+	// Added or removed volumes and network interfaces might be
+	// hot-plugged instead
+	if needPurge && config.PurgeCmd.Counter == oldConfig.PurgeCmd.Counter &&
+		hotplugPossible(ctx, effectiveAppInstanceConfig(config, status),
+			effectiveAppInstanceConfig(oldConfig, status), status) {
+		log.Noticef("handleModify(%s) applying to the running domain: %s",
+			status.Key(), purgeReason)
+		needPurge = false
+		status.HotplugInprogress = true
+	}
 	if needPurge {
 		needRestart = false
 	}
@@ -580,6 +745,14 @@ func handleModify(ctxArg interface{}, key string,
 			// the status counter here.
 			status.RestartInprogress = types.BringDown
 			status.State = types.RESTARTING
+			// Just reboot the guest OS if its agent can do it
+			ds := lookupDomainStatus(ctx, key)
+			if !needRestart && status.Activated && ds != nil &&
+				ds.Activated && ds.GuestInfo.AgentRunning {
+				log.Noticef("handleModify(%s) restart through the guest agent",
+					status.Key())
+				status.RestartInprogress = types.GuestReboot
+			}
 		} else {
 			log.Functionf("handleModify(%v) for %s restartcmd ignored config !Activate",
 				config.UUIDandVersion, config.DisplayName)
@@ -605,6 +778,7 @@ func handleModify(ctxArg interface{}, key string,
 			status.ClearErrorWithSource()
 		}
 		status.PurgeInprogress = types.RecreateVolumes
+		status.HotplugInprogress = false
 		status.State = types.PURGING
 		// We persist the PurgeCmd Counter when PurgeInprogress is done
 	} else if needPurge {
@@ -724,6 +898,27 @@ func quantifyChanges(config types.AppInstanceConfig, oldConfig types.AppInstance
 		needRestart = true
 		restartReason += str + "\n"
 	}
+	if !cmp.Equal(config.PodContainers, oldConfig.PodContainers) {
+		str := fmt.Sprintf("PodContainers changed: %v",
+			cmp.Diff(oldConfig.PodContainers, config.PodContainers))
+		log.Functionf(str)
+		needRestart = true
+		restartReason += str + "\n"
+	}
+	if !cmp.Equal(config.InitContainers, oldConfig.InitContainers) {
+		str := fmt.Sprintf("InitContainers changed: %v",
+			cmp.Diff(oldConfig.InitContainers, config.InitContainers))
+		log.Functionf(str)
+		needRestart = true
+		restartReason += str + "\n"
+	}
+	if !cmp.Equal(config.Security, oldConfig.Security) {
+		str := fmt.Sprintf("Security changed: %v",
+			cmp.Diff(oldConfig.Security, config.Security))
+		log.Functionf(str)
+		needRestart = true
+		restartReason += str + "\n"
+	}
 	log.Functionf("quantifyChanges for %s %s returns %v, %v",
 		config.Key(), config.DisplayName, needPurge, needRestart)
 	return needPurge, needRestart, purgeReason, restartReason
@@ -1363,8 +1363,11 @@ func handleAppNetworkModify(ctxArg interface{}, key string,
 		// Look for ACL changes in underlay
 		doAppNetworkModifyAllUnderlayNetworks(ctx, config, oldConfig, status, ipsets)
 
+		// Look for added or removed underlays
+		doAppNetworkModifyUnderlayCount(ctx, config, status, ipsets)
+
 		// Write out what we modified to AppNetworkStatus
-		// Note that lengths are the same as before
+		// Note that lengths are the same as the config now
 		for i := range config.UnderlayNetworkList {
 			status.UnderlayNetworkList[i].UnderlayNetworkConfig =
 				config.UnderlayNetworkList[i]
@@ -1398,11 +1401,11 @@ func doAppNetworkSanityCheckForModify(ctx *zedrouterContext,
 
 	// XXX what about changing the number of interfaces as
 	// part of an inactive/active transition?
-	// XXX We could should we allow the addition of interfaces
-	// if the domU would find out through some hotplug event.
-	// But deletion is hard.
-	// For now don't allow any adds or deletes.
-	if len(config.UnderlayNetworkList) != len(oldConfig.UnderlayNetworkList) {
+	// Interfaces can be added or removed at the end while the app
+	// instance is running when zedmanager asks for it, which it does
+	// when domainmgr can hot-plug them into the domain.
+	if len(config.UnderlayNetworkList) != len(oldConfig.UnderlayNetworkList) &&
+		!(config.Hotplug && config.Activate && status.Activated) {
 		err := fmt.Errorf("Unsupported: Changed number of underlays for %s",
 			config.UUIDandVersion)
 		log.Error(err.Error())
@@ -1457,6 +1460,10 @@ func doAppNetworkModifyAllUnderlayNetworks(
 	ipsets []string) {
 
 	for i := range config.UnderlayNetworkList {
+		if i >= len(oldConfig.UnderlayNetworkList) {
+			// Added; see doAppNetworkModifyUnderlayCount
+			break
+		}
 		log.Tracef("handleModify ulNum %d\n", i)
 		ulConfig := &config.UnderlayNetworkList[i]
 		oldulConfig := &oldConfig.UnderlayNetworkList[i]
@@ -1467,6 +1474,39 @@ func doAppNetworkModifyAllUnderlayNetworks(
 	publishAppNetworkStatus(ctx, status)
 }
 
+// doAppNetworkModifyUnderlayCount sets up the underlays added at the end
+// of the list and tears down the ones removed from the end
+func doAppNetworkModifyUnderlayCount(
+	ctx *zedrouterContext,
+	config types.AppNetworkConfig,
+	status *types.AppNetworkStatus,
+	ipsets []string) {
+
+	for len(status.UnderlayNetworkList) > len(config.UnderlayNetworkList) {
+		last := len(status.UnderlayNetworkList) - 1
+		ulStatus := &status.UnderlayNetworkList[last]
+		log.Functionf("handleModify removing ulNum %d: %v\n",
+			last+1, ulStatus)
+		appNetworkDoInactivateUnderlayNetwork(ctx, status, ulStatus,
+			ipsets)
+		status.UnderlayNetworkList = status.UnderlayNetworkList[:last]
+	}
+	for i := len(status.UnderlayNetworkList); i < len(config.UnderlayNetworkList); i++ {
+		ulConfig := config.UnderlayNetworkList[i]
+		log.Functionf("handleModify adding ulNum %d network %s\n",
+			i+1, ulConfig.Network.String())
+		status.UnderlayNetworkList = append(status.UnderlayNetworkList,
+			types.UnderlayNetworkStatus{UnderlayNetworkConfig: ulConfig})
+		err := appNetworkDoActivateUnderlayNetwork(ctx, config, status,
+			ipsets, &ulConfig, i+1)
+		if err != nil {
+			addError(ctx, status, "handleModify adding underlay",
+				fmt.Errorf("ulNum %d: %v", i+1, err))
+		}
+	}
+	publishAppNetworkStatus(ctx, status)
+}
+
 func doAppNetworkModifyUnderlayNetwork(
 	ctx *zedrouterContext,
 	status *types.AppNetworkStatus,
@@ -0,0 +1,143 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package containerd
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	consoleDir = "/run/tasks/consoles"
+	// a console client which doesn't keep up with the output of the task
+	// for that long is dropped rather than stalling the task
+	consoleWriteTimeout = 10 * time.Second
+	consoleBusyMessage  = "console is in use by another session\r\n"
+)
+
+// ConsoleSocket returns the unix socket serving the terminal of the task
+// of the container, if it was created with one
+func ConsoleSocket(containerID string) string {
+	return filepath.Join(consoleDir, containerID+".sock")
+}
+
+// consoleRelay is the other end of the terminal of a task. The output of
+// the task goes to its log and to the client connected to the socket, if
+// any; the input of that client goes to the task. Only one client is
+// attached at a time, as with the serial console of a VM.
+type consoleRelay struct {
+	socket   string
+	listener net.Listener
+	log      io.WriteCloser
+	stdin    *io.PipeReader
+	stdinW   *io.PipeWriter
+	sync.Mutex
+	client net.Conn
+	closed bool
+}
+
+func newConsoleRelay(socket string, log io.WriteCloser) (*consoleRelay, error) {
+	if err := os.MkdirAll(filepath.Dir(socket), 0700); err != nil {
+		return nil, err
+	}
+	// left over by a previous task of the container
+	_ = os.Remove(socket)
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+	r := &consoleRelay{
+		socket:   socket,
+		listener: listener,
+		log:      log,
+	}
+	r.stdin, r.stdinW = io.Pipe()
+	go r.serve()
+	return r, nil
+}
+
+func (r *consoleRelay) serve() {
+	for {
+		conn, err := r.listener.Accept()
+		if err != nil {
+			return
+		}
+		r.Lock()
+		busy := r.client != nil || r.closed
+		if !busy {
+			r.client = conn
+		}
+		r.Unlock()
+		if busy {
+			_, _ = conn.Write([]byte(consoleBusyMessage))
+			conn.Close()
+			continue
+		}
+		go r.input(conn)
+	}
+}
+
+// input feeds what the client types to the task until it goes away
+func (r *consoleRelay) input(conn net.Conn) {
+	if _, err := io.Copy(r.stdinW, conn); err != nil {
+		logrus.Debugf("consoleRelay %s: input: %v", r.socket, err)
+	}
+	r.detach(conn)
+}
+
+func (r *consoleRelay) detach(conn net.Conn) {
+	r.Lock()
+	if r.client == conn {
+		r.client = nil
+	}
+	r.Unlock()
+	conn.Close()
+}
+
+// Write takes the output of the task. It never fails since the task must
+// not stall on its log or on a console client.
+func (r *consoleRelay) Write(p []byte) (int, error) {
+	if _, err := r.log.Write(p); err != nil {
+		logrus.Debugf("consoleRelay %s: log: %v", r.socket, err)
+	}
+	r.Lock()
+	client := r.client
+	r.Unlock()
+	if client != nil {
+		_ = client.SetWriteDeadline(time.Now().Add(consoleWriteTimeout))
+		if _, err := client.Write(p); err != nil {
+			logrus.Warnf("consoleRelay %s: dropping client: %v", r.socket, err)
+			r.detach(client)
+		}
+	}
+	return len(p), nil
+}
+
+// Close stops serving the console once the task is gone
+func (r *consoleRelay) Close() error {
+	r.Lock()
+	if r.closed {
+		r.Unlock()
+		return nil
+	}
+	r.closed = true
+	client := r.client
+	r.client = nil
+	r.Unlock()
+
+	err := r.listener.Close()
+	_ = os.Remove(r.socket)
+	if client != nil {
+		client.Close()
+	}
+	r.stdinW.Close()
+	r.log.Close()
+	return err
+}
@@ -0,0 +1,128 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package containerd
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testLog struct {
+	sync.Mutex
+	bytes.Buffer
+	closed bool
+}
+
+func (l *testLog) Write(p []byte) (int, error) {
+	l.Lock()
+	defer l.Unlock()
+	return l.Buffer.Write(p)
+}
+
+func (l *testLog) Close() error {
+	l.Lock()
+	defer l.Unlock()
+	l.closed = true
+	return nil
+}
+
+func (l *testLog) String() string {
+	l.Lock()
+	defer l.Unlock()
+	return l.Buffer.String()
+}
+
+func readFull(t *testing.T, conn net.Conn, n int) string {
+	t.Helper()
+	buf := make([]byte, n)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("read from console: %v", err)
+	}
+	return string(buf)
+}
+
+func TestConsoleRelay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "console")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "task.sock")
+	log := &testLog{}
+	relay, err := newConsoleRelay(socket, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// without a client the output only goes to the log
+	relay.Write([]byte("boot\n"))
+
+	client, err := net.Dial("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// wait for the relay to pick up the client
+	for i := 0; ; i++ {
+		relay.Lock()
+		attached := relay.client != nil
+		relay.Unlock()
+		if attached {
+			break
+		}
+		if i == 100 {
+			t.Fatal("client not attached")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	relay.Write([]byte("login: "))
+	if got := readFull(t, client, 7); got != "login: " {
+		t.Errorf("client got %q", got)
+	}
+
+	// only one client at a time
+	second, err := net.Dial("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readFull(t, second, len(consoleBusyMessage)); got != consoleBusyMessage {
+		t.Errorf("second client got %q", got)
+	}
+	second.Close()
+
+	if _, err := client.Write([]byte("root\n")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(relay.stdin, buf); err != nil || string(buf) != "root\n" {
+		t.Errorf("task got %q, %v", buf, err)
+	}
+
+	if err := relay.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	if got := log.String(); got != "boot\nlogin: " {
+		t.Errorf("log is %q", got)
+	}
+	if !log.closed {
+		t.Error("log not closed")
+	}
+	if _, err := os.Stat(socket); !os.IsNotExist(err) {
+		t.Errorf("socket left behind: %v", err)
+	}
+	_ = client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := client.Read(buf); err != io.EOF {
+		t.Errorf("client not closed: %v", err)
+	}
+	if _, err := relay.stdin.Read(buf); err != io.EOF {
+		t.Errorf("task input not closed: %v", err)
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 
 	"google.golang.org/grpc/connectivity"
@@ -65,6 +66,8 @@ const (
 	eveOCIMountPointsLabel = "org.lfedge.eve.blk_mounts"
 	// EVEOCIVNCPasswordLabel is OCI runtime spec label that tracks VNC password in OCI Image config
 	EVEOCIVNCPasswordLabel = "org.lfedge.eve.vnc_password"
+	// EVEOCICPUsLabel is OCI runtime spec label that tracks the host CPUs the vCPUs are pinned to
+	EVEOCICPUsLabel = "org.lfedge.eve.cpus"
 
 	//TBD: Have a better way to calculate this number.
 	//For now it is based on some trial-and-error experiments
@@ -303,7 +306,7 @@ func (client *Client) CtrMountSnapshot(ctx context.Context, snapshotID, targetPa
 	if err := client.verifyCtr(ctx, true); err != nil {
 		return fmt.Errorf("CtrMountSnapshot: exception while verifying ctrd client: %s", err.Error())
 	}
-	snapshotter := client.ctrdClient.SnapshotService(defaultSnapshotter)
+	snapshotter := client.snapshotterOf(ctx, snapshotID)
 	mounts, err := snapshotter.Mounts(ctx, snapshotID)
 	if err != nil {
 		return fmt.Errorf("CtrMountSnapshot: Exception while fetching mounts of snapshot: %s. %s", snapshotID, err)
@@ -314,18 +317,25 @@ func (client *Client) CtrMountSnapshot(ctx context.Context, snapshotID, targetPa
 	return mounts[0].Mount(targetPath)
 }
 
-//CtrListSnapshotInfo returns a list of all snapshot's info present in containerd's snapshot store.
+//CtrListSnapshotInfo returns a list of all snapshot's info present in containerd's snapshot store,
+//including the ones of the lazily pulled images if the remote snapshotter is loaded.
 func (client *Client) CtrListSnapshotInfo(ctx context.Context) ([]snapshots.Info, error) {
 	if err := client.verifyCtr(ctx, true); err != nil {
 		return nil, fmt.Errorf("CtrListSnapshotInfo: exception while verifying ctrd client: %s", err.Error())
 	}
-	snapshotter := client.ctrdClient.SnapshotService(defaultSnapshotter)
+	snapshotters := []string{defaultSnapshotter}
+	if client.CtrLazySnapshotterAvailable(ctx) {
+		snapshotters = append(snapshotters, LazySnapshotter)
+	}
 	snapshotInfoList := make([]snapshots.Info, 0)
-	if err := snapshotter.Walk(ctx, func(i context.Context, info snapshots.Info) error {
-		snapshotInfoList = append(snapshotInfoList, info)
-		return nil
-	}); err != nil {
-		return nil, fmt.Errorf("CtrListSnapshotInfo: Execption while fetching snapshot list. %s", err.Error())
+	for _, name := range snapshotters {
+		snapshotter := client.ctrdClient.SnapshotService(name)
+		if err := snapshotter.Walk(ctx, func(i context.Context, info snapshots.Info) error {
+			snapshotInfoList = append(snapshotInfoList, info)
+			return nil
+		}); err != nil {
+			return nil, fmt.Errorf("CtrListSnapshotInfo: Execption while fetching snapshot list. %s", err.Error())
+		}
 	}
 	return snapshotInfoList, nil
 }
@@ -335,7 +345,7 @@ func (client *Client) CtrRemoveSnapshot(ctx context.Context, snapshotID string)
 	if err := client.verifyCtr(ctx, true); err != nil {
 		return fmt.Errorf("CtrRemoveSnapshot: exception while verifying ctrd client: %s", err.Error())
 	}
-	snapshotter := client.ctrdClient.SnapshotService(defaultSnapshotter)
+	snapshotter := client.snapshotterOf(ctx, snapshotID)
 	if err := snapshotter.Remove(ctx, snapshotID); err != nil {
 		logrus.Errorf("CtrRemoveSnapshot: unable to remove snapshot: %v. %v", snapshotID, err)
 		return err
@@ -442,6 +452,12 @@ func (client *Client) CtrContainerInfo(ctx context.Context, name string) (int, i
 
 // CtrCreateTask creates (but doesn't start) the default task in a pre-existing container and attaches its logging to memlogd
 func (client *Client) CtrCreateTask(ctx context.Context, domainName string) (int, error) {
+	return client.CtrCreateTaskWithLog(ctx, domainName, domainName)
+}
+
+// CtrCreateTaskWithLog is CtrCreateTask for a container whose output is
+// logged under logName rather than the name of the container
+func (client *Client) CtrCreateTaskWithLog(ctx context.Context, domainName string, logName string) (int, error) {
 	if err := client.verifyCtr(ctx, true); err != nil {
 		return 0, fmt.Errorf("CtrStartContainer: exception while verifying ctrd client: %s", err.Error())
 	}
@@ -452,9 +468,17 @@ func (client *Client) CtrCreateTask(ctx context.Context, domainName string) (int
 
 	logger := GetLog()
 
+	spec, err := ctr.Spec(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if spec.Process != nil && spec.Process.Terminal {
+		return client.ctrCreateTaskWithConsole(ctx, ctr, domainName, logger, "guest_vm-"+logName)
+	}
+
 	io := func(id string) (cio.IO, error) {
-		stdoutFile := logger.Path("guest_vm-" + domainName)
-		stderrFile := logger.Path("guest_vm_err-" + domainName)
+		stdoutFile := logger.Path("guest_vm-" + logName)
+		stderrFile := logger.Path("guest_vm_err-" + logName)
 		return &logio{
 			cio.Config{
 				Stdin:    "/dev/null",
@@ -472,6 +496,35 @@ func (client *Client) CtrCreateTask(ctx context.Context, domainName string) (int
 	return int(task.Pid()), nil
 }
 
+// ctrCreateTaskWithConsole creates the task of a container whose process
+// has a terminal. The terminal is relayed to ConsoleSocket and its output
+// is logged under logName.
+func (client *Client) ctrCreateTaskWithConsole(ctx context.Context, ctr containerd.Container,
+	domainName string, logger Log, logName string) (int, error) {
+
+	logFile, err := logger.Open(logName)
+	if err != nil {
+		return 0, err
+	}
+	relay, err := newConsoleRelay(ConsoleSocket(domainName), logFile)
+	if err != nil {
+		logFile.Close()
+		return 0, fmt.Errorf("console of %s: %v", domainName, err)
+	}
+	cioOpts := []cio.Opt{cio.WithStreams(relay.stdin, relay, nil), cio.WithTerminal, cio.WithFIFODir(fifoDir)}
+	task, err := ctr.NewTask(ctx, cio.NewCreator(cioOpts...))
+	if err != nil {
+		relay.Close()
+		return 0, err
+	}
+	go func() {
+		// the output of the task is drained once it exits
+		task.IO().Wait()
+		relay.Close()
+	}()
+	return int(task.Pid()), nil
+}
+
 // CtrListTaskIds returns a list of all known tasks
 func (client *Client) CtrListTaskIds(ctx context.Context) ([]string, error) {
 	if err := client.verifyCtr(ctx, true); err != nil {
@@ -704,6 +757,81 @@ func (client *Client) ctrExec(ctx context.Context, domainName string, args []str
 	return stdOut.String(), stdErr.String(), err
 }
 
+// CtrExecTTY starts the executable in a running container with a terminal
+// attached and returns that terminal. The process is killed when the
+// terminal is closed, and reading from it returns io.EOF once the process
+// exits. ctx has to stay valid for as long as the terminal is in use.
+func (client *Client) CtrExecTTY(ctx context.Context, domainName string, args []string) (io.ReadWriteCloser, error) {
+	if err := client.verifyCtr(ctx, true); err != nil {
+		return nil, fmt.Errorf("CtrExecTTY: exception while verifying ctrd client: %s", err.Error())
+	}
+	ctr, err := client.ctrdClient.LoadContainer(ctx, domainName)
+	if err != nil {
+		return nil, fmt.Errorf("CtrExecTTY: Exception while loading container: %v", err)
+	}
+	spec, err := ctr.Spec(ctx)
+	if err != nil {
+		return nil, err
+	}
+	task, err := ctr.Task(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	pspec := spec.Process
+	pspec.Terminal = true
+	pspec.Args = args
+
+	stdinR, stdinW := io.Pipe()
+	stdoutR, stdoutW := io.Pipe()
+	cioOpts := []cio.Opt{cio.WithStreams(stdinR, stdoutW, ioutil.Discard), cio.WithTerminal, cio.WithFIFODir(fifoDir)}
+	process, err := task.Exec(ctx, fmt.Sprintf("%.50s%.20d", domainName, rand.Int()), pspec, cio.NewCreator(cioOpts...))
+	if err != nil {
+		return nil, err
+	}
+	statusC, err := process.Wait(ctx)
+	if err != nil {
+		process.Delete(ctx)
+		return nil, err
+	}
+	if err := process.Start(ctx); err != nil {
+		process.Delete(ctx)
+		return nil, err
+	}
+	go func() {
+		status := <-statusC
+		code, _, _ := status.Result()
+		logrus.Debugf("CtrExecTTY %v in %s exited with %d", args, domainName, code)
+		stdinR.Close()
+		stdoutW.Close()
+		process.Delete(ctx)
+	}()
+	return &ctrTTY{process: process, ctx: ctx, stdin: stdinW, stdout: stdoutR}, nil
+}
+
+// ctrTTY is the terminal of a process started by CtrExecTTY
+type ctrTTY struct {
+	process containerd.Process
+	ctx     context.Context
+	stdin   *io.PipeWriter
+	stdout  *io.PipeReader
+}
+
+func (t *ctrTTY) Read(p []byte) (int, error) {
+	return t.stdout.Read(p)
+}
+
+func (t *ctrTTY) Write(p []byte) (int, error) {
+	return t.stdin.Write(p)
+}
+
+func (t *ctrTTY) Close() error {
+	t.stdin.Close()
+	err := t.process.Kill(t.ctx, syscall.SIGKILL)
+	t.stdout.Close()
+	return err
+}
+
 // prepareProcess sets up anything that needs to be done after the container process is created,
 // but before it runs (for example networking)
 func prepareProcess(pid int, VifList []types.VifInfo) error {
@@ -812,6 +940,17 @@ func newServiceCtxWithLease(ctrdClient *containerd.Client, namespace string) (co
 	}, nil
 }
 
+// BindNetNS makes the network namespace of the process pid available at
+// path, so that other containers can join it even if the process restarts
+func BindNetNS(path string, pid int) error {
+	return bindNS("net", path, pid)
+}
+
+// UnbindNetNS releases the network namespace bound at path by BindNetNS
+func UnbindNetNS(path string) error {
+	return unbindNS(path)
+}
+
 // SaveSnapshotID stores snapshotID under newRootpath to handle upgrade scenario
 func SaveSnapshotID(oldRootpath, newRootpath string) error {
 	snapshotID := filepath.Base(oldRootpath)
@@ -9,3 +9,23 @@ import "fmt"
 func bindNS(ns string, path string, pid int) error {
 	return fmt.Errorf("bindNS is not implemented on Mac OS X")
 }
+
+// unbind a namespace file bound by bindNS
+func unbindNS(path string) error {
+	return fmt.Errorf("unbindNS is not implemented on Mac OS X")
+}
+
+// blockDevice returns the major and minor number of the disk holding the file
+func blockDevice(path string) (int64, int64, error) {
+	return 0, 0, fmt.Errorf("blockDevice is not implemented on Mac OS X")
+}
+
+// readTaskFile reads up to limit bytes of a file of a process
+func readTaskFile(pid int, path string, limit int64) ([]byte, bool, error) {
+	return nil, false, fmt.Errorf("readTaskFile is not implemented on Mac OS X")
+}
+
+// writeTaskFile writes a file of a process
+func writeTaskFile(pid int, path string, data []byte) error {
+	return fmt.Errorf("writeTaskFile is not implemented on Mac OS X")
+}
@@ -6,6 +6,8 @@ package containerd
 import (
 	"fmt"
 	"golang.org/x/sys/unix"
+	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 )
@@ -32,3 +34,113 @@ func bindNS(ns string, path string, pid int) error {
 	}
 	return nil
 }
+
+// unbind a namespace file bound by bindNS
+func unbindNS(path string) error {
+	if err := unix.Unmount(path, unix.MNT_DETACH); err != nil && err != unix.EINVAL {
+		return fmt.Errorf("unbindNS: Failed to unmount %s: %v", path, err)
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("unbindNS: Failed to remove %s: %v", path, err)
+	}
+	return nil
+}
+
+// blockDevice returns the major and minor number of the disk holding the
+// file, or of the device itself for a block device. The IO of a partition
+// is throttled on its disk.
+func blockDevice(path string) (int64, int64, error) {
+	var st unix.Stat_t
+	if err := unix.Stat(path, &st); err != nil {
+		return 0, 0, fmt.Errorf("blockDevice: %s: %v", path, err)
+	}
+	dev := uint64(st.Dev)
+	if st.Mode&unix.S_IFMT == unix.S_IFBLK {
+		dev = uint64(st.Rdev)
+	}
+	major, minor := int64(unix.Major(dev)), int64(unix.Minor(dev))
+	sysDev, err := filepath.EvalSymlinks(fmt.Sprintf("/sys/dev/block/%d:%d", major, minor))
+	if err != nil {
+		// not a block device
+		return major, minor, nil
+	}
+	if _, err := os.Stat(filepath.Join(sysDev, "partition")); err != nil {
+		return major, minor, nil
+	}
+	b, err := ioutil.ReadFile(filepath.Join(filepath.Dir(sysDev), "dev"))
+	if err != nil {
+		return 0, 0, fmt.Errorf("blockDevice: disk of %s: %v", sysDev, err)
+	}
+	if _, err := fmt.Sscanf(string(b), "%d:%d", &major, &minor); err != nil {
+		return 0, 0, fmt.Errorf("blockDevice: disk of %s: %v", sysDev, err)
+	}
+	return major, minor, nil
+}
+
+// openTaskFile opens the file at path in the root filesystem of the process
+// pid, resolving the path and any symlinks in it as if that were the root
+func openTaskFile(pid int, path string, flags int, mode uint32) (*os.File, error) {
+	root, err := unix.Open(fmt.Sprintf("/proc/%d/root", pid), unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("root of process %d: %v", pid, err)
+	}
+	defer unix.Close(root)
+	how := unix.OpenHow{
+		Flags:   uint64(flags | unix.O_CLOEXEC),
+		Mode:    uint64(mode),
+		Resolve: unix.RESOLVE_IN_ROOT | unix.RESOLVE_NO_MAGICLINKS,
+	}
+	fd, err := unix.Openat2(root, path, &how)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %v", path, err)
+	}
+	return os.NewFile(uintptr(fd), path), nil
+}
+
+// readTaskFile reads up to limit bytes of the regular file at path in the
+// root filesystem of the process pid. Returns true if there is more.
+func readTaskFile(pid int, path string, limit int64) ([]byte, bool, error) {
+	f, err := openTaskFile(pid, path, unix.O_RDONLY|unix.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, false, fmt.Errorf("readTaskFile: %v", err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, false, fmt.Errorf("readTaskFile: %v", err)
+	}
+	if !fi.Mode().IsRegular() {
+		return nil, false, fmt.Errorf("readTaskFile: %s is not a regular file", path)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(f, limit+1))
+	if err != nil {
+		return nil, false, fmt.Errorf("readTaskFile: %s: %v", path, err)
+	}
+	if int64(len(data)) > limit {
+		return data[:limit], true, nil
+	}
+	return data, false, nil
+}
+
+// writeTaskFile creates or truncates the regular file at path in the root
+// filesystem of the process pid and writes data to it
+func writeTaskFile(pid int, path string, data []byte) error {
+	f, err := openTaskFile(pid, path, unix.O_WRONLY|unix.O_CREAT|unix.O_TRUNC|unix.O_NONBLOCK, 0644)
+	if err != nil {
+		return fmt.Errorf("writeTaskFile: %v", err)
+	}
+	fi, err := f.Stat()
+	if err == nil && !fi.Mode().IsRegular() {
+		err = fmt.Errorf("%s is not a regular file", path)
+	}
+	if err == nil {
+		_, err = f.Write(data)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("writeTaskFile: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package containerd
+
+// Running commands in, and copying files to and from, the containers of
+// user apps for troubleshooting, and running containers to completion.
+// Unlike ctrExec the processes have no terminal, their exit code is
+// returned and they are killed once their time is up. Files are accessed
+// through the root of the main task of the container, with the path
+// resolved as if that were the root of the host.
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/cio"
+	"github.com/sirupsen/logrus"
+)
+
+// execDrainTime is how long the output of a process is copied after it
+// exits, since it could have left children holding on to its stdout
+const execDrainTime = 5 * time.Second
+
+// CtrExecWithTimeout runs args in a running container without a terminal,
+// writing its output to stdout and stderr. The process is killed if it has
+// not exited within timeout. Returns the exit code of the process and
+// whether it was killed. Nothing is written to stdout and stderr once it
+// returns.
+func (client *Client) CtrExecWithTimeout(ctx context.Context, containerID string, args []string,
+	stdout io.Writer, stderr io.Writer, timeout time.Duration) (int, bool, error) {
+
+	if err := client.verifyCtr(ctx, true); err != nil {
+		return 0, false, fmt.Errorf("CtrExecWithTimeout: exception while verifying ctrd client: %s", err.Error())
+	}
+	ctr, err := client.ctrdClient.LoadContainer(ctx, containerID)
+	if err != nil {
+		return 0, false, fmt.Errorf("CtrExecWithTimeout: Exception while loading container: %v", err)
+	}
+	spec, err := ctr.Spec(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+	task, err := ctr.Task(ctx, nil)
+	if err != nil {
+		return 0, false, err
+	}
+
+	pspec := spec.Process
+	pspec.Terminal = false
+	pspec.Args = args
+
+	outW := &sealedWriter{w: stdout}
+	errW := &sealedWriter{w: stderr}
+	defer outW.seal()
+	defer errW.seal()
+	cioOpts := []cio.Opt{cio.WithStreams(nil, outW, errW), cio.WithFIFODir(fifoDir)}
+	process, err := task.Exec(ctx, fmt.Sprintf("%.50s%.20d", containerID, rand.Int()), pspec, cio.NewCreator(cioOpts...))
+	if err != nil {
+		return 0, false, err
+	}
+	defer process.Delete(ctx)
+
+	statusC, err := process.Wait(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+	if err := process.Start(ctx); err != nil {
+		return 0, false, err
+	}
+
+	status, timedOut := waitWithTimeout(ctx, process, statusC, timeout, nil)
+	code, _, err := status.Result()
+	return int(code), timedOut, err
+}
+
+// CtrRunTaskWithTimeout runs the main task of a container to completion,
+// writing its stdout and stderr to output, and deletes the task. The task is
+// killed if it has not exited within timeout, or once stop is closed.
+// Returns its exit code and whether it timed out. Nothing is written to
+// output once it returns.
+func (client *Client) CtrRunTaskWithTimeout(ctx context.Context, containerID string,
+	output io.Writer, timeout time.Duration, stop <-chan struct{}) (int, bool, error) {
+
+	if err := client.verifyCtr(ctx, true); err != nil {
+		return 0, false, fmt.Errorf("CtrRunTaskWithTimeout: exception while verifying ctrd client: %s", err.Error())
+	}
+	ctr, err := client.CtrLoadContainer(ctx, containerID)
+	if err != nil {
+		return 0, false, fmt.Errorf("CtrRunTaskWithTimeout: Exception while loading container: %v", err)
+	}
+
+	w := &sealedWriter{w: output}
+	defer w.seal()
+	cioOpts := []cio.Opt{cio.WithStreams(nil, w, w), cio.WithFIFODir(fifoDir)}
+	task, err := ctr.NewTask(ctx, cio.NewCreator(cioOpts...))
+	if err != nil {
+		return 0, false, err
+	}
+	defer task.Delete(ctx, containerd.WithProcessKill)
+
+	statusC, err := task.Wait(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+	if err := task.Start(ctx); err != nil {
+		return 0, false, err
+	}
+
+	status, timedOut := waitWithTimeout(ctx, task, statusC, timeout, stop)
+	code, _, err := status.Result()
+	return int(code), timedOut, err
+}
+
+// waitWithTimeout waits for the started process to exit, killing it once
+// timeout is up or stop, if not nil, is closed, and for its output to be
+// copied. Returns its exit status and whether it timed out.
+func waitWithTimeout(ctx context.Context, process containerd.Process,
+	statusC <-chan containerd.ExitStatus, timeout time.Duration,
+	stop <-chan struct{}) (containerd.ExitStatus, bool) {
+
+	timedOut := false
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	var status containerd.ExitStatus
+	kill := false
+	select {
+	case status = <-statusC:
+	case <-timer.C:
+		timedOut = true
+		kill = true
+	case <-stop:
+		kill = true
+	}
+	if kill {
+		if err := process.Kill(ctx, syscall.SIGKILL); err != nil {
+			logrus.Errorf("waitWithTimeout: unable to kill process %s: %v", process.ID(), err)
+		}
+		status = <-statusC
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		process.IO().Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-time.After(execDrainTime):
+		logrus.Warnf("waitWithTimeout: output of process %s still open after exit", process.ID())
+	}
+	return status, timedOut
+}
+
+// CtrReadFile reads up to limit bytes of the file at path in a running
+// container. Returns true if the file is longer than that.
+func (client *Client) CtrReadFile(ctx context.Context, containerID string, path string, limit int64) ([]byte, bool, error) {
+	pid, err := client.ctrTaskPid(ctx, containerID)
+	if err != nil {
+		return nil, false, fmt.Errorf("CtrReadFile: %v", err)
+	}
+	return readTaskFile(pid, path, limit)
+}
+
+// CtrWriteFile creates or replaces the file at path in a running container
+func (client *Client) CtrWriteFile(ctx context.Context, containerID string, path string, data []byte) error {
+	pid, err := client.ctrTaskPid(ctx, containerID)
+	if err != nil {
+		return fmt.Errorf("CtrWriteFile: %v", err)
+	}
+	return writeTaskFile(pid, path, data)
+}
+
+// ctrTaskPid returns the pid of the main task of a running container
+func (client *Client) ctrTaskPid(ctx context.Context, containerID string) (int, error) {
+	pid, _, status, err := client.CtrContainerInfo(ctx, containerID)
+	if err != nil {
+		return 0, err
+	}
+	if pid == 0 || status != string(containerd.Running) {
+		return 0, fmt.Errorf("container %s is %s", containerID, status)
+	}
+	return pid, nil
+}
+
+// sealedWriter passes writes on until it is sealed and drops them after
+type sealedWriter struct {
+	sync.Mutex
+	w      io.Writer
+	sealed bool
+}
+
+func (s *sealedWriter) Write(p []byte) (int, error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.sealed || s.w == nil {
+		return len(p), nil
+	}
+	return s.w.Write(p)
+}
+
+func (s *sealedWriter) seal() {
+	s.Lock()
+	s.sealed = true
+	s.Unlock()
+}
@@ -0,0 +1,233 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package containerd
+
+// Lazy pulling of images through a remote snapshotter. Only the manifest
+// and the config of such an image are in the content store; the snapshots
+// of its layers are made by the remote snapshotter, which mounts them from
+// the registry and fetches their chunks when they are first read.
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/platforms"
+	"github.com/containerd/containerd/snapshots"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/identity"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// LazySnapshotter is the remote snapshotter for lazily pulled images
+	LazySnapshotter = "stargz"
+	// snapshotterPluginType is the containerd plugin type of the snapshotters
+	snapshotterPluginType = "io.containerd.snapshotter.v1"
+
+	// labels the remote snapshotter finds the layers in the registry by
+	targetSnapshotLabel   = "containerd.io/snapshot.ref"
+	targetImageRefLabel   = "containerd.io/snapshot/cri.image-ref"
+	targetManifestLabel   = "containerd.io/snapshot/cri.manifest-digest"
+	targetLayerLabel      = "containerd.io/snapshot/cri.layer-digest"
+	targetImageLayerLabel = "containerd.io/snapshot/cri.image-layers"
+	// maxImageLayersLabel bounds the length of the image-layers label,
+	// which containerd limits to 4096 bytes
+	maxImageLayersLabel = 4000
+)
+
+// CtrLazySnapshotterAvailable reports whether containerd has loaded the
+// remote snapshotter
+func (client *Client) CtrLazySnapshotterAvailable(ctx context.Context) bool {
+	if err := client.verifyCtr(ctx, true); err != nil {
+		logrus.Errorf("CtrLazySnapshotterAvailable: exception while verifying ctrd client: %s", err.Error())
+		return false
+	}
+	filter := fmt.Sprintf("type==%s,id==%s", snapshotterPluginType, LazySnapshotter)
+	resp, err := client.ctrdClient.IntrospectionService().Plugins(ctx, []string{filter})
+	if err != nil {
+		logrus.Errorf("CtrLazySnapshotterAvailable: %v", err)
+		return false
+	}
+	for _, p := range resp.Plugins {
+		if p.InitErr == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// CtrPrepareLazySnapshot creates a snapshot for the image in the remote
+// snapshotter. imageRef is the reference of the image in the registry, which
+// the remote snapshotter uses to fetch the layers. It fails if the remote
+// snapshotter can not mount any of the layers, leaving a full pull to the
+// caller.
+func (client *Client) CtrPrepareLazySnapshot(ctx context.Context, snapshotID string, image containerd.Image, imageRef string) error {
+	if err := client.verifyCtr(ctx, true); err != nil {
+		return fmt.Errorf("CtrPrepareLazySnapshot: exception while verifying ctrd client: %s", err.Error())
+	}
+	manifest, err := images.Manifest(ctx, client.contentStore, image.Target(), platforms.Default())
+	if err != nil {
+		return fmt.Errorf("CtrPrepareLazySnapshot: could not load manifest of image %s: %v", image.Name(), err)
+	}
+	diffIDs, err := image.RootFS(ctx)
+	if err != nil {
+		return fmt.Errorf("CtrPrepareLazySnapshot: could not load rootfs of image %s: %v", image.Name(), err)
+	}
+	if len(diffIDs) != len(manifest.Layers) {
+		return fmt.Errorf("CtrPrepareLazySnapshot: image %s has %d layers and %d diff ids",
+			image.Name(), len(manifest.Layers), len(diffIDs))
+	}
+	manifestDigest := image.Target().Digest
+	if image.Target().MediaType == images.MediaTypeDockerSchema2ManifestList ||
+		image.Target().MediaType == ocispec.MediaTypeImageIndex {
+		// the remote snapshotter wants the platform manifest
+		desc, err := platformManifest(ctx, client, image)
+		if err != nil {
+			return fmt.Errorf("CtrPrepareLazySnapshot: %v", err)
+		}
+		manifestDigest = desc.Digest
+	}
+
+	snapshotter := client.ctrdClient.SnapshotService(LazySnapshotter)
+	for i, layer := range manifest.Layers {
+		chainID := identity.ChainID(diffIDs[:i+1]).String()
+		if _, err := snapshotter.Stat(ctx, chainID); err == nil {
+			continue
+		}
+		parent := ""
+		if i > 0 {
+			parent = identity.ChainID(diffIDs[:i]).String()
+		}
+		labels := remoteLayerLabels(imageRef, manifestDigest, manifest.Layers, i, chainID)
+		key := fmt.Sprintf(snapshots.UnpackKeyFormat, fmt.Sprint(time.Now().UnixNano()), chainID)
+		_, err := snapshotter.Prepare(ctx, key, parent, snapshots.WithLabels(labels))
+		if err == nil {
+			// the snapshotter made an empty snapshot to unpack into
+			// instead of mounting the layer from the registry
+			if err := snapshotter.Remove(ctx, key); err != nil {
+				logrus.Errorf("CtrPrepareLazySnapshot: unable to remove snapshot %s: %v", key, err)
+			}
+			return fmt.Errorf("CtrPrepareLazySnapshot: layer %s of image %s can not be pulled lazily",
+				layer.Digest, image.Name())
+		}
+		if !errdefs.IsAlreadyExists(err) {
+			return fmt.Errorf("CtrPrepareLazySnapshot: could not prepare layer %s of image %s: %v",
+				layer.Digest, image.Name(), err)
+		}
+		if _, err := snapshotter.Stat(ctx, chainID); err != nil {
+			return fmt.Errorf("CtrPrepareLazySnapshot: layer %s of image %s is missing: %v",
+				layer.Digest, image.Name(), err)
+		}
+	}
+
+	parent := identity.ChainID(diffIDs).String()
+	labels := map[string]string{"containerd.io/gc.root": time.Now().UTC().Format(time.RFC3339)}
+	if _, err := snapshotter.Prepare(ctx, snapshotID, parent, snapshots.WithLabels(labels)); err != nil {
+		return fmt.Errorf("CtrPrepareLazySnapshot: Exception while creating snapshot: %s. %v", snapshotID, err)
+	}
+	return nil
+}
+
+// platformManifest returns the descriptor of the manifest of the image for
+// this platform
+func platformManifest(ctx context.Context, client *Client, image containerd.Image) (ocispec.Descriptor, error) {
+	var found []ocispec.Descriptor
+	handler := images.HandlerFunc(func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		if images.IsManifestType(desc.MediaType) {
+			found = append(found, desc)
+			return nil, nil
+		}
+		return images.Children(ctx, client.contentStore, desc)
+	})
+	handler = images.FilterPlatforms(handler, platforms.Default())
+	if err := images.Walk(ctx, images.LimitManifests(handler, platforms.Default(), 1), image.Target()); err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	if len(found) == 0 {
+		return ocispec.Descriptor{}, fmt.Errorf("no manifest of image %s for %s",
+			image.Name(), platforms.DefaultString())
+	}
+	return found[0], nil
+}
+
+// remoteLayerLabels returns the labels telling the remote snapshotter where
+// to find the layer with the index i, and which layers follow it so that it
+// can prefetch them
+func remoteLayerLabels(imageRef string, manifest digest.Digest, layers []ocispec.Descriptor, i int, chainID string) map[string]string {
+	var following []string
+	length := 0
+	for _, l := range layers[i:] {
+		d := l.Digest.String()
+		if length+len(d) > maxImageLayersLabel {
+			break
+		}
+		following = append(following, d)
+		length += len(d) + 1
+	}
+	return map[string]string{
+		targetSnapshotLabel:   chainID,
+		targetImageRefLabel:   imageRef,
+		targetManifestLabel:   manifest.String(),
+		targetLayerLabel:      layers[i].Digest.String(),
+		targetImageLayerLabel: strings.Join(following, ","),
+	}
+}
+
+// CtrImageLocalSize returns how many bytes of the layers of the image are on
+// the device, and the size of all of them. A layer is on the device if its
+// blob is in the content store; otherwise the usage the remote snapshotter
+// reports for its snapshot is counted.
+func (client *Client) CtrImageLocalSize(ctx context.Context, image containerd.Image) (int64, int64, error) {
+	if err := client.verifyCtr(ctx, true); err != nil {
+		return 0, 0, fmt.Errorf("CtrImageLocalSize: exception while verifying ctrd client: %s", err.Error())
+	}
+	manifest, err := images.Manifest(ctx, client.contentStore, image.Target(), platforms.Default())
+	if err != nil {
+		return 0, 0, fmt.Errorf("CtrImageLocalSize: could not load manifest of image %s: %v", image.Name(), err)
+	}
+	diffIDs, err := image.RootFS(ctx)
+	if err != nil {
+		return 0, 0, fmt.Errorf("CtrImageLocalSize: could not load rootfs of image %s: %v", image.Name(), err)
+	}
+	snapshotter := client.ctrdClient.SnapshotService(LazySnapshotter)
+	var local, total int64
+	for i, layer := range manifest.Layers {
+		total += layer.Size
+		if _, err := client.contentStore.Info(ctx, layer.Digest); err == nil {
+			local += layer.Size
+			continue
+		}
+		if i >= len(diffIDs) {
+			continue
+		}
+		usage, err := snapshotter.Usage(ctx, identity.ChainID(diffIDs[:i+1]).String())
+		if err != nil {
+			continue
+		}
+		if usage.Size > layer.Size {
+			usage.Size = layer.Size
+		}
+		local += usage.Size
+	}
+	return local, total, nil
+}
+
+// snapshotterOf returns the snapshotter holding the snapshot
+func (client *Client) snapshotterOf(ctx context.Context, snapshotID string) snapshots.Snapshotter {
+	snapshotter := client.ctrdClient.SnapshotService(defaultSnapshotter)
+	if _, err := snapshotter.Stat(ctx, snapshotID); err == nil {
+		return snapshotter
+	}
+	lazy := client.ctrdClient.SnapshotService(LazySnapshotter)
+	if _, err := lazy.Stat(ctx, snapshotID); err == nil {
+		return lazy
+	}
+	return snapshotter
+}
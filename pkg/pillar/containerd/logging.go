@@ -94,6 +94,9 @@ type remoteLog struct {
 // Path returns the name of a FIFO connected to the logging daemon.
 func (r *remoteLog) Path(n string) string {
 	path := filepath.Join(r.fifoDir, n+".log")
+	// a FIFO left over from a previous task of the same name has already
+	// been handed over to the logging daemon, so we need a fresh one
+	_ = os.Remove(path)
 	if err := syscall.Mkfifo(path, 0600); err != nil {
 		return "/dev/null"
 	}
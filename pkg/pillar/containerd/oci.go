@@ -57,7 +57,11 @@ type OCISpec interface {
 	UpdateFromDomain(*types.DomainConfig)
 	UpdateFromVolume(string) error
 	UpdateMounts([]types.DiskStatus) error
+	UpdateBlockIO(*types.DomainConfig, []types.DiskStatus) error
 	UpdateEnvVar(map[string]string)
+	JoinNetNS(string)
+	UpdateSecurity(*types.DomainConfig) error
+	ShiftOwnership(*types.DomainConfig, []types.DiskStatus) error
 }
 
 // NewOciSpec returns a default oci spec from the containerd point of view
@@ -253,7 +257,11 @@ func (s *ociSpec) CreateContainer(removeExisting bool) error {
 func (s *ociSpec) AdjustMemLimit(dom types.DomainConfig, addMemory int64) {
 	// update cgroup resource constraints for CPU and memory
 	if s.Linux != nil {
-		m := int64(dom.Memory*1024) + addMemory
+		mem := dom.Memory
+		if dom.Ballooning() {
+			mem = dom.MaxMem
+		}
+		m := int64(mem*1024) + addMemory
 		s.Linux.Resources.Memory.Limit = &m
 	}
 }
@@ -297,11 +305,12 @@ func (s *ociSpec) UpdateFromDomain(dom *types.DomainConfig) {
 		}
 
 		m := int64(dom.Memory * 1024)
-		p := uint64(100000)
-		q := int64(100000 * dom.VCpus)
+		p := uint64(cpuPeriod)
+		q := int64(cpuPeriod * dom.VCpus)
 		s.Linux.Resources.Memory.Limit = &m
 		s.Linux.Resources.CPU.Period = &p
 		s.Linux.Resources.CPU.Quota = &q
+		s.updateResources(dom)
 
 		s.Linux.CgroupsPath = fmt.Sprintf("/%s/%s", ctrdServicesNamespace, dom.GetTaskName())
 	}
@@ -441,3 +450,21 @@ func (s *ociSpec) UpdateEnvVar(envVars map[string]string) {
 		s.Process.Env = append(s.Process.Env, fmt.Sprintf("%s=%s", k, v))
 	}
 }
+
+// JoinNetNS makes the container join the network namespace at path
+// instead of creating its own
+func (s *ociSpec) JoinNetNS(path string) {
+	if s.Linux == nil {
+		s.Linux = &specs.Linux{}
+	}
+	for i, ns := range s.Linux.Namespaces {
+		if ns.Type == specs.NetworkNamespace {
+			s.Linux.Namespaces[i].Path = path
+			return
+		}
+	}
+	s.Linux.Namespaces = append(s.Linux.Namespaces, specs.LinuxNamespace{
+		Type: specs.NetworkNamespace,
+		Path: path,
+	})
+}
@@ -12,6 +12,8 @@ import (
 	"path"
 	"path/filepath"
 	"reflect"
+	"strings"
+	"syscall"
 	"testing"
 
 	zconfig "github.com/lf-edge/eve/api/go/config"
@@ -825,3 +827,220 @@ func deepCopy(in interface{}) interface{} {
 	val = val.Elem()
 	return val.Interface()
 }
+
+func TestUpdateSecurity(t *testing.T) {
+	g := NewGomegaWithT(t)
+	newSpec := func() *ociSpec {
+		defaultCaps := []string{"CAP_CHOWN", "CAP_NET_RAW", "CAP_KILL"}
+		return &ociSpec{
+			name: "test",
+			Spec: specs.Spec{
+				Root: &specs.Root{Path: "/rootfs"},
+				Process: &specs.Process{
+					Capabilities: &specs.LinuxCapabilities{
+						Bounding:  defaultCaps,
+						Effective: defaultCaps,
+						Permitted: defaultCaps,
+					},
+				},
+				Linux:       &specs.Linux{},
+				Annotations: map[string]string{},
+			},
+		}
+	}
+
+	// unconfined without adjustments leaves the spec alone
+	spec := newSpec()
+	g.Expect(spec.UpdateSecurity(&types.DomainConfig{})).ToNot(HaveOccurred())
+	g.Expect(spec.Spec).To(Equal(newSpec().Spec))
+
+	spec = newSpec()
+	g.Expect(spec.UpdateSecurity(&types.DomainConfig{Security: types.ContainerSecurity{
+		Profile: types.SecurityProfileDefault,
+		CapAdd:  []string{"sys_time"},
+		CapDrop: []string{"NET_RAW"},
+	}})).ToNot(HaveOccurred())
+	g.Expect(spec.Process.Capabilities.Bounding).To(Equal([]string{"CAP_CHOWN", "CAP_KILL", "CAP_SYS_TIME"}))
+	g.Expect(spec.Process.NoNewPrivileges).To(BeTrue())
+	g.Expect(spec.Root.Readonly).To(BeFalse())
+	g.Expect(spec.Linux.Seccomp).ToNot(BeNil())
+	for _, rule := range spec.Linux.Seccomp.Syscalls {
+		// CAP_SYS_TIME was added hence settimeofday is allowed
+		g.Expect(rule.Names).ToNot(ContainElement("settimeofday"))
+	}
+
+	spec = newSpec()
+	g.Expect(spec.UpdateSecurity(&types.DomainConfig{AppNum: 2, Security: types.ContainerSecurity{
+		Profile: types.SecurityProfileStrict,
+		CapAdd:  []string{"NET_BIND_SERVICE"},
+	}})).ToNot(HaveOccurred())
+	g.Expect(spec.Process.Capabilities.Bounding).To(Equal([]string{"CAP_NET_BIND_SERVICE"}))
+	g.Expect(spec.Root.Readonly).To(BeTrue())
+	g.Expect(spec.Linux.Namespaces).To(ContainElement(specs.LinuxNamespace{Type: specs.UserNamespace}))
+	g.Expect(spec.Linux.UIDMappings).To(Equal([]specs.LinuxIDMapping{
+		{ContainerID: 0, HostID: userNamespaceBase + 2*userNamespaceSize, Size: userNamespaceSize},
+	}))
+
+	spec = newSpec()
+	g.Expect(spec.UpdateSecurity(&types.DomainConfig{Security: types.ContainerSecurity{
+		CapDrop: []string{"ALL"},
+	}})).ToNot(HaveOccurred())
+	g.Expect(spec.Process.Capabilities.Bounding).To(BeEmpty())
+	g.Expect(spec.Linux.Seccomp).To(BeNil())
+
+	g.Expect(newSpec().UpdateSecurity(&types.DomainConfig{Security: types.ContainerSecurity{
+		CapAdd: []string{"CAP_FLY"},
+	}})).To(HaveOccurred())
+	g.Expect(newSpec().UpdateSecurity(&types.DomainConfig{Security: types.ContainerSecurity{
+		Profile: 42,
+	}})).To(HaveOccurred())
+
+	// AppArmor is not enabled in the kernel
+	spec = newSpec()
+	spec.Process.ApparmorProfile = "docker-default"
+	g.Expect(spec.UpdateSecurity(&types.DomainConfig{Security: types.ContainerSecurity{
+		Profile: types.SecurityProfileDefault,
+	}})).To(HaveOccurred())
+
+	// a spec which does not enforce the profile is rejected
+	spec = newSpec()
+	g.Expect(validateSecurity(&spec.Spec, types.SecurityProfileDefault)).To(HaveOccurred())
+}
+
+func TestUpdateResources(t *testing.T) {
+	g := NewGomegaWithT(t)
+	newSpec := func() *ociSpec {
+		return &ociSpec{
+			name: "test",
+			Spec: specs.Spec{
+				Linux:       &specs.Linux{},
+				Annotations: map[string]string{},
+			},
+		}
+	}
+
+	// no controls but memory and VCpus by default
+	spec := newSpec()
+	spec.UpdateFromDomain(&types.DomainConfig{VmConfig: types.VmConfig{Memory: 1024, VCpus: 2}})
+	g.Expect(*spec.Linux.Resources.CPU.Quota).To(Equal(int64(200000)))
+	g.Expect(spec.Linux.Resources.CPU.Shares).To(BeNil())
+	g.Expect(spec.Linux.Resources.Pids).To(BeNil())
+	g.Expect(spec.Linux.Resources.BlockIO).To(BeNil())
+
+	spec = newSpec()
+	dom := &types.DomainConfig{VmConfig: types.VmConfig{
+		Memory:    1024,
+		VCpus:     2,
+		CPUQuota:  50,
+		CPUWeight: 100,
+		IOWeight:  10000,
+		PidsLimit: 128,
+		ReadBps:   1 << 20,
+		WriteIOPS: 100,
+	}}
+	spec.UpdateFromDomain(dom)
+	g.Expect(*spec.Linux.Resources.CPU.Quota).To(Equal(int64(50000)))
+	g.Expect(spec.Linux.Resources.Pids).To(Equal(&specs.LinuxPids{Limit: 128}))
+	g.Expect(*spec.Linux.Resources.BlockIO.Weight).To(Equal(uint16(1000)))
+
+	bio := spec.Linux.Resources.BlockIO
+	addBlockIOLimits(bio, dom, 8, 0)
+	g.Expect(bio.ThrottleReadBpsDevice).To(HaveLen(1))
+	g.Expect(bio.ThrottleReadBpsDevice[0].Major).To(Equal(int64(8)))
+	g.Expect(bio.ThrottleReadBpsDevice[0].Rate).To(Equal(uint64(1 << 20)))
+	g.Expect(bio.ThrottleWriteIOPSDevice).To(HaveLen(1))
+	g.Expect(bio.ThrottleWriteIOPSDevice[0].Rate).To(Equal(uint64(100)))
+	g.Expect(bio.ThrottleWriteBpsDevice).To(BeEmpty())
+	g.Expect(bio.ThrottleReadIOPSDevice).To(BeEmpty())
+
+	// runc converts shares back with 1 + ((shares - 2) * 9999) / 262142
+	for _, weight := range []uint16{1, 50, 100, 1000, 9999, 10000} {
+		shares := cpuWeightToShares(weight)
+		g.Expect(1 + ((shares-2)*9999)/262142).To(Equal(uint64(weight)))
+	}
+	g.Expect(ioWeightToBlkio(1)).To(Equal(uint16(10)))
+	g.Expect(ioWeightToBlkio(10000)).To(Equal(uint16(1000)))
+}
+
+func TestCgroupThrottling(t *testing.T) {
+	g := NewGomegaWithT(t)
+	v2CPUStat := "usage_usec 1000\nuser_usec 600\nsystem_usec 400\nnr_periods 50\nnr_throttled 7\nthrottled_usec 3500\n"
+	v1CPUStat := "nr_periods 50\nnr_throttled 7\nthrottled_time 3500000\n"
+	pidsEvents := "max 3\n"
+
+	cpuStat, err := parseFlatKeyed(strings.NewReader(v2CPUStat))
+	g.Expect(err).ToNot(HaveOccurred())
+	events, err := parseFlatKeyed(strings.NewReader(pidsEvents))
+	g.Expect(err).ToNot(HaveOccurred())
+	expected := types.CgroupThrottling{
+		CPUThrottledPeriods: 7,
+		CPUThrottledTime:    3500,
+		PidsLimitHits:       3,
+	}
+	g.Expect(cgroupThrottling(true, cpuStat, events)).To(Equal(expected))
+
+	cpuStat, err = parseFlatKeyed(strings.NewReader(v1CPUStat))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(cgroupThrottling(false, cpuStat, events)).To(Equal(expected))
+
+	// no pids controller
+	expected.PidsLimitHits = 0
+	g.Expect(cgroupThrottling(false, cpuStat, nil)).To(Equal(expected))
+
+	_, err = parseFlatKeyed(strings.NewReader("nr_throttled x\n"))
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestShiftOwnership(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("chown needs root")
+	}
+	g := NewGomegaWithT(t)
+	dir, err := ioutil.TempDir("", "shift")
+	g.Expect(err).ToNot(HaveOccurred())
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "rootfs")
+	g.Expect(os.MkdirAll(filepath.Join(root, "home"), 0755)).To(Succeed())
+	g.Expect(ioutil.WriteFile(filepath.Join(root, "su"), nil, 0755)).To(Succeed())
+	g.Expect(os.Chmod(filepath.Join(root, "su"), 0755|os.ModeSetuid)).To(Succeed())
+	g.Expect(os.Lchown(filepath.Join(root, "home"), 1000, 1000)).To(Succeed())
+	g.Expect(os.Symlink("su", filepath.Join(root, "link"))).To(Succeed())
+	// outside of the range of the container
+	g.Expect(ioutil.WriteFile(filepath.Join(root, "far"), nil, 0644)).To(Succeed())
+	g.Expect(os.Lchown(filepath.Join(root, "far"), 70000, 70000)).To(Succeed())
+
+	owners := func() map[string]uint32 {
+		ret := make(map[string]uint32)
+		for _, name := range []string{"", "home", "su", "link", "far"} {
+			info, err := os.Lstat(filepath.Join(root, name))
+			g.Expect(err).ToNot(HaveOccurred())
+			st := info.Sys().(*syscall.Stat_t)
+			g.Expect(st.Gid).To(Equal(st.Uid))
+			ret[name] = st.Uid
+		}
+		return ret
+	}
+	spec := &ociSpec{Spec: specs.Spec{Root: &specs.Root{Path: root}}}
+	strict := &types.DomainConfig{AppNum: 1, Security: types.ContainerSecurity{
+		Profile: types.SecurityProfileStrict,
+	}}
+	hostID := userNamespaceHostID(1)
+	g.Expect(spec.ShiftOwnership(strict, nil)).To(Succeed())
+	g.Expect(owners()).To(Equal(map[string]uint32{
+		"": hostID, "home": hostID + 1000, "su": hostID, "link": hostID, "far": 70000,
+	}))
+	info, err := os.Stat(filepath.Join(root, "su"))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(info.Mode() & os.ModeSetuid).ToNot(BeZero())
+
+	// nothing to do the second time
+	g.Expect(os.Lchown(filepath.Join(root, "far"), 0, 0)).To(Succeed())
+	g.Expect(spec.ShiftOwnership(strict, nil)).To(Succeed())
+	g.Expect(owners()["far"]).To(BeZero())
+	g.Expect(os.Lchown(filepath.Join(root, "far"), 70000, 70000)).To(Succeed())
+
+	g.Expect(spec.ShiftOwnership(&types.DomainConfig{AppNum: 1}, nil)).To(Succeed())
+	g.Expect(owners()).To(Equal(map[string]uint32{
+		"": 0, "home": 1000, "su": 0, "link": 0, "far": 70000,
+	}))
+}
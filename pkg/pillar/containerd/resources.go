@@ -0,0 +1,178 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package containerd
+
+// cgroup controls of the tasks besides memory. The weights are given on the
+// cgroup v2 scale and converted to the v1 one the OCI spec uses, which runc
+// converts back when the host runs cgroup v2; the throttling counters are
+// read from whichever hierarchy the host has.
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/lf-edge/eve/pkg/pillar/types"
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+const (
+	cgroupRoot = "/sys/fs/cgroup"
+	// cpuPeriod is the CFS period of the tasks in microseconds
+	cpuPeriod = 100000
+)
+
+// cpuWeightToShares converts a cgroup v2 cpu.weight into cpu.shares,
+// rounding up so that runc converts it back to the same weight
+func cpuWeightToShares(weight uint16) uint64 {
+	return 2 + (uint64(weight-1)*262142+9998)/9999
+}
+
+// ioWeightToBlkio converts a cgroup v2 io.weight into blkio.weight, which
+// has a tenth of its resolution
+func ioWeightToBlkio(weight uint16) uint16 {
+	return uint16(10 + (uint64(weight-1)*990+9998)/9999)
+}
+
+// updateResources sets the CPU, pids and IO weight controls of the domain
+func (s *ociSpec) updateResources(dom *types.DomainConfig) {
+	res := s.Linux.Resources
+	if dom.CPUQuota != 0 {
+		q := int64(dom.CPUQuota) * cpuPeriod / 100
+		res.CPU.Quota = &q
+	}
+	if dom.CPUWeight != 0 {
+		shares := cpuWeightToShares(dom.CPUWeight)
+		res.CPU.Shares = &shares
+	}
+	if dom.PidsLimit != 0 {
+		res.Pids = &specs.LinuxPids{Limit: dom.PidsLimit}
+	}
+	if dom.IOWeight != 0 {
+		if res.BlockIO == nil {
+			res.BlockIO = &specs.LinuxBlockIO{}
+		}
+		weight := ioWeightToBlkio(dom.IOWeight)
+		res.BlockIO.Weight = &weight
+	}
+}
+
+// UpdateBlockIO limits the bandwidth and IOPS of the domain on each of the
+// disks holding its volumes. Volumes which are not on a block device, like
+// the files in a ZFS dataset, can not be limited.
+func (s *ociSpec) UpdateBlockIO(dom *types.DomainConfig, disks []types.DiskStatus) error {
+	if s.Linux == nil || s.Linux.Resources == nil ||
+		dom.ReadBps == 0 && dom.WriteBps == 0 && dom.ReadIOPS == 0 && dom.WriteIOPS == 0 {
+		return nil
+	}
+	if s.Linux.Resources.BlockIO == nil {
+		s.Linux.Resources.BlockIO = &specs.LinuxBlockIO{}
+	}
+	seen := make(map[[2]int64]bool)
+	for _, disk := range disks {
+		if disk.FileLocation == "" {
+			continue
+		}
+		major, minor, err := blockDevice(disk.FileLocation)
+		if err != nil {
+			return fmt.Errorf("UpdateBlockIO: %v", err)
+		}
+		if major == 0 || seen[[2]int64{major, minor}] {
+			continue
+		}
+		seen[[2]int64{major, minor}] = true
+		addBlockIOLimits(s.Linux.Resources.BlockIO, dom, major, minor)
+	}
+	return nil
+}
+
+// addBlockIOLimits adds the throttling of the device to bio
+func addBlockIOLimits(bio *specs.LinuxBlockIO, dom *types.DomainConfig, major, minor int64) {
+	device := func(rate uint64) specs.LinuxThrottleDevice {
+		dev := specs.LinuxThrottleDevice{Rate: rate}
+		dev.Major = major
+		dev.Minor = minor
+		return dev
+	}
+	if dom.ReadBps != 0 {
+		bio.ThrottleReadBpsDevice = append(bio.ThrottleReadBpsDevice, device(dom.ReadBps))
+	}
+	if dom.WriteBps != 0 {
+		bio.ThrottleWriteBpsDevice = append(bio.ThrottleWriteBpsDevice, device(dom.WriteBps))
+	}
+	if dom.ReadIOPS != 0 {
+		bio.ThrottleReadIOPSDevice = append(bio.ThrottleReadIOPSDevice, device(dom.ReadIOPS))
+	}
+	if dom.WriteIOPS != 0 {
+		bio.ThrottleWriteIOPSDevice = append(bio.ThrottleWriteIOPSDevice, device(dom.WriteIOPS))
+	}
+}
+
+// GetThrottling returns the throttling counters of the cgroup of a task
+func GetThrottling(containerID string) (types.CgroupThrottling, error) {
+	group := filepath.Join(ctrdServicesNamespace, containerID)
+	cpuDir := filepath.Join(cgroupRoot, group)
+	pidsDir := cpuDir
+	unified := true
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err != nil {
+		unified = false
+		cpuDir = filepath.Join(cgroupRoot, "cpu", group)
+		pidsDir = filepath.Join(cgroupRoot, "pids", group)
+	}
+	cpuStat, err := readFlatKeyed(filepath.Join(cpuDir, "cpu.stat"))
+	if err != nil {
+		return types.CgroupThrottling{}, err
+	}
+	pidsEvents, err := readFlatKeyed(filepath.Join(pidsDir, "pids.events"))
+	if err != nil && !os.IsNotExist(err) {
+		return types.CgroupThrottling{}, err
+	}
+	return cgroupThrottling(unified, cpuStat, pidsEvents), nil
+}
+
+// cgroupThrottling picks the counters from cpu.stat and pids.events, which
+// differ in the unit of the throttled time between cgroup v1 and v2
+func cgroupThrottling(unified bool, cpuStat, pidsEvents map[string]uint64) types.CgroupThrottling {
+	t := types.CgroupThrottling{
+		CPUThrottledPeriods: cpuStat["nr_throttled"],
+		PidsLimitHits:       pidsEvents["max"],
+	}
+	if unified {
+		t.CPUThrottledTime = cpuStat["throttled_usec"]
+	} else {
+		t.CPUThrottledTime = cpuStat["throttled_time"] / 1000
+	}
+	return t
+}
+
+func readFlatKeyed(file string) (map[string]uint64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseFlatKeyed(f)
+}
+
+// parseFlatKeyed parses the "<key> <value>" lines of a cgroup file
+func parseFlatKeyed(r io.Reader) (map[string]uint64, error) {
+	ret := make(map[string]uint64)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("bad cgroup line %q", scanner.Text())
+		}
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad cgroup line %q: %v", scanner.Text(), err)
+		}
+		ret[fields[0]] = value
+	}
+	return ret, scanner.Err()
+}
@@ -0,0 +1,409 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package containerd
+
+// Security profiles of container domains, see types.SecurityProfile. The
+// seccomp filter allows everything but the syscalls which the default
+// profile of Docker blocks; those which a capability makes legitimate are
+// only blocked when the container lacks that capability.
+//
+// There is no AppArmor profile: the kernels of EVE are built without
+// AppArmor, hence a spec naming one is rejected since runc would fail to
+// apply it.
+//
+// The user namespace of the strict profile maps root of the container to
+// an unprivileged range of host ids. The rootfs and the volumes of the
+// container are chowned into that range before it starts, and back once
+// it runs with another profile; the host id they were shifted to is kept
+// in the idShiftXattr of each of them. The ranges of the app instances do
+// not overlap, hence a shift interrupted half way is completed by the
+// next one.
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	zconfig "github.com/lf-edge/eve/api/go/config"
+	"github.com/lf-edge/eve/pkg/pillar/types"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// userNamespaceBase is the first host id mapped into the user
+	// namespaces of the strict profile. Each app instance gets its own
+	// range of userNamespaceSize ids, picked by its AppNum.
+	userNamespaceBase = 0x100000
+	userNamespaceSize = 0x10000
+	// idShiftXattr is the host id the ownership of a volume was shifted to
+	idShiftXattr = "user.eve.idshift"
+)
+
+// allCapabilities are the capabilities known to the runc we ship with
+var allCapabilities = []string{
+	"CAP_CHOWN",
+	"CAP_DAC_OVERRIDE",
+	"CAP_DAC_READ_SEARCH",
+	"CAP_FOWNER",
+	"CAP_FSETID",
+	"CAP_KILL",
+	"CAP_SETGID",
+	"CAP_SETUID",
+	"CAP_SETPCAP",
+	"CAP_LINUX_IMMUTABLE",
+	"CAP_NET_BIND_SERVICE",
+	"CAP_NET_BROADCAST",
+	"CAP_NET_ADMIN",
+	"CAP_NET_RAW",
+	"CAP_IPC_LOCK",
+	"CAP_IPC_OWNER",
+	"CAP_SYS_MODULE",
+	"CAP_SYS_RAWIO",
+	"CAP_SYS_CHROOT",
+	"CAP_SYS_PTRACE",
+	"CAP_SYS_PACCT",
+	"CAP_SYS_ADMIN",
+	"CAP_SYS_BOOT",
+	"CAP_SYS_NICE",
+	"CAP_SYS_RESOURCE",
+	"CAP_SYS_TIME",
+	"CAP_SYS_TTY_CONFIG",
+	"CAP_MKNOD",
+	"CAP_LEASE",
+	"CAP_AUDIT_WRITE",
+	"CAP_AUDIT_CONTROL",
+	"CAP_SETFCAP",
+	"CAP_MAC_OVERRIDE",
+	"CAP_MAC_ADMIN",
+	"CAP_SYSLOG",
+	"CAP_WAKE_ALARM",
+	"CAP_BLOCK_SUSPEND",
+	"CAP_AUDIT_READ",
+}
+
+// seccompBlocked are the syscalls blocked by the seccomp filter, keyed by
+// the capability which allows them; the ones under "" are always blocked
+var seccompBlocked = map[string][]string{
+	"": {
+		"add_key", "create_module", "get_kernel_syms", "kexec_file_load",
+		"kexec_load", "keyctl", "lookup_dcookie", "nfsservctl",
+		"query_module", "request_key", "sysfs", "_sysctl", "uselib",
+		"userfaultfd", "ustat", "vm86", "vm86old",
+	},
+	"CAP_SYS_ADMIN": {
+		"bpf", "fanotify_init", "fsconfig", "fsmount", "fsopen",
+		"fspick", "mount", "move_mount", "name_to_handle_at",
+		"open_tree", "perf_event_open", "pivot_root", "quotactl",
+		"setdomainname", "sethostname", "setns", "swapoff", "swapon",
+		"umount", "umount2", "unshare",
+	},
+	"CAP_SYS_BOOT":        {"reboot"},
+	"CAP_SYS_MODULE":      {"delete_module", "finit_module", "init_module"},
+	"CAP_SYS_PACCT":       {"acct"},
+	"CAP_SYS_PTRACE":      {"kcmp", "process_vm_readv", "process_vm_writev", "ptrace"},
+	"CAP_SYS_RAWIO":       {"ioperm", "iopl"},
+	"CAP_SYS_TIME":        {"adjtimex", "clock_adjtime", "clock_settime", "settimeofday", "stime"},
+	"CAP_SYS_NICE":        {"get_mempolicy", "mbind", "move_pages", "set_mempolicy"},
+	"CAP_DAC_READ_SEARCH": {"open_by_handle_at"},
+}
+
+var seccompArchitectures = []specs.Arch{
+	specs.ArchX86_64, specs.ArchX86, specs.ArchX32,
+	specs.ArchAARCH64, specs.ArchARM,
+}
+
+// normalizeCapabilities returns the capabilities with the CAP_ prefix, in
+// upper case, and ALL expanded
+func normalizeCapabilities(caps []string) ([]string, error) {
+	var ret []string
+	for _, c := range caps {
+		c = strings.ToUpper(c)
+		if c == "ALL" {
+			ret = append(ret, allCapabilities...)
+			continue
+		}
+		if !strings.HasPrefix(c, "CAP_") {
+			c = "CAP_" + c
+		}
+		if !capsContain(allCapabilities, c) {
+			return nil, fmt.Errorf("unknown capability %s", c)
+		}
+		ret = append(ret, c)
+	}
+	return ret, nil
+}
+
+func capsContain(caps []string, c string) bool {
+	for _, name := range caps {
+		if name == c {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultSeccomp returns the seccomp filter for a process with caps
+func defaultSeccomp(caps []string) *specs.LinuxSeccomp {
+	errno := uint(syscall.EPERM)
+	seccomp := &specs.LinuxSeccomp{
+		DefaultAction: specs.ActAllow,
+		Architectures: seccompArchitectures,
+	}
+	for _, c := range append([]string{""}, allCapabilities...) {
+		names, ok := seccompBlocked[c]
+		if !ok || (c != "" && capsContain(caps, c)) {
+			continue
+		}
+		seccomp.Syscalls = append(seccomp.Syscalls, specs.LinuxSyscall{
+			Names:    names,
+			Action:   specs.ActErrno,
+			ErrnoRet: &errno,
+		})
+	}
+	return seccomp
+}
+
+// UpdateSecurity applies the security profile of the domain to the spec
+// and checks the outcome
+func (s *ociSpec) UpdateSecurity(dom *types.DomainConfig) error {
+	sec := dom.Security
+	if sec.Profile == types.SecurityProfileUnconfined &&
+		len(sec.CapAdd) == 0 && len(sec.CapDrop) == 0 {
+		// leave the spec as it was before profiles existed
+		return nil
+	}
+	capAdd, err := normalizeCapabilities(sec.CapAdd)
+	if err != nil {
+		return err
+	}
+	capDrop, err := normalizeCapabilities(sec.CapDrop)
+	if err != nil {
+		return err
+	}
+	if s.Process == nil {
+		s.Process = &specs.Process{}
+	}
+	if s.Process.Capabilities == nil {
+		s.Process.Capabilities = &specs.LinuxCapabilities{}
+	}
+	if s.Linux == nil {
+		s.Linux = &specs.Linux{}
+	}
+
+	caps := s.Process.Capabilities.Bounding
+	switch sec.Profile {
+	case types.SecurityProfileUnconfined, types.SecurityProfileDefault:
+	case types.SecurityProfileStrict:
+		caps = nil
+		if s.Root == nil {
+			s.Root = &specs.Root{}
+		}
+		s.Root.Readonly = true
+		s.setUserNamespace(dom.AppNum)
+	default:
+		return fmt.Errorf("unknown security profile %d", sec.Profile)
+	}
+	var newCaps []string
+	for _, c := range caps {
+		if !capsContain(capDrop, c) {
+			newCaps = append(newCaps, c)
+		}
+	}
+	for _, c := range capAdd {
+		if !capsContain(newCaps, c) {
+			newCaps = append(newCaps, c)
+		}
+	}
+	s.Process.Capabilities.Bounding = newCaps
+	s.Process.Capabilities.Effective = newCaps
+	s.Process.Capabilities.Permitted = newCaps
+	s.Process.Capabilities.Inheritable = newCaps
+	s.Process.Capabilities.Ambient = nil
+
+	if sec.Profile != types.SecurityProfileUnconfined {
+		s.Process.NoNewPrivileges = true
+		s.Linux.Seccomp = defaultSeccomp(newCaps)
+	}
+	return validateSecurity(&s.Spec, sec.Profile)
+}
+
+// userNamespaceHostID returns the host id of root of the containers of an
+// app instance with the strict profile
+func userNamespaceHostID(appNum int) uint32 {
+	return uint32(userNamespaceBase + appNum*userNamespaceSize)
+}
+
+// setUserNamespace maps root of the container to the range of host ids of
+// the app instance
+func (s *ociSpec) setUserNamespace(appNum int) {
+	hostID := userNamespaceHostID(appNum)
+	mapping := []specs.LinuxIDMapping{{
+		ContainerID: 0,
+		HostID:      hostID,
+		Size:        userNamespaceSize,
+	}}
+	s.Linux.UIDMappings = mapping
+	s.Linux.GIDMappings = mapping
+	for _, ns := range s.Linux.Namespaces {
+		if ns.Type == specs.UserNamespace {
+			return
+		}
+	}
+	s.Linux.Namespaces = append(s.Linux.Namespaces,
+		specs.LinuxNamespace{Type: specs.UserNamespace})
+}
+
+// ShiftOwnership chowns the rootfs of the spec and the disks the
+// container mounts into the range of host ids its user namespace maps,
+// or back to the host ids if it has none
+func (s *ociSpec) ShiftOwnership(dom *types.DomainConfig, disks []types.DiskStatus) error {
+	hostID := uint32(0)
+	if dom.Security.Profile == types.SecurityProfileStrict {
+		hostID = userNamespaceHostID(dom.AppNum)
+	}
+	var paths []string
+	if s.Root != nil && s.Root.Path != "" {
+		paths = append(paths, s.Root.Path)
+	}
+	for _, disk := range disks {
+		switch disk.Format {
+		case zconfig.Format_FmtUnknown:
+		case zconfig.Format_CONTAINER:
+			paths = append(paths, filepath.Join(disk.FileLocation, "rootfs"))
+		default:
+			paths = append(paths, disk.FileLocation)
+		}
+	}
+	done := make(map[string]bool)
+	for _, path := range paths {
+		path = filepath.Clean(path)
+		if done[path] {
+			continue
+		}
+		done[path] = true
+		if err := shiftOwnership(path, hostID, userNamespaceSize); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shiftOwnership moves the owners of the files under path which are in
+// the range of size ids it was last shifted to into the range at hostID
+func shiftOwnership(path string, hostID uint32, size uint32) error {
+	from := uint32(0)
+	buf := make([]byte, 16)
+	if n, err := unix.Lgetxattr(path, idShiftXattr, buf); err == nil {
+		id, err := strconv.ParseUint(string(buf[:n]), 10, 32)
+		if err != nil {
+			return fmt.Errorf("bad %s of %s: %v", idShiftXattr, path, err)
+		}
+		from = uint32(id)
+	} else if err != unix.ENODATA {
+		return fmt.Errorf("can't read %s of %s: %v", idShiftXattr, path, err)
+	}
+	if from == hostID {
+		return nil
+	}
+	logrus.Infof("shiftOwnership: %s from %d to %d", path, from, hostID)
+	shift := func(id uint32) uint32 {
+		if id >= from && id-from < size {
+			return id - from + hostID
+		}
+		return id
+	}
+	err := filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		st, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return fmt.Errorf("no owner for %s", p)
+		}
+		uid, gid := shift(st.Uid), shift(st.Gid)
+		if uid == st.Uid && gid == st.Gid {
+			return nil
+		}
+		if err := os.Lchown(p, int(uid), int(gid)); err != nil {
+			return err
+		}
+		// chown clears the setuid and setgid bits
+		mode := info.Mode()
+		if mode&os.ModeSymlink == 0 && mode&(os.ModeSetuid|os.ModeSetgid) != 0 {
+			return os.Chmod(p, mode)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("shifting the ownership of %s to %d failed: %v", path, hostID, err)
+	}
+	value := []byte(strconv.FormatUint(uint64(hostID), 10))
+	if err := unix.Lsetxattr(path, idShiftXattr, value, 0); err != nil {
+		return fmt.Errorf("can't record the ownership shift of %s: %v", path, err)
+	}
+	return nil
+}
+
+// validateSecurity checks that the spec enforces the profile before a
+// container is created from it
+func validateSecurity(spec *specs.Spec, profile types.SecurityProfile) error {
+	if spec.Process == nil || spec.Process.Capabilities == nil || spec.Linux == nil {
+		return fmt.Errorf("spec has no process capabilities")
+	}
+	if spec.Process.ApparmorProfile != "" {
+		return fmt.Errorf("AppArmor profile %s can not be applied: AppArmor is not enabled",
+			spec.Process.ApparmorProfile)
+	}
+	caps := spec.Process.Capabilities
+	for _, c := range caps.Bounding {
+		if !capsContain(allCapabilities, c) {
+			return fmt.Errorf("unknown capability %s", c)
+		}
+	}
+	for _, set := range [][]string{caps.Effective, caps.Permitted, caps.Inheritable, caps.Ambient} {
+		for _, c := range set {
+			if !capsContain(caps.Bounding, c) {
+				return fmt.Errorf("capability %s is not in the bounding set", c)
+			}
+		}
+	}
+	if profile == types.SecurityProfileUnconfined {
+		return nil
+	}
+	if !spec.Process.NoNewPrivileges {
+		return fmt.Errorf("%s profile requires no-new-privileges", profile)
+	}
+	if spec.Linux.Seccomp == nil || spec.Linux.Seccomp.DefaultAction == "" {
+		return fmt.Errorf("%s profile requires a seccomp filter", profile)
+	}
+	if profile != types.SecurityProfileStrict {
+		return nil
+	}
+	if spec.Root == nil || !spec.Root.Readonly {
+		return fmt.Errorf("%s profile requires a read-only rootfs", profile)
+	}
+	userns := false
+	for _, ns := range spec.Linux.Namespaces {
+		if ns.Type == specs.UserNamespace {
+			if ns.Path != "" {
+				return fmt.Errorf("%s profile requires a new user namespace", profile)
+			}
+			userns = true
+		}
+	}
+	if !userns || len(spec.Linux.UIDMappings) == 0 || len(spec.Linux.GIDMappings) == 0 {
+		return fmt.Errorf("%s profile requires a user namespace", profile)
+	}
+	for _, m := range append(spec.Linux.UIDMappings, spec.Linux.GIDMappings...) {
+		if m.HostID == 0 {
+			return fmt.Errorf("%s profile can not map host root into the container", profile)
+		}
+	}
+	return nil
+}
@@ -1,17 +1,505 @@
-// Copyright (c) 2017-2020 Zededa, Inc.
+// Copyright (c) 2017-2021 Zededa, Inc.
 // SPDX-License-Identifier: Apache-2.0
 
 package hypervisor
 
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	zconfig "github.com/lf-edge/eve/api/go/config"
+	"github.com/lf-edge/eve/pkg/pillar/types"
+	"github.com/sirupsen/logrus"
+)
+
+// acrnOverHead is what acrn-dm itself needs on top of the memory of the
+// domain
+const acrnOverHead = int64(300 * 1024 * 1024)
+
+const acrnStateDir = "/run/hypervisor/acrn/"
+
+// The ACRN tools are part of the host root filesystem of ACRN images, which
+// the xen-tools loader has under /hostfs
+const acrnToolsDir = "/hostfs/usr/bin/"
+const acrnBiosDir = "/hostfs/usr/share/acrn/bios/"
+
+// ACRN is a type-1 hypervisor but, like qemu for KVM, the acrn-dm device
+// model running in the service VM is the anchor process of each domain and
+// runs as a containerd task. acrn-dm takes its configuration on the
+// command line and registers with the acrnctl manager under the domain
+// name. For every domain we keep the following in
+// /run/hypervisor/acrn/DOMAIN_NAME:
+//
+//	domain.json - what Start, Delete and GetDomsCPUMem need to know
+//
+// acrn-dm only accepts tap devices named tap*, which it creates itself.
+// Start renames them to the VIF names zedrouter uses for its ACLs and puts
+// them on the bridges.
 type acrnContext struct {
-	nullContext
+	ctrdContext
+	dmExec  string
+	ctlExec string
+}
+
+// acrnDomain is saved by Setup for the rest of the domain lifecycle
+type acrnDomain struct {
+	MemoryMB uint32
+	VifList  []types.VifInfo
 }
 
 func newAcrn() Hypervisor {
-	return acrnContext{}
+	ctrdCtx, err := initContainerd()
+	if err != nil {
+		logrus.Fatalf("couldn't initialize containerd (this should not happen): %v. Exiting.", err)
+		return nil // it really never returns on account of above
+	}
+	return acrnContext{
+		ctrdContext: *ctrdCtx,
+		dmExec:      acrnToolsDir + "acrn-dm",
+		ctlExec:     acrnToolsDir + "acrnctl",
+	}
 }
 
 // Name returns the name of this hypervisor implementation
 func (ctx acrnContext) Name() string {
 	return "acrn"
 }
+
+// GetCapabilities reports IOVirtualization since ACRN does not boot
+// without VT-d
+func (ctx acrnContext) GetCapabilities() (*types.Capabilities, error) {
+	return &types.Capabilities{
+		HWAssistedVirtualization: true,
+		IOVirtualization:         true,
+	}, nil
+}
+
+func (ctx acrnContext) Task(status *types.DomainStatus) types.Task {
+	if status.VirtualizationMode == types.NOHYPER {
+		return ctx.ctrdContext
+	}
+	return ctx
+}
+
+// acrnTapName is the name acrn-dm is given for the tap of a VIF
+func acrnTapName(vif string) string {
+	return "tap" + strings.TrimPrefix(vif, "nbu")
+}
+
+// pciShortToAcrn turns 00:1f.2 into the 00/1f/2 acrn-dm wants
+func pciShortToAcrn(short string) string {
+	return strings.NewReplacer(":", "/", ".", "/").Replace(short)
+}
+
+// dmArgs returns the acrn-dm command line of the domain
+func (ctx acrnContext) dmArgs(domainName string, config types.DomainConfig,
+	diskStatusList []types.DiskStatus, aa *types.AssignableAdapters) ([]string, error) {
+
+	vcpus := config.VCpus
+	if vcpus == 0 {
+		vcpus = 1
+	}
+	args := []string{ctx.dmExec, "-A",
+		"-m", fmt.Sprintf("%dM", (config.Memory+1023)/1024),
+		"-c", fmt.Sprintf("%d", vcpus),
+		"-s", "0:0,hostbridge",
+		"-s", "1:0,lpc",
+		"-l", "com1,stdio",
+	}
+	slot := 2
+	for _, ds := range diskStatusList {
+		switch ds.Devtype {
+		case "":
+			continue
+		case "cdrom":
+			args = append(args, "-s", fmt.Sprintf("%d,ahci,cd:%s", slot, ds.FileLocation))
+		case "hdd":
+			// acrn-dm only does raw images
+			if ds.Format != zconfig.Format_RAW {
+				return nil, fmt.Errorf("disk %s of format %s is not supported",
+					ds.DisplayName, ds.Format)
+			}
+			disk := fmt.Sprintf("%d,virtio-blk,%s", slot, ds.FileLocation)
+			if ds.ReadOnly {
+				disk += ",ro"
+			}
+			args = append(args, "-s", disk)
+		default:
+			return nil, fmt.Errorf("disk %s of type %s is not supported",
+				ds.DisplayName, ds.Devtype)
+		}
+		slot++
+	}
+	for _, net := range config.VifList {
+		args = append(args, "-s", fmt.Sprintf("%d,virtio-net,%s,mac=%s",
+			slot, acrnTapName(net.Vif), net.Mac))
+		slot++
+	}
+
+	var pciAssignments []typeAndPCI
+	var serialAssignments []string
+	var usbAssignments []string
+	for _, adapter := range config.IoAdapterList {
+		logrus.Debugf("processing adapter %d %s\n", adapter.Type, adapter.Name)
+		list := aa.LookupIoBundleAny(adapter.Name)
+		// We reserved it in handleCreate so nobody could have stolen it
+		if len(list) == 0 {
+			logrus.Fatalf("IoBundle disappeared %d %s for %s\n",
+				adapter.Type, adapter.Name, domainName)
+		}
+		for _, ib := range list {
+			if ib == nil {
+				continue
+			}
+			if ib.UsedByUUID != config.UUIDandVersion.UUID {
+				logrus.Fatalf("IoBundle not ours %s: %d %s for %s\n",
+					ib.UsedByUUID, adapter.Type, adapter.Name,
+					domainName)
+			}
+			if ib.PciLong != "" {
+				logrus.Infof("Adding PCI device <%v>\n", ib.PciLong)
+				tap := typeAndPCI{pciLong: ib.PciLong, ioType: ib.Type}
+				pciAssignments = addNoDuplicatePCI(pciAssignments, tap)
+			}
+			if ib.Serial != "" {
+				logrus.Infof("Adding serial <%s>\n", ib.Serial)
+				serialAssignments = addNoDuplicate(serialAssignments, ib.Serial)
+			}
+			if ib.UsbAddr != "" {
+				logrus.Infof("Adding USB host device <%s>\n", ib.UsbAddr)
+				usbAssignments = addNoDuplicate(usbAssignments, ib.UsbAddr)
+			}
+		}
+	}
+	for _, pa := range pciAssignments {
+		short := pciShortToAcrn(types.PCILongToShort(pa.pciLong))
+		args = append(args, "-s", fmt.Sprintf("%d,passthru,%s", slot, short))
+		slot++
+	}
+	// com1 is the console and acrn-dm has only one more
+	if len(serialAssignments) > 1 {
+		return nil, fmt.Errorf("only one serial port can be assigned")
+	}
+	for _, serial := range serialAssignments {
+		args = append(args, "-l", "com2,"+serial)
+	}
+	if len(usbAssignments) != 0 {
+		var ports []string
+		for _, usbaddr := range usbAssignments {
+			bus, port := usbBusPort(usbaddr)
+			ports = append(ports, bus+"-"+port)
+		}
+		args = append(args, "-s", fmt.Sprintf("%d,xhci,%s", slot,
+			strings.Join(ports, ":")))
+	}
+
+	if config.Kernel != "" {
+		args = append(args, "-k", config.Kernel)
+		if config.Ramdisk != "" {
+			args = append(args, "-r", config.Ramdisk)
+		}
+		bootArgs := "console=ttyS0"
+		if config.RootDev != "" {
+			bootArgs += " root=" + config.RootDev
+		}
+		if config.ExtraArgs != "" {
+			bootArgs += " " + config.ExtraArgs
+		}
+		args = append(args, "-B", bootArgs)
+	} else {
+		args = append(args, "--ovmf", acrnBiosDir+"OVMF.fd")
+	}
+	return append(args, domainName), nil
+}
+
+// CreateDomConfig writes the acrn-dm command line, one argument per line
+func (ctx acrnContext) CreateDomConfig(domainName string, config types.DomainConfig, diskStatusList []types.DiskStatus,
+	aa *types.AssignableAdapters, file *os.File) error {
+
+	args, err := ctx.dmArgs(domainName, config, diskStatusList, aa)
+	if err != nil {
+		return logError("domain %s can not run under ACRN: %v", domainName, err)
+	}
+	for _, arg := range args {
+		if _, err := file.WriteString(arg + "\n"); err != nil {
+			return logError("can't write to config file %s (%v)", file.Name(), err)
+		}
+	}
+	return nil
+}
+
+func (ctx acrnContext) Setup(status types.DomainStatus, config types.DomainConfig, aa *types.AssignableAdapters, file *os.File) error {
+	domainName := status.DomainName
+	// first lets build the domain config
+	if err := ctx.CreateDomConfig(domainName, config, status.DiskStatusList, aa, file); err != nil {
+		return logError("failed to build domain config: %v", err)
+	}
+	args, err := ctx.dmArgs(domainName, config, status.DiskStatusList, aa)
+	if err != nil {
+		return logError("failed to build domain config: %v", err)
+	}
+
+	stateDir := acrnStateDir + domainName
+	if err := os.MkdirAll(stateDir, 0700); err != nil {
+		return logError("failed to create state directory for %s: %v", domainName, err)
+	}
+	domain := acrnDomain{
+		MemoryMB: uint32((config.Memory + 1023) / 1024),
+		VifList:  config.VifList,
+	}
+	data, err := json.Marshal(domain)
+	if err != nil {
+		return logError("failed to encode state of domain %s: %v", domainName, err)
+	}
+	if err := ioutil.WriteFile(stateDir+"/domain.json", data, 0600); err != nil {
+		return logError("failed to save state of domain %s: %v", domainName, err)
+	}
+
+	spec, err := ctx.setupSpec(&status, &config, status.OCIConfigDir)
+	if err != nil {
+		return logError("failed to load OCI spec for domain %s: %v", domainName, err)
+	}
+	if err = spec.AddLoader("/containers/services/xen-tools"); err != nil {
+		return logError("failed to add acrn hypervisor loader to domain %s: %v", domainName, err)
+	}
+	spec.AdjustMemLimit(config, acrnOverHead)
+	spec.Get().Process.Args = args
+	logrus.Infof("Hypervisor args: %v", args)
+
+	if err := spec.CreateContainer(true); err != nil {
+		return logError("Failed to create container for task %s from %v: %v", domainName, config, err)
+	}
+	return nil
+}
+
+func readAcrnDomain(domainName string) (acrnDomain, error) {
+	var domain acrnDomain
+	data, err := ioutil.ReadFile(acrnStateDir + domainName + "/domain.json")
+	if err != nil {
+		return domain, err
+	}
+	err = json.Unmarshal(data, &domain)
+	return domain, err
+}
+
+// attachTaps waits for acrn-dm to create the taps of the domain and puts
+// them on the bridges under their VIF names
+func attachTaps(domainName string, vifList []types.VifInfo) error {
+	for _, net := range vifList {
+		tap := acrnTapName(net.Vif)
+		// Depending on the version acrn-dm prefixes the name
+		names := []string{tap, "acrn_" + tap}
+		attached := false
+		for i := 0; i < 10 && !attached; i++ {
+			for _, name := range names {
+				found, err := attachTap(name, net.Vif, net.Bridge)
+				if err != nil {
+					return fmt.Errorf("%s: %v", name, err)
+				}
+				if found {
+					logrus.Infof("attachTaps %s: %s is %s on %s",
+						domainName, name, net.Vif, net.Bridge)
+					attached = true
+					break
+				}
+			}
+			if !attached {
+				time.Sleep(time.Second)
+			}
+		}
+		if !attached {
+			return fmt.Errorf("no tap device for %s", net.Vif)
+		}
+	}
+	return nil
+}
+
+func (ctx acrnContext) Start(domainName string, domainID int) error {
+	logrus.Infof("starting ACRN domain %s", domainName)
+	domain, err := readAcrnDomain(domainName)
+	if err != nil {
+		return logError("failed to read state of domain %s: %v", domainName, err)
+	}
+	if err := ctx.ctrdContext.Start(domainName, domainID); err != nil {
+		logrus.Errorf("couldn't start task for domain %s: %v", domainName, err)
+		return err
+	}
+	if err := attachTaps(domainName, domain.VifList); err != nil {
+		return logError("failed to attach network interfaces of %s: %v", domainName, err)
+	}
+	return nil
+}
+
+func (ctx acrnContext) acrnctl(args ...string) (string, error) {
+	ctrdSystemCtx, done := ctx.ctrdClient.CtrNewSystemServicesCtx()
+	defer done()
+	stdOut, stdErr, err := ctx.ctrdClient.CtrSystemExec(ctrdSystemCtx, "xen-tools",
+		append([]string{ctx.ctlExec}, args...))
+	if err != nil {
+		return stdOut, fmt.Errorf("acrnctl %s failed: %s %s", strings.Join(args, " "),
+			stdOut, stdErr)
+	}
+	logrus.Debugf("acrnctl %s done: stdout: %s, stderr: %s",
+		strings.Join(args, " "), stdOut, stdErr)
+	return stdOut, nil
+}
+
+func (ctx acrnContext) Stop(domainName string, domainID int, force bool) error {
+	args := []string{"stop", domainName}
+	if force {
+		args = append(args, "-f")
+	}
+	if _, err := ctx.acrnctl(args...); err != nil {
+		return logError("Stop: %v", err)
+	}
+	return nil
+}
+
+func (ctx acrnContext) Delete(domainName string, domainID int) (result error) {
+	// regardless of happens to everything else, we have to try and delete the task
+	defer func() {
+		if err := ctx.ctrdContext.Delete(domainName, domainID); err != nil {
+			result = fmt.Errorf("%w; couldn't delete task %s: %v", result, domainName, err)
+		}
+	}()
+
+	// acrn-dm may be gone already
+	if _, err := ctx.acrnctl("stop", domainName, "-f"); err != nil {
+		logrus.Warnf("Delete: %v", err)
+	}
+	if err := os.RemoveAll(acrnStateDir + domainName); err != nil {
+		return logError("failed to clean up domain state directory %s (%v)", domainName, err)
+	}
+	return nil
+}
+
+// parseAcrnctlList returns the state of each domain listed by acrnctl list
+func parseAcrnctlList(out string) map[string]string {
+	res := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 {
+			res[fields[0]] = fields[len(fields)-1]
+		}
+	}
+	return res
+}
+
+func (ctx acrnContext) Info(domainName string, domainID int) (int, types.SwState, error) {
+	// first we ask for the task status
+	effectiveDomainID, effectiveDomainState, err := ctx.ctrdContext.Info(domainName, domainID)
+	if err != nil || effectiveDomainState != types.RUNNING {
+		return effectiveDomainID, effectiveDomainState, err
+	}
+
+	// if task is alive, we augment task status with the VM state from acrnctl
+	stateMap := map[string]types.SwState{
+		"started":   types.RUNNING,
+		"paused":    types.PAUSED,
+		"suspended": types.PAUSED,
+		"stopped":   types.HALTING,
+	}
+	out, err := ctx.acrnctl("list")
+	if err != nil {
+		return effectiveDomainID, types.BROKEN, logError("couldn't retrieve status for domain %s: %v", domainName, err)
+	}
+	state, ok := parseAcrnctlList(out)[domainName]
+	if !ok {
+		// acrn-dm registers with the manager once the VM is set up
+		logrus.Warnf("domain %s is not known to acrnctl yet", domainName)
+		return effectiveDomainID, effectiveDomainState, nil
+	}
+	effectiveDomainState, matched := stateMap[state]
+	if !matched {
+		return effectiveDomainID, types.BROKEN, logError("domain %s reported to be in unexpected state %s", domainName, state)
+	}
+	return effectiveDomainID, effectiveDomainState, nil
+}
+
+// PCIReserve binds the device to pci-stub so that ACRN can assign it
+func (ctx acrnContext) PCIReserve(long string) error {
+	logrus.Infof("PCIReserve long addr is %s", long)
+
+	overrideFile := sysfsPciDevices + long + "/driver_override"
+	unbindFile := sysfsPciDevices + long + "/driver/unbind"
+
+	if err := ioutil.WriteFile(overrideFile, []byte("pci-stub"), 0644); err != nil {
+		return logError("driver_override failure for PCI device %s: %v",
+			long, err)
+	}
+	if _, err := os.Stat(unbindFile); err == nil {
+		if err := ioutil.WriteFile(unbindFile, []byte(long), 0644); err != nil {
+			return logError("unbind failure for PCI device %s: %v",
+				long, err)
+		}
+	}
+	if err := ioutil.WriteFile(sysfsPciDriversProbe, []byte(long), 0644); err != nil {
+		return logError("drivers_probe failure for PCI device %s: %v",
+			long, err)
+	}
+	return nil
+}
+
+// PCIRelease gives the device back to its own driver
+func (ctx acrnContext) PCIRelease(long string) error {
+	logrus.Infof("PCIRelease long addr is %s", long)
+
+	overrideFile := sysfsPciDevices + long + "/driver_override"
+	unbindFile := sysfsPciDevices + long + "/driver/unbind"
+
+	if err := ioutil.WriteFile(overrideFile, []byte("\n"), 0644); err != nil {
+		return logError("driver_override failure for PCI device %s: %v",
+			long, err)
+	}
+	if _, err := os.Stat(unbindFile); err == nil {
+		if err := ioutil.WriteFile(unbindFile, []byte(long), 0644); err != nil {
+			return logError("unbind failure for PCI device %s: %v",
+				long, err)
+		}
+	}
+	if err := ioutil.WriteFile(sysfsPciDriversProbe, []byte(long), 0644); err != nil {
+		return logError("drivers_probe failure for PCI device %s: %v",
+			long, err)
+	}
+	return nil
+}
+
+func (ctx acrnContext) PCISameController(id1 string, id2 string) bool {
+	// As with Xen the IOMMU belongs to the hypervisor, hence the service
+	// VM does not see the groups
+	return types.PCISameController(id1, id2)
+}
+
+// GetHostCPUMem returns what the service VM sees; ACRN has no interface
+// to ask for the CPUs and memory it keeps for the other VMs
+func (ctx acrnContext) GetHostCPUMem() (types.HostMemory, error) {
+	return selfDomCPUMem()
+}
+
+// GetDomsCPUMem reports the CPU usage of the tasks, which for the domains
+// is that of acrn-dm. The memory of a domain is allocated by ACRN when it
+// boots, hence it is reported as fully used.
+func (ctx acrnContext) GetDomsCPUMem() (map[string]types.DomainMetric, error) {
+	res, err := ctx.ctrdContext.GetDomsCPUMem()
+	if err != nil {
+		return nil, err
+	}
+	for id, dm := range res {
+		domain, err := readAcrnDomain(id)
+		if err != nil {
+			// Not an ACRN domain
+			continue
+		}
+		dm.UsedMemory = domain.MemoryMB
+		dm.AvailableMemory = 0
+		dm.UsedMemoryPercent = 100
+		res[id] = dm
+	}
+	return res, nil
+}
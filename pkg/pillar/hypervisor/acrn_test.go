@@ -0,0 +1,180 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package hypervisor
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	zconfig "github.com/lf-edge/eve/api/go/config"
+	"github.com/lf-edge/eve/pkg/pillar/types"
+	uuid "github.com/satori/go.uuid"
+)
+
+var acrnTest = acrnContext{dmExec: "acrn-dm", ctlExec: "acrnctl"}
+
+func TestAcrnCreateDomConfig(t *testing.T) {
+	config := types.DomainConfig{
+		UUIDandVersion: types.UUIDandVersion{UUID: uuid.NewV4(), Version: "1.0"},
+		VmConfig: types.VmConfig{
+			Kernel:    "/boot/kernel",
+			Ramdisk:   "/boot/ramdisk",
+			ExtraArgs: "init=/bin/sh",
+			Memory:    1024 * 1024,
+			VCpus:     2,
+		},
+		VifList: []types.VifInfo{
+			{Bridge: "bn0", Mac: "6a:00:03:61:a6:90", Vif: "nbu1x1"},
+			{Bridge: "bn0", Mac: "6a:00:03:61:a6:91", Vif: "nbu2x1"},
+		},
+		IoAdapterList: []types.IoAdapter{
+			{Type: types.IoNetEth, Name: "eth0"},
+			{Type: types.IoCom, Name: "COM1"},
+			{Type: types.IoUSB, Name: "USB1"},
+		},
+	}
+	disks := []types.DiskStatus{
+		{Format: zconfig.Format_RAW, FileLocation: "/foo/bar.raw", Devtype: "hdd"},
+		{Format: zconfig.Format_RAW, FileLocation: "/foo/ro.raw", Devtype: "hdd", ReadOnly: true},
+		{Format: zconfig.Format_RAW, FileLocation: "/foo/cd.iso", Devtype: "cdrom"},
+		{Format: zconfig.Format_CONTAINER, FileLocation: "/foo/volume", Devtype: ""},
+	}
+	aa := types.AssignableAdapters{
+		Initialized: true,
+		IoBundleList: []types.IoBundle{
+			{
+				Type:            types.IoNetEth,
+				AssignmentGroup: "eth0-1",
+				Phylabel:        "eth0",
+				Ifname:          "eth0",
+				PciLong:         "0000:03:00.0",
+				UsedByUUID:      config.UUIDandVersion.UUID,
+			},
+			{
+				Type:            types.IoCom,
+				AssignmentGroup: "COM1",
+				Phylabel:        "COM1",
+				Ifname:          "COM1",
+				Serial:          "/dev/ttyS0",
+				UsedByUUID:      config.UUIDandVersion.UUID,
+			},
+			{
+				Type:            types.IoUSB,
+				AssignmentGroup: "USB1",
+				Phylabel:        "USB1:1",
+				UsbAddr:         "1:1",
+				UsedByUUID:      config.UUIDandVersion.UUID,
+			},
+		},
+	}
+	conf, err := ioutil.TempFile("/tmp", "config")
+	if err != nil {
+		t.Fatalf("Can't create config file for a domain %v", err)
+	}
+	defer os.Remove(conf.Name())
+
+	if err := acrnTest.CreateDomConfig("test", config, disks, &aa, conf); err != nil {
+		t.Fatalf("CreateDomConfig failed %v", err)
+	}
+	result, err := ioutil.ReadFile(conf.Name())
+	if err != nil {
+		t.Fatalf("reading conf file failed %v", err)
+	}
+	expected := `acrn-dm
+-A
+-m
+1024M
+-c
+2
+-s
+0:0,hostbridge
+-s
+1:0,lpc
+-l
+com1,stdio
+-s
+2,virtio-blk,/foo/bar.raw
+-s
+3,virtio-blk,/foo/ro.raw,ro
+-s
+4,ahci,cd:/foo/cd.iso
+-s
+5,virtio-net,tap1x1,mac=6a:00:03:61:a6:90
+-s
+6,virtio-net,tap2x1,mac=6a:00:03:61:a6:91
+-s
+7,passthru,03/00/0
+-l
+com2,/dev/ttyS0
+-s
+8,xhci,1-1
+-k
+/boot/kernel
+-r
+/boot/ramdisk
+-B
+console=ttyS0 init=/bin/sh
+test
+`
+	if string(result) != expected {
+		t.Errorf("got an unexpected resulting config %s", string(result))
+	}
+}
+
+func TestAcrnCreateDomConfigOVMF(t *testing.T) {
+	config := types.DomainConfig{
+		UUIDandVersion: types.UUIDandVersion{UUID: uuid.NewV4(), Version: "1.0"},
+		VmConfig: types.VmConfig{
+			Memory: 512 * 1024,
+		},
+	}
+	disks := []types.DiskStatus{
+		{Format: zconfig.Format_RAW, FileLocation: "/foo/bar.raw", Devtype: "hdd"},
+	}
+	args, err := acrnTest.dmArgs("test", config, disks, &types.AssignableAdapters{})
+	if err != nil {
+		t.Fatalf("dmArgs failed %v", err)
+	}
+	expected := []string{"acrn-dm", "-A", "-m", "512M", "-c", "1",
+		"-s", "0:0,hostbridge", "-s", "1:0,lpc", "-l", "com1,stdio",
+		"-s", "2,virtio-blk,/foo/bar.raw",
+		"--ovmf", acrnBiosDir + "OVMF.fd", "test"}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("got %v instead of %v", args, expected)
+	}
+}
+
+func TestAcrnUnsupportedDisks(t *testing.T) {
+	testMatrix := map[string]types.DiskStatus{
+		"QCOW2":     {Format: zconfig.Format_QCOW2, FileLocation: "/foo/bar.qcow2", Devtype: "hdd"},
+		"Container": {Format: zconfig.Format_CONTAINER, FileLocation: "/foo/container", Devtype: "9P"},
+		"Legacy":    {Format: zconfig.Format_RAW, FileLocation: "/foo/bar.raw", Devtype: "legacy"},
+	}
+	config := types.DomainConfig{VmConfig: types.VmConfig{Memory: 512 * 1024}}
+	for testname, disk := range testMatrix {
+		t.Logf("Running test %s", testname)
+		if _, err := acrnTest.dmArgs("test", config, []types.DiskStatus{disk},
+			&types.AssignableAdapters{}); err == nil {
+			t.Errorf("%s: expected dmArgs to fail", testname)
+		}
+	}
+}
+
+func TestParseAcrnctlList(t *testing.T) {
+	out := `vm1.1.1		started
+vm2.2.1		stopped
+
+vm3.3.1		paused
+`
+	expected := map[string]string{
+		"vm1.1.1": "started",
+		"vm2.2.1": "stopped",
+		"vm3.3.1": "paused",
+	}
+	if res := parseAcrnctlList(out); !reflect.DeepEqual(res, expected) {
+		t.Errorf("got %v instead of %v", res, expected)
+	}
+}
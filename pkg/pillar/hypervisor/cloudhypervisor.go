@@ -0,0 +1,413 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package hypervisor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	zconfig "github.com/lf-edge/eve/api/go/config"
+	"github.com/lf-edge/eve/pkg/pillar/containerd"
+	"github.com/lf-edge/eve/pkg/pillar/types"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sirupsen/logrus"
+)
+
+// cloudHypervisorOverHead is an estimate of what the VMM itself needs on
+// top of the memory of the domain. It is much smaller than qemuOverHead
+// since there is no emulated hardware.
+const cloudHypervisorOverHead = int64(100 * 1024 * 1024)
+
+const cloudHypervisorStateDir = "/run/hypervisor/cloud-hypervisor/"
+
+// Cloud-hypervisor domains map 1-1 to a cloud-hypervisor process, which
+// runs as a containerd task the way qemu does for KVM. The process is
+// started with only its REST API socket; Start then creates the VM from
+// the configuration written by Setup and boots it. For every domain we
+// keep the following in /run/hypervisor/cloud-hypervisor/DOMAIN_NAME:
+//
+//	api - UNIX domain socket of the REST API
+//	vm.json - the VM configuration as passed to vm.create
+//
+// Only direct kernel boot, virtio-blk disks and virtio-net interfaces on
+// tap devices are supported; no PCI passthrough, no emulated devices and
+// no containers in a VM.
+type cloudHypervisorContext struct {
+	ctrdContext
+	chExec string
+}
+
+func newCloudHypervisor() Hypervisor {
+	ctrdCtx, err := initContainerd()
+	if err != nil {
+		logrus.Fatalf("couldn't initialize containerd (this should not happen): %v. Exiting.", err)
+		return nil // it really never returns on account of above
+	}
+	return cloudHypervisorContext{
+		ctrdContext: *ctrdCtx,
+		chExec:      "/usr/lib/xen/bin/cloud-hypervisor",
+	}
+}
+
+// Name returns the name of this hypervisor implementation
+func (ctx cloudHypervisorContext) Name() string {
+	return "cloud-hypervisor"
+}
+
+// GetCapabilities reports no IOVirtualization since devices can not be
+// assigned to cloud-hypervisor domains
+func (ctx cloudHypervisorContext) GetCapabilities() (*types.Capabilities, error) {
+	return &types.Capabilities{
+		HWAssistedVirtualization: true,
+		IOVirtualization:         false,
+	}, nil
+}
+
+func (ctx cloudHypervisorContext) Task(status *types.DomainStatus) types.Task {
+	if status.VirtualizationMode == types.NOHYPER {
+		return ctx.ctrdContext
+	}
+	return ctx
+}
+
+// The subset of the VmConfig of the cloud-hypervisor REST API we use
+type chPayload struct {
+	Path string `json:"path"`
+}
+
+type chCmdline struct {
+	Args string `json:"args"`
+}
+
+type chCpus struct {
+	BootVcpus int `json:"boot_vcpus"`
+	MaxVcpus  int `json:"max_vcpus"`
+}
+
+type chMemory struct {
+	Size int64 `json:"size"`
+}
+
+type chDisk struct {
+	Path     string `json:"path"`
+	Readonly bool   `json:"readonly"`
+	ID       string `json:"id"`
+}
+
+type chNet struct {
+	Tap string `json:"tap"`
+	Mac string `json:"mac"`
+	ID  string `json:"id"`
+}
+
+type chConsole struct {
+	Mode string `json:"mode"`
+}
+
+type chVMConfig struct {
+	Cpus      chCpus     `json:"cpus"`
+	Memory    chMemory   `json:"memory"`
+	Kernel    chPayload  `json:"kernel"`
+	Initramfs *chPayload `json:"initramfs,omitempty"`
+	Cmdline   chCmdline  `json:"cmdline"`
+	Disks     []chDisk   `json:"disks,omitempty"`
+	Net       []chNet    `json:"net,omitempty"`
+	Serial    chConsole  `json:"serial"`
+	Console   chConsole  `json:"console"`
+}
+
+// vmConfig checks that the domain only uses what cloud-hypervisor
+// supports and returns its VM configuration
+func (ctx cloudHypervisorContext) vmConfig(config types.DomainConfig,
+	diskStatusList []types.DiskStatus) (*chVMConfig, error) {
+
+	if config.Kernel == "" {
+		return nil, fmt.Errorf("no kernel to boot")
+	}
+	if len(config.IoAdapterList) != 0 {
+		return nil, fmt.Errorf("assigned I/O adapters are not supported")
+	}
+	vcpus := config.VCpus
+	if vcpus == 0 {
+		vcpus = 1
+	}
+	maxCpus := config.MaxCpus
+	if maxCpus < vcpus {
+		maxCpus = vcpus
+	}
+	vm := chVMConfig{
+		Cpus:    chCpus{BootVcpus: vcpus, MaxVcpus: maxCpus},
+		Memory:  chMemory{Size: int64((config.Memory+1023)/1024) << 20},
+		Kernel:  chPayload{Path: config.Kernel},
+		Cmdline: chCmdline{Args: "console=ttyS0"},
+		// The serial port goes to the stdout of the task
+		Serial:  chConsole{Mode: "Tty"},
+		Console: chConsole{Mode: "Off"},
+	}
+	if config.Ramdisk != "" {
+		vm.Initramfs = &chPayload{Path: config.Ramdisk}
+	}
+	if config.RootDev != "" {
+		vm.Cmdline.Args += " root=" + config.RootDev
+	}
+	if config.ExtraArgs != "" {
+		vm.Cmdline.Args += " " + config.ExtraArgs
+	}
+	for i, ds := range diskStatusList {
+		if ds.Devtype == "" {
+			continue
+		}
+		if ds.Format == zconfig.Format_CONTAINER || ds.Devtype != "hdd" {
+			return nil, fmt.Errorf("disk %s of format %s is not supported",
+				ds.DisplayName, ds.Format)
+		}
+		vm.Disks = append(vm.Disks, chDisk{
+			Path:     ds.FileLocation,
+			Readonly: ds.ReadOnly,
+			ID:       fmt.Sprintf("disk%d", i),
+		})
+	}
+	for _, net := range config.VifList {
+		vm.Net = append(vm.Net, chNet{
+			Tap: net.Vif,
+			Mac: net.Mac,
+			ID:  net.Vif,
+		})
+	}
+	return &vm, nil
+}
+
+// CreateDomConfig writes the VM configuration passed to vm.create
+func (ctx cloudHypervisorContext) CreateDomConfig(domainName string,
+	config types.DomainConfig, diskStatusList []types.DiskStatus,
+	file *os.File) error {
+
+	vm, err := ctx.vmConfig(config, diskStatusList)
+	if err != nil {
+		return logError("domain %s can not run under cloud-hypervisor: %v",
+			domainName, err)
+	}
+	data, err := json.MarshalIndent(vm, "", "  ")
+	if err != nil {
+		return logError("failed to encode config of domain %s: %v",
+			domainName, err)
+	}
+	if _, err := file.Write(data); err != nil {
+		return logError("can't write to config file %s (%v)", file.Name(), err)
+	}
+	return nil
+}
+
+func (ctx cloudHypervisorContext) Setup(status types.DomainStatus, config types.DomainConfig,
+	aa *types.AssignableAdapters, file *os.File) error {
+
+	domainName := status.DomainName
+	if err := ctx.CreateDomConfig(domainName, config, status.DiskStatusList, file); err != nil {
+		return err
+	}
+	stateDir := cloudHypervisorStateDir + domainName
+	if err := os.MkdirAll(stateDir, 0700); err != nil {
+		return logError("failed to create state directory for %s: %v", domainName, err)
+	}
+	data, err := ioutil.ReadFile(file.Name())
+	if err != nil {
+		return logError("failed to read config file %s: %v", file.Name(), err)
+	}
+	if err := ioutil.WriteFile(stateDir+"/vm.json", data, 0600); err != nil {
+		return logError("failed to save config of domain %s: %v", domainName, err)
+	}
+
+	// cloud-hypervisor opens the taps by name; since it does not run
+	// a script like qemu-ifup they are created on the bridges here
+	for _, net := range config.VifList {
+		if err := createTap(net.Vif, net.Bridge); err != nil {
+			return logError("failed to create %s for domain %s: %v",
+				net.Vif, domainName, err)
+		}
+	}
+
+	args := []string{ctx.chExec, "--api-socket", getCloudHypervisorSocket(domainName)}
+
+	spec, err := ctx.setupSpec(&status, &config, status.OCIConfigDir)
+	if err != nil {
+		return logError("failed to load OCI spec for domain %s: %v", domainName, err)
+	}
+	if err = spec.AddLoader("/containers/services/xen-tools"); err != nil {
+		return logError("failed to add cloud-hypervisor loader to domain %s: %v", domainName, err)
+	}
+	spec.AdjustMemLimit(config, cloudHypervisorOverHead)
+	if config.CPUs != "" {
+		s := spec.Get()
+		if s.Linux != nil && s.Linux.Resources != nil {
+			if s.Linux.Resources.CPU == nil {
+				s.Linux.Resources.CPU = &specs.LinuxCPU{}
+			}
+			s.Linux.Resources.CPU.Cpus = config.CPUs
+		}
+		if s.Annotations == nil {
+			s.Annotations = make(map[string]string)
+		}
+		s.Annotations[containerd.EVEOCICPUsLabel] = config.CPUs
+	}
+	spec.Get().Process.Args = args
+	logrus.Infof("Hypervisor args: %v", args)
+
+	if err := spec.CreateContainer(true); err != nil {
+		return logError("Failed to create container for task %s from %v: %v", domainName, config, err)
+	}
+	return nil
+}
+
+func (ctx cloudHypervisorContext) Start(domainName string, domainID int) error {
+	logrus.Infof("starting cloud-hypervisor domain %s", domainName)
+	if err := ctx.ctrdContext.Start(domainName, domainID); err != nil {
+		logrus.Errorf("couldn't start task for domain %s: %v", domainName, err)
+		return err
+	}
+	socket := getCloudHypervisorSocket(domainName)
+	if err := waitForCloudHypervisor(socket); err != nil {
+		return logError("cloud-hypervisor API for domain %s: %v", domainName, err)
+	}
+	data, err := ioutil.ReadFile(cloudHypervisorStateDir + domainName + "/vm.json")
+	if err != nil {
+		return logError("failed to read config of domain %s: %v", domainName, err)
+	}
+	if _, err := cloudHypervisorRequest(socket, http.MethodPut, "vm.create", data); err != nil {
+		return logError("failed to create domain %s: %v", domainName, err)
+	}
+	if _, err := cloudHypervisorRequest(socket, http.MethodPut, "vm.boot", nil); err != nil {
+		return logError("failed to boot domain %s: %v", domainName, err)
+	}
+	return nil
+}
+
+// Stop presses the ACPI power button of the domain
+func (ctx cloudHypervisorContext) Stop(domainName string, domainID int, force bool) error {
+	socket := getCloudHypervisorSocket(domainName)
+	if _, err := cloudHypervisorRequest(socket, http.MethodPut, "vm.power-button", nil); err != nil {
+		return logError("Stop: failed to press power button of %s: %v", domainName, err)
+	}
+	return nil
+}
+
+func (ctx cloudHypervisorContext) Delete(domainName string, domainID int) (result error) {
+	// regardless of happens to everything else, we have to try and delete the task
+	defer func() {
+		if err := ctx.ctrdContext.Delete(domainName, domainID); err != nil {
+			result = fmt.Errorf("%w; couldn't delete task %s: %v", result, domainName, err)
+		}
+	}()
+
+	stateDir := cloudHypervisorStateDir + domainName
+	// The VMM exits on vmm.shutdown; ignore errors since it may be gone
+	_, _ = cloudHypervisorRequest(getCloudHypervisorSocket(domainName),
+		http.MethodPut, "vmm.shutdown", nil)
+	var vm chVMConfig
+	if data, err := ioutil.ReadFile(stateDir + "/vm.json"); err == nil {
+		if err := json.Unmarshal(data, &vm); err != nil {
+			logrus.Warnf("Delete: bad config of domain %s: %v", domainName, err)
+		}
+	}
+	for _, net := range vm.Net {
+		if err := deleteTap(net.Tap); err != nil {
+			logrus.Warnf("Delete: failed to delete %s of domain %s: %v",
+				net.Tap, domainName, err)
+		}
+	}
+	if err := os.RemoveAll(stateDir); err != nil {
+		return logError("failed to clean up domain state directory %s (%v)", domainName, err)
+	}
+	return nil
+}
+
+func (ctx cloudHypervisorContext) Info(domainName string, domainID int) (int, types.SwState, error) {
+	effectiveDomainID, effectiveDomainState, err := ctx.ctrdContext.Info(domainName, domainID)
+	if err != nil || effectiveDomainState != types.RUNNING {
+		return effectiveDomainID, effectiveDomainState, err
+	}
+
+	// the task is alive, hence ask cloud-hypervisor about the VM
+	stateMap := map[string]types.SwState{
+		"Created":  types.PAUSED,
+		"Running":  types.RUNNING,
+		"Shutdown": types.HALTING,
+		"Paused":   types.PAUSED,
+	}
+	data, err := cloudHypervisorRequest(getCloudHypervisorSocket(domainName),
+		http.MethodGet, "vm.info", nil)
+	if err != nil {
+		return effectiveDomainID, types.BROKEN, logError("couldn't retrieve status for domain %s: %v", domainName, err)
+	}
+	var info struct {
+		State string `json:"state"`
+	}
+	if err := json.Unmarshal(data, &info); err != nil {
+		return effectiveDomainID, types.BROKEN, logError("couldn't parse status for domain %s: %v", domainName, err)
+	}
+	state, matched := stateMap[info.State]
+	if !matched {
+		return effectiveDomainID, types.BROKEN, logError("domain %s reported to be in unexpected state %s", domainName, info.State)
+	}
+	return effectiveDomainID, state, nil
+}
+
+func getCloudHypervisorSocket(domainName string) string {
+	return cloudHypervisorStateDir + domainName + "/api"
+}
+
+// cloudHypervisorRequest sends a request to the REST API of
+// cloud-hypervisor and returns the body of the response
+func cloudHypervisorRequest(socket string, method string, endpoint string,
+	body []byte) ([]byte, error) {
+
+	client := http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		},
+		Timeout: 10 * time.Second,
+	}
+	req, err := http.NewRequest(method, "http://localhost/api/v1/"+endpoint,
+		bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%s %s: %s: %s", method, endpoint, resp.Status,
+			string(data))
+	}
+	return data, nil
+}
+
+func waitForCloudHypervisor(socket string) error {
+	var err error
+	for i := 0; i < 10; i++ {
+		if _, err = cloudHypervisorRequest(socket, http.MethodGet, "vmm.ping", nil); err == nil {
+			return nil
+		}
+		time.Sleep(time.Second)
+	}
+	return err
+}
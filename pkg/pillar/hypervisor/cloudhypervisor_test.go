@@ -0,0 +1,132 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package hypervisor
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	zconfig "github.com/lf-edge/eve/api/go/config"
+	"github.com/lf-edge/eve/pkg/pillar/types"
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestCloudHypervisorCreateDomConfig(t *testing.T) {
+	ctx := cloudHypervisorContext{}
+	config := types.DomainConfig{
+		UUIDandVersion: types.UUIDandVersion{UUID: uuid.NewV4(), Version: "1.0"},
+		VmConfig: types.VmConfig{
+			Kernel:    "/boot/kernel",
+			Ramdisk:   "/boot/initrd",
+			Memory:    512 * 1024,
+			VCpus:     2,
+			RootDev:   "/dev/vda",
+			ExtraArgs: "quiet",
+		},
+		VifList: []types.VifInfo{
+			{Bridge: "bn0", Mac: "6a:00:03:61:a6:90", Vif: "nbu1x1"},
+		},
+	}
+	disks := []types.DiskStatus{
+		{Format: zconfig.Format_QCOW2, FileLocation: "/foo/bar.qcow2", Devtype: "hdd"},
+		{Format: zconfig.Format_RAW, FileLocation: "/foo/data.img", Devtype: "hdd", ReadOnly: true},
+	}
+	conf, err := ioutil.TempFile("/tmp", "config")
+	if err != nil {
+		t.Fatalf("Can't create config file for a domain %v", err)
+	}
+	defer os.Remove(conf.Name())
+
+	if err := ctx.CreateDomConfig("test", config, disks, conf); err != nil {
+		t.Fatalf("CreateDomConfig failed %v", err)
+	}
+	result, err := ioutil.ReadFile(conf.Name())
+	if err != nil {
+		t.Fatalf("reading conf file failed %v", err)
+	}
+	expected := `{
+  "cpus": {
+    "boot_vcpus": 2,
+    "max_vcpus": 2
+  },
+  "memory": {
+    "size": 536870912
+  },
+  "kernel": {
+    "path": "/boot/kernel"
+  },
+  "initramfs": {
+    "path": "/boot/initrd"
+  },
+  "cmdline": {
+    "args": "console=ttyS0 root=/dev/vda quiet"
+  },
+  "disks": [
+    {
+      "path": "/foo/bar.qcow2",
+      "readonly": false,
+      "id": "disk0"
+    },
+    {
+      "path": "/foo/data.img",
+      "readonly": true,
+      "id": "disk1"
+    }
+  ],
+  "net": [
+    {
+      "tap": "nbu1x1",
+      "mac": "6a:00:03:61:a6:90",
+      "id": "nbu1x1"
+    }
+  ],
+  "serial": {
+    "mode": "Tty"
+  },
+  "console": {
+    "mode": "Off"
+  }
+}`
+	if string(result) != expected {
+		t.Errorf("got an unexpected resulting config %s", string(result))
+	}
+}
+
+func TestCloudHypervisorUnsupported(t *testing.T) {
+	ctx := cloudHypervisorContext{}
+	kernel := types.VmConfig{Kernel: "/boot/kernel", Memory: 512 * 1024}
+	testMatrix := map[string]struct {
+		config types.DomainConfig
+		disks  []types.DiskStatus
+	}{
+		"No kernel": {
+			config: types.DomainConfig{VmConfig: types.VmConfig{Memory: 512 * 1024}},
+		},
+		"Assigned adapter": {
+			config: types.DomainConfig{
+				VmConfig:      kernel,
+				IoAdapterList: []types.IoAdapter{{Type: types.IoNetEth, Name: "eth1"}},
+			},
+		},
+		"Container disk": {
+			config: types.DomainConfig{VmConfig: kernel},
+			disks: []types.DiskStatus{
+				{Format: zconfig.Format_CONTAINER, FileLocation: "/foo", Devtype: "9P"},
+			},
+		},
+		"CD-ROM": {
+			config: types.DomainConfig{VmConfig: kernel},
+			disks: []types.DiskStatus{
+				{Format: zconfig.Format_RAW, FileLocation: "/foo.iso", Devtype: "cdrom"},
+			},
+		},
+	}
+	for testname, test := range testMatrix {
+		t.Logf("Running test %s", testname)
+		if _, err := ctx.vmConfig(test.config, test.disks); err == nil {
+			t.Errorf("%s: expected vmConfig to fail", testname)
+		}
+	}
+}
@@ -4,10 +4,13 @@
 package hypervisor
 
 import (
+	"context"
 	"fmt"
 	"github.com/lf-edge/eve/pkg/pillar/containerd"
 	"github.com/lf-edge/eve/pkg/pillar/types"
 	"github.com/opencontainers/runtime-spec/specs-go"
+	"io"
+	"net"
 	"os"
 	"path/filepath"
 	"time"
@@ -71,6 +74,9 @@ func (ctx ctrdContext) setupSpec(status *types.DomainStatus, config *types.Domai
 		return nil, err
 	}
 	spec.UpdateFromDomain(config)
+	if err := spec.UpdateBlockIO(config, status.DiskStatusList); err != nil {
+		return nil, err
+	}
 	spec.UpdateMounts(status.DiskStatusList)
 	spec.UpdateVifList(status.VifList)
 	spec.UpdateEnvVar(status.EnvVariables)
@@ -82,35 +88,60 @@ func (ctx ctrdContext) Setup(status types.DomainStatus, config types.DomainConfi
 	if status.OCIConfigDir == "" {
 		return logError("failed to run domain %s: not based on an OCI image", status.DomainName)
 	}
+	if len(config.PodContainers) != 0 {
+		return ctx.setupPod(status, config)
+	}
 
 	spec, err := ctx.setupSpec(&status, &config, status.OCIConfigDir)
 	if err != nil {
 		return logError("setting up OCI spec for domain %s failed %v", status.DomainName, err)
 	}
+	// only native containers get the security profile: for the other
+	// hypervisors the spec is replaced by the one of their loader
+	if err := spec.UpdateSecurity(&config); err != nil {
+		return logError("security profile of domain %s: %v", status.DomainName, err)
+	}
+	if err := spec.ShiftOwnership(&config, status.DiskStatusList); err != nil {
+		return logError("security profile of domain %s: %v", status.DomainName, err)
+	}
+	// the terminal is what the remote console attaches to
+	if spec.Get().Process != nil {
+		spec.Get().Process.Terminal = config.RemoteConsole
+	}
 
-	vifsTaskResolv := filepath.Join(vifsDir, status.DomainName, "etc", "resolv.conf")
-	err = os.MkdirAll(filepath.Dir(vifsTaskResolv), 0755)
+	resolv, err := taskResolvMount(status.DomainName)
 	if err != nil {
-		return logError("Failed to create directory for vifs task %s with err: %s",
+		return err
+	}
+	spec.Get().Mounts = append(spec.Get().Mounts, resolv)
+
+	if err := spec.CreateContainer(true); err != nil {
+		return logError("Failed to create container for task %s from %v: %v", status.DomainName, config, err)
+	}
+
+	return nil
+}
+
+// taskResolvMount returns the bind mount of the resolv.conf of the domain,
+// which is filled in once its VIFs are up
+func taskResolvMount(domainName string) (specs.Mount, error) {
+	vifsTaskResolv := filepath.Join(vifsDir, domainName, "etc", "resolv.conf")
+	err := os.MkdirAll(filepath.Dir(vifsTaskResolv), 0755)
+	if err != nil {
+		return specs.Mount{}, logError("Failed to create directory for vifs task %s with err: %s",
 			filepath.Dir(vifsTaskResolv), err)
 	}
 	f, err := os.OpenFile(vifsTaskResolv, os.O_WRONLY|os.O_CREATE|os.O_SYNC, 0755)
 	if err != nil {
-		return logError("Failed creating empty resolv.conf file %s with err: %s", vifsTaskResolv, err)
+		return specs.Mount{}, logError("Failed creating empty resolv.conf file %s with err: %s", vifsTaskResolv, err)
 	}
-	defer f.Close()
+	f.Close()
 
-	spec.Get().Mounts = append(spec.Get().Mounts, specs.Mount{
+	return specs.Mount{
 		Type:        "bind",
 		Source:      vifsTaskResolv,
 		Destination: "/etc/resolv.conf",
-		Options:     []string{"rbind", "ro"}})
-
-	if err := spec.CreateContainer(true); err != nil {
-		return logError("Failed to create container for task %s from %v: %v", status.DomainName, config, err)
-	}
-
-	return nil
+		Options:     []string{"rbind", "ro"}}, nil
 }
 
 func (ctx ctrdContext) Create(domainName string, cfgFilename string, config *types.DomainConfig) (int, error) {
@@ -122,7 +153,11 @@ func (ctx ctrdContext) Create(domainName string, cfgFilename string, config *typ
 	defer done()
 	_ = ctx.ctrdClient.CtrStopContainer(ctrdCtx, domainName, true)
 
-	return ctx.ctrdClient.CtrCreateTask(ctrdCtx, domainName)
+	pid, err := ctx.ctrdClient.CtrCreateTaskWithLog(ctrdCtx, domainName, podLogName(domainName))
+	if err != nil {
+		return pid, err
+	}
+	return pid, ctx.createPod(domainName, pid)
 }
 
 func (ctx ctrdContext) Start(domainName string, domainID int) error {
@@ -132,26 +167,40 @@ func (ctx ctrdContext) Start(domainName string, domainID int) error {
 	if err != nil {
 		return err
 	}
+	if err := ctx.waitSteadyState(domainName); err != nil {
+		return err
+	}
+	return ctx.startPod(domainName)
+}
 
-	// now lets wait for task to reach a steady state or for >10sec to elapse
+// waitSteadyState waits for the task to reach a steady state or for >10sec to elapse
+func (ctx ctrdContext) waitSteadyState(containerID string) error {
+	ctrdCtx, done := ctx.ctrdClient.CtrNewUserServicesCtx()
+	defer done()
 	for i := 0; i < 10; i++ {
-		_, _, status, err := ctx.ctrdClient.CtrContainerInfo(ctrdCtx, domainName)
+		_, _, status, err := ctx.ctrdClient.CtrContainerInfo(ctrdCtx, containerID)
 		if err == nil && (status == "running" || status == "stopped" || status == "paused") {
 			return nil
 		}
 		time.Sleep(time.Second)
 	}
 
-	return fmt.Errorf("task %s couldn't reach a steady state in time", domainName)
+	return fmt.Errorf("task %s couldn't reach a steady state in time", containerID)
 }
 
 func (ctx ctrdContext) Stop(domainName string, domainID int, force bool) error {
+	// the primary container of a pod holds the network namespace, so it
+	// goes last
+	ctx.stopPod(domainName, force)
 	ctrdCtx, done := ctx.ctrdClient.CtrNewUserServicesCtx()
 	defer done()
 	return ctx.ctrdClient.CtrStopContainer(ctrdCtx, domainName, force)
 }
 
 func (ctx ctrdContext) Delete(domainName string, domainID int) error {
+	if err := ctx.deletePod(domainName); err != nil {
+		return err
+	}
 	ctrdCtx, done := ctx.ctrdClient.CtrNewUserServicesCtx()
 	defer done()
 	if err := ctx.ctrdClient.CtrDeleteContainer(ctrdCtx, domainName); err != nil {
@@ -181,20 +230,16 @@ func (ctx ctrdContext) Info(domainName string, domainID int) (int, types.SwState
 	if status == "stopped" && exit != 0 {
 		return domainID, types.BROKEN, logError("task broke with exit status %d", exit)
 	}
+	if err := ctx.podBroken(domainName); err != nil {
+		return domainID, types.BROKEN, logError("domain %s: %v", domainName, err)
+	}
 
 	if effectiveDomainID != domainID {
 		logrus.Warnf("containerd domain %s with PID %d (different from expected %d) is %s",
 			domainName, effectiveDomainID, domainID, status)
 	}
 
-	stateMap := map[string]types.SwState{
-		"created": types.INSTALLED,
-		"running": types.RUNNING,
-		"pausing": types.PAUSING,
-		"paused":  types.PAUSED,
-		"stopped": types.HALTED,
-	}
-	if effectiveDomainState, matched := stateMap[status]; !matched {
+	if effectiveDomainState, matched := containerStates[status]; !matched {
 		err := fmt.Errorf("task %s happens to be in an unexpected state %s",
 			domainName, status)
 		logrus.Error(err)
@@ -204,6 +249,66 @@ func (ctx ctrdContext) Info(domainName string, domainID int) (int, types.SwState
 	}
 }
 
+var containerStates = map[string]types.SwState{
+	"created": types.INSTALLED,
+	"running": types.RUNNING,
+	"pausing": types.PAUSING,
+	"paused":  types.PAUSED,
+	"stopped": types.HALTED,
+}
+
+// containerState maps the status of a containerd task to a SwState
+func containerState(status string, exit int) types.SwState {
+	if status == "stopped" && exit != 0 {
+		return types.BROKEN
+	}
+	if state, matched := containerStates[status]; matched {
+		return state
+	}
+	return types.UNKNOWN
+}
+
+// OpenConsole attaches to the terminal of the task of the container,
+// which it only has if remote console was enabled when it was created
+func (ctx ctrdContext) OpenConsole(domainName string) (io.ReadWriteCloser, error) {
+	conn, err := net.Dial("unix", containerd.ConsoleSocket(domainName))
+	if err != nil {
+		return nil, logError("OpenConsole: %s has no terminal, restart it if remote console was just enabled: %v",
+			domainName, err)
+	}
+	return conn, nil
+}
+
+// execTTY runs args in the given user (or EVE's own, if system is set)
+// container with a terminal attached to the returned console
+func (ctx ctrdContext) execTTY(system bool, containerID string, args []string) (io.ReadWriteCloser, error) {
+	var ctrdCtx context.Context
+	var done context.CancelFunc
+	if system {
+		ctrdCtx, done = ctx.ctrdClient.CtrNewSystemServicesCtx()
+	} else {
+		ctrdCtx, done = ctx.ctrdClient.CtrNewUserServicesCtx()
+	}
+	tty, err := ctx.ctrdClient.CtrExecTTY(ctrdCtx, containerID, args)
+	if err != nil {
+		done()
+		return nil, logError("execTTY: %v in %s failed: %v", args, containerID, err)
+	}
+	return &ttyConsole{ReadWriteCloser: tty, done: done}, nil
+}
+
+// ttyConsole releases the containerd context once the terminal is closed
+type ttyConsole struct {
+	io.ReadWriteCloser
+	done context.CancelFunc
+}
+
+func (c *ttyConsole) Close() error {
+	err := c.ReadWriteCloser.Close()
+	c.done()
+	return err
+}
+
 func (ctx ctrdContext) PCIReserve(long string) error {
 	if ctx.PCI[long] {
 		return fmt.Errorf("PCI %s is already reserved", long)
@@ -273,13 +378,20 @@ func (ctx ctrdContext) GetDomsCPUMem() (map[string]types.DomainMetric, error) {
 			logrus.Errorf("GetDomsCPUMem failed with error %v", err)
 		}
 
+		throttling, err := containerd.GetThrottling(id)
+		if err != nil {
+			logrus.Errorf("GetDomsCPUMem failed to get throttling of %s: %v", id, err)
+		}
+
 		res[id] = types.DomainMetric{
 			UUIDandVersion:    types.UUIDandVersion{},
 			CPUTotal:          cpuTotal,
 			UsedMemory:        usedMem,
 			AvailableMemory:   availMem,
 			UsedMemoryPercent: usedMemPerc,
+			Throttling:        throttling,
 		}
 	}
+	podMetrics(res)
 	return res, nil
 }
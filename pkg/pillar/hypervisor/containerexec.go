@@ -0,0 +1,65 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package hypervisor
+
+// Commands run in, and files copied to and from, the containers of the
+// container domains on request of domainmgr (see types.AppExecConfig).
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// podContainerID returns the id of the container of the domain with the
+// given name, the primary one for no name
+func podContainerID(p *pod, domainName string, name string) (string, error) {
+	if name == "" {
+		return domainName, nil
+	}
+	if p == nil {
+		return "", fmt.Errorf("%s is not a pod", domainName)
+	}
+	for _, m := range p.Members {
+		if m.Name == name {
+			return m.ID, nil
+		}
+	}
+	return "", fmt.Errorf("pod %s has no container %s", domainName, name)
+}
+
+// ContainerExec runs args in a container of the domain
+func (ctx ctrdContext) ContainerExec(domainName string, container string, args []string,
+	stdout io.Writer, stderr io.Writer, timeout time.Duration) (int, bool, error) {
+
+	id, err := podContainerID(loadPod(domainName), domainName, container)
+	if err != nil {
+		return 0, false, err
+	}
+	ctrdCtx, done := ctx.ctrdClient.CtrNewUserServicesCtx()
+	defer done()
+	return ctx.ctrdClient.CtrExecWithTimeout(ctrdCtx, id, args, stdout, stderr, timeout)
+}
+
+// ContainerReadFile reads a file in a container of the domain
+func (ctx ctrdContext) ContainerReadFile(domainName string, container string, path string, limit int64) ([]byte, bool, error) {
+	id, err := podContainerID(loadPod(domainName), domainName, container)
+	if err != nil {
+		return nil, false, err
+	}
+	ctrdCtx, done := ctx.ctrdClient.CtrNewUserServicesCtx()
+	defer done()
+	return ctx.ctrdClient.CtrReadFile(ctrdCtx, id, path, limit)
+}
+
+// ContainerWriteFile writes a file in a container of the domain
+func (ctx ctrdContext) ContainerWriteFile(domainName string, container string, path string, data []byte) error {
+	id, err := podContainerID(loadPod(domainName), domainName, container)
+	if err != nil {
+		return err
+	}
+	ctrdCtx, done := ctx.ctrdClient.CtrNewUserServicesCtx()
+	defer done()
+	return ctx.ctrdClient.CtrWriteFile(ctrdCtx, id, path, data)
+}
@@ -0,0 +1,41 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package hypervisor
+
+import (
+	"testing"
+)
+
+func TestPodContainerID(t *testing.T) {
+	p := &pod{Members: []podMember{
+		{ID: "app.1", Name: "web"},
+		{ID: "app.1.db", Name: "db"},
+	}}
+	testMatrix := map[string]struct {
+		pod      *pod
+		name     string
+		expected string
+		fail     bool
+	}{
+		"no pod":          {name: "", expected: "app.1"},
+		"no pod but name": {name: "db", fail: true},
+		"primary":         {pod: p, name: "", expected: "app.1"},
+		"sidecar":         {pod: p, name: "db", expected: "app.1.db"},
+		"unknown":         {pod: p, name: "cache", fail: true},
+	}
+	for testname, test := range testMatrix {
+		id, err := podContainerID(test.pod, "app.1", test.name)
+		if test.fail {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %s", testname, id)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", testname, err)
+		} else if id != test.expected {
+			t.Errorf("%s: got %s, expected %s", testname, id, test.expected)
+		}
+	}
+}
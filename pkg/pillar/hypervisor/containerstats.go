@@ -0,0 +1,138 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package hypervisor
+
+// Per container stats of the container domains, reported the same way
+// zedrouter reports the containers of docker-in-VM apps. They come from the
+// cgroup metrics containerd collects for the task and, for the network, from
+// the interfaces in the network namespace of the task.
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	v1stat "github.com/containerd/cgroups/stats/v1"
+	"github.com/lf-edge/eve/pkg/pillar/types"
+	"github.com/sirupsen/logrus"
+)
+
+// ContainerStats returns the stats of the containers of the domain, with the
+// containers of a pod in start order. The container of a domain which is not
+// a pod has no name. The containers of a pod share the network namespace,
+// hence its traffic is only reported for the primary container.
+func (ctx ctrdContext) ContainerStats(domainName string) ([]types.AppContainerStats, error) {
+	members := []podMember{{ID: domainName}}
+	if p := loadPod(domainName); p != nil {
+		members = p.Members
+	}
+	ctrdCtx, done := ctx.ctrdClient.CtrNewUserServicesCtx()
+	defer done()
+	ret := make([]types.AppContainerStats, 0, len(members))
+	for i, m := range members {
+		pid, _, status, err := ctx.ctrdClient.CtrContainerInfo(ctrdCtx, m.ID)
+		if err != nil {
+			return nil, err
+		}
+		var metric *v1stat.Metrics
+		if pid != 0 {
+			if metric, err = ctx.ctrdClient.CtrGetContainerMetrics(ctrdCtx, m.ID); err != nil {
+				logrus.Errorf("ContainerStats(%s): %v", m.ID, err)
+			}
+		}
+		stats := containerStats(m.Name, status, metric)
+		if i == 0 && pid != 0 {
+			if stats.TxBytes, stats.RxBytes, err = taskNetStats(pid); err != nil {
+				logrus.Errorf("ContainerStats(%s): %v", m.ID, err)
+			}
+		}
+		ret = append(ret, stats)
+	}
+	return ret, nil
+}
+
+// containerStats converts the cgroup metrics of a task, if any, into the
+// stats of its container. The memory limit of a container without one is
+// not reported.
+func containerStats(name string, status string, metric *v1stat.Metrics) types.AppContainerStats {
+	stats := types.AppContainerStats{ContainerName: name, Status: status}
+	if metric == nil {
+		return stats
+	}
+	if metric.Pids != nil {
+		stats.Pids = uint32(metric.Pids.Current)
+	}
+	if metric.CPU != nil && metric.CPU.Usage != nil {
+		stats.CPUTotal = metric.CPU.Usage.Total / nanoSecToSec
+	}
+	if metric.Memory != nil && metric.Memory.Usage != nil {
+		stats.UsedMem = uint32(roundFromBytesToMbytes(metric.Memory.Usage.Usage))
+		if limit := roundFromBytesToMbytes(metric.Memory.Usage.Limit); limit <= math.MaxUint32 {
+			stats.AvailMem = uint32(limit)
+		}
+	}
+	if metric.Blkio != nil {
+		var read, write uint64
+		for _, entry := range metric.Blkio.IoServiceBytesRecursive {
+			switch strings.ToLower(entry.Op) {
+			case "read":
+				read += entry.Value
+			case "write":
+				write += entry.Value
+			}
+		}
+		stats.ReadBytes = roundFromBytesToMbytes(read)
+		stats.WriteBytes = roundFromBytesToMbytes(write)
+	}
+	return stats
+}
+
+// taskNetStats returns the bytes sent and received over the interfaces in
+// the network namespace of the process
+func taskNetStats(pid int) (uint64, uint64, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/net/dev", pid))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	return parseNetDev(f)
+}
+
+// parseNetDev sums up the bytes sent and received in the format of
+// /proc/net/dev, leaving out the loopback interface
+func parseNetDev(r io.Reader) (uint64, uint64, error) {
+	var tx, rx uint64
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), ":", 2)
+		if len(fields) != 2 {
+			// one of the two header lines
+			continue
+		}
+		if strings.TrimSpace(fields[0]) == "lo" {
+			continue
+		}
+		// receive bytes, packets, errs, drop, fifo, frame, compressed,
+		// multicast, then transmit bytes, ...
+		counters := strings.Fields(fields[1])
+		if len(counters) < 9 {
+			return 0, 0, fmt.Errorf("bad interface line %q", scanner.Text())
+		}
+		ifRx, err := strconv.ParseUint(counters[0], 10, 64)
+		if err != nil {
+			return 0, 0, err
+		}
+		ifTx, err := strconv.ParseUint(counters[8], 10, 64)
+		if err != nil {
+			return 0, 0, err
+		}
+		rx += ifRx
+		tx += ifTx
+	}
+	return tx, rx, scanner.Err()
+}
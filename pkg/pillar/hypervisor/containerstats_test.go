@@ -0,0 +1,89 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package hypervisor
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	v1stat "github.com/containerd/cgroups/stats/v1"
+	"github.com/lf-edge/eve/pkg/pillar/types"
+)
+
+func TestContainerStats(t *testing.T) {
+	testMatrix := map[string]struct {
+		metric   *v1stat.Metrics
+		expected types.AppContainerStats
+	}{
+		"no metrics": {
+			expected: types.AppContainerStats{ContainerName: "web", Status: "stopped"},
+		},
+		"all metrics": {
+			metric: &v1stat.Metrics{
+				Pids: &v1stat.PidsStat{Current: 7},
+				CPU:  &v1stat.CPUStat{Usage: &v1stat.CPUUsage{Total: 42 * nanoSecToSec}},
+				Memory: &v1stat.MemoryStat{Usage: &v1stat.MemoryEntry{
+					Usage: 64 << 20,
+					Limit: 256 << 20,
+				}},
+				Blkio: &v1stat.BlkIOStat{IoServiceBytesRecursive: []*v1stat.BlkIOEntry{
+					{Op: "Read", Value: 3 << 20},
+					{Op: "Write", Value: 1 << 20},
+					{Op: "Read", Value: 2 << 20},
+					{Op: "Total", Value: 6 << 20},
+				}},
+			},
+			expected: types.AppContainerStats{
+				ContainerName: "web",
+				Status:        "stopped",
+				Pids:          7,
+				CPUTotal:      42,
+				UsedMem:       64,
+				AvailMem:      256,
+				ReadBytes:     5,
+				WriteBytes:    1,
+			},
+		},
+		"no memory limit": {
+			metric: &v1stat.Metrics{
+				Memory: &v1stat.MemoryStat{Usage: &v1stat.MemoryEntry{
+					Usage: 64 << 20,
+					Limit: 0x7FFFFFFFFFFFF000,
+				}},
+			},
+			expected: types.AppContainerStats{
+				ContainerName: "web",
+				Status:        "stopped",
+				UsedMem:       64,
+			},
+		},
+	}
+	for testname, test := range testMatrix {
+		t.Logf("Running test case %s", testname)
+		stats := containerStats("web", "stopped", test.metric)
+		if !reflect.DeepEqual(stats, test.expected) {
+			t.Errorf("%s: got %+v, expected %+v", testname, stats, test.expected)
+		}
+	}
+}
+
+func TestParseNetDev(t *testing.T) {
+	netDev := `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo:    1000      10    0    0    0     0          0         0     1000      10    0    0    0     0       0          0
+  eth0: 2000000    1500    0    0    0     0          0         0   300000     900    0    0    0     0       0          0
+  eth1:     500       5    0    0    0     0          0         0       70       1    0    0    0     0       0          0
+`
+	tx, rx, err := parseNetDev(strings.NewReader(netDev))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if tx != 300070 || rx != 2000500 {
+		t.Errorf("got tx %d rx %d, expected tx 300070 rx 2000500", tx, rx)
+	}
+	if _, _, err := parseNetDev(strings.NewReader("eth0: 1 2 3\n")); err == nil {
+		t.Errorf("expected an error for a truncated line")
+	}
+}
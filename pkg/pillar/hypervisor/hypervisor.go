@@ -9,7 +9,9 @@ import (
 	"github.com/shirou/gopsutil/cpu"
 	"github.com/shirou/gopsutil/mem"
 	"github.com/sirupsen/logrus"
+	"io"
 	"os"
+	"time"
 )
 
 // Hypervisor provides methods for manipulating domains on the host
@@ -27,21 +29,111 @@ type Hypervisor interface {
 	GetCapabilities() (*types.Capabilities, error)
 }
 
+// MemoryResizer is implemented by the tasks which can grow and shrink the
+// memory of a running domain by ballooning, between the Memory it was
+// started with and its MaxMem
+type MemoryResizer interface {
+	// SetMemoryTarget sets the memory of the domain to targetKB kbytes
+	SetMemoryTarget(domainName string, domainID int, targetKB int) error
+}
+
+// Hotplugger is implemented by the tasks which can add disks and network
+// interfaces to, and remove them from, a running domain. The domain must
+// have been set up with DomainConfig.HotplugSlots spare slots.
+type Hotplugger interface {
+	AttachDisk(status types.DomainStatus, disk types.DiskStatus) error
+	DetachDisk(status types.DomainStatus, disk types.DiskStatus) error
+	AttachVif(status types.DomainStatus, vif types.VifInfo) error
+	DetachVif(status types.DomainStatus, vif types.VifInfo) error
+}
+
+// GuestAgent is implemented by the tasks which can talk to an agent running
+// inside the domain. All of them fail if no agent is running.
+type GuestAgent interface {
+	// GuestShutdown asks the guest OS to power off
+	GuestShutdown(domainName string) error
+	// GuestReboot asks the guest OS to reboot, leaving the domain running
+	GuestReboot(domainName string) error
+	GuestInfo(domainName string) (types.GuestInfo, error)
+	// GuestFsFreeze flushes and freezes the filesystems of the guest so
+	// that a snapshot of its disks is consistent. Writes in the guest block
+	// until GuestFsThaw, which must follow even if the snapshot failed.
+	GuestFsFreeze(domainName string) error
+	GuestFsThaw(domainName string) error
+}
+
+// ConsoleOpener is implemented by the tasks which can attach to the
+// console of a running domain. Writes to the returned console are input
+// for the domain and reads return its output.
+type ConsoleOpener interface {
+	OpenConsole(domainName string) (io.ReadWriteCloser, error)
+}
+
+// CrashDumper is implemented by the tasks which learn about a kernel panic
+// in the guest. A panicked domain is kept around, but not running, until it
+// is deleted so that its memory can be saved.
+type CrashDumper interface {
+	Panicked(domainName string) bool
+	// DumpGuestMemory writes the memory of a panicked domain to file
+	DumpGuestMemory(domainName string, file string) error
+}
+
+// PodInspector is implemented by the tasks which can run a container domain
+// as a pod of several containers (see DomainConfig.PodContainers)
+type PodInspector interface {
+	// PodInfo returns the state of each container of the pod in the
+	// order they were started, or nothing if the domain is not a pod
+	PodInfo(domainName string) ([]types.PodContainerStatus, error)
+}
+
+// ContainerStatsCollector is implemented by the tasks which run container
+// domains natively and can report the usage of each of their containers
+type ContainerStatsCollector interface {
+	ContainerStats(domainName string) ([]types.AppContainerStats, error)
+}
+
+// InitContainerRunner is implemented by the tasks which can run the init
+// containers of a domain on the host before it is created
+type InitContainerRunner interface {
+	// RunInitContainer runs the init container to completion with its
+	// output written to output, killing it after timeout or once stop is
+	// closed. Returns its exit code and whether it timed out.
+	RunInitContainer(status types.DomainStatus, config types.DomainConfig, c types.InitContainer,
+		output io.Writer, timeout time.Duration, stop <-chan struct{}) (int, bool, error)
+}
+
+// ContainerExecer is implemented by the tasks which run container domains
+// natively and can run commands in, and copy files to and from, their
+// containers. container is the name of a pod container, or empty for the
+// container of the domain itself or the primary one of a pod.
+type ContainerExecer interface {
+	// ContainerExec runs args with their output written to stdout and
+	// stderr, killing them after timeout. Returns the exit code and
+	// whether they were killed.
+	ContainerExec(domainName string, container string, args []string,
+		stdout io.Writer, stderr io.Writer, timeout time.Duration) (int, bool, error)
+	// ContainerReadFile reads up to limit bytes of a file, returning true
+	// if there is more
+	ContainerReadFile(domainName string, container string, path string, limit int64) ([]byte, bool, error)
+	ContainerWriteFile(domainName string, container string, path string, data []byte) error
+}
+
 type hypervisorDesc struct {
 	constructor func() Hypervisor
 	dom0handle  string
 }
 
 var knownHypervisors = map[string]hypervisorDesc{
-	"xen":        {constructor: newXen, dom0handle: "/proc/xen"},
-	"kvm":        {constructor: newKvm, dom0handle: "/dev/kvm"},
-	"acrn":       {constructor: newAcrn, dom0handle: "/dev/acrn"},
-	"containerd": {constructor: newContainerd, dom0handle: "/run/containerd/containerd.sock"},
-	"null":       {constructor: newNull, dom0handle: "/"},
+	"xen":              {constructor: newXen, dom0handle: "/proc/xen"},
+	"kvm":              {constructor: newKvm, dom0handle: "/dev/kvm"},
+	"acrn":             {constructor: newAcrn, dom0handle: "/dev/acrn"},
+	"cloud-hypervisor": {constructor: newCloudHypervisor, dom0handle: "/dev/kvm"},
+	"containerd":       {constructor: newContainerd, dom0handle: "/run/containerd/containerd.sock"},
+	"null":             {constructor: newNull, dom0handle: "/"},
 }
 
 // this is a priority order to pick a default hypervisor if multiple are availabel (more to less likely)
-var hypervisorPriority = []string{"xen", "kvm", "acrn", "containerd", "null"}
+var hypervisorPriority = []string{"xen", "kvm", "acrn", "cloud-hypervisor", "containerd", "null"}
 
 // GetHypervisor returns a particular hypervisor implementation
 func GetHypervisor(hint string) (Hypervisor, error) {
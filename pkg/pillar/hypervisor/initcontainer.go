@@ -0,0 +1,95 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package hypervisor
+
+// Init containers. Before a domain is created each of its InitContainers is
+// run to completion as a container of its own on the host, named
+// <domain>.init.<name>, with the volumes in its Mounts and the VIFs of the
+// domain, which are released again when it is deleted. This works the same
+// whatever the domain runs in, since all the hypervisors run containers.
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	zconfig "github.com/lf-edge/eve/api/go/config"
+	"github.com/lf-edge/eve/pkg/pillar/types"
+	"github.com/sirupsen/logrus"
+)
+
+// initContainerID returns the id of the container of the init container
+func initContainerID(domainName string, name string) string {
+	return fmt.Sprintf("%s.init.%s", domainName, name)
+}
+
+// RunInitContainer creates the container of the init container, runs it to
+// completion, or until stop is closed, and deletes it
+func (ctx ctrdContext) RunInitContainer(status types.DomainStatus, config types.DomainConfig,
+	c types.InitContainer, output io.Writer, timeout time.Duration,
+	stop <-chan struct{}) (int, bool, error) {
+
+	if !podContainerName.MatchString(c.Name) {
+		return 0, false, logError("domain %s: invalid init container name %q",
+			status.DomainName, c.Name)
+	}
+	if c.ImageDisk < 0 || c.ImageDisk >= len(status.DiskStatusList) ||
+		status.DiskStatusList[c.ImageDisk].Format != zconfig.Format_CONTAINER {
+		return 0, false, logError("init container %s of %s: disk %d is not a container image",
+			c.Name, status.DomainName, c.ImageDisk)
+	}
+	disks, err := podMounts(status.DiskStatusList, c.Mounts)
+	if err != nil {
+		return 0, false, logError("init container %s of %s: %v", c.Name, status.DomainName, err)
+	}
+	id := initContainerID(status.DomainName, c.Name)
+	spec, err := ctx.ctrdClient.NewOciSpec(id)
+	if err != nil {
+		return 0, false, logError("setting up OCI spec for init container %s failed %v", id, err)
+	}
+	if err := spec.UpdateFromVolume(status.DiskStatusList[c.ImageDisk].FileLocation); err != nil {
+		return 0, false, logError("setting up OCI spec for init container %s failed %v", id, err)
+	}
+	spec.UpdateFromDomain(&config)
+	if err := spec.UpdateBlockIO(&config, status.DiskStatusList); err != nil {
+		return 0, false, logError("setting up OCI spec for init container %s failed %v", id, err)
+	}
+	if err := spec.UpdateSecurity(&config); err != nil {
+		return 0, false, logError("setting up OCI spec for init container %s failed %v", id, err)
+	}
+	if err := spec.UpdateMounts(disks); err != nil {
+		return 0, false, logError("setting up OCI spec for init container %s failed %v", id, err)
+	}
+	if err := spec.ShiftOwnership(&config, disks); err != nil {
+		return 0, false, logError("setting up OCI spec for init container %s failed %v", id, err)
+	}
+	spec.UpdateVifList(status.VifList)
+	spec.UpdateEnvVar(status.EnvVariables)
+	spec.UpdateEnvVar(c.Env)
+	if len(c.Command) != 0 {
+		spec.Get().Process.Args = c.Command
+	}
+	resolv, err := taskResolvMount(id)
+	if err != nil {
+		return 0, false, err
+	}
+	spec.Get().Mounts = append(spec.Get().Mounts, resolv)
+	if err := spec.CreateContainer(true); err != nil {
+		return 0, false, logError("Failed to create init container %s: %v", id, err)
+	}
+
+	ctrdCtx, done := ctx.ctrdClient.CtrNewUserServicesCtx()
+	defer done()
+	defer func() {
+		if err := ctx.ctrdClient.CtrDeleteContainer(ctrdCtx, id); err != nil {
+			logrus.Warnf("RunInitContainer(%s): deleting container failed: %v", id, err)
+		}
+		if err := os.RemoveAll(filepath.Join(vifsDir, id)); err != nil {
+			logrus.Warnf("RunInitContainer(%s): %v", id, err)
+		}
+	}()
+	return ctx.ctrdClient.CtrRunTaskWithTimeout(ctrdCtx, id, output, timeout, stop)
+}
@@ -5,7 +5,9 @@ package hypervisor
 
 import (
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"os"
 	"runtime"
 	"strings"
@@ -16,6 +18,7 @@ import (
 	"github.com/lf-edge/eve/pkg/pillar/agentlog"
 	"github.com/lf-edge/eve/pkg/pillar/containerd"
 	"github.com/lf-edge/eve/pkg/pillar/types"
+	"github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/sirupsen/logrus"
 )
 
@@ -25,6 +28,8 @@ const qemuOverHead = int64(600 * 1024 * 1024)
 
 const minUringKernelTag = uint64((5 << 16) | (4 << 8) | (72 << 0))
 
+const qemuAioType = "io_uring"
+
 // We build device model around PCIe topology according to best practices
 //    https://github.com/qemu/qemu/blob/master/docs/pcie.txt
 // and
@@ -149,6 +154,22 @@ const qemuConfTemplate = `# This file is automatically generated by domainmgr
   chardev = "charserial0"
   name = "org.lfedge.eve.console.0"
 
+[chardev "charqga"]
+  backend = "socket"
+  path = "` + kvmStateDir + `{{.DisplayName}}/qga"
+  server = "on"
+  wait = "off"
+
+[device "qga0"]
+  driver = "virtserialport"
+  chardev = "charqga"
+  name = "org.qemu.guest_agent.0"
+{{- if ne .Machine "virt" }}
+
+[device "pvpanic"]
+  driver = "pvpanic"
+{{- end}}
+
 {{if .EnableVnc}}
 [vnc "default"]
   vnc = "0.0.0.0:{{if .VncDisplay}}{{.VncDisplay}}{{else}}0{{end}}"
@@ -206,6 +227,23 @@ const qemuConfTemplate = `# This file is automatically generated by domainmgr
   driver = "usb-mouse"
   bus = "usb.0"
   port = "2"
+{{end}}
+{{- if .EnableVTPM}}
+[chardev "chrtpm"]
+  backend = "socket"
+  path = "{{.VTPMSocket}}"
+
+[tpmdev "tpm0"]
+  type = "emulator"
+  chardev = "chrtpm"
+
+[device "tpm-tpm0"]
+{{- if eq .Machine "virt"}}
+  driver = "tpm-tis-device"
+{{- else}}
+  driver = "tpm-crb"
+{{- end}}
+  tpmdev = "tpm0"
 {{end}}`
 
 //   multidevs = "remap"
@@ -294,6 +332,30 @@ const qemuNetTemplate = `
   addr = "0x0"
 `
 
+const qemuBalloonTemplate = `
+[device "pci.{{.PCIId}}"]
+  driver = "pcie-root-port"
+  port = "1{{.PCIId}}"
+  chassis = "{{.PCIId}}"
+  bus = "pcie.0"
+  multifunction = "on"
+  addr = "{{printf "0x%x" .PCIId}}"
+
+[device "balloon0"]
+  driver = "virtio-balloon-pci"
+  bus = "pci.{{.PCIId}}"
+  addr = "0x0"
+`
+
+const qemuHotplugTemplate = `
+[device "hotplug.{{.Slot}}"]
+  driver = "pcie-root-port"
+  port = "1{{.PCIId}}"
+  chassis = "{{.PCIId}}"
+  bus = "pcie.0"
+  addr = "{{printf "0x%x" .PCIId}}"
+`
+
 const qemuPciPassthruTemplate = `
 [device "pci.{{.PCIId}}"]
   driver = "pcie-root-port"
@@ -330,6 +392,9 @@ const qemuUsbHostTemplate = `
 `
 
 const kvmStateDir = "/run/hypervisor/kvm/"
+
+// panickedFile is created in the state directory of a domain on GUEST_PANICKED
+const panickedFile = "panicked"
 const sysfsPciDevices = "/sys/bus/pci/devices/"
 const sysfsVfioPciBind = "/sys/bus/pci/drivers/vfio-pci/bind"
 const sysfsPciDriversProbe = "/sys/bus/pci/drivers_probe"
@@ -470,6 +535,21 @@ func (ctx kvmContext) Setup(status types.DomainStatus, config types.DomainConfig
 	}
 
 	spec.AdjustMemLimit(config, qemuOverHead)
+	if config.CPUs != "" {
+		// Keep qemu and its I/O threads on the CPUs of the domain;
+		// the vCPU threads are pinned one by one in Start
+		s := spec.Get()
+		if s.Linux != nil && s.Linux.Resources != nil {
+			if s.Linux.Resources.CPU == nil {
+				s.Linux.Resources.CPU = &specs.LinuxCPU{}
+			}
+			s.Linux.Resources.CPU.Cpus = config.CPUs
+		}
+		if s.Annotations == nil {
+			s.Annotations = make(map[string]string)
+		}
+		s.Annotations[containerd.EVEOCICPUsLabel] = config.CPUs
+	}
 	spec.Get().Process.Args = args
 	logrus.Infof("Hypervisor args: %v", args)
 
@@ -487,6 +567,11 @@ func (ctx kvmContext) CreateDomConfig(domainName string, config types.DomainConf
 		types.DomainConfig
 	}{ctx.devicemodel, config}
 	tmplCtx.Memory = (config.Memory + 1023) / 1024
+	if config.Ballooning() {
+		// Boot with all of it; domainmgr balloons it down
+		// to Memory once the domain is running
+		tmplCtx.Memory = (config.MaxMem + 1023) / 1024
+	}
 	tmplCtx.DisplayName = domainName
 
 	// render global device model settings
@@ -501,7 +586,7 @@ func (ctx kvmContext) CreateDomConfig(domainName string, config types.DomainConf
 		PCIId, DiskID, SATAId int
 		AioType               string
 		types.DiskStatus
-	}{Machine: ctx.devicemodel, PCIId: 4, DiskID: 0, SATAId: 0, AioType: "io_uring"}
+	}{Machine: ctx.devicemodel, PCIId: 4, DiskID: 0, SATAId: 0, AioType: qemuAioType}
 
 	t, _ = template.New("qemuDisk").
 		Funcs(template.FuncMap{"Fmt": func(f zconfig.Format) string { return strings.ToLower(f.String()) }}).
@@ -607,6 +692,31 @@ func (ctx kvmContext) CreateDomConfig(domainName string, config types.DomainConf
 			pciPTContext.PCIId = pciPTContext.PCIId + 1
 		}
 	}
+	pciID := netContext.PCIId + len(pciAssignments)
+	if config.Ballooning() {
+		balloonContext := struct {
+			PCIId int
+		}{PCIId: pciID}
+
+		t, _ = template.New("qemuBalloon").Parse(qemuBalloonTemplate)
+		if err := t.Execute(file, balloonContext); err != nil {
+			return logError("can't write balloon device to config file %s (%v)", file.Name(), err)
+		}
+		pciID = pciID + 1
+	}
+	if config.HotplugSlots != 0 {
+		hotplugContext := struct {
+			PCIId, Slot int
+		}{PCIId: pciID, Slot: 0}
+
+		t, _ = template.New("qemuHotplug").Parse(qemuHotplugTemplate)
+		for hotplugContext.Slot = 0; hotplugContext.Slot < config.HotplugSlots; hotplugContext.Slot++ {
+			if err := t.Execute(file, hotplugContext); err != nil {
+				return logError("can't write hot-plug slot to config file %s (%v)", file.Name(), err)
+			}
+			hotplugContext.PCIId = hotplugContext.PCIId + 1
+		}
+	}
 	if len(serialAssignments) != 0 {
 		serialPortContext := struct {
 			SerialPortName string
@@ -702,6 +812,12 @@ func (ctx kvmContext) Start(domainName string, domainID int) error {
 		}
 	}
 
+	if cpus, ok := annotations[containerd.EVEOCICPUsLabel]; ok && cpus != "" {
+		if err := pinVCPUs(qmpFile, cpus); err != nil {
+			return logError("failed to pin vCPUs of %s: %v", domainName, err)
+		}
+	}
+
 	if err := execContinue(qmpFile); err != nil {
 		return logError("failed to start domain that is stopped %v", err)
 	}
@@ -712,6 +828,283 @@ func (ctx kvmContext) Start(domainName string, domainID int) error {
 	return nil
 }
 
+// SetMemoryTarget asks the virtio-balloon driver in the guest to give back
+// or take memory. The domain is started with its MaxMem hence that is the
+// most it can grow to.
+func (ctx kvmContext) SetMemoryTarget(domainName string, domainID int, targetKB int) error {
+	if err := execBalloon(getQmpExecutorSocket(domainName), int64(targetKB)<<10); err != nil {
+		return logError("SetMemoryTarget: failed to execute balloon command %v", err)
+	}
+	return nil
+}
+
+// Panicked returns true once the pvpanic device reported a kernel panic.
+// The virt machine has no pvpanic device (QEMU lacks pvpanic-pci for ARM)
+// hence a panic is never detected there, nor dumped.
+func (ctx kvmContext) Panicked(domainName string) bool {
+	if ctx.devicemodel == "virt" {
+		logrus.Infof("Panicked(%s): no pvpanic device on %s, can't tell a kernel panic",
+			domainName, ctx.devicemodel)
+		return false
+	}
+	_, err := os.Stat(kvmStateDir + domainName + "/" + panickedFile)
+	return err == nil
+}
+
+// DumpGuestMemory saves the memory of the domain
+func (ctx kvmContext) DumpGuestMemory(domainName string, file string) error {
+	if err := execDumpGuestMemory(getQmpExecutorSocket(domainName), file); err != nil {
+		return logError("DumpGuestMemory: failed for %s: %v", domainName, err)
+	}
+	return nil
+}
+
+// OpenConsole connects to the socket shared by the serial port and the
+// virtio console of the domain
+func (ctx kvmContext) OpenConsole(domainName string) (io.ReadWriteCloser, error) {
+	conn, err := net.Dial("unix", kvmStateDir+domainName+"/cons")
+	if err != nil {
+		return nil, logError("OpenConsole: can't connect to the console of %s: %v", domainName, err)
+	}
+	return conn, nil
+}
+
+// GuestShutdown has the guest agent power off the guest OS
+func (ctx kvmContext) GuestShutdown(domainName string) error {
+	socket := getQgaSocket(domainName)
+	if err := execGuestPing(socket); err != nil {
+		return logError("GuestShutdown: no guest agent in %s: %v", domainName, err)
+	}
+	if err := execGuestShutdown(socket, "powerdown"); err != nil {
+		return logError("GuestShutdown: failed for %s: %v", domainName, err)
+	}
+	return nil
+}
+
+// GuestReboot has the guest agent reboot the guest OS
+func (ctx kvmContext) GuestReboot(domainName string) error {
+	socket := getQgaSocket(domainName)
+	if err := execGuestPing(socket); err != nil {
+		return logError("GuestReboot: no guest agent in %s: %v", domainName, err)
+	}
+	if err := execGuestShutdown(socket, "reboot"); err != nil {
+		return logError("GuestReboot: failed for %s: %v", domainName, err)
+	}
+	return nil
+}
+
+// GuestFsFreeze has the guest agent freeze the filesystems of the guest OS
+func (ctx kvmContext) GuestFsFreeze(domainName string) error {
+	count, err := execGuestFsFreeze(getQgaSocket(domainName))
+	if err != nil {
+		return logError("GuestFsFreeze: failed for %s: %v", domainName, err)
+	}
+	logrus.Infof("GuestFsFreeze: froze %d filesystems of %s", count, domainName)
+	return nil
+}
+
+// GuestFsThaw has the guest agent thaw the filesystems of the guest OS
+func (ctx kvmContext) GuestFsThaw(domainName string) error {
+	count, err := execGuestFsThaw(getQgaSocket(domainName))
+	if err != nil {
+		return logError("GuestFsThaw: failed for %s: %v", domainName, err)
+	}
+	logrus.Infof("GuestFsThaw: thawed %d filesystems of %s", count, domainName)
+	return nil
+}
+
+// GuestInfo collects the OS, network and filesystem information from the
+// guest agent. Older agents lack some of the commands, hence only a failing
+// ping is an error.
+func (ctx kvmContext) GuestInfo(domainName string) (types.GuestInfo, error) {
+	info := types.GuestInfo{LastUpdate: time.Now()}
+	socket := getQgaSocket(domainName)
+	if err := execGuestPing(socket); err != nil {
+		return info, err
+	}
+	info.AgentRunning = true
+	if osInfo, err := getGuestOSInfo(socket); err != nil {
+		logrus.Debugf("GuestInfo: %s: %v", domainName, err)
+	} else {
+		info.OSName = osInfo.PrettyName
+		if info.OSName == "" {
+			info.OSName = osInfo.Name
+		}
+		info.OSVersion = osInfo.Version
+		info.KernelRelease = osInfo.KernelRelease
+	}
+	if interfaces, err := getGuestInterfaces(socket); err != nil {
+		logrus.Debugf("GuestInfo: %s: %v", domainName, err)
+	} else {
+		for _, intf := range interfaces {
+			// Skip loopback
+			if intf.HardwareAddress == "" ||
+				intf.HardwareAddress == "00:00:00:00:00:00" {
+				continue
+			}
+			gi := types.GuestInterface{
+				Name:    intf.Name,
+				MacAddr: intf.HardwareAddress,
+			}
+			for _, addr := range intf.IPAddresses {
+				gi.IPAddrs = append(gi.IPAddrs, addr.Address)
+			}
+			info.Interfaces = append(info.Interfaces, gi)
+		}
+	}
+	if filesystems, err := getGuestFilesystems(socket); err != nil {
+		logrus.Debugf("GuestInfo: %s: %v", domainName, err)
+	} else {
+		for _, fs := range filesystems {
+			info.Filesystems = append(info.Filesystems,
+				types.GuestFilesystem{
+					Mountpoint: fs.Mountpoint,
+					Type:       fs.Type,
+					UsedBytes:  fs.UsedBytes,
+					TotalBytes: fs.TotalBytes,
+				})
+		}
+	}
+	return info, nil
+}
+
+// hotplugDevice adds the device behind the first spare pcie-root-port
+// which is not in use yet
+func hotplugDevice(socket string, slots int, args map[string]string) error {
+	err := fmt.Errorf("no hot-plug slots")
+	for slot := 0; slot < slots; slot++ {
+		args["bus"] = fmt.Sprintf("hotplug.%d", slot)
+		if err = execDeviceAdd(socket, args); err == nil {
+			return nil
+		}
+		logrus.Debugf("hotplugDevice %s on %s: %v", args["id"], args["bus"], err)
+	}
+	return err
+}
+
+// AttachDisk adds a virtio block device to the running domain. The drive
+// and device IDs are derived from the Vdev so that DetachDisk can find them.
+func (ctx kvmContext) AttachDisk(status types.DomainStatus, disk types.DiskStatus) error {
+	if disk.Devtype != "hdd" {
+		return logError("AttachDisk: can not hot-plug %s disk %s into %s",
+			disk.Devtype, disk.FileLocation, status.DomainName)
+	}
+	socket := getQmpExecutorSocket(status.DomainName)
+	id := "virtio-disk-" + disk.Vdev
+	options := fmt.Sprintf("if=none,id=drive-%s,file=%s,format=%s,cache=writeback,aio=%s",
+		id, disk.FileLocation, strings.ToLower(disk.Format.String()), qemuAioType)
+	if disk.ReadOnly {
+		options += ",readonly=on"
+	}
+	if err := execDriveAdd(socket, options); err != nil {
+		return logError("AttachDisk: failed to add drive %s to %s: %v",
+			disk.FileLocation, status.DomainName, err)
+	}
+	device := map[string]string{
+		"driver": "virtio-blk-pci",
+		"id":     id,
+		"drive":  "drive-" + id,
+		"scsi":   "off",
+	}
+	if err := hotplugDevice(socket, status.HotplugSlots, device); err != nil {
+		if err := execDriveDel(socket, "drive-"+id); err != nil {
+			logrus.Warnf("AttachDisk: failed to remove drive-%s: %v", id, err)
+		}
+		return logError("AttachDisk: failed to add %s to %s: %v",
+			id, status.DomainName, err)
+	}
+	return nil
+}
+
+// DetachDisk removes the virtio block device using the disk from the
+// running domain. The drive goes away with the device.
+func (ctx kvmContext) DetachDisk(status types.DomainStatus, disk types.DiskStatus) error {
+	if disk.Devtype != "hdd" {
+		return logError("DetachDisk: can not hot-unplug %s disk %s from %s",
+			disk.Devtype, disk.FileLocation, status.DomainName)
+	}
+	socket := getQmpExecutorSocket(status.DomainName)
+	drives, err := getBlockDevices(socket)
+	if err != nil {
+		return logError("DetachDisk: failed to query drives of %s: %v",
+			status.DomainName, err)
+	}
+	drive, ok := drives[disk.FileLocation]
+	if !ok {
+		return logError("DetachDisk: no drive for %s in %s",
+			disk.FileLocation, status.DomainName)
+	}
+	if err := execDeviceDel(socket, strings.TrimPrefix(drive, "drive-")); err != nil {
+		return logError("DetachDisk: failed to remove %s from %s: %v",
+			drive, status.DomainName, err)
+	}
+	return nil
+}
+
+// AttachVif adds a virtio-net device on the vif tap interface to the
+// running domain
+func (ctx kvmContext) AttachVif(status types.DomainStatus, vif types.VifInfo) error {
+	if status.VirtualizationMode == types.LEGACY {
+		return logError("AttachVif: can not hot-plug %s into legacy domain %s",
+			vif.Vif, status.DomainName)
+	}
+	socket := getQmpExecutorSocket(status.DomainName)
+	id := "net-" + vif.Vif
+	netdev := map[string]string{
+		"type":       "tap",
+		"id":         "host" + id,
+		"ifname":     vif.Vif,
+		"br":         vif.Bridge,
+		"script":     "/etc/xen/scripts/qemu-ifup",
+		"downscript": "no",
+	}
+	if err := execNetdevAdd(socket, netdev); err != nil {
+		return logError("AttachVif: failed to add netdev for %s to %s: %v",
+			vif.Vif, status.DomainName, err)
+	}
+	device := map[string]string{
+		"driver": "virtio-net-pci",
+		"id":     id,
+		"netdev": "host" + id,
+		"mac":    vif.Mac,
+	}
+	if err := hotplugDevice(socket, status.HotplugSlots, device); err != nil {
+		if err := execNetdevDel(socket, "host"+id); err != nil {
+			logrus.Warnf("AttachVif: failed to remove host%s: %v", id, err)
+		}
+		return logError("AttachVif: failed to add %s to %s: %v",
+			id, status.DomainName, err)
+	}
+	return nil
+}
+
+// DetachVif removes the network device with the MAC address of the vif
+// from the running domain
+func (ctx kvmContext) DetachVif(status types.DomainStatus, vif types.VifInfo) error {
+	socket := getQmpExecutorSocket(status.DomainName)
+	nics, err := getNetDevices(socket)
+	if err != nil {
+		return logError("DetachVif: failed to query network devices of %s: %v",
+			status.DomainName, err)
+	}
+	id, ok := nics[strings.ToLower(vif.Mac)]
+	if !ok {
+		return logError("DetachVif: no device with MAC %s in %s",
+			vif.Mac, status.DomainName)
+	}
+	if err := execDeviceDel(socket, id); err != nil {
+		return logError("DetachVif: failed to remove %s from %s: %v",
+			id, status.DomainName, err)
+	}
+	// Both the boot time and the hot-plugged netdevs are named after
+	// their device
+	if err := execNetdevDel(socket, "host"+id); err != nil {
+		logrus.Warnf("DetachVif: failed to remove host%s from %s: %v",
+			id, status.DomainName, err)
+	}
+	return nil
+}
+
 func (ctx kvmContext) Stop(domainName string, domainID int, force bool) error {
 	if err := execShutdown(getQmpExecutorSocket(domainName)); err != nil {
 		return logError("Stop: failed to execute shutdown command %v", err)
@@ -769,6 +1162,9 @@ func (ctx kvmContext) Info(domainName string, domainID int) (int, types.SwState,
 		return effectiveDomainID, types.BROKEN, logError("couldn't retrieve status for domain %s: %v", domainName, err)
 	}
 
+	if res == "guest-panicked" {
+		return effectiveDomainID, types.BROKEN, logError("guest kernel of domain %s panicked", domainName)
+	}
 	if effectiveDomainState, matched := stateMap[res]; !matched {
 		return effectiveDomainID, types.BROKEN, logError("domain %s reported to be in unexpected state %s", domainName, res)
 	} else {
@@ -870,6 +1266,10 @@ func getQmpExecutorSocket(domainName string) string {
 	return kvmStateDir + domainName + "/qmp"
 }
 
+func getQgaSocket(domainName string) string {
+	return kvmStateDir + domainName + "/qga"
+}
+
 func getQmpListenerSocket(domainName string) string {
 	return kvmStateDir + domainName + "/listener.qmp"
 }
@@ -3,6 +3,12 @@
 
 package hypervisor
 
+import "fmt"
+
 func getOsVersion() string {
 	return ""
 }
+
+func pinVCPUs(qmpFile string, cpuList string) error {
+	return fmt.Errorf("not supported")
+}
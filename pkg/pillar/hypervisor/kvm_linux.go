@@ -3,7 +3,14 @@
 
 package hypervisor
 
-import "syscall"
+import (
+	"fmt"
+	"syscall"
+
+	"github.com/lf-edge/eve/pkg/pillar/utils"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
 
 func getOsVersion() string {
 	var uname syscall.Utsname
@@ -17,3 +24,27 @@ func getOsVersion() string {
 
 	return string(b)
 }
+
+// pinVCPUs pins each vCPU thread of the domain to one of the host CPUs
+func pinVCPUs(qmpFile string, cpuList string) error {
+	cpus, err := utils.ParseCPUList(cpuList)
+	if err != nil {
+		return err
+	}
+	if len(cpus) == 0 {
+		return nil
+	}
+	threads, err := getVCPUThreads(qmpFile)
+	if err != nil {
+		return err
+	}
+	for i, tid := range threads {
+		var set unix.CPUSet
+		set.Set(cpus[i%len(cpus)])
+		if err := unix.SchedSetaffinity(tid, &set); err != nil {
+			return fmt.Errorf("vCPU %d thread %d: %v", i, tid, err)
+		}
+		logrus.Infof("pinVCPUs: vCPU %d thread %d to CPU %d", i, tid, cpus[i%len(cpus)])
+	}
+	return nil
+}
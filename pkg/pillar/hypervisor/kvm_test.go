@@ -4,6 +4,7 @@ import (
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"strings"
 	"testing"
 
 	zconfig "github.com/lf-edge/eve/api/go/config"
@@ -189,6 +190,20 @@ func TestCreateDomConfigOnlyCom1(t *testing.T) {
   chardev = "charserial0"
   name = "org.lfedge.eve.console.0"
 
+[chardev "charqga"]
+  backend = "socket"
+  path = "/run/hypervisor/kvm/test/qga"
+  server = "on"
+  wait = "off"
+
+[device "qga0"]
+  driver = "virtserialport"
+  chardev = "charqga"
+  name = "org.qemu.guest_agent.0"
+
+[device "pvpanic"]
+  driver = "pvpanic"
+
 
 #[device "video0"]
 #  driver = "qxl-vga"
@@ -451,6 +466,20 @@ func TestCreateDomConfigOnlyCom1(t *testing.T) {
   chardev = "charserial0"
   name = "org.lfedge.eve.console.0"
 
+[chardev "charqga"]
+  backend = "socket"
+  path = "/run/hypervisor/kvm/test/qga"
+  server = "on"
+  wait = "off"
+
+[device "qga0"]
+  driver = "virtserialport"
+  chardev = "charqga"
+  name = "org.qemu.guest_agent.0"
+
+[device "pvpanic"]
+  driver = "pvpanic"
+
 
 #[device "video0"]
 #  driver = "qxl-vga"
@@ -689,6 +718,17 @@ func TestCreateDomConfigOnlyCom1(t *testing.T) {
   chardev = "charserial0"
   name = "org.lfedge.eve.console.0"
 
+[chardev "charqga"]
+  backend = "socket"
+  path = "/run/hypervisor/kvm/test/qga"
+  server = "on"
+  wait = "off"
+
+[device "qga0"]
+  driver = "virtserialport"
+  chardev = "charqga"
+  name = "org.qemu.guest_agent.0"
+
 
 #[device "video0"]
 #  driver = "qxl-vga"
@@ -1025,6 +1065,20 @@ func TestCreateDomConfig(t *testing.T) {
   chardev = "charserial0"
   name = "org.lfedge.eve.console.0"
 
+[chardev "charqga"]
+  backend = "socket"
+  path = "/run/hypervisor/kvm/test/qga"
+  server = "on"
+  wait = "off"
+
+[device "qga0"]
+  driver = "virtserialport"
+  chardev = "charqga"
+  name = "org.qemu.guest_agent.0"
+
+[device "pvpanic"]
+  driver = "pvpanic"
+
 
 #[device "video0"]
 #  driver = "qxl-vga"
@@ -1304,6 +1358,20 @@ func TestCreateDomConfig(t *testing.T) {
   chardev = "charserial0"
   name = "org.lfedge.eve.console.0"
 
+[chardev "charqga"]
+  backend = "socket"
+  path = "/run/hypervisor/kvm/test/qga"
+  server = "on"
+  wait = "off"
+
+[device "qga0"]
+  driver = "virtserialport"
+  chardev = "charqga"
+  name = "org.qemu.guest_agent.0"
+
+[device "pvpanic"]
+  driver = "pvpanic"
+
 
 #[device "video0"]
 #  driver = "qxl-vga"
@@ -1602,6 +1670,20 @@ func TestCreateDomConfig(t *testing.T) {
   chardev = "charserial0"
   name = "org.lfedge.eve.console.0"
 
+[chardev "charqga"]
+  backend = "socket"
+  path = "/run/hypervisor/kvm/test/qga"
+  server = "on"
+  wait = "off"
+
+[device "qga0"]
+  driver = "virtserialport"
+  chardev = "charqga"
+  name = "org.qemu.guest_agent.0"
+
+[device "pvpanic"]
+  driver = "pvpanic"
+
 
 #[device "video0"]
 #  driver = "qxl-vga"
@@ -1871,6 +1953,17 @@ func TestCreateDomConfig(t *testing.T) {
   chardev = "charserial0"
   name = "org.lfedge.eve.console.0"
 
+[chardev "charqga"]
+  backend = "socket"
+  path = "/run/hypervisor/kvm/test/qga"
+  server = "on"
+  wait = "off"
+
+[device "qga0"]
+  driver = "virtserialport"
+  chardev = "charqga"
+  name = "org.qemu.guest_agent.0"
+
 
 #[device "video0"]
 #  driver = "qxl-vga"
@@ -2053,6 +2146,109 @@ func TestCreateDomConfig(t *testing.T) {
 	})
 }
 
+func TestCreateDomConfigVTPM(t *testing.T) {
+	initTest(t)
+	config := types.DomainConfig{
+		UUIDandVersion: types.UUIDandVersion{UUID: uuid.NewV4(), Version: "1.0"},
+		VmConfig: types.VmConfig{
+			Memory:     1024 * 1024,
+			VCpus:      1,
+			EnableVTPM: true,
+		},
+	}
+	conf, err := ioutil.TempFile("/tmp", "config")
+	if err != nil {
+		t.Fatalf("Can't create config file for a domain %v", err)
+	}
+	defer os.Remove(conf.Name())
+
+	for _, tc := range []struct {
+		name   string
+		ctx    kvmContext
+		driver string
+	}{
+		{"amd64", kvmIntel, "tpm-crb"},
+		{"arm64", kvmArm, "tpm-tis-device"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			conf.Seek(0, 0)
+			defer os.Truncate(conf.Name(), 0)
+			if err := tc.ctx.CreateDomConfig("test", config, nil, &types.AssignableAdapters{}, conf); err != nil {
+				t.Fatalf("CreateDomConfig failed %v", err)
+			}
+			result, err := ioutil.ReadFile(conf.Name())
+			if err != nil {
+				t.Fatalf("reading conf file failed %v", err)
+			}
+			expected := `[chardev "chrtpm"]
+  backend = "socket"
+  path = "` + config.VTPMSocket() + `"
+
+[tpmdev "tpm0"]
+  type = "emulator"
+  chardev = "chrtpm"
+
+[device "tpm-tpm0"]
+  driver = "` + tc.driver + `"
+  tpmdev = "tpm0"
+`
+			if !strings.HasSuffix(string(result), expected) {
+				t.Errorf("got an unexpected resulting config %s", string(result))
+			}
+		})
+	}
+}
+
+func TestCreateDomConfigHotplug(t *testing.T) {
+	initTest(t)
+	config := types.DomainConfig{
+		UUIDandVersion: types.UUIDandVersion{UUID: uuid.NewV4(), Version: "1.0"},
+		VmConfig: types.VmConfig{
+			Memory: 1024 * 1024,
+			VCpus:  1,
+		},
+		VifList: []types.VifInfo{
+			{Bridge: "bn0", Mac: "6a:00:03:61:a6:90", Vif: "nbu1x1"},
+		},
+		HotplugSlots: 2,
+	}
+	disks := []types.DiskStatus{
+		{Format: zconfig.Format_QCOW2, FileLocation: "/foo/bar.qcow2", Devtype: "hdd"},
+	}
+	conf, err := ioutil.TempFile("/tmp", "config")
+	if err != nil {
+		t.Fatalf("Can't create config file for a domain %v", err)
+	}
+	defer os.Remove(conf.Name())
+
+	if err := kvmIntel.CreateDomConfig("test", config, disks, &types.AssignableAdapters{}, conf); err != nil {
+		t.Fatalf("CreateDomConfig failed %v", err)
+	}
+	result, err := ioutil.ReadFile(conf.Name())
+	if err != nil {
+		t.Fatalf("reading conf file failed %v", err)
+	}
+	// One disk and one network interface take 0x4 and 0x5
+	expected := `
+[device "hotplug.0"]
+  driver = "pcie-root-port"
+  port = "16"
+  chassis = "6"
+  bus = "pcie.0"
+  addr = "0x6"
+
+[device "hotplug.1"]
+  driver = "pcie-root-port"
+  port = "17"
+  chassis = "7"
+  bus = "pcie.0"
+  addr = "0x7"
+`
+	if !strings.HasSuffix(string(result), expected) {
+		t.Errorf("got an unexpected resulting config %s", string(result))
+	}
+}
+
 func TestCreateDom(t *testing.T) {
 	initTest(t)
 	if exec.Command("qemu-system-x86_64", "--version").Run() != nil {
@@ -0,0 +1,404 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package hypervisor
+
+// Pods. A container domain with PodContainers runs one container per entry
+// instead of one for the domain. The first container to start, in the order
+// given by DependsOn, is the primary one: it is named after the domain and
+// gets the VIFs. The others are named <domain>.<name> and join its network
+// namespace, which is bound under podsDir so that it outlives restarts of
+// the primary. The containers are started one after the other once the
+// ones they depend on are running, and stopped in the reverse order. The
+// output of each container is logged as <domain>.<name>.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	zconfig "github.com/lf-edge/eve/api/go/config"
+	"github.com/lf-edge/eve/pkg/pillar/containerd"
+	"github.com/lf-edge/eve/pkg/pillar/types"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	podsDir         = "/run/tasks/pods"
+	podManifestFile = "pod.json"
+	podNetNSFile    = "netns"
+)
+
+var podContainerName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_-]*$`)
+
+// podMember is a container of a pod as recorded in the manifest
+type podMember struct {
+	ID   string
+	Name string
+}
+
+// pod is the manifest saved by Setup for the other task methods. Members
+// are in start order, the first one being the primary container.
+type pod struct {
+	Members []podMember
+}
+
+// sidecars returns the containers other than the primary one
+func (p *pod) sidecars() []podMember {
+	if p == nil || len(p.Members) == 0 {
+		return nil
+	}
+	return p.Members[1:]
+}
+
+func podDir(domainName string) string {
+	return filepath.Join(podsDir, domainName)
+}
+
+func podNetNS(domainName string) string {
+	return filepath.Join(podDir(domainName), podNetNSFile)
+}
+
+// podLogName returns the name the output of the primary container of the
+// domain is logged under. For a pod it follows the names of the other
+// containers, <domain>.<name>, so that newlogd can tell them apart.
+func podLogName(domainName string) string {
+	p := loadPod(domainName)
+	if p == nil || len(p.Members) == 0 {
+		return domainName
+	}
+	return domainName + "." + p.Members[0].Name
+}
+
+// podStartOrder validates the containers of a pod and sorts them so that
+// each one comes after those it depends on. Containers which do not depend
+// on each other keep the order they are listed in.
+func podStartOrder(containers []types.PodContainer) ([]types.PodContainer, error) {
+	names := make(map[string]bool, len(containers))
+	for _, c := range containers {
+		if !podContainerName.MatchString(c.Name) {
+			return nil, fmt.Errorf("invalid pod container name %q", c.Name)
+		}
+		if names[c.Name] {
+			return nil, fmt.Errorf("duplicate pod container %s", c.Name)
+		}
+		names[c.Name] = true
+	}
+	for _, c := range containers {
+		for _, dep := range c.DependsOn {
+			if !names[dep] {
+				return nil, fmt.Errorf("pod container %s depends on unknown %s",
+					c.Name, dep)
+			}
+		}
+	}
+	started := make(map[string]bool, len(containers))
+	order := make([]types.PodContainer, 0, len(containers))
+	for len(order) < len(containers) {
+		progress := false
+		for _, c := range containers {
+			if started[c.Name] {
+				continue
+			}
+			ready := true
+			for _, dep := range c.DependsOn {
+				if !started[dep] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				started[c.Name] = true
+				order = append(order, c)
+				progress = true
+				break
+			}
+		}
+		if !progress {
+			var cycle []string
+			for _, c := range containers {
+				if !started[c.Name] {
+					cycle = append(cycle, c.Name)
+				}
+			}
+			return nil, fmt.Errorf("dependency cycle between pod containers %v", cycle)
+		}
+	}
+	return order, nil
+}
+
+// podMounts returns the disks of the domain with only the ones mounted in
+// the container set, at their path in the container. The other ones are
+// left as FmtUnknown to keep the volume ids the same in all containers.
+func podMounts(disks []types.DiskStatus, mounts []types.PodMount) ([]types.DiskStatus, error) {
+	ret := make([]types.DiskStatus, len(disks))
+	for _, m := range mounts {
+		if m.Disk < 0 || m.Disk >= len(disks) {
+			return nil, fmt.Errorf("no disk %d to mount at %s", m.Disk, m.Path)
+		}
+		ds := disks[m.Disk]
+		ds.MountDir = m.Path
+		ds.ReadOnly = ds.ReadOnly || m.ReadOnly
+		ret[m.Disk] = ds
+	}
+	return ret, nil
+}
+
+// setupPod creates a container for each of the PodContainers and saves
+// the manifest of the pod
+func (ctx ctrdContext) setupPod(status types.DomainStatus, config types.DomainConfig) error {
+	order, err := podStartOrder(config.PodContainers)
+	if err != nil {
+		return logError("domain %s: %v", status.DomainName, err)
+	}
+	if config.Security.Profile == types.SecurityProfileStrict {
+		// the containers would each have their own user namespace, none
+		// of which owns the shared network namespace
+		return logError("domain %s: %s security profile is not supported for pods",
+			status.DomainName, config.Security.Profile)
+	}
+	resolv, err := taskResolvMount(status.DomainName)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(podDir(status.DomainName), 0755); err != nil {
+		return logError("failed to create pod dir for %s: %v", status.DomainName, err)
+	}
+	var manifest pod
+	for i, c := range order {
+		id := status.DomainName
+		if i != 0 {
+			id = fmt.Sprintf("%s.%s", status.DomainName, c.Name)
+		}
+		if c.ImageDisk < 0 || c.ImageDisk >= len(status.DiskStatusList) ||
+			status.DiskStatusList[c.ImageDisk].Format != zconfig.Format_CONTAINER {
+			return logError("pod container %s of %s: disk %d is not a container image",
+				c.Name, status.DomainName, c.ImageDisk)
+		}
+		disks, err := podMounts(status.DiskStatusList, c.Mounts)
+		if err != nil {
+			return logError("pod container %s of %s: %v", c.Name, status.DomainName, err)
+		}
+		spec, err := ctx.ctrdClient.NewOciSpec(id)
+		if err != nil {
+			return logError("setting up OCI spec for pod container %s failed %v", id, err)
+		}
+		if err := spec.UpdateFromVolume(status.DiskStatusList[c.ImageDisk].FileLocation); err != nil {
+			return logError("setting up OCI spec for pod container %s failed %v", id, err)
+		}
+		spec.UpdateFromDomain(&config)
+		if err := spec.UpdateBlockIO(&config, status.DiskStatusList); err != nil {
+			return logError("setting up OCI spec for pod container %s failed %v", id, err)
+		}
+		if err := spec.UpdateSecurity(&config); err != nil {
+			return logError("setting up OCI spec for pod container %s failed %v", id, err)
+		}
+		if err := spec.UpdateMounts(disks); err != nil {
+			return logError("setting up OCI spec for pod container %s failed %v", id, err)
+		}
+		if err := spec.ShiftOwnership(&config, disks); err != nil {
+			return logError("setting up OCI spec for pod container %s failed %v", id, err)
+		}
+		if i == 0 {
+			spec.UpdateVifList(status.VifList)
+		} else {
+			spec.JoinNetNS(podNetNS(status.DomainName))
+			if linux := spec.Get().Linux; linux != nil && linux.CgroupsPath != "" {
+				linux.CgroupsPath = filepath.Join(filepath.Dir(linux.CgroupsPath), id)
+			}
+		}
+		spec.UpdateEnvVar(status.EnvVariables)
+		spec.UpdateEnvVar(c.Env)
+		if len(c.Command) != 0 {
+			spec.Get().Process.Args = c.Command
+		}
+		spec.Get().Mounts = append(spec.Get().Mounts, resolv)
+		if err := spec.CreateContainer(true); err != nil {
+			return logError("Failed to create container %s for pod %s: %v", id, status.DomainName, err)
+		}
+		manifest.Members = append(manifest.Members, podMember{ID: id, Name: c.Name})
+	}
+	b, err := json.Marshal(manifest)
+	if err != nil {
+		return logError("failed to marshal pod manifest for %s: %v", status.DomainName, err)
+	}
+	file := filepath.Join(podDir(status.DomainName), podManifestFile)
+	if err := ioutil.WriteFile(file, b, 0644); err != nil {
+		return logError("failed to save pod manifest %s: %v", file, err)
+	}
+	return nil
+}
+
+// loadPod returns the manifest of the pod, or nil if the domain is not one
+func loadPod(domainName string) *pod {
+	file := filepath.Join(podDir(domainName), podManifestFile)
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logrus.Errorf("loadPod: %v", err)
+		}
+		return nil
+	}
+	var p pod
+	if err := json.Unmarshal(b, &p); err != nil {
+		logrus.Errorf("loadPod: %s: %v", file, err)
+		return nil
+	}
+	return &p
+}
+
+// createPod creates the tasks of the other containers once the task of the
+// primary one has pid
+func (ctx ctrdContext) createPod(domainName string, pid int) error {
+	p := loadPod(domainName)
+	if p == nil {
+		return nil
+	}
+	netns := podNetNS(domainName)
+	if err := containerd.UnbindNetNS(netns); err != nil {
+		logrus.Warnf("createPod(%s): %v", domainName, err)
+	}
+	if err := containerd.BindNetNS(netns, pid); err != nil {
+		return logError("createPod(%s): %v", domainName, err)
+	}
+	ctrdCtx, done := ctx.ctrdClient.CtrNewUserServicesCtx()
+	defer done()
+	for _, m := range p.sidecars() {
+		// same as for the primary, get rid of a stale task
+		_ = ctx.ctrdClient.CtrStopContainer(ctrdCtx, m.ID, true)
+		if _, err := ctx.ctrdClient.CtrCreateTask(ctrdCtx, m.ID); err != nil {
+			return logError("createPod(%s): creating task %s failed: %v",
+				domainName, m.ID, err)
+		}
+	}
+	return nil
+}
+
+// startPod starts the other containers in order, each one once the
+// previous one is running
+func (ctx ctrdContext) startPod(domainName string) error {
+	ctrdCtx, done := ctx.ctrdClient.CtrNewUserServicesCtx()
+	defer done()
+	for _, m := range loadPod(domainName).sidecars() {
+		if err := ctx.ctrdClient.CtrStartTask(ctrdCtx, m.ID); err != nil {
+			return logError("startPod(%s): starting %s failed: %v",
+				domainName, m.ID, err)
+		}
+		if err := ctx.waitSteadyState(m.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stopPod stops the other containers in the reverse order. Failures are
+// only logged since the containers are deleted with the domain anyway.
+func (ctx ctrdContext) stopPod(domainName string, force bool) {
+	ctrdCtx, done := ctx.ctrdClient.CtrNewUserServicesCtx()
+	defer done()
+	sidecars := loadPod(domainName).sidecars()
+	for i := len(sidecars) - 1; i >= 0; i-- {
+		id := sidecars[i].ID
+		if err := ctx.ctrdClient.CtrStopContainer(ctrdCtx, id, force); err != nil {
+			logrus.Warnf("stopPod(%s): stopping %s failed: %v", domainName, id, err)
+		}
+	}
+}
+
+// deletePod deletes the other containers in the reverse order and releases
+// the network namespace
+func (ctx ctrdContext) deletePod(domainName string) error {
+	ctrdCtx, done := ctx.ctrdClient.CtrNewUserServicesCtx()
+	defer done()
+	sidecars := loadPod(domainName).sidecars()
+	for i := len(sidecars) - 1; i >= 0; i-- {
+		id := sidecars[i].ID
+		if err := ctx.ctrdClient.CtrDeleteContainer(ctrdCtx, id); err != nil {
+			logrus.Warnf("deletePod(%s): deleting %s failed: %v", domainName, id, err)
+		}
+	}
+	if _, err := os.Stat(podDir(domainName)); os.IsNotExist(err) {
+		return nil
+	}
+	if err := containerd.UnbindNetNS(podNetNS(domainName)); err != nil {
+		logrus.Warnf("deletePod(%s): %v", domainName, err)
+	}
+	if err := os.RemoveAll(podDir(domainName)); err != nil {
+		return logError("cannot clear pod dir %s: %v", podDir(domainName), err)
+	}
+	return nil
+}
+
+// PodInfo returns the state of each container of the pod
+func (ctx ctrdContext) PodInfo(domainName string) ([]types.PodContainerStatus, error) {
+	p := loadPod(domainName)
+	if p == nil {
+		return nil, nil
+	}
+	ctrdCtx, done := ctx.ctrdClient.CtrNewUserServicesCtx()
+	defer done()
+	ret := make([]types.PodContainerStatus, 0, len(p.Members))
+	for _, m := range p.Members {
+		cs := types.PodContainerStatus{Name: m.Name, State: types.UNKNOWN}
+		pid, exit, status, err := ctx.ctrdClient.CtrContainerInfo(ctrdCtx, m.ID)
+		if err == nil {
+			cs.PID = pid
+			cs.ExitCode = exit
+			cs.State = containerState(status, exit)
+		}
+		ret = append(ret, cs)
+	}
+	return ret, nil
+}
+
+// podBroken returns an error naming the first container of the pod, other
+// than the primary one, which exited with an error
+func (ctx ctrdContext) podBroken(domainName string) error {
+	sidecars := loadPod(domainName).sidecars()
+	if len(sidecars) == 0 {
+		return nil
+	}
+	ctrdCtx, done := ctx.ctrdClient.CtrNewUserServicesCtx()
+	defer done()
+	for _, m := range sidecars {
+		_, exit, status, err := ctx.ctrdClient.CtrContainerInfo(ctrdCtx, m.ID)
+		if err == nil && status == "stopped" && exit != 0 {
+			return fmt.Errorf("pod container %s broke with exit status %d",
+				m.Name, exit)
+		}
+	}
+	return nil
+}
+
+// podMetrics adds the usage of the other containers of each pod to the
+// one of its primary container, which is the one reported for the domain
+func podMetrics(res map[string]types.DomainMetric) {
+	entries, err := ioutil.ReadDir(podsDir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		primary, ok := res[entry.Name()]
+		if !ok {
+			continue
+		}
+		for _, m := range loadPod(entry.Name()).sidecars() {
+			metric, ok := res[m.ID]
+			if !ok {
+				continue
+			}
+			primary.CPUTotal += metric.CPUTotal
+			primary.UsedMemory += metric.UsedMemory
+			primary.AvailableMemory += metric.AvailableMemory
+			primary.Throttling.Add(metric.Throttling)
+			delete(res, m.ID)
+		}
+		if total := primary.UsedMemory + primary.AvailableMemory; total != 0 {
+			primary.UsedMemoryPercent = float64(100 * float32(primary.UsedMemory) / float32(total))
+		}
+		res[entry.Name()] = primary
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package hypervisor
+
+import (
+	"reflect"
+	"testing"
+
+	zconfig "github.com/lf-edge/eve/api/go/config"
+	"github.com/lf-edge/eve/pkg/pillar/types"
+)
+
+func TestPodStartOrder(t *testing.T) {
+	testMatrix := map[string]struct {
+		containers []types.PodContainer
+		order      []string
+		fail       bool
+	}{
+		"no dependencies": {
+			containers: []types.PodContainer{{Name: "a"}, {Name: "b"}, {Name: "c"}},
+			order:      []string{"a", "b", "c"},
+		},
+		"chain": {
+			containers: []types.PodContainer{
+				{Name: "web", DependsOn: []string{"app"}},
+				{Name: "app", DependsOn: []string{"db"}},
+				{Name: "db"},
+			},
+			order: []string{"db", "app", "web"},
+		},
+		"diamond": {
+			containers: []types.PodContainer{
+				{Name: "proxy", DependsOn: []string{"api", "ui"}},
+				{Name: "ui", DependsOn: []string{"db"}},
+				{Name: "api", DependsOn: []string{"db"}},
+				{Name: "db"},
+			},
+			order: []string{"db", "ui", "api", "proxy"},
+		},
+		"cycle": {
+			containers: []types.PodContainer{
+				{Name: "a", DependsOn: []string{"b"}},
+				{Name: "b", DependsOn: []string{"a"}},
+			},
+			fail: true,
+		},
+		"unknown dependency": {
+			containers: []types.PodContainer{{Name: "a", DependsOn: []string{"z"}}},
+			fail:       true,
+		},
+		"duplicate name": {
+			containers: []types.PodContainer{{Name: "a"}, {Name: "a"}},
+			fail:       true,
+		},
+		"invalid name": {
+			containers: []types.PodContainer{{Name: "a.b"}},
+			fail:       true,
+		},
+	}
+	for testname, test := range testMatrix {
+		t.Logf("Running test case %s", testname)
+		order, err := podStartOrder(test.containers)
+		if test.fail {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %v", testname, order)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", testname, err)
+			continue
+		}
+		var names []string
+		for _, c := range order {
+			names = append(names, c.Name)
+		}
+		if !reflect.DeepEqual(names, test.order) {
+			t.Errorf("%s: got %v, expected %v", testname, names, test.order)
+		}
+	}
+}
+
+func TestPodMounts(t *testing.T) {
+	disks := []types.DiskStatus{
+		{MountDir: "/", Format: zconfig.Format_CONTAINER, FileLocation: "/foo/web"},
+		{Format: zconfig.Format_CONTAINER, FileLocation: "/foo/db"},
+		{MountDir: "/data", Format: zconfig.Format_QCOW2, FileLocation: "/foo/data.qcow2"},
+	}
+	mounts, err := podMounts(disks, []types.PodMount{{Disk: 2, Path: "/var/lib/db", ReadOnly: true}})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	expected := []types.DiskStatus{
+		{},
+		{},
+		{MountDir: "/var/lib/db", Format: zconfig.Format_QCOW2, FileLocation: "/foo/data.qcow2", ReadOnly: true},
+	}
+	if !reflect.DeepEqual(mounts, expected) {
+		t.Errorf("got %+v, expected %+v", mounts, expected)
+	}
+	if _, err := podMounts(disks, []types.PodMount{{Disk: 3, Path: "/x"}}); err == nil {
+		t.Errorf("expected an error for a missing disk")
+	}
+}
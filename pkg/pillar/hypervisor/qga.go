@@ -0,0 +1,194 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package hypervisor
+
+// Client for the qemu-guest-agent running inside KVM domains. The agent is
+// reached over the org.qemu.guest_agent.0 virtio-serial port which qemu
+// exposes as a UNIX socket. Unlike QMP there is no greeting; a guest-sync
+// with a random id is used to skip any stale responses left in the channel.
+// This implements a subset of
+//     https://qemu.readthedocs.io/en/latest/interop/qemu-ga-ref.html
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// qgaTimeout bounds each exchange with the guest agent; when the agent is
+// not running in the guest nothing answers
+const qgaTimeout = 5 * time.Second
+
+type qgaResponse struct {
+	Return json.RawMessage `json:"return"`
+	Error  *struct {
+		Class string `json:"class"`
+		Desc  string `json:"desc"`
+	} `json:"error"`
+}
+
+// execGuestAgentCmd runs cmd and returns the content of its "return". With
+// noReply the command is assumed to have worked if the agent goes away
+// without answering, as is the case for guest-shutdown.
+func execGuestAgentCmd(socket string, cmd string, args interface{},
+	noReply bool) (json.RawMessage, error) {
+
+	logrus.Debugf("executing guest agent command: %s", cmd)
+	conn, err := net.DialTimeout("unix", socket, qgaTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(2 * qgaTimeout)); err != nil {
+		return nil, err
+	}
+	enc := json.NewEncoder(conn)
+	dec := json.NewDecoder(conn)
+
+	id := rand.Int31()
+	sync := map[string]interface{}{
+		"execute":   "guest-sync",
+		"arguments": map[string]interface{}{"id": id},
+	}
+	if err := enc.Encode(sync); err != nil {
+		return nil, err
+	}
+	for {
+		var resp qgaResponse
+		if err := dec.Decode(&resp); err != nil {
+			return nil, fmt.Errorf("guest-sync: %v", err)
+		}
+		var got int32
+		if json.Unmarshal(resp.Return, &got) == nil && got == id {
+			break
+		}
+	}
+
+	request := map[string]interface{}{"execute": cmd}
+	if args != nil {
+		request["arguments"] = args
+	}
+	if err := enc.Encode(request); err != nil {
+		return nil, err
+	}
+	if noReply {
+		// Do not wait long for what is not coming
+		if err := conn.SetDeadline(time.Now().Add(time.Second)); err != nil {
+			return nil, err
+		}
+	}
+	var resp qgaResponse
+	if err := dec.Decode(&resp); err != nil {
+		if noReply {
+			if netErr, ok := err.(net.Error); err == io.EOF || (ok && netErr.Timeout()) {
+				return nil, nil
+			}
+		}
+		return nil, fmt.Errorf("%s: %v", cmd, err)
+	}
+	if resp.Error != nil {
+		return nil, fmt.Errorf("%s: %s: %s", cmd, resp.Error.Class,
+			resp.Error.Desc)
+	}
+	return resp.Return, nil
+}
+
+func execGuestPing(socket string) error {
+	_, err := execGuestAgentCmd(socket, "guest-ping", nil, false)
+	return err
+}
+
+func execGuestShutdown(socket string, mode string) error {
+	_, err := execGuestAgentCmd(socket, "guest-shutdown",
+		map[string]string{"mode": mode}, true)
+	return err
+}
+
+// execGuestFsFreeze returns the number of filesystems frozen
+func execGuestFsFreeze(socket string) (int, error) {
+	raw, err := execGuestAgentCmd(socket, "guest-fsfreeze-freeze", nil, false)
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	if err := json.Unmarshal(raw, &count); err != nil {
+		return 0, fmt.Errorf("guest-fsfreeze-freeze: %v", err)
+	}
+	return count, nil
+}
+
+// execGuestFsThaw returns the number of filesystems thawed
+func execGuestFsThaw(socket string) (int, error) {
+	raw, err := execGuestAgentCmd(socket, "guest-fsfreeze-thaw", nil, false)
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	if err := json.Unmarshal(raw, &count); err != nil {
+		return 0, fmt.Errorf("guest-fsfreeze-thaw: %v", err)
+	}
+	return count, nil
+}
+
+type qgaOSInfo struct {
+	Name          string `json:"name"`
+	PrettyName    string `json:"pretty-name"`
+	Version       string `json:"version"`
+	VersionID     string `json:"version-id"`
+	KernelRelease string `json:"kernel-release"`
+}
+
+func getGuestOSInfo(socket string) (*qgaOSInfo, error) {
+	raw, err := execGuestAgentCmd(socket, "guest-get-osinfo", nil, false)
+	if err != nil {
+		return nil, err
+	}
+	var osInfo qgaOSInfo
+	err = json.Unmarshal(raw, &osInfo)
+	return &osInfo, err
+}
+
+type qgaInterface struct {
+	Name            string `json:"name"`
+	HardwareAddress string `json:"hardware-address"`
+	IPAddresses     []struct {
+		Type    string `json:"ip-address-type"`
+		Address string `json:"ip-address"`
+		Prefix  int    `json:"prefix"`
+	} `json:"ip-addresses"`
+}
+
+func getGuestInterfaces(socket string) ([]qgaInterface, error) {
+	raw, err := execGuestAgentCmd(socket, "guest-network-get-interfaces",
+		nil, false)
+	if err != nil {
+		return nil, err
+	}
+	var interfaces []qgaInterface
+	err = json.Unmarshal(raw, &interfaces)
+	return interfaces, err
+}
+
+type qgaFilesystem struct {
+	Name       string `json:"name"`
+	Mountpoint string `json:"mountpoint"`
+	Type       string `json:"type"`
+	UsedBytes  uint64 `json:"used-bytes"`
+	TotalBytes uint64 `json:"total-bytes"`
+}
+
+func getGuestFilesystems(socket string) ([]qgaFilesystem, error) {
+	raw, err := execGuestAgentCmd(socket, "guest-get-fsinfo", nil, false)
+	if err != nil {
+		return nil, err
+	}
+	var filesystems []qgaFilesystem
+	err = json.Unmarshal(raw, &filesystems)
+	return filesystems, err
+}
@@ -0,0 +1,109 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package hypervisor
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeGuestAgent answers guest-sync, and every other command with the
+// reply given for it
+func fakeGuestAgent(t *testing.T, replies map[string]string) string {
+	dir, err := ioutil.TempDir("", "qga")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	socket := filepath.Join(dir, "qga")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			dec := json.NewDecoder(conn)
+			for {
+				var req struct {
+					Execute   string          `json:"execute"`
+					Arguments json.RawMessage `json:"arguments"`
+				}
+				if err := dec.Decode(&req); err != nil {
+					break
+				}
+				reply := replies[req.Execute]
+				if req.Execute == "guest-sync" {
+					var args struct {
+						ID int32 `json:"id"`
+					}
+					json.Unmarshal(req.Arguments, &args)
+					b, _ := json.Marshal(map[string]int32{"return": args.ID})
+					reply = string(b)
+				}
+				conn.Write([]byte(reply + "\n"))
+			}
+			conn.Close()
+		}
+	}()
+	return socket
+}
+
+func TestGuestFsFreeze(t *testing.T) {
+	testMatrix := map[string]struct {
+		reply string
+		count int
+		fail  bool
+	}{
+		"frozen": {
+			reply: `{"return": 2}`,
+			count: 2,
+		},
+		"no filesystems": {
+			reply: `{"return": 0}`,
+		},
+		"already frozen": {
+			reply: `{"error": {"class": "GenericError", "desc": "Command guest-fsfreeze-freeze has been disabled: the agent is in frozen state"}}`,
+			fail:  true,
+		},
+		"garbage": {
+			reply: `{"return": "frozen"}`,
+			fail:  true,
+		},
+	}
+	for testname, test := range testMatrix {
+		t.Run(testname, func(t *testing.T) {
+			socket := fakeGuestAgent(t, map[string]string{
+				"guest-fsfreeze-freeze": test.reply,
+				"guest-fsfreeze-thaw":   test.reply,
+			})
+			for cmd, exec := range map[string]func(string) (int, error){
+				"freeze": execGuestFsFreeze,
+				"thaw":   execGuestFsThaw,
+			} {
+				count, err := exec(socket)
+				if test.fail {
+					if err == nil {
+						t.Errorf("%s: no error", cmd)
+					}
+					continue
+				}
+				if err != nil {
+					t.Errorf("%s: %v", cmd, err)
+				} else if count != test.count {
+					t.Errorf("%s: %d filesystems, expected %d", cmd,
+						count, test.count)
+				}
+			}
+		})
+	}
+}
@@ -5,7 +5,10 @@ import (
 	"fmt"
 	"github.com/digitalocean/go-qemu/qmp"
 	"github.com/sirupsen/logrus"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -66,6 +69,173 @@ func execVNCPassword(socket string, password string) error {
 	return err
 }
 
+func execBalloon(socket string, bytes int64) error {
+	balloon := fmt.Sprintf(`{ "execute": "balloon", "arguments": { "value": %d } }`, bytes)
+	_, err := execRawCmd(socket, balloon)
+	return err
+}
+
+// execDeviceAdd adds a device described by the device_add arguments
+func execDeviceAdd(socket string, args map[string]string) error {
+	arguments, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+	_, err = execRawCmd(socket, fmt.Sprintf(`{ "execute": "device_add", "arguments": %s }`, arguments))
+	return err
+}
+
+// execDeviceDel asks the guest to release the device. It is gone once the
+// guest has acknowledged this.
+func execDeviceDel(socket string, id string) error {
+	_, err := execRawCmd(socket, fmt.Sprintf(`{ "execute": "device_del", "arguments": { "id": "%s" } }`, id))
+	return err
+}
+
+func execNetdevAdd(socket string, args map[string]string) error {
+	arguments, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+	_, err = execRawCmd(socket, fmt.Sprintf(`{ "execute": "netdev_add", "arguments": %s }`, arguments))
+	return err
+}
+
+func execNetdevDel(socket string, id string) error {
+	_, err := execRawCmd(socket, fmt.Sprintf(`{ "execute": "netdev_del", "arguments": { "id": "%s" } }`, id))
+	return err
+}
+
+// execHumanMonitor runs a command which is only available through the human
+// monitor and returns its output
+func execHumanMonitor(socket string, cmdline string) (string, error) {
+	arguments, err := json.Marshal(map[string]string{"command-line": cmdline})
+	if err != nil {
+		return "", err
+	}
+	raw, err := execRawCmd(socket, fmt.Sprintf(`{ "execute": "human-monitor-command", "arguments": %s }`, arguments))
+	if err != nil {
+		return "", err
+	}
+	var result struct {
+		Return string `json:"return"`
+	}
+	err = json.Unmarshal(raw, &result)
+	return result.Return, err
+}
+
+// execDriveAdd adds a drive which is removed together with the device
+// using it. Unlike blockdev-add this takes the same options as -drive.
+func execDriveAdd(socket string, options string) error {
+	out, err := execHumanMonitor(socket, "drive_add 0 "+options)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(out, "OK") {
+		return fmt.Errorf("drive_add failed: %s", strings.TrimSpace(out))
+	}
+	return nil
+}
+
+func execDriveDel(socket string, id string) error {
+	out, err := execHumanMonitor(socket, "drive_del "+id)
+	if err != nil {
+		return err
+	}
+	if out != "" {
+		return fmt.Errorf("drive_del failed: %s", strings.TrimSpace(out))
+	}
+	return nil
+}
+
+// getBlockDevices returns the IDs of the drives by the file they use
+func getBlockDevices(socket string) (map[string]string, error) {
+	raw, err := execRawCmd(socket, `{ "execute": "query-block" }`)
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		Return []struct {
+			Device   string `json:"device"`
+			Inserted *struct {
+				File string `json:"file"`
+			} `json:"inserted"`
+		} `json:"return"`
+	}
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return nil, err
+	}
+	drives := make(map[string]string)
+	for _, block := range result.Return {
+		if block.Inserted != nil {
+			drives[block.Inserted.File] = block.Device
+		}
+	}
+	return drives, nil
+}
+
+// getNetDevices returns the IDs of the virtio network devices by MAC address
+func getNetDevices(socket string) (map[string]string, error) {
+	raw, err := execRawCmd(socket, `{ "execute": "query-rx-filter" }`)
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		Return []struct {
+			Name    string `json:"name"`
+			MainMac string `json:"main-mac"`
+		} `json:"return"`
+	}
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return nil, err
+	}
+	nics := make(map[string]string)
+	for _, nic := range result.Return {
+		nics[strings.ToLower(nic.MainMac)] = nic.Name
+	}
+	return nics, nil
+}
+
+// getVCPUThreads returns the host thread IDs of the vCPUs in order
+func getVCPUThreads(socket string) ([]int, error) {
+	raw, err := execRawCmd(socket, `{ "execute": "query-cpus-fast" }`)
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		Return []struct {
+			CPUIndex int `json:"cpu-index"`
+			ThreadID int `json:"thread-id"`
+		} `json:"return"`
+	}
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return nil, err
+	}
+	threads := make([]int, len(result.Return))
+	for _, cpu := range result.Return {
+		if cpu.CPUIndex < 0 || cpu.CPUIndex >= len(threads) {
+			return nil, fmt.Errorf("unexpected cpu-index %d", cpu.CPUIndex)
+		}
+		threads[cpu.CPUIndex] = cpu.ThreadID
+	}
+	return threads, nil
+}
+
+// execDumpGuestMemory writes the memory of the guest to file in the
+// compressed kdump format readable by crash(8). It returns once done.
+func execDumpGuestMemory(socket string, file string) error {
+	arguments, err := json.Marshal(map[string]interface{}{
+		"paging":   false,
+		"protocol": "file:" + file,
+		"format":   "kdump-zlib",
+	})
+	if err != nil {
+		return err
+	}
+	_, err = execRawCmd(socket, fmt.Sprintf(`{ "execute": "dump-guest-memory", "arguments": %s }`, arguments))
+	return err
+}
+
 func getQemuStatus(socket string) (string, error) {
 	if raw, err := execRawCmd(socket, `{ "execute": "query-status" }`); err == nil {
 		var result struct {
@@ -116,6 +286,13 @@ func qmpEventHandler(listenerSocket, executorSocket string) {
 				if err := execQuit(executorSocket); err != nil {
 					logrus.Errorf("qmpEventHandler: Exception while quitting domain with socket: %s. %s", executorSocket, err.Error())
 				}
+			case "GUEST_PANICKED":
+				// the guest is left paused, domainmgr finds it broken and
+				// saves its memory before deleting it
+				logrus.Warnf("qmpEventHandler: Received event: %s event details: %v from listenerSocket: %s", event.Event, event.Data, listenerSocket)
+				if err := ioutil.WriteFile(filepath.Join(filepath.Dir(listenerSocket), panickedFile), nil, 0644); err != nil {
+					logrus.Errorf("qmpEventHandler: Exception while recording panic for listenerSocket: %s. %s", listenerSocket, err.Error())
+				}
 			default:
 				//Not handling the following events: RESUME, NIC_RX_FILTER_CHANGED, RTC_CHANGE, POWERDOWN, STOP
 				logrus.Debugf("qmpEventHandler: Unhandled event: %s from listenerSocket: %s", event.Event, listenerSocket)
@@ -0,0 +1,18 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package hypervisor
+
+import "fmt"
+
+func createTap(name string, bridgeName string) error {
+	return fmt.Errorf("not supported")
+}
+
+func deleteTap(name string) error {
+	return fmt.Errorf("not supported")
+}
+
+func attachTap(name string, newName string, bridgeName string) (bool, error) {
+	return false, fmt.Errorf("not supported")
+}
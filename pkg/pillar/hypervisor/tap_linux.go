@@ -0,0 +1,72 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package hypervisor
+
+import (
+	"fmt"
+
+	"github.com/vishvananda/netlink"
+)
+
+// createTap creates a persistent tap device on the bridge, replacing any
+// left over by an earlier run of the domain
+func createTap(name string, bridgeName string) error {
+	if err := deleteTap(name); err != nil {
+		return err
+	}
+	bridge, err := netlink.LinkByName(bridgeName)
+	if err != nil {
+		return fmt.Errorf("bridge %s: %v", bridgeName, err)
+	}
+	tap := &netlink.Tuntap{
+		LinkAttrs: netlink.LinkAttrs{Name: name},
+		Mode:      netlink.TUNTAP_MODE_TAP,
+		// What cloud-hypervisor asks for when it opens the tap
+		Flags: netlink.TUNTAP_NO_PI | netlink.TUNTAP_VNET_HDR,
+	}
+	if err := netlink.LinkAdd(tap); err != nil {
+		return err
+	}
+	if err := netlink.LinkSetMaster(tap, bridge); err != nil {
+		return err
+	}
+	return netlink.LinkSetUp(tap)
+}
+
+func deleteTap(name string) error {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		if _, ok := err.(netlink.LinkNotFoundError); ok {
+			return nil
+		}
+		return err
+	}
+	return netlink.LinkDel(link)
+}
+
+// attachTap renames a tap device created by a device model and puts it on
+// the bridge. Returns false if there is no tap device by that name yet.
+func attachTap(name string, newName string, bridgeName string) (bool, error) {
+	tap, err := netlink.LinkByName(name)
+	if err != nil {
+		if _, ok := err.(netlink.LinkNotFoundError); ok {
+			return false, nil
+		}
+		return false, err
+	}
+	bridge, err := netlink.LinkByName(bridgeName)
+	if err != nil {
+		return true, fmt.Errorf("bridge %s: %v", bridgeName, err)
+	}
+	if err := netlink.LinkSetDown(tap); err != nil {
+		return true, err
+	}
+	if err := netlink.LinkSetName(tap, newName); err != nil {
+		return true, err
+	}
+	if err := netlink.LinkSetMaster(tap, bridge); err != nil {
+		return true, err
+	}
+	return true, netlink.LinkSetUp(tap)
+}
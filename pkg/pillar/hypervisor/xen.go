@@ -10,6 +10,7 @@ import (
 	"github.com/shirou/gopsutil/cpu"
 	"github.com/shirou/gopsutil/mem"
 	"github.com/sirupsen/logrus"
+	"io"
 	"io/ioutil"
 	"os"
 	"runtime"
@@ -465,6 +466,11 @@ func (ctx xenContext) CreateDomConfig(domainName string, config types.DomainConf
 	return nil
 }
 
+// OpenConsole attaches xl console to the primary console of the domain
+func (ctx xenContext) OpenConsole(domainName string) (io.ReadWriteCloser, error) {
+	return ctx.execTTY(true, "xen-tools", []string{"xl", "console", domainName})
+}
+
 func (ctx xenContext) Stop(domainName string, domainID int, force bool) error {
 	logrus.Infof("xlShutdown %s %d\n", domainName, domainID)
 	args := []string{
@@ -511,6 +517,22 @@ func (ctx xenContext) Delete(domainName string, domainID int) (result error) {
 	return nil
 }
 
+// SetMemoryTarget balloons the domain up to its maxmem or down
+func (ctx xenContext) SetMemoryTarget(domainName string, domainID int, targetKB int) error {
+	logrus.Infof("xlMemSet %s %d %dk\n", domainName, domainID, targetKB)
+	ctrdSystemCtx, done := ctx.ctrdClient.CtrNewSystemServicesCtx()
+	defer done()
+	stdOut, stdErr, err := ctx.ctrdClient.CtrSystemExec(ctrdSystemCtx, "xen-tools",
+		[]string{"xl", "mem-set", domainName, fmt.Sprintf("%dk", targetKB)})
+	if err != nil {
+		logrus.Errorln("xl mem-set failed ", err)
+		logrus.Errorln("xl mem-set output ", stdOut, stdErr)
+		return fmt.Errorf("xl mem-set failed: %s %s", stdOut, stdErr)
+	}
+	logrus.Infof("xl mem-set done: stdout: %s, stderr: %s", stdOut, stdErr)
+	return nil
+}
+
 func (ctx xenContext) Info(domainName string, domainID int) (int, types.SwState, error) {
 	// first we ask for the task status
 	effectiveDomainID, effectiveDomainState, err := ctx.ctrdContext.Info(domainName, domainID)
@@ -786,6 +808,8 @@ func parseCPUMemoryStat(cpuMemoryStat [][]string, dmList map[string]types.Domain
 			UsedMemory:        uint32(usedMemory),
 			AvailableMemory:   uint32(availableMemory),
 			UsedMemoryPercent: float64(usedMemoryPercent),
+			// the throttling of the device model from containerd
+			Throttling: result[domainname].Throttling,
 		}
 		result[domainname] = dm
 	}
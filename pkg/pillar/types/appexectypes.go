@@ -0,0 +1,83 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+// Types for running commands in, and copying files to and from, the
+// containers of apps for troubleshooting. Served by domainmgr.
+
+package types
+
+import (
+	"fmt"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// AppExecKind is what an AppExecConfig asks for
+type AppExecKind uint8
+
+const (
+	// AppExecCommand runs Args in the container
+	AppExecCommand AppExecKind = iota
+	// AppExecCopyIn writes Data to the file Path in the container
+	AppExecCopyIn
+	// AppExecCopyOut reads the file Path in the container
+	AppExecCopyOut
+)
+
+// String returns the name of the kind for the logs
+func (kind AppExecKind) String() string {
+	switch kind {
+	case AppExecCommand:
+		return "exec"
+	case AppExecCopyIn:
+		return "copy-in"
+	case AppExecCopyOut:
+		return "copy-out"
+	default:
+		return fmt.Sprintf("Unknown AppExecKind %d", kind)
+	}
+}
+
+// AppExecConfig asks for a command to be run in, or a file to be copied to
+// or from, a container of a running app. As with ExecConfig the request is
+// carried out when an item is added or its Sequence changes.
+type AppExecConfig struct {
+	Caller    string // Typically agentName
+	Sequence  int    // To be able to repeat the same request
+	AppUUID   uuid.UUID
+	Container string // Name of the pod container; empty for the app's own
+	Kind      AppExecKind
+	Args      []string // Command and arguments for AppExecCommand
+	Path      string   // File in the container for AppExecCopyIn/Out
+	Data      []byte   `json:"pubsub-large-Data"` // Content of the file for AppExecCopyIn
+	TimeLimit uint     // In seconds; zero means server default
+	MaxOutput uint     // In bytes per output; zero means server default
+}
+
+// Key returns the pubsub key
+func (config AppExecConfig) Key() string {
+	return config.Caller
+}
+
+// AppExecStatus contains the result of an AppExecConfig
+type AppExecStatus struct {
+	Caller    string // Typically agentName
+	Sequence  int    // To be able to repeat the same request
+	AppUUID   uuid.UUID
+	Container string
+	Kind      AppExecKind
+	ExitValue int
+	Stdout    string `json:"pubsub-large-Stdout"`
+	Stderr    string `json:"pubsub-large-Stderr"`
+	Data      []byte `json:"pubsub-large-Data"` // Content of the file for AppExecCopyOut
+	Truncated bool   // Output or Data exceeded MaxOutput
+	TimedOut  bool   // Exceeded TimeLimit and was killed
+	Error     string // Failed to carry out the request
+	Time      time.Time
+}
+
+// Key returns the pubsub key
+func (status AppExecStatus) Key() string {
+	return status.Caller
+}
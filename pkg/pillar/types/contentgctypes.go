@@ -0,0 +1,106 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package types
+
+import (
+	"fmt"
+	"time"
+)
+
+// ContentGCPolicy determines what the content garbage collector does with
+// the unreferenced content it finds
+type ContentGCPolicy uint8
+
+const (
+	// ContentGCOff disables the content garbage collector
+	ContentGCOff ContentGCPolicy = iota
+	// ContentGCReport only reports the unreferenced content (dry-run)
+	ContentGCReport
+	// ContentGCDelete reports and deletes the unreferenced content
+	ContentGCDelete
+)
+
+// String returns the string used in the global setting
+func (policy ContentGCPolicy) String() string {
+	switch policy {
+	case ContentGCOff:
+		return "off"
+	case ContentGCReport:
+		return "report"
+	case ContentGCDelete:
+		return "delete"
+	default:
+		return fmt.Sprintf("Unknown ContentGCPolicy %d", policy)
+	}
+}
+
+// ParseContentGCPolicy parses the storage.gc.content.policy global setting
+func ParseContentGCPolicy(s string) (ContentGCPolicy, error) {
+	for _, policy := range []ContentGCPolicy{ContentGCOff, ContentGCReport,
+		ContentGCDelete} {
+		if s == policy.String() {
+			return policy, nil
+		}
+	}
+	return ContentGCReport, fmt.Errorf("unknown content GC policy %q", s)
+}
+
+// ContentGCKind is the kind of an unreferenced object
+type ContentGCKind uint8
+
+// The kinds are in the order in which the orphans are deleted
+const (
+	// ContentGCSnapshot is a writable container snapshot in CAS
+	ContentGCSnapshot ContentGCKind = iota
+	// ContentGCImage is an image in CAS
+	ContentGCImage
+	// ContentGCBlob is a blob in CAS
+	ContentGCBlob
+	// ContentGCVerifiedFile is a file in the verifier's verified directory
+	ContentGCVerifiedFile
+)
+
+// String returns a short name for the kind
+func (kind ContentGCKind) String() string {
+	switch kind {
+	case ContentGCSnapshot:
+		return "snapshot"
+	case ContentGCImage:
+		return "image"
+	case ContentGCBlob:
+		return "blob"
+	case ContentGCVerifiedFile:
+		return "verified"
+	default:
+		return fmt.Sprintf("Unknown ContentGCKind %d", kind)
+	}
+}
+
+// ContentGCOrphan is an object which is not referenced by any
+// ContentTreeStatus, VolumeStatus, BlobStatus or VerifyImageStatus
+type ContentGCOrphan struct {
+	Kind      ContentGCKind
+	ID        string // digest, reference, snapshot ID or pathname
+	Size      int64  // zero when not known, as for snapshots
+	FirstSeen time.Time
+	Deleted   bool
+}
+
+// ContentGCStatus is published by volumemgr after each run of the content
+// garbage collector
+type ContentGCStatus struct {
+	LastRun        time.Time
+	Policy         ContentGCPolicy
+	Orphans        []ContentGCOrphan
+	OrphanSize     int64 // total size of the orphans still present
+	ReclaimedCount uint32
+	ReclaimedSize  int64 // total size of the orphans deleted by the last run
+	// ErrorAndTime provides SetErrorNow() and ClearError()
+	ErrorAndTime
+}
+
+// Key returns the key of the single instance
+func (status ContentGCStatus) Key() string {
+	return "global"
+}
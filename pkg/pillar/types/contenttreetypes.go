@@ -122,6 +122,13 @@ type ContentTreeStatus struct {
 	NameIsURL    bool
 	// Blobs the sha256 hashes of the blobs that are in this tree, the first of which always is the root
 	Blobs []string
+	// LazyPull is set when the layers are not in Blobs but left to the
+	// remote snapshotter, see app.lazy.pull. LazyPullFailed is set when
+	// that did not work and the layers were added after all.
+	LazyPull       bool
+	LazyPullFailed bool
+	// ImageRef is the reference in the registry the layers are fetched from
+	ImageRef string
 
 	ErrorAndTimeWithSource
 }
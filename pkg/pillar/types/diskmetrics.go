@@ -30,6 +30,7 @@ var ReportDirPaths = []string{
 	PersistDir + "/status",
 	PersistDir + "/certs",
 	PersistDir + "/checkpoint",
+	AppCrashDumpDir,
 }
 
 //AppPersistPaths  Application-related files live here
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	uuid "github.com/satori/go.uuid"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -42,6 +43,116 @@ type DomainConfig struct {
 
 	// MetaDataType for select type of metadata service for app
 	MetaDataType MetaDataType
+
+	// HotplugSlots is the number of spare PCIe ports to create for
+	// hot-plugging disks and network interfaces. Set by domainmgr.
+	HotplugSlots int
+
+	// PodContainers, if any, make a container domain a pod: one
+	// container per entry instead of one for the domain
+	PodContainers []PodContainer
+
+	// InitContainers are run to completion, one after the other, each
+	// time before the domain is started
+	InitContainers []InitContainer
+
+	// Security is the hardening of a container domain
+	Security ContainerSecurity
+
+	// GuestRebootCounter is changed by zedmanager to have the guest
+	// agent reboot the guest OS of the running domain
+	GuestRebootCounter uint32
+
+	// RemoteConsole gives the task of a native container a terminal
+	// for the remote console. Applied when the domain is (re)started.
+	RemoteConsole bool
+}
+
+// SecurityProfile is the set of restrictions applied to the containers of
+// a container domain
+type SecurityProfile uint8
+
+const (
+	// SecurityProfileUnconfined runs the containers with the default
+	// capabilities of containerd and no seccomp filter
+	SecurityProfileUnconfined SecurityProfile = iota
+	// SecurityProfileDefault adds the default seccomp filter and
+	// no-new-privileges
+	SecurityProfileDefault
+	// SecurityProfileStrict also runs the containers in a user namespace,
+	// with a read-only rootfs and no capabilities unless added by CapAdd
+	SecurityProfileStrict
+)
+
+// String returns the name of the profile
+func (profile SecurityProfile) String() string {
+	switch profile {
+	case SecurityProfileUnconfined:
+		return "unconfined"
+	case SecurityProfileDefault:
+		return "default"
+	case SecurityProfileStrict:
+		return "strict"
+	default:
+		return fmt.Sprintf("Unknown SecurityProfile %d", profile)
+	}
+}
+
+// ContainerSecurity selects the security profile of a container domain
+// and adjusts its capabilities. Capabilities are named as in
+// capabilities(7), with or without the CAP_ prefix; ALL stands for all of
+// them.
+type ContainerSecurity struct {
+	Profile SecurityProfile
+	CapAdd  []string
+	CapDrop []string
+}
+
+// PodContainer is one of the containers of a pod. The containers of a pod
+// share the network namespace, hence the VIFs, of the first one to start.
+type PodContainer struct {
+	Name string
+	// ImageDisk is the index of the container volume with the image to
+	// run: in VolumeRefConfigList for an AppInstanceConfig, translated by
+	// zedmanager to the index in DiskConfigList for a DomainConfig
+	ImageDisk int
+	// Command replaces the entrypoint and command of the image if set
+	Command []string
+	// Env is added to the environment of the image
+	Env map[string]string
+	// Mounts are the volumes of the app instance the container sees
+	Mounts []PodMount
+	// DependsOn are the names of the containers which must be running
+	// before this one is started
+	DependsOn []string
+}
+
+// PodMount makes a volume of the app instance appear in a container of
+// the pod
+type PodMount struct {
+	// Disk is the index of the volume, in the same way as ImageDisk
+	Disk     int
+	Path     string
+	ReadOnly bool
+}
+
+// InitContainer is a container run to completion on the host before a
+// domain, of any kind, is started. It gets the VIFs of the domain while it
+// runs.
+type InitContainer struct {
+	Name string
+	// ImageDisk is the index of the container volume with the image to
+	// run, as in PodContainer. The domain does not see it unless it is a
+	// container domain.
+	ImageDisk int
+	// Command replaces the entrypoint and command of the image if set
+	Command []string
+	// Env is added to the environment of the image
+	Env map[string]string
+	// Mounts are the volumes of the app instance the container sees
+	Mounts []PodMount
+	// TimeLimit in seconds; zero means the default of domainmgr
+	TimeLimit uint
 }
 
 // MetaDataType of metadata service for app
@@ -94,6 +205,30 @@ func (config DomainConfig) GetTaskName() string {
 		strconv.Itoa(config.AppNum)
 }
 
+// Ballooning returns true if the memory of the domain can be grown from
+// Memory up to MaxMem while it is running
+func (config DomainConfig) Ballooning() bool {
+	return config.MaxMem > config.Memory
+}
+
+// VTPMSocket returns the path of the control socket of the swtpm
+// instance serving the domain
+func (config DomainConfig) VTPMSocket() string {
+	return VTPMSocketPath(config.UUIDandVersion.UUID)
+}
+
+// VTPMSocketPath returns the path of the control socket of the swtpm
+// instance for the app instance
+func VTPMSocketPath(appUUID uuid.UUID) string {
+	return filepath.Join(VTPMRunDir, appUUID.String()+".sock")
+}
+
+// VTPMStatePath returns the directory holding the persistent state of
+// the TPM of the app instance
+func VTPMStatePath(appUUID uuid.UUID) string {
+	return filepath.Join(VTPMStateDir, appUUID.String())
+}
+
 // DomainnameToUUID does the reverse of GetTaskName
 func DomainnameToUUID(name string) (uuid.UUID, error) {
 	// FIXME: we can likely drop this altogether
@@ -203,6 +338,19 @@ type VmConfig struct {
 	EnableVnc          bool
 	VncDisplay         uint32
 	VncPasswd          string
+	// EnableVTPM gives a KVM domain a software TPM 2.0 backed by an
+	// swtpm instance
+	EnableVTPM bool
+	// cgroup controls of the containers of the domain, or of the device
+	// model of a VM, on top of Memory and VCpus. Zero leaves the default.
+	CPUWeight uint16 // share of CPU time under contention, 1-10000, default 100
+	CPUQuota  uint32 // in percent of one CPU, default 100 per VCpu
+	IOWeight  uint16 // share of disk time under contention, 1-10000, default 100
+	ReadBps   uint64 // per disk used by the domain
+	WriteBps  uint64
+	ReadIOPS  uint64
+	WriteIOPS uint64
+	PidsLimit int64 // number of processes and threads
 }
 
 type VmMode uint8
@@ -252,6 +400,98 @@ type DomainStatus struct {
 	AdaptersFailed bool
 	OCIConfigDir   string            // folder holding an OCI Image config for this domain (empty string means no config)
 	EnvVariables   map[string]string // List of environment variables to be set in container
+	// MemoryTarget is the current balloon target in kbytes; zero
+	// unless the domain is ballooning between Memory and MaxMem
+	MemoryTarget int
+	// CPUs is the list of host CPUs allocated by domainmgr when CPU
+	// pinning is enabled
+	CPUs string
+	// HotplugSlots is the number of spare PCIe ports the running domain
+	// has; zero if disks and network interfaces can not be hot-plugged
+	HotplugSlots int
+	// GuestInfo is what the guest agent in the domain reports, if any
+	GuestInfo GuestInfo
+	// GuestRebootCounter is the GuestRebootCounter of the last reboot
+	// asked of the guest agent, or of the DomainConfig at activation
+	GuestRebootCounter uint32
+	// GuestRebootFailed is set if the guest agent could not be asked to
+	// reboot for GuestRebootCounter
+	GuestRebootFailed bool
+	// CrashDump is the memory dump saved the last time the guest kernel
+	// panicked, if any
+	CrashDump CrashDump
+	// PodContainers is the state of each container of a pod
+	PodContainers []PodContainerStatus
+	// InitContainers is the outcome of the last run of each of the
+	// InitContainers, in order
+	InitContainers []InitContainerStatus
+}
+
+// PodContainerStatus is the state of one container of a pod
+type PodContainerStatus struct {
+	Name     string
+	PID      int
+	State    SwState
+	ExitCode int
+}
+
+// InitContainerStatus is the outcome of a run of an init container. State
+// is RUNNING while it runs, HALTED once it succeeded and BROKEN if it
+// failed, which leaves the ones after it INITIAL.
+type InitContainerStatus struct {
+	Name      string
+	State     SwState
+	ExitCode  int
+	TimedOut  bool
+	Output    string // The end of its stdout and stderr
+	StartTime time.Time
+	EndTime   time.Time
+}
+
+// CrashDump is a memory dump of a domain whose guest kernel panicked. It
+// is kept in AppCrashDumpDir until evicted by newer dumps.
+type CrashDump struct {
+	FileLocation string
+	Size         int64
+	Time         time.Time
+}
+
+// GuestInfo is reported by the guest agent running inside a domain
+type GuestInfo struct {
+	AgentRunning  bool
+	OSName        string
+	OSVersion     string
+	KernelRelease string
+	Interfaces    []GuestInterface
+	Filesystems   []GuestFilesystem
+	LastUpdate    time.Time
+}
+
+// GuestInterface is a network interface as seen inside the domain
+type GuestInterface struct {
+	Name    string
+	MacAddr string
+	IPAddrs []string
+}
+
+// GuestFilesystem is a mounted filesystem inside the domain. The sizes are
+// zero if the guest agent does not report them.
+type GuestFilesystem struct {
+	Mountpoint string
+	Type       string
+	UsedBytes  uint64
+	TotalBytes uint64
+}
+
+// IPAddrsByMac returns the addresses the guest has on the interface with
+// the MAC address
+func (info GuestInfo) IPAddrsByMac(mac string) []string {
+	for _, intf := range info.Interfaces {
+		if strings.EqualFold(intf.MacAddr, mac) {
+			return intf.IPAddrs
+		}
+	}
+	return nil
 }
 
 func (status DomainStatus) Key() string {
@@ -262,6 +502,26 @@ func (status DomainStatus) Pending() bool {
 	return status.PendingAdd || status.PendingModify || status.PendingDelete
 }
 
+// FreeHotplugSlots returns how many more disks and network interfaces can
+// be hot-plugged into the running domain
+func (status DomainStatus) FreeHotplugSlots() int {
+	free := status.HotplugSlots
+	for _, ds := range status.DiskStatusList {
+		if ds.Hotplugged {
+			free--
+		}
+	}
+	for _, net := range status.VifList {
+		if net.Hotplugged {
+			free--
+		}
+	}
+	if free < 0 {
+		free = 0
+	}
+	return free
+}
+
 // VifInfoByVif looks up based on the name aka Vif
 func (status DomainStatus) VifInfoByVif(vif string) *VifInfo {
 	for i := range status.VifList {
@@ -348,6 +608,9 @@ type VifInfo struct {
 	Mac     string
 
 	Vlan VlanInfo
+	// Hotplugged is set by domainmgr when the interface was added to
+	// the running domain
+	Hotplugged bool
 }
 
 // DomainManager will pass these to the xen xl config file
@@ -373,6 +636,8 @@ type DiskStatus struct {
 	DisplayName  string
 	Devtype      string // XXX used internally by hypervisor; deprecate?
 	Vdev         string // Allocated
+	// Hotplugged is set when the disk was added to the running domain
+	Hotplugged bool
 }
 
 // DomainMetric carries CPU and memory usage. UUID=devUUID for the dom0/host metrics overhead
@@ -384,6 +649,25 @@ type DomainMetric struct {
 	UsedMemoryPercent float64
 	LastHeard         time.Time
 	Activated         bool
+	// MemoryTarget is the current balloon target in MBytes; zero
+	// unless the domain is ballooning
+	MemoryTarget uint32
+	Throttling   CgroupThrottling
+}
+
+// CgroupThrottling counts how often the cgroup limits of a domain, see
+// VmConfig, held it back
+type CgroupThrottling struct {
+	CPUThrottledPeriods uint64 // CFS periods in which CPUQuota was used up
+	CPUThrottledTime    uint64 // in microseconds
+	PidsLimitHits       uint64 // forks which failed on PidsLimit
+}
+
+// Add adds the counters of another cgroup
+func (t *CgroupThrottling) Add(other CgroupThrottling) {
+	t.CPUThrottledPeriods += other.CPUThrottledPeriods
+	t.CPUThrottledTime += other.CPUThrottledTime
+	t.PidsLimitHits += other.PidsLimitHits
 }
 
 // Key returns the key for pubsub
@@ -111,6 +111,10 @@ type DownloaderStatus struct {
 	Progress      uint      // In percent i.e., 0-100, given by CurrentSize/ExpectedSize
 	ModTime       time.Time
 	ContentType   string // content-type header, if provided
+	// Paused is set while downloads are not allowed by the download
+	// window; the download resumes at ResumeTime
+	Paused     bool
+	ResumeTime time.Time
 	// ErrorAndTime provides SetErrorNow() and ClearError()
 	ErrorAndTime
 	RetryCount int
@@ -170,14 +174,17 @@ func (status DownloaderStatus) LogModify(logBase *base.LogObject, old interface{
 	}
 	if oldStatus.State != status.State ||
 		oldStatus.RefCount != status.RefCount ||
-		oldStatus.Size != status.Size {
+		oldStatus.Size != status.Size ||
+		oldStatus.Paused != status.Paused {
 
 		logObject.CloneAndAddField("state", status.State.String()).
 			AddField("refcount-int64", status.RefCount).
 			AddField("size-int64", status.Size).
+			AddField("paused", status.Paused).
 			AddField("old-state", oldStatus.State.String()).
 			AddField("old-refcount-int64", oldStatus.RefCount).
 			AddField("old-size-int64", oldStatus.Size).
+			AddField("old-paused", oldStatus.Paused).
 			Noticef("Download status modify")
 	} else {
 		// XXX remove?
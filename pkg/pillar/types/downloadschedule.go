@@ -0,0 +1,207 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package types
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DownloadWindow is a weekly recurring time window during which downloads
+// are allowed. When End is not after Start the window extends into the
+// next day, thus "mon-fri 18:00-08:00" ends on Saturday morning.
+type DownloadWindow struct {
+	Days  [7]bool       // indexed by time.Weekday; the day the window starts
+	Start time.Duration // offset from midnight
+	End   time.Duration // offset from midnight, up to 24h
+}
+
+// DownloadSchedule is a set of download windows. An empty schedule means
+// that downloads are always allowed.
+type DownloadSchedule []DownloadWindow
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// ParseDownloadSchedule parses a list of windows separated by ';' where
+// each window is an optional day specification followed by HH:MM-HH:MM.
+// Days are given as a range such as "mon-fri", a list such as
+// "sat,sun", or "daily" which is also the default. Times are in the
+// device's local time zone.
+// Example: "mon-fri 18:00-08:00; sat-sun 00:00-24:00"
+func ParseDownloadSchedule(s string) (DownloadSchedule, error) {
+	var sched DownloadSchedule
+	for _, w := range strings.Split(s, ";") {
+		w = strings.TrimSpace(w)
+		if w == "" {
+			continue
+		}
+		window, err := parseDownloadWindow(w)
+		if err != nil {
+			return nil, fmt.Errorf("window %q: %v", w, err)
+		}
+		sched = append(sched, window)
+	}
+	return sched, nil
+}
+
+func parseDownloadWindow(s string) (DownloadWindow, error) {
+	var window DownloadWindow
+	fields := strings.Fields(strings.ToLower(s))
+	var days, times string
+	switch len(fields) {
+	case 1:
+		days, times = "daily", fields[0]
+	case 2:
+		days, times = fields[0], fields[1]
+	default:
+		return window, fmt.Errorf("expected [days] HH:MM-HH:MM")
+	}
+	if err := parseWindowDays(days, &window.Days); err != nil {
+		return window, err
+	}
+	startEnd := strings.Split(times, "-")
+	if len(startEnd) != 2 {
+		return window, fmt.Errorf("bad time range %s", times)
+	}
+	var err error
+	if window.Start, err = parseTimeOfDay(startEnd[0]); err != nil {
+		return window, err
+	}
+	if window.End, err = parseTimeOfDay(startEnd[1]); err != nil {
+		return window, err
+	}
+	if window.Start == 24*time.Hour {
+		return window, fmt.Errorf("window cannot start at 24:00")
+	}
+	if window.Start == window.End {
+		return window, fmt.Errorf("empty window %s", times)
+	}
+	return window, nil
+}
+
+func parseWindowDays(s string, days *[7]bool) error {
+	if s == "daily" || s == "*" {
+		for i := range days {
+			days[i] = true
+		}
+		return nil
+	}
+	for _, d := range strings.Split(s, ",") {
+		fromTo := strings.Split(d, "-")
+		if len(fromTo) > 2 {
+			return fmt.Errorf("bad day range %s", d)
+		}
+		from, ok := weekdayNames[fromTo[0]]
+		if !ok {
+			return fmt.Errorf("unknown day %s", fromTo[0])
+		}
+		to := from
+		if len(fromTo) == 2 {
+			if to, ok = weekdayNames[fromTo[1]]; !ok {
+				return fmt.Errorf("unknown day %s", fromTo[1])
+			}
+		}
+		// A range such as fri-mon wraps around the week
+		for day := from; ; day = (day + 1) % 7 {
+			days[day] = true
+			if day == to {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// parseTimeOfDay parses HH:MM where 24:00 is allowed
+func parseTimeOfDay(s string) (time.Duration, error) {
+	hm := strings.Split(s, ":")
+	if len(hm) != 2 {
+		return 0, fmt.Errorf("bad time %s", s)
+	}
+	hours, err := strconv.Atoi(hm[0])
+	if err != nil {
+		return 0, fmt.Errorf("bad time %s: %v", s, err)
+	}
+	minutes, err := strconv.Atoi(hm[1])
+	if err != nil {
+		return 0, fmt.Errorf("bad time %s: %v", s, err)
+	}
+	if hours < 0 || minutes < 0 || minutes > 59 || hours > 24 ||
+		(hours == 24 && minutes != 0) {
+		return 0, fmt.Errorf("bad time %s", s)
+	}
+	return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute, nil
+}
+
+// Allowed returns true if downloads are allowed at t
+func (sched DownloadSchedule) Allowed(t time.Time) bool {
+	if len(sched) == 0 {
+		return true
+	}
+	day := t.Weekday()
+	prevDay := (day + 6) % 7
+	offset := t.Sub(midnight(t, 0))
+	for _, w := range sched {
+		if w.Start < w.End {
+			if w.Days[day] && offset >= w.Start && offset < w.End {
+				return true
+			}
+			continue
+		}
+		// Window extends into the next day
+		if w.Days[day] && offset >= w.Start {
+			return true
+		}
+		if w.Days[prevDay] && offset < w.End {
+			return true
+		}
+	}
+	return false
+}
+
+// NextChange returns the first time after t when Allowed changes, or the
+// zero time if it never changes
+func (sched DownloadSchedule) NextChange(t time.Time) time.Time {
+	if len(sched) == 0 {
+		return time.Time{}
+	}
+	// Allowed can only change at the start or end of a window hence
+	// check those edges over the next week in time order
+	var edges []time.Time
+	for dayOffset := 0; dayOffset <= 7; dayOffset++ {
+		base := midnight(t, dayOffset)
+		for _, w := range sched {
+			for _, edge := range []time.Time{base.Add(w.Start), base.Add(w.End)} {
+				if edge.After(t) {
+					edges = append(edges, edge)
+				}
+			}
+		}
+	}
+	sort.Slice(edges, func(i, j int) bool { return edges[i].Before(edges[j]) })
+	allowed := sched.Allowed(t)
+	for _, edge := range edges {
+		if sched.Allowed(edge) != allowed {
+			return edge
+		}
+	}
+	return time.Time{}
+}
+
+// midnight returns the start of the day dayOffset days after the day of t
+func midnight(t time.Time, dayOffset int) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day+dayOffset, 0, 0, 0, 0, t.Location())
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package types
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDownloadSchedule(t *testing.T) {
+	testMatrix := map[string]struct {
+		schedule    string
+		expectError bool
+		windows     int
+	}{
+		"Empty": {
+			schedule: "",
+			windows:  0,
+		},
+		"Daily": {
+			schedule: "01:00-05:30",
+			windows:  1,
+		},
+		"Weekdays and weekend": {
+			schedule: "mon-fri 18:00-08:00; sat-sun 00:00-24:00",
+			windows:  2,
+		},
+		"Day list": {
+			schedule: "Mon,Wed,fri-sun 22:00-23:00;",
+			windows:  1,
+		},
+		"Unknown day": {
+			schedule:    "mo-fri 18:00-08:00",
+			expectError: true,
+		},
+		"Bad time": {
+			schedule:    "daily 18:00-25:00",
+			expectError: true,
+		},
+		"Empty window": {
+			schedule:    "daily 18:00-18:00",
+			expectError: true,
+		},
+		"Missing end": {
+			schedule:    "daily 18:00",
+			expectError: true,
+		},
+	}
+	for testname, test := range testMatrix {
+		t.Logf("Running test case %s", testname)
+		sched, err := ParseDownloadSchedule(test.schedule)
+		if test.expectError {
+			assert.Error(t, err)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, test.windows, len(sched))
+	}
+}
+
+func TestDownloadScheduleAllowed(t *testing.T) {
+	sched, err := ParseDownloadSchedule("mon-fri 18:00-08:00; sat-sun 00:00-24:00")
+	assert.NoError(t, err)
+
+	// 2021-03-01 is a Monday
+	at := func(day, hour, min int) time.Time {
+		return time.Date(2021, time.March, day, hour, min, 0, 0, time.UTC)
+	}
+	testMatrix := map[string]struct {
+		time       time.Time
+		allowed    bool
+		nextChange time.Time
+	}{
+		"Monday morning": {
+			time:       at(1, 7, 0),
+			allowed:    false,
+			nextChange: at(1, 18, 0),
+		},
+		"Monday evening": {
+			time:       at(1, 18, 0),
+			allowed:    true,
+			nextChange: at(2, 8, 0),
+		},
+		"Tuesday night": {
+			time:       at(3, 2, 0),
+			allowed:    true,
+			nextChange: at(3, 8, 0),
+		},
+		"Friday evening into weekend": {
+			time:       at(5, 20, 0),
+			allowed:    true,
+			nextChange: at(8, 0, 0),
+		},
+		"Sunday": {
+			time:       at(7, 12, 0),
+			allowed:    true,
+			nextChange: at(8, 0, 0),
+		},
+	}
+	for testname, test := range testMatrix {
+		t.Logf("Running test case %s", testname)
+		assert.Equal(t, test.allowed, sched.Allowed(test.time))
+		assert.Equal(t, test.nextChange, sched.NextChange(test.time))
+	}
+
+	var always DownloadSchedule
+	assert.True(t, always.Allowed(at(1, 7, 0)))
+	assert.True(t, always.NextChange(at(1, 7, 0)).IsZero())
+}
@@ -142,10 +142,19 @@ const (
 	VdiskGCTime GlobalSettingKey = "timer.gc.vdisk"
 	// DeferContentDelete global setting key
 	DeferContentDelete GlobalSettingKey = "timer.defer.content.delete"
+	// ContentGCInterval global setting key
+	ContentGCInterval GlobalSettingKey = "timer.gc.content"
+	// ContentGCMinAge global setting key; how long content must have been
+	// found unreferenced before the content garbage collector deletes it
+	ContentGCMinAge GlobalSettingKey = "timer.gc.content.minage"
 	// DownloadRetryTime global setting key
 	DownloadRetryTime GlobalSettingKey = "timer.download.retry"
 	// DownloadStalledTime global setting key
 	DownloadStalledTime GlobalSettingKey = "timer.download.stalled"
+	// UploadRetryTime global setting key
+	UploadRetryTime GlobalSettingKey = "timer.upload.retry"
+	// UploadMaxRetries global setting key
+	UploadMaxRetries GlobalSettingKey = "upload.max.retries"
 	// DomainBootRetryTime global setting key
 	DomainBootRetryTime GlobalSettingKey = "timer.boot.retry"
 	// NetworkGeoRedoTime global setting key
@@ -181,6 +190,21 @@ const (
 	// how the EVE microservices will use free and non-free (e.g., WWAN)
 	// ports for image downloads.
 	DownloadMaxPortCost GlobalSettingKey = "network.download.max.cost"
+	// DownloadMaxBps global setting key caps the combined bandwidth in
+	// bytes per second used for downloads. Zero means unlimited.
+	DownloadMaxBps GlobalSettingKey = "network.download.max.bps"
+	// EveCPUsReserved global setting key; the number of CPUs, starting
+	// with CPU 0, which are never handed out to app instances when
+	// CPU pinning is enabled
+	EveCPUsReserved GlobalSettingKey = "cpu.eve.reserved"
+	// AppHotplugSlots global setting key; the number of spare PCIe ports
+	// given to each KVM app instance for hot-plugging volumes and network
+	// interfaces. Takes effect when the app instance is next booted.
+	AppHotplugSlots GlobalSettingKey = "app.hotplug.slots"
+	// AppCrashDumpMaxSize global setting key; the space in MBytes the
+	// memory dumps of panicked app instances may take in /persist. 0
+	// disables saving them.
+	AppCrashDumpMaxSize GlobalSettingKey = "app.crashdump.max.size"
 
 	// Bool Items
 	// UsbAccess global setting key
@@ -195,6 +219,15 @@ const (
 	IgnoreDiskCheckForApps GlobalSettingKey = "storage.apps.ignore.disk.check"
 	// AllowLogFastupload global setting key
 	AllowLogFastupload GlobalSettingKey = "newlog.allow.fastupload"
+	// CPUPinning global setting key; dedicate CPUs to the vCPUs of
+	// the app instances
+	CPUPinning GlobalSettingKey = "cpu.pinning.enable"
+	// LazyImagePull global setting key; leave the layers of eStargz
+	// container images to be fetched when they are read
+	LazyImagePull GlobalSettingKey = "app.lazy.pull"
+	// KubernetesNodeEnable global setting key; run a Kubernetes node for
+	// the app instances deployed as Kubernetes objects
+	KubernetesNodeEnable GlobalSettingKey = "kubernetes.node.enable"
 
 	// TriState Items
 	// NetworkFallbackAnyEth global setting key
@@ -210,6 +243,11 @@ const (
 	DefaultLogLevel GlobalSettingKey = "debug.default.loglevel"
 	// DefaultRemoteLogLevel global setting key
 	DefaultRemoteLogLevel GlobalSettingKey = "debug.default.remote.loglevel"
+	// DownloadWindows global setting key lists the time windows during
+	// which downloads are allowed. Empty means always.
+	DownloadWindows GlobalSettingKey = "network.download.window"
+	// ContentGCPolicyKey global setting key; one of off, report or delete
+	ContentGCPolicyKey GlobalSettingKey = "storage.gc.content.policy"
 
 	// XXX Temporary flag to disable RFC 3442 classless static route usage
 	DisableDHCPAllOnesNetMask GlobalSettingKey = "debug.disable.dhcp.all-ones.netmask"
@@ -742,8 +780,12 @@ func NewConfigItemSpecMap() ConfigItemSpecMap {
 	configItemSpecMap.AddIntItem(StaleConfigTime, 7*24*3600, 0, 0xFFFFFFFF)
 	configItemSpecMap.AddIntItem(VdiskGCTime, 3600, 60, 0xFFFFFFFF)
 	configItemSpecMap.AddIntItem(DeferContentDelete, 0, 0, 24*3600)
+	configItemSpecMap.AddIntItem(ContentGCInterval, 3600, 60, 0xFFFFFFFF)
+	configItemSpecMap.AddIntItem(ContentGCMinAge, 3600, 0, 0xFFFFFFFF)
 	configItemSpecMap.AddIntItem(DownloadRetryTime, 600, 60, 0xFFFFFFFF)
 	configItemSpecMap.AddIntItem(DownloadStalledTime, 600, 20, 0xFFFFFFFF)
+	configItemSpecMap.AddIntItem(UploadRetryTime, 600, 60, 0xFFFFFFFF)
+	configItemSpecMap.AddIntItem(UploadMaxRetries, 10, 0, 0xFFFFFFFF)
 	configItemSpecMap.AddIntItem(DomainBootRetryTime, 600, 10, 0xFFFFFFFF)
 	configItemSpecMap.AddIntItem(NetworkGeoRedoTime, 3600, 60, 0xFFFFFFFF)
 	configItemSpecMap.AddIntItem(NetworkGeoRetryTime, 600, 5, 0xFFFFFFFF)
@@ -764,6 +806,10 @@ func NewConfigItemSpecMap() ConfigItemSpecMap {
 	// LogRemainToSendMBytes - Default is 2 Gbytes, minimum is 10 Mbytes
 	configItemSpecMap.AddIntItem(LogRemainToSendMBytes, 2048, 10, 0xFFFFFFFF)
 	configItemSpecMap.AddIntItem(DownloadMaxPortCost, 0, 0, 255)
+	configItemSpecMap.AddIntItem(DownloadMaxBps, 0, 0, 0xFFFFFFFF)
+	configItemSpecMap.AddIntItem(EveCPUsReserved, 1, 0, 1024)
+	configItemSpecMap.AddIntItem(AppHotplugSlots, 4, 0, 8)
+	configItemSpecMap.AddIntItem(AppCrashDumpMaxSize, 2048, 0, 0xFFFFFFFF)
 
 	// Add Bool Items
 	configItemSpecMap.AddBoolItem(UsbAccess, true) // Controller likely default to false
@@ -771,6 +817,9 @@ func NewConfigItemSpecMap() ConfigItemSpecMap {
 	configItemSpecMap.AddBoolItem(IgnoreMemoryCheckForApps, false)
 	configItemSpecMap.AddBoolItem(IgnoreDiskCheckForApps, false)
 	configItemSpecMap.AddBoolItem(AllowLogFastupload, false)
+	configItemSpecMap.AddBoolItem(CPUPinning, false)
+	configItemSpecMap.AddBoolItem(LazyImagePull, false)
+	configItemSpecMap.AddBoolItem(KubernetesNodeEnable, false)
 	configItemSpecMap.AddBoolItem(DisableDHCPAllOnesNetMask, false)
 	configItemSpecMap.AddBoolItem(ProcessCloudInitMultiPart, false)
 
@@ -782,6 +831,9 @@ func NewConfigItemSpecMap() ConfigItemSpecMap {
 	configItemSpecMap.AddStringItem(SSHAuthorizedKeys, "", blankValidator)
 	configItemSpecMap.AddStringItem(DefaultLogLevel, "info", parseLevel)
 	configItemSpecMap.AddStringItem(DefaultRemoteLogLevel, "info", parseLevel)
+	configItemSpecMap.AddStringItem(DownloadWindows, "", parseDownloadWindowItem)
+	configItemSpecMap.AddStringItem(ContentGCPolicyKey, ContentGCReport.String(),
+		parseContentGCPolicyItem)
 
 	// Add Agent Settings
 	configItemSpecMap.AddAgentSettingStringItem(LogLevel, "info", parseLevel)
@@ -796,6 +848,20 @@ func parseLevel(level string) error {
 	return err
 }
 
+// parseDownloadWindowItem - Wrapper that ignores the schedule returned by
+// ParseDownloadSchedule
+func parseDownloadWindowItem(s string) error {
+	_, err := ParseDownloadSchedule(s)
+	return err
+}
+
+// parseContentGCPolicyItem - Wrapper that ignores the policy returned by
+// ParseContentGCPolicy
+func parseContentGCPolicyItem(s string) error {
+	_, err := ParseContentGCPolicy(s)
+	return err
+}
+
 // blankValidator - A validator that accepts any string
 func blankValidator(s string) error {
 	return nil
@@ -154,9 +154,13 @@ func TestNewConfigItemSpecMap(t *testing.T) {
 		MintimeUpdateSuccess,
 		StaleConfigTime,
 		VdiskGCTime,
+		ContentGCInterval,
+		ContentGCMinAge,
 		DeferContentDelete,
 		DownloadRetryTime,
 		DownloadStalledTime,
+		UploadRetryTime,
+		UploadMaxRetries,
 		DomainBootRetryTime,
 		NetworkGeoRedoTime,
 		NetworkGeoRetryTime,
@@ -172,6 +176,10 @@ func TestNewConfigItemSpecMap(t *testing.T) {
 		ForceFallbackCounter,
 		LogRemainToSendMBytes,
 		DownloadMaxPortCost,
+		DownloadMaxBps,
+		EveCPUsReserved,
+		AppHotplugSlots,
+		AppCrashDumpMaxSize,
 		// Bool Items
 		UsbAccess,
 		AllowAppVnc,
@@ -179,6 +187,9 @@ func TestNewConfigItemSpecMap(t *testing.T) {
 		IgnoreMemoryCheckForApps,
 		IgnoreDiskCheckForApps,
 		AllowLogFastupload,
+		CPUPinning,
+		LazyImagePull,
+		KubernetesNodeEnable,
 		// TriState Items
 		NetworkFallbackAnyEth,
 		MaintenanceMode,
@@ -186,6 +197,8 @@ func TestNewConfigItemSpecMap(t *testing.T) {
 		SSHAuthorizedKeys,
 		DefaultLogLevel,
 		DefaultRemoteLogLevel,
+		DownloadWindows,
+		ContentGCPolicyKey,
 		DisableDHCPAllOnesNetMask,
 		ProcessCloudInitMultiPart,
 	}
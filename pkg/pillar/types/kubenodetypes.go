@@ -0,0 +1,82 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+// Types for the app instances deployed as Kubernetes objects to the k3s
+// node of the device. Served by kubenodemgr.
+
+package types
+
+import (
+	uuid "github.com/satori/go.uuid"
+)
+
+// KubeAppConfig is an app instance with a KubeManifest, passed on by
+// zedmanager once its volumes are ready
+type KubeAppConfig struct {
+	UUIDandVersion UUIDandVersion
+	DisplayName    string
+	Activate       bool
+	Manifest       string `json:"pubsub-large-Manifest"`
+	// VolumeKeys are the keys of the VolumeStatus of the volumes, in the
+	// order of VolumeRefConfigList
+	VolumeKeys []string
+	// NetworkInstance is the one the pods join, from the first
+	// UnderlayNetworkConfig; nil if the app instance has none
+	NetworkInstance uuid.UUID
+}
+
+// Key returns the pubsub key
+func (config KubeAppConfig) Key() string {
+	return config.UUIDandVersion.UUID.String()
+}
+
+// Namespace returns the Kubernetes namespace of the app instance
+func (config KubeAppConfig) Namespace() string {
+	return KubeNamespace(config.UUIDandVersion.UUID)
+}
+
+// KubeNamespace returns the Kubernetes namespace of the app instance
+func KubeNamespace(appUUID uuid.UUID) string {
+	return "eve-" + appUUID.String()
+}
+
+// KubeAppStatus is the state of a KubeAppConfig. It is Activated once its
+// objects are handed to k3s, and RUNNING once all of its pods are running.
+type KubeAppStatus struct {
+	UUIDandVersion UUIDandVersion
+	DisplayName    string
+	Activated      bool
+	State          SwState
+	Pods           []KubePodStatus
+	// ErrorAndTime provides SetErrorNow() and ClearError()
+	ErrorAndTime
+}
+
+// Key returns the pubsub key
+func (status KubeAppStatus) Key() string {
+	return status.UUIDandVersion.UUID.String()
+}
+
+// KubePodStatus is the state of a pod as reported by Kubernetes
+type KubePodStatus struct {
+	Name     string
+	Phase    string // Pending, Running, Succeeded, Failed or Unknown
+	Ready    bool
+	Restarts int
+}
+
+// KubeNodeStatus is the state of the k3s node of the device
+type KubeNodeStatus struct {
+	Enabled bool
+	Ready   bool
+	Version string // Of the kubelet
+	// PodNetworkInstance is the network instance the pods join, if any
+	PodNetworkInstance uuid.UUID
+	// ErrorAndTime provides SetErrorNow() and ClearError()
+	ErrorAndTime
+}
+
+// Key returns the pubsub key
+func (status KubeNodeStatus) Key() string {
+	return "global"
+}
@@ -25,6 +25,8 @@ const (
 	VolumeClearDirName = ClearDirName + "/volumes"
 	// PersistDebugDir - Location for service specific debug/traces
 	PersistDebugDir = PersistDir + "/agentdebug"
+	// AppCrashDumpDir - memory dumps of app instances whose kernel panicked
+	AppCrashDumpDir = PersistDir + "/crashdumps"
 	//VolumeZFSPool - pool for create volumes
 	VolumeZFSPool = "persist" + "/volumes"
 
@@ -49,6 +51,9 @@ const (
 
 	// APIV1FileName - user can statically allow for API v1
 	APIV1FileName = IdentityDirname + "/Force-API-V1"
+	// CASTypeFileName - names the CAS used for the app images if it is
+	// not containerd
+	CASTypeFileName = IdentityDirname + "/cas-type"
 
 	// ServerSigningCertFileName - filename for server signing leaf certificate
 	ServerSigningCertFileName = CertificateDirname + "/server-signing-cert.pem"
@@ -82,4 +87,13 @@ const (
 
 	// ContainerdContentDir - path to containerd`s content store
 	ContainerdContentDir = PersistDir + "/containerd/io.containerd.content.v1.content"
+
+	// FilesystemCASDir - path to the blobs, images and snapshots of the
+	// filesystem CAS
+	FilesystemCASDir = SealedDirName + "/fscas"
+
+	// VTPMStateDir - persistent state of the software TPMs of the domains
+	VTPMStateDir = SealedDirName + "/vtpm"
+	// VTPMRunDir - control sockets and pid files of the swtpm instances
+	VTPMRunDir = "/run/hypervisor/swtpm"
 )
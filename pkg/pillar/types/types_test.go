@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"time"
 )
 
 func TestParseTriState(t *testing.T) {
@@ -62,3 +63,126 @@ func TestParseTriState(t *testing.T) {
 		assert.Equal(t, test.ts, ts)
 	}
 }
+
+func TestGuestInfoIPAddrsByMac(t *testing.T) {
+	info := GuestInfo{
+		AgentRunning: true,
+		Interfaces: []GuestInterface{
+			{Name: "eth0", MacAddr: "02:16:3e:00:00:01",
+				IPAddrs: []string{"10.1.0.2", "fe80::16:3eff:fe00:1"}},
+			{Name: "eth1", MacAddr: "02:16:3e:00:00:02"},
+		},
+	}
+	testMatrix := map[string]struct {
+		mac      string
+		expected []string
+	}{
+		"Match": {
+			mac:      "02:16:3e:00:00:01",
+			expected: []string{"10.1.0.2", "fe80::16:3eff:fe00:1"},
+		},
+		"Match upper case": {
+			mac:      "02:16:3E:00:00:01",
+			expected: []string{"10.1.0.2", "fe80::16:3eff:fe00:1"},
+		},
+		"No addresses": {
+			mac:      "02:16:3e:00:00:02",
+			expected: nil,
+		},
+		"No match": {
+			mac:      "02:16:3e:00:00:03",
+			expected: nil,
+		},
+	}
+
+	for testname, test := range testMatrix {
+		t.Logf("Running test %s", testname)
+		assert.Equal(t, test.expected, info.IPAddrsByMac(test.mac))
+	}
+}
+
+func TestHealthConfigBackoffDelay(t *testing.T) {
+	testMatrix := map[string]struct {
+		backoff  uint32
+		restarts uint32
+		expect   time.Duration
+	}{
+		"First restart": {
+			restarts: 0,
+			expect:   0,
+		},
+		"Default backoff": {
+			restarts: 1,
+			expect:   DefaultRestartBackoff,
+		},
+		"Doubles": {
+			backoff:  5,
+			restarts: 3,
+			expect:   20 * time.Second,
+		},
+		"Capped": {
+			backoff:  60,
+			restarts: 10,
+			expect:   MaxRestartBackoff,
+		},
+	}
+	for testname, test := range testMatrix {
+		t.Logf("Running test case %s", testname)
+		hc := HealthConfig{RestartBackoff: test.backoff}
+		assert.Equal(t, test.expect, hc.BackoffDelay(test.restarts))
+	}
+}
+
+func TestHealthConfigNeedRestart(t *testing.T) {
+	testMatrix := map[string]struct {
+		policy      RestartPolicy
+		state       SwState
+		domainError bool
+		unhealthy   bool
+		expect      bool
+	}{
+		"Never when broken": {
+			policy: RestartPolicyNever,
+			state:  BROKEN,
+			expect: false,
+		},
+		"On failure when running": {
+			policy: RestartPolicyOnFailure,
+			state:  RUNNING,
+			expect: false,
+		},
+		"On failure when unhealthy": {
+			policy:    RestartPolicyOnFailure,
+			state:     RUNNING,
+			unhealthy: true,
+			expect:    true,
+		},
+		"On failure when broken": {
+			policy: RestartPolicyOnFailure,
+			state:  BROKEN,
+			expect: true,
+		},
+		"On failure when halted": {
+			policy: RestartPolicyOnFailure,
+			state:  HALTED,
+			expect: false,
+		},
+		"On failure when halted with error": {
+			policy:      RestartPolicyOnFailure,
+			state:       HALTED,
+			domainError: true,
+			expect:      true,
+		},
+		"Always when halted": {
+			policy: RestartPolicyAlways,
+			state:  HALTED,
+			expect: true,
+		},
+	}
+	for testname, test := range testMatrix {
+		t.Logf("Running test case %s", testname)
+		hc := HealthConfig{RestartPolicy: test.policy}
+		assert.Equal(t, test.expect,
+			hc.NeedRestart(test.state, test.domainError, test.unhealthy))
+	}
+}
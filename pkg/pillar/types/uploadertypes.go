@@ -0,0 +1,206 @@
+// Copyright (c) 2021 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package types
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/lf-edge/eve/pkg/pillar/base"
+	uuid "github.com/satori/go.uuid"
+)
+
+// UploaderConfig asks the uploader to send a file on the device, such as a
+// diagnostic bundle or a core dump, to a datastore.
+// The key is the UUID which is allocated by the publisher.
+// The upload is redone when Sequence changes.
+type UploaderConfig struct {
+	UUID        uuid.UUID
+	DatastoreID uuid.UUID
+	LocalPath   string // file to upload
+	RemoteName  string // object name relative to the datastore path
+	Sequence    int    // To be able to repeat the upload of the same file
+	DeleteLocal bool   // Remove LocalPath once the upload succeeded
+}
+
+// Key is the key in pubsub
+func (config UploaderConfig) Key() string {
+	return config.UUID.String()
+}
+
+// LogCreate :
+func (config UploaderConfig) LogCreate(logBase *base.LogObject) {
+	logObject := base.NewLogObject(logBase, base.UploaderConfigLogType, config.RemoteName,
+		config.UUID, config.LogKey())
+	if logObject == nil {
+		return
+	}
+	logObject.CloneAndAddField("local-path", config.LocalPath).
+		AddField("datastore-id", config.DatastoreID).
+		AddField("sequence-int64", config.Sequence).
+		Noticef("Uploader config create")
+}
+
+// LogModify :
+func (config UploaderConfig) LogModify(logBase *base.LogObject, old interface{}) {
+	logObject := base.EnsureLogObject(logBase, base.UploaderConfigLogType, config.RemoteName,
+		config.UUID, config.LogKey())
+
+	oldConfig, ok := old.(UploaderConfig)
+	if !ok {
+		logObject.Clone().Fatalf("LogModify: Old object interface passed is not of UploaderConfig type")
+	}
+	logObject.CloneAndAddField("diff", cmp.Diff(oldConfig, config)).
+		Noticef("Uploader config modify")
+}
+
+// LogDelete :
+func (config UploaderConfig) LogDelete(logBase *base.LogObject) {
+	logObject := base.EnsureLogObject(logBase, base.UploaderConfigLogType, config.RemoteName,
+		config.UUID, config.LogKey())
+	logObject.CloneAndAddField("local-path", config.LocalPath).
+		AddField("datastore-id", config.DatastoreID).
+		AddField("sequence-int64", config.Sequence).
+		Noticef("Uploader config delete")
+
+	base.DeleteLogObject(logBase, config.LogKey())
+}
+
+// LogKey :
+func (config UploaderConfig) LogKey() string {
+	return string(base.UploaderConfigLogType) + "-" + config.Key()
+}
+
+// UploadState is the state of an upload
+type UploadState uint8
+
+// Enum of UploadState
+const (
+	UploadStateNone UploadState = iota
+	UploadStatePending
+	UploadStateUploading
+	UploadStateUploaded
+	UploadStateFailed
+)
+
+// String returns the string representation of UploadState
+func (state UploadState) String() string {
+	switch state {
+	case UploadStateNone:
+		return "NONE"
+	case UploadStatePending:
+		return "PENDING"
+	case UploadStateUploading:
+		return "UPLOADING"
+	case UploadStateUploaded:
+		return "UPLOADED"
+	case UploadStateFailed:
+		return "FAILED"
+	default:
+		return fmt.Sprintf("Unknown state %d", state)
+	}
+}
+
+// UploaderStatus reports the progress of an UploaderConfig.
+// For datastores supporting multipart uploads the parts uploaded so far
+// are recorded here, and since the status is persistent, an interrupted
+// upload is resumed from the first missing part.
+type UploaderStatus struct {
+	UUID        uuid.UUID
+	DatastoreID uuid.UUID
+	LocalPath   string
+	RemoteName  string
+	Sequence    int
+	State       UploadState
+	TotalSize   int64 // size of LocalPath
+	CurrentSize int64 // bytes uploaded so far
+	Progress    uint  // In percent i.e., 0-100
+	ModTime     time.Time
+	// Location of the uploaded object as reported by the datastore, if any
+	RemoteLocation string
+
+	// Multipart state. UploadID is empty when not using multipart
+	UploadID string
+	PartSize int64
+	Parts    []string // ETags or block IDs in part order
+
+	// ErrorAndTime provides SetErrorNow() and ClearError()
+	ErrorAndTime
+	RetryCount int
+}
+
+// Key is the key in pubsub
+func (status UploaderStatus) Key() string {
+	return status.UUID.String()
+}
+
+// ClearMultipart forgets any partial upload so the next attempt starts over
+func (status *UploaderStatus) ClearMultipart() {
+	status.UploadID = ""
+	status.PartSize = 0
+	status.Parts = nil
+}
+
+// LogCreate :
+func (status UploaderStatus) LogCreate(logBase *base.LogObject) {
+	logObject := base.NewLogObject(logBase, base.UploaderStatusLogType, status.RemoteName,
+		status.UUID, status.LogKey())
+	if logObject == nil {
+		return
+	}
+	logObject.CloneAndAddField("state", status.State.String()).
+		AddField("total-size-int64", status.TotalSize).
+		Noticef("Uploader status create")
+}
+
+// LogModify :
+func (status UploaderStatus) LogModify(logBase *base.LogObject, old interface{}) {
+	logObject := base.EnsureLogObject(logBase, base.UploaderStatusLogType, status.RemoteName,
+		status.UUID, status.LogKey())
+
+	oldStatus, ok := old.(UploaderStatus)
+	if !ok {
+		logObject.Clone().Fatalf("LogModify: Old object interface passed is not of UploaderStatus type")
+	}
+	if oldStatus.State != status.State ||
+		oldStatus.RetryCount != status.RetryCount {
+
+		logObject.CloneAndAddField("state", status.State.String()).
+			AddField("retry-count-int64", status.RetryCount).
+			AddField("current-size-int64", status.CurrentSize).
+			AddField("old-state", oldStatus.State.String()).
+			AddField("old-retry-count-int64", oldStatus.RetryCount).
+			Noticef("Uploader status modify")
+	} else {
+		// Progress updates are frequent
+		logObject.CloneAndAddField("current-size-int64", status.CurrentSize).
+			AddField("progress-int64", status.Progress).
+			Functionf("Uploader status modify other change")
+	}
+
+	if status.HasError() {
+		errAndTime := status.ErrorAndTime
+		logObject.CloneAndAddField("state", status.State.String()).
+			AddField("error", errAndTime.Error).
+			AddField("error-time", errAndTime.ErrorTime).
+			Noticef("Uploader status modify")
+	}
+}
+
+// LogDelete :
+func (status UploaderStatus) LogDelete(logBase *base.LogObject) {
+	logObject := base.EnsureLogObject(logBase, base.UploaderStatusLogType, status.RemoteName,
+		status.UUID, status.LogKey())
+	logObject.CloneAndAddField("state", status.State.String()).
+		AddField("current-size-int64", status.CurrentSize).
+		Noticef("Uploader status delete")
+
+	base.DeleteLogObject(logBase, status.LogKey())
+}
+
+// LogKey :
+func (status UploaderStatus) LogKey() string {
+	return string(base.UploaderStatusLogType) + "-" + status.Key()
+}
@@ -129,6 +129,12 @@ type VolumeStatus struct {
 	LastUse                 time.Time
 	PreReboot               bool // Was volume last use prior to device reboot?
 	ReferenceName           string
+	// For container volumes; bytes of the layers of the image on the
+	// device, which is less than ImageSize while the layers of a lazily
+	// pulled image are being fetched
+	LazyPull  bool
+	LocalSize int64
+	ImageSize int64
 
 	ErrorAndTimeWithSource
 }
@@ -147,6 +153,15 @@ func (status VolumeStatus) IsContainer() bool {
 	return false
 }
 
+// LocalPercent returns how much of the image of a container volume is on
+// the device, in percent
+func (status VolumeStatus) LocalPercent() uint {
+	if status.ImageSize <= 0 {
+		return 0
+	}
+	return uint(100 * status.LocalSize / status.ImageSize)
+}
+
 // PathName returns the path of the volume
 func (status VolumeStatus) PathName() string {
 	return fmt.Sprintf("%s/%s#%d.%s", status.VolumeDir, status.VolumeID.String(),
@@ -198,6 +198,12 @@ type DatastoreConfig struct {
 	Region    string
 	DsCertPEM [][]byte // cert chain used for the datastore
 
+	// Download scheduling for this datastore, overriding the
+	// network.download.window and network.download.max.bps global
+	// settings when set
+	DownloadWindow string // see ParseDownloadSchedule
+	DownloadMaxBps uint64 // bytes per second; zero means use global
+
 	// CipherBlockStatus, for encrypted credentials
 	CipherBlockStatus
 }
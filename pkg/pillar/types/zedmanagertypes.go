@@ -72,6 +72,254 @@ type AppInstanceConfig struct {
 	MetaDataType MetaDataType
 
 	ProfileList []string
+
+	// NextVolumeRefConfigList is the set of volumes for the next version
+	// of the app instance. They are downloaded, verified and created ahead
+	// of time without touching the running domain, and replace
+	// VolumeRefConfigList at NextActivationTime as if purged.
+	NextVolumeRefConfigList []VolumeRefConfig
+	NextActivationTime      time.Time
+
+	// Health has the liveness probe and restart policy enforced by
+	// zedmanager
+	Health HealthConfig
+
+	// PodContainers, if any, run the app instance as a pod of several
+	// containers. The disk indexes in them refer to VolumeRefConfigList.
+	PodContainers []PodContainer
+
+	// InitContainers are run to completion before the app instance is
+	// started. The disk indexes in them refer to VolumeRefConfigList.
+	InitContainers []InitContainer
+
+	// Security is the hardening of a container app instance
+	Security ContainerSecurity
+
+	// KubeManifest, if set, deploys the app instance as Kubernetes objects
+	// to the k3s node instead of a domain. VolumeRefConfigList are its
+	// persistent volumes and the first UnderlayNetworkList entry is the
+	// network instance of its pods.
+	KubeManifest string `json:"pubsub-large-KubeManifest"`
+
+	// StartDependencies hold back the start of the app instance until
+	// other app instances are up
+	StartDependencies []StartDependency
+}
+
+// NextActivationDue returns true if there is a next version of the volumes
+// which should be in use at time now
+func (config AppInstanceConfig) NextActivationDue(now time.Time) bool {
+	return len(config.NextVolumeRefConfigList) != 0 &&
+		!config.NextActivationTime.IsZero() &&
+		!now.Before(config.NextActivationTime)
+}
+
+// RestartPolicy decides when zedmanager restarts an app instance by itself
+type RestartPolicy uint8
+
+const (
+	// RestartPolicyNever only reports the health of the app instance
+	RestartPolicyNever RestartPolicy = iota
+	// RestartPolicyOnFailure restarts the app instance when its domain
+	// crashed or its liveness probe fails
+	RestartPolicyOnFailure
+	// RestartPolicyAlways also restarts the app instance when its domain
+	// halted by itself
+	RestartPolicyAlways
+)
+
+// ProbeType is how a liveness probe checks an app instance
+type ProbeType uint8
+
+const (
+	// ProbeTypeNone means there is no liveness probe
+	ProbeTypeNone ProbeType = iota
+	// ProbeTypeTCP connects to Port of the app instance
+	ProbeTypeTCP
+	// ProbeTypeHTTP sends a GET for Path to Port of the app instance.
+	// A 2xx or 3xx status is a success.
+	ProbeTypeHTTP
+	// ProbeTypeExec runs Command in the container of the app instance.
+	// Exit status 0 is a success.
+	ProbeTypeExec
+)
+
+// Defaults for the zero values in LivenessProbe and HealthConfig
+const (
+	DefaultProbePeriod      = 10 * time.Second
+	DefaultProbeTimeout     = time.Second
+	DefaultFailureThreshold = 3
+	DefaultRestartBackoff   = 10 * time.Second
+	// MaxRestartBackoff caps the doubling of RestartBackoff
+	MaxRestartBackoff = 5 * time.Minute
+	// RestartBackoffReset is how long an app instance has to stay up for
+	// its restarts to be forgotten
+	RestartBackoffReset = 10 * time.Minute
+)
+
+// LivenessProbe tells whether a running app instance is still alive. TCP
+// and HTTP probes connect to the address of the app instance on its first
+// network instance from the bridge of that network instance.
+type LivenessProbe struct {
+	Type             ProbeType
+	Port             uint16
+	Path             string
+	Command          []string
+	InitialDelay     uint32 // Seconds after boot before the first probe
+	Period           uint32 // Seconds between probes
+	Timeout          uint32 // Seconds
+	FailureThreshold uint32 // Consecutive failures to be unhealthy
+}
+
+// PeriodOrDefault returns the time between probes
+func (probe LivenessProbe) PeriodOrDefault() time.Duration {
+	if probe.Period == 0 {
+		return DefaultProbePeriod
+	}
+	return time.Duration(probe.Period) * time.Second
+}
+
+// TimeoutOrDefault returns the time a probe may take
+func (probe LivenessProbe) TimeoutOrDefault() time.Duration {
+	if probe.Timeout == 0 {
+		return DefaultProbeTimeout
+	}
+	return time.Duration(probe.Timeout) * time.Second
+}
+
+// FailureThresholdOrDefault returns the number of consecutive failures
+// which make the app instance unhealthy
+func (probe LivenessProbe) FailureThresholdOrDefault() uint32 {
+	if probe.FailureThreshold == 0 {
+		return DefaultFailureThreshold
+	}
+	return probe.FailureThreshold
+}
+
+// HealthConfig is the liveness probe and restart policy of an app instance
+type HealthConfig struct {
+	LivenessProbe LivenessProbe
+	RestartPolicy RestartPolicy
+	// RestartBackoff is the minimum time in seconds between the first
+	// and the second restart; it doubles with each further restart up to
+	// MaxRestartBackoff
+	RestartBackoff uint32
+	// RestartMaxRetries is the number of restarts after which zedmanager
+	// gives up; zero means no limit
+	RestartMaxRetries uint32
+}
+
+// Enabled returns true if there is anything for zedmanager to do
+func (hc HealthConfig) Enabled() bool {
+	return hc.LivenessProbe.Type != ProbeTypeNone ||
+		hc.RestartPolicy != RestartPolicyNever
+}
+
+// BackoffDelay returns the minimum time between the last restart and the
+// next one after the given number of restarts. The first restart is not
+// delayed.
+func (hc HealthConfig) BackoffDelay(restarts uint32) time.Duration {
+	if restarts == 0 {
+		return 0
+	}
+	delay := DefaultRestartBackoff
+	if hc.RestartBackoff != 0 {
+		delay = time.Duration(hc.RestartBackoff) * time.Second
+	}
+	for i := uint32(1); i < restarts && delay < MaxRestartBackoff; i++ {
+		delay *= 2
+	}
+	if delay > MaxRestartBackoff {
+		delay = MaxRestartBackoff
+	}
+	return delay
+}
+
+// NeedRestart returns true if the restart policy asks for a restart of an
+// app instance in state, which has a domain error if domainError is set,
+// and is unhealthy according to its liveness probe if unhealthy is set
+func (hc HealthConfig) NeedRestart(state SwState, domainError bool, unhealthy bool) bool {
+	switch hc.RestartPolicy {
+	case RestartPolicyOnFailure:
+		return unhealthy || state == BROKEN ||
+			(state == HALTED && domainError)
+	case RestartPolicyAlways:
+		return unhealthy || state == BROKEN || state == HALTED
+	default:
+		return false
+	}
+}
+
+// HealthState is the health of an app instance according to its liveness
+// probe
+type HealthState uint8
+
+const (
+	// HealthUnknown means there is no probe or it has not run yet
+	HealthUnknown HealthState = iota
+	// HealthHealthy means the last probe succeeded
+	HealthHealthy
+	// HealthUnhealthy means FailureThreshold probes in a row failed
+	HealthUnhealthy
+)
+
+// String returns the name of the health state
+func (state HealthState) String() string {
+	switch state {
+	case HealthHealthy:
+		return "healthy"
+	case HealthUnhealthy:
+		return "unhealthy"
+	default:
+		return "unknown"
+	}
+}
+
+// AppHealth is the health of an app instance and the restarts done by its
+// restart policy
+type AppHealth struct {
+	State     HealthState
+	Failures  uint32 // Consecutive probe failures
+	LastProbe time.Time
+	LastError string
+	Restarts  uint32 // Restarts since the app instance was last up for RestartBackoffReset
+	// LastRestart is when the last restart was requested
+	LastRestart time.Time
+	// HealthySince is when State last became HealthHealthy
+	HealthySince time.Time
+	// GaveUp is set once RestartMaxRetries restarts were done
+	GaveUp bool
+}
+
+// StartCondition is what a StartDependency waits for
+type StartCondition uint8
+
+const (
+	// StartWhenRunning waits for the other app instance to be RUNNING
+	StartWhenRunning StartCondition = iota
+	// StartWhenHealthy waits for the liveness probe of the other app
+	// instance to succeed; the same as StartWhenRunning if it has none
+	StartWhenHealthy
+)
+
+// String returns the name of the condition
+func (cond StartCondition) String() string {
+	switch cond {
+	case StartWhenRunning:
+		return "running"
+	case StartWhenHealthy:
+		return "healthy"
+	default:
+		return fmt.Sprintf("Unknown StartCondition %d", cond)
+	}
+}
+
+// StartDependency holds back the start of an app instance until the app
+// instance AppUUID meets Condition, and then for Delay seconds
+type StartDependency struct {
+	AppUUID   uuid.UUID
+	Condition StartCondition
+	Delay     uint
 }
 
 type AppInstanceOpsCmd struct {
@@ -158,12 +406,46 @@ type AppInstanceStatus struct {
 
 	// Mininum state across all steps and all StorageStatus.
 	// Error* set implies error.
-	State          SwState
-	MissingNetwork bool // If some Network UUID not found
-	MissingMemory  bool // Waiting for memory
+	State            SwState
+	MissingNetwork   bool // If some Network UUID not found
+	MissingResources bool // Waiting for memory, CPUs, adapters or addresses
 
 	EffectiveActivate bool //set here effective activate after profile check and apply
 
+	// Pre-staged volumes for NextVolumeRefConfigList in AppInstanceConfig.
+	// NextState is the minimum state across them. Once NextActivated
+	// the next volumes are in VolumeRefStatusList.
+	NextVolumeRefStatusList []VolumeRefStatus
+	NextState               SwState
+	NextActivated           bool
+
+	// HotplugInprogress is set while added or removed volumes and
+	// network interfaces are applied to the running domain instead of
+	// purging it
+	HotplugInprogress bool
+
+	// GuestInfo is what the guest agent in the domain reports
+	GuestInfo GuestInfo
+	// CrashDump is the memory dump saved the last time the guest kernel
+	// panicked, if any
+	CrashDump CrashDump
+
+	// Health is the outcome of the liveness probe and the restarts
+	// done by the restart policy
+	Health AppHealth
+
+	// PodContainers is the state of each container if the app instance
+	// is a pod
+	PodContainers []PodContainerStatus
+
+	// InitContainers is the outcome of the last run of each init
+	// container
+	InitContainers []InitContainerStatus
+
+	// StartBlocked is why the start of the app instance is held back by
+	// its StartDependencies, if it is
+	StartBlocked string
+
 	// All error strings across all steps and all StorageStatus
 	// ErrorAndTimeWithSource provides SetError, SetErrrorWithSource, etc
 	ErrorAndTimeWithSource
@@ -245,6 +527,7 @@ const (
 	RecreateVolumes            // Download and verify new images if need be
 	BringDown
 	BringUp
+	GuestReboot // Waiting for the guest agent to reboot the guest OS
 )
 
 func (status AppInstanceStatus) Key() string {
@@ -341,3 +624,50 @@ func (aih AppAndImageToHash) LogDelete(logBase *base.LogObject) {
 func (aih AppAndImageToHash) LogKey() string {
 	return string(base.AppAndImageToHashLogType) + "-" + aih.Key()
 }
+
+// ResourceAllocation is what zedmanager has admitted of the resources of
+// the device for the app instances which are activated or being
+// activated. Published under the "global" key and reported by zedagent in
+// the device info.
+type ResourceAllocation struct {
+	CPUs            int    // Host CPUs
+	ReservedCPUs    int    // Kept for EVE when CPU pinning is enabled
+	AllocatedVCPUs  int    // Sum of the vCPUs of the app instances
+	CPUPinning      bool   // Each vCPU gets a host CPU for itself
+	Memory          uint64 // Bytes for app instances after the EVE reserve
+	AllocatedMemory uint64
+	Disk            uint64 // Bytes in /persist
+	AllocatedDisk   uint64 // Sum of the sizes of their volumes
+	Adapters        []AdapterAllocation
+	Networks        []NetworkAllocation
+	Apps            []AppAllocation
+}
+
+// Key returns the key for pubsub
+func (ra ResourceAllocation) Key() string {
+	return "global"
+}
+
+// AdapterAllocation is an adapter assigned to an app instance
+type AdapterAllocation struct {
+	Adapter IoAdapter
+	AppUUID uuid.UUID
+}
+
+// NetworkAllocation is the use of the addresses of a network instance.
+// Addresses is zero for a network instance without a limit, such as a
+// switch network instance.
+type NetworkAllocation struct {
+	UUID      uuid.UUID
+	Addresses int
+	Allocated int // Interfaces of app instances
+}
+
+// AppAllocation is what an app instance was admitted with
+type AppAllocation struct {
+	UUID        uuid.UUID
+	DisplayName string
+	VCPUs       int
+	Memory      uint64 // Bytes
+	Disk        uint64 // Bytes
+}
@@ -30,6 +30,9 @@ type AppNetworkConfig struct {
 	CloudInitUserData   *string `json:"pubsub-large-CloudInitUserData"`
 	CipherBlockStatus   CipherBlockStatus
 	MetaDataType        MetaDataType
+	// Hotplug is set by zedmanager when underlays are added to or removed
+	// from the end of UnderlayNetworkList of the running app instance
+	Hotplug bool
 }
 
 func (config AppNetworkConfig) Key() string {
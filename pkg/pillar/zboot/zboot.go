@@ -30,7 +30,6 @@ type MountFlags uint
 const (
 	// MountFlagRDONLY readOnly mount
 	MountFlagRDONLY MountFlags = 0x01
-	casClientType              = "containerd"
 )
 
 // mutex for zboot/dd APIs
@@ -372,7 +371,8 @@ func WriteToPartition(log *base.LogObject, image string, partName string) error
 	puller := registry.Puller{
 		Image: image,
 	}
-	if casClient, err = cas.NewCAS(casClientType); err != nil {
+	// volumemgr loaded the image into the CAS selected for the device
+	if casClient, err = cas.NewCAS(cas.SelectedCAS()); err != nil {
 		err = fmt.Errorf("Run: exception while initializing CAS client: %s", err.Error())
 		log.Fatal(err)
 	}
@@ -408,7 +408,7 @@ func WriteToPartition(log *base.LogObject, image string, partName string) error
 	defer f.Close()
 
 	if _, _, err := puller.Pull(&registry.FilesTarget{Root: f, AcceptHash: true}, 0, false, os.Stderr, resolver); err != nil {
-		errStr := fmt.Sprintf("error pulling %s from containerd: %v", image, err)
+		errStr := fmt.Sprintf("error pulling %s from CAS: %v", image, err)
 		log.Error(errStr)
 		return errors.New(errStr)
 	}
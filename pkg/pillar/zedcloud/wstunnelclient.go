@@ -6,6 +6,7 @@ package zedcloud
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -43,6 +44,31 @@ type WSTunnelClient struct {
 	conn                    *WSConnection     // reference to remote websocket connection
 	retryOnFailCount        int               // no of times the ws connection attempts have continuously failed
 	log                     *base.LogObject
+	// ConsoleHandler is called for every console frame received from the
+	// remote server; it must not block
+	ConsoleHandler func(msg ConsoleMessage)
+	// ConsoleReset is called when the websocket carrying the console
+	// sessions goes away
+	ConsoleReset func()
+}
+
+// Console session operations
+const (
+	ConsoleOpOpen  = "open"  // open a session; echoed back with Error set on failure
+	ConsoleOpData  = "data"  // console input or output
+	ConsoleOpClose = "close" // close a session from either end
+)
+
+// ConsoleMessage is a frame of a console session. Console sessions are
+// multiplexed over the tunnel as websocket text messages carrying JSON,
+// while the binary messages keep being relayed to LocalRelayServer.
+type ConsoleMessage struct {
+	Session string `json:"session"`
+	Op      string `json:"op"`
+	App     string `json:"app,omitempty"`
+	Console string `json:"console,omitempty"`
+	Data    []byte `json:"data,omitempty"`
+	Error   string `json:"error,omitempty"`
 }
 
 // WSConnection represents a single websocket connection
@@ -232,6 +258,10 @@ func (wsc *WSConnection) handleRequests() {
 			log.Tracef("WS ReadMessage Error: %s", err.Error())
 			break
 		}
+		if messageType == websocket.TextMessage {
+			wsc.processConsoleMessage(reader)
+			continue
+		}
 		if messageType != websocket.BinaryMessage {
 			log.Tracef("WS ReadMessage Invalid message type: %d", messageType)
 			break
@@ -266,6 +296,9 @@ func (wsc *WSConnection) handleRequests() {
 		}
 
 	}
+	if wsc.tun.ConsoleReset != nil {
+		wsc.tun.ConsoleReset()
+	}
 	// delay a few seconds to allow for writes to drain and then force-close the socket
 	log.Functionf("Creating %s at %s", "func", agentlog.GetMyStack())
 	go func() {
@@ -275,6 +308,46 @@ func (wsc *WSConnection) handleRequests() {
 	}()
 }
 
+// processConsoleMessage decodes a console frame and hands it over to
+// the ConsoleHandler
+func (wsc *WSConnection) processConsoleMessage(reader io.Reader) {
+	log := wsc.tun.log
+	var msg ConsoleMessage
+	if err := json.NewDecoder(reader).Decode(&msg); err != nil {
+		log.Errorf("WS cannot decode console message: %v", err)
+		return
+	}
+	if msg.Session == "" {
+		log.Errorf("WS console message %s without a session", msg.Op)
+		return
+	}
+	if wsc.tun.ConsoleHandler == nil {
+		log.Warnf("WS console message for session %s with no handler", msg.Session)
+		return
+	}
+	wsc.tun.ConsoleHandler(msg)
+}
+
+// SendConsoleMessage sends a console frame to the remote server
+func (t *WSTunnelClient) SendConsoleMessage(msg ConsoleMessage) error {
+	wsc := t.conn
+	if wsc == nil || !t.Connected {
+		return fmt.Errorf("no websocket connection to %s", t.DestURL)
+	}
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	wsWriterMutex.Lock()
+	defer wsWriterMutex.Unlock()
+	wsc.ws.SetWriteDeadline(time.Now().Add(time.Minute))
+	if err := wsc.ws.WriteMessage(websocket.TextMessage, payload); err != nil {
+		wsc.ws.Close()
+		return fmt.Errorf("WS cannot write console message: %v", err)
+	}
+	return nil
+}
+
 // Pinger that keeps connections alive and terminates them if they seem stuck
 func (wsc *WSConnection) pinger() {
 	log := wsc.tun.log